@@ -0,0 +1,214 @@
+package admin
+
+import (
+	"encoding/json"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/middleware"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const DefaultAdminRole = "turbo-auth:admin"
+
+type (
+	// Admin exposes the runtime management of the authentication over http, mount its Handler under a prefix
+	// with http.StripPrefix:
+	//
+	//	POST /keys/rotate     rotates the signing key of the KeyManager
+	//	GET  /revocations     lists the revoked token ids, the Revoker must be a jwt.RevocationLister
+	//	POST /revocations     revokes {"jti": "...", "expiresAt": "..."} or {"subject": "..."}, the latter
+	//	                      requires a jwt.TokenVersioner or a jwt.SubjectRevoker, see jwt.RevokeAll
+	//	POST /reload          invokes Reload, e.g. to reload the configuration into a turboAuth.Registry
+	//
+	// The endpoints of the unset dependencies answer 501
+	Admin struct {
+		// Authenticator protects the endpoints, the identity must hold the Role
+		Authenticator turboAuth.Authenticator
+		Role          string
+		KeyManager    *jwt.KeyManager
+		Revoker       jwt.Revoker
+		Reload        func() error
+		AuditLogger   audit.AuditLogger
+		// ErrorWriter renders the failures, plain text errors are written when nil
+		ErrorWriter turboError.ErrorWriter
+	}
+
+	// RevocationRequest is the body of POST /revocations
+	RevocationRequest struct {
+		JTI       string    `json:"jti,omitempty"`
+		ExpiresAt time.Time `json:"expiresAt,omitempty"`
+		Subject   string    `json:"subject,omitempty"`
+	}
+
+	Revocation struct {
+		JTI       string    `json:"jti"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+
+	rotationResponse struct {
+		KeyID string `json:"kid"`
+	}
+
+	revocationsResponse struct {
+		Revocations []Revocation `json:"revocations"`
+	}
+)
+
+func NewAdmin(authenticator turboAuth.Authenticator) *Admin {
+	return &Admin{
+		Authenticator: authenticator,
+		Role:          DefaultAdminRole,
+	}
+}
+
+// Handler returns the admin mux behind the Authenticator and the Role check
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys/rotate", a.method(http.MethodPost, a.rotateKeys))
+	mux.HandleFunc("/revocations", a.revocations)
+	mux.HandleFunc("/reload", a.method(http.MethodPost, a.reload))
+	return middleware.Chain(middleware.Authenticate(a.Authenticator), middleware.RequireRole(a.Role))(mux)
+}
+
+func (a *Admin) rotateKeys(w http.ResponseWriter, r *http.Request) {
+	if a.KeyManager == nil {
+		a.writeError(w, r, http.StatusNotImplemented, "no key manager configured")
+		return
+	}
+	err := a.KeyManager.RotateNow()
+	a.audit(r, audit.EventKeyRotation, "", "", err)
+	if err != nil {
+		a.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	current, err := a.KeyManager.KeyStore.CurrentKey()
+	if err != nil {
+		a.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, &rotationResponse{KeyID: current.ID})
+}
+
+func (a *Admin) revocations(w http.ResponseWriter, r *http.Request) {
+	if a.Revoker == nil {
+		a.writeError(w, r, http.StatusNotImplemented, "no revoker configured")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		a.listRevocations(w, r)
+	case http.MethodPost:
+		a.revoke(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		a.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *Admin) listRevocations(w http.ResponseWriter, r *http.Request) {
+	lister, ok := a.Revoker.(jwt.RevocationLister)
+	if !ok {
+		a.writeError(w, r, http.StatusNotImplemented, "the revoker cannot list its revocations")
+		return
+	}
+	revoked, err := lister.Revocations()
+	if err != nil {
+		a.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := &revocationsResponse{Revocations: make([]Revocation, 0, len(revoked))}
+	for jti, expiresAt := range revoked {
+		response.Revocations = append(response.Revocations, Revocation{JTI: jti, ExpiresAt: expiresAt})
+	}
+	sort.Slice(response.Revocations, func(i, j int) bool {
+		return response.Revocations[i].JTI < response.Revocations[j].JTI
+	})
+	writeJSON(w, response)
+}
+
+func (a *Admin) revoke(w http.ResponseWriter, r *http.Request) {
+	var request RevocationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		a.writeError(w, r, http.StatusBadRequest, "invalid revocation request")
+		return
+	}
+	var err error
+	switch {
+	case request.JTI != "" && request.Subject == "":
+		err = a.Revoker.Revoke(request.JTI, request.ExpiresAt)
+	case request.Subject != "" && request.JTI == "":
+		if err = jwt.RevokeAll(a.Revoker, request.Subject); err == jwt.ErrRevocationUnsupported {
+			a.writeError(w, r, http.StatusNotImplemented, "the revoker cannot revoke subjects")
+			return
+		}
+	default:
+		a.writeError(w, r, http.StatusBadRequest, "either jti or subject is required")
+		return
+	}
+	a.audit(r, audit.EventTokenRevocation, request.JTI, request.Subject, err)
+	if err != nil {
+		a.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) reload(w http.ResponseWriter, r *http.Request) {
+	if a.Reload == nil {
+		a.writeError(w, r, http.StatusNotImplemented, "no reload configured")
+		return
+	}
+	err := a.Reload()
+	a.audit(r, audit.EventConfigReload, "", "", err)
+	if err != nil {
+		a.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) method(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			a.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// audit records the action, the subject of the event is the operator performing it and the revoked subject,
+// if any, is appended to the reason
+func (a *Admin) audit(r *http.Request, eventType audit.EventType, tokenID string, revokedSubject string, err error) {
+	if a.AuditLogger == nil {
+		return
+	}
+	event := audit.NewEvent(r, eventType, "admin", err)
+	event.TokenID = tokenID
+	if revokedSubject != "" {
+		event.Reason = strings.TrimPrefix(event.Reason+", revoked subject "+revokedSubject, ", ")
+	}
+	if identity, ok := turboAuth.IdentityFromContext(r.Context()); ok {
+		event.Subject = identity.Subject
+	}
+	a.AuditLogger.Log(event)
+}
+
+func (a *Admin) writeError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	turboError.WriteError(a.ErrorWriter, w, r, &turboError.HttpError{
+		StatusCode: statusCode,
+		Message:    "Error : " + message + " \n",
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"encoding/json"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"github.com/nandlabs/turbo-auth/turbotest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdmin_Handler(t *testing.T) {
+	keyRing := &jwt.KeyRing{}
+	keyManager := jwt.NewKeyManager(keyRing, jwt.NewHMACKeySource("HS256", 32), time.Hour)
+	if err := keyManager.RotateNow(); err != nil {
+		t.Fatalf("RotateNow() error = %v", err)
+	}
+	revoker := jwt.NewMemoryRevoker()
+	authenticator, err := jwt.NewJwtAuthenticator(jwt.WithKeyStore(keyRing), jwt.WithRevoker(revoker))
+	if err != nil {
+		t.Fatalf("NewJwtAuthenticator() error = %v", err)
+	}
+	token, jwtErr := authenticator.IssueNewToken("test_user", time.Hour)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+
+	var reloads int
+	operator := &turboAuth.Identity{Subject: "operator", Roles: []string{DefaultAdminRole}}
+	admin := NewAdmin(turbotest.Authenticated(operator))
+	admin.KeyManager = keyManager
+	admin.Revoker = revoker
+	admin.Reload = func() error {
+		reloads++
+		return nil
+	}
+	handler := admin.Handler()
+
+	tests := []struct {
+		name     string
+		admin    http.Handler
+		method   string
+		path     string
+		body     string
+		want     int
+		wantBody string
+	}{
+		{name: "Test_not_admin", admin: NewAdmin(turbotest.Authenticated(&turboAuth.Identity{Subject: "user"})).Handler(), method: http.MethodPost, path: "/reload", want: http.StatusForbidden},
+		{name: "Test_rotate", method: http.MethodPost, path: "/keys/rotate", want: http.StatusOK, wantBody: `"kid"`},
+		{name: "Test_rotate_get", method: http.MethodGet, path: "/keys/rotate", want: http.StatusMethodNotAllowed},
+		{name: "Test_revoke_jti", method: http.MethodPost, path: "/revocations", body: `{"jti": "jti-1"}`, want: http.StatusNoContent},
+		{name: "Test_revoke_nothing", method: http.MethodPost, path: "/revocations", body: `{}`, want: http.StatusBadRequest},
+		{name: "Test_list", method: http.MethodGet, path: "/revocations", want: http.StatusOK, wantBody: `"jti":"jti-1"`},
+		{name: "Test_revoke_subject", method: http.MethodPost, path: "/revocations", body: `{"subject": "test_user"}`, want: http.StatusNoContent},
+		{name: "Test_reload", method: http.MethodPost, path: "/reload", want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.admin
+			if h == nil {
+				h = handler
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Fatalf("status = %v, want %v, body = %v", w.Code, tt.want, w.Body.String())
+			}
+			if tt.wantBody != "" && !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if w.Code == http.StatusOK && !json.Valid(w.Body.Bytes()) {
+				t.Errorf("body is not json: %v", w.Body.String())
+			}
+		})
+	}
+	if reloads != 1 {
+		t.Errorf("Reload() calls = %v, want 1", reloads)
+	}
+	if len(keyRing.Keys()) != 2 {
+		t.Errorf("Keys() = %v, want 2 after rotation", len(keyRing.Keys()))
+	}
+	if _, err := authenticator.Authenticate(token); err == nil {
+		t.Errorf("Authenticate() after subject revocation succeeded")
+	}
+}
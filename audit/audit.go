@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"encoding/json"
+	"github.com/nandlabs/turbo-auth/clientip"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	EventType string
+
+	Outcome string
+
+	// Event carries the structured data of an authentication event
+	Event struct {
+		Time      time.Time `json:"time"`
+		Type      EventType `json:"type"`
+		Provider  string    `json:"provider,omitempty"`
+		Subject   string    `json:"subject,omitempty"`
+		TokenID   string    `json:"jti,omitempty"`
+		IP        string    `json:"ip,omitempty"`
+		UserAgent string    `json:"userAgent,omitempty"`
+		Outcome   Outcome   `json:"outcome"`
+		Reason    string    `json:"reason,omitempty"`
+		// Actor is the subject acting on behalf of the Subject, e.g. the admin impersonating a user
+		Actor string `json:"actor,omitempty"`
+	}
+
+	// AuditLogger is the sink of the authentication events, implementations must be safe for concurrent use
+	AuditLogger interface {
+		Log(event *Event)
+	}
+
+	// AuditLoggerFunc adapts a function to the AuditLogger interface
+	AuditLoggerFunc func(event *Event)
+
+	// JSONLogger writes one json document per line
+	JSONLogger struct {
+		mutex   sync.Mutex
+		encoder *json.Encoder
+	}
+)
+
+const (
+	EventTokenIssued     EventType = "token_issued"
+	EventTokenValidation EventType = "token_validation"
+	EventTokenRefresh    EventType = "token_refresh"
+	EventTokenRevocation EventType = "token_revocation"
+	EventLogout          EventType = "logout"
+	EventTokenExchange   EventType = "token_exchange"
+	EventKeyRotation     EventType = "key_rotation"
+	EventConfigReload    EventType = "config_reload"
+	EventLogin           EventType = "login"
+	EventImpersonation   EventType = "impersonation"
+	// EventTokenReuse records a rotated refresh token presented again, the token has leaked
+	EventTokenReuse EventType = "token_reuse"
+	// EventBreakGlass records each use of a break-glass token, the Reason is the one of the token on success
+	EventBreakGlass EventType = "break_glass"
+
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+)
+
+func (f AuditLoggerFunc) Log(event *Event) {
+	f(event)
+}
+
+// NewJSONLogger writes the events to w, os.Stdout when nil
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	if w == nil {
+		w = os.Stdout
+	}
+	return &JSONLogger{encoder: json.NewEncoder(w)}
+}
+
+func (l *JSONLogger) Log(event *Event) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_ = l.encoder.Encode(event)
+}
+
+// NewEvent builds an event of the request, r may be nil for events outside of a request (e.g. token issuance)
+func NewEvent(r *http.Request, eventType EventType, provider string, err error) *Event {
+	event := &Event{
+		Time:     time.Now(),
+		Type:     eventType,
+		Provider: provider,
+		Outcome:  OutcomeSuccess,
+	}
+	if err != nil {
+		event.Outcome = OutcomeFailure
+		event.Reason = err.Error()
+	}
+	if r != nil {
+		event.IP = clientip.FromRequest(r)
+		event.UserAgent = r.UserAgent()
+	}
+	return event
+}
@@ -0,0 +1,41 @@
+package audit
+
+const (
+	// the keys of the structured logs of the events, shared by the adapters so that the logs of the services can be
+	// queried alike
+	KeyEvent     = "event"
+	KeyProvider  = "provider"
+	KeySubject   = "subject"
+	KeyTokenID   = "jti"
+	KeyOutcome   = "outcome"
+	KeyReason    = "reason"
+	KeyIP        = "ip"
+	KeyUserAgent = "user_agent"
+	KeyActor     = "actor"
+
+	// Message is the message of the events logged by the adapters, the type of the event is a field
+	Message = "authentication event"
+)
+
+// Fields returns the alternating keys and values of the event, the empty values are skipped but the event type and
+// the outcome
+func Fields(event *Event) []interface{} {
+	fields := []interface{}{KeyEvent, string(event.Type), KeyOutcome, string(event.Outcome)}
+	for _, field := range []struct {
+		key   string
+		value string
+	}{
+		{KeyProvider, event.Provider},
+		{KeySubject, event.Subject},
+		{KeyTokenID, event.TokenID},
+		{KeyReason, event.Reason},
+		{KeyIP, event.IP},
+		{KeyUserAgent, event.UserAgent},
+		{KeyActor, event.Actor},
+	} {
+		if field.value != "" {
+			fields = append(fields, field.key, field.value)
+		}
+	}
+	return fields
+}
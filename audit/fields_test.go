@@ -0,0 +1,36 @@
+package audit
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		event *Event
+		want  []interface{}
+	}{
+		{
+			name: "Test_success",
+			event: &Event{Type: EventTokenIssued, Provider: "jwt", Subject: "test_user", TokenID: "jti-1",
+				Outcome: OutcomeSuccess},
+			want: []interface{}{KeyEvent, "token_issued", KeyOutcome, "success", KeyProvider, "jwt",
+				KeySubject, "test_user", KeyTokenID, "jti-1"},
+		},
+		{
+			name:  "Test_failure",
+			event: NewEvent(nil, EventTokenValidation, "jwt", errors.New("token has expired")),
+			want: []interface{}{KeyEvent, "token_validation", KeyOutcome, "failure", KeyProvider, "jwt",
+				KeyReason, "token has expired"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Fields(tt.event); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+//go:build go1.21
+
+package audit
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger logs the events with the slog logger, slog.Default() when nil, at the info level and the failures
+// at the warn level. The records carry the time of the event
+func NewSlogLogger(logger *slog.Logger) AuditLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &slogLogger{logger: logger}
+}
+
+func (s *slogLogger) Log(event *Event) {
+	level := slog.LevelInfo
+	if event.Outcome == OutcomeFailure {
+		level = slog.LevelWarn
+	}
+	ctx := context.Background()
+	handler := s.logger.Handler()
+	if !handler.Enabled(ctx, level) {
+		return
+	}
+	record := slog.NewRecord(event.Time, level, Message, 0)
+	record.Add(Fields(event)...)
+	_ = handler.Handle(ctx, record)
+}
@@ -0,0 +1,59 @@
+//go:build go1.21
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestNewSlogLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(&buffer, nil)))
+	issuedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		event *Event
+		want  map[string]interface{}
+	}{
+		{
+			name: "Test_success",
+			event: &Event{Time: issuedAt, Type: EventTokenIssued, Provider: "jwt", Subject: "test_user",
+				TokenID: "jti-1", Outcome: OutcomeSuccess},
+			want: map[string]interface{}{"time": "2024-01-02T03:04:05Z", "level": "INFO", "msg": Message,
+				KeyEvent: "token_issued", KeyOutcome: "success", KeyProvider: "jwt", KeySubject: "test_user",
+				KeyTokenID: "jti-1"},
+		},
+		{
+			name: "Test_failure",
+			event: &Event{Time: issuedAt, Type: EventTokenValidation, Provider: "jwt", Outcome: OutcomeFailure,
+				Reason: errors.New("token has expired").Error()},
+			want: map[string]interface{}{"time": "2024-01-02T03:04:05Z", "level": "WARN", "msg": Message,
+				KeyEvent: "token_validation", KeyOutcome: "failure", KeyProvider: "jwt",
+				KeyReason: "token has expired"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer.Reset()
+			logger.Log(tt.event)
+			var got map[string]interface{}
+			if err := json.Unmarshal(buffer.Bytes(), &got); err != nil {
+				t.Fatalf("logged %q: %v", buffer.String(), err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("logged %v, want %v", got, tt.want)
+			}
+			for key, value := range tt.want {
+				if got[key] != value {
+					t.Errorf("logged %s = %v, want %v", key, got[key], value)
+				}
+			}
+		})
+	}
+}
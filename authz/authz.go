@@ -0,0 +1,279 @@
+package authz
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/clientip"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/logging"
+	"github.com/nandlabs/turbo-auth/resilience"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Input describes the request to the policy engine, the identity and the request attributes
+	Input struct {
+		Subject string                 `json:"subject"`
+		Tenant  string                 `json:"tenant,omitempty"`
+		Roles   []string               `json:"roles,omitempty"`
+		Scopes  []string               `json:"scopes,omitempty"`
+		Claims  map[string]interface{} `json:"claims,omitempty"`
+		// Action is the http method unless the InputFunc tells otherwise
+		Action string `json:"action"`
+		// Resource is the path of the request unless the InputFunc tells otherwise
+		Resource string `json:"resource"`
+		Host     string `json:"host,omitempty"`
+		ClientIP string `json:"client_ip,omitempty"`
+		// Attributes are the additional attributes of the request set by the InputFunc
+		Attributes map[string]interface{} `json:"attributes,omitempty"`
+	}
+
+	// Result is the decision of the policy engine
+	Result struct {
+		Allow bool
+		// Reason explains the decision when the engine provides one
+		Reason string
+	}
+
+	// PDP is a policy decision point, e.g. an OPA server or a Cedar agent
+	PDP interface {
+		Decide(ctx context.Context, input *Input) (*Result, error)
+	}
+
+	// PDPFunc adapts a function to a PDP, e.g. to evaluate a prepared rego query of an embedded OPA
+	PDPFunc func(ctx context.Context, input *Input) (*Result, error)
+
+	// Authorizer delegates the authorization of the requests authenticated by the middleware wrapping it to the PDP.
+	// The requests are denied when the PDP cannot be reached
+	Authorizer struct {
+		PDP PDP
+		// InputFunc builds the input of the request, NewInput when nil
+		InputFunc func(r *http.Request, identity *turboAuth.Identity) *Input
+		// CacheTTL caches the allow decisions keyed by the subject, the tenant, the roles, the action and the
+		// resource of the input, disabled when 0. The policies depending on the other attributes of the input must
+		// disable the caching
+		CacheTTL time.Duration
+		// NegativeCacheTTL caches the deny decisions, disabled when 0
+		NegativeCacheTTL time.Duration
+		// CacheSize bounds the cached decisions, the least recently used one is evicted once full, DefaultCacheSize
+		// when 0
+		CacheSize int
+		// Resilience bounds, retries and breaks the calls to the PDP
+		Resilience  *resilience.Policy
+		ErrorWriter turboError.ErrorWriter
+
+		mutex sync.Mutex
+		cache map[string]*list.Element
+		lru   *list.List
+	}
+
+	cacheEntry struct {
+		key       string
+		result    *Result
+		expiresAt time.Time
+	}
+
+	// decisionKey are the attributes of the input the cached decisions are keyed by
+	decisionKey struct {
+		Subject  string   `json:"subject"`
+		Tenant   string   `json:"tenant"`
+		Roles    []string `json:"roles"`
+		Action   string   `json:"action"`
+		Resource string   `json:"resource"`
+	}
+)
+
+const (
+	DefaultCacheTTL         = 30 * time.Second
+	DefaultNegativeCacheTTL = 5 * time.Second
+	DefaultCacheSize        = 10000
+)
+
+var (
+	ErrDenied = errors.New("denied by policy")
+
+	logger = logging.Get()
+)
+
+func (f PDPFunc) Decide(ctx context.Context, input *Input) (*Result, error) {
+	return f(ctx, input)
+}
+
+func NewAuthorizer(pdp PDP) *Authorizer {
+	return &Authorizer{
+		PDP:              pdp,
+		CacheTTL:         DefaultCacheTTL,
+		NegativeCacheTTL: DefaultNegativeCacheTTL,
+		CacheSize:        DefaultCacheSize,
+	}
+}
+
+// NewInput describes the identity and the method, path, host and client ip of the request
+func NewInput(r *http.Request, identity *turboAuth.Identity) *Input {
+	input := &Input{
+		Action:   r.Method,
+		Resource: r.URL.Path,
+		Host:     r.Host,
+		ClientIP: clientip.FromRequest(r),
+	}
+	if identity != nil {
+		input.Subject = identity.Subject
+		input.Tenant = identity.Tenant
+		input.Roles = identity.Roles
+		input.Scopes = identity.Scopes
+		input.Claims = identity.Claims
+	}
+	return input
+}
+
+// RequirePermission lets the request through when the PDP allows the action on the resource to the identity, e.g.
+// RequirePermission(NewCasbin(enforcer), "write", "orders")
+func RequirePermission(pdp PDP, action string, resource string) func(http.Handler) http.Handler {
+	authorizer := &Authorizer{
+		PDP: pdp,
+		InputFunc: func(r *http.Request, identity *turboAuth.Identity) *Input {
+			input := NewInput(r, identity)
+			input.Action = action
+			input.Resource = resource
+			return input
+		},
+	}
+	return authorizer.Middleware
+}
+
+// Middleware answers 403 to the requests denied by the PDP and 503 when the PDP cannot be reached
+func (a *Authorizer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := turboAuth.IdentityFromContext(r.Context())
+		if !ok {
+			turboError.WriteError(a.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Incoming request cannot be authorized \n",
+			})
+			return
+		}
+		inputFunc := a.InputFunc
+		if inputFunc == nil {
+			inputFunc = NewInput
+		}
+		result, err := a.Authorize(r.Context(), inputFunc(r, identity))
+		if err != nil {
+			logger.ErrorF("policy decision failed: %v", err)
+			turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+				Source: "authz", Reason: "policy decision point unavailable"})
+			turboError.WriteError(a.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: http.StatusServiceUnavailable,
+				Message:    "Error : authorization is unavailable \n",
+				Err:        err,
+			})
+			return
+		}
+		turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+			Source: "authz", Allowed: result.Allow, Reason: result.Reason})
+		if !result.Allow {
+			turboError.WriteError(a.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: http.StatusForbidden,
+				Message:    "Error : " + ErrDenied.Error() + " \n",
+				Err:        ErrDenied,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Authorize returns the decision of the PDP for the input, from the cache when an identical input was decided
+// recently
+func (a *Authorizer) Authorize(ctx context.Context, input *Input) (*Result, error) {
+	key, err := cacheKey(input)
+	if err != nil {
+		return nil, err
+	}
+	if result, ok := a.cached(key); ok {
+		return result, nil
+	}
+	var result *Result
+	err = a.Resilience.Do(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = a.PDP.Decide(ctx, input)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, errors.New("policy decision point returned no result")
+	}
+	a.store(key, result)
+	return result, nil
+}
+
+func (a *Authorizer) cached(key string) (*Result, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	element, ok := a.cache[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		a.remove(element)
+		return nil, false
+	}
+	a.lru.MoveToFront(element)
+	return entry.result, true
+}
+
+func (a *Authorizer) store(key string, result *Result) {
+	ttl := a.NegativeCacheTTL
+	if result.Allow {
+		ttl = a.CacheTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	size := a.CacheSize
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.cache == nil {
+		a.cache, a.lru = make(map[string]*list.Element), list.New()
+	}
+	if element, ok := a.cache[key]; ok {
+		a.remove(element)
+	}
+	a.cache[key] = a.lru.PushFront(&cacheEntry{key: key, result: result, expiresAt: time.Now().Add(ttl)})
+	for a.lru.Len() > size {
+		a.remove(a.lru.Back())
+	}
+}
+
+// remove evicts the cached decision, caller must hold the lock
+func (a *Authorizer) remove(element *list.Element) {
+	a.lru.Remove(element)
+	delete(a.cache, element.Value.(*cacheEntry).key)
+}
+
+// cacheKey hashes the subject, the tenant, the sorted roles, the action and the resource of the input, the claims
+// and the request attributes which differ for every token or request are left out
+func cacheKey(input *Input) (string, error) {
+	roles := append([]string(nil), input.Roles...)
+	sort.Strings(roles)
+	raw, err := json.Marshal(&decisionKey{Subject: input.Subject, Tenant: input.Tenant, Roles: roles,
+		Action: input.Action, Resource: input.Resource})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
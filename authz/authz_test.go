@@ -0,0 +1,235 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAuthorizer_Middleware(t *testing.T) {
+	var calls int32
+	a := NewAuthorizer(PDPFunc(func(ctx context.Context, input *Input) (*Result, error) {
+		atomic.AddInt32(&calls, 1)
+		if input.Subject == "broken" {
+			return nil, errors.New("unreachable")
+		}
+		for _, role := range input.Roles {
+			if role == "admin" {
+				return &Result{Allow: true}, nil
+			}
+		}
+		return &Result{Allow: input.Action == http.MethodGet, Reason: "read only"}, nil
+	}))
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name      string
+		identity  *turboAuth.Identity
+		method    string
+		wantCode  int
+		wantCalls int32
+	}{
+		{name: "Test_anonymous", method: http.MethodGet, wantCode: http.StatusUnauthorized},
+		{name: "Test_read", identity: &turboAuth.Identity{Subject: "alice"}, method: http.MethodGet, wantCode: http.StatusOK, wantCalls: 1},
+		{name: "Test_read_cached", identity: &turboAuth.Identity{Subject: "alice"}, method: http.MethodGet, wantCode: http.StatusOK},
+		{name: "Test_read_cached_other_token", identity: &turboAuth.Identity{Subject: "alice", TokenID: "t-2",
+			Claims: map[string]interface{}{"jti": "t-2", "iat": 1700000000}}, method: http.MethodGet, wantCode: http.StatusOK},
+		{name: "Test_write_denied", identity: &turboAuth.Identity{Subject: "alice"}, method: http.MethodPost, wantCode: http.StatusForbidden, wantCalls: 1},
+		{name: "Test_write_denied_cached", identity: &turboAuth.Identity{Subject: "alice"}, method: http.MethodPost, wantCode: http.StatusForbidden},
+		{name: "Test_admin_write", identity: &turboAuth.Identity{Subject: "alice", Roles: []string{"admin"}}, method: http.MethodPost, wantCode: http.StatusOK, wantCalls: 1},
+		{name: "Test_unavailable", identity: &turboAuth.Identity{Subject: "broken"}, method: http.MethodGet, wantCode: http.StatusServiceUnavailable, wantCalls: 1},
+		{name: "Test_unavailable_not_cached", identity: &turboAuth.Identity{Subject: "broken"}, method: http.MethodGet, wantCode: http.StatusServiceUnavailable, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&calls, 0)
+			r := httptest.NewRequest(tt.method, "/orders", nil)
+			if tt.identity != nil {
+				r = r.WithContext(turboAuth.NewContext(r.Context(), tt.identity))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %v, want %v", w.Code, tt.wantCode)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("pdp calls = %v, want %v", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestOPA_Decide(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		response  string
+		wantAllow bool
+		wantErr   bool
+	}{
+		{name: "Test_boolean_allow", status: http.StatusOK, response: `{"result":true}`, wantAllow: true},
+		{name: "Test_boolean_deny", status: http.StatusOK, response: `{"result":false}`},
+		{name: "Test_object", status: http.StatusOK, response: `{"result":{"allow":true,"reason":"owner"}}`, wantAllow: true},
+		{name: "Test_undefined", status: http.StatusOK, response: `{}`},
+		{name: "Test_invalid_result", status: http.StatusOK, response: `{"result":"yes"}`, wantErr: true},
+		{name: "Test_server_error", status: http.StatusInternalServerError, response: `{}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/data/httpapi/authz/allow" {
+					t.Errorf("path = %v", r.URL.Path)
+				}
+				var body struct {
+					Input Input `json:"input"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Input.Subject != "alice" {
+					t.Errorf("input = %+v, %v", body.Input, err)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+			result, err := NewOPA(server.URL, "/httpapi/authz/allow").Decide(context.Background(), &Input{Subject: "alice", Action: "GET", Resource: "/orders"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decide() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && result.Allow != tt.wantAllow {
+				t.Errorf("Allow = %v, want %v", result.Allow, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestCedarAgent_Decide(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  string
+		wantAllow bool
+		wantErr   bool
+	}{
+		{name: "Test_allow", response: `{"decision":"Allow","diagnostics":{"reason":["policy0"]}}`, wantAllow: true},
+		{name: "Test_deny", response: `{"decision":"Deny","diagnostics":{"reason":[]}}`},
+		{name: "Test_unknown_decision", response: `{"decision":"Maybe"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body map[string]interface{}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				if r.URL.Path != "/v1/is_authorized" || body["principal"] != `User::"alice"` ||
+					body["action"] != `Action::"GET"` || body["resource"] != `Resource::"/orders"` {
+					t.Errorf("request = %v %v", r.URL.Path, body)
+				}
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+			result, err := NewCedarAgent(server.URL).Decide(context.Background(), &Input{Subject: "alice", Action: "GET", Resource: "/orders"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decide() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && result.Allow != tt.wantAllow {
+				t.Errorf("Allow = %v, want %v", result.Allow, tt.wantAllow)
+			}
+		})
+	}
+}
+
+// policyEnforcer is a minimal enforcer of the policies (sub, obj, act, eft) or (sub, dom, obj, act, eft) with
+// the deny-override effect
+type policyEnforcer [][]interface{}
+
+func (p policyEnforcer) Enforce(rvals ...interface{}) (bool, error) {
+	allowed, _, err := p.EnforceEx(rvals...)
+	return allowed, err
+}
+
+func (p policyEnforcer) EnforceEx(rvals ...interface{}) (bool, []string, error) {
+	var allow []string
+	for _, policy := range p {
+		if len(policy) != len(rvals)+1 {
+			continue
+		}
+		matched := true
+		for i := range rvals {
+			matched = matched && policy[i] == rvals[i]
+		}
+		if !matched {
+			continue
+		}
+		explain := make([]string, len(policy))
+		for i := range policy {
+			explain[i] = policy[i].(string)
+		}
+		if policy[len(rvals)] == "deny" {
+			return false, explain, nil
+		}
+		allow = explain
+	}
+	return allow != nil, allow, nil
+}
+
+func TestRequirePermission(t *testing.T) {
+	enforcer := policyEnforcer{
+		{"user:alice", "orders", "read", "allow"},
+		{"role:editor", "orders", "write", "allow"},
+		{"role:editor", "acme", "invoices", "write", "allow"},
+		{"user:mallory", "orders", "write", "deny"},
+	}
+	tests := []struct {
+		name     string
+		casbin   *Casbin
+		identity *turboAuth.Identity
+		action   string
+		resource string
+		wantCode int
+	}{
+		{name: "Test_subject_policy", casbin: NewCasbin(enforcer), identity: &turboAuth.Identity{Subject: "alice"}, action: "read", resource: "orders", wantCode: http.StatusOK},
+		{name: "Test_no_policy", casbin: NewCasbin(enforcer), identity: &turboAuth.Identity{Subject: "alice"}, action: "write", resource: "orders", wantCode: http.StatusForbidden},
+		{name: "Test_role_policy", casbin: NewCasbin(enforcer), identity: &turboAuth.Identity{Subject: "bob", Roles: []string{"viewer", "editor"}}, action: "write", resource: "orders", wantCode: http.StatusOK},
+		{name: "Test_subject_deny_overrides_role", casbin: NewCasbin(enforcer), identity: &turboAuth.Identity{Subject: "mallory", Roles: []string{"editor"}}, action: "write", resource: "orders", wantCode: http.StatusForbidden},
+		{name: "Test_subject_named_as_role", casbin: NewCasbin(enforcer), identity: &turboAuth.Identity{Subject: "role:editor"}, action: "write", resource: "orders", wantCode: http.StatusForbidden},
+		{name: "Test_domain_policy", casbin: &Casbin{Enforcer: enforcer, Domains: true}, identity: &turboAuth.Identity{Subject: "bob", Tenant: "acme", Roles: []string{"editor"}}, action: "write", resource: "invoices", wantCode: http.StatusOK},
+		{name: "Test_other_domain", casbin: &Casbin{Enforcer: enforcer, Domains: true}, identity: &turboAuth.Identity{Subject: "bob", Tenant: "globex", Roles: []string{"editor"}}, action: "write", resource: "invoices", wantCode: http.StatusForbidden},
+		{name: "Test_request_func", casbin: &Casbin{Enforcer: enforcer, RequestFunc: func(input *Input) []interface{} {
+			return []interface{}{"user:" + input.Subject, input.Resource, "read"}
+		}}, identity: &turboAuth.Identity{Subject: "alice"}, action: "delete", resource: "orders", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequirePermission(tt.casbin, tt.action, tt.resource)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r = r.WithContext(turboAuth.NewContext(r.Context(), tt.identity))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %v, want %v", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAuthorizer_CacheSize(t *testing.T) {
+	var calls int32
+	a := NewAuthorizer(PDPFunc(func(ctx context.Context, input *Input) (*Result, error) {
+		atomic.AddInt32(&calls, 1)
+		return &Result{Allow: true}, nil
+	}))
+	a.CacheSize = 2
+	for _, subject := range []string{"alice", "bob", "carol", "carol", "bob", "alice"} {
+		if _, err := a.Authorize(context.Background(), &Input{Subject: subject, Action: "read"}); err != nil {
+			t.Fatalf("Authorize() error = %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 4 {
+		t.Errorf("pdp calls = %v, want 4 with alice evicted", got)
+	}
+	if got := a.lru.Len(); got != 2 {
+		t.Errorf("cached decisions = %v, want 2", got)
+	}
+}
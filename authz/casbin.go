@@ -0,0 +1,89 @@
+package authz
+
+import (
+	"context"
+	"strings"
+)
+
+type (
+	// Enforcer is the subset of the Casbin enforcers used by the adapter, *casbin.Enforcer, *casbin.SyncedEnforcer
+	// and *casbin.CachedEnforcer satisfy it. EnforceEx explains the decision with the matched policy, a denied
+	// request with a matched policy is an explicit deny
+	Enforcer interface {
+		Enforce(rvals ...interface{}) (bool, error)
+		EnforceEx(rvals ...interface{}) (bool, []string, error)
+	}
+
+	// Casbin decides with a Casbin enforcer. The request (sub, obj, act), or (sub, dom, obj, act) with Domains, is
+	// enforced for the subject of the identity and for each of its roles, an explicit deny of any of them overrides
+	// the allows. The roles of the token are not known to the role manager of the enforcer, so they are enforced as
+	// subjects with the RolePrefix, e.g. the policy "p, role:admin, /orders, POST" grants the POST to the holders of
+	// the role admin. The subject is namespaced with the SubjectPrefix so that it never collides with a role, e.g.
+	// "p, user:alice, /orders, GET"
+	Casbin struct {
+		Enforcer Enforcer
+		// Domains passes the tenant of the identity as the domain of the request
+		Domains bool
+		// RolePrefix prefixes the roles enforced as subjects, DefaultRolePrefix when empty
+		RolePrefix string
+		// SubjectPrefix prefixes the subject of the identity, DefaultSubjectPrefix when empty
+		SubjectPrefix string
+		// RequestFunc replaces the requests of the subject and roles with a single request, e.g. to pass the Input
+		// as the subject of an ABAC model matching r.sub.Tenant == r.obj.Owner
+		RequestFunc func(input *Input) []interface{}
+	}
+)
+
+const (
+	DefaultRolePrefix    = "role:"
+	DefaultSubjectPrefix = "user:"
+)
+
+func NewCasbin(enforcer Enforcer) *Casbin {
+	return &Casbin{Enforcer: enforcer, RolePrefix: DefaultRolePrefix, SubjectPrefix: DefaultSubjectPrefix}
+}
+
+func (c *Casbin) Decide(_ context.Context, input *Input) (*Result, error) {
+	if c.RequestFunc != nil {
+		allowed, err := c.Enforcer.Enforce(c.RequestFunc(input)...)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Allow: allowed}, nil
+	}
+	rolePrefix, subjectPrefix := c.RolePrefix, c.SubjectPrefix
+	if rolePrefix == "" {
+		rolePrefix = DefaultRolePrefix
+	}
+	if subjectPrefix == "" {
+		subjectPrefix = DefaultSubjectPrefix
+	}
+	subjects := make([]string, 0, len(input.Roles)+1)
+	if input.Subject != "" {
+		subjects = append(subjects, subjectPrefix+input.Subject)
+	}
+	for _, role := range input.Roles {
+		subjects = append(subjects, rolePrefix+role)
+	}
+	var allowedBy string
+	for _, subject := range subjects {
+		rvals := []interface{}{subject, input.Resource, input.Action}
+		if c.Domains {
+			rvals = []interface{}{subject, input.Tenant, input.Resource, input.Action}
+		}
+		allowed, explain, err := c.Enforcer.EnforceEx(rvals...)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed && len(explain) > 0 {
+			return &Result{Reason: "casbin policy of " + subject + " denies: " + strings.Join(explain, ", ")}, nil
+		}
+		if allowed && allowedBy == "" {
+			allowedBy = subject
+		}
+	}
+	if allowedBy == "" {
+		return &Result{Reason: "no casbin policy"}, nil
+	}
+	return &Result{Allow: true, Reason: "casbin policy of " + allowedBy}, nil
+}
@@ -0,0 +1,87 @@
+package authz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CedarAgent queries the is_authorized endpoint of a Cedar agent, the principal, action and resource are the
+// entities of the types set on the agent with the subject, action and resource of the Input as ids, the Input is
+// the context of the request
+type CedarAgent struct {
+	// URL of the agent, e.g. http://localhost:8180
+	URL           string
+	PrincipalType string
+	ActionType    string
+	ResourceType  string
+	Client        *http.Client
+}
+
+func NewCedarAgent(url string) *CedarAgent {
+	return &CedarAgent{
+		URL:           url,
+		PrincipalType: "User",
+		ActionType:    "Action",
+		ResourceType:  "Resource",
+		Client:        &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (c *CedarAgent) Decide(ctx context.Context, input *Input) (*Result, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"principal": entity(c.PrincipalType, input.Subject),
+		"action":    entity(c.ActionType, input.Action),
+		"resource":  entity(c.ResourceType, input.Resource),
+		"context":   input,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(c.URL, "/")+"/v1/is_authorized", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cedar agent returned %s", res.Status)
+	}
+	var response struct {
+		Decision    string `json:"decision"`
+		Diagnostics struct {
+			Reason []string `json:"reason"`
+		} `json:"diagnostics"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	reason := strings.Join(response.Diagnostics.Reason, ", ")
+	switch response.Decision {
+	case "Allow":
+		return &Result{Allow: true, Reason: reason}, nil
+	case "Deny":
+		return &Result{Reason: reason}, nil
+	default:
+		return nil, fmt.Errorf("cedar agent returned the decision %q", response.Decision)
+	}
+}
+
+// entity formats the uid of a Cedar entity, e.g. User::"alice"
+func entity(entityType string, id string) string {
+	return entityType + "::" + strconv.Quote(id)
+}
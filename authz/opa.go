@@ -0,0 +1,81 @@
+package authz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OPA queries the Data API of an OPA server, the rule at Path receives the Input as the input document. The rule
+// evaluates to a boolean, or to an object with an allow boolean and an optional reason string, e.g.
+//
+//	package httpapi.authz
+//	default allow := false
+//	allow { input.roles[_] == "admin" }
+type OPA struct {
+	// URL of the server, e.g. http://localhost:8181
+	URL string
+	// Path of the rule, e.g. httpapi/authz/allow
+	Path   string
+	Client *http.Client
+}
+
+func NewOPA(url string, path string) *OPA {
+	return &OPA{
+		URL:    url,
+		Path:   path,
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (o *OPA) Decide(ctx context.Context, input *Input) (*Result, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := strings.TrimSuffix(o.URL, "/") + "/v1/data/" + strings.Trim(o.Path, "/")
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("opa returned %s", res.Status)
+	}
+	var response struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	// an undefined rule has no result, it denies
+	if len(response.Result) == 0 {
+		return &Result{Reason: "undefined " + o.Path}, nil
+	}
+	var allow bool
+	if err := json.Unmarshal(response.Result, &allow); err == nil {
+		return &Result{Allow: allow}, nil
+	}
+	var result struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return nil, fmt.Errorf("opa result is neither a boolean nor an object: %w", err)
+	}
+	return &Result{Allow: result.Allow, Reason: result.Reason}, nil
+}
@@ -0,0 +1,181 @@
+package clientip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type (
+	// Resolver derives the address of the client from the forwarding header, the header is only read when the
+	// immediate peer is one of the TrustedProxies since any client can set it. The rate limiting, the audit events
+	// and the network policies resolve the address through it
+	Resolver struct {
+		TrustedProxies []*net.IPNet
+		// Header is the one forwarding header the trusted proxies set, e.g. HeaderForwarded. The other forwarding
+		// headers are ignored as the proxies pass them through from the clients, the remote address of the
+		// connection is used when empty
+		Header string
+	}
+
+	clientIPKey struct{}
+)
+
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+var (
+	// Default is used by FromRequest when the request was not resolved by a Middleware, it trusts no proxy
+	Default = &Resolver{}
+
+	ErrHeaderRequired = errors.New("the forwarding header of the trusted proxies is required")
+)
+
+// NewResolver trusts the header set by the proxies of the ranges, in the CIDR notation or as single addresses
+func NewResolver(header string, trustedProxies ...string) (*Resolver, error) {
+	if header == "" && len(trustedProxies) > 0 {
+		return nil, ErrHeaderRequired
+	}
+	networks, err := ParseCIDRs(trustedProxies...)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{TrustedProxies: networks, Header: header}, nil
+}
+
+// ParseCIDRs parses the ranges, a single address is a range of its own
+func ParseCIDRs(cidrs ...string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Contains reports whether one of the networks contains the address
+func Contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromRequest returns the address stored by a Middleware, the address resolved by the Default resolver otherwise
+func FromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return Default.RemoteIP(r)
+}
+
+// NewContext returns a copy of the parent context carrying the address of the client
+func NewContext(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// Middleware resolves the address of the client once and stores it in the context of the request for FromRequest
+func (resolver *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), resolver.RemoteIP(r))))
+	})
+}
+
+// RemoteIP returns the address of the client as a string, the remote address of the request when it cannot be
+// parsed
+func (resolver *Resolver) RemoteIP(r *http.Request) string {
+	if ip := resolver.ClientIP(r); ip != nil {
+		return ip.String()
+	}
+	return peer(r)
+}
+
+// ClientIP returns the address of the client, the hops of the forwarding header are walked from the right, the
+// ones appended by the trusted proxies are skipped and the first untrusted one is the client
+func (resolver *Resolver) ClientIP(r *http.Request) net.IP {
+	ip := net.ParseIP(peer(r))
+	if ip == nil || !Contains(resolver.TrustedProxies, ip) {
+		return ip
+	}
+	hops := resolver.hops(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(hops[i])
+		// e.g. the unknown and the obfuscated identifiers of the Forwarded header
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !Contains(resolver.TrustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// hops returns the addresses of the forwarding header, the client first
+func (resolver *Resolver) hops(r *http.Request) []string {
+	if resolver.Header == "" {
+		return nil
+	}
+	values := r.Header.Values(resolver.Header)
+	if len(values) == 0 {
+		return nil
+	}
+	var hops []string
+	for _, element := range strings.Split(strings.Join(values, ","), ",") {
+		if http.CanonicalHeaderKey(resolver.Header) == HeaderForwarded {
+			element = forwardedFor(element)
+		}
+		hops = append(hops, strings.TrimSpace(element))
+	}
+	return hops
+}
+
+// forwardedFor returns the address of the for parameter of a Forwarded element (RFC 7239), e.g.
+// for="[2001:db8::17]:4711";proto=https
+func forwardedFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) < 4 || !strings.EqualFold(pair[:4], "for=") {
+			continue
+		}
+		node := strings.Trim(pair[4:], `"`)
+		if host, _, err := net.SplitHostPort(node); err == nil {
+			return host
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	}
+	return ""
+}
+
+func peer(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
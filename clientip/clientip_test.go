@@ -0,0 +1,76 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolver_RemoteIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{name: "Test_direct", header: HeaderXForwardedFor, remoteAddr: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "Test_untrusted_peer", header: HeaderXForwardedFor, remoteAddr: "203.0.113.7:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.1"}, want: "203.0.113.7"},
+		{name: "Test_x_forwarded_for", header: HeaderXForwardedFor, remoteAddr: "10.0.0.2:1234",
+			headers: map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1, 10.0.0.3"}, want: "198.51.100.1"},
+		{name: "Test_x_real_ip", header: HeaderXRealIP, remoteAddr: "10.0.0.2:1234",
+			headers: map[string]string{"X-Real-IP": "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "Test_forwarded", header: HeaderForwarded, remoteAddr: "[2001:db8::1]:1234",
+			headers: map[string]string{"Forwarded": `for=1.1.1.1, for="[2001:db8:cafe::17]:4711";proto=https`}, want: "2001:db8:cafe::17"},
+		{name: "Test_other_header_ignored", header: HeaderForwarded, remoteAddr: "10.0.0.2:1234",
+			headers: map[string]string{"X-Forwarded-For": "1.1.1.1"}, want: "10.0.0.2"},
+		{name: "Test_forwarded_unknown", header: HeaderForwarded, remoteAddr: "10.0.0.2:1234",
+			headers: map[string]string{"Forwarded": "for=unknown, for=10.0.0.3"}, want: "10.0.0.3"},
+		{name: "Test_no_header", header: HeaderXForwardedFor, remoteAddr: "10.0.0.2:1234", want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := NewResolver(tt.header, "10.0.0.0/8", "2001:db8::1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if got := resolver.RemoteIP(r); got != tt.want {
+				t.Errorf("RemoteIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	if _, err := NewResolver("", "10.0.0.0/8"); err != ErrHeaderRequired {
+		t.Errorf("NewResolver() error = %v, want %v", err, ErrHeaderRequired)
+	}
+}
+
+func TestResolver_Middleware(t *testing.T) {
+	resolver, err := NewResolver(HeaderXForwardedFor, "10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromRequest(r)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "198.51.100.1" {
+		t.Errorf("FromRequest() = %v, want 198.51.100.1", got)
+	}
+	// the default resolver trusts no proxy
+	if got := FromRequest(r); got != "10.0.0.2" {
+		t.Errorf("FromRequest() without middleware = %v, want 10.0.0.2", got)
+	}
+}
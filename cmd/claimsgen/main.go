@@ -0,0 +1,328 @@
+// Command claimsgen generates the MarshalClaims and UnmarshalClaims methods of the claims structs embedding
+// jwt.Payload, the jwt.JSONSerializer uses them instead of reflecting over the structs, e.g.
+//
+//	//go:generate go run github.com/nandlabs/turbo-auth/cmd/claimsgen -type OrderClaims
+//
+// The fields are encoded under their json names, the string and []string fields without encoding/json, the other
+// ones with it. omitempty is supported on the basic types, the pointers, the slices and the maps
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const jwtPackage = "github.com/nandlabs/turbo-auth/providers/jwt"
+
+type (
+	// claimsType is a struct to generate the methods of
+	claimsType struct {
+		name   string
+		fields []claimsField
+	}
+
+	claimsField struct {
+		// name is the name of the Go field, jsonName the name of the claim
+		name      string
+		jsonName  string
+		kind      fieldKind
+		omitEmpty bool
+		// present is the condition of the omitempty fields being written
+		present string
+	}
+
+	fieldKind int
+)
+
+const (
+	kindPayload fieldKind = iota
+	kindString
+	kindStrings
+	kindValue
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+// run generates the file and returns the exit code, 2 for usage errors
+func run(args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("claimsgen", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	types := flags.String("type", "", "comma separated names of the claims structs")
+	output := flags.String("output", "", "generated file, <type>_claims.go of the first type when empty")
+	dir := flags.String("dir", ".", "directory of the package declaring the types")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *types == "" {
+		fmt.Fprintln(stderr, "usage: claimsgen -type <name>[,<name>] [-output <file>] [-dir <package directory>]")
+		return 2
+	}
+	names := strings.Split(*types, ",")
+	if *output == "" {
+		*output = strings.ToLower(names[0]) + "_claims.go"
+	}
+	source, err := generate(*dir, names)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(*dir, *output), source, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "claimsgen: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// generate returns the source of the methods of the named structs declared in the package of dir
+func generate(dir string, names []string) ([]byte, error) {
+	fset := token.NewFileSet()
+	packages, err := parser.ParseDir(fset, dir, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		pkgName string
+		alias   string
+		types   []*claimsType
+	)
+	for _, name := range names {
+		file, spec := lookupType(packages, name)
+		if spec == nil {
+			return nil, fmt.Errorf("type %s not found in %s", name, dir)
+		}
+		if pkgName != "" && file.Name.Name != pkgName {
+			return nil, fmt.Errorf("type %s is declared in package %s, not %s", name, file.Name.Name, pkgName)
+		}
+		pkgName, alias = file.Name.Name, jwtAlias(file)
+		if alias == "" {
+			return nil, fmt.Errorf("the file declaring %s does not import %s", name, jwtPackage)
+		}
+		structType, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			return nil, fmt.Errorf("type %s is not a struct", name)
+		}
+		claims, err := newClaimsType(name, structType, alias)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, claims)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by claimsgen. DO NOT EDIT.\n\npackage %s\n\n", pkgName)
+	importName := alias + " "
+	if alias == "jwt" {
+		importName = ""
+	}
+	fmt.Fprintf(&buf, "import (\n\t\"encoding/json\"\n\t%s%q\n)\n", importName, jwtPackage)
+	for _, claims := range types {
+		claims.writeMarshal(&buf, alias)
+		claims.writeUnmarshal(&buf, alias)
+	}
+	return format.Source(buf.Bytes())
+}
+
+// lookupType finds the declaration of the type, the packages are searched in the order of their names
+func lookupType(packages map[string]*ast.Package, name string) (*ast.File, *ast.TypeSpec) {
+	var pkgNames []string
+	for pkgName := range packages {
+		pkgNames = append(pkgNames, pkgName)
+	}
+	sort.Strings(pkgNames)
+	for _, pkgName := range pkgNames {
+		for _, file := range packages[pkgName].Files {
+			for _, decl := range file.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					if typeSpec := spec.(*ast.TypeSpec); typeSpec.Name.Name == name {
+						return file, typeSpec
+					}
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// jwtAlias returns the name the file imports the jwt provider under, empty when it does not import it
+func jwtAlias(file *ast.File) string {
+	for _, spec := range file.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path == jwtPackage {
+			if spec.Name != nil {
+				return spec.Name.Name
+			}
+			return "jwt"
+		}
+	}
+	return ""
+}
+
+func newClaimsType(name string, structType *ast.StructType, alias string) (*claimsType, error) {
+	claims := &claimsType{name: name}
+	var payload bool
+	for _, field := range structType.Fields.List {
+		if len(field.Names) == 0 {
+			if selector, ok := field.Type.(*ast.SelectorExpr); ok && selector.Sel.Name == "Payload" &&
+				isIdent(selector.X, alias) {
+				claims.fields = append(claims.fields, claimsField{name: "Payload", kind: kindPayload})
+				payload = true
+				continue
+			}
+			return nil, fmt.Errorf("%s: only %s.Payload can be embedded", name, alias)
+		}
+		jsonName, omitEmpty, skip := jsonTag(field)
+		for _, ident := range field.Names {
+			if skip || !ident.IsExported() {
+				continue
+			}
+			f := claimsField{name: ident.Name, jsonName: jsonName, omitEmpty: omitEmpty}
+			if f.jsonName == "" {
+				f.jsonName = ident.Name
+			}
+			f.kind, f.present = fieldType(field.Type, "c."+ident.Name)
+			if omitEmpty && f.present == "" {
+				return nil, fmt.Errorf("%s.%s: omitempty is supported on the basic types, the pointers, the slices and "+
+					"the maps only", name, ident.Name)
+			}
+			claims.fields = append(claims.fields, f)
+		}
+	}
+	if !payload {
+		return nil, fmt.Errorf("%s does not embed %s.Payload", name, alias)
+	}
+	return claims, nil
+}
+
+// jsonTag reads the name and the omitempty option of the json tag, skip is true for the fields tagged "-"
+func jsonTag(field *ast.Field) (name string, omitEmpty bool, skip bool) {
+	if field.Tag == nil {
+		return "", false, false
+	}
+	tag, _ := strconv.Unquote(field.Tag.Value)
+	value, ok := reflect.StructTag(tag).Lookup("json")
+	if !ok {
+		return "", false, false
+	}
+	if value == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(value, ",")
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return parts[0], omitEmpty, false
+}
+
+// fieldType returns the kind of the field and the condition of its value not being empty, empty when unknown
+func fieldType(expr ast.Expr, value string) (fieldKind, string) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			return kindString, value + ` != ""`
+		case "bool":
+			return kindValue, value
+		case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "float32",
+			"float64":
+			return kindValue, value + " != 0"
+		}
+	case *ast.ArrayType:
+		if t.Len != nil {
+			break
+		}
+		if isIdent(t.Elt, "string") {
+			return kindStrings, "len(" + value + ") != 0"
+		}
+		return kindValue, "len(" + value + ") != 0"
+	case *ast.MapType:
+		return kindValue, "len(" + value + ") != 0"
+	case *ast.StarExpr, *ast.InterfaceType:
+		return kindValue, value + " != nil"
+	}
+	return kindValue, ""
+}
+
+func isIdent(expr ast.Expr, name string) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == name
+}
+
+func (claims *claimsType) writeMarshal(w io.Writer, alias string) {
+	var values bool
+	for _, f := range claims.fields {
+		values = values || f.kind == kindValue
+	}
+	fmt.Fprintf(w, "\n// MarshalClaims encodes the claims, see %s.ClaimsMarshaler\n", alias)
+	fmt.Fprintf(w, "func (c *%s) MarshalClaims() ([]byte, error) {\n", claims.name)
+	if values {
+		fmt.Fprintf(w, "var err error\n")
+	}
+	fmt.Fprintf(w, "buf := make([]byte, 0, 256)\nbuf = append(buf, '{')\n")
+	for _, f := range claims.fields {
+		if f.omitEmpty {
+			fmt.Fprintf(w, "if %s {\n", f.present)
+		}
+		switch f.kind {
+		case kindPayload:
+			fmt.Fprintf(w, "buf = %s.AppendPayloadClaims(buf, &c.Payload)\n", alias)
+		case kindString:
+			fmt.Fprintf(w, "buf = %s.AppendClaimName(buf, %q)\n", alias, f.jsonName)
+			fmt.Fprintf(w, "buf = %s.AppendClaimString(buf, c.%s)\n", alias, f.name)
+		case kindStrings:
+			fmt.Fprintf(w, "buf = %s.AppendClaimName(buf, %q)\n", alias, f.jsonName)
+			fmt.Fprintf(w, "buf = %s.AppendClaimStrings(buf, c.%s)\n", alias, f.name)
+		default:
+			fmt.Fprintf(w, "buf = %s.AppendClaimName(buf, %q)\n", alias, f.jsonName)
+			fmt.Fprintf(w, "if buf, err = %s.AppendClaimValue(buf, c.%s); err != nil {\nreturn nil, err\n}\n", alias,
+				f.name)
+		}
+		if f.omitEmpty {
+			fmt.Fprintf(w, "}\n")
+		}
+	}
+	fmt.Fprintf(w, "return append(buf, '}'), nil\n}\n")
+}
+
+func (claims *claimsType) writeUnmarshal(w io.Writer, alias string) {
+	fmt.Fprintf(w, "\n// UnmarshalClaims decodes the claims, see %s.ClaimsUnmarshaler\n", alias)
+	fmt.Fprintf(w, "func (c *%s) UnmarshalClaims(data []byte) error {\n", claims.name)
+	fmt.Fprintf(w, "var fields map[string]json.RawMessage\n")
+	fmt.Fprintf(w, "if err := json.Unmarshal(data, &fields); err != nil {\nreturn err\n}\n")
+	for _, f := range claims.fields {
+		var call string
+		switch f.kind {
+		case kindPayload:
+			fmt.Fprintf(w, "if err := %s.UnmarshalPayloadClaims(fields, &c.Payload); err != nil {\nreturn err\n}\n",
+				alias)
+			continue
+		case kindString:
+			call = "UnmarshalClaimString"
+		case kindStrings:
+			call = "UnmarshalClaimStrings"
+		default:
+			call = "UnmarshalClaimValue"
+		}
+		fmt.Fprintf(w, "if raw, ok := fields[%q]; ok {\n", f.jsonName)
+		fmt.Fprintf(w, "if err := %s.%s(raw, &c.%s); err != nil {\nreturn err\n}\n}\n", alias, call, f.name)
+	}
+	fmt.Fprintf(w, "return nil\n}\n")
+}
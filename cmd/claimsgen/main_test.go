@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		args     []string
+		wantCode int
+		want     []string
+	}{
+		{name: "Test_generated", source: `package orders
+
+import auth "github.com/nandlabs/turbo-auth/providers/jwt"
+
+type OrderClaims struct {
+	auth.Payload
+	Roles    []string ` + "`json:\"Roles,omitempty\"`" + `
+	TenantID string   ` + "`json:\"tid\"`" + `
+	Admin    bool     ` + "`json:\"adm,omitempty\"`" + `
+	secret   string
+}
+`, args: []string{"-type", "OrderClaims"}, want: []string{
+			"package orders",
+			`auth "github.com/nandlabs/turbo-auth/providers/jwt"`,
+			"func (c *OrderClaims) MarshalClaims() ([]byte, error)",
+			"buf = auth.AppendPayloadClaims(buf, &c.Payload)",
+			"if len(c.Roles) != 0 {",
+			`buf = auth.AppendClaimString(buf, c.TenantID)`,
+			"if c.Admin {",
+			"func (c *OrderClaims) UnmarshalClaims(data []byte) error",
+			`if raw, ok := fields["tid"]; ok {`,
+		}},
+		{name: "Test_missing_type", source: "package orders\n", args: []string{"-type", "OrderClaims"}, wantCode: 1},
+		{name: "Test_no_payload", source: `package orders
+
+import "github.com/nandlabs/turbo-auth/providers/jwt"
+
+var _ jwt.Payload
+
+type OrderClaims struct {
+	TenantID string
+}
+`, args: []string{"-type", "OrderClaims"}, wantCode: 1},
+		{name: "Test_unsupported_omitempty", source: `package orders
+
+import "github.com/nandlabs/turbo-auth/providers/jwt"
+
+type OrderClaims struct {
+	jwt.Payload
+	Level level ` + "`json:\"lvl,omitempty\"`" + `
+}
+`, args: []string{"-type", "OrderClaims"}, wantCode: 1},
+		{name: "Test_usage", args: []string{}, wantCode: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := ioutil.WriteFile(filepath.Join(dir, "claims.go"), []byte(tt.source), 0600); err != nil {
+				t.Fatal(err)
+			}
+			var stderr bytes.Buffer
+			if code := run(append(tt.args, "-dir", dir), &stderr); code != tt.wantCode {
+				t.Fatalf("run() = %v, want %v, stderr = %v", code, tt.wantCode, stderr.String())
+			}
+			if tt.wantCode != 0 {
+				return
+			}
+			generated, err := ioutil.ReadFile(filepath.Join(dir, "orderclaims_claims.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(generated), want) {
+					t.Errorf("generated source does not contain %q:\n%s", want, generated)
+				}
+			}
+			if strings.Contains(string(generated), "secret") {
+				t.Errorf("generated source encodes the unexported field:\n%s", generated)
+			}
+		})
+	}
+}
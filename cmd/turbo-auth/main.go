@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/nandlabs/turbo-auth/config"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `usage: turbo-auth <command> [flags]
+
+commands:
+  issue   -config <file> -sub <subject> [-ttl <duration>]     issue a token from the jwt configuration
+  decode  <token>                                           print the header and the claims without verifying
+  verify  (-config <file> | -key <file> [-alg <alg>] | -jwks <url>) <token>
+                                                            verify the token and print its identity, the HMAC
+                                                            secret is read from the file, stdin for -key -, or
+                                                            TURBO_AUTH_JWT_SIGNING_KEY
+  revoke  -config <file> -jti <id> [-exp <RFC 3339 time>]    revoke the token id in the configured store
+  encrypt (-new-key | <value>)                              seal a config secret with TURBO_AUTH_MASTER_KEY,
+                                                            or print a new master key
+  break-glass -key <file> -alg <alg> -sub <subject> -reason <text> [-roles <a,b>] [-ttl <duration>]
+                                                            issue an emergency token with the offline key
+`
+
+// envSigningKey is the HMAC secret of verify when neither -config, -key nor -jwks is set
+const envSigningKey = config.DefaultEnvPrefix + "_JWT_SIGNING_KEY"
+
+var (
+	errUsage = errors.New("invalid usage")
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command and returns the exit code, 2 for usage errors
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "issue":
+		err = issue(args[1:], stdout, stderr)
+	case "decode":
+		err = decode(args[1:], stdout)
+	case "verify":
+		err = verify(args[1:], stdin, stdout, stderr)
+	case "revoke":
+		err = revoke(args[1:], stdout, stderr)
+	case "encrypt":
+		err = encrypt(args[1:], stdout, stderr)
+	case "break-glass":
+		err = breakGlass(args[1:], stdout, stderr)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		err = errUsage
+	}
+	if err == errUsage || err == flag.ErrHelp {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "turbo-auth %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func issue(args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := newFlagSet("issue", stderr)
+	path := flags.String("config", "", "configuration file")
+	subject := flags.String("sub", "", "subject (username) of the token")
+	ttl := flags.Duration("ttl", 0, "validity of the token, the configured auth token validity by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" || *subject == "" {
+		return errUsage
+	}
+	c, err := loadJwtConfig(*path)
+	if err != nil {
+		return err
+	}
+	if *ttl == 0 {
+		*ttl = time.Duration(c.AuthTokenValidTime)
+	}
+	token, jwtErr := c.JwtAuthConfig().IssueNewToken(*subject, *ttl)
+	if jwtErr != nil {
+		return jwtErr
+	}
+	fmt.Fprintln(stdout, token)
+	return nil
+}
+
+func decode(args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	segments := strings.Split(args[0], ".")
+	var document struct {
+		Header json.RawMessage `json:"header"`
+		Claims json.RawMessage `json:"claims,omitempty"`
+		// Encrypted tokens only expose their protected header
+		Encrypted bool `json:"encrypted,omitempty"`
+	}
+	switch len(segments) {
+	case 3:
+	case 5:
+		document.Encrypted = true
+	default:
+		return errors.New("token is malformed")
+	}
+	var err error
+	if document.Header, err = decodeSegment(segments[0]); err != nil {
+		return fmt.Errorf("header: %v", err)
+	}
+	if !document.Encrypted {
+		if document.Claims, err = decodeSegment(segments[1]); err != nil {
+			return fmt.Errorf("claims: %v", err)
+		}
+	}
+	return printJSON(stdout, document)
+}
+
+func verify(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	flags := newFlagSet("verify", stderr)
+	path := flags.String("config", "", "configuration file")
+	keyPath := flags.String("key", "", "file of the HMAC secret, - for stdin")
+	alg := flags.String("alg", "HS256", "signing method of the HMAC secret")
+	jwksURL := flags.String("jwks", "", "url of the json web key set")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	var opts []jwt.Option
+	key, err := readSecret(*keyPath, stdin)
+	if err != nil {
+		return err
+	}
+	switch {
+	case *path != "":
+		c, err := loadJwtConfig(*path)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, jwt.WithSigningKey(c.SigningKey), jwt.WithSigningMethod(c.SigningMethod),
+			jwt.WithLeeway(time.Duration(c.Leeway)))
+	case *jwksURL != "":
+		keyRing, err := fetchJWKS(*jwksURL)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, jwt.WithKeyStore(keyRing))
+	case key != "":
+		opts = append(opts, jwt.WithSigningKey(key), jwt.WithSigningMethod(*alg))
+	default:
+		return errUsage
+	}
+	authenticator, err := jwt.NewJwtAuthenticator(opts...)
+	if err != nil {
+		return err
+	}
+	identity, err := authenticator.Authenticate(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printJSON(stdout, identity)
+}
+
+func revoke(args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := newFlagSet("revoke", stderr)
+	path := flags.String("config", "", "configuration file")
+	jti := flags.String("jti", "", "id of the token")
+	exp := flags.String("exp", "", "expiry of the token in RFC 3339, the revocation is kept forever when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" || *jti == "" {
+		return errUsage
+	}
+	var expiresAt time.Time
+	if *exp != "" {
+		var err error
+		if expiresAt, err = time.Parse(time.RFC3339, *exp); err != nil {
+			return err
+		}
+	}
+	c, err := config.Load(*path)
+	if err != nil {
+		return err
+	}
+	if c.Revocation == nil {
+		return errors.New("no revocation store configured")
+	}
+	if err := c.Revocation.Revoker().Revoke(*jti, expiresAt); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "revoked %s\n", *jti)
+	return nil
+}
+
+func encrypt(args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := newFlagSet("encrypt", stderr)
+	newKey := flags.Bool("new-key", false, "print a new random master key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *newKey {
+		masterKey, err := config.NewMasterKey()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, masterKey)
+		return nil
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	resolver, err := config.NewSecretResolverFromEnv(config.DefaultEnvPrefix)
+	if err != nil {
+		return err
+	}
+	if resolver.MasterKey == nil {
+		return config.ErrNoMasterKey
+	}
+	sealed, err := config.EncryptSecret(resolver.MasterKey, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, sealed)
+	return nil
+}
+
+func breakGlass(args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := newFlagSet("break-glass", stderr)
+	path := flags.String("key", "", "file of the offline key, the PEM private key or the HMAC secret")
+	alg := flags.String("alg", "ES256", "signing method of the key")
+	subject := flags.String("sub", "", "subject of the token")
+	reason := flags.String("reason", "", "reason of the emergency access, e.g. the incident id")
+	roles := flags.String("roles", "", "comma separated roles of the token")
+	ttl := flags.Duration("ttl", jwt.DefaultBreakGlassTTL, "validity of the token")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" || *subject == "" || *reason == "" {
+		return errUsage
+	}
+	key, err := jwt.NewFileKeySource(*path, *alg).NextKey()
+	if err != nil {
+		return err
+	}
+	grant := &jwt.BreakGlassGrant{Subject: *subject, Reason: *reason, TTL: *ttl}
+	if *roles != "" {
+		grant.Roles = strings.Split(*roles, ",")
+	}
+	token, err := jwt.IssueBreakGlassToken(key, grant)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, token)
+	return nil
+}
+
+// readSecret reads the secret of the file, of stdin for "-", or of envSigningKey when path is empty. Secrets are not
+// taken as flags, they would show in the process list and the shell history
+func readSecret(path string, stdin io.Reader) (string, error) {
+	var (
+		content []byte
+		err     error
+	)
+	switch path {
+	case "":
+		return os.Getenv(envSigningKey), nil
+	case "-":
+		content, err = ioutil.ReadAll(stdin)
+	default:
+		content, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return flags
+}
+
+func loadJwtConfig(path string) (*config.JwtConfig, error) {
+	c, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if c.Jwt == nil {
+		return nil, errors.New("no jwt section in " + path)
+	}
+	return c.Jwt, nil
+}
+
+// fetchJWKS builds a verification only key ring from the key set
+func fetchJWKS(url string) (*jwt.KeyRing, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: unexpected status %s", response.Status)
+	}
+	var jwks jwt.JWKS
+	if err := json.NewDecoder(response.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+	var keys []*jwt.Key
+	for _, jwk := range jwks.Keys {
+		publicKey, err := jwk.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %s: %v", jwk.Kid, err)
+		}
+		keys = append(keys, &jwt.Key{ID: jwk.Kid, SigningMethod: jwk.Alg, VerifyKey: publicKey})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks: no keys")
+	}
+	return jwt.NewKeyRing(keys[0], keys[1:]...)
+}
+
+func decodeSegment(segment string) (json.RawMessage, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, errors.New("not a json document")
+	}
+	return data, nil
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "turbo-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "auth.yaml")
+	if err := ioutil.WriteFile(path, []byte("jwt:\n  signingKey: \"0123456789abcdef0123456789abcdef\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "hmac.key")
+	if err := ioutil.WriteFile(keyPath, []byte("0123456789abcdef0123456789abcdef\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	wrongKeyPath := filepath.Join(dir, "wrong.key")
+	if err := ioutil.WriteFile(wrongKeyPath, []byte("wrong"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"issue", "-config", path, "-sub", "test_user"}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("issue exit code = %v, stderr = %v", code, stderr.String())
+	}
+	token := strings.TrimSpace(stdout.String())
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		env      string
+		wantCode int
+		wantOut  string
+	}{
+		{name: "Test_decode", args: []string{"decode", token}, wantOut: `"Username": "test_user"`},
+		{name: "Test_verify_config", args: []string{"verify", "-config", path, token}, wantOut: `"Subject": "test_user"`},
+		{name: "Test_verify_key_file", args: []string{"verify", "-key", keyPath, token}, wantOut: `"Subject": "test_user"`},
+		{name: "Test_verify_key_stdin", args: []string{"verify", "-key", "-", token}, stdin: "0123456789abcdef0123456789abcdef\n", wantOut: `"Subject": "test_user"`},
+		{name: "Test_verify_key_env", args: []string{"verify", token}, env: "0123456789abcdef0123456789abcdef", wantOut: `"Subject": "test_user"`},
+		{name: "Test_verify_wrong_key", args: []string{"verify", "-key", wrongKeyPath, token}, wantCode: 1},
+		{name: "Test_verify_missing_key_file", args: []string{"verify", "-key", filepath.Join(dir, "missing"), token}, wantCode: 1},
+		{name: "Test_verify_without_key", args: []string{"verify", token}, wantCode: 2},
+		{name: "Test_revoke_without_store", args: []string{"revoke", "-config", path, "-jti", "id"}, wantCode: 1},
+		{name: "Test_encrypt_without_master_key", args: []string{"encrypt", "secret"}, wantCode: 1},
+		{name: "Test_unknown_command", args: []string{"sign"}, wantCode: 2},
+		{name: "Test_missing_flags", args: []string{"issue"}, wantCode: 2},
+		{name: "Test_break_glass_without_reason", args: []string{"break-glass", "-key", path, "-sub", "admin"}, wantCode: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				os.Setenv(envSigningKey, tt.env)
+				defer os.Unsetenv(envSigningKey)
+			}
+			var stdout, stderr bytes.Buffer
+			if code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr); code != tt.wantCode {
+				t.Fatalf("exit code = %v, want %v, stderr = %v", code, tt.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantOut) {
+				t.Errorf("stdout = %v, want %v", stdout.String(), tt.wantOut)
+			}
+			if tt.wantCode == 0 && !json.Valid(stdout.Bytes()) {
+				t.Errorf("stdout is not json: %v", stdout.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type (
+	// Format of the configuration file
+	Format string
+
+	// Duration is a time.Duration written as a string, e.g. "15m" or "72h"
+	Duration time.Duration
+
+	// Config is the file / environment representation of the providers, a nil section is not configured
+	Config struct {
+		Jwt       *JwtConfig       `json:"jwt,omitempty" yaml:"jwt,omitempty" env:"JWT"`
+		Sessions  *SessionsConfig  `json:"sessions,omitempty" yaml:"sessions,omitempty" env:"SESSIONS"`
+		RateLimit *RateLimitConfig `json:"rateLimit,omitempty" yaml:"rateLimit,omitempty" env:"RATE_LIMIT"`
+		// Revocation configures the shared store of the revoked tokens, in memory revocation is used when nil
+		Revocation *RevocationConfig `json:"revocation,omitempty" yaml:"revocation,omitempty" env:"REVOCATION"`
+		Social     *SocialConfig     `json:"social,omitempty" yaml:"social,omitempty" env:"SOCIAL"`
+	}
+
+	JwtConfig struct {
+		// SigningKey is the HMAC secret, a secret reference (env://, file:// or enc:v1:) keeps it out of the file
+		SigningKey            string   `json:"signingKey" yaml:"signingKey" env:"SIGNING_KEY" secret:"true"`
+		SigningMethod         string   `json:"signingMethod" yaml:"signingMethod" env:"SIGNING_METHOD"`
+		BearerTokens          bool     `json:"bearerTokens" yaml:"bearerTokens" env:"BEARER_TOKENS"`
+		AuthTokenValidTime    Duration `json:"authTokenValidTime" yaml:"authTokenValidTime" env:"AUTH_TOKEN_VALID_TIME"`
+		RefreshTokenValidTime Duration `json:"refreshTokenValidTime" yaml:"refreshTokenValidTime" env:"REFRESH_TOKEN_VALID_TIME"`
+		AuthTokenName         string   `json:"authTokenName" yaml:"authTokenName" env:"AUTH_TOKEN_NAME"`
+		RefreshTokenName      string   `json:"refreshTokenName" yaml:"refreshTokenName" env:"REFRESH_TOKEN_NAME"`
+		SlidingWindow         Duration `json:"slidingWindow" yaml:"slidingWindow" env:"SLIDING_WINDOW"`
+		// Leeway tolerates the clock skew between the nodes
+		Leeway Duration `json:"leeway" yaml:"leeway" env:"LEEWAY"`
+		// ReloadInterval is how often a file:// signing key is checked for changes by the KeyManager
+		ReloadInterval Duration `json:"reloadInterval" yaml:"reloadInterval" env:"RELOAD_INTERVAL"`
+		// KeyIDSecret derives the kid of a file:// HMAC signing key, the replicas must share it to agree on the kid
+		KeyIDSecret string `json:"keyIdSecret" yaml:"keyIdSecret" env:"KEY_ID_SECRET" secret:"true"`
+
+		signingKeyFile string
+	}
+
+	SessionsConfig struct {
+		CookieName      string   `json:"cookieName" yaml:"cookieName" env:"COOKIE_NAME"`
+		IdleTimeout     Duration `json:"idleTimeout" yaml:"idleTimeout" env:"IDLE_TIMEOUT"`
+		AbsoluteTimeout Duration `json:"absoluteTimeout" yaml:"absoluteTimeout" env:"ABSOLUTE_TIMEOUT"`
+		Insecure        bool     `json:"insecure" yaml:"insecure" env:"INSECURE"`
+		// SameSite is one of lax, strict or none
+		SameSite string `json:"sameSite" yaml:"sameSite" env:"SAME_SITE"`
+	}
+
+	RateLimitConfig struct {
+		MaxAttempts int64    `json:"maxAttempts" yaml:"maxAttempts" env:"MAX_ATTEMPTS"`
+		Window      Duration `json:"window" yaml:"window" env:"WINDOW"`
+		BaseLockout Duration `json:"baseLockout" yaml:"baseLockout" env:"BASE_LOCKOUT"`
+		MaxLockout  Duration `json:"maxLockout" yaml:"maxLockout" env:"MAX_LOCKOUT"`
+	}
+
+	RevocationConfig struct {
+		// RedisAddrs are the host:port of the redis nodes, a single address for a standalone server
+		RedisAddrs    []string `json:"redisAddrs" yaml:"redisAddrs" env:"REDIS_ADDRS"`
+		RedisPassword string   `json:"redisPassword" yaml:"redisPassword" env:"REDIS_PASSWORD" secret:"true"`
+		KeyPrefix     string   `json:"keyPrefix" yaml:"keyPrefix" env:"KEY_PREFIX"`
+	}
+
+	// SocialConfig enables the social logins, a nil provider is not configured
+	SocialConfig struct {
+		Google    *SocialProviderConfig `json:"google,omitempty" yaml:"google,omitempty" env:"GOOGLE"`
+		GitHub    *SocialProviderConfig `json:"github,omitempty" yaml:"github,omitempty" env:"GITHUB"`
+		Microsoft *SocialProviderConfig `json:"microsoft,omitempty" yaml:"microsoft,omitempty" env:"MICROSOFT"`
+	}
+
+	SocialProviderConfig struct {
+		ClientID     string `json:"clientId" yaml:"clientId" env:"CLIENT_ID"`
+		ClientSecret string `json:"clientSecret" yaml:"clientSecret" env:"CLIENT_SECRET" secret:"true"`
+		RedirectURL  string `json:"redirectUrl" yaml:"redirectUrl" env:"REDIRECT_URL"`
+		// Scopes replace the default scopes of the provider
+		Scopes []string `json:"scopes" yaml:"scopes" env:"SCOPES"`
+		// Tenant restricts the Microsoft accounts, common when empty
+		Tenant string `json:"tenant" yaml:"tenant" env:"TENANT"`
+	}
+
+	// ValidationError lists every problem found in the configuration
+	ValidationError struct {
+		Problems []string
+	}
+)
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+
+	// DefaultEnvPrefix prefixes the environment variables, e.g. TURBO_AUTH_JWT_SIGNING_KEY
+	DefaultEnvPrefix = "TURBO_AUTH"
+)
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (err *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(err.Problems, "; ")
+}
+
+// Load reads the file (format detected from the .json, .yaml or .yml extension), overrides it with the
+// DefaultEnvPrefix environment variables, then resolves the secrets (see SecretResolver), applies the defaults and
+// validates the result
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var format Format
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		format = FormatJSON
+	case ".yaml", ".yml":
+		format = FormatYAML
+	default:
+		return nil, fmt.Errorf("unsupported configuration file %s", path)
+	}
+	c, err := decode(data, format)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ApplyEnv(DefaultEnvPrefix); err != nil {
+		return nil, err
+	}
+	return c, c.finish(DefaultEnvPrefix)
+}
+
+// Parse decodes the configuration, unknown fields are rejected, then resolves the secrets, applies the defaults and
+// validates the result
+func Parse(data []byte, format Format) (*Config, error) {
+	c, err := decode(data, format)
+	if err != nil {
+		return nil, err
+	}
+	return c, c.finish(DefaultEnvPrefix)
+}
+
+// FromEnv builds the configuration from the environment variables alone, a section is configured when
+// at least one of its variables is set
+func FromEnv(prefix string) (*Config, error) {
+	c := &Config{}
+	if err := c.ApplyEnv(prefix); err != nil {
+		return nil, err
+	}
+	return c, c.finish(prefix)
+}
+
+func decode(data []byte, format Format) (*Config, error) {
+	c := &Config{}
+	switch format {
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(c); err != nil {
+			return nil, err
+		}
+	case FormatYAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unsupported configuration format " + string(format))
+	}
+	return c, nil
+}
+
+// finish resolves the secrets with the master key of the prefix, then applies the defaults and validates
+func (c *Config) finish(prefix string) error {
+	resolver, err := NewSecretResolverFromEnv(prefix)
+	if err != nil {
+		return err
+	}
+	if err := c.ResolveSecrets(resolver); err != nil {
+		return err
+	}
+	c.SetDefaults()
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
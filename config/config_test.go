@@ -0,0 +1,235 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSigningKey = "0123456789abcdef0123456789abcdef"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		format   Format
+		wantErr  bool
+		validate func(t *testing.T, c *Config)
+	}{
+		{
+			name:   "yaml",
+			format: FormatYAML,
+			data: `
+jwt:
+  signingKey: "` + testSigningKey + `"
+  bearerTokens: true
+  authTokenValidTime: 5m
+sessions:
+  sameSite: strict
+`,
+			validate: func(t *testing.T, c *Config) {
+				if c.Jwt.SigningMethod != "HS256" || time.Duration(c.Jwt.AuthTokenValidTime) != 5*time.Minute {
+					t.Errorf("jwt = %+v", c.Jwt)
+				}
+				if c.Sessions.CookieName == "" || c.RateLimit != nil {
+					t.Errorf("sessions = %+v, rateLimit = %+v", c.Sessions, c.RateLimit)
+				}
+			},
+		},
+		{
+			name:   "json",
+			format: FormatJSON,
+			data:   `{"rateLimit": {"maxAttempts": 3, "window": "1m"}}`,
+			validate: func(t *testing.T, c *Config) {
+				if c.RateLimit.MaxAttempts != 3 || time.Duration(c.RateLimit.Window) != time.Minute {
+					t.Errorf("rateLimit = %+v", c.RateLimit)
+				}
+			},
+		},
+		{
+			name:   "social",
+			format: FormatYAML,
+			data: `
+social:
+  github:
+    clientId: client
+    clientSecret: secret
+    redirectUrl: https://app.example.com/auth/github/callback
+  microsoft:
+    clientId: client
+    clientSecret: secret
+    redirectUrl: https://app.example.com/auth/microsoft/callback
+    tenant: organizations
+`,
+			validate: func(t *testing.T, c *Config) {
+				logins := c.Social.SocialLogins()
+				if len(logins) != 2 || logins["github"].Config.ClientID != "client" ||
+					!strings.Contains(logins["microsoft"].Config.AuthURL, "/organizations/") {
+					t.Errorf("social logins = %+v", logins)
+				}
+			},
+		},
+		{
+			name:    "incomplete social provider",
+			format:  FormatJSON,
+			data:    `{"social": {"google": {"clientId": "client"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			format:  FormatJSON,
+			data:    `{"jwt": {"signingKye": "typo"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "short signing key",
+			format:  FormatYAML,
+			data:    "jwt:\n  signingKey: short\n",
+			wantErr: true,
+		},
+		{
+			name:    "asymmetric signing method",
+			format:  FormatJSON,
+			data:    `{"jwt": {"signingKey": "` + testSigningKey + `", "signingMethod": "RS256"}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Parse([]byte(tt.data), tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.validate != nil {
+				tt.validate(t, c)
+			}
+		})
+	}
+}
+
+func TestValidate_reportsAllProblems(t *testing.T) {
+	c := &Config{
+		Jwt:       &JwtConfig{SigningMethod: "none"},
+		RateLimit: &RateLimitConfig{MaxAttempts: -1},
+	}
+	c.SetDefaults()
+	var validationErr *ValidationError
+	if err := c.Validate(); !errors.As(err, &validationErr) || len(validationErr.Problems) != 3 {
+		t.Errorf("Validate() error = %v, want 3 problems", err)
+	}
+}
+
+func TestLoad_envOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "auth.yaml")
+	if err := ioutil.WriteFile(path, []byte("jwt:\n  bearerTokens: true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("TURBO_AUTH_JWT_SIGNING_KEY", testSigningKey)
+	os.Setenv("TURBO_AUTH_SESSIONS_IDLE_TIMEOUT", "10m")
+	os.Setenv("TURBO_AUTH_REVOCATION_REDIS_ADDRS", "redis-1:6379, redis-2:6379")
+	defer os.Unsetenv("TURBO_AUTH_JWT_SIGNING_KEY")
+	defer os.Unsetenv("TURBO_AUTH_SESSIONS_IDLE_TIMEOUT")
+	defer os.Unsetenv("TURBO_AUTH_REVOCATION_REDIS_ADDRS")
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if c.Jwt.SigningKey != testSigningKey || !c.Jwt.BearerTokens {
+		t.Errorf("jwt = %+v", c.Jwt)
+	}
+	if c.Sessions == nil || time.Duration(c.Sessions.IdleTimeout) != 10*time.Minute {
+		t.Errorf("sessions = %+v", c.Sessions)
+	}
+	if c.Revocation == nil || len(c.Revocation.RedisAddrs) != 2 || c.Revocation.RedisAddrs[1] != "redis-2:6379" ||
+		c.Revocation.KeyPrefix == "" {
+		t.Errorf("revocation = %+v", c.Revocation)
+	}
+
+	authConfig := c.Jwt.JwtAuthConfig()
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	if _, err := authConfig.Authenticate(token); err != nil {
+		t.Errorf("Authenticate() error = %v", err)
+	}
+}
+
+func TestParse_secretReferences(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "client-secret")
+	if err := ioutil.WriteFile(secretFile, []byte("file_secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	masterKey, err := NewMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := ParseMasterKey(masterKey)
+	sealed, err := EncryptSecret(key, testSigningKey)
+	if err != nil {
+		t.Fatalf("EncryptSecret() error = %v", err)
+	}
+	os.Setenv("TEST_REDIS_PASSWORD", "env_secret")
+	defer os.Unsetenv("TEST_REDIS_PASSWORD")
+
+	tests := []struct {
+		name      string
+		masterKey string
+		data      string
+		wantErr   bool
+	}{
+		{
+			name:      "resolved references",
+			masterKey: masterKey,
+			data: `{
+  "jwt": {"signingKey": "` + sealed + `"},
+  "revocation": {"redisAddrs": ["redis:6379"], "redisPassword": "env://TEST_REDIS_PASSWORD"},
+  "social": {"github": {"clientId": "client", "clientSecret": "file://` + secretFile + `",
+    "redirectUrl": "https://app.example.com/auth/github/callback"}}
+}`,
+		},
+		{name: "missing master key", data: `{"jwt": {"signingKey": "` + sealed + `"}}`, wantErr: true},
+		{
+			name:      "wrong master key",
+			masterKey: base64.StdEncoding.EncodeToString(make([]byte, 32)),
+			data:      `{"jwt": {"signingKey": "` + sealed + `"}}`,
+			wantErr:   true,
+		},
+		{name: "unset variable", data: `{"revocation": {"redisPassword": "env://TEST_UNSET_VARIABLE"}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.masterKey != "" {
+				os.Setenv("TURBO_AUTH_MASTER_KEY", tt.masterKey)
+				defer os.Unsetenv("TURBO_AUTH_MASTER_KEY")
+			}
+			c, err := Parse([]byte(tt.data), FormatJSON)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if c.Jwt.SigningKey != testSigningKey || c.Revocation.RedisPassword != "env_secret" ||
+				c.Social.GitHub.ClientSecret != "file_secret" {
+				t.Errorf("jwt = %+v, revocation = %+v, github = %+v", c.Jwt, c.Revocation, c.Social.GitHub)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// ApplyEnv overrides the configuration with the environment variables named after the env tags, e.g.
+// PREFIX_JWT_SIGNING_KEY, a nil section is created when one of its variables is set
+func (c *Config) ApplyEnv(prefix string) error {
+	_, err := applyEnv(reflect.ValueOf(c).Elem(), prefix)
+	return err
+}
+
+func applyEnv(v reflect.Value, prefix string) (bool, error) {
+	var set bool
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("env")
+		if tag == "" {
+			continue
+		}
+		name := prefix + "_" + tag
+		field := v.Field(i)
+		if field.Kind() == reflect.Ptr {
+			section := field
+			if field.IsNil() {
+				section = reflect.New(field.Type().Elem())
+			}
+			sectionSet, err := applyEnv(section.Elem(), name)
+			if err != nil {
+				return false, err
+			}
+			if sectionSet && field.IsNil() {
+				field.Set(section)
+			}
+			set = set || sectionSet
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return false, fmt.Errorf("%s: %v", name, err)
+		}
+		set = true
+	}
+	return set, nil
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", field.Type())
+		}
+		// comma separated, e.g. TURBO_AUTH_REVOCATION_REDIS_ADDRS=redis-1:6379,redis-2:6379
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
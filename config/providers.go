@@ -0,0 +1,106 @@
+package config
+
+import (
+	"github.com/go-redis/redis/v8"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"github.com/nandlabs/turbo-auth/providers/oauth"
+	"github.com/nandlabs/turbo-auth/ratelimit"
+	"github.com/nandlabs/turbo-auth/sessions"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JwtAuthConfig builds the jwt authenticator, the options are applied on top of the configuration
+func (c *JwtConfig) JwtAuthConfig(opts ...jwt.Option) *jwt.JwtAuthConfig {
+	return jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{
+		SigningKey:            c.SigningKey,
+		SigningMethod:         c.SigningMethod,
+		BearerTokens:          c.BearerTokens,
+		AuthTokenValidTime:    time.Duration(c.AuthTokenValidTime),
+		RefreshTokenValidTime: time.Duration(c.RefreshTokenValidTime),
+		AuthTokenName:         c.AuthTokenName,
+		RefreshTokenName:      c.RefreshTokenName,
+		SlidingWindow:         time.Duration(c.SlidingWindow),
+		Leeway:                time.Duration(c.Leeway),
+	}, opts...)
+}
+
+// KeyManager builds the key manager of a file:// signing key, it watches the file, besides reloading it every
+// ReloadInterval, and swaps the key when the content changes, e.g. a secret rotated by cert-manager. The
+// authenticator is built with JwtAuthConfig(jwt.WithKeyStore(manager.KeyStore)) once the manager is started, nil is
+// returned when the signing key is not a file reference
+func (c *JwtConfig) KeyManager() *jwt.KeyManager {
+	if c.signingKeyFile == "" {
+		return nil
+	}
+	source := jwt.NewFileKeySource(c.signingKeyFile, c.SigningMethod)
+	source.KeyIDSecret = c.KeyIDSecret
+	return jwt.NewKeyManager(&jwt.KeyRing{}, source, time.Duration(c.ReloadInterval))
+}
+
+// Revoker builds the redis revoker over a client connected to the configured nodes
+func (c *RevocationConfig) Revoker() *jwt.RedisRevoker {
+	revoker := jwt.NewRedisRevoker(redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:    c.RedisAddrs,
+		Password: c.RedisPassword,
+	}))
+	revoker.KeyPrefix = c.KeyPrefix
+	return revoker
+}
+
+// SessionManager builds the session manager over the store, sessions.NewMemoryStore when nil
+func (c *SessionsConfig) SessionManager(store sessions.SessionStore) *sessions.SessionManager {
+	manager := sessions.NewSessionManager(store)
+	manager.CookieName = c.CookieName
+	manager.IdleTimeout = time.Duration(c.IdleTimeout)
+	manager.AbsoluteTimeout = time.Duration(c.AbsoluteTimeout)
+	manager.Insecure = c.Insecure
+	switch strings.ToLower(c.SameSite) {
+	case "strict":
+		manager.SameSite = http.SameSiteStrictMode
+	case "none":
+		manager.SameSite = http.SameSiteNoneMode
+	default:
+		manager.SameSite = http.SameSiteLaxMode
+	}
+	return manager
+}
+
+// Limiter builds the lockout limiter over the store, ratelimit.NewMemoryStore when nil
+func (c *RateLimitConfig) Limiter(store ratelimit.CounterStore) *ratelimit.Limiter {
+	limiter := ratelimit.NewLimiter(store)
+	limiter.MaxAttempts = c.MaxAttempts
+	limiter.Window = time.Duration(c.Window)
+	limiter.BaseLockout = time.Duration(c.BaseLockout)
+	limiter.MaxLockout = time.Duration(c.MaxLockout)
+	return limiter
+}
+
+// SocialLogins builds the social logins of the configured providers by preset name, the Issuer or SessionManager
+// of each login remains to be set
+func (c *SocialConfig) SocialLogins() map[string]*oauth.SocialLogin {
+	logins := make(map[string]*oauth.SocialLogin)
+	for _, provider := range []struct {
+		preset oauth.Preset
+		config *SocialProviderConfig
+	}{
+		{oauth.Google, c.Google},
+		{oauth.GitHub, c.GitHub},
+		{oauth.Microsoft, c.Microsoft},
+	} {
+		if provider.config == nil {
+			continue
+		}
+		preset := provider.preset
+		if provider.config.Tenant != "" && preset.Name == oauth.Microsoft.Name {
+			preset = oauth.MicrosoftTenant(provider.config.Tenant)
+		}
+		login := oauth.NewSocialLogin(preset, provider.config.ClientID, provider.config.ClientSecret, provider.config.RedirectURL)
+		if len(provider.config.Scopes) > 0 {
+			login.Config.Scopes = provider.config.Scopes
+		}
+		logins[preset.Name] = login
+	}
+	return logins
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// SecretResolver resolves the references of the fields tagged secret:"true": env://NAME is the value of the
+// environment variable, file:///path the content of the file (e.g. a mounted kubernetes secret) and enc:v1:<base64>
+// a value sealed by EncryptSecret under the MasterKey with AES-256-GCM. Other values are kept as is
+type SecretResolver struct {
+	// MasterKey is the 32 bytes key of the enc:v1: values, they are rejected when nil
+	MasterKey []byte
+}
+
+const (
+	secretEnvScheme  = "env://"
+	secretFileScheme = "file://"
+	secretEncPrefix  = "enc:v1:"
+	masterKeySize    = 32
+)
+
+var ErrNoMasterKey = errors.New("encrypted secret without a master key, set " + DefaultEnvPrefix + "_MASTER_KEY")
+
+// NewSecretResolverFromEnv reads the base64 master key of PREFIX_MASTER_KEY, or of the file named by
+// PREFIX_MASTER_KEY_FILE, the resolver has no master key when neither is set
+func NewSecretResolverFromEnv(prefix string) (*SecretResolver, error) {
+	encoded := os.Getenv(prefix + "_MASTER_KEY")
+	if path := os.Getenv(prefix + "_MASTER_KEY_FILE"); encoded == "" && path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(content)
+	}
+	if encoded == "" {
+		return &SecretResolver{}, nil
+	}
+	masterKey, err := ParseMasterKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretResolver{MasterKey: masterKey}, nil
+}
+
+// ParseMasterKey decodes a base64 master key of 32 bytes
+func ParseMasterKey(encoded string) ([]byte, error) {
+	masterKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(masterKey) != masterKeySize {
+		return nil, fmt.Errorf("the master key must be %d base64 encoded bytes", masterKeySize)
+	}
+	return masterKey, nil
+}
+
+// NewMasterKey returns a random base64 master key
+func NewMasterKey() (string, error) {
+	masterKey := make([]byte, masterKeySize)
+	if _, err := rand.Read(masterKey); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(masterKey), nil
+}
+
+// EncryptSecret seals the value under the master key, the result is an enc:v1: reference
+func EncryptSecret(masterKey []byte, value string) (string, error) {
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(secretEncPrefix))
+	return secretEncPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Resolve returns the value of the reference, the values without a known scheme are returned as is
+func (r *SecretResolver) Resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretEnvScheme):
+		name := strings.TrimPrefix(value, secretEnvScheme)
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return resolved, nil
+	case strings.HasPrefix(value, secretFileScheme):
+		content, err := ioutil.ReadFile(strings.TrimPrefix(value, secretFileScheme))
+		if err != nil {
+			return "", err
+		}
+		// the editors and the secret mounts commonly end the files with a newline
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case strings.HasPrefix(value, secretEncPrefix):
+		if r == nil || r.MasterKey == nil {
+			return "", ErrNoMasterKey
+		}
+		aead, err := newAEAD(r.MasterKey)
+		if err != nil {
+			return "", err
+		}
+		sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretEncPrefix))
+		if err != nil || len(sealed) < aead.NonceSize() {
+			return "", errors.New("malformed encrypted secret")
+		}
+		plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(secretEncPrefix))
+		if err != nil {
+			return "", errors.New("the encrypted secret does not match the master key")
+		}
+		return string(plain), nil
+	default:
+		return value, nil
+	}
+}
+
+// ResolveSecrets replaces the references of the secret fields with their values, the file of a file:// signing key
+// is remembered for JwtConfig.KeyManager
+func (c *Config) ResolveSecrets(resolver *SecretResolver) error {
+	files := make(map[string]string)
+	if err := resolveSecrets(reflect.ValueOf(c).Elem(), resolver, "", files); err != nil {
+		return err
+	}
+	if c.Jwt != nil {
+		c.Jwt.signingKeyFile = files["Jwt.SigningKey"]
+	}
+	return nil
+}
+
+// resolveSecrets walks the nested sections, files collects the paths of the file:// references by field
+func resolveSecrets(v reflect.Value, resolver *SecretResolver, path string, files map[string]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, name := v.Field(i), path+t.Field(i).Name
+		switch {
+		case field.Kind() == reflect.Ptr && field.Elem().Kind() == reflect.Struct:
+			if err := resolveSecrets(field.Elem(), resolver, name+".", files); err != nil {
+				return err
+			}
+		case field.Kind() == reflect.String && t.Field(i).Tag.Get("secret") == "true":
+			reference := field.String()
+			value, err := resolver.Resolve(reference)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			if strings.HasPrefix(reference, secretFileScheme) {
+				files[name] = strings.TrimPrefix(reference, secretFileScheme)
+			}
+			field.SetString(value)
+		}
+	}
+	return nil
+}
+
+func newAEAD(masterKey []byte) (cipher.AEAD, error) {
+	if len(masterKey) != masterKeySize {
+		return nil, fmt.Errorf("the master key must be %d bytes", masterKeySize)
+	}
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
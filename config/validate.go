@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"github.com/nandlabs/turbo-auth/ratelimit"
+	"github.com/nandlabs/turbo-auth/sessions"
+	"strings"
+	"time"
+)
+
+const (
+	// minSigningKeyLength is the shortest HMAC secret accepted, 256 bits
+	minSigningKeyLength = 32
+
+	// DefaultReloadInterval is how often a file:// signing key is checked for changes, the kubelet takes up to a
+	// minute to update the mounted secrets
+	DefaultReloadInterval = time.Minute
+)
+
+// SetDefaults fills the zero values of the configured sections
+func (c *Config) SetDefaults() {
+	if jwt := c.Jwt; jwt != nil {
+		if jwt.SigningMethod == "" {
+			jwt.SigningMethod = "HS256"
+		}
+		if jwt.AuthTokenValidTime == 0 {
+			jwt.AuthTokenValidTime = Duration(turboAuth.DefaultAuthTokenValidTime)
+		}
+		if jwt.RefreshTokenValidTime == 0 {
+			jwt.RefreshTokenValidTime = Duration(turboAuth.DefaultRefreshTokenValidTime)
+		}
+		if jwt.ReloadInterval == 0 {
+			jwt.ReloadInterval = Duration(DefaultReloadInterval)
+		}
+	}
+	if s := c.Sessions; s != nil {
+		if s.CookieName == "" {
+			s.CookieName = sessions.DefaultSessionCookieName
+		}
+		if s.IdleTimeout == 0 {
+			s.IdleTimeout = Duration(sessions.DefaultIdleTimeout)
+		}
+		if s.AbsoluteTimeout == 0 {
+			s.AbsoluteTimeout = Duration(sessions.DefaultAbsoluteTimeout)
+		}
+		if s.SameSite == "" {
+			s.SameSite = "lax"
+		}
+	}
+	if rl := c.RateLimit; rl != nil {
+		if rl.MaxAttempts == 0 {
+			rl.MaxAttempts = ratelimit.DefaultMaxAttempts
+		}
+		if rl.Window == 0 {
+			rl.Window = Duration(ratelimit.DefaultWindow)
+		}
+		if rl.BaseLockout == 0 {
+			rl.BaseLockout = Duration(ratelimit.DefaultBaseLockout)
+		}
+		if rl.MaxLockout == 0 {
+			rl.MaxLockout = Duration(ratelimit.DefaultMaxLockout)
+		}
+	}
+	if rv := c.Revocation; rv != nil && rv.KeyPrefix == "" {
+		rv.KeyPrefix = jwt.DefaultRedisRevokerKeyPrefix
+	}
+}
+
+// Validate checks the configured sections, all the problems are reported at once in a *ValidationError
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if jwt := c.Jwt; jwt != nil {
+		switch jwt.SigningMethod {
+		case "HS256", "HS384", "HS512":
+		default:
+			add("jwt.signingMethod %q is not supported, use HS256, HS384 or HS512", jwt.SigningMethod)
+		}
+		if len(jwt.SigningKey) < minSigningKeyLength {
+			add("jwt.signingKey must be at least %d characters", minSigningKeyLength)
+		}
+		if jwt.AuthTokenValidTime < 0 {
+			add("jwt.authTokenValidTime must not be negative")
+		}
+		if jwt.RefreshTokenValidTime < jwt.AuthTokenValidTime {
+			add("jwt.refreshTokenValidTime must not be shorter than jwt.authTokenValidTime")
+		}
+		if jwt.SlidingWindow < 0 || jwt.SlidingWindow >= jwt.AuthTokenValidTime {
+			add("jwt.slidingWindow must be between 0 and jwt.authTokenValidTime")
+		}
+		if jwt.Leeway < 0 {
+			add("jwt.leeway must not be negative")
+		}
+		if jwt.ReloadInterval < 0 {
+			add("jwt.reloadInterval must not be negative")
+		}
+	}
+	if s := c.Sessions; s != nil {
+		if s.IdleTimeout < 0 || s.AbsoluteTimeout < 0 {
+			add("sessions timeouts must not be negative")
+		}
+		switch strings.ToLower(s.SameSite) {
+		case "lax", "strict", "none":
+		default:
+			add("sessions.sameSite %q must be one of lax, strict or none", s.SameSite)
+		}
+		if strings.EqualFold(s.SameSite, "none") && s.Insecure {
+			add("sessions.sameSite none requires secure cookies")
+		}
+	}
+	if rl := c.RateLimit; rl != nil {
+		if rl.MaxAttempts < 1 {
+			add("rateLimit.maxAttempts must be positive")
+		}
+		if rl.Window <= 0 || rl.BaseLockout <= 0 {
+			add("rateLimit.window and rateLimit.baseLockout must be positive")
+		}
+		if rl.MaxLockout < rl.BaseLockout {
+			add("rateLimit.maxLockout must not be shorter than rateLimit.baseLockout")
+		}
+	}
+	if rv := c.Revocation; rv != nil && len(rv.RedisAddrs) == 0 {
+		add("revocation.redisAddrs must not be empty")
+	}
+	if social := c.Social; social != nil {
+		for _, provider := range []struct {
+			name   string
+			config *SocialProviderConfig
+		}{
+			{"google", social.Google},
+			{"github", social.GitHub},
+			{"microsoft", social.Microsoft},
+		} {
+			if c := provider.config; c != nil && (c.ClientID == "" || c.ClientSecret == "" || c.RedirectURL == "") {
+				add("social.%s requires clientId, clientSecret and redirectUrl", provider.name)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
@@ -16,4 +16,7 @@ const (
 	DefaultRefreshAuthTokenHeader = "X-Refresh-Token"
 	DefaultCookieAuthTokenName    = "AuthToken"
 	DefaultCookieRefreshTokenName = "RefreshToken"
+	DefaultJWKSPath               = "/.well-known/jwks.json"
+	DefaultJWKSCacheControl       = "public, max-age=300"
+	DefaultMetadataPath           = "/.well-known/oauth-authorization-server"
 )
@@ -0,0 +1,130 @@
+package credentials
+
+import (
+	"errors"
+	"strings"
+)
+
+type (
+	// Hasher hashes passwords into a self describing encoded string
+	Hasher interface {
+		Hash(password string) (string, error)
+		// Verify compares the password with an encoded hash produced by this hasher
+		Verify(password string, encoded string) (bool, error)
+		// Supports reports whether the encoded hash was produced by this hasher's algorithm
+		Supports(encoded string) bool
+		// NeedsRehash reports whether the encoded hash uses weaker parameters than the hasher's
+		NeedsRehash(encoded string) bool
+	}
+
+	// PasswordHasher hashes with the preferred hasher and verifies the hashes of any of the configured hashers,
+	// so stored hashes can be upgraded transparently when the algorithm or its cost changes
+	PasswordHasher struct {
+		Preferred Hasher
+		Fallbacks []Hasher
+	}
+
+	// HashLookup returns the stored hash of the username
+	HashLookup func(username string) (string, error)
+
+	// HashUpdate persists the upgraded hash of the username
+	HashUpdate func(username string, hash string) error
+)
+
+var (
+	ErrUnsupportedHash = errors.New("unsupported password hash")
+	ErrInvalidHash     = errors.New("invalid password hash encoding")
+
+	// DefaultPasswordHasher hashes with argon2id and still verifies bcrypt hashes
+	DefaultPasswordHasher = &PasswordHasher{
+		Preferred: NewArgon2idHasher(DefaultArgon2idParams),
+		Fallbacks: []Hasher{NewBcryptHasher(DefaultBcryptCost)},
+	}
+)
+
+// Hash hashes the password with the DefaultPasswordHasher
+func Hash(password string) (string, error) {
+	return DefaultPasswordHasher.Hash(password)
+}
+
+// Verify checks the password against the encoded hash with the DefaultPasswordHasher
+func Verify(password string, encoded string) (bool, error) {
+	return DefaultPasswordHasher.Verify(password, encoded)
+}
+
+// NeedsRehash reports whether the encoded hash should be replaced according to the DefaultPasswordHasher
+func NeedsRehash(encoded string) bool {
+	return DefaultPasswordHasher.NeedsRehash(encoded)
+}
+
+func (p *PasswordHasher) Hash(password string) (string, error) {
+	return p.Preferred.Hash(password)
+}
+
+func (p *PasswordHasher) Verify(password string, encoded string) (bool, error) {
+	hasher, err := p.hasherFor(encoded)
+	if err != nil {
+		return false, err
+	}
+	return hasher.Verify(password, encoded)
+}
+
+// NeedsRehash is true when the hash was produced by a fallback hasher or with outdated parameters
+func (p *PasswordHasher) NeedsRehash(encoded string) bool {
+	if !p.Preferred.Supports(encoded) {
+		return true
+	}
+	return p.Preferred.NeedsRehash(encoded)
+}
+
+// VerifyAndUpgrade verifies the password and returns a new hash when the stored one needs a rehash,
+// the returned hash is empty when no upgrade is required
+func (p *PasswordHasher) VerifyAndUpgrade(password string, encoded string) (bool, string, error) {
+	valid, err := p.Verify(password, encoded)
+	if err != nil || !valid {
+		return false, "", err
+	}
+	if !p.NeedsRehash(encoded) {
+		return true, "", nil
+	}
+	upgraded, err := p.Hash(password)
+	if err != nil {
+		return true, "", err
+	}
+	return true, upgraded, nil
+}
+
+// Validator builds a basic auth validator verifying the stored hashes and upgrading them on successful logins
+func (p *PasswordHasher) Validator(lookup HashLookup, update HashUpdate) func(string, string) (bool, error) {
+	return func(username string, password string) (bool, error) {
+		encoded, err := lookup(username)
+		if err != nil {
+			return false, err
+		}
+		valid, upgraded, err := p.VerifyAndUpgrade(password, encoded)
+		if err != nil || !valid {
+			return false, err
+		}
+		if upgraded != "" && update != nil {
+			if err := update(username, upgraded); err != nil {
+				logger.ErrorF("unable to upgrade the password hash of %s: %v", username, err)
+			}
+		}
+		return true, nil
+	}
+}
+
+func (p *PasswordHasher) hasherFor(encoded string) (Hasher, error) {
+	if strings.TrimSpace(encoded) == "" {
+		return nil, ErrInvalidHash
+	}
+	if p.Preferred.Supports(encoded) {
+		return p.Preferred, nil
+	}
+	for _, hasher := range p.Fallbacks {
+		if hasher.Supports(encoded) {
+			return hasher, nil
+		}
+	}
+	return nil, ErrUnsupportedHash
+}
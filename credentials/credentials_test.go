@@ -0,0 +1,66 @@
+package credentials
+
+import (
+	"testing"
+)
+
+func TestPasswordHasher_VerifyAndUpgrade(t *testing.T) {
+	weakArgon := NewArgon2idHasher(Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	strongArgon := NewArgon2idHasher(Argon2idParams{Memory: 2048, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	bcryptHasher := NewBcryptHasher(4)
+	hasher := &PasswordHasher{
+		Preferred: strongArgon,
+		Fallbacks: []Hasher{bcryptHasher},
+	}
+
+	bcryptHash, _ := bcryptHasher.Hash("password")
+	weakHash, _ := weakArgon.Hash("password")
+	strongHash, _ := strongArgon.Hash("password")
+
+	tests := []struct {
+		name        string
+		password    string
+		encoded     string
+		wantValid   bool
+		wantUpgrade bool
+		wantErr     bool
+	}{
+		{name: "Test_bcrypt_upgraded", password: "password", encoded: bcryptHash, wantValid: true, wantUpgrade: true},
+		{name: "Test_weak_argon_upgraded", password: "password", encoded: weakHash, wantValid: true, wantUpgrade: true},
+		{name: "Test_current_argon", password: "password", encoded: strongHash, wantValid: true},
+		{name: "Test_wrong_password", password: "wrong", encoded: strongHash},
+		{name: "Test_unsupported_hash", password: "password", encoded: "$md5$abc", wantErr: true},
+		{name: "Test_zero_parallelism_hash", password: "password", encoded: "$argon2id$v=19$m=1024,t=1,p=0$c2FsdHNhbHQ$a2V5a2V5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, upgraded, err := hasher.VerifyAndUpgrade(tt.password, tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyAndUpgrade() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if valid != tt.wantValid {
+				t.Errorf("VerifyAndUpgrade() valid = %v, want %v", valid, tt.wantValid)
+			}
+			if (upgraded != "") != tt.wantUpgrade {
+				t.Errorf("VerifyAndUpgrade() upgraded = %v, want upgrade %v", upgraded, tt.wantUpgrade)
+			}
+			if upgraded != "" && hasher.NeedsRehash(upgraded) {
+				t.Errorf("NeedsRehash() of the upgraded hash = true")
+			}
+		})
+	}
+}
+
+func TestArgon2idHasher_defaults(t *testing.T) {
+	hasher := NewArgon2idHasher(Argon2idParams{Memory: 1024, Iterations: 1})
+	if hasher.Params.Parallelism != DefaultArgon2idParams.Parallelism || hasher.Params.KeyLength != DefaultArgon2idParams.KeyLength {
+		t.Errorf("Params = %+v, want the defaults for the zero values", hasher.Params)
+	}
+	encoded, err := (&Argon2idHasher{}).Hash("password")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if valid, err := hasher.Verify("password", encoded); err != nil || !valid {
+		t.Errorf("Verify() = %v, %v, want true", valid, err)
+	}
+}
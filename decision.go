@@ -0,0 +1,99 @@
+package turbo_auth
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+type (
+	// Decision is one step of the authentication and the authorization of a request
+	Decision struct {
+		// Stage is one of DecisionExtractor, DecisionKey or DecisionPolicy
+		Stage string
+		// Source names the component taking the decision, e.g. jwt or RequireScopes
+		Source  string
+		Allowed bool
+		Reason  string
+	}
+
+	// DecisionTrace collects the decisions taken on a request so that a rejection can be explained, e.g. which
+	// extractor found the token, which key verified it and which policy denied the request. It is safe for
+	// concurrent use
+	DecisionTrace struct {
+		mutex     sync.Mutex
+		decisions []Decision
+	}
+
+	decisionTraceKey struct{}
+)
+
+const (
+	DecisionExtractor = "extractor"
+	DecisionKey       = "key"
+	DecisionPolicy    = "policy"
+)
+
+// WithDecisionTrace returns a copy of the parent context carrying a new trace, the providers and the middlewares
+// record their decisions in it
+func WithDecisionTrace(ctx context.Context) (context.Context, *DecisionTrace) {
+	trace := &DecisionTrace{}
+	return context.WithValue(ctx, decisionTraceKey{}, trace), trace
+}
+
+// DecisionTraceFromContext returns the trace stored by WithDecisionTrace, if any
+func DecisionTraceFromContext(ctx context.Context) (*DecisionTrace, bool) {
+	trace, ok := ctx.Value(decisionTraceKey{}).(*DecisionTrace)
+	return trace, ok && trace != nil
+}
+
+// RecordDecision appends the decision to the trace of the context, it does nothing when the context has no trace
+func RecordDecision(ctx context.Context, decision Decision) {
+	if trace, ok := DecisionTraceFromContext(ctx); ok {
+		trace.Record(decision)
+	}
+}
+
+func (trace *DecisionTrace) Record(decision Decision) {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+	trace.decisions = append(trace.decisions, decision)
+}
+
+// Decisions returns the decisions in the order they were taken
+func (trace *DecisionTrace) Decisions() []Decision {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+	return append([]Decision(nil), trace.decisions...)
+}
+
+// Denied returns the first denying decision, nil when none was recorded
+func (trace *DecisionTrace) Denied() *Decision {
+	for _, decision := range trace.Decisions() {
+		if !decision.Allowed {
+			return &decision
+		}
+	}
+	return nil
+}
+
+// String renders the trace on a single line, e.g. for the logs
+func (trace *DecisionTrace) String() string {
+	var steps []string
+	for _, decision := range trace.Decisions() {
+		steps = append(steps, decision.String())
+	}
+	return strings.Join(steps, "; ")
+}
+
+func (decision Decision) String() string {
+	outcome := "allowed"
+	if !decision.Allowed {
+		outcome = "denied"
+	}
+	step := decision.Stage + " " + decision.Source + " " + outcome
+	if decision.Reason != "" {
+		step += ": " + decision.Reason
+	}
+	return step
+}
@@ -0,0 +1,90 @@
+package errors
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type (
+	// BearerWriter answers the failures with the RFC 6750 challenge of the Bearer scheme: the WWW-Authenticate
+	// header carries the realm, the error code, its description and the required scope on 400, 401 and 403. The body
+	// is written by Next, the plain text HttpError is used when nil
+	BearerWriter struct {
+		Realm string
+		Next  ErrorWriter
+	}
+)
+
+// RFC 6750 error codes of the Bearer challenges
+const (
+	BearerInvalidRequest    = "invalid_request"
+	BearerInvalidToken      = "invalid_token"
+	BearerInsufficientScope = "insufficient_scope"
+	// BearerInsufficientUserAuthentication asks for a step-up authentication (RFC 9470)
+	BearerInsufficientUserAuthentication = "insufficient_user_authentication"
+)
+
+var ErrInsufficientScope = errors.New("insufficient scope for the request")
+
+func NewBearerWriter(realm string) *BearerWriter {
+	return &BearerWriter{Realm: realm}
+}
+
+func (b *BearerWriter) WriteError(w http.ResponseWriter, r *http.Request, httpError *HttpError) {
+	if challenge := BearerChallenge(b.Realm, httpError); challenge != "" {
+		w.Header().Set(HeaderWWWAuthenticate, challenge)
+	}
+	WriteError(b.Next, w, r, httpError)
+}
+
+// BearerChallenge builds the WWW-Authenticate value of the failure, empty for the status codes without a challenge.
+// A request without a token is only told the realm, as RFC 6750 section 3.1 recommends
+func BearerChallenge(realm string, httpError *HttpError) string {
+	var code string
+	switch httpError.StatusCode {
+	case http.StatusBadRequest:
+		code = BearerInvalidRequest
+	case http.StatusUnauthorized:
+		if httpError.Err != nil && !errors.Is(httpError.Err, ErrMissingToken) {
+			code = BearerInvalidToken
+		}
+	case http.StatusForbidden:
+		code = BearerInsufficientScope
+	default:
+		return ""
+	}
+	var params []string
+	if realm != "" {
+		params = append(params, "realm="+quoteParam(realm))
+	}
+	if code != "" {
+		params = append(params, "error="+quoteParam(code))
+		description := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(httpError.Message), "Error :"))
+		if httpError.Err != nil {
+			description = httpError.Err.Error()
+		}
+		if description != "" {
+			params = append(params, "error_description="+quoteParam(description))
+		}
+	}
+	if code == BearerInsufficientScope && httpError.Scope != "" {
+		params = append(params, "scope="+quoteParam(httpError.Scope))
+	}
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// quoteParam quotes the value, the characters outside of the ones RFC 6750 allows in the attributes are dropped
+func quoteParam(value string) string {
+	cleaned := strings.Map(func(c rune) rune {
+		if c < 0x20 || c > 0x7e || c == '"' || c == '\\' {
+			return -1
+		}
+		return c
+	}, value)
+	return strconv.Quote(cleaned)
+}
@@ -1,7 +1,8 @@
 package errors
 
 import (
-	"go.nandlabs.io/l3"
+	"fmt"
+	"github.com/nandlabs/turbo-auth/logging"
 	"net/http"
 	"reflect"
 )
@@ -10,6 +11,10 @@ type (
 	HttpError struct {
 		StatusCode int
 		Message    string
+		// Err is the cause of the failure when known, it becomes the error_description of the Bearer challenges
+		Err error
+		// Scope lists the scopes required by the resource on an insufficient scope failure
+		Scope string
 	}
 
 	JwtError struct {
@@ -19,7 +24,7 @@ type (
 )
 
 var (
-	logger = l3.Get()
+	logger = logging.Get()
 )
 
 func (httpError *HttpError) GenerateError(w http.ResponseWriter, r *http.Request) {
@@ -41,8 +46,12 @@ func NewJwtError(err interface{}, errCode int) *JwtError {
 	if err == nil {
 		return nil
 	}
+	e, ok := err.(error)
+	if !ok {
+		e = fmt.Errorf("%v", err)
+	}
 	return &JwtError{
-		Err:  err.(error),
+		Err:  e,
 		Code: errCode,
 	}
 }
@@ -53,3 +62,8 @@ func (err JwtError) Error() string {
 	}
 	return "Unknown Error Occurred"
 }
+
+// Unwrap exposes the cause so that errors.Is and errors.As see through the JwtError
+func (err JwtError) Unwrap() error {
+	return err.Err
+}
@@ -0,0 +1,55 @@
+package errors
+
+import "errors"
+
+// Sentinel errors classifying the authentication failures, use errors.Is to branch on them
+var (
+	ErrMissingToken      = errors.New("empty auth token")
+	ErrTokenMalformed    = errors.New("token is malformed")
+	ErrSignatureInvalid  = errors.New("token signature is invalid")
+	ErrTokenUnverifiable = errors.New("token cannot be verified")
+	ErrTokenExpired      = errors.New("token has expired")
+	ErrTokenNotValidYet  = errors.New("token is not valid yet")
+	ErrTokenRevoked      = errors.New("token has been revoked")
+	ErrTokenInvalid      = errors.New("invalid token passed")
+)
+
+type kindError struct {
+	kind  error
+	cause error
+}
+
+// Wrap tags the cause with one of the sentinel errors, errors.Is(err, kind) holds while
+// the message and the chain of the cause are preserved
+func Wrap(kind error, cause error) error {
+	if cause == nil {
+		return nil
+	}
+	return &kindError{
+		kind:  kind,
+		cause: cause,
+	}
+}
+
+func (err *kindError) Error() string {
+	return err.cause.Error()
+}
+
+func (err *kindError) Is(target error) bool {
+	return target == err.kind
+}
+
+func (err *kindError) Unwrap() error {
+	return err.cause
+}
+
+// Kind returns the sentinel error the err was classified with, nil when it is none of them
+func Kind(err error) error {
+	for _, kind := range []error{ErrMissingToken, ErrTokenMalformed, ErrSignatureInvalid, ErrTokenUnverifiable,
+		ErrTokenExpired, ErrTokenNotValidYet, ErrTokenRevoked, ErrTokenInvalid} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return nil
+}
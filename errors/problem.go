@@ -0,0 +1,99 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	ProblemContentType    = "application/problem+json"
+	HeaderWWWAuthenticate = "WWW-Authenticate"
+)
+
+type (
+	// ErrorWriter renders the failures of an authenticator, the plain text HttpError is used when none is configured
+	ErrorWriter interface {
+		WriteError(w http.ResponseWriter, r *http.Request, httpError *HttpError)
+	}
+
+	// ErrorWriterFunc adapts a function to the ErrorWriter interface
+	ErrorWriterFunc func(w http.ResponseWriter, r *http.Request, httpError *HttpError)
+
+	// ProblemDetails is the RFC 7807 body of an error response
+	ProblemDetails struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail,omitempty"`
+		Instance string `json:"instance,omitempty"`
+	}
+
+	// ProblemWriter emits application/problem+json bodies along with a WWW-Authenticate challenge on 401, the Bearer
+	// scheme is challenged on 400 and 403 as well, see BearerChallenge
+	ProblemWriter struct {
+		// TypeBaseURI prefixes the status code to build the problem type, about:blank is used when empty
+		TypeBaseURI string
+		// Scheme of the WWW-Authenticate challenge, e.g. Bearer or Basic
+		Scheme string
+		Realm  string
+	}
+)
+
+func (f ErrorWriterFunc) WriteError(w http.ResponseWriter, r *http.Request, httpError *HttpError) {
+	f(w, r, httpError)
+}
+
+func NewProblemWriter(scheme string, realm string) *ProblemWriter {
+	return &ProblemWriter{
+		Scheme: scheme,
+		Realm:  realm,
+	}
+}
+
+func (p *ProblemWriter) WriteError(w http.ResponseWriter, r *http.Request, httpError *HttpError) {
+	logger.ErrorF("Error occurred at endpoint: %s", r.URL.Path)
+	logger.ErrorF("Error Message: %s", httpError.Message)
+	problem := ProblemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(httpError.StatusCode),
+		Status:   httpError.StatusCode,
+		Detail:   strings.TrimSpace(httpError.Message),
+		Instance: r.URL.Path,
+	}
+	if p.TypeBaseURI != "" {
+		problem.Type = fmt.Sprintf("%s/%d", strings.TrimSuffix(p.TypeBaseURI, "/"), httpError.StatusCode)
+	}
+	if challenge := p.challenge(httpError); challenge != "" {
+		w.Header().Set(HeaderWWWAuthenticate, challenge)
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(httpError.StatusCode)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		logger.ErrorF("Error writing problem details: %v", err)
+	}
+}
+
+// challenge is the RFC 6750 challenge for the Bearer scheme, the other schemes are only challenged on 401
+func (p *ProblemWriter) challenge(httpError *HttpError) string {
+	switch {
+	case strings.EqualFold(p.Scheme, "Bearer"):
+		return BearerChallenge(p.Realm, httpError)
+	case httpError.StatusCode != http.StatusUnauthorized || p.Scheme == "":
+		return ""
+	case p.Realm == "":
+		return p.Scheme
+	default:
+		return fmt.Sprintf("%s realm=%q", p.Scheme, p.Realm)
+	}
+}
+
+// WriteError renders the error through the writer or falls back to GenerateError when writer is nil
+func WriteError(writer ErrorWriter, w http.ResponseWriter, r *http.Request, httpError *HttpError) {
+	if writer == nil {
+		httpError.GenerateError(w, r)
+		return
+	}
+	writer.WriteError(w, r, httpError)
+}
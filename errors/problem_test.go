@@ -0,0 +1,109 @@
+package errors
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		realm     string
+		httpError *HttpError
+		want      string
+	}{
+		{
+			name:      "Test_missing_token",
+			realm:     "api",
+			httpError: &HttpError{StatusCode: http.StatusUnauthorized, Err: ErrMissingToken},
+			want:      `Bearer realm="api"`,
+		},
+		{
+			name:      "Test_invalid_token",
+			realm:     "api",
+			httpError: &HttpError{StatusCode: http.StatusUnauthorized, Message: "Error : invalid \n", Err: ErrTokenExpired},
+			want:      `Bearer realm="api", error="invalid_token", error_description="token has expired"`,
+		},
+		{
+			name:      "Test_invalid_request",
+			httpError: &HttpError{StatusCode: http.StatusBadRequest, Message: "Error : \"token\" is required \n"},
+			want:      `Bearer error="invalid_request", error_description="token is required"`,
+		},
+		{
+			name:      "Test_insufficient_scope",
+			realm:     "api",
+			httpError: &HttpError{StatusCode: http.StatusForbidden, Err: ErrInsufficientScope, Scope: "orders:read orders:write"},
+			want: `Bearer realm="api", error="insufficient_scope", error_description="insufficient scope for the request", ` +
+				`scope="orders:read orders:write"`,
+		},
+		{name: "Test_no_challenge", httpError: &HttpError{StatusCode: http.StatusTooManyRequests}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BearerChallenge(tt.realm, tt.httpError); got != tt.want {
+				t.Errorf("BearerChallenge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProblemWriter_WriteError(t *testing.T) {
+	tests := []struct {
+		name      string
+		writer    *ProblemWriter
+		status    int
+		wantType  string
+		challenge string
+	}{
+		{
+			name:      "Test_unauthorized_challenge",
+			writer:    NewProblemWriter("Bearer", "api"),
+			status:    http.StatusUnauthorized,
+			wantType:  "about:blank",
+			challenge: `Bearer realm="api"`,
+		},
+		{
+			name:      "Test_forbidden_type_uri",
+			writer:    &ProblemWriter{TypeBaseURI: "https://errors.example.com/auth/", Scheme: "Bearer"},
+			status:    http.StatusForbidden,
+			wantType:  "https://errors.example.com/auth/403",
+			challenge: `Bearer error="insufficient_scope", error_description="token is expired"`,
+		},
+		{
+			name:     "Test_basic_forbidden",
+			writer:   NewProblemWriter("Basic", "api"),
+			status:   http.StatusForbidden,
+			wantType: "about:blank",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			tt.writer.WriteError(w, r, &HttpError{StatusCode: tt.status, Message: "token is expired \n"})
+
+			if got := w.Header().Get("Content-Type"); got != ProblemContentType {
+				t.Errorf("Content-Type = %v, want %v", got, ProblemContentType)
+			}
+			if got := w.Header().Get(HeaderWWWAuthenticate); got != tt.challenge {
+				t.Errorf("WWW-Authenticate = %v, want %v", got, tt.challenge)
+			}
+			var problem ProblemDetails
+			if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+			want := ProblemDetails{
+				Type:     tt.wantType,
+				Title:    http.StatusText(tt.status),
+				Status:   tt.status,
+				Detail:   "token is expired",
+				Instance: "/orders",
+			}
+			if problem != want {
+				t.Errorf("WriteError() = %+v, want %+v", problem, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package extractor
+
+import (
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"net/http"
+	"strings"
+)
+
+type (
+	// Extractor pulls the raw token out of the request, an empty token with a nil error means no token was sent
+	Extractor interface {
+		Extract(r *http.Request) (string, error)
+	}
+
+	ExtractorFunc func(r *http.Request) (string, error)
+)
+
+var (
+	ErrMalformedAuthorization = errors.New("authorization header is not a bearer token")
+)
+
+func (fn ExtractorFunc) Extract(r *http.Request) (string, error) {
+	return fn(r)
+}
+
+// AuthorizationBearer reads the token of the "Authorization: Bearer <token>" header, other schemes are rejected
+func AuthorizationBearer() Extractor {
+	return ExtractorFunc(func(r *http.Request) (string, error) {
+		auth := r.Header.Get(turboAuth.HeaderAuthorization)
+		if auth == "" {
+			return "", nil
+		}
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+			return strings.TrimSpace(auth[7:]), nil
+		}
+		return "", ErrMalformedAuthorization
+	})
+}
+
+// Header reads the whole value of the header
+func Header(name string) Extractor {
+	return ExtractorFunc(func(r *http.Request) (string, error) {
+		return r.Header.Get(name), nil
+	})
+}
+
+// Cookie reads the value of the cookie
+func Cookie(name string) Extractor {
+	return ExtractorFunc(func(r *http.Request) (string, error) {
+		cookie, err := r.Cookie(name)
+		if err == http.ErrNoCookie {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return cookie.Value, nil
+	})
+}
+
+// QueryParam reads the query parameter, tokens in urls end up in access logs, see websocket.Tickets instead
+func QueryParam(name string) Extractor {
+	return ExtractorFunc(func(r *http.Request) (string, error) {
+		return r.URL.Query().Get(name), nil
+	})
+}
+
+// Chain returns the first token found by the extractors, in order. An error stops the chain
+func Chain(extractors ...Extractor) Extractor {
+	return ExtractorFunc(func(r *http.Request) (string, error) {
+		for _, extractor := range extractors {
+			token, err := extractor.Extract(r)
+			if err != nil || token != "" {
+				return token, err
+			}
+		}
+		return "", nil
+	})
+}
@@ -0,0 +1,42 @@
+package extractor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	extractor := Chain(AuthorizationBearer(), Header("X-Auth-Token"), Cookie("AuthToken"), QueryParam("access_token"))
+	tests := []struct {
+		name    string
+		request func(r *http.Request)
+		want    string
+		wantErr bool
+	}{
+		{name: "Test_bearer", request: func(r *http.Request) { r.Header.Set("Authorization", "Bearer token-1") }, want: "token-1"},
+		{name: "Test_bearer_case_insensitive", request: func(r *http.Request) { r.Header.Set("Authorization", "bearer token-1") }, want: "token-1"},
+		{name: "Test_other_scheme", request: func(r *http.Request) { r.Header.Set("Authorization", "Basic dXNlcjpwYXNz") }, wantErr: true},
+		{name: "Test_header", request: func(r *http.Request) { r.Header.Set("X-Auth-Token", "token-2") }, want: "token-2"},
+		{name: "Test_cookie", request: func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "AuthToken", Value: "token-3"}) }, want: "token-3"},
+		{name: "Test_query", request: func(r *http.Request) { r.URL.RawQuery = "access_token=token-4" }, want: "token-4"},
+		{name: "Test_header_before_query", request: func(r *http.Request) {
+			r.Header.Set("X-Auth-Token", "token-2")
+			r.URL.RawQuery = "access_token=token-4"
+		}, want: "token-2"},
+		{name: "Test_none", request: func(r *http.Request) {}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			tt.request(r)
+			got, err := extractor.Extract(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Extract() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
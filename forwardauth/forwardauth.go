@@ -0,0 +1,124 @@
+package forwardauth
+
+import (
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/identityheaders"
+	"github.com/nandlabs/turbo-auth/logging"
+	"github.com/nandlabs/turbo-auth/middleware"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// the headers of the Traefik ForwardAuth middleware
+	HeaderForwardedMethod = "X-Forwarded-Method"
+	HeaderForwardedProto  = "X-Forwarded-Proto"
+	HeaderForwardedHost   = "X-Forwarded-Host"
+	HeaderForwardedURI    = "X-Forwarded-Uri"
+	// the headers conventionally set along with the nginx auth_request directive
+	HeaderOriginalMethod = "X-Original-Method"
+	HeaderOriginalURI    = "X-Original-URI"
+
+	DefaultHeaderPrefix = identityheaders.DefaultPrefix
+)
+
+var logger = logging.Get()
+
+type (
+	// Handler runs the middlewares against the original request described by the headers of the proxy, it answers
+	// 200 with the identity headers when the request is let through and the response of the middleware otherwise,
+	// e.g. 401 or 403. The headers are trusted, the handler must only be reachable by the proxy
+	Handler struct {
+		Middleware middleware.Middleware
+		// HeaderPrefix prefixes the identity headers, DefaultHeaderPrefix when empty. The proxy copies them to the
+		// upstream request, e.g. authResponseHeaders of Traefik or auth_request_set of nginx
+		HeaderPrefix string
+		// Injector sets the identity headers, e.g. to add the claims or to sign them for the upstreams, unsigned
+		// headers of the HeaderPrefix are set when nil
+		Injector *identityheaders.Injector
+	}
+
+	// statusWriter maps the client errors to the statuses understood by the proxies
+	statusWriter struct {
+		http.ResponseWriter
+	}
+)
+
+// NewHandler chains the middlewares, e.g. middleware.Authenticate(authenticator) followed by
+// middleware.RequireRole("admin"), or middleware.Routes(authenticator, matcher) for per route policies
+func NewHandler(middlewares ...middleware.Middleware) *Handler {
+	return &Handler{
+		Middleware:   middleware.Chain(middlewares...),
+		HeaderPrefix: DefaultHeaderPrefix,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Middleware(http.HandlerFunc(h.allow)).ServeHTTP(&statusWriter{ResponseWriter: w}, OriginalRequest(r))
+}
+
+// WriteHeader answers 401 for the client errors other than 403, e.g. the 400 of a missing token, nginx only accepts
+// 2xx, 401 and 403 from the auth_request
+func (w *statusWriter) WriteHeader(status int) {
+	if status >= 400 && status < 500 && status != http.StatusForbidden {
+		status = http.StatusUnauthorized
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// allow answers the requests let through by the middlewares with their identity
+func (h *Handler) allow(w http.ResponseWriter, r *http.Request) {
+	injector := h.Injector
+	if injector == nil {
+		injector = &identityheaders.Injector{Prefix: h.HeaderPrefix}
+	}
+	identity, _ := turboAuth.IdentityFromContext(r.Context())
+	if err := injector.Inject(w.Header(), identity); err != nil {
+		logger.ErrorF("failed to inject the identity headers: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// OriginalRequest rebuilds the request of the client from the headers of the proxy, the credentials (headers and
+// cookies) are those of the request of the proxy. The request is returned as is when the headers are absent
+func OriginalRequest(r *http.Request) *http.Request {
+	method := firstHeader(r, HeaderForwardedMethod, HeaderOriginalMethod)
+	uri := firstHeader(r, HeaderForwardedURI, HeaderOriginalURI)
+	if method == "" && uri == "" {
+		return r
+	}
+	original := r.Clone(r.Context())
+	if method != "" {
+		original.Method = strings.ToUpper(method)
+	}
+	if uri != "" {
+		if parsed, err := url.ParseRequestURI(uri); err == nil {
+			if parsed.Host != "" {
+				original.Host = parsed.Host
+			}
+			original.URL = parsed
+			original.RequestURI = parsed.RequestURI()
+		}
+	}
+	if host := r.Header.Get(HeaderForwardedHost); host != "" {
+		original.Host = host
+	}
+	original.URL.Host = original.Host
+	if proto := r.Header.Get(HeaderForwardedProto); proto != "" {
+		original.URL.Scheme = proto
+	}
+	return original
+}
+
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if value := r.Header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package forwardauth
+
+import (
+	"github.com/nandlabs/turbo-auth/middleware"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	authConfig := jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+	admin, jwtErr := authConfig.IssueTokenPair("admin_user", []string{"admin"})
+	if jwtErr != nil {
+		t.Fatalf("IssueTokenPair() error = %v", jwtErr)
+	}
+	user, jwtErr := authConfig.IssueTokenPair("test_user", []string{"user"})
+	if jwtErr != nil {
+		t.Fatalf("IssueTokenPair() error = %v", jwtErr)
+	}
+	matcher := middleware.NewRouteMatcher(middleware.PublicRoute("/public/*"))
+	handler := NewHandler(middleware.Routes(authConfig, matcher), middleware.SkipPaths(middleware.RequireRole("admin"), "/public/*"))
+
+	tests := []struct {
+		name        string
+		token       string
+		headers     map[string]string
+		want        int
+		wantSubject string
+	}{
+		{name: "Test_admin", token: admin.AuthToken, headers: map[string]string{HeaderForwardedURI: "/admin"},
+			want: http.StatusOK, wantSubject: "admin_user"},
+		{name: "Test_missing_token", headers: map[string]string{HeaderForwardedURI: "/admin"},
+			want: http.StatusUnauthorized},
+		{name: "Test_missing_role", token: user.AuthToken, headers: map[string]string{HeaderForwardedURI: "/admin"},
+			want: http.StatusForbidden},
+		{name: "Test_public_route", headers: map[string]string{HeaderForwardedURI: "/public/index.html"},
+			want: http.StatusOK},
+		{name: "Test_nginx_public_route", headers: map[string]string{HeaderOriginalURI: "/public/index.html",
+			HeaderOriginalMethod: "GET"}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/auth", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if tt.token != "" {
+				r.Header.Set(authConfig.AuthTokenName, tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want || w.Header().Get(DefaultHeaderPrefix+"Subject") != tt.wantSubject {
+				t.Errorf("status = %v, subject = %q, want %v, %q", w.Code, w.Header().Get(DefaultHeaderPrefix+"Subject"),
+					tt.want, tt.wantSubject)
+			}
+		})
+	}
+}
+
+func TestOriginalRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/auth", nil)
+	r.Header.Set(HeaderForwardedMethod, "post")
+	r.Header.Set(HeaderForwardedProto, "https")
+	r.Header.Set(HeaderForwardedHost, "app.example.com")
+	r.Header.Set(HeaderForwardedURI, "/orders?id=1")
+	original := OriginalRequest(r)
+	if original.Method != http.MethodPost || original.URL.String() != "https://app.example.com/orders?id=1" ||
+		original.Host != "app.example.com" {
+		t.Errorf("OriginalRequest() = %v %v (host %v)", original.Method, original.URL, original.Host)
+	}
+	if r.Method != http.MethodGet || r.URL.Path != "/auth" {
+		t.Errorf("OriginalRequest() modified the request of the proxy")
+	}
+}
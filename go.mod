@@ -3,7 +3,21 @@ module github.com/nandlabs/turbo-auth
 go 1.14
 
 require (
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/gin-gonic/gin v1.8.1
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/go-webauthn/webauthn v0.5.0
+	github.com/gofiber/fiber/v2 v2.38.1
 	github.com/golang-jwt/jwt/v4 v4.4.2
 	github.com/google/uuid v1.3.0
-	go.nandlabs.io/l3 v0.0.1
+	github.com/labstack/echo/v4 v4.9.0
+	github.com/prometheus/client_golang v1.13.0
+	github.com/valyala/fasthttp v1.40.0
+	go.opentelemetry.io/otel v1.11.1
+	go.opentelemetry.io/otel/trace v1.11.1
+	go.uber.org/zap v1.21.0
+	golang.org/x/crypto v0.1.0
+	google.golang.org/grpc v1.50.1
+	gopkg.in/square/go-jose.v2 v2.6.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,151 @@
+package health
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// Checker reports whether a dependency of the authentication is usable, e.g. the JWKS of a provider or the
+	// store of the revocations. The stores and verifiers of the library implement it
+	Checker interface {
+		Check(ctx context.Context) error
+	}
+
+	// CheckerFunc adapts a function to the Checker interface
+	CheckerFunc func(ctx context.Context) error
+
+	// Check is a named Checker, the failures of the Optional checks are reported without failing the readiness
+	Check struct {
+		Name     string
+		Checker  Checker
+		Optional bool
+	}
+
+	// Health runs the checks of the dependencies, its Handler is meant for the readiness probe of kubernetes so that
+	// no traffic is routed to an instance which cannot validate the tokens
+	Health struct {
+		Checks []Check
+		// Timeout bounds each check, DefaultTimeout when 0
+		Timeout time.Duration
+	}
+
+	// Report is the body of the Handler responses
+	Report struct {
+		Status Status                 `json:"status"`
+		Checks map[string]CheckResult `json:"checks"`
+	}
+
+	CheckResult struct {
+		Status   Status `json:"status"`
+		Error    string `json:"error,omitempty"`
+		Optional bool   `json:"optional,omitempty"`
+		Duration string `json:"duration"`
+	}
+
+	Status string
+)
+
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+
+	DefaultTimeout = 2 * time.Second
+)
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+func NewHealth() *Health {
+	return &Health{Timeout: DefaultTimeout}
+}
+
+// Add registers a check failing the readiness
+func (h *Health) Add(name string, checker Checker) *Health {
+	h.Checks = append(h.Checks, Check{Name: name, Checker: checker})
+	return h
+}
+
+// AddOptional registers a check only reported, e.g. a dependency the authentication fails open on
+func (h *Health) AddOptional(name string, checker Checker) *Health {
+	h.Checks = append(h.Checks, Check{Name: name, Checker: checker, Optional: true})
+	return h
+}
+
+// Run runs the checks concurrently, the status is down when a check which is not Optional fails
+func (h *Health) Run(ctx context.Context) *Report {
+	report := &Report{Status: StatusUp, Checks: make(map[string]CheckResult, len(h.Checks))}
+	results := make([]CheckResult, len(h.Checks))
+	var wg sync.WaitGroup
+	for i := range h.Checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = h.run(ctx, h.Checks[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, check := range h.Checks {
+		report.Checks[check.Name] = results[i]
+		if results[i].Status == StatusDown && !check.Optional {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+// Handler answers 200 with the Report when the status is up, 503 otherwise
+func (h *Health) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := h.Run(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status != StatusUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+func (h *Health) run(ctx context.Context, check Check) CheckResult {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	// the checks which do not honor the context still answer within the timeout
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Checker.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := CheckResult{Status: StatusUp, Optional: check.Optional, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status, result.Error = StatusDown, err.Error()
+	}
+	return result
+}
+
+// CertificateExpiry fails once the certificate expires within the duration, e.g. the certificate of the signing
+// key or of the mutual TLS, so that the renewal is noticed before the tokens are rejected
+func CertificateExpiry(cert *x509.Certificate, within time.Duration) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		if remaining := time.Until(cert.NotAfter); remaining < within {
+			return fmt.Errorf("certificate %s expires at %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+		}
+		return nil
+	})
+}
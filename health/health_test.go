@@ -0,0 +1,73 @@
+package health
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth_Handler(t *testing.T) {
+	up := CheckerFunc(func(ctx context.Context) error { return nil })
+	down := CheckerFunc(func(ctx context.Context) error { return errors.New("connection refused") })
+	hanging := CheckerFunc(func(ctx context.Context) error { select {} })
+
+	tests := []struct {
+		name       string
+		health     *Health
+		want       int
+		wantStatus map[string]Status
+	}{
+		{name: "Test_up", health: NewHealth().Add("jwks", up).Add("redis", up), want: http.StatusOK,
+			wantStatus: map[string]Status{"jwks": StatusUp, "redis": StatusUp}},
+		{name: "Test_required_down", health: NewHealth().Add("jwks", up).Add("redis", down),
+			want: http.StatusServiceUnavailable, wantStatus: map[string]Status{"jwks": StatusUp, "redis": StatusDown}},
+		{name: "Test_optional_down", health: NewHealth().Add("jwks", up).AddOptional("redis", down),
+			want: http.StatusOK, wantStatus: map[string]Status{"jwks": StatusUp, "redis": StatusDown}},
+		{name: "Test_timeout", health: &Health{Timeout: 10 * time.Millisecond, Checks: []Check{{Name: "sql",
+			Checker: hanging}}}, want: http.StatusServiceUnavailable, wantStatus: map[string]Status{"sql": StatusDown}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.health.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v", w.Code, tt.want)
+			}
+			var report Report
+			if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+				t.Fatalf("decoding the report: %v", err)
+			}
+			for name, status := range tt.wantStatus {
+				if report.Checks[name].Status != status {
+					t.Errorf("check %s = %+v, want %v", name, report.Checks[name], status)
+				}
+			}
+		})
+	}
+}
+
+func TestCertificateExpiry(t *testing.T) {
+	tests := []struct {
+		name     string
+		notAfter time.Time
+		wantErr  bool
+	}{
+		{name: "Test_valid", notAfter: time.Now().Add(30 * 24 * time.Hour)},
+		{name: "Test_expiring", notAfter: time.Now().Add(24 * time.Hour), wantErr: true},
+		{name: "Test_expired", notAfter: time.Now().Add(-time.Hour), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: "signing"}, NotAfter: tt.notAfter}
+			if err := CertificateExpiry(cert, 7*24*time.Hour).Check(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
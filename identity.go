@@ -0,0 +1,108 @@
+package turbo_auth
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type (
+	// Identity is the authenticated principal of a request, populated by the providers once a token is validated
+	Identity struct {
+		// Subject identifies the principal, the username for the jwt provider
+		Subject string
+		// TokenID is the jti of the token the identity was built from
+		TokenID string
+		// Tenant is the id of the tenant the token was verified for, empty for single tenant deployments
+		Tenant string
+		Roles  []string
+		Scopes []string
+		// ExpiresAt is the expiry of the token, zero when the token does not expire
+		ExpiresAt time.Time
+		// Claims holds the raw claims of the token
+		Claims map[string]interface{}
+		// TypedClaims holds the claims decoded into the struct of the application when the provider is configured
+		// with one, e.g. jwt.WithClaimsType, nil otherwise
+		TypedClaims interface{}
+	}
+
+	// TokenAuthenticator validates a raw token outside the http middleware chain (grpc, messaging ...)
+	TokenAuthenticator interface {
+		Authenticate(token string) (*Identity, error)
+	}
+
+	// ContextAuthenticator is implemented by the TokenAuthenticators which call a network store or endpoint, the
+	// deadline and cancellation of the context apply to the calls
+	ContextAuthenticator interface {
+		AuthenticateContext(ctx context.Context, token string) (*Identity, error)
+	}
+
+	// RequestAuthenticator is implemented by the TokenAuthenticators verifying the proofs the request carries along
+	// with the token, e.g. the DPoP proof or the client certificate of the sender constrained tokens
+	RequestAuthenticator interface {
+		AuthenticateRequest(r *http.Request, token string) (*Identity, error)
+	}
+
+	// ClaimsMapper turns the identity built from the validated claims into the one of the application, e.g. to
+	// normalize the role names or to load the user record. Returning an error rejects the request
+	ClaimsMapper func(ctx context.Context, identity *Identity) (*Identity, error)
+
+	identityKey struct{}
+)
+
+// AuthenticateContext validates the token with the AuthenticateContext of the authenticator when it is a
+// ContextAuthenticator, with its Authenticate otherwise
+func AuthenticateContext(ctx context.Context, authenticator TokenAuthenticator, token string) (*Identity, error) {
+	if contextAuthenticator, ok := authenticator.(ContextAuthenticator); ok {
+		return contextAuthenticator.AuthenticateContext(ctx, token)
+	}
+	return authenticator.Authenticate(token)
+}
+
+// AuthenticateRequest validates the token of the request with the AuthenticateRequest of the authenticator when it is
+// a RequestAuthenticator, with AuthenticateContext otherwise
+func AuthenticateRequest(r *http.Request, authenticator TokenAuthenticator, token string) (*Identity, error) {
+	if requestAuthenticator, ok := authenticator.(RequestAuthenticator); ok {
+		return requestAuthenticator.AuthenticateRequest(r, token)
+	}
+	return AuthenticateContext(r.Context(), authenticator, token)
+}
+
+// NewContext returns a copy of the parent context carrying the identity
+func NewContext(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity stored by NewContext, if any
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
+
+// HasRole reports whether the identity holds the role
+func (identity *Identity) HasRole(role string) bool {
+	for _, r := range identity.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the identity was granted the scope
+func (identity *Identity) HasScope(scope string) bool {
+	for _, s := range identity.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Actor returns the subject acting on behalf of the identity, recorded in the act claim of the impersonation and
+// the delegation tokens
+func (identity *Identity) Actor() (string, bool) {
+	act, _ := identity.Claims["act"].(map[string]interface{})
+	actor, _ := act["sub"].(string)
+	return actor, actor != ""
+}
@@ -0,0 +1,214 @@
+package identityheaders
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPrefix = "X-Auth-"
+	DefaultMaxAge = time.Minute
+
+	Subject   = "Subject"
+	Roles     = "Roles"
+	Scopes    = "Scopes"
+	Tenant    = "Tenant"
+	Actor     = "Actor"
+	Claims    = "Claims"
+	Timestamp = "Timestamp"
+	Signature = "Signature"
+)
+
+type (
+	// Injector writes the validated identity into the headers of the requests sent to the upstream services, the
+	// headers are prefixed with the Prefix, e.g. X-Auth-Subject, X-Auth-Roles (comma separated), X-Auth-Scopes
+	// (space separated) and X-Auth-Claims (base64url json). With a Key the headers are signed so that the
+	// upstreams reachable by other means than the gateway can tell them from the ones set by a client
+	Injector struct {
+		// Prefix of the headers, DefaultPrefix when empty
+		Prefix string
+		// Claims adds the claims of the token, they may be large
+		Claims bool
+		// Key signs the headers with HMAC-SHA256 along with a timestamp, unsigned when empty
+		Key []byte
+		// MaxAge bounds the age of the signed headers accepted by Verify, DefaultMaxAge when 0
+		MaxAge time.Duration
+		// ErrorWriter renders the failures of Trust, plain text errors are written when nil
+		ErrorWriter turboError.ErrorWriter
+	}
+)
+
+var (
+	ErrMissingIdentity  = errors.New("identity headers are missing")
+	ErrInvalidSignature = errors.New("identity headers signature is invalid")
+	ErrExpiredSignature = errors.New("identity headers signature has expired")
+)
+
+// NewInjector signs the headers with the key when not empty
+func NewInjector(key []byte) *Injector {
+	return &Injector{Prefix: DefaultPrefix, Key: key, MaxAge: DefaultMaxAge}
+}
+
+// Inject replaces the identity headers of the header with the ones of the identity, the headers of the prefix are
+// always removed first so that a client cannot pass its own, the identity may be nil
+func (i *Injector) Inject(header http.Header, identity *turboAuth.Identity) error {
+	prefix := http.CanonicalHeaderKey(i.prefix())
+	for name := range header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(name), prefix) {
+			header.Del(name)
+		}
+	}
+	if identity == nil {
+		return nil
+	}
+	values := map[string]string{
+		Subject: identity.Subject,
+		Roles:   strings.Join(identity.Roles, ","),
+		Scopes:  strings.Join(identity.Scopes, " "),
+		Tenant:  identity.Tenant,
+	}
+	values[Actor], _ = identity.Actor()
+	if i.Claims && len(identity.Claims) > 0 {
+		claims, err := json.Marshal(identity.Claims)
+		if err != nil {
+			return err
+		}
+		values[Claims] = base64.RawURLEncoding.EncodeToString(claims)
+	}
+	for name, value := range values {
+		if value != "" {
+			header.Set(i.prefix()+name, value)
+		}
+	}
+	if len(i.Key) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		header.Set(i.prefix()+Timestamp, timestamp)
+		header.Set(i.prefix()+Signature, i.sign(header, timestamp))
+	}
+	return nil
+}
+
+// Middleware injects the identity of the request context into the request, before it is proxied upstream, e.g.
+// by an httputil.ReverseProxy placed after the authentication
+func (i *Injector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := turboAuth.IdentityFromContext(r.Context())
+		r = r.Clone(r.Context())
+		if err := i.Inject(r.Header, identity); err != nil {
+			turboError.WriteError(i.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: http.StatusInternalServerError,
+				Message:    "Error : " + err.Error() + " \n",
+				Err:        err,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Verify rebuilds the identity of the headers set by Inject, the signature and its age are checked when the Key
+// is set
+func (i *Injector) Verify(header http.Header) (*turboAuth.Identity, error) {
+	subject := header.Get(i.prefix() + Subject)
+	if subject == "" {
+		return nil, ErrMissingIdentity
+	}
+	if len(i.Key) > 0 {
+		timestamp := header.Get(i.prefix() + Timestamp)
+		signature := header.Get(i.prefix() + Signature)
+		if !hmac.Equal([]byte(signature), []byte(i.sign(header, timestamp))) {
+			return nil, turboError.Wrap(turboError.ErrTokenInvalid, ErrInvalidSignature)
+		}
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return nil, turboError.Wrap(turboError.ErrTokenInvalid, ErrInvalidSignature)
+		}
+		if age := time.Since(time.Unix(seconds, 0)); age > i.maxAge() || age < -i.maxAge() {
+			return nil, turboError.Wrap(turboError.ErrTokenExpired, ErrExpiredSignature)
+		}
+	}
+	identity := &turboAuth.Identity{
+		Subject: subject,
+		Roles:   split(header.Get(i.prefix()+Roles), ","),
+		Scopes:  strings.Fields(header.Get(i.prefix() + Scopes)),
+		Tenant:  header.Get(i.prefix() + Tenant),
+	}
+	if encoded := header.Get(i.prefix() + Claims); encoded != "" {
+		claims, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err == nil {
+			err = json.Unmarshal(claims, &identity.Claims)
+		}
+		if err != nil {
+			return nil, turboError.Wrap(turboError.ErrTokenMalformed, err)
+		}
+	}
+	if actor := header.Get(i.prefix() + Actor); actor != "" && identity.Claims["act"] == nil {
+		if identity.Claims == nil {
+			identity.Claims = make(map[string]interface{})
+		}
+		identity.Claims["act"] = map[string]interface{}{"sub": actor}
+	}
+	return identity, nil
+}
+
+// Trust is the middleware of the upstream services, it verifies the identity headers and stores the identity in
+// the request context, the requests without valid headers are rejected with 401
+func (i *Injector) Trust(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := i.Verify(r.Header)
+		if err != nil {
+			turboError.WriteError(i.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Error : " + err.Error() + " \n",
+				Err:        err,
+			})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(turboAuth.NewContext(r.Context(), identity)))
+	})
+}
+
+// sign computes the signature of the identity headers and the timestamp, each value is length prefixed so that
+// the values cannot be shifted from one header to another
+func (i *Injector) sign(header http.Header, timestamp string) string {
+	mac := hmac.New(sha256.New, i.Key)
+	for _, name := range []string{Subject, Roles, Scopes, Tenant, Actor, Claims} {
+		value := header.Get(i.prefix() + name)
+		mac.Write([]byte(strconv.Itoa(len(value)) + ":" + value))
+	}
+	mac.Write([]byte(timestamp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (i *Injector) prefix() string {
+	if i.Prefix == "" {
+		return DefaultPrefix
+	}
+	return i.Prefix
+}
+
+func (i *Injector) maxAge() time.Duration {
+	if i.MaxAge <= 0 {
+		return DefaultMaxAge
+	}
+	return i.MaxAge
+}
+
+func split(value string, separator string) []string {
+	var values []string
+	for _, v := range strings.Split(value, separator) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
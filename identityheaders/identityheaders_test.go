@@ -0,0 +1,107 @@
+package identityheaders
+
+import (
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestInjector(t *testing.T) {
+	identity := &turboAuth.Identity{
+		Subject: "test_user",
+		Tenant:  "acme",
+		Roles:   []string{"admin", "user"},
+		Scopes:  []string{"read", "write"},
+		Claims:  map[string]interface{}{"sub": "test_user", "act": map[string]interface{}{"sub": "support"}},
+	}
+	injector := NewInjector([]byte("test_key"))
+	injector.Claims = true
+
+	tests := []struct {
+		name    string
+		tamper  func(header http.Header)
+		verify  *Injector
+		wantErr bool
+	}{
+		{name: "Test_valid", verify: injector},
+		{name: "Test_tampered_roles", verify: injector, tamper: func(header http.Header) {
+			header.Set(DefaultPrefix+Roles, "admin,superuser")
+		}, wantErr: true},
+		{name: "Test_shifted_values", verify: injector, tamper: func(header http.Header) {
+			header.Set(DefaultPrefix+Roles, "admin,user read")
+			header.Del(DefaultPrefix + Scopes)
+		}, wantErr: true},
+		{name: "Test_expired", verify: injector, tamper: func(header http.Header) {
+			header.Set(DefaultPrefix+Timestamp, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+		}, wantErr: true},
+		{name: "Test_wrong_key", verify: NewInjector([]byte("other_key")), wantErr: true},
+		{name: "Test_unsigned", verify: &Injector{}, tamper: func(header http.Header) {
+			header.Del(DefaultPrefix + Signature)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if err := injector.Inject(header, identity); err != nil {
+				t.Fatalf("Inject() error = %v", err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(header)
+			}
+			got, err := tt.verify.Verify(header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Subject != "test_user" || got.Tenant != "acme" || len(got.Roles) != 2 || len(got.Scopes) != 2 {
+				t.Errorf("Verify() = %+v", got)
+			}
+			if actor, _ := got.Actor(); actor != "support" {
+				t.Errorf("Actor() = %q, want support", actor)
+			}
+		})
+	}
+}
+
+func TestInjector_Middleware(t *testing.T) {
+	injector := NewInjector([]byte("test_key"))
+	var upstream *http.Request
+	proxy := injector.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r
+	}))
+	trusted := injector.Trust(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := turboAuth.IdentityFromContext(r.Context())
+		_, _ = w.Write([]byte(identity.Subject))
+	}))
+
+	tests := []struct {
+		name     string
+		identity *turboAuth.Identity
+		want     int
+		wantBody string
+	}{
+		{name: "Test_authenticated", identity: &turboAuth.Identity{Subject: "test_user"}, want: http.StatusOK,
+			wantBody: "test_user"},
+		{name: "Test_spoofed", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			r.Header.Set(DefaultPrefix+Subject, "spoofed_user")
+			if tt.identity != nil {
+				r = r.WithContext(turboAuth.NewContext(r.Context(), tt.identity))
+			}
+			proxy.ServeHTTP(httptest.NewRecorder(), r)
+			w := httptest.NewRecorder()
+			trusted.ServeHTTP(w, upstream)
+			if w.Code != tt.want || (tt.wantBody != "" && w.Body.String() != tt.wantBody) {
+				t.Errorf("status = %v, body = %q, want %v, %q", w.Code, w.Body.String(), tt.want, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package idp
+
+import (
+	"errors"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"github.com/nandlabs/turbo-auth/sessions"
+	"net/http"
+)
+
+// redirectWriter keeps the headers the TokenResponder sets, e.g. its cookies, the status and the body are replaced
+// by the redirect
+type redirectWriter struct {
+	http.ResponseWriter
+}
+
+var ErrNoIssuer = errors.New("neither an issuer nor a session manager is configured")
+
+// StartLogin finishes the login of the authenticated user for the passwordless and federated providers: the token
+// pair of the issuer is returned for WriteLoginResponse, or a session of the sessionManager is started when the
+// issuer is nil, in which case the pair is nil. The values are stored in the session along with the roles, e.g. the
+// sid of the session at the federated provider
+func StartLogin(w http.ResponseWriter, issuer TokenIssuer, sessionManager *sessions.SessionManager, username string, roles []string, values map[string]interface{}) (*jwt.TokenPair, error) {
+	switch {
+	case issuer != nil:
+		pair, jwtErr := issuer.IssueTokenPair(username, roles)
+		if jwtErr != nil {
+			return nil, jwtErr
+		}
+		return pair, nil
+	case sessionManager != nil:
+		sessionValues := map[string]interface{}{"Roles": roles}
+		for name, value := range values {
+			sessionValues[name] = value
+		}
+		_, err := sessionManager.Create(w, username, sessionValues)
+		return nil, err
+	default:
+		return nil, ErrNoIssuer
+	}
+}
+
+// WriteLoginResponse answers a successful login, all the providers write their logins with it. The pair is written
+// with the responder, or with the issuer and the LoginResponse body when nil. With a redirectURL the browser is
+// redirected once the responder set the tokens, its status and body are dropped so it should set cookies. A nil
+// pair, a started session, is answered 204 No Content
+func WriteLoginResponse(w http.ResponseWriter, r *http.Request, responder TokenResponder, issuer TokenIssuer, pair *jwt.TokenPair, redirectURL string) {
+	w.Header().Set("Cache-Control", "no-store")
+	if pair != nil {
+		if responder == nil {
+			responder = TokenResponderFunc(func(w http.ResponseWriter, r *http.Request, pair *jwt.TokenPair) {
+				issuer.WriteTokens(w, pair.AuthToken, pair.RefreshToken)
+				(&JSONResponder{}).WriteTokenResponse(w, r, pair)
+			})
+		}
+		if redirectURL == "" {
+			responder.WriteTokenResponse(w, r, pair)
+			return
+		}
+		responder.WriteTokenResponse(&redirectWriter{w}, r, pair)
+		w.Header().Del("Content-Type")
+	}
+	if redirectURL != "" {
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (w *redirectWriter) WriteHeader(int) {}
+
+func (w *redirectWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
@@ -0,0 +1,160 @@
+package idp
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/logging"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"github.com/nandlabs/turbo-auth/ratelimit"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+type (
+	// TokenIssuer issues the tokens of the authenticated users, implemented by *jwt.JwtAuthConfig
+	TokenIssuer interface {
+		IssueTokenPair(username string, roles []string) (*jwt.TokenPair, *turboError.JwtError)
+		WriteTokens(w http.ResponseWriter, authToken string, refreshToken string)
+	}
+
+	// Provider is a minimal identity provider, it authenticates the users of the UserStore and issues the turbo-auth
+	// token pair
+	Provider struct {
+		Users  UserStore
+		Issuer TokenIssuer
+		// Lockout locks the accounts out after repeated failed logins when set, see NewLockout. Protect the
+		// LoginHandler with a ratelimit.Limiter by ip as well to slow down the attempts spread over many accounts
+		Lockout *ratelimit.Limiter
+		// AuditLogger receives the login events when set
+		AuditLogger audit.AuditLogger
+		// ErrorWriter renders the failures, plain text errors are written when nil
+		ErrorWriter turboError.ErrorWriter
+		// Responder writes the tokens of the LoginHandler, when nil the tokens are written with the TokenIssuer and
+		// the LoginResponse is answered
+		Responder TokenResponder
+	}
+
+	// LoginRequest is the json body of the LoginHandler, the username and password form values are accepted too
+	LoginRequest struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	LoginResponse struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+)
+
+var (
+	// ErrInvalidCredentials is returned for unknown users, wrong passwords and disabled users alike
+	ErrInvalidCredentials = errors.New("invalid username or password")
+)
+
+var logger = logging.Get()
+
+func NewProvider(users UserStore, issuer TokenIssuer) *Provider {
+	return &Provider{Users: users, Issuer: issuer}
+}
+
+// Login verifies the credentials and issues the token pair carrying the roles of the user, an *AccountLockedError
+// is returned while the account is locked out
+func (p *Provider) Login(r *http.Request, username string, password string) (*jwt.TokenPair, error) {
+	pair, err := p.login(username, password)
+	p.recordAttempt(username, err)
+	if p.AuditLogger != nil {
+		event := audit.NewEvent(r, audit.EventLogin, "idp", err)
+		event.Subject = username
+		p.AuditLogger.Log(event)
+	}
+	return pair, err
+}
+
+func (p *Provider) login(username string, password string) (*jwt.TokenPair, error) {
+	if err := p.checkLockout(username); err != nil {
+		return nil, err
+	}
+	user, err := p.Users.FindByUsername(username)
+	if errors.Is(err, ErrUserNotFound) {
+		_, _ = p.Users.VerifyPassword(nil, password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	valid, err := p.Users.VerifyPassword(user, password)
+	if err != nil {
+		return nil, err
+	}
+	if !valid || user.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	roles, err := p.Users.GetRoles(user)
+	if err != nil {
+		return nil, err
+	}
+	pair, jwtErr := p.Issuer.IssueTokenPair(user.Username, roles)
+	if jwtErr != nil {
+		return nil, jwtErr
+	}
+	return pair, nil
+}
+
+// LoginHandler authenticates the POSTed credentials and writes the tokens with the Responder
+func (p *Provider) LoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			p.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		request, err := readLoginRequest(r)
+		if err != nil || request.Username == "" || request.Password == "" {
+			p.writeError(w, r, http.StatusBadRequest, "username and password are required")
+			return
+		}
+		pair, err := p.Login(r, request.Username, request.Password)
+		var lockedErr *AccountLockedError
+		if errors.As(err, &lockedErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedErr.RetryAfter.Seconds()))))
+			p.writeError(w, r, http.StatusTooManyRequests, "too many failed attempts, try again later")
+			return
+		}
+		if errors.Is(err, ErrInvalidCredentials) {
+			p.writeError(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			p.writeError(w, r, http.StatusInternalServerError, "unable to complete the login")
+			return
+		}
+		WriteLoginResponse(w, r, p.Responder, p.Issuer, pair, "")
+	})
+}
+
+func (p *Provider) writeError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	turboError.WriteError(p.ErrorWriter, w, r, &turboError.HttpError{
+		StatusCode: statusCode,
+		Message:    "Error : " + message + " \n",
+	})
+}
+
+func readLoginRequest(r *http.Request) (*LoginRequest, error) {
+	var request LoginRequest
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		err := json.NewDecoder(r.Body).Decode(&request)
+		return &request, err
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	request.Username = r.PostForm.Get("username")
+	request.Password = r.PostForm.Get("password")
+	return &request, nil
+}
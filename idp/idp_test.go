@@ -0,0 +1,350 @@
+package idp
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/nandlabs/turbo-auth/audit"
+	"github.com/nandlabs/turbo-auth/credentials"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *jwt.JwtAuthConfig, *[]*audit.Event) {
+	users := NewMemoryUserStore()
+	users.Hasher = &credentials.PasswordHasher{Preferred: credentials.NewBcryptHasher(4)}
+	if err := users.AddUser("alice", "correct horse", "admin", "user"); err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+	if err := users.AddUser("bob", "battery staple"); err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+	if err := users.SetDisabled("bob", true); err != nil {
+		t.Fatalf("SetDisabled() error = %v", err)
+	}
+	authConfig := jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+	var events []*audit.Event
+	provider := NewProvider(users, authConfig)
+	provider.AuditLogger = audit.AuditLoggerFunc(func(event *audit.Event) {
+		events = append(events, event)
+	})
+	return provider, authConfig, &events
+}
+
+func TestMemoryUserStore_AddUser(t *testing.T) {
+	provider, _, _ := newTestProvider(t)
+	users := provider.Users.(*MemoryUserStore)
+	if err := users.AddUser("alice", "other"); err != ErrUserExists {
+		t.Errorf("AddUser() duplicate error = %v, want %v", err, ErrUserExists)
+	}
+	if _, err := users.FindByUsername("carol"); err != ErrUserNotFound {
+		t.Errorf("FindByUsername() error = %v, want %v", err, ErrUserNotFound)
+	}
+	if valid, err := users.VerifyPassword(nil, "anything"); valid || err != nil {
+		t.Errorf("VerifyPassword(nil) = %v, %v, want false", valid, err)
+	}
+}
+
+func TestProvider_LoginHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{
+			name:        "Test_json",
+			contentType: "application/json",
+			body:        `{"username": "alice", "password": "correct horse"}`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "Test_form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"username": {"alice"}, "password": {"correct horse"}}.Encode(),
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "Test_wrong_password",
+			contentType: "application/json",
+			body:        `{"username": "alice", "password": "wrong"}`,
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "Test_unknown_user",
+			contentType: "application/json",
+			body:        `{"username": "carol", "password": "correct horse"}`,
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "Test_disabled_user",
+			contentType: "application/json",
+			body:        `{"username": "bob", "password": "battery staple"}`,
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "Test_missing_password",
+			contentType: "application/json",
+			body:        `{"username": "alice"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, authConfig, events := newTestProvider(t)
+			r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			provider.LoginHandler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				if len(*events) != 0 {
+					t.Errorf("audit events = %v, want none", len(*events))
+				}
+				return
+			}
+			if len(*events) != 1 || (*events)[0].Type != audit.EventLogin {
+				t.Fatalf("audit events = %v, want one login event", *events)
+			}
+			if w.Code != http.StatusOK {
+				if (*events)[0].Outcome != audit.OutcomeFailure {
+					t.Errorf("audit outcome = %v, want failure", (*events)[0].Outcome)
+				}
+				return
+			}
+
+			var response LoginResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+			if response.TokenType != "Bearer" || response.RefreshToken == "" || response.ExpiresIn <= 0 {
+				t.Errorf("response = %+v", response)
+			}
+			if got := w.Header().Get(authConfig.AuthTokenName); got != response.AccessToken {
+				t.Errorf("auth token header = %v, want the access token", got)
+			}
+			identity, err := authConfig.Authenticate(response.AccessToken)
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if identity.Subject != "alice" || !identity.HasRole("admin") {
+				t.Errorf("identity = %+v", identity)
+			}
+		})
+	}
+}
+
+func TestProvider_LoginHandler_method(t *testing.T) {
+	provider, _, _ := newTestProvider(t)
+	w := httptest.NewRecorder()
+	provider.LoginHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("status = %v, Allow = %v", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestProvider_LoginHandler_responders(t *testing.T) {
+	tests := []struct {
+		name       string
+		responder  TokenResponder
+		wantStatus int
+		// token returns the access token written by the responder
+		token func(w *httptest.ResponseRecorder) string
+	}{
+		{
+			name:       "Test_json",
+			responder:  &JSONResponder{OmitRefreshToken: true},
+			wantStatus: http.StatusOK,
+			token: func(w *httptest.ResponseRecorder) string {
+				var response LoginResponse
+				_ = json.NewDecoder(w.Body).Decode(&response)
+				if response.RefreshToken != "" {
+					return ""
+				}
+				return response.AccessToken
+			},
+		},
+		{
+			name:       "Test_cookies",
+			responder:  NewCookieResponder(),
+			wantStatus: http.StatusNoContent,
+			token: func(w *httptest.ResponseRecorder) string {
+				cookies := w.Result().Cookies()
+				if len(cookies) != 2 || !cookies[0].HttpOnly || !cookies[0].Secure || cookies[1].Name != "RefreshToken" {
+					return ""
+				}
+				return cookies[0].Value
+			},
+		},
+		{
+			name:       "Test_headers",
+			responder:  &HeaderResponder{AuthHeader: "X-Access-Token"},
+			wantStatus: http.StatusNoContent,
+			token: func(w *httptest.ResponseRecorder) string {
+				return w.Header().Get("X-Access-Token")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, authConfig, _ := newTestProvider(t)
+			provider.Responder = tt.responder
+			r := httptest.NewRequest(http.MethodPost, "/login",
+				strings.NewReader(`{"username": "alice", "password": "correct horse"}`))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			provider.LoginHandler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get(authConfig.AuthTokenName); got != "" {
+				t.Errorf("auth token header = %v, want the issuer not to write the tokens", got)
+			}
+			if _, err := authConfig.Authenticate(tt.token(w)); err != nil {
+				t.Errorf("Authenticate() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteLoginResponse(t *testing.T) {
+	_, authConfig, _ := newTestProvider(t)
+	pair, jwtErr := authConfig.IssueTokenPair("alice", nil)
+	if jwtErr != nil {
+		t.Fatalf("IssueTokenPair() error = %v", jwtErr)
+	}
+	tests := []struct {
+		name         string
+		responder    TokenResponder
+		pair         *jwt.TokenPair
+		redirectURL  string
+		wantStatus   int
+		wantCookies  int
+		wantLocation string
+	}{
+		{name: "Test_default_json", pair: pair, wantStatus: http.StatusOK},
+		{name: "Test_cookies_redirect", responder: NewCookieResponder(), pair: pair, redirectURL: "/home", wantStatus: http.StatusSeeOther, wantCookies: 2, wantLocation: "/home"},
+		{name: "Test_json_redirect", responder: &JSONResponder{}, pair: pair, redirectURL: "/home", wantStatus: http.StatusSeeOther, wantLocation: "/home"},
+		{name: "Test_session", wantStatus: http.StatusNoContent},
+		{name: "Test_session_redirect", redirectURL: "/home", wantStatus: http.StatusSeeOther, wantLocation: "/home"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			WriteLoginResponse(w, httptest.NewRequest(http.MethodPost, "/callback", nil), tt.responder, authConfig, tt.pair, tt.redirectURL)
+			if w.Code != tt.wantStatus || w.Header().Get("Location") != tt.wantLocation {
+				t.Fatalf("status = %v, Location = %v, want %v, %v", w.Code, w.Header().Get("Location"), tt.wantStatus, tt.wantLocation)
+			}
+			if got := len(w.Result().Cookies()); got != tt.wantCookies {
+				t.Errorf("cookies = %v, want %v", got, tt.wantCookies)
+			}
+			if w.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Cache-Control = %v, want no-store", w.Header().Get("Cache-Control"))
+			}
+			if tt.redirectURL != "" && strings.Contains(w.Body.String(), "access_token") {
+				t.Errorf("body of the redirect = %v", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:     10,
+		MaxLength:     20,
+		RequireUpper:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		BreachedCheck: func(password string) (bool, error) {
+			return password == "Password123!", nil
+		},
+	}
+	tests := []struct {
+		name     string
+		password string
+		want     []Violation
+	}{
+		{name: "Test_valid", password: "Corr3ct horse!"},
+		{name: "Test_too_short", password: "Sh0rt!", want: []Violation{ViolationTooShort}},
+		{name: "Test_too_long", password: "Way too l0ng for the policy!", want: []Violation{ViolationTooLong}},
+		{
+			name:     "Test_composition",
+			password: "only lowercase letters",
+			want:     []Violation{ViolationTooLong, ViolationMissingUpper, ViolationMissingDigit, ViolationMissingSymbol},
+		},
+		{name: "Test_breached", password: "Password123!", want: []Violation{ViolationBreached}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.password)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) || !errors.Is(err, ErrPasswordPolicy) {
+				t.Fatalf("Validate() error = %v, want a PolicyError", err)
+			}
+			if !reflect.DeepEqual(policyErr.Violations, tt.want) {
+				t.Errorf("Violations = %v, want %v", policyErr.Violations, tt.want)
+			}
+		})
+	}
+
+	users := NewMemoryUserStore()
+	users.Policy = policy
+	if err := users.AddUser("carol", "weak"); !errors.Is(err, ErrPasswordPolicy) {
+		t.Errorf("AddUser() error = %v, want %v", err, ErrPasswordPolicy)
+	}
+}
+
+func TestProvider_Lockout(t *testing.T) {
+	provider, _, _ := newTestProvider(t)
+	provider.Lockout = NewLockout(nil, 3, time.Hour)
+	login := func(username, password string) error {
+		_, err := provider.Login(nil, username, password)
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := login("alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Login() attempt %v error = %v, want %v", i, err, ErrInvalidCredentials)
+		}
+	}
+	err := login("alice", "correct horse")
+	var lockedErr *AccountLockedError
+	if !errors.As(err, &lockedErr) || !errors.Is(err, ErrAccountLocked) || lockedErr.RetryAfter <= 0 {
+		t.Fatalf("Login() locked out error = %v, want an AccountLockedError", err)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username": "alice", "password": "correct horse"}`))
+	r.Header.Set("Content-Type", "application/json")
+	provider.LoginHandler().ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("LoginHandler() locked out status = %v, Retry-After = %v", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	if err := provider.Unlock("alice"); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if err := login("alice", "correct horse"); err != nil {
+		t.Errorf("Login() after unlock error = %v", err)
+	}
+	if err := login("carol", "unknown"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login() unknown user error = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
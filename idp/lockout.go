@@ -0,0 +1,78 @@
+package idp
+
+import (
+	"errors"
+	"github.com/nandlabs/turbo-auth/ratelimit"
+	"time"
+)
+
+// AccountLockedError is returned at login while the account is locked out, errors.Is(err, ErrAccountLocked) holds
+type AccountLockedError struct {
+	Username   string
+	RetryAfter time.Duration
+}
+
+const lockoutKeyPrefix = "account:"
+
+var ErrAccountLocked = errors.New("account is locked")
+
+// NewLockout returns a Limiter locking the accounts out for lockoutDuration once maxAttempts failed logins are
+// recorded within the lockoutDuration, use it as the Provider Lockout
+func NewLockout(store ratelimit.CounterStore, maxAttempts int64, lockoutDuration time.Duration) *ratelimit.Limiter {
+	limiter := ratelimit.NewLimiter(store)
+	limiter.MaxAttempts = maxAttempts
+	limiter.Window = lockoutDuration
+	limiter.BaseLockout = lockoutDuration
+	limiter.MaxLockout = lockoutDuration
+	return limiter
+}
+
+func (err *AccountLockedError) Error() string {
+	return ErrAccountLocked.Error() + ", retry after " + err.RetryAfter.Round(time.Second).String()
+}
+
+func (err *AccountLockedError) Is(target error) bool {
+	return target == ErrAccountLocked
+}
+
+// Unlock clears the failed attempts and the lockout of the account
+func (p *Provider) Unlock(username string) error {
+	if p.Lockout == nil {
+		return nil
+	}
+	return p.Lockout.Succeed(lockoutKeyPrefix + username)
+}
+
+// checkLockout fails when the account is locked out, the errors of the counter store do not block the login
+func (p *Provider) checkLockout(username string) error {
+	if p.Lockout == nil {
+		return nil
+	}
+	allowed, retryAfter, err := p.Lockout.Allowed(lockoutKeyPrefix + username)
+	if err != nil {
+		logger.ErrorF("lockout store error: %v", err)
+		return nil
+	}
+	if !allowed {
+		return &AccountLockedError{Username: username, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// recordAttempt counts the failed logins towards the lockout, the unknown usernames included so the lockout does
+// not reveal which accounts exist
+func (p *Provider) recordAttempt(username string, err error) {
+	if p.Lockout == nil {
+		return
+	}
+	var storeErr error
+	switch {
+	case err == nil:
+		storeErr = p.Lockout.Succeed(lockoutKeyPrefix + username)
+	case errors.Is(err, ErrInvalidCredentials):
+		storeErr = p.Lockout.Fail(lockoutKeyPrefix + username)
+	}
+	if storeErr != nil {
+		logger.ErrorF("lockout store error: %v", storeErr)
+	}
+}
@@ -0,0 +1,121 @@
+package idp
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type (
+	// PasswordPolicy is enforced when the passwords are set, the zero value accepts any password
+	PasswordPolicy struct {
+		MinLength int
+		// MaxLength bounds the cost of hashing, unlimited when 0
+		MaxLength     int
+		RequireUpper  bool
+		RequireLower  bool
+		RequireDigit  bool
+		RequireSymbol bool
+		// BreachedCheck rejects the passwords known from data breaches when set, e.g. a Have I Been Pwned client
+		BreachedCheck BreachedPasswordCheck
+	}
+
+	// BreachedPasswordCheck reports whether the password appeared in a data breach
+	BreachedPasswordCheck func(password string) (bool, error)
+
+	Violation string
+
+	// PolicyError lists the rules of the PasswordPolicy the password violates, errors.Is(err, ErrPasswordPolicy) holds
+	PolicyError struct {
+		Violations []Violation
+	}
+)
+
+const (
+	ViolationTooShort      Violation = "too_short"
+	ViolationTooLong       Violation = "too_long"
+	ViolationMissingUpper  Violation = "missing_upper"
+	ViolationMissingLower  Violation = "missing_lower"
+	ViolationMissingDigit  Violation = "missing_digit"
+	ViolationMissingSymbol Violation = "missing_symbol"
+	ViolationBreached      Violation = "breached"
+)
+
+var (
+	ErrPasswordPolicy = errors.New("password does not satisfy the policy")
+
+	// DefaultPasswordPolicy follows NIST SP 800-63B: a minimum length rather than composition rules
+	DefaultPasswordPolicy = &PasswordPolicy{
+		MinLength: 8,
+		MaxLength: 128,
+	}
+)
+
+// Validate returns a *PolicyError when the password violates the policy, the BreachedCheck failures are returned
+// as is
+func (p *PasswordPolicy) Validate(password string) error {
+	if p == nil {
+		return nil
+	}
+	var violations []Violation
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, ViolationTooShort)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, ViolationTooLong)
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+	for _, rule := range []struct {
+		required  bool
+		satisfied bool
+		violation Violation
+	}{
+		{p.RequireUpper, upper, ViolationMissingUpper},
+		{p.RequireLower, lower, ViolationMissingLower},
+		{p.RequireDigit, digit, ViolationMissingDigit},
+		{p.RequireSymbol, symbol, ViolationMissingSymbol},
+	} {
+		if rule.required && !rule.satisfied {
+			violations = append(violations, rule.violation)
+		}
+	}
+	if p.BreachedCheck != nil && len(violations) == 0 {
+		breached, err := p.BreachedCheck(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			violations = append(violations, ViolationBreached)
+		}
+	}
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func (err *PolicyError) Error() string {
+	violations := make([]string, len(err.Violations))
+	for i, violation := range err.Violations {
+		violations[i] = string(violation)
+	}
+	return ErrPasswordPolicy.Error() + ": " + strings.Join(violations, ", ")
+}
+
+func (err *PolicyError) Is(target error) bool {
+	return target == ErrPasswordPolicy
+}
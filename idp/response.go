@@ -0,0 +1,118 @@
+package idp
+
+import (
+	"encoding/json"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"time"
+)
+
+type (
+	// TokenResponder writes the token pair of a successful login, the LoginHandler and the passwordless and social
+	// logins use it so that the browsers, the mobile apps and the services are served by the same handlers with the
+	// responder matching the client, see WriteLoginResponse
+	TokenResponder interface {
+		WriteTokenResponse(w http.ResponseWriter, r *http.Request, pair *jwt.TokenPair)
+	}
+
+	// TokenResponderFunc adapts a function to the TokenResponder interface
+	TokenResponderFunc func(w http.ResponseWriter, r *http.Request, pair *jwt.TokenPair)
+
+	// JSONResponder answers the OAuth2 style LoginResponse body
+	JSONResponder struct {
+		// OmitRefreshToken keeps the refresh token out of the body, e.g. for the public clients
+		OmitRefreshToken bool
+	}
+
+	// CookieResponder sets the auth and refresh token cookies and answers 204 No Content, the cookies are HttpOnly
+	// and Secure unless Insecure is set
+	CookieResponder struct {
+		AuthCookieName    string
+		RefreshCookieName string
+		Path              string
+		Domain            string
+		Insecure          bool
+		SameSite          http.SameSite
+		// RefreshTokenValidTime is the lifetime of the refresh cookie, the lifetime of the auth cookie is the
+		// ExpiresIn of the pair
+		RefreshTokenValidTime time.Duration
+	}
+
+	// HeaderResponder sets the tokens in the response headers and answers 204 No Content, the headers should be
+	// listed in the Access-Control-Expose-Headers of the cross origin clients
+	HeaderResponder struct {
+		AuthHeader    string
+		RefreshHeader string
+	}
+)
+
+func (f TokenResponderFunc) WriteTokenResponse(w http.ResponseWriter, r *http.Request, pair *jwt.TokenPair) {
+	f(w, r, pair)
+}
+
+func (j *JSONResponder) WriteTokenResponse(w http.ResponseWriter, r *http.Request, pair *jwt.TokenPair) {
+	response := &LoginResponse{
+		AccessToken:  pair.AuthToken,
+		RefreshToken: pair.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(pair.ExpiresIn.Seconds()),
+	}
+	if j.OmitRefreshToken {
+		response.RefreshToken = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// NewCookieResponder uses the default cookie names of turbo-auth with SameSite lax
+func NewCookieResponder() *CookieResponder {
+	return &CookieResponder{
+		AuthCookieName:        turboAuth.DefaultCookieAuthTokenName,
+		RefreshCookieName:     turboAuth.DefaultCookieRefreshTokenName,
+		Path:                  "/",
+		SameSite:              http.SameSiteLaxMode,
+		RefreshTokenValidTime: turboAuth.DefaultRefreshTokenValidTime,
+	}
+}
+
+func (c *CookieResponder) WriteTokenResponse(w http.ResponseWriter, r *http.Request, pair *jwt.TokenPair) {
+	http.SetCookie(w, c.cookie(c.AuthCookieName, pair.AuthToken, pair.ExpiresIn))
+	if pair.RefreshToken != "" {
+		http.SetCookie(w, c.cookie(c.RefreshCookieName, pair.RefreshToken, c.RefreshTokenValidTime))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *CookieResponder) cookie(name string, value string, ttl time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Expires:  time.Now().Add(ttl),
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   !c.Insecure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	}
+}
+
+// NewHeaderResponder uses the default bearer token headers of turbo-auth
+func NewHeaderResponder() *HeaderResponder {
+	return &HeaderResponder{
+		AuthHeader:    turboAuth.DefaultBearerAuthTokenHeader,
+		RefreshHeader: turboAuth.DefaultRefreshAuthTokenHeader,
+	}
+}
+
+func (h *HeaderResponder) WriteTokenResponse(w http.ResponseWriter, r *http.Request, pair *jwt.TokenPair) {
+	w.Header().Set(h.AuthHeader, pair.AuthToken)
+	if pair.RefreshToken != "" && h.RefreshHeader != "" {
+		w.Header().Set(h.RefreshHeader, pair.RefreshToken)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
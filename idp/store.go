@@ -0,0 +1,161 @@
+package idp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/nandlabs/turbo-auth/credentials"
+	"sync"
+)
+
+type (
+	User struct {
+		Username     string
+		PasswordHash string
+		Roles        []string
+		// Disabled users are refused at login
+		Disabled bool
+	}
+
+	// UserStore is the source of the users of the identity provider, implementations must be safe for concurrent use
+	UserStore interface {
+		// FindByUsername returns ErrUserNotFound when the username is unknown
+		FindByUsername(username string) (*User, error)
+		// VerifyPassword is also invoked with a nil user when the username is unknown, implementations should then
+		// spend a comparable time and return false so the response time does not reveal which usernames exist
+		VerifyPassword(user *User, password string) (bool, error)
+		GetRoles(user *User) ([]string, error)
+	}
+
+	// MemoryUserStore is an in-memory UserStore, the passwords are hashed with the Hasher
+	MemoryUserStore struct {
+		// Hasher is credentials.DefaultPasswordHasher when nil
+		Hasher *credentials.PasswordHasher
+		// Policy is enforced by AddUser and SetPassword when set
+		Policy    *PasswordPolicy
+		mutex     sync.RWMutex
+		users     map[string]*User
+		dummyOnce sync.Once
+		dummyHash string
+	}
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists   = errors.New("user already exists")
+)
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: make(map[string]*User)}
+}
+
+// AddUser hashes the password and stores the user, a *PolicyError is returned when the password violates the Policy
+func (m *MemoryUserStore) AddUser(username string, password string, roles ...string) error {
+	if err := m.Policy.Validate(password); err != nil {
+		return err
+	}
+	hash, err := m.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.users[username]; ok {
+		return ErrUserExists
+	}
+	m.users[username] = &User{Username: username, PasswordHash: hash, Roles: roles}
+	return nil
+}
+
+// SetPassword replaces the password of the user, a *PolicyError is returned when the password violates the Policy
+func (m *MemoryUserStore) SetPassword(username string, password string) error {
+	if err := m.Policy.Validate(password); err != nil {
+		return err
+	}
+	hash, err := m.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.PasswordHash = hash
+	return nil
+}
+
+// SetDisabled enables or disables the login of the user
+func (m *MemoryUserStore) SetDisabled(username string, disabled bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Disabled = disabled
+	return nil
+}
+
+func (m *MemoryUserStore) RemoveUser(username string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.users, username)
+}
+
+// FindByUsername returns a copy of the stored user
+func (m *MemoryUserStore) FindByUsername(username string) (*User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	user, ok := m.users[username]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	found := *user
+	found.Roles = append([]string(nil), user.Roles...)
+	return &found, nil
+}
+
+// VerifyPassword checks the password against the hash of the user, the stored hash is upgraded when the Hasher
+// parameters changed since it was computed
+func (m *MemoryUserStore) VerifyPassword(user *User, password string) (bool, error) {
+	if user == nil {
+		_, _ = m.hasher().Verify(password, m.dummy())
+		return false, nil
+	}
+	// a failed upgrade keeps the current hash, the password is valid nonetheless
+	ok, upgraded, err := m.hasher().VerifyAndUpgrade(password, user.PasswordHash)
+	if !ok {
+		return false, err
+	}
+	if upgraded != "" {
+		m.mutex.Lock()
+		if stored, found := m.users[user.Username]; found && stored.PasswordHash == user.PasswordHash {
+			stored.PasswordHash = upgraded
+		}
+		m.mutex.Unlock()
+	}
+	return true, nil
+}
+
+func (m *MemoryUserStore) GetRoles(user *User) ([]string, error) {
+	return user.Roles, nil
+}
+
+func (m *MemoryUserStore) hasher() *credentials.PasswordHasher {
+	if m.Hasher != nil {
+		return m.Hasher
+	}
+	return credentials.DefaultPasswordHasher
+}
+
+// dummy returns the hash of a random password, verified when the username is unknown
+func (m *MemoryUserStore) dummy() string {
+	m.dummyOnce.Do(func() {
+		random := make([]byte, 16)
+		_, _ = rand.Read(random)
+		m.dummyHash, _ = m.hasher().Hash(hex.EncodeToString(random))
+	})
+	return m.dummyHash
+}
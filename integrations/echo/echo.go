@@ -0,0 +1,35 @@
+package echo
+
+import (
+	"github.com/labstack/echo/v4"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/integrations/internal/adapter"
+)
+
+// IdentityKey is the echo context key holding the *turboAuth.Identity of an authenticated request
+const IdentityKey = "turboAuth.identity"
+
+// Authenticate adapts any turbo-auth Authenticator to echo, failures are returned as *echo.HTTPError
+// so they are rendered by the configured echo.HTTPErrorHandler
+func Authenticate(authenticator turboAuth.Authenticator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			result := adapter.Authenticate(authenticator, c.Request())
+			result.CopyHeaders(c.Response().Header())
+			if !result.Authenticated {
+				return echo.NewHTTPError(result.StatusCode, result.Message())
+			}
+			c.SetRequest(result.Request)
+			if result.Identity != nil {
+				c.Set(IdentityKey, result.Identity)
+			}
+			return next(c)
+		}
+	}
+}
+
+// GetIdentity returns the identity set by Authenticate
+func GetIdentity(c echo.Context) (*turboAuth.Identity, bool) {
+	identity, ok := c.Get(IdentityKey).(*turboAuth.Identity)
+	return identity, ok && identity != nil
+}
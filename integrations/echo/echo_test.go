@@ -0,0 +1,54 @@
+package echo
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	authConfig := jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		identity, ok := GetIdentity(c)
+		if !ok {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		return c.String(http.StatusOK, identity.Subject)
+	}, Authenticate(authConfig))
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{name: "Test_allowed", token: token, wantCode: http.StatusOK, wantBody: "test_user"},
+		{name: "Test_denied", token: "invalid", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(authConfig.AuthTokenName, tt.token)
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %v, want %v", w.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
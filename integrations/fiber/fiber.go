@@ -0,0 +1,43 @@
+package fiber
+
+import (
+	"github.com/gofiber/fiber/v2"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/integrations/internal/adapter"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"net/http"
+)
+
+// IdentityKey is the fiber locals key holding the *turboAuth.Identity of an authenticated request
+const IdentityKey = "turboAuth.identity"
+
+// Authenticate adapts any turbo-auth Authenticator to fiber, the fasthttp request is converted to a net/http
+// request for the authenticator and failures are returned as *fiber.Error for the configured ErrorHandler
+func Authenticate(authenticator turboAuth.Authenticator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		r := new(http.Request)
+		if err := fasthttpadaptor.ConvertRequest(c.Context(), r, true); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+		result := adapter.Authenticate(authenticator, r.WithContext(c.UserContext()))
+		for key, values := range result.Header {
+			for _, value := range values {
+				c.Response().Header.Add(key, value)
+			}
+		}
+		if !result.Authenticated {
+			return fiber.NewError(result.StatusCode, result.Message())
+		}
+		if result.Identity != nil {
+			c.Locals(IdentityKey, result.Identity)
+			c.SetUserContext(turboAuth.NewContext(c.UserContext(), result.Identity))
+		}
+		return c.Next()
+	}
+}
+
+// GetIdentity returns the identity set by Authenticate
+func GetIdentity(c *fiber.Ctx) (*turboAuth.Identity, bool) {
+	identity, ok := c.Locals(IdentityKey).(*turboAuth.Identity)
+	return identity, ok && identity != nil
+}
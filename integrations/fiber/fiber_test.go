@@ -0,0 +1,59 @@
+package fiber
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	authConfig := jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	app := fiber.New()
+	app.Get("/", Authenticate(authConfig), func(c *fiber.Ctx) error {
+		identity, ok := GetIdentity(c)
+		if !ok {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		return c.SendString(identity.Subject)
+	})
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{name: "Test_allowed", token: token, wantCode: http.StatusOK, wantBody: "test_user"},
+		{name: "Test_denied", token: "invalid", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(authConfig.AuthTokenName, tt.token)
+			res, err := app.Test(r)
+			if err != nil {
+				t.Fatalf("Test() error = %v", err)
+			}
+			defer res.Body.Close()
+			body, _ := ioutil.ReadAll(res.Body)
+			if res.StatusCode != tt.wantCode {
+				t.Errorf("status = %v, want %v", res.StatusCode, tt.wantCode)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
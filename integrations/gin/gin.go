@@ -0,0 +1,39 @@
+package gin
+
+import (
+	"github.com/gin-gonic/gin"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/integrations/internal/adapter"
+)
+
+// IdentityKey is the gin context key holding the *turboAuth.Identity of an authenticated request
+const IdentityKey = "turboAuth.identity"
+
+// Authenticate adapts any turbo-auth Authenticator to gin, failed requests are aborted with a json error body
+func Authenticate(authenticator turboAuth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result := adapter.Authenticate(authenticator, c.Request)
+		result.CopyHeaders(c.Writer.Header())
+		if !result.Authenticated {
+			c.AbortWithStatusJSON(result.StatusCode, gin.H{
+				"error": result.Message(),
+			})
+			return
+		}
+		c.Request = result.Request
+		if result.Identity != nil {
+			c.Set(IdentityKey, result.Identity)
+		}
+		c.Next()
+	}
+}
+
+// GetIdentity returns the identity set by Authenticate
+func GetIdentity(c *gin.Context) (*turboAuth.Identity, bool) {
+	value, ok := c.Get(IdentityKey)
+	if !ok {
+		return nil, false
+	}
+	identity, ok := value.(*turboAuth.Identity)
+	return identity, ok
+}
@@ -0,0 +1,111 @@
+package grpc
+
+import (
+	"context"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+const (
+	AuthorizationMetadataKey = "authorization"
+	BearerScheme             = "bearer"
+)
+
+type (
+	// TokenCredentials attaches a bearer token to every outgoing rpc, it implements credentials.PerRPCCredentials
+	TokenCredentials struct {
+		// TokenSource is called for every rpc so that refreshed tokens are picked up
+		TokenSource func(ctx context.Context) (string, error)
+		// AllowInsecure permits the token to be sent over a connection without transport security
+		AllowInsecure bool
+	}
+
+	// serverStream overrides the context of the wrapped stream with the authenticated one
+	serverStream struct {
+		grpc.ServerStream
+		ctx context.Context
+	}
+)
+
+var _ credentials.PerRPCCredentials = (*TokenCredentials)(nil)
+
+// UnaryServerInterceptor authenticates the bearer token of the incoming metadata and injects the Identity in the context
+func UnaryServerInterceptor(authenticator turboAuth.TokenAuthenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, authenticator)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authenticates the bearer token of the incoming metadata and injects the Identity in the stream context
+func StreamServerInterceptor(authenticator turboAuth.TokenAuthenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), authenticator)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func authenticate(ctx context.Context, authenticator turboAuth.TokenAuthenticator) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing request metadata")
+	}
+	token := TokenFromMetadata(md)
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	identity, err := turboAuth.AuthenticateContext(ctx, authenticator, token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return turboAuth.NewContext(ctx, identity), nil
+}
+
+// TokenFromMetadata extracts the token of the "authorization: Bearer <token>" metadata entry
+func TokenFromMetadata(md metadata.MD) string {
+	for _, value := range md.Get(AuthorizationMetadataKey) {
+		l := len(BearerScheme)
+		if len(value) > l+1 && strings.EqualFold(value[:l], BearerScheme) {
+			return strings.TrimSpace(value[l+1:])
+		}
+	}
+	return ""
+}
+
+// NewTokenCredentials sends the same static token on every rpc
+func NewTokenCredentials(token string) *TokenCredentials {
+	return &TokenCredentials{
+		TokenSource: func(ctx context.Context) (string, error) {
+			return token, nil
+		},
+	}
+}
+
+func (c *TokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.TokenSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		AuthorizationMetadataKey: "Bearer " + token,
+	}, nil
+}
+
+func (c *TokenCredentials) RequireTransportSecurity() bool {
+	return !c.AllowInsecure
+}
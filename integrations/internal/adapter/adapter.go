@@ -0,0 +1,88 @@
+package adapter
+
+import (
+	"bytes"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"net/http"
+	"strings"
+)
+
+type (
+	// Result is the outcome of running an Authenticator against a request outside of a net/http chain
+	Result struct {
+		// Authenticated is true when the authenticator called the next handler
+		Authenticated bool
+		// Request is the request as passed on by the authenticator, with the identity in its context
+		Request  *http.Request
+		Identity *turboAuth.Identity
+		// StatusCode, Header and Body hold what the authenticator wrote
+		StatusCode int
+		Header     http.Header
+		Body       []byte
+	}
+
+	// recorder buffers the response of the authenticator so the framework can render it its own way
+	recorder struct {
+		header     http.Header
+		statusCode int
+		body       bytes.Buffer
+	}
+)
+
+// Authenticate runs the authenticator middleware with a terminal handler which records the forwarded request
+func Authenticate(authenticator turboAuth.Authenticator, r *http.Request) *Result {
+	rec := &recorder{header: make(http.Header)}
+	result := &Result{}
+	authenticator.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result.Authenticated = true
+		result.Request = r
+		result.Identity, _ = turboAuth.IdentityFromContext(r.Context())
+	})).ServeHTTP(rec, r)
+
+	result.Header = rec.header
+	result.Body = rec.body.Bytes()
+	result.StatusCode = rec.statusCode
+	if result.StatusCode == 0 {
+		if result.Authenticated {
+			result.StatusCode = http.StatusOK
+		} else {
+			result.StatusCode = http.StatusUnauthorized
+		}
+	}
+	return result
+}
+
+// Message is the error message written by the authenticator, or the status text when nothing was written
+func (result *Result) Message() string {
+	message := strings.TrimSpace(string(result.Body))
+	if message == "" {
+		message = http.StatusText(result.StatusCode)
+	}
+	return message
+}
+
+// CopyHeaders forwards the headers set by the authenticator (refreshed cookies, challenges ...)
+func (result *Result) CopyHeaders(dst http.Header) {
+	for key, values := range result.Header {
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+func (rec *recorder) WriteHeader(statusCode int) {
+	if rec.statusCode == 0 {
+		rec.statusCode = statusCode
+	}
+}
@@ -0,0 +1,44 @@
+package adapter
+
+import (
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	authConfig := jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	tests := []struct {
+		name          string
+		token         string
+		authenticated bool
+		statusCode    int
+		subject       string
+	}{
+		{name: "Test_valid_token", token: token, authenticated: true, statusCode: http.StatusOK, subject: "test_user"},
+		{name: "Test_invalid_token", token: "invalid", authenticated: false, statusCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(authConfig.AuthTokenName, tt.token)
+			result := Authenticate(authConfig, r)
+			if result.Authenticated != tt.authenticated || result.StatusCode != tt.statusCode {
+				t.Errorf("Authenticate() = %v %v, want %v %v", result.Authenticated, result.StatusCode, tt.authenticated, tt.statusCode)
+			}
+			if tt.authenticated && (result.Identity == nil || result.Identity.Subject != tt.subject) {
+				t.Errorf("Authenticate() identity = %+v, want subject %v", result.Identity, tt.subject)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package messaging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/internal/secret"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHeader carries "Bearer <token>", the lower case name suits the brokers with case sensitive headers
+	DefaultHeader = "authorization"
+	BearerScheme  = "bearer"
+	// HeaderMessageID and HeaderTimestamp carry the id and the unix time of the message, both are covered by the
+	// digest the token is bound to
+	HeaderMessageID = "message-id"
+	HeaderTimestamp = "message-timestamp"
+	// DigestClaim carries the digest of the message, the bsh claim of jwt.IssueBodyBoundToken
+	DigestClaim = "bsh"
+	// DefaultMaxAge bounds the age of the messages accepted by the Authenticator
+	DefaultMaxAge = 5 * time.Minute
+)
+
+var (
+	// ErrMessageDigestMismatch is returned when the message does not match the digest its token is bound to
+	ErrMessageDigestMismatch = errors.New("message does not match its digest")
+	// ErrMessageExpired is returned when the timestamp of the message is older than the MaxAge or in the future
+	ErrMessageExpired = errors.New("message expired")
+)
+
+type (
+	// Carrier reads and writes the headers of a message, the adapters of the common brokers are MapCarrier,
+	// HeaderCarrier (NATS), TableCarrier (AMQP) and RecordHeaders (Kafka)
+	Carrier interface {
+		Get(key string) string
+		Set(key string, value string)
+	}
+
+	// MapCarrier adapts the plain string headers
+	MapCarrier map[string]string
+
+	// HeaderCarrier adapts the multi-valued headers, e.g. nats.Header
+	HeaderCarrier http.Header
+
+	// TableCarrier adapts the AMQP 0.9.1 header tables, e.g. amqp.Table
+	TableCarrier map[string]interface{}
+
+	// RecordHeader is a Kafka record header, copied from and to the headers of the client library
+	RecordHeader struct {
+		Key   string
+		Value []byte
+	}
+
+	// RecordHeaders adapts the Kafka record headers
+	RecordHeaders []RecordHeader
+
+	// TokenSource returns a token of the producer bound to the digest of the message in its DigestClaim, e.g.
+	// with jwt.IssueBodyBoundToken. It is called for every message so that a token is never valid for another one
+	TokenSource func(ctx context.Context, digest string) (string, error)
+
+	// Authenticator authenticates the messages with a TokenAuthenticator, the jwt provider applies the same
+	// signature, expiry and revocation checks as to the http requests. The token must be bound to the digest of
+	// the payload, the id and the timestamp of the message, the consumers deduplicating on the id are protected
+	// against the replays within the MaxAge
+	Authenticator struct {
+		Tokens turboAuth.TokenAuthenticator
+		// Header carries the token, DefaultHeader when empty
+		Header string
+		// MaxAge bounds the age of the messages, DefaultMaxAge when 0
+		MaxAge time.Duration
+		// Now returns the current time, time.Now when nil
+		Now func() time.Time
+	}
+
+	// Handler processes a message, the context carries the identity of the producer
+	Handler func(ctx context.Context, carrier Carrier, payload []byte) error
+)
+
+func NewAuthenticator(tokens turboAuth.TokenAuthenticator) *Authenticator {
+	return &Authenticator{Tokens: tokens, Header: DefaultHeader, MaxAge: DefaultMaxAge}
+}
+
+// Digest returns the base64url SHA-256 of the id, the unix timestamp and the payload of a message
+func Digest(id string, timestamp string, payload []byte) string {
+	sum := sha256.New()
+	sum.Write([]byte(id + "\n" + timestamp + "\n"))
+	sum.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(sum.Sum(nil))
+}
+
+// Sign stamps the message with its id and the current time and sets the token of the source, bound to the digest
+// of the message, in the header, DefaultHeader when empty
+func Sign(ctx context.Context, carrier Carrier, header string, id string, payload []byte, source TokenSource) error {
+	if id == "" {
+		return errors.New("message id is required")
+	}
+	if header == "" {
+		header = DefaultHeader
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	token, err := source(ctx, Digest(id, timestamp, payload))
+	if err != nil {
+		return err
+	}
+	carrier.Set(HeaderMessageID, id)
+	carrier.Set(HeaderTimestamp, timestamp)
+	carrier.Set(header, "Bearer "+token)
+	return nil
+}
+
+// Sign stamps the message and sets the token of the source in the Header of the Authenticator
+func (a *Authenticator) Sign(ctx context.Context, carrier Carrier, id string, payload []byte, source TokenSource) error {
+	return Sign(ctx, carrier, a.header(), id, payload, source)
+}
+
+// Token reads the token of the header, the bearer scheme is optional
+func Token(carrier Carrier, header string) string {
+	value := strings.TrimSpace(carrier.Get(header))
+	l := len(BearerScheme)
+	if len(value) > l+1 && strings.EqualFold(value[:l], BearerScheme) && value[l] == ' ' {
+		return strings.TrimSpace(value[l+1:])
+	}
+	return value
+}
+
+// Authenticate validates the token of the message, checks it is bound to the message and returns the context
+// carrying its identity
+func (a *Authenticator) Authenticate(ctx context.Context, carrier Carrier, payload []byte) (context.Context, error) {
+	token := Token(carrier, a.header())
+	if token == "" {
+		return nil, turboError.ErrMissingToken
+	}
+	identity, err := turboAuth.AuthenticateContext(ctx, a.Tokens, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.verify(carrier, payload, identity); err != nil {
+		return nil, err
+	}
+	return turboAuth.NewContext(ctx, identity), nil
+}
+
+// verify checks the timestamp of the message is within the MaxAge and the message matches the digest of the token
+func (a *Authenticator) verify(carrier Carrier, payload []byte, identity *turboAuth.Identity) error {
+	bound, _ := identity.Claims[DigestClaim].(string)
+	if bound == "" {
+		return messageError("token is not bound to the message")
+	}
+	id, timestamp := carrier.Get(HeaderMessageID), carrier.Get(HeaderTimestamp)
+	if id == "" {
+		return messageError("missing message id")
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return messageError("malformed message timestamp")
+	}
+	maxAge := a.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	if age := a.now().Sub(time.Unix(unix, 0)); age > maxAge || age < -maxAge {
+		return turboError.Wrap(turboError.ErrTokenInvalid, ErrMessageExpired)
+	}
+	if !secret.Equal(Digest(id, timestamp, payload), bound) {
+		return turboError.Wrap(turboError.ErrTokenInvalid, ErrMessageDigestMismatch)
+	}
+	return nil
+}
+
+// Wrap authenticates the messages before the handler, the error of the authentication is returned for the consumer
+// to reject or dead-letter the message
+func (a *Authenticator) Wrap(next Handler) Handler {
+	return func(ctx context.Context, carrier Carrier, payload []byte) error {
+		ctx, err := a.Authenticate(ctx, carrier, payload)
+		if err != nil {
+			return err
+		}
+		return next(ctx, carrier, payload)
+	}
+}
+
+func (a *Authenticator) now() time.Time {
+	if a.Now == nil {
+		return time.Now()
+	}
+	return a.Now()
+}
+
+func messageError(reason string) error {
+	return turboError.Wrap(turboError.ErrTokenInvalid, fmt.Errorf("%w: %s", ErrMessageDigestMismatch, reason))
+}
+
+func (a *Authenticator) header() string {
+	if a.Header == "" {
+		return DefaultHeader
+	}
+	return a.Header
+}
+
+func (c MapCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c MapCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c HeaderCarrier) Get(key string) string {
+	return http.Header(c).Get(key)
+}
+
+func (c HeaderCarrier) Set(key string, value string) {
+	http.Header(c).Set(key, value)
+}
+
+func (c TableCarrier) Get(key string) string {
+	switch value := c[key].(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	default:
+		return ""
+	}
+}
+
+func (c TableCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+// Get returns the last header of the key, Kafka allows repeated keys
+func (c *RecordHeaders) Get(key string) string {
+	for i := len(*c) - 1; i >= 0; i-- {
+		if (*c)[i].Key == key {
+			return string((*c)[i].Value)
+		}
+	}
+	return ""
+}
+
+// Set replaces the headers of the key
+func (c *RecordHeaders) Set(key string, value string) {
+	headers := (*c)[:0]
+	for _, header := range *c {
+		if header.Key != key {
+			headers = append(headers, header)
+		}
+	}
+	*c = append(headers, RecordHeader{Key: key, Value: []byte(value)})
+}
@@ -0,0 +1,129 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCarriers(t *testing.T) {
+	tests := []struct {
+		name    string
+		carrier Carrier
+	}{
+		{name: "Test_map", carrier: MapCarrier{}},
+		{name: "Test_header", carrier: HeaderCarrier(http.Header{})},
+		{name: "Test_table", carrier: TableCarrier{}},
+		{name: "Test_record_headers", carrier: &RecordHeaders{{Key: DefaultHeader, Value: []byte("stale")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var digest string
+			source := func(ctx context.Context, d string) (string, error) {
+				digest = d
+				return "a.b.c", nil
+			}
+			if err := Sign(context.Background(), tt.carrier, "", "m-1", []byte("payload"), source); err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+			if got := Token(tt.carrier, DefaultHeader); got != "a.b.c" {
+				t.Errorf("Token() = %v, want a.b.c", got)
+			}
+			if got := Digest(tt.carrier.Get(HeaderMessageID), tt.carrier.Get(HeaderTimestamp), []byte("payload")); got != digest {
+				t.Errorf("Digest() = %v, want the signed %v", got, digest)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_Wrap(t *testing.T) {
+	authConfig := jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		Revoker:       jwt.NewMemoryRevoker(),
+	})
+	source := func(ctx context.Context, digest string) (string, error) {
+		token, jwtErr := authConfig.IssueBodyBoundToken("producer", time.Minute, digest)
+		if jwtErr != nil {
+			return "", jwtErr
+		}
+		return token, nil
+	}
+	authenticator := NewAuthenticator(authConfig)
+	signed := func(id string, payload string) MapCarrier {
+		carrier := MapCarrier{}
+		if err := authenticator.Sign(context.Background(), carrier, id, []byte(payload), source); err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return carrier
+	}
+	unbound, jwtErr := authConfig.IssueNewToken("producer", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	revoked := signed("m-revoked", "payload")
+	identity, err := authConfig.Authenticate(Token(revoked, DefaultHeader))
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if err := authConfig.Revoker.Revoke(identity.TokenID, identity.ExpiresAt); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	replayed := signed("m-1", "payload")
+	replayed[HeaderMessageID] = "m-2"
+	stale := signed("m-1", "payload")
+	stale[HeaderTimestamp] = strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name    string
+		headers MapCarrier
+		payload string
+		wantErr error
+	}{
+		{name: "Test_authenticated", headers: signed("m-1", "payload"), payload: "payload"},
+		{name: "Test_missing_token", headers: MapCarrier{}, payload: "payload", wantErr: turboError.ErrMissingToken},
+		{name: "Test_revoked_token", headers: revoked, payload: "payload", wantErr: turboError.ErrTokenRevoked},
+		{name: "Test_unbound_token", headers: MapCarrier{DefaultHeader: "Bearer " + unbound}, payload: "payload",
+			wantErr: ErrMessageDigestMismatch},
+		{name: "Test_substituted_payload", headers: signed("m-1", "payload"), payload: "other",
+			wantErr: ErrMessageDigestMismatch},
+		{name: "Test_substituted_id", headers: replayed, payload: "payload", wantErr: ErrMessageDigestMismatch},
+		{name: "Test_stale_message", headers: stale, payload: "payload", wantErr: ErrMessageExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var subject string
+			handler := authenticator.Wrap(func(ctx context.Context, carrier Carrier, payload []byte) error {
+				identity, _ := turboAuth.IdentityFromContext(ctx)
+				subject = identity.Subject
+				return nil
+			})
+			err := handler(context.Background(), tt.headers, []byte(tt.payload))
+			if tt.wantErr == nil && (err != nil || subject != "producer") {
+				t.Errorf("handler error = %v, subject = %v, want producer", err, subject)
+			}
+			if tt.wantErr != nil && (!errors.Is(err, tt.wantErr) || subject != "") {
+				t.Errorf("handler error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_Sign_header(t *testing.T) {
+	authenticator := &Authenticator{Header: "x-token"}
+	carrier := MapCarrier{}
+	source := func(ctx context.Context, digest string) (string, error) { return "a.b.c", nil }
+	if err := authenticator.Sign(context.Background(), carrier, "m-1", nil, source); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if carrier["x-token"] != "Bearer a.b.c" || carrier[DefaultHeader] != "" {
+		t.Errorf("headers = %v, want the token in x-token", carrier)
+	}
+}
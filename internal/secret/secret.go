@@ -0,0 +1,21 @@
+package secret
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+// Equal compares the secrets in constant time, the SHA-256 digests are compared rather than the values so that
+// neither the content nor the length of the expected secret is revealed by the timing
+func Equal(presented string, expected string) bool {
+	a := sha256.Sum256([]byte(presented))
+	b := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// EqualBytes is Equal for byte slices
+func EqualBytes(presented []byte, expected []byte) bool {
+	a := sha256.Sum256(presented)
+	b := sha256.Sum256(expected)
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
@@ -0,0 +1,54 @@
+package secret
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		name      string
+		presented string
+		expected  string
+		want      bool
+	}{
+		{name: "Test_equal", presented: "s3cr3t", expected: "s3cr3t", want: true},
+		{name: "Test_empty", want: true},
+		{name: "Test_first_byte", presented: "x3cr3t", expected: "s3cr3t"},
+		{name: "Test_last_byte", presented: "s3cr3x", expected: "s3cr3t"},
+		{name: "Test_prefix", presented: "s3cr", expected: "s3cr3t"},
+		{name: "Test_empty_presented", expected: "s3cr3t"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Equal(tt.presented, tt.expected); got != tt.want {
+				t.Errorf("Equal() = %v, want %v", got, tt.want)
+			}
+			if got := EqualBytes([]byte(tt.presented), []byte(tt.expected)); got != tt.want {
+				t.Errorf("EqualBytes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkEqual compares the mismatches at the first and last byte and of another length, the timings do not
+// depend on where the secrets differ
+func BenchmarkEqual(b *testing.B) {
+	expected := strings.Repeat("s", 64)
+	benchmarks := []struct {
+		name      string
+		presented string
+	}{
+		{name: "equal", presented: expected},
+		{name: "first_byte", presented: "x" + expected[1:]},
+		{name: "last_byte", presented: expected[:63] + "x"},
+		{name: "shorter", presented: expected[:8]},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Equal(bm.presented, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Credentials are the AWS credentials signing the requests, SessionToken is set for the temporary credentials
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+const (
+	Algorithm           = "AWS4-HMAC-SHA256"
+	DateFormat          = "20060102T150405Z"
+	HeaderDate          = "X-Amz-Date"
+	HeaderSecurityToken = "X-Amz-Security-Token"
+)
+
+// Sign adds the Signature Version 4 authorization of the request, all the headers set so far are signed
+func Sign(req *http.Request, body []byte, credentials Credentials, region string, service string, now time.Time) {
+	amzDate := now.UTC().Format(DateFormat)
+	req.Header.Set(HeaderDate, amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set(HeaderSecurityToken, credentials.SessionToken)
+	}
+	names := []string{"host"}
+	for name := range req.Header {
+		if !strings.EqualFold(name, "Authorization") {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := req.Host
+		if name != "host" {
+			value = strings.Join(req.Header.Values(name), ",")
+		} else if value == "" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hashHex(body),
+	}, "\n")
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), amzDate[:8])
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", Algorithm+" Credential="+credentials.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
+
+// SignedHeaders returns the lower case SignedHeaders of the authorization
+func SignedHeaders(authorization string) []string {
+	for _, part := range strings.Split(authorization, ",") {
+		part = strings.TrimSpace(part)
+		if index := strings.Index(part, "SignedHeaders="); index >= 0 {
+			return strings.Split(part[index+len("SignedHeaders="):], ";")
+		}
+	}
+	return nil
+}
+
+func hashHex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, content string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(content))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,139 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/nandlabs/turbo-auth/internal/sigv4"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// AWSSigner signs with an asymmetric key of AWS KMS, KeyARN is the ARN or the alias ARN of the key
+	AWSSigner struct {
+		KeyARN string
+		Method string
+		Region string
+		// Endpoint is https://kms.<region>.amazonaws.com when empty
+		Endpoint string
+		// Credentials is called for every request, the temporary credentials of IRSA or the instance metadata are
+		// refreshed by the provider
+		Credentials CredentialsProvider
+		Client      *http.Client
+	}
+
+	// AWSCredentials are the credentials signing the KMS requests, SessionToken is set for the temporary credentials
+	AWSCredentials = sigv4.Credentials
+
+	// CredentialsProvider returns the current AWS credentials, e.g. from the web identity or the instance metadata
+	CredentialsProvider func(ctx context.Context) (AWSCredentials, error)
+
+	awsSignResponse struct {
+		Signature string `json:"Signature"`
+	}
+
+	awsPublicKeyResponse struct {
+		PublicKey string `json:"PublicKey"`
+	}
+)
+
+// awsAlgorithms maps the JWS algorithms to the KMS signing algorithms
+var awsAlgorithms = map[string]string{
+	"RS256": "RSASSA_PKCS1_V1_5_SHA_256",
+	"RS384": "RSASSA_PKCS1_V1_5_SHA_384",
+	"RS512": "RSASSA_PKCS1_V1_5_SHA_512",
+	"ES256": "ECDSA_SHA_256",
+	"ES384": "ECDSA_SHA_384",
+	"ES512": "ECDSA_SHA_512",
+}
+
+func NewAWSSigner(keyARN string, signingMethod string, region string, credentials CredentialsProvider) *AWSSigner {
+	return &AWSSigner{KeyARN: keyARN, Method: signingMethod, Region: region, Credentials: credentials}
+}
+
+// StaticCredentials is the CredentialsProvider of long lived credentials
+func StaticCredentials(credentials AWSCredentials) CredentialsProvider {
+	return func(context.Context) (AWSCredentials, error) {
+		return credentials, nil
+	}
+}
+
+func (a *AWSSigner) KeyID() string {
+	return a.KeyARN
+}
+
+func (a *AWSSigner) SigningMethod() string {
+	return a.Method
+}
+
+func (a *AWSSigner) Sign(signingString string) ([]byte, error) {
+	return a.SignContext(context.Background(), signingString)
+}
+
+// SignContext sends the digest of the signing string to kms:Sign
+func (a *AWSSigner) SignContext(ctx context.Context, signingString string) ([]byte, error) {
+	algorithm, ok := awsAlgorithms[a.Method]
+	if !ok {
+		return nil, ErrUnsupportedMethod
+	}
+	sum, err := digest(a.Method, signingString)
+	if err != nil {
+		return nil, err
+	}
+	var response awsSignResponse
+	if err := a.call(ctx, "Sign", map[string]string{
+		"KeyId":            a.KeyARN,
+		"Message":          base64.StdEncoding.EncodeToString(sum),
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": algorithm,
+	}, &response); err != nil {
+		return nil, err
+	}
+	signature, err := base64.StdEncoding.DecodeString(response.Signature)
+	if err != nil {
+		return nil, err
+	}
+	return jwsSignature(a.Method, signature)
+}
+
+// PublicKey fetches the DER public key of kms:GetPublicKey
+func (a *AWSSigner) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	var response awsPublicKeyResponse
+	if err := a.call(ctx, "GetPublicKey", map[string]string{"KeyId": a.KeyARN}, &response); err != nil {
+		return nil, err
+	}
+	der, err := base64.StdEncoding.DecodeString(response.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return parseDERPublicKey(der)
+}
+
+// call sends a SigV4 signed request of the KMS json protocol
+func (a *AWSSigner) call(ctx context.Context, action string, body interface{}, v interface{}) error {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = "https://kms." + a.Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	credentials, err := a.Credentials(ctx)
+	if err != nil {
+		return err
+	}
+	sigv4.Sign(req, content, credentials, a.Region, "kms", time.Now())
+	return do(a.Client, req, v)
+}
@@ -0,0 +1,75 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	turboJwt "github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"strings"
+)
+
+type (
+	// AzureSigner signs with a key of Azure Key Vault, KeyURL is https://<vault>.vault.azure.net/keys/<name>/<version>
+	AzureSigner struct {
+		KeyURL string
+		Method string
+		// TokenSource returns the access tokens of the https://vault.azure.net resource
+		TokenSource TokenSource
+		Client      *http.Client
+	}
+
+	azureSignResponse struct {
+		Value string `json:"value"`
+	}
+
+	azureKeyResponse struct {
+		Key turboJwt.JWK `json:"key"`
+	}
+)
+
+const AzureAPIVersion = "7.4"
+
+func NewAzureSigner(keyURL string, signingMethod string, tokenSource TokenSource) *AzureSigner {
+	return &AzureSigner{KeyURL: keyURL, Method: signingMethod, TokenSource: tokenSource}
+}
+
+func (a *AzureSigner) KeyID() string {
+	return a.KeyURL
+}
+
+func (a *AzureSigner) SigningMethod() string {
+	return a.Method
+}
+
+func (a *AzureSigner) Sign(signingString string) ([]byte, error) {
+	return a.SignContext(context.Background(), signingString)
+}
+
+// SignContext sends the digest of the signing string to the sign operation, Key Vault returns the JWS signature format
+func (a *AzureSigner) SignContext(ctx context.Context, signingString string) ([]byte, error) {
+	sum, err := digest(a.Method, signingString)
+	if err != nil {
+		return nil, err
+	}
+	var response azureSignResponse
+	if err := callJSON(ctx, a.Client, a.TokenSource, http.MethodPost, a.url("/sign"),
+		map[string]string{"alg": a.Method, "value": base64.RawURLEncoding.EncodeToString(sum)}, &response); err != nil {
+		return nil, err
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(response.Value, "="))
+}
+
+// PublicKey fetches the JWK of the key, the HSM key types are read as their software counterparts
+func (a *AzureSigner) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	var response azureKeyResponse
+	if err := callJSON(ctx, a.Client, a.TokenSource, http.MethodGet, a.url(""), nil, &response); err != nil {
+		return nil, err
+	}
+	response.Key.Kty = strings.TrimSuffix(response.Key.Kty, "-HSM")
+	return response.Key.PublicKey()
+}
+
+func (a *AzureSigner) url(suffix string) string {
+	return strings.TrimSuffix(a.KeyURL, "/") + suffix + "?api-version=" + AzureAPIVersion
+}
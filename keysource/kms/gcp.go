@@ -0,0 +1,85 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+type (
+	// GCPSigner signs with an asymmetric key version of Cloud KMS, KeyName is the resource name
+	// projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>/cryptoKeyVersions/<version>
+	GCPSigner struct {
+		KeyName string
+		Method  string
+		// Endpoint is DefaultGCPEndpoint when empty
+		Endpoint    string
+		TokenSource TokenSource
+		Client      *http.Client
+	}
+
+	gcpSignResponse struct {
+		Signature string `json:"signature"`
+	}
+
+	gcpPublicKeyResponse struct {
+		PEM string `json:"pem"`
+	}
+)
+
+const DefaultGCPEndpoint = "https://cloudkms.googleapis.com"
+
+func NewGCPSigner(keyName string, signingMethod string, tokenSource TokenSource) *GCPSigner {
+	return &GCPSigner{KeyName: keyName, Method: signingMethod, TokenSource: tokenSource}
+}
+
+func (g *GCPSigner) KeyID() string {
+	return g.KeyName
+}
+
+func (g *GCPSigner) SigningMethod() string {
+	return g.Method
+}
+
+func (g *GCPSigner) Sign(signingString string) ([]byte, error) {
+	return g.SignContext(context.Background(), signingString)
+}
+
+// SignContext sends the digest of the signing string to asymmetricSign
+func (g *GCPSigner) SignContext(ctx context.Context, signingString string) ([]byte, error) {
+	sum, err := digest(g.Method, signingString)
+	if err != nil {
+		return nil, err
+	}
+	digestName := "sha" + strings.TrimLeft(g.Method, "RSEP")
+	var response gcpSignResponse
+	if err := callJSON(ctx, g.Client, g.TokenSource, http.MethodPost, g.url(":asymmetricSign"),
+		map[string]interface{}{"digest": map[string]string{digestName: base64.StdEncoding.EncodeToString(sum)}},
+		&response); err != nil {
+		return nil, err
+	}
+	signature, err := base64.StdEncoding.DecodeString(response.Signature)
+	if err != nil {
+		return nil, err
+	}
+	return jwsSignature(g.Method, signature)
+}
+
+// PublicKey fetches the PEM public key of the key version
+func (g *GCPSigner) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	var response gcpPublicKeyResponse
+	if err := callJSON(ctx, g.Client, g.TokenSource, http.MethodGet, g.url("/publicKey"), nil, &response); err != nil {
+		return nil, err
+	}
+	return parsePEMPublicKey(response.PEM)
+}
+
+func (g *GCPSigner) url(suffix string) string {
+	endpoint := g.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultGCPEndpoint
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/v1/" + strings.Trim(g.KeyName, "/") + suffix
+}
@@ -0,0 +1,179 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	turboJwt "github.com/nandlabs/turbo-auth/providers/jwt"
+	"hash"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// Signer is a non exportable key of a cloud KMS, it signs the tokens as the SignKey of the jwt.Key built by
+	// NewKey
+	Signer interface {
+		turboJwt.ContextSigner
+		// KeyID is the ARN, resource name or url of the key in the KMS
+		KeyID() string
+		// SigningMethod is the JWS algorithm of the key, e.g. ES256
+		SigningMethod() string
+		// PublicKey fetches the verification key
+		PublicKey(ctx context.Context) (crypto.PublicKey, error)
+	}
+
+	// TokenSource returns the OAuth2 access token of the KMS api calls, e.g. from the metadata server or the
+	// golang.org/x/oauth2 token sources
+	TokenSource func(ctx context.Context) (string, error)
+
+	ecdsaSignature struct {
+		R, S *big.Int
+	}
+)
+
+const defaultTimeout = 10 * time.Second
+
+var ErrUnsupportedMethod = errors.New("kms: unsupported signing method")
+
+// NewKey fetches the public key of the signer, the kid is derived from the KeyID with DeriveKid
+func NewKey(ctx context.Context, signer Signer) (*turboJwt.Key, error) {
+	publicKey, err := signer.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &turboJwt.Key{
+		ID:            DeriveKid(signer.KeyID()),
+		SigningMethod: signer.SigningMethod(),
+		SignKey:       signer,
+		VerifyKey:     publicKey,
+	}, nil
+}
+
+// NewKeySource adapts the signer to the jwt.KeySource of a jwt.KeyManager, the kid is stable so the rotations only
+// pick up a changed key
+func NewKeySource(signer Signer) turboJwt.KeySource {
+	return turboJwt.KeySourceFunc(func() (*turboJwt.Key, error) {
+		return NewKey(context.Background(), signer)
+	})
+}
+
+// DeriveKid hashes the key id of the KMS, the ARNs and resource names are not published in the token headers
+func DeriveKid(keyID string) string {
+	sum := sha256.Sum256([]byte(keyID))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// digest hashes the signing string with the hash of the signing method
+func digest(signingMethod string, signingString string) ([]byte, error) {
+	var h hash.Hash
+	switch strings.TrimLeft(signingMethod, "RSEP") {
+	case "256":
+		h = sha256.New()
+	case "384":
+		h = sha512.New384()
+	case "512":
+		h = sha512.New()
+	default:
+		return nil, ErrUnsupportedMethod
+	}
+	h.Write([]byte(signingString))
+	return h.Sum(nil), nil
+}
+
+// jwsSignature converts the ASN.1 DER ecdsa signatures of the KMS into the fixed size r || s of the JWS, the rsa
+// signatures are returned as is
+func jwsSignature(signingMethod string, signature []byte) ([]byte, error) {
+	var size int
+	switch signingMethod {
+	case "ES256":
+		size = 32
+	case "ES384":
+		size = 48
+	case "ES512":
+		size = 66
+	default:
+		return signature, nil
+	}
+	var parsed ecdsaSignature
+	if rest, err := asn1.Unmarshal(signature, &parsed); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("kms: malformed ecdsa signature")
+	}
+	r, s := parsed.R.Bytes(), parsed.S.Bytes()
+	if len(r) > size || len(s) > size {
+		return nil, fmt.Errorf("kms: malformed ecdsa signature")
+	}
+	out := make([]byte, 2*size)
+	copy(out[size-len(r):size], r)
+	copy(out[2*size-len(s):], s)
+	return out, nil
+}
+
+func parsePEMPublicKey(content string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(content))
+	if block == nil {
+		return nil, errors.New("kms: no PEM public key")
+	}
+	return parseDERPublicKey(block.Bytes)
+}
+
+func parseDERPublicKey(der []byte) (crypto.PublicKey, error) {
+	return x509.ParsePKIXPublicKey(der)
+}
+
+// callJSON sends the json body with the bearer token of the source when set and decodes the json response into v
+func callJSON(ctx context.Context, client *http.Client, tokenSource TokenSource, method string, endpoint string,
+	body interface{}, v interface{}) error {
+	var content []byte
+	if body != nil {
+		var err error
+		if content, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if tokenSource != nil {
+		token, err := tokenSource(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return do(client, req, v)
+}
+
+func do(client *http.Client, req *http.Request, v interface{}) error {
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("kms: %s returned %s %s", req.URL.Host, res.Status, bytes.TrimSpace(message))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
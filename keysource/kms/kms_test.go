@@ -0,0 +1,218 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/nandlabs/turbo-auth/internal/sigv4"
+	turboJwt "github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newKMSServer fakes the AWS KMS, Cloud KMS and Key Vault apis, the ecdsa keys sign the digests in ASN.1 DER like
+// AWS and GCP, or in the JWS format like Key Vault
+func newKMSServer(t *testing.T) *httptest.Server {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	signDER := func(digest []byte) []byte {
+		r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest)
+		signature, _ := asn1.Marshal(ecdsaSignature{R: r, S: s})
+		return signature
+	}
+	reply := func(w http.ResponseWriter, v interface{}) {
+		_ = json.NewEncoder(w).Encode(v)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		switch {
+		case r.Header.Get("X-Amz-Target") != "":
+			if !strings.HasPrefix(r.Header.Get("Authorization"), sigv4.Algorithm+" Credential=AKID/") {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			switch r.Header.Get("X-Amz-Target") {
+			case "TrentService.GetPublicKey":
+				reply(w, map[string]string{"PublicKey": base64.StdEncoding.EncodeToString(der)})
+			case "TrentService.Sign":
+				digest, _ := base64.StdEncoding.DecodeString(body["Message"].(string))
+				if body["MessageType"] != "DIGEST" || body["SigningAlgorithm"] != "ECDSA_SHA_256" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				reply(w, map[string]string{"Signature": base64.StdEncoding.EncodeToString(signDER(digest))})
+			}
+		case r.Header.Get("Authorization") != "Bearer access-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case strings.HasSuffix(r.URL.Path, "/publicKey"):
+			reply(w, map[string]string{"pem": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))})
+		case strings.HasSuffix(r.URL.Path, ":asymmetricSign"):
+			digest, _ := base64.StdEncoding.DecodeString(body["digest"].(map[string]interface{})["sha256"].(string))
+			reply(w, map[string]string{"signature": base64.StdEncoding.EncodeToString(signDER(digest))})
+		case r.URL.Path == "/keys/jwt/v1" && r.URL.Query().Get("api-version") == AzureAPIVersion:
+			jwk, _ := turboJwt.NewJWK(&turboJwt.Key{ID: "jwt", SigningMethod: "RS256", VerifyKey: &rsaKey.PublicKey})
+			jwk.Kty = "RSA-HSM"
+			reply(w, map[string]interface{}{"key": jwk})
+		case r.URL.Path == "/keys/jwt/v1/sign":
+			digest, _ := base64.RawURLEncoding.DecodeString(body["value"].(string))
+			signature, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest)
+			reply(w, map[string]string{"kid": "jwt", "value": base64.RawURLEncoding.EncodeToString(signature)})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewKey(t *testing.T) {
+	server := newKMSServer(t)
+	tokenSource := func(context.Context) (string, error) {
+		return "access-token", nil
+	}
+	aws := NewAWSSigner("arn:aws:kms:eu-west-1:123456789012:key/1234abcd", "ES256", "eu-west-1",
+		StaticCredentials(AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}))
+	aws.Endpoint = server.URL
+	gcp := NewGCPSigner("projects/p/locations/global/keyRings/r/cryptoKeys/jwt/cryptoKeyVersions/1", "ES256", tokenSource)
+	gcp.Endpoint = server.URL
+	unauthorized := NewGCPSigner(gcp.KeyName, "ES256", func(context.Context) (string, error) {
+		return "expired", nil
+	})
+	unauthorized.Endpoint = server.URL
+
+	tests := []struct {
+		name    string
+		signer  Signer
+		wantErr bool
+	}{
+		{name: "Test_aws_kms", signer: aws},
+		{name: "Test_gcp_kms", signer: gcp},
+		{name: "Test_azure_key_vault", signer: NewAzureSigner(server.URL+"/keys/jwt/v1", "RS256", tokenSource)},
+		{name: "Test_unauthorized", signer: unauthorized, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := NewKey(context.Background(), tt.signer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if key.ID != DeriveKid(tt.signer.KeyID()) || strings.Contains(key.ID, "/") {
+				t.Errorf("kid = %v", key.ID)
+			}
+			keyRing, err := turboJwt.NewKeyRing(key)
+			if err != nil {
+				t.Fatalf("NewKeyRing() error = %v", err)
+			}
+			authConfig := turboJwt.CreateJwtAuthenticator(&turboJwt.JwtAuthConfig{BearerTokens: true, KeyStore: keyRing})
+			token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+			if jwtErr != nil {
+				t.Fatalf("IssueNewToken() error = %v", jwtErr)
+			}
+			if identity, err := authConfig.Authenticate(token); err != nil || identity.Subject != "test_user" {
+				t.Errorf("Authenticate() = %+v, error = %v", identity, err)
+			}
+		})
+	}
+}
+
+func TestAWSSigner_SignContext(t *testing.T) {
+	server := newKMSServer(t)
+	var calls int
+	aws := NewAWSSigner("arn:aws:kms:eu-west-1:123456789012:key/1234abcd", "ES256", "eu-west-1",
+		func(ctx context.Context) (AWSCredentials, error) {
+			calls++
+			if calls > 2 {
+				return AWSCredentials{}, errors.New("credentials expired")
+			}
+			return AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "session"}, nil
+		})
+	aws.Endpoint = server.URL
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		wantErr bool
+	}{
+		{name: "Test_signed", ctx: context.Background()},
+		{name: "Test_cancelled", ctx: cancelled, wantErr: true},
+		{name: "Test_credentials_error", ctx: context.Background(), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature, err := aws.SignContext(tt.ctx, "header.payload")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SignContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(signature) != 64 {
+				t.Errorf("len = %v, want 64", len(signature))
+			}
+		})
+	}
+	if calls != 3 {
+		t.Errorf("credentials calls = %v, want 3", calls)
+	}
+}
+
+func TestJwsSignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		signature []byte
+		wantLen   int
+		wantErr   bool
+	}{
+		{name: "Test_rsa_unchanged", method: "RS256", signature: []byte{1, 2, 3}, wantLen: 3},
+		{name: "Test_es384", method: "ES384", signature: mustDER(t, 48), wantLen: 96},
+		{name: "Test_malformed", method: "ES256", signature: []byte{1, 2, 3}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature, err := jwsSignature(tt.method, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("jwsSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(signature) != tt.wantLen {
+				t.Errorf("len = %v, want %v", len(signature), tt.wantLen)
+			}
+		})
+	}
+}
+
+func mustDER(t *testing.T, size int) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, key, make([]byte, size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, _ := asn1.Marshal(ecdsaSignature{R: r, S: s})
+	return signature
+}
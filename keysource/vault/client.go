@@ -0,0 +1,163 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nandlabs/turbo-auth/logging"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Client is a minimal Vault http api client authenticated with a token
+	Client struct {
+		Address string
+		Token   string
+		// Namespace is the Vault Enterprise namespace, optional
+		Namespace  string
+		HTTPClient *http.Client
+	}
+
+	// response is the envelope of the Vault api responses
+	response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+		Auth   *struct {
+			LeaseDuration int  `json:"lease_duration"`
+			Renewable     bool `json:"renewable"`
+		} `json:"auth"`
+	}
+)
+
+const defaultTimeout = 10 * time.Second
+
+var logger = logging.Get()
+
+func NewClient(address string, token string) *Client {
+	return &Client{
+		Address:    strings.TrimSuffix(address, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// NewClientFromEnv reads the VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE variables of the Vault cli
+func NewClientFromEnv() (*Client, error) {
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		return nil, fmt.Errorf("vault: VAULT_ADDR is not set")
+	}
+	client := NewClient(address, os.Getenv("VAULT_TOKEN"))
+	client.Namespace = os.Getenv("VAULT_NAMESPACE")
+	return client, nil
+}
+
+// RenewToken extends the lease of the client token by increment, the ttl granted by Vault is returned
+func (c *Client) RenewToken(ctx context.Context, increment time.Duration) (time.Duration, error) {
+	body := map[string]interface{}{}
+	if increment > 0 {
+		body["increment"] = int(increment.Seconds())
+	}
+	res, err := c.call(ctx, http.MethodPost, "auth/token/renew-self", body)
+	if err != nil {
+		return 0, err
+	}
+	if res.Auth == nil || !res.Auth.Renewable {
+		return 0, fmt.Errorf("vault: the token is not renewable")
+	}
+	return time.Duration(res.Auth.LeaseDuration) * time.Second, nil
+}
+
+// StartTokenRenewal renews the client token on every interval until the returned stop function is called, the
+// failures are logged
+func (c *Client) StartTokenRenewal(interval time.Duration, increment time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := c.RenewToken(context.Background(), increment); err != nil {
+					logger.ErrorF("vault token renewal failed: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// read sends a GET to the api path and decodes the data of the response into v
+func (c *Client) read(ctx context.Context, path string, v interface{}) error {
+	res, err := c.call(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(res.Data, v)
+}
+
+// write sends a POST of the body to the api path and decodes the data of the response into v
+func (c *Client) write(ctx context.Context, path string, body interface{}, v interface{}) error {
+	res, err := c.call(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(res.Data, v)
+}
+
+func (c *Client) call(ctx context.Context, method string, path string, body interface{}) (*response, error) {
+	var content []byte
+	if body != nil {
+		var err error
+		if content, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, c.Address+"/v1/"+strings.TrimPrefix(path, "/"), bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", c.Token)
+	req.Header.Set("X-Vault-Request", "true")
+	if c.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var decoded response
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &decoded); err != nil && res.StatusCode == http.StatusOK {
+			return nil, err
+		}
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault: %s %s returned %s %s", method, path, res.Status, strings.Join(decoded.Errors, ", "))
+	}
+	return &decoded, nil
+}
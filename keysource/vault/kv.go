@@ -0,0 +1,86 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	turboJwt "github.com/nandlabs/turbo-auth/providers/jwt"
+	"strconv"
+	"strings"
+)
+
+type (
+	// KVSource reads the signing secret from a KV version 2 secret, it implements jwt.KeySource so a
+	// jwt.KeyManager picks up the new versions of the secret. The kid of the key is "<path>-v<version>"
+	KVSource struct {
+		Client *Client
+		// Mount is the path of the KV engine, "secret" by default
+		Mount string
+		Path  string
+		// Field of the secret holding the HMAC secret or the PEM private key, "signing_key" by default
+		Field         string
+		SigningMethod string
+	}
+
+	kvSecret struct {
+		Data     map[string]interface{} `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	}
+)
+
+const (
+	DefaultKVMount = "secret"
+	DefaultKVField = "signing_key"
+)
+
+func NewKVSource(client *Client, path string, signingMethod string) *KVSource {
+	return &KVSource{Client: client, Mount: DefaultKVMount, Path: path, Field: DefaultKVField, SigningMethod: signingMethod}
+}
+
+func (s *KVSource) NextKey() (*turboJwt.Key, error) {
+	return s.Key(context.Background())
+}
+
+// Key reads the current version of the secret
+func (s *KVSource) Key(ctx context.Context) (*turboJwt.Key, error) {
+	mount, field := s.Mount, s.Field
+	if mount == "" {
+		mount = DefaultKVMount
+	}
+	if field == "" {
+		field = DefaultKVField
+	}
+	var secret kvSecret
+	if err := s.Client.read(ctx, mount+"/data/"+strings.Trim(s.Path, "/"), &secret); err != nil {
+		return nil, err
+	}
+	value, _ := secret.Data[field].(string)
+	if value == "" {
+		return nil, fmt.Errorf("vault: the secret %s has no %s field", s.Path, field)
+	}
+	key := &turboJwt.Key{
+		ID:            strings.Trim(s.Path, "/") + "-v" + strconv.Itoa(secret.Metadata.Version),
+		SigningMethod: s.SigningMethod,
+	}
+	switch {
+	case strings.HasPrefix(s.SigningMethod, "HS"):
+		key.SignKey, key.VerifyKey = []byte(value), []byte(value)
+	case strings.HasPrefix(s.SigningMethod, "RS"):
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(value))
+		if err != nil {
+			return nil, err
+		}
+		key.SignKey, key.VerifyKey = privateKey, &privateKey.PublicKey
+	case strings.HasPrefix(s.SigningMethod, "ES"):
+		privateKey, err := jwt.ParseECPrivateKeyFromPEM([]byte(value))
+		if err != nil {
+			return nil, err
+		}
+		key.SignKey, key.VerifyKey = privateKey, &privateKey.PublicKey
+	default:
+		return nil, fmt.Errorf("vault: unsupported signing method %s", s.SigningMethod)
+	}
+	return key, nil
+}
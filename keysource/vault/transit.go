@@ -0,0 +1,189 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	turboJwt "github.com/nandlabs/turbo-auth/providers/jwt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// TransitSource exposes an asymmetric key of the transit engine, the tokens are signed by Vault and the private
+	// key never leaves it. It implements jwt.KeySource, the kid of each version is "<name>-v<version>"
+	TransitSource struct {
+		Client *Client
+		// Mount is the path of the transit engine, "transit" by default
+		Mount string
+		Name  string
+		// SigningMethod of the rsa keys, RS256 by default, the method of the ecdsa keys follows the curve
+		SigningMethod string
+	}
+
+	// TransitSigner signs with a version of a transit key, it is the jwt.Signer of the keys of the TransitSource
+	TransitSigner struct {
+		source        *TransitSource
+		version       int
+		signingMethod string
+	}
+
+	transitKey struct {
+		Type                 string                     `json:"type"`
+		LatestVersion        int                        `json:"latest_version"`
+		MinDecryptionVersion int                        `json:"min_decryption_version"`
+		Keys                 map[string]transitKeyEntry `json:"keys"`
+	}
+
+	// transitKeyEntry is the version entry of the asymmetric keys, the symmetric keys only hold a timestamp
+	transitKeyEntry struct {
+		PublicKey string `json:"public_key"`
+	}
+
+	transitSignature struct {
+		Signature string `json:"signature"`
+	}
+)
+
+const DefaultTransitMount = "transit"
+
+func NewTransitSource(client *Client, name string) *TransitSource {
+	return &TransitSource{Client: client, Mount: DefaultTransitMount, Name: name}
+}
+
+func (s *TransitSource) NextKey() (*turboJwt.Key, error) {
+	keys, err := s.Keys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// Keys returns the versions accepted for verification (from min_decryption_version), the latest first
+func (s *TransitSource) Keys(ctx context.Context) ([]*turboJwt.Key, error) {
+	var key transitKey
+	if err := s.Client.read(ctx, s.mount()+"/keys/"+s.Name, &key); err != nil {
+		return nil, err
+	}
+	signingMethod, err := s.signingMethod(key.Type)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int, 0, len(key.Keys))
+	for version := range key.Keys {
+		if v, err := strconv.Atoi(version); err == nil && v >= key.MinDecryptionVersion {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("vault: the transit key %s has no version", s.Name)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	keys := make([]*turboJwt.Key, 0, len(versions))
+	for _, version := range versions {
+		publicKey, err := parsePublicKey(key.Keys[strconv.Itoa(version)].PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("vault: version %d of the transit key %s: %v", version, s.Name, err)
+		}
+		keys = append(keys, &turboJwt.Key{
+			ID:            s.Name + "-v" + strconv.Itoa(version),
+			SigningMethod: signingMethod,
+			SignKey:       &TransitSigner{source: s, version: version, signingMethod: signingMethod},
+			VerifyKey:     publicKey,
+		})
+	}
+	return keys, nil
+}
+
+// KeyRing returns a jwt.KeyRing holding the Keys, the latest version signs
+func (s *TransitSource) KeyRing(ctx context.Context) (*turboJwt.KeyRing, error) {
+	keys, err := s.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return turboJwt.NewKeyRing(keys[0], keys[1:]...)
+}
+
+func (t *TransitSigner) Sign(signingString string) ([]byte, error) {
+	return t.SignContext(context.Background(), signingString)
+}
+
+// SignContext has Vault sign the signing string with the version of the key
+func (t *TransitSigner) SignContext(ctx context.Context, signingString string) ([]byte, error) {
+	request := map[string]interface{}{
+		"input":       base64.StdEncoding.EncodeToString([]byte(signingString)),
+		"key_version": t.version,
+	}
+	if strings.HasPrefix(t.signingMethod, "ES") {
+		// the jws marshaling returns the fixed size r || s of the JWS signatures instead of ASN.1
+		request["marshaling_algorithm"] = "jws"
+	} else {
+		request["signature_algorithm"] = "pkcs1v15"
+	}
+	var signed transitSignature
+	path := t.source.mount() + "/sign/" + t.source.Name + "/" + hashAlgorithm(t.signingMethod)
+	if err := t.source.Client.write(ctx, path, request, &signed); err != nil {
+		return nil, err
+	}
+	// vault:v<version>:<signature>
+	parts := strings.SplitN(signed.Signature, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, fmt.Errorf("vault: malformed signature of the transit key %s", t.source.Name)
+	}
+	if strings.HasPrefix(t.signingMethod, "ES") {
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[2], "="))
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
+
+func (s *TransitSource) mount() string {
+	if s.Mount == "" {
+		return DefaultTransitMount
+	}
+	return strings.Trim(s.Mount, "/")
+}
+
+// signingMethod maps the type of the transit key, the symmetric types cannot sign JWTs
+func (s *TransitSource) signingMethod(keyType string) (string, error) {
+	switch {
+	case keyType == "ecdsa-p256":
+		return "ES256", nil
+	case keyType == "ecdsa-p384":
+		return "ES384", nil
+	case keyType == "ecdsa-p521":
+		return "ES512", nil
+	case strings.HasPrefix(keyType, "rsa-"):
+		if s.SigningMethod == "" {
+			return "RS256", nil
+		}
+		return s.SigningMethod, nil
+	default:
+		return "", fmt.Errorf("vault: the transit key %s of type %s cannot sign JWTs", s.Name, keyType)
+	}
+}
+
+func hashAlgorithm(signingMethod string) string {
+	return "sha2-" + strings.TrimLeft(signingMethod, "RSE")
+}
+
+func parsePublicKey(publicKey string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key %T", key)
+	}
+}
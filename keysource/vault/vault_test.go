@@ -0,0 +1,178 @@
+package vault
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	turboJwt "github.com/nandlabs/turbo-auth/providers/jwt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newVaultServer fakes the transit engine with two versions of an ecdsa-p256 key and one rsa-2048 key, and a KV
+// version 2 secret
+func newVaultServer(t *testing.T) *httptest.Server {
+	ecKeys := []*ecdsa.PrivateKey{nil}
+	for i := 0; i < 2; i++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ecKeys = append(ecKeys, key)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := func(key interface{}) string {
+		der, _ := x509.MarshalPKIXPublicKey(key)
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+	reply := func(w http.ResponseWriter, data interface{}) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/transit/keys/jwt-ec":
+			reply(w, map[string]interface{}{
+				"type":                   "ecdsa-p256",
+				"latest_version":         2,
+				"min_decryption_version": 1,
+				"keys": map[string]interface{}{
+					"1": map[string]string{"public_key": publicPEM(&ecKeys[1].PublicKey)},
+					"2": map[string]string{"public_key": publicPEM(&ecKeys[2].PublicKey)},
+				},
+			})
+		case "/v1/transit/keys/jwt-rsa":
+			reply(w, map[string]interface{}{
+				"type":           "rsa-2048",
+				"latest_version": 1,
+				"keys":           map[string]interface{}{"1": map[string]string{"public_key": publicPEM(&rsaKey.PublicKey)}},
+			})
+		case "/v1/transit/keys/aes":
+			reply(w, map[string]interface{}{"type": "aes256-gcm96", "keys": map[string]int{"1": 1700000000}})
+		case "/v1/transit/sign/jwt-ec/sha2-256", "/v1/transit/sign/jwt-rsa/sha2-256":
+			var request struct {
+				Input      string `json:"input"`
+				KeyVersion int    `json:"key_version"`
+				Marshaling string `json:"marshaling_algorithm"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&request)
+			input, _ := base64.StdEncoding.DecodeString(request.Input)
+			digest := sha256.Sum256(input)
+			if strings.Contains(r.URL.Path, "jwt-rsa") {
+				signature, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+				reply(w, map[string]string{"signature": "vault:v1:" + base64.StdEncoding.EncodeToString(signature)})
+				return
+			}
+			if request.Marshaling != "jws" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sigR, sigS, _ := ecdsa.Sign(rand.Reader, ecKeys[request.KeyVersion], digest[:])
+			signature := append(padded(sigR), padded(sigS)...)
+			reply(w, map[string]string{
+				"signature": fmt.Sprintf("vault:v%d:%s", request.KeyVersion, base64.RawURLEncoding.EncodeToString(signature)),
+			})
+		case "/v1/secret/data/turbo-auth/jwt":
+			reply(w, map[string]interface{}{
+				"data":     map[string]string{"signing_key": "kv_secret"},
+				"metadata": map[string]int{"version": 3},
+			})
+		case "/v1/auth/token/renew-self":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"lease_duration": 3600, "renewable": true}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func padded(n *big.Int) []byte {
+	out := make([]byte, 32)
+	b := n.Bytes()
+	copy(out[32-len(b):], b)
+	return out
+}
+
+func TestTransitSource_KeyRing(t *testing.T) {
+	client := NewClient(newVaultServer(t).URL, "root")
+
+	tests := []struct {
+		name     string
+		key      string
+		wantKids []string
+		wantErr  bool
+	}{
+		{name: "Test_ecdsa_versions", key: "jwt-ec", wantKids: []string{"jwt-ec-v2", "jwt-ec-v1"}},
+		{name: "Test_rsa", key: "jwt-rsa", wantKids: []string{"jwt-rsa-v1"}},
+		{name: "Test_symmetric_key", key: "aes", wantErr: true},
+		{name: "Test_unknown_key", key: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyRing, err := NewTransitSource(client, tt.key).KeyRing(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KeyRing() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var kids []string
+			for _, key := range keyRing.Keys() {
+				kids = append(kids, key.ID)
+			}
+			if strings.Join(kids, ",") != strings.Join(tt.wantKids, ",") {
+				t.Errorf("kids = %v, want %v", kids, tt.wantKids)
+			}
+			authConfig := turboJwt.CreateJwtAuthenticator(&turboJwt.JwtAuthConfig{BearerTokens: true, KeyStore: keyRing})
+			token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+			if jwtErr != nil {
+				t.Fatalf("IssueNewToken() error = %v", jwtErr)
+			}
+			identity, err := authConfig.Authenticate(token)
+			if err != nil || identity.Subject != "test_user" {
+				t.Errorf("Authenticate() = %+v, error = %v", identity, err)
+			}
+		})
+	}
+}
+
+func TestKVSource_NextKey(t *testing.T) {
+	server := newVaultServer(t)
+	source := NewKVSource(NewClient(server.URL, "root"), "turbo-auth/jwt", "HS256")
+	key, err := source.NextKey()
+	if err != nil {
+		t.Fatalf("NextKey() error = %v", err)
+	}
+	if key.ID != "turbo-auth/jwt-v3" || string(key.SignKey.([]byte)) != "kv_secret" {
+		t.Errorf("key = %+v", key)
+	}
+	if _, err := NewKVSource(NewClient(server.URL, "wrong"), "turbo-auth/jwt", "HS256").NextKey(); err == nil ||
+		!strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("NextKey() error = %v", err)
+	}
+	if ttl, err := NewClient(server.URL, "root").RenewToken(context.Background(), time.Hour); err != nil || ttl != time.Hour {
+		t.Errorf("RenewToken() = %v, %v", ttl, err)
+	}
+}
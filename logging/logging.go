@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"sync/atomic"
+)
+
+type (
+	// Logger receives the logs of the library, the messages are printf style formats. The loggers of
+	// go.nandlabs.io/l3 implement it, e.g. SetLogger(logging.Get()), see Slog for log/slog
+	Logger interface {
+		DebugF(format string, v ...interface{})
+		InfoF(format string, v ...interface{})
+		WarnF(format string, v ...interface{})
+		ErrorF(format string, v ...interface{})
+	}
+
+	// delegate forwards to the Logger set last, so that the package loggers created at init follow SetLogger
+	delegate struct{}
+
+	nop struct{}
+
+	// holder keeps the concrete type stored in the atomic.Value constant
+	holder struct {
+		logger Logger
+	}
+)
+
+var current atomic.Value
+
+func init() {
+	current.Store(holder{logger: nop{}})
+}
+
+// SetLogger routes the logs of all the packages of the library to the logger, nil discards them which is the
+// default
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = nop{}
+	}
+	current.Store(holder{logger: logger})
+}
+
+// Get returns the logger of a package of the library, it forwards to the one of SetLogger
+func Get() Logger {
+	return delegate{}
+}
+
+// Nop discards the logs
+func Nop() Logger {
+	return nop{}
+}
+
+func load() Logger {
+	return current.Load().(holder).logger
+}
+
+func (delegate) DebugF(format string, v ...interface{}) { load().DebugF(format, v...) }
+func (delegate) InfoF(format string, v ...interface{})  { load().InfoF(format, v...) }
+func (delegate) WarnF(format string, v ...interface{})  { load().WarnF(format, v...) }
+func (delegate) ErrorF(format string, v ...interface{}) { load().ErrorF(format, v...) }
+
+func (nop) DebugF(string, ...interface{}) {}
+func (nop) InfoF(string, ...interface{})  {}
+func (nop) WarnF(string, ...interface{})  {}
+func (nop) ErrorF(string, ...interface{}) {}
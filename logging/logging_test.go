@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"fmt"
+	"testing"
+)
+
+type recorder struct {
+	lines []string
+}
+
+func (r *recorder) DebugF(format string, v ...interface{}) { r.record("DEBUG", format, v...) }
+func (r *recorder) InfoF(format string, v ...interface{})  { r.record("INFO", format, v...) }
+func (r *recorder) WarnF(format string, v ...interface{})  { r.record("WARN", format, v...) }
+func (r *recorder) ErrorF(format string, v ...interface{}) { r.record("ERROR", format, v...) }
+
+func (r *recorder) record(level string, format string, v ...interface{}) {
+	r.lines = append(r.lines, level+" "+fmt.Sprintf(format, v...))
+}
+
+func TestSetLogger(t *testing.T) {
+	// the package loggers are created before SetLogger is called
+	logger := Get()
+	defer SetLogger(nil)
+
+	tests := []struct {
+		name   string
+		logger *recorder
+		want   int
+	}{
+		{name: "Test_default_nop", want: 0},
+		{name: "Test_custom_logger", logger: &recorder{}, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.logger != nil {
+				SetLogger(tt.logger)
+			} else {
+				SetLogger(nil)
+			}
+			logger.DebugF("key %s", "kid-1")
+			logger.InfoF("key %s rotated", "kid-2")
+			logger.WarnF("cache miss")
+			logger.ErrorF("refresh failed: %v", "timeout")
+			if tt.logger != nil && len(tt.logger.lines) != tt.want {
+				t.Errorf("logged %v, want %d lines", tt.logger.lines, tt.want)
+			}
+			if tt.logger != nil && tt.logger.lines[1] != "INFO key kid-2 rotated" {
+				t.Errorf("logged %q, want the formatted message", tt.logger.lines[1])
+			}
+		})
+	}
+}
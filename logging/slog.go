@@ -0,0 +1,35 @@
+//go:build go1.21
+
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// Slog adapts the slog logger, slog.Default() when nil, the formatted message is logged at the matching level
+func Slog(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &slogLogger{logger: logger}
+}
+
+func (s *slogLogger) DebugF(format string, v ...interface{}) { s.log(slog.LevelDebug, format, v...) }
+func (s *slogLogger) InfoF(format string, v ...interface{})  { s.log(slog.LevelInfo, format, v...) }
+func (s *slogLogger) WarnF(format string, v ...interface{})  { s.log(slog.LevelWarn, format, v...) }
+func (s *slogLogger) ErrorF(format string, v ...interface{}) { s.log(slog.LevelError, format, v...) }
+
+// log skips the formatting of the disabled levels
+func (s *slogLogger) log(level slog.Level, format string, v ...interface{}) {
+	ctx := context.Background()
+	if !s.logger.Enabled(ctx, level) {
+		return
+	}
+	s.logger.Log(ctx, level, fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,35 @@
+//go:build go1.21
+
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlog(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := Slog(slog.New(slog.NewTextHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	tests := []struct {
+		name string
+		log  func()
+		want string
+	}{
+		{name: "Test_debug_disabled", log: func() { logger.DebugF("cache miss for %s", "kid-1") }, want: ""},
+		{name: "Test_info", log: func() { logger.InfoF("signing key rotated, current kid: %s", "kid-2") },
+			want: `level=INFO msg="signing key rotated, current kid: kid-2"`},
+		{name: "Test_error", log: func() { logger.ErrorF("refresh failed") }, want: `level=ERROR msg="refresh failed"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer.Reset()
+			tt.log()
+			if got := buffer.String(); (tt.want == "" && got != "") || !strings.Contains(got, tt.want) {
+				t.Errorf("logged %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
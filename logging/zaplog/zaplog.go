@@ -0,0 +1,79 @@
+// Package zaplog adapts zap to the logs and the audit events of the library, the fields of the events are logged as
+// zap fields under the audit keys
+package zaplog
+
+import (
+	"fmt"
+	"github.com/nandlabs/turbo-auth/audit"
+	"github.com/nandlabs/turbo-auth/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	zapLogger struct {
+		logger *zap.Logger
+	}
+
+	auditLogger struct {
+		logger *zap.Logger
+	}
+)
+
+// Logger adapts the zap logger, zap.L() when nil, e.g. logging.SetLogger(zaplog.Logger(logger.With(zap.String(
+// "component", "auth")))). The formatted message is logged at the matching level, the fields of the logger are kept
+func Logger(logger *zap.Logger) logging.Logger {
+	if logger == nil {
+		logger = zap.L()
+	}
+	// the caller is the library code calling the logger of logging.Get
+	return &zapLogger{logger: logger.WithOptions(zap.AddCallerSkip(3))}
+}
+
+// AuditLogger logs the events with the zap logger, zap.L() when nil, at the info level and the failures at the warn
+// level. The entries carry the time of the event, see Fields
+func AuditLogger(logger *zap.Logger) audit.AuditLogger {
+	if logger == nil {
+		logger = zap.L()
+	}
+	return &auditLogger{logger: logger}
+}
+
+// Fields returns the zap fields of the event under the keys of audit.Fields, the empty values are skipped but the
+// event type and the outcome
+func Fields(event *audit.Event) []zap.Field {
+	keysAndValues := audit.Fields(event)
+	fields := make([]zap.Field, 0, len(keysAndValues)/2)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields = append(fields, zap.String(keysAndValues[i].(string), keysAndValues[i+1].(string)))
+	}
+	return fields
+}
+
+func (z *zapLogger) DebugF(format string, v ...interface{}) { z.log(zapcore.DebugLevel, format, v...) }
+func (z *zapLogger) InfoF(format string, v ...interface{})  { z.log(zapcore.InfoLevel, format, v...) }
+func (z *zapLogger) WarnF(format string, v ...interface{})  { z.log(zapcore.WarnLevel, format, v...) }
+func (z *zapLogger) ErrorF(format string, v ...interface{}) { z.log(zapcore.ErrorLevel, format, v...) }
+
+// log skips the formatting of the disabled levels
+func (z *zapLogger) log(level zapcore.Level, format string, v ...interface{}) {
+	if !z.logger.Core().Enabled(level) {
+		return
+	}
+	if entry := z.logger.Check(level, fmt.Sprintf(format, v...)); entry != nil {
+		entry.Write()
+	}
+}
+
+func (a *auditLogger) Log(event *audit.Event) {
+	level := zapcore.InfoLevel
+	if event.Outcome == audit.OutcomeFailure {
+		level = zapcore.WarnLevel
+	}
+	if entry := a.logger.Check(level, audit.Message); entry != nil {
+		if !event.Time.IsZero() {
+			entry.Time = event.Time
+		}
+		entry.Write(Fields(event)...)
+	}
+}
@@ -0,0 +1,76 @@
+package zaplog
+
+import (
+	"errors"
+	"github.com/nandlabs/turbo-auth/audit"
+	"github.com/nandlabs/turbo-auth/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logging.SetLogger(Logger(zap.New(core).With(zap.String("component", "auth"))))
+	defer logging.SetLogger(nil)
+	logger := logging.Get()
+
+	logger.DebugF("cache miss for %s", "kid-1")
+	logger.WarnF("unable to refresh the keys of %s", "https://example.com/jwks")
+	entries := logs.AllUntimed()
+	if len(entries) != 1 {
+		t.Fatalf("logged %v, want 1 entry", entries)
+	}
+	if entries[0].Level != zapcore.WarnLevel || entries[0].Message != "unable to refresh the keys of https://example.com/jwks" {
+		t.Errorf("logged %v %q", entries[0].Level, entries[0].Message)
+	}
+	if got := entries[0].ContextMap(); got["component"] != "auth" {
+		t.Errorf("fields = %v, want the fields of the logger", got)
+	}
+}
+
+func TestAuditLogger(t *testing.T) {
+	issuedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		event     *audit.Event
+		wantLevel zapcore.Level
+		want      map[string]interface{}
+	}{
+		{
+			name: "Test_success",
+			event: &audit.Event{Time: issuedAt, Type: audit.EventTokenIssued, Provider: "jwt", Subject: "test_user",
+				TokenID: "jti-1", Outcome: audit.OutcomeSuccess},
+			wantLevel: zapcore.InfoLevel,
+			want: map[string]interface{}{audit.KeyEvent: "token_issued", audit.KeyOutcome: "success",
+				audit.KeyProvider: "jwt", audit.KeySubject: "test_user", audit.KeyTokenID: "jti-1"},
+		},
+		{
+			name:      "Test_failure",
+			event:     audit.NewEvent(nil, audit.EventTokenValidation, "jwt", errors.New("token has expired")),
+			wantLevel: zapcore.WarnLevel,
+			want: map[string]interface{}{audit.KeyEvent: "token_validation", audit.KeyOutcome: "failure",
+				audit.KeyProvider: "jwt", audit.KeyReason: "token has expired"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			AuditLogger(zap.New(core)).Log(tt.event)
+			entries := logs.All()
+			if len(entries) != 1 {
+				t.Fatalf("logged %v, want 1 entry", entries)
+			}
+			entry := entries[0]
+			if entry.Level != tt.wantLevel || entry.Message != audit.Message || !entry.Time.Equal(tt.event.Time) {
+				t.Errorf("logged %v %q at %v", entry.Level, entry.Message, entry.Time)
+			}
+			if got := entry.ContextMap(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+type (
+	// Metrics holds the prometheus collectors of the authentication outcomes, a nil *Metrics records nothing
+	Metrics struct {
+		AuthSuccess        *prometheus.CounterVec
+		AuthFailure        *prometheus.CounterVec
+		TokenIssueDuration *prometheus.HistogramVec
+		JWKSCacheHits      prometheus.Counter
+		// JWKSStaleHits counts the keys served past their max age while the key set could not be refreshed
+		JWKSStaleHits       prometheus.Counter
+		JWKSRefreshFailures prometheus.Counter
+		// JWKSLastRefresh is the unix time of the last successful fetch of a key set, the age of the cache is
+		// time() - jwks_last_refresh_timestamp_seconds
+		JWKSLastRefresh prometheus.Gauge
+	}
+)
+
+const (
+	LabelProvider = "provider"
+	LabelReason   = "reason"
+
+	ReasonOther = "other"
+)
+
+var (
+	reasons = map[error]string{
+		turboError.ErrMissingToken:      "missing_token",
+		turboError.ErrTokenMalformed:    "malformed",
+		turboError.ErrSignatureInvalid:  "signature_invalid",
+		turboError.ErrTokenUnverifiable: "unverifiable",
+		turboError.ErrTokenExpired:      "expired",
+		turboError.ErrTokenNotValidYet:  "not_valid_yet",
+		turboError.ErrTokenRevoked:      "revoked",
+		turboError.ErrTokenInvalid:      "invalid",
+	}
+)
+
+// NewMetrics creates the collectors and registers them on the registerer, prometheus.DefaultRegisterer when nil
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	m := &Metrics{
+		AuthSuccess: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_success_total",
+			Help: "Number of successful authentications.",
+		}, []string{LabelProvider}),
+		AuthFailure: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_failure_total",
+			Help: "Number of failed authentications by reason.",
+		}, []string{LabelProvider, LabelReason}),
+		TokenIssueDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "token_issue_duration_seconds",
+			Help:    "Time taken to sign new tokens.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{LabelProvider}),
+		JWKSCacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "jwks_cache_hits",
+			Help: "Number of key lookups served from the JWKS cache.",
+		}),
+		JWKSStaleHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "jwks_cache_stale_hits",
+			Help: "Number of key lookups served from a stale JWKS cache.",
+		}),
+		JWKSRefreshFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "jwks_refresh_failures_total",
+			Help: "Number of failed fetches of a key set.",
+		}),
+		JWKSLastRefresh: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "jwks_last_refresh_timestamp_seconds",
+			Help: "Unix time of the last successful fetch of a key set.",
+		}),
+	}
+	for _, collector := range []prometheus.Collector{m.AuthSuccess, m.AuthFailure, m.TokenIssueDuration, m.JWKSCacheHits,
+		m.JWKSStaleHits, m.JWKSRefreshFailures, m.JWKSLastRefresh} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Reason maps the error to a label of bounded cardinality using the sentinel errors of the errors package
+func Reason(err error) string {
+	if reason, ok := reasons[turboError.Kind(err)]; ok {
+		return reason
+	}
+	return ReasonOther
+}
+
+// ObserveAuth counts the outcome of an authentication, err is nil on success
+func (m *Metrics) ObserveAuth(provider string, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.AuthFailure.WithLabelValues(provider, Reason(err)).Inc()
+		return
+	}
+	m.AuthSuccess.WithLabelValues(provider).Inc()
+}
+
+// ObserveTokenIssue records the time taken to issue a token since start
+func (m *Metrics) ObserveTokenIssue(provider string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.TokenIssueDuration.WithLabelValues(provider).Observe(time.Since(start).Seconds())
+}
+
+// JWKSCacheHit counts a key served from the JWKS cache
+func (m *Metrics) JWKSCacheHit() {
+	if m == nil {
+		return
+	}
+	m.JWKSCacheHits.Inc()
+}
+
+// JWKSStaleHit counts a key served from a stale JWKS cache
+func (m *Metrics) JWKSStaleHit() {
+	if m == nil {
+		return
+	}
+	m.JWKSStaleHits.Inc()
+}
+
+// ObserveJWKSRefresh records the outcome of a fetch of a key set, err is nil on success
+func (m *Metrics) ObserveJWKSRefresh(err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.JWKSRefreshFailures.Inc()
+		return
+	}
+	m.JWKSLastRefresh.SetToCurrentTime()
+}
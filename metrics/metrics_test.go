@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+	"time"
+)
+
+func TestReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"expired", turboError.Wrap(turboError.ErrTokenExpired, errors.New("exp")), "expired"},
+		{"revoked", fmt.Errorf("jti: %w", turboError.ErrTokenRevoked), "revoked"},
+		{"unclassified", errors.New("boom"), ReasonOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Reason(tt.err); got != tt.want {
+				t.Errorf("Reason() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewMetrics(registry)
+	if err != nil {
+		t.Fatalf("NewMetrics() error = %v", err)
+	}
+	if _, err := NewMetrics(registry); err == nil {
+		t.Errorf("NewMetrics() registered twice, want error")
+	}
+
+	m.ObserveAuth("jwt", nil)
+	m.ObserveAuth("jwt", nil)
+	m.ObserveAuth("jwt", turboError.ErrTokenExpired)
+	m.ObserveTokenIssue("jwt", time.Now())
+	m.JWKSCacheHit()
+	m.JWKSStaleHit()
+	m.ObserveJWKSRefresh(errors.New("unreachable"))
+	m.ObserveJWKSRefresh(nil)
+
+	if got := testutil.ToFloat64(m.AuthSuccess.WithLabelValues("jwt")); got != 2 {
+		t.Errorf("auth_success_total = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.AuthFailure.WithLabelValues("jwt", "expired")); got != 1 {
+		t.Errorf("auth_failure_total = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.JWKSCacheHits); got != 1 {
+		t.Errorf("jwks_cache_hits = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.JWKSRefreshFailures); got != 1 {
+		t.Errorf("jwks_refresh_failures_total = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.JWKSLastRefresh); got == 0 {
+		t.Errorf("jwks_last_refresh_timestamp_seconds = %v, want the time of the refresh", got)
+	}
+
+	var nilMetrics *Metrics
+	nilMetrics.ObserveAuth("jwt", nil)
+	nilMetrics.JWKSCacheHit()
+	nilMetrics.ObserveJWKSRefresh(nil)
+}
@@ -0,0 +1,55 @@
+package mfa
+
+import (
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+)
+
+const (
+	// ClaimMFA is set to true on the tokens issued after a successful second factor
+	ClaimMFA = "mfa"
+	// ClaimAMR lists the authentication methods references (RFC 8176) of the token
+	ClaimAMR = "amr"
+)
+
+// Verified reports whether the identity passed a second factor, either through the mfa claim or an
+// "mfa"/"otp" authentication method reference
+func Verified(identity *turboAuth.Identity) bool {
+	if identity == nil {
+		return false
+	}
+	if verified, ok := identity.Claims[ClaimMFA].(bool); ok && verified {
+		return true
+	}
+	for _, method := range amr(identity) {
+		if method == "mfa" || method == "otp" {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireMFA rejects the requests of identities which did not pass a second factor, to be chained after the authenticator
+func RequireMFA(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := turboAuth.IdentityFromContext(r.Context())
+		if !ok {
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Incoming request cannot be authorized \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+		if !Verified(identity) {
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusForbidden,
+				Message:    "Error : multi-factor authentication required \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
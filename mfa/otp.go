@@ -0,0 +1,254 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/nandlabs/turbo-auth/internal/secret"
+	"github.com/nandlabs/turbo-auth/nonce"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDigits     = 6
+	MinDigits         = 6
+	MaxDigits         = 8
+	DefaultPeriod     = 30 * time.Second
+	DefaultSkew       = 1
+	DefaultSecretSize = 20
+)
+
+type (
+	// Algorithm is the HMAC hash of the one time passwords, SHA1 is the one supported by most authenticator apps
+	Algorithm string
+
+	// TOTP is a time based one time password generator and validator (RFC 6238)
+	TOTP struct {
+		// Secret is base32 encoded without padding, as shared with the authenticator app
+		Secret    string
+		Issuer    string
+		Account   string
+		Digits    int
+		Period    time.Duration
+		Algorithm Algorithm
+		// Skew is the number of periods accepted before and after the current one to tolerate clock drift
+		Skew uint
+	}
+
+	// QREncoder renders the content as a PNG image of the given size, e.g. backed by github.com/skip2/go-qrcode
+	QREncoder func(content string, size int) ([]byte, error)
+)
+
+const (
+	SHA1   Algorithm = "SHA1"
+	SHA256 Algorithm = "SHA256"
+	SHA512 Algorithm = "SHA512"
+)
+
+var (
+	ErrInvalidSecret = errors.New("invalid otp secret")
+	ErrInvalidCode   = errors.New("invalid otp code")
+	ErrInvalidDigits = fmt.Errorf("otp codes have %d to %d digits", MinDigits, MaxDigits)
+
+	secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// GenerateSecret returns a random base32 encoded secret of size bytes
+func GenerateSecret(size int) (string, error) {
+	if size <= 0 {
+		size = DefaultSecretSize
+	}
+	secret := make([]byte, size)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return secretEncoding.EncodeToString(secret), nil
+}
+
+// NewTOTP creates a TOTP with a fresh secret and the defaults understood by the authenticator apps
+func NewTOTP(issuer string, account string) (*TOTP, error) {
+	secret, err := GenerateSecret(DefaultSecretSize)
+	if err != nil {
+		return nil, err
+	}
+	return &TOTP{
+		Secret:    secret,
+		Issuer:    issuer,
+		Account:   account,
+		Digits:    DefaultDigits,
+		Period:    DefaultPeriod,
+		Algorithm: SHA1,
+		Skew:      DefaultSkew,
+	}, nil
+}
+
+// HOTP computes the counter based one time password (RFC 4226), of 6 to 8 digits as the 31 bits of the truncated
+// HMAC do not hold more
+func HOTP(secret []byte, counter uint64, digits int, algorithm Algorithm) (string, error) {
+	if digits < MinDigits || digits > MaxDigits {
+		return "", ErrInvalidDigits
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(algorithm.hash(), secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod), nil
+}
+
+// ValidateHOTP checks the code against the counter and up to lookAhead following counters, the counter to store
+// for the next validation is returned on success
+func ValidateHOTP(secret string, code string, counter uint64, lookAhead uint64, digits int, algorithm Algorithm) (bool, uint64, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return false, counter, err
+	}
+	for c := counter; c <= counter+lookAhead; c++ {
+		expected, err := HOTP(key, c, digits, algorithm)
+		if err != nil {
+			return false, counter, err
+		}
+		if equal(expected, code) {
+			return true, c + 1, nil
+		}
+	}
+	return false, counter, nil
+}
+
+// Generate returns the code for the given time
+func (t *TOTP) Generate(at time.Time) (string, error) {
+	key, err := decodeSecret(t.Secret)
+	if err != nil {
+		return "", err
+	}
+	return HOTP(key, t.step(at), t.digits(), t.Algorithm)
+}
+
+// Validate checks the code within the skew window, the matched time step is returned so that callers can
+// reject its reuse
+func (t *TOTP) Validate(code string, at time.Time) (bool, uint64, error) {
+	key, err := decodeSecret(t.Secret)
+	if err != nil {
+		return false, 0, err
+	}
+	current := t.step(at)
+	for i := int64(-int64(t.Skew)); i <= int64(t.Skew); i++ {
+		step := uint64(int64(current) + i)
+		expected, err := HOTP(key, step, t.digits(), t.Algorithm)
+		if err != nil {
+			return false, 0, err
+		}
+		if len(code) == len(expected) && equal(expected, code) {
+			return true, step, nil
+		}
+	}
+	return false, 0, nil
+}
+
+// ValidateOnce is Validate rejecting the reuse of the codes, the matched time step is recorded in the store until
+// it leaves the skew window
+func (t *TOTP) ValidateOnce(store nonce.Store, code string, at time.Time) (bool, error) {
+	ok, step, err := t.Validate(code, at)
+	if !ok || err != nil {
+		return false, err
+	}
+	// the steps are keyed by the digest of the secret, unique to the enrollment whatever the Account
+	sum := sha256.Sum256([]byte(t.Secret))
+	period := int64(t.period() / time.Second)
+	expiresAt := time.Unix(int64(step+uint64(t.Skew)+1)*period, 0)
+	used, err := store.Seen("totp:"+hex.EncodeToString(sum[:16])+":"+strconv.FormatUint(step, 10), expiresAt)
+	if used || err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ProvisioningURI is the otpauth:// uri to be scanned by the authenticator apps
+func (t *TOTP) ProvisioningURI() string {
+	label := url.PathEscape(t.Account)
+	if t.Issuer != "" {
+		label = url.PathEscape(t.Issuer) + ":" + label
+	}
+	values := url.Values{}
+	values.Set("secret", t.Secret)
+	if t.Issuer != "" {
+		values.Set("issuer", t.Issuer)
+	}
+	values.Set("algorithm", string(t.algorithm()))
+	values.Set("digits", fmt.Sprintf("%d", t.digits()))
+	values.Set("period", fmt.Sprintf("%d", int64(t.period()/time.Second)))
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// QRCode renders the provisioning uri as a PNG with the given encoder
+func (t *TOTP) QRCode(encoder QREncoder, size int) ([]byte, error) {
+	if encoder == nil {
+		return nil, errors.New("qr encoder is required")
+	}
+	return encoder(t.ProvisioningURI(), size)
+}
+
+func (t *TOTP) step(at time.Time) uint64 {
+	return uint64(at.Unix() / int64(t.period()/time.Second))
+}
+
+func (t *TOTP) digits() int {
+	if t.Digits <= 0 {
+		return DefaultDigits
+	}
+	return t.Digits
+}
+
+func (t *TOTP) period() time.Duration {
+	if t.Period < time.Second {
+		return DefaultPeriod
+	}
+	return t.Period
+}
+
+func (t *TOTP) algorithm() Algorithm {
+	if t.Algorithm == "" {
+		return SHA1
+	}
+	return t.Algorithm
+}
+
+func (algorithm Algorithm) hash() func() hash.Hash {
+	switch algorithm {
+	case SHA256:
+		return sha256.New
+	case SHA512:
+		return sha512.New
+	default:
+		return sha1.New
+	}
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := secretEncoding.DecodeString(strings.ToUpper(strings.TrimRight(strings.Replace(secret, " ", "", -1), "=")))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
+
+func equal(a string, b string) bool {
+	return secret.Equal(a, b)
+}
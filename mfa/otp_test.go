@@ -0,0 +1,117 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"github.com/nandlabs/turbo-auth/nonce"
+	"strings"
+	"testing"
+	"time"
+)
+
+// test vectors of RFC 6238 appendix B
+func TestTOTP_Generate(t *testing.T) {
+	secret := func(seed string) string {
+		return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(seed))
+	}
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		secret    string
+		unix      int64
+		want      string
+	}{
+		{name: "Test_sha1_59", algorithm: SHA1, secret: secret("12345678901234567890"), unix: 59, want: "94287082"},
+		{name: "Test_sha1_1111111109", algorithm: SHA1, secret: secret("12345678901234567890"), unix: 1111111109, want: "07081804"},
+		{name: "Test_sha256_59", algorithm: SHA256, secret: secret("12345678901234567890123456789012"), unix: 59, want: "46119246"},
+		{name: "Test_sha512_59", algorithm: SHA512, secret: secret("1234567890123456789012345678901234567890123456789012345678901234"), unix: 59, want: "90693936"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totp := &TOTP{Secret: tt.secret, Digits: 8, Period: 30 * time.Second, Algorithm: tt.algorithm, Skew: 1}
+			got, err := totp.Generate(time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Generate() = %v, want %v", got, tt.want)
+			}
+			valid, _, _ := totp.Validate(tt.want, time.Unix(tt.unix+30, 0))
+			if !valid {
+				t.Errorf("Validate() within skew = false")
+			}
+			valid, _, _ = totp.Validate(tt.want, time.Unix(tt.unix+90, 0))
+			if valid {
+				t.Errorf("Validate() outside skew = true")
+			}
+		})
+	}
+}
+
+func TestHOTP_digits(t *testing.T) {
+	tests := []struct {
+		name    string
+		digits  int
+		wantErr bool
+	}{
+		{name: "Test_six_digits", digits: 6},
+		{name: "Test_eight_digits", digits: 8},
+		{name: "Test_five_digits", digits: 5, wantErr: true},
+		{name: "Test_ten_digits", digits: 10, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := HOTP([]byte("12345678901234567890"), 1, tt.digits, SHA1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("HOTP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(code) != tt.digits {
+				t.Errorf("HOTP() = %v, want %v digits", code, tt.digits)
+			}
+		})
+	}
+}
+
+func TestTOTP_ProvisioningURI(t *testing.T) {
+	totp, err := NewTOTP("Turbo Auth", "user@example.com")
+	if err != nil {
+		t.Fatalf("NewTOTP() error = %v", err)
+	}
+	uri := totp.ProvisioningURI()
+	if !strings.HasPrefix(uri, "otpauth://totp/Turbo%20Auth:user@example.com?") || !strings.Contains(uri, "secret="+totp.Secret) {
+		t.Errorf("ProvisioningURI() = %v", uri)
+	}
+}
+
+func TestTOTP_ValidateOnce(t *testing.T) {
+	totp := &TOTP{Secret: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890")), Skew: 1}
+	other := &TOTP{Secret: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("09876543210987654321")), Skew: 1}
+	store := nonce.NewMemoryStore()
+	now := time.Now()
+	code, _ := totp.Generate(now)
+	otherCode, _ := other.Generate(now)
+	wrong := string('0'+(code[0]-'0'+1)%10) + code[1:]
+	tests := []struct {
+		name string
+		totp *TOTP
+		code string
+		at   time.Time
+		want bool
+	}{
+		{name: "Test_first_use", totp: totp, code: code, at: now, want: true},
+		{name: "Test_replayed", totp: totp, code: code, at: now},
+		{name: "Test_replayed_next_period", totp: totp, code: code, at: now.Add(DefaultPeriod)},
+		{name: "Test_other_enrollment", totp: other, code: otherCode, at: now, want: true},
+		{name: "Test_wrong_code", totp: totp, code: wrong, at: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.totp.ValidateOnce(store, tt.code, tt.at)
+			if err != nil {
+				t.Fatalf("ValidateOnce() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ValidateOnce() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package mfa
+
+import (
+	"fmt"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// AuthLevel ranks the strength of the authentication a token was obtained with, higher is stronger
+	AuthLevel int
+
+	// StepUp rejects the requests whose token was obtained with a weaker authentication than Level, or longer ago
+	// than MaxAge, with the RFC 9470 challenge asking the client to authenticate again with a stronger method
+	StepUp struct {
+		Level AuthLevel
+		// MaxAge bounds the age of the authentication (auth_time claim) when set, the tokens without auth_time are
+		// rejected
+		MaxAge time.Duration
+		// ACRValues are advertised in the challenge, e.g. the acr the authorization server must be asked for
+		ACRValues []string
+		Realm     string
+		// Levels ranks the acr claim values, DefaultACRLevels when nil
+		Levels map[string]AuthLevel
+	}
+)
+
+const (
+	LevelNone AuthLevel = iota
+	// LevelPassword is a single factor, e.g. a password or a magic link
+	LevelPassword
+	// LevelMFA is two factors or more, e.g. a password and a one time password
+	LevelMFA
+	// LevelPhishingResistant is a hardware bound key, e.g. a WebAuthn credential
+	LevelPhishingResistant
+)
+
+const (
+	// ClaimACR is the authentication context class reference of the token
+	ClaimACR = "acr"
+	// ClaimAuthTime is the time of the authentication, in seconds since the epoch
+	ClaimAuthTime = "auth_time"
+)
+
+// DefaultACRLevels ranks the numeric acr values and the phishing resistant ones of the OpenID EAP profile
+var DefaultACRLevels = map[string]AuthLevel{
+	"0":    LevelNone,
+	"1":    LevelPassword,
+	"2":    LevelMFA,
+	"3":    LevelPhishingResistant,
+	"pwd":  LevelPassword,
+	"mfa":  LevelMFA,
+	"phr":  LevelPhishingResistant,
+	"phrh": LevelPhishingResistant,
+}
+
+// RequireAuthLevel rejects the requests of identities authenticated with a weaker level, to be chained after the
+// authenticator
+func RequireAuthLevel(level AuthLevel) func(http.Handler) http.Handler {
+	return (&StepUp{Level: level}).Middleware
+}
+
+// Level returns the strongest of the levels of the acr claim, ranked by levels (DefaultACRLevels when nil), and of
+// the amr claim (RFC 8176)
+func Level(identity *turboAuth.Identity, levels map[string]AuthLevel) AuthLevel {
+	if identity == nil {
+		return LevelNone
+	}
+	if levels == nil {
+		levels = DefaultACRLevels
+	}
+	level := LevelNone
+	if acr, ok := identity.Claims[ClaimACR].(string); ok {
+		level = levels[acr]
+	}
+	if Verified(identity) && level < LevelMFA {
+		level = LevelMFA
+	}
+	methods := amr(identity)
+	// distinct methods are distinct factors
+	if len(methods) > 1 && level < LevelMFA {
+		level = LevelMFA
+	}
+	for _, method := range methods {
+		switch {
+		case method == "hwk":
+			level = LevelPhishingResistant
+		case level < LevelPassword:
+			level = LevelPassword
+		}
+	}
+	return level
+}
+
+// AuthTime returns the time of the authentication, false when the auth_time claim is absent. The issue time of the
+// token is not assumed, a refreshed token is issued long after the authentication
+func AuthTime(identity *turboAuth.Identity) (time.Time, bool) {
+	seconds, ok := identity.Claims[ClaimAuthTime].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+func (s *StepUp) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := turboAuth.IdentityFromContext(r.Context())
+		if !ok {
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Incoming request cannot be authorized \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+		if reason := s.check(identity); reason != "" {
+			turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+				Source: "StepUp", Reason: reason})
+			w.Header().Set(turboError.HeaderWWWAuthenticate, s.challenge(reason))
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Error : " + reason + " \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// check returns why the identity must step up, empty when it does not
+func (s *StepUp) check(identity *turboAuth.Identity) string {
+	if Level(identity, s.Levels) < s.Level {
+		return "a stronger authentication is required"
+	}
+	if s.MaxAge > 0 {
+		authTime, ok := AuthTime(identity)
+		if !ok || time.Since(authTime) > s.MaxAge {
+			return "a more recent authentication is required"
+		}
+	}
+	return ""
+}
+
+// challenge is the RFC 9470 insufficient_user_authentication challenge
+func (s *StepUp) challenge(reason string) string {
+	var params []string
+	if s.Realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", s.Realm))
+	}
+	params = append(params, fmt.Sprintf("error=%q", turboError.BearerInsufficientUserAuthentication),
+		fmt.Sprintf("error_description=%q", reason))
+	if len(s.ACRValues) > 0 {
+		params = append(params, fmt.Sprintf("acr_values=%q", strings.Join(s.ACRValues, " ")))
+	}
+	if s.MaxAge > 0 {
+		params = append(params, fmt.Sprintf("max_age=%d", int64(s.MaxAge/time.Second)))
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// amr returns the authentication methods references of the identity
+func amr(identity *turboAuth.Identity) []string {
+	var methods []string
+	switch values := identity.Claims[ClaimAMR].(type) {
+	case []interface{}:
+		for _, value := range values {
+			if method, ok := value.(string); ok {
+				methods = append(methods, method)
+			}
+		}
+	case []string:
+		methods = values
+	}
+	return methods
+}
@@ -0,0 +1,93 @@
+package mfa
+
+import (
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLevel(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   AuthLevel
+	}{
+		{name: "Test_no_claims", want: LevelNone},
+		{name: "Test_password", claims: map[string]interface{}{"amr": []interface{}{"pwd"}}, want: LevelPassword},
+		{name: "Test_two_factors", claims: map[string]interface{}{"amr": []interface{}{"pwd", "sms"}}, want: LevelMFA},
+		{name: "Test_mfa_claim", claims: map[string]interface{}{"mfa": true}, want: LevelMFA},
+		{name: "Test_acr", claims: map[string]interface{}{"acr": "phr"}, want: LevelPhishingResistant},
+		{name: "Test_hardware_key", claims: map[string]interface{}{"amr": []string{"hwk"}}, want: LevelPhishingResistant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Level(&turboAuth.Identity{Claims: tt.claims}, nil); got != tt.want {
+				t.Errorf("Level() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStepUp_Middleware(t *testing.T) {
+	authConfig := jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{SigningKey: "test_key", SigningMethod: "HS256", BearerTokens: true})
+	issue := func(authentication *jwt.Authentication) *turboAuth.Identity {
+		pair, jwtErr := authConfig.IssueAuthenticatedTokenPair("test_user", nil, authentication)
+		if jwtErr != nil {
+			t.Fatalf("IssueAuthenticatedTokenPair() error = %v", jwtErr)
+		}
+		identity, err := authConfig.Authenticate(pair.AuthToken)
+		if err != nil {
+			t.Fatalf("Authenticate() error = %v", err)
+		}
+		return identity
+	}
+	stepUp := &StepUp{Level: LevelMFA, MaxAge: 5 * time.Minute, ACRValues: []string{"2"}}
+	tests := []struct {
+		name          string
+		identity      *turboAuth.Identity
+		wantStatus    int
+		wantChallenge string
+	}{
+		{name: "Test_mfa", identity: issue(&jwt.Authentication{AMR: []string{"pwd", "otp"}, Time: time.Now()}), wantStatus: http.StatusOK},
+		{
+			name:          "Test_password_only",
+			identity:      issue(&jwt.Authentication{AMR: []string{"pwd"}}),
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `Bearer error="insufficient_user_authentication", error_description="a stronger authentication is required", acr_values="2", max_age=300`,
+		},
+		{
+			name:          "Test_stale_authentication",
+			identity:      issue(&jwt.Authentication{ACR: "2", Time: time.Now().Add(-time.Hour)}),
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `Bearer error="insufficient_user_authentication", error_description="a more recent authentication is required", acr_values="2", max_age=300`,
+		},
+		{
+			name: "Test_missing_auth_time",
+			identity: &turboAuth.Identity{Claims: map[string]interface{}{"acr": "2",
+				"iat": float64(time.Now().Unix())}},
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `Bearer error="insufficient_user_authentication", error_description="a more recent authentication is required", acr_values="2", max_age=300`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/transfers", nil)
+			r = r.WithContext(turboAuth.NewContext(r.Context(), tt.identity))
+			w := httptest.NewRecorder()
+			stepUp.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != tt.wantChallenge {
+				t.Errorf("WWW-Authenticate = %v, want %v", got, tt.wantChallenge)
+			}
+			if tt.wantStatus != http.StatusOK && !strings.Contains(w.Body.String(), "authentication is required") {
+				t.Errorf("body = %v", w.Body.String())
+			}
+		})
+	}
+}
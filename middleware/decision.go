@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"bufio"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/logging"
+	"net"
+	"net/http"
+)
+
+type (
+	// DecisionFunc receives the decision trace of a request once it has been served
+	DecisionFunc func(r *http.Request, status int, trace *turboAuth.DecisionTrace)
+
+	// statusRecorder captures the status code of the response
+	statusRecorder struct {
+		http.ResponseWriter
+		status int
+	}
+)
+
+var logger = logging.Get()
+
+// TraceDecisions attaches a turboAuth.DecisionTrace to the requests, the authenticators and the policies placed
+// after it record which extractor found the token, which key verified it and which policy allowed or denied the
+// request. The trace is retrieved with turboAuth.DecisionTraceFromContext, and passed to onDecision once the
+// request is served, the trace is logged at debug level when onDecision is nil
+func TraceDecisions(onDecision DecisionFunc) Middleware {
+	if onDecision == nil {
+		onDecision = logDecision
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, trace := turboAuth.WithDecisionTrace(r.Context())
+			r = r.WithContext(ctx)
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+			onDecision(r, recorder.status, trace)
+		})
+	}
+}
+
+func logDecision(r *http.Request, status int, trace *turboAuth.DecisionTrace) {
+	logger.DebugF("authorization of %s %s answered %d: %s", r.Method, r.URL.Path, status, trace)
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps the streamed responses working behind the recorder
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack keeps the websocket upgrades working behind the recorder
+func (recorder *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := recorder.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"net/http"
+	"time"
+)
+
+type (
+	// AuthEvent is the outcome of the authentication of a request
+	AuthEvent struct {
+		// Identity is the authenticated identity, nil on failure
+		Identity *turboAuth.Identity
+		// Err explains the failure with the reason of the denying decision of the authenticator, nil on success
+		Err error
+		// Status is the status of the rejection, 0 on success
+		Status int
+		// Duration is the time spent authenticating the request
+		Duration time.Duration
+	}
+
+	// AuthHook receives the outcome of the authentication, the headers of the response can still be set, e.g. for
+	// analytics, alerting or custom headers
+	AuthHook func(w http.ResponseWriter, r *http.Request, event *AuthEvent)
+
+	// Hooks are invoked by AuthenticateWithHooks, the nil hooks are skipped
+	Hooks struct {
+		// OnAuthSuccess is invoked before the request is passed to the next handler
+		OnAuthSuccess AuthHook
+		// OnAuthFailure is invoked before the rejection of the authenticator is written
+		OnAuthFailure AuthHook
+	}
+
+	// hookWriter invokes the failure hook before the first write of the authenticator
+	hookWriter struct {
+		http.ResponseWriter
+		onWrite func(status int)
+	}
+)
+
+// AuthenticateWithHooks wraps the authenticator as a Middleware invoking the hooks with the outcome of the
+// authentication. The failures are explained by the decisions the authenticator records, a DecisionTrace is
+// attached to the request when TraceDecisions is not placed before
+func AuthenticateWithHooks(authenticator turboAuth.Authenticator, hooks Hooks) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			trace, ok := turboAuth.DecisionTraceFromContext(r.Context())
+			if !ok {
+				var ctx context.Context
+				ctx, trace = turboAuth.WithDecisionTrace(r.Context())
+				r = r.WithContext(ctx)
+			}
+			start := time.Now()
+			authenticated := false
+			writer := &hookWriter{ResponseWriter: w}
+			writer.onWrite = func(status int) {
+				writer.onWrite = nil
+				// e.g. the preflight requests answered by the authenticator are not failures
+				if authenticated || status < http.StatusBadRequest || hooks.OnAuthFailure == nil {
+					return
+				}
+				hooks.OnAuthFailure(w, r, &AuthEvent{Err: rejection(trace, status), Status: status,
+					Duration: time.Since(start)})
+			}
+			authenticator.Apply(http.HandlerFunc(func(inner http.ResponseWriter, r *http.Request) {
+				authenticated = true
+				if hooked, ok := inner.(*hookWriter); ok {
+					inner = hooked.ResponseWriter
+				}
+				if hooks.OnAuthSuccess != nil {
+					identity, _ := turboAuth.IdentityFromContext(r.Context())
+					hooks.OnAuthSuccess(inner, r, &AuthEvent{Identity: identity, Duration: time.Since(start)})
+				}
+				next.ServeHTTP(inner, r)
+			})).ServeHTTP(writer, r)
+		})
+	}
+}
+
+func (writer *hookWriter) WriteHeader(status int) {
+	if writer.onWrite != nil {
+		writer.onWrite(status)
+	}
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *hookWriter) Write(b []byte) (int, error) {
+	if writer.onWrite != nil {
+		writer.onWrite(http.StatusOK)
+	}
+	return writer.ResponseWriter.Write(b)
+}
+
+// rejection builds the error of the failure from the first denying decision, the status text otherwise
+func rejection(trace *turboAuth.DecisionTrace, status int) error {
+	if denied := trace.Denied(); denied != nil && denied.Reason != "" {
+		return errors.New(denied.Source + ": " + denied.Reason)
+	}
+	return errors.New(http.StatusText(status))
+}
@@ -0,0 +1,259 @@
+package middleware
+
+import (
+	"context"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/clientip"
+	"github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Middleware follows the func(http.Handler) http.Handler convention so it slots into chi, alice and negroni chains
+	Middleware func(http.Handler) http.Handler
+
+	// rateLimiter is a token bucket per client ip
+	rateLimiter struct {
+		mutex    sync.Mutex
+		requests int
+		per      time.Duration
+		buckets  map[string]*bucket
+		lastGC   time.Time
+	}
+
+	bucket struct {
+		tokens   float64
+		lastSeen time.Time
+	}
+
+	// optionalSlot receives the request let through by the authenticator of OptionalAuthenticate
+	optionalSlot struct {
+		r *http.Request
+	}
+
+	optionalSlotKey struct{}
+
+	// probeWriter keeps the headers written by the authenticator and discards the response of its failures
+	probeWriter struct {
+		header http.Header
+	}
+)
+
+// Chain composes the middlewares, the first one being the outermost
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// Authenticate wraps the authenticator as a Middleware
+func Authenticate(authenticator turboAuth.Authenticator) Middleware {
+	return authenticator.Apply
+}
+
+// OptionalAuthenticate populates the identity of the requests carrying a valid token and lets the other requests
+// proceed as anonymous, e.g. on the public pages personalized for the logged in users. The failures of the
+// authenticator, missing and invalid tokens alike, are not written to the response
+func OptionalAuthenticate(authenticator turboAuth.Authenticator) Middleware {
+	return func(next http.Handler) http.Handler {
+		authenticated := authenticator.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slot, ok := r.Context().Value(optionalSlotKey{}).(*optionalSlot); ok {
+				slot.r = r
+			}
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			slot := &optionalSlot{}
+			probe := &probeWriter{header: make(http.Header)}
+			authenticated.ServeHTTP(probe, r.WithContext(context.WithValue(r.Context(), optionalSlotKey{}, slot)))
+			if slot.r == nil {
+				turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+					Source: "OptionalAuthenticate", Allowed: true, Reason: "anonymous request"})
+				next.ServeHTTP(w, r)
+				return
+			}
+			// e.g. the token re-issued by the sliding expiration of the jwt authenticator
+			for name, values := range probe.header {
+				w.Header()[name] = values
+			}
+			next.ServeHTTP(w, slot.r)
+		})
+	}
+}
+
+func (probe *probeWriter) Header() http.Header {
+	return probe.header
+}
+
+func (probe *probeWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (probe *probeWriter) WriteHeader(int) {}
+
+// RequireRole lets the request through when the identity holds at least one of the roles
+func RequireRole(roles ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := turboAuth.IdentityFromContext(r.Context())
+			if !ok {
+				httpError := &errors.HttpError{
+					StatusCode: http.StatusUnauthorized,
+					Message:    "Incoming request cannot be authorized \n",
+				}
+				httpError.GenerateError(w, r)
+				return
+			}
+			for _, role := range roles {
+				if identity.HasRole(role) {
+					turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+						Source: "RequireRole", Allowed: true, Reason: "role " + role})
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+				Source: "RequireRole", Reason: "none of the roles " + strings.Join(roles, ", ")})
+			httpError := &errors.HttpError{
+				StatusCode: http.StatusForbidden,
+				Message:    "Error : insufficient role for the request \n",
+			}
+			httpError.GenerateError(w, r)
+		})
+	}
+}
+
+// RejectImpersonation refuses the requests of the impersonated identities, e.g. on the password and payment routes
+// which only the user may reach. Requests without an identity pass through
+func RejectImpersonation() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := turboAuth.IdentityFromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if actor, impersonated := identity.Actor(); impersonated {
+				turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+					Source: "RejectImpersonation", Reason: "impersonated by " + actor})
+				httpError := &errors.HttpError{
+					StatusCode: http.StatusForbidden,
+					Message:    "Error : not allowed while impersonating \n",
+				}
+				httpError.GenerateError(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimit allows the given number of requests per duration for every client ip, a non positive duration is a
+// second and a non positive number of requests allows one
+func RateLimit(requests int, per time.Duration) Middleware {
+	if per <= 0 {
+		per = time.Second
+	}
+	if requests <= 0 {
+		requests = 1
+	}
+	limiter := &rateLimiter{
+		requests: requests,
+		per:      per,
+		buckets:  make(map[string]*bucket),
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !limiter.allow(clientip.FromRequest(r), time.Now()) {
+				w.Header().Set("Retry-After", "1")
+				httpError := &errors.HttpError{
+					StatusCode: http.StatusTooManyRequests,
+					Message:    "Error : too many requests \n",
+				}
+				httpError.GenerateError(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SkipPaths bypasses the middleware for the given paths, a trailing * matches by prefix (e.g. /metrics/*). The
+// paths are matched once cleaned, see cleanPath
+func SkipPaths(middleware Middleware, paths ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		wrapped := middleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if matchPath(paths, cleanPath(r.URL.Path)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
+
+func matchPath(paths []string, requestPath string) bool {
+	for _, p := range paths {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(requestPath, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		} else if p == requestPath {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanPath resolves the dot segments of the path the way the muxes do before routing, so that /public/../admin
+// is matched as the /admin it is served as. The trailing slash is kept as http.ServeMux does
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	cleaned := path.Clean(p)
+	if p[len(p)-1] == '/' && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func (limiter *rateLimiter) allow(key string, now time.Time) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	rate := float64(limiter.requests) / float64(limiter.per)
+	if now.Sub(limiter.lastGC) > limiter.per {
+		// drop the buckets which are full again
+		for k, b := range limiter.buckets {
+			if now.Sub(b.lastSeen) > limiter.per {
+				delete(limiter.buckets, k)
+			}
+		}
+		limiter.lastGC = now
+	}
+	b, ok := limiter.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limiter.requests), lastSeen: now}
+		limiter.buckets[key] = b
+	}
+	b.tokens += float64(now.Sub(b.lastSeen)) * rate
+	if b.tokens > float64(limiter.requests) {
+		b.tokens = float64(limiter.requests)
+	}
+	b.lastSeen = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
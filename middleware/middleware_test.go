@@ -0,0 +1,478 @@
+package middleware
+
+import (
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func withIdentity(identity *turboAuth.Identity) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if identity != nil {
+				r = r.WithContext(turboAuth.NewContext(r.Context(), identity))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name       string
+		middleware Middleware
+		path       string
+		want       int
+	}{
+		{
+			name:       "Test_role_present",
+			middleware: Chain(withIdentity(&turboAuth.Identity{Roles: []string{"admin"}}), RequireRole("admin")),
+			path:       "/",
+			want:       http.StatusOK,
+		},
+		{
+			name:       "Test_role_missing",
+			middleware: Chain(withIdentity(&turboAuth.Identity{Roles: []string{"viewer"}}), RequireRole("admin")),
+			path:       "/",
+			want:       http.StatusForbidden,
+		},
+		{
+			name:       "Test_no_identity",
+			middleware: RequireRole("admin"),
+			path:       "/",
+			want:       http.StatusUnauthorized,
+		},
+		{
+			name:       "Test_skip_exact_path",
+			middleware: SkipPaths(RequireRole("admin"), "/healthz"),
+			path:       "/healthz",
+			want:       http.StatusOK,
+		},
+		{
+			name:       "Test_skip_prefix_path",
+			middleware: SkipPaths(RequireRole("admin"), "/metrics/*"),
+			path:       "/metrics/auth",
+			want:       http.StatusOK,
+		},
+		{
+			name:       "Test_skip_traversal_path",
+			middleware: SkipPaths(RequireRole("admin"), "/metrics/*"),
+			path:       "/metrics/../admin",
+			want:       http.StatusUnauthorized,
+		},
+		{
+			name:       "Test_skip_dot_segments",
+			middleware: SkipPaths(RequireRole("admin"), "/healthz"),
+			path:       "/admin/./../healthz",
+			want:       http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.middleware(ok).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests int
+		per      time.Duration
+		want     []int
+	}{
+		{name: "Test_limited", requests: 2, per: time.Minute, want: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+		{name: "Test_zero_duration", requests: 2, want: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+		{name: "Test_zero_requests", per: time.Minute, want: []int{http.StatusOK, http.StatusTooManyRequests}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RateLimit(tt.requests, tt.per)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			for i, code := range tt.want {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+				if w.Code != code {
+					t.Errorf("request %v status = %v, want %v", i, w.Code, code)
+				}
+			}
+		})
+	}
+}
+
+type identityAuthenticator struct {
+	identity *turboAuth.Identity
+}
+
+func (a identityAuthenticator) Apply(next http.Handler) http.Handler {
+	return withIdentity(a.identity)(next)
+}
+
+func TestRoutes(t *testing.T) {
+	matcher := NewRouteMatcher(
+		PublicRoute("/healthz", http.MethodGet),
+		PublicRoute("/public/**"),
+		ScopedRoute("/admin/**", []string{"admin:write"}, http.MethodPost),
+	)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name     string
+		identity *turboAuth.Identity
+		method   string
+		path     string
+		want     int
+	}{
+		{name: "Test_public_route", method: http.MethodGet, path: "/healthz", want: http.StatusOK},
+		{name: "Test_public_route_other_method", method: http.MethodPost, path: "/healthz", want: http.StatusOK},
+		{name: "Test_scope_missing", identity: &turboAuth.Identity{}, method: http.MethodPost, path: "/admin/users", want: http.StatusForbidden},
+		{name: "Test_scope_present", identity: &turboAuth.Identity{Scopes: []string{"admin:write"}}, method: http.MethodPost, path: "/admin/users", want: http.StatusOK},
+		{name: "Test_scope_other_method", identity: &turboAuth.Identity{}, method: http.MethodGet, path: "/admin/users", want: http.StatusOK},
+		{name: "Test_public_route_traversal", identity: &turboAuth.Identity{}, method: http.MethodPost, path: "/public/../admin/users", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Routes(identityAuthenticator{tt.identity}, matcher)(ok).ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v", w.Code, tt.want)
+			}
+			wantChallenge := ""
+			if tt.want == http.StatusForbidden {
+				wantChallenge = `Bearer error="insufficient_scope", error_description="insufficient scope for the request", scope="admin:write"`
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != wantChallenge {
+				t.Errorf("WWW-Authenticate = %v, want %v", got, wantChallenge)
+			}
+		})
+	}
+}
+
+// countingAuthenticator counts the chains built with Apply
+type countingAuthenticator struct {
+	identity *turboAuth.Identity
+	applied  *int
+}
+
+func (a countingAuthenticator) Apply(next http.Handler) http.Handler {
+	*a.applied++
+	return identityAuthenticator{a.identity}.Apply(next)
+}
+
+func TestRoutes_chains(t *testing.T) {
+	matcher := NewRouteMatcher(ScopedRoute("/admin/**", []string{"admin:write"}))
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "Test_scoped_route_reuses_chain", path: "/admin/users"},
+		{name: "Test_unmatched_route_reuses_chain", path: "/users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applied := 0
+			handler := Routes(countingAuthenticator{&turboAuth.Identity{Scopes: []string{"admin:write"}}, &applied}, matcher)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			built := applied
+			for i := 0; i < 3; i++ {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+				if w.Code != http.StatusOK {
+					t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+				}
+			}
+			if applied != built {
+				t.Errorf("Apply() called %v times while serving, want 0", applied-built)
+			}
+		})
+	}
+}
+
+// headerAuthenticator accepts the "valid" token of the X-Token header
+type headerAuthenticator struct{}
+
+func (headerAuthenticator) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "valid" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-Refreshed", "true")
+		next.ServeHTTP(w, r.WithContext(turboAuth.NewContext(r.Context(), &turboAuth.Identity{Subject: "user"})))
+	})
+}
+
+func TestOptionalAuthenticate(t *testing.T) {
+	handler := OptionalAuthenticate(headerAuthenticator{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if identity, ok := turboAuth.IdentityFromContext(r.Context()); ok {
+			_, _ = w.Write([]byte(identity.Subject))
+		}
+	}))
+	tests := []struct {
+		name        string
+		token       string
+		wantBody    string
+		wantHeaders bool
+	}{
+		{name: "Test_anonymous"},
+		{name: "Test_invalid_token", token: "expired"},
+		{name: "Test_valid_token", token: "valid", wantBody: "user", wantHeaders: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-Token", tt.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != http.StatusOK || w.Body.String() != tt.wantBody || w.Header().Get("WWW-Authenticate") != "" {
+				t.Errorf("status = %v, body = %v, headers = %v", w.Code, w.Body.String(), w.Header())
+			}
+			if got := w.Header().Get("X-Refreshed") != ""; got != tt.wantHeaders {
+				t.Errorf("authenticator headers kept = %v, want %v", got, tt.wantHeaders)
+			}
+		})
+	}
+}
+
+func TestRejectImpersonation(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name     string
+		identity *turboAuth.Identity
+		want     int
+	}{
+		{name: "Test_anonymous", want: http.StatusOK},
+		{name: "Test_user", identity: &turboAuth.Identity{Subject: "user"}, want: http.StatusOK},
+		{
+			name:     "Test_impersonated",
+			identity: &turboAuth.Identity{Subject: "user", Claims: map[string]interface{}{"act": map[string]interface{}{"sub": "agent"}}},
+			want:     http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Chain(withIdentity(tt.identity), RejectImpersonation())(ok).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireTenant(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	guard := RequireTenant(TenantFromPath("/tenants/{tenant}/**"), OverrideRoles("support"))
+	tests := []struct {
+		name     string
+		identity *turboAuth.Identity
+		path     string
+		want     int
+	}{
+		{name: "Test_unscoped_route", path: "/health", want: http.StatusOK},
+		{name: "Test_anonymous", path: "/tenants/acme/orders", want: http.StatusUnauthorized},
+		{name: "Test_same_tenant", identity: &turboAuth.Identity{Subject: "alice", Tenant: "acme"}, path: "/tenants/acme/orders", want: http.StatusOK},
+		{name: "Test_tenant_root", identity: &turboAuth.Identity{Subject: "alice", Tenant: "acme"}, path: "/tenants/acme", want: http.StatusOK},
+		{name: "Test_cross_tenant", identity: &turboAuth.Identity{Subject: "alice", Tenant: "acme"}, path: "/tenants/globex/orders", want: http.StatusForbidden},
+		{name: "Test_tenant_claim", identity: &turboAuth.Identity{Subject: "alice", Claims: map[string]interface{}{"tid": "globex"}}, path: "/tenants/globex/orders", want: http.StatusOK},
+		{name: "Test_no_tenant", identity: &turboAuth.Identity{Subject: "alice"}, path: "/tenants/acme/orders", want: http.StatusForbidden},
+		{name: "Test_override", identity: &turboAuth.Identity{Subject: "bob", Tenant: "acme", Roles: []string{"support"}}, path: "/tenants/globex/orders", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Chain(withIdentity(tt.identity), guard)(ok).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantFromPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		pattern    string
+		path       string
+		want       string
+		wantScoped bool
+	}{
+		{name: "Test_exact", pattern: "/tenants/{tenant}", path: "/tenants/acme", want: "acme", wantScoped: true},
+		{name: "Test_exact_sub_path", pattern: "/tenants/{tenant}", path: "/tenants/acme/orders"},
+		{name: "Test_sub_path", pattern: "/tenants/{tenant}/**", path: "/tenants/acme/orders/1", want: "acme", wantScoped: true},
+		{name: "Test_wildcard", pattern: "/api/*/{tenant}/**", path: "/api/v2/acme/orders", want: "acme", wantScoped: true},
+		{name: "Test_other_prefix", pattern: "/tenants/{tenant}/**", path: "/users/acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, scoped := TenantFromPath(tt.pattern)(httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if got != tt.want || scoped != tt.wantScoped {
+				t.Errorf("TenantFromPath() = %v, %v, want %v, %v", got, scoped, tt.want, tt.wantScoped)
+			}
+		})
+	}
+}
+
+func TestTraceDecisions(t *testing.T) {
+	matcher := NewRouteMatcher(
+		PublicRoute("/healthz"),
+		ScopedRoute("/admin/**", []string{"admin:write"}),
+	)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       string
+	}{
+		{name: "Test_public", path: "/healthz", wantStatus: http.StatusOK, want: "policy Routes allowed: public route /healthz"},
+		{name: "Test_denied", path: "/admin/users", wantStatus: http.StatusForbidden, want: "policy RequireScopes denied: missing scope admin:write"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var status int
+			var trace *turboAuth.DecisionTrace
+			handler := Chain(
+				TraceDecisions(func(r *http.Request, s int, tr *turboAuth.DecisionTrace) {
+					status, trace = s, tr
+				}),
+				Routes(identityAuthenticator{&turboAuth.Identity{}}, matcher),
+			)(ok)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if status != tt.wantStatus || trace == nil || trace.String() != tt.want {
+				t.Errorf("status = %v, trace = %v, want %v, %v", status, trace, tt.wantStatus, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry_Authenticator(t *testing.T) {
+	registry := turboAuth.NewRegistry()
+	requireAdmin := Chain(Authenticate(registry.Authenticator("jwt")), RequireRole("admin"))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() int {
+		w := httptest.NewRecorder()
+		requireAdmin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	if got := serve(); got != http.StatusServiceUnavailable {
+		t.Errorf("status before register = %v, want %v", got, http.StatusServiceUnavailable)
+	}
+	registry.Register("jwt", identityAuthenticator{&turboAuth.Identity{Roles: []string{"viewer"}}})
+	if got := serve(); got != http.StatusForbidden {
+		t.Errorf("status with viewer = %v, want %v", got, http.StatusForbidden)
+	}
+	// swapped without rebuilding the middleware chain
+	var applied int
+	registry.Register("jwt", countingAuthenticator{&turboAuth.Identity{Roles: []string{"admin"}}, &applied})
+	for i := 0; i < 3; i++ {
+		if got := serve(); got != http.StatusOK {
+			t.Errorf("status with admin = %v, want %v", got, http.StatusOK)
+		}
+	}
+	if applied != 1 {
+		t.Errorf("chains built = %v, want 1", applied)
+	}
+	if names := registry.Names(); len(names) != 1 || names[0] != "jwt" {
+		t.Errorf("Names() = %v", names)
+	}
+	registry.Remove("jwt")
+	if got := serve(); got != http.StatusServiceUnavailable {
+		t.Errorf("status after remove = %v, want %v", got, http.StatusServiceUnavailable)
+	}
+}
+
+func TestStreamExpiry(t *testing.T) {
+	expired := make(chan string, 1)
+	onExpired := func(r *http.Request, identity *turboAuth.Identity) {
+		expired <- identity.Subject
+	}
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			if TokenExpired(r.Context()) {
+				w.WriteHeader(http.StatusNoContent)
+			}
+		case <-time.After(time.Second):
+		}
+	})
+	tests := []struct {
+		name        string
+		expiresIn   time.Duration
+		want        int
+		wantExpired bool
+	}{
+		{name: "Test_expires_mid_stream", expiresIn: 20 * time.Millisecond, want: http.StatusNoContent, wantExpired: true},
+		{name: "Test_already_expired", expiresIn: -time.Second, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := &turboAuth.Identity{Subject: "test_user", ExpiresAt: time.Now().Add(tt.expiresIn)}
+			w := httptest.NewRecorder()
+			Chain(withIdentity(identity), StreamExpiry(onExpired))(stream).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v", w.Code, tt.want)
+			}
+			select {
+			case subject := <-expired:
+				if !tt.wantExpired || subject != "test_user" {
+					t.Errorf("onExpired(%v) unexpected", subject)
+				}
+			case <-time.After(50 * time.Millisecond):
+				if tt.wantExpired {
+					t.Errorf("onExpired() not invoked")
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithHooks(t *testing.T) {
+	var event *AuthEvent
+	var success bool
+	handler := AuthenticateWithHooks(headerAuthenticator{}, Hooks{
+		OnAuthSuccess: func(w http.ResponseWriter, r *http.Request, e *AuthEvent) {
+			event, success = e, true
+			w.Header().Set("X-Subject", e.Identity.Subject)
+		},
+		OnAuthFailure: func(w http.ResponseWriter, r *http.Request, e *AuthEvent) {
+			event, success = e, false
+			w.Header().Set("X-Auth-Failure", e.Err.Error())
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name        string
+		token       string
+		wantSuccess bool
+		wantStatus  int
+		wantHeader  string
+	}{
+		{name: "Test_success", token: "valid", wantSuccess: true, wantHeader: "X-Subject"},
+		{name: "Test_failure", token: "expired", wantStatus: http.StatusUnauthorized, wantHeader: "X-Auth-Failure"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event = nil
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-Token", tt.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if event == nil || success != tt.wantSuccess || event.Status != tt.wantStatus {
+				t.Fatalf("event = %+v, success = %v, want %v, %v", event, success, tt.wantSuccess, tt.wantStatus)
+			}
+			if (event.Identity != nil) != tt.wantSuccess || (event.Err != nil) == tt.wantSuccess {
+				t.Errorf("event = %+v", event)
+			}
+			if w.Header().Get(tt.wantHeader) == "" {
+				t.Errorf("headers = %v, want %s set by the hook", w.Header(), tt.wantHeader)
+			}
+		})
+	}
+}
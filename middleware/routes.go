@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type (
+	// Route selects requests by path and method
+	Route struct {
+		// Pattern is a glob in path.Match syntax, a trailing /** matches any sub path. Ignored when Regex is set
+		Pattern string
+		Regex   *regexp.Regexp
+		// Methods restricts the route to the given http methods, empty matches all methods
+		Methods []string
+		// Public routes skip authentication altogether
+		Public bool
+		// Scopes are all required on the identity of the request
+		Scopes []string
+	}
+
+	// RouteMatcher holds the per route configuration, the first matching route wins
+	RouteMatcher struct {
+		Routes []Route
+	}
+)
+
+func NewRouteMatcher(routes ...Route) *RouteMatcher {
+	return &RouteMatcher{Routes: routes}
+}
+
+// PublicRoute is a Route exempted from authentication, e.g. /healthz, /metrics or /login
+func PublicRoute(pattern string, methods ...string) Route {
+	return Route{Pattern: pattern, Methods: methods, Public: true}
+}
+
+// ScopedRoute is a Route requiring all the scopes on the identity
+func ScopedRoute(pattern string, scopes []string, methods ...string) Route {
+	return Route{Pattern: pattern, Methods: methods, Scopes: scopes}
+}
+
+// Match returns the first route matching the request
+func (matcher *RouteMatcher) Match(r *http.Request) (*Route, bool) {
+	if i := matcher.index(r); i >= 0 {
+		return &matcher.Routes[i], true
+	}
+	return nil, false
+}
+
+// index returns the index of the first route matching the request, -1 when none matches
+func (matcher *RouteMatcher) index(r *http.Request) int {
+	for i := range matcher.Routes {
+		if matcher.Routes[i].Matches(r) {
+			return i
+		}
+	}
+	return -1
+}
+
+// describe names the route in the decision traces
+func (route *Route) describe() string {
+	if route.Regex != nil {
+		return route.Regex.String()
+	}
+	return route.Pattern
+}
+
+func (route *Route) Matches(r *http.Request) bool {
+	if len(route.Methods) > 0 {
+		found := false
+		for _, method := range route.Methods {
+			if strings.EqualFold(method, r.Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	// the path is matched as the mux routes it, e.g. /public/../admin as /admin
+	requestPath := cleanPath(r.URL.Path)
+	if route.Regex != nil {
+		return route.Regex.MatchString(requestPath)
+	}
+	return globMatch(route.Pattern, requestPath)
+}
+
+// Routes authenticates the requests according to the matcher: public routes are skipped, the others go through
+// the authenticator and have their scopes enforced. Unmatched requests are authenticated without scope checks
+func Routes(authenticator turboAuth.Authenticator, matcher *RouteMatcher) Middleware {
+	return func(next http.Handler) http.Handler {
+		authenticated := authenticator.Apply(next)
+		// the chains of the scoped routes are built once, the routes must not change afterwards
+		scoped := make([]http.Handler, len(matcher.Routes))
+		for i, route := range matcher.Routes {
+			if !route.Public && len(route.Scopes) > 0 {
+				scoped[i] = authenticator.Apply(RequireScopes(route.Scopes...)(next))
+			}
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			i := matcher.index(r)
+			if i < 0 || i >= len(scoped) {
+				authenticated.ServeHTTP(w, r)
+				return
+			}
+			route := &matcher.Routes[i]
+			if route.Public {
+				turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+					Source: "Routes", Allowed: true, Reason: "public route " + route.describe()})
+				next.ServeHTTP(w, r)
+				return
+			}
+			if scoped[i] == nil {
+				authenticated.ServeHTTP(w, r)
+				return
+			}
+			scoped[i].ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScopes lets the request through when the identity holds all the scopes, the missing scopes are answered
+// with 403 and the insufficient_scope Bearer challenge of RFC 6750
+func RequireScopes(scopes ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := turboAuth.IdentityFromContext(r.Context())
+			if !ok {
+				httpError := &errors.HttpError{
+					StatusCode: http.StatusUnauthorized,
+					Message:    "Incoming request cannot be authorized \n",
+				}
+				httpError.GenerateError(w, r)
+				return
+			}
+			for _, scope := range scopes {
+				if !identity.HasScope(scope) {
+					turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+						Source: "RequireScopes", Reason: "missing scope " + scope})
+					httpError := &errors.HttpError{
+						StatusCode: http.StatusForbidden,
+						Message:    "Error : insufficient scope for the request \n",
+						Err:        errors.ErrInsufficientScope,
+						Scope:      strings.Join(scopes, " "),
+					}
+					w.Header().Set(errors.HeaderWWWAuthenticate, errors.BearerChallenge("", httpError))
+					httpError.GenerateError(w, r)
+					return
+				}
+			}
+			turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+				Source: "RequireScopes", Allowed: true, Reason: "scopes " + strings.Join(scopes, " ")})
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func globMatch(pattern string, p string) bool {
+	if strings.HasSuffix(pattern, "/**") {
+		prefix := strings.TrimSuffix(pattern, "/**")
+		return p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+	matched, err := path.Match(pattern, p)
+	return err == nil && matched
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"time"
+)
+
+type (
+	// StreamExpiredFunc is notified when the token of a long-lived response expires, it runs on its own goroutine
+	// and must not write to the response
+	StreamExpiredFunc func(r *http.Request, identity *turboAuth.Identity)
+
+	tokenExpiryKey struct{}
+)
+
+// StreamExpiry bounds the long-lived responses (server-sent events, long-polls) by the expiry of the token rather
+// than only checking it at connect time: once the token expires onExpired (which may be nil) is invoked and the
+// request context is cancelled, so the handler returns and the connection is released. It must be placed after
+// the authenticator, requests without an identity or an expiry pass through
+func StreamExpiry(onExpired StreamExpiredFunc) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := turboAuth.IdentityFromContext(r.Context())
+			if !ok || identity.ExpiresAt.IsZero() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !time.Now().Before(identity.ExpiresAt) {
+				httpError := &errors.HttpError{
+					StatusCode: http.StatusUnauthorized,
+					Message:    "Error : token has expired \n",
+				}
+				httpError.GenerateError(w, r)
+				return
+			}
+			ctx, cancel := context.WithCancel(context.WithValue(r.Context(), tokenExpiryKey{}, identity.ExpiresAt))
+			defer cancel()
+			r = r.WithContext(ctx)
+			timer := time.AfterFunc(time.Until(identity.ExpiresAt), func() {
+				if onExpired != nil {
+					onExpired(r, identity)
+				}
+				cancel()
+			})
+			defer timer.Stop()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TokenExpired reports whether the context was cancelled by StreamExpiry, e.g. to emit a final event asking the
+// client to reconnect with a fresh token
+func TokenExpired(ctx context.Context) bool {
+	expiresAt, ok := ctx.Value(tokenExpiryKey{}).(time.Time)
+	return ok && ctx.Err() != nil && !time.Now().Before(expiresAt)
+}
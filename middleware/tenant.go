@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"strings"
+)
+
+type (
+	// TenantFunc returns the tenant addressed by the request, false when the request is not tenant scoped. Route
+	// parameters of the routers are read with a closure, e.g. func(r *http.Request) (string, bool) { tenant :=
+	// chi.URLParam(r, "tenant"); return tenant, tenant != "" }
+	TenantFunc func(r *http.Request) (string, bool)
+
+	// TenantOverride lets the identity access the tenant of another one, e.g. the support staff
+	TenantOverride func(r *http.Request, identity *turboAuth.Identity, tenant string) bool
+)
+
+// TenantClaims are the claims holding the tenant of the identity when the provider did not set Identity.Tenant
+var TenantClaims = []string{"tenant", "tid"}
+
+// TenantFromPath reads the tenant from the segment {tenant} of the pattern, e.g. /tenants/{tenant}/**. A * segment
+// matches any segment and a trailing /** any sub path
+func TenantFromPath(pattern string) TenantFunc {
+	anySubPath := strings.HasSuffix(pattern, "/**")
+	segments := strings.Split(strings.Trim(strings.TrimSuffix(pattern, "/**"), "/"), "/")
+	return func(r *http.Request) (string, bool) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) < len(segments) || (!anySubPath && len(parts) != len(segments)) {
+			return "", false
+		}
+		tenant := ""
+		for i, segment := range segments {
+			switch segment {
+			case "{tenant}":
+				tenant = parts[i]
+			case "*":
+			default:
+				if segment != parts[i] {
+					return "", false
+				}
+			}
+		}
+		return tenant, tenant != ""
+	}
+}
+
+// TenantFromHeader reads the tenant from the request header, e.g. X-Tenant-ID
+func TenantFromHeader(name string) TenantFunc {
+	return func(r *http.Request) (string, bool) {
+		tenant := r.Header.Get(name)
+		return tenant, tenant != ""
+	}
+}
+
+// TenantFromQuery reads the tenant from the query parameter
+func TenantFromQuery(name string) TenantFunc {
+	return func(r *http.Request) (string, bool) {
+		tenant := r.URL.Query().Get(name)
+		return tenant, tenant != ""
+	}
+}
+
+// OverrideRoles lets the identities holding one of the roles access every tenant
+func OverrideRoles(roles ...string) TenantOverride {
+	return func(_ *http.Request, identity *turboAuth.Identity, _ string) bool {
+		for _, role := range roles {
+			if identity.HasRole(role) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RequireTenant rejects with 403 the requests addressing a tenant other than the one of the identity, unless the
+// override allows them. The requests the TenantFunc finds no tenant in are let through
+func RequireTenant(tenant TenantFunc, override TenantOverride) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested, scoped := tenant(r)
+			if !scoped {
+				next.ServeHTTP(w, r)
+				return
+			}
+			identity, ok := turboAuth.IdentityFromContext(r.Context())
+			if !ok {
+				httpError := &errors.HttpError{
+					StatusCode: http.StatusUnauthorized,
+					Message:    "Incoming request cannot be authorized \n",
+				}
+				httpError.GenerateError(w, r)
+				return
+			}
+			if identityTenant(identity) == requested {
+				turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+					Source: "RequireTenant", Allowed: true, Reason: "tenant " + requested})
+				next.ServeHTTP(w, r)
+				return
+			}
+			if override != nil && override(r, identity, requested) {
+				turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+					Source: "RequireTenant", Allowed: true, Reason: "override for tenant " + requested})
+				next.ServeHTTP(w, r)
+				return
+			}
+			turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy,
+				Source: "RequireTenant", Reason: "cross tenant access to " + requested})
+			httpError := &errors.HttpError{
+				StatusCode: http.StatusForbidden,
+				Message:    "Error : access to the tenant is not allowed \n",
+			}
+			httpError.GenerateError(w, r)
+		})
+	}
+}
+
+// identityTenant returns the tenant of the identity, empty when it has none so that it matches no tenant
+func identityTenant(identity *turboAuth.Identity) string {
+	if identity.Tenant != "" {
+		return identity.Tenant
+	}
+	for _, claim := range TenantClaims {
+		if tenant, ok := identity.Claims[claim].(string); ok && tenant != "" {
+			return tenant
+		}
+	}
+	return ""
+}
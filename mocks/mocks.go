@@ -0,0 +1,208 @@
+package mocks
+
+import (
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"github.com/nandlabs/turbo-auth/sessions"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The mocks call the Func field of a method when set and return zero values otherwise, every call is recorded.
+// They are safe for concurrent use
+type (
+	Authenticator struct {
+		ApplyFunc        func(next http.Handler) http.Handler
+		AuthenticateFunc func(token string) (*turboAuth.Identity, error)
+
+		mutex   sync.Mutex
+		Tokens  []string
+		Applied int
+	}
+
+	KeyStore struct {
+		CurrentKeyFunc func() (*jwt.Key, error)
+		KeyFunc        func(kid string) (*jwt.Key, error)
+		KeysFunc       func() []*jwt.Key
+		RotateFunc     func(key *jwt.Key) error
+		RemoveFunc     func(kid string) error
+
+		mutex   sync.Mutex
+		Kids    []string
+		Rotated []*jwt.Key
+		Removed []string
+	}
+
+	RevokeCall struct {
+		JTI       string
+		ExpiresAt time.Time
+	}
+
+	Revoker struct {
+		RevokeFunc    func(jti string, expiresAt time.Time) error
+		IsRevokedFunc func(jti string) (bool, error)
+
+		mutex   sync.Mutex
+		Revoked []RevokeCall
+		Checked []string
+	}
+
+	SaveCall struct {
+		Session *sessions.Session
+		TTL     time.Duration
+	}
+
+	SessionStore struct {
+		SaveFunc   func(session *sessions.Session, ttl time.Duration) error
+		LoadFunc   func(id string) (*sessions.Session, error)
+		DeleteFunc func(id string) error
+
+		mutex   sync.Mutex
+		Saved   []SaveCall
+		Loaded  []string
+		Deleted []string
+	}
+
+	AuditLogger struct {
+		LogFunc func(event *audit.Event)
+
+		mutex  sync.Mutex
+		Events []*audit.Event
+	}
+)
+
+// Apply passes the requests through unless ApplyFunc is set
+func (m *Authenticator) Apply(next http.Handler) http.Handler {
+	m.mutex.Lock()
+	m.Applied++
+	m.mutex.Unlock()
+	if m.ApplyFunc != nil {
+		return m.ApplyFunc(next)
+	}
+	return next
+}
+
+func (m *Authenticator) Authenticate(token string) (*turboAuth.Identity, error) {
+	m.mutex.Lock()
+	m.Tokens = append(m.Tokens, token)
+	m.mutex.Unlock()
+	if m.AuthenticateFunc != nil {
+		return m.AuthenticateFunc(token)
+	}
+	return nil, nil
+}
+
+func (m *KeyStore) CurrentKey() (*jwt.Key, error) {
+	if m.CurrentKeyFunc != nil {
+		return m.CurrentKeyFunc()
+	}
+	return nil, nil
+}
+
+func (m *KeyStore) Key(kid string) (*jwt.Key, error) {
+	m.mutex.Lock()
+	m.Kids = append(m.Kids, kid)
+	m.mutex.Unlock()
+	if m.KeyFunc != nil {
+		return m.KeyFunc(kid)
+	}
+	return nil, nil
+}
+
+func (m *KeyStore) Keys() []*jwt.Key {
+	if m.KeysFunc != nil {
+		return m.KeysFunc()
+	}
+	return nil
+}
+
+func (m *KeyStore) Rotate(key *jwt.Key) error {
+	m.mutex.Lock()
+	m.Rotated = append(m.Rotated, key)
+	m.mutex.Unlock()
+	if m.RotateFunc != nil {
+		return m.RotateFunc(key)
+	}
+	return nil
+}
+
+func (m *KeyStore) Remove(kid string) error {
+	m.mutex.Lock()
+	m.Removed = append(m.Removed, kid)
+	m.mutex.Unlock()
+	if m.RemoveFunc != nil {
+		return m.RemoveFunc(kid)
+	}
+	return nil
+}
+
+func (m *Revoker) Revoke(jti string, expiresAt time.Time) error {
+	m.mutex.Lock()
+	m.Revoked = append(m.Revoked, RevokeCall{JTI: jti, ExpiresAt: expiresAt})
+	m.mutex.Unlock()
+	if m.RevokeFunc != nil {
+		return m.RevokeFunc(jti, expiresAt)
+	}
+	return nil
+}
+
+func (m *Revoker) IsRevoked(jti string) (bool, error) {
+	m.mutex.Lock()
+	m.Checked = append(m.Checked, jti)
+	m.mutex.Unlock()
+	if m.IsRevokedFunc != nil {
+		return m.IsRevokedFunc(jti)
+	}
+	return false, nil
+}
+
+func (m *SessionStore) Save(session *sessions.Session, ttl time.Duration) error {
+	m.mutex.Lock()
+	m.Saved = append(m.Saved, SaveCall{Session: session, TTL: ttl})
+	m.mutex.Unlock()
+	if m.SaveFunc != nil {
+		return m.SaveFunc(session, ttl)
+	}
+	return nil
+}
+
+// Load returns sessions.ErrSessionNotFound unless LoadFunc is set
+func (m *SessionStore) Load(id string) (*sessions.Session, error) {
+	m.mutex.Lock()
+	m.Loaded = append(m.Loaded, id)
+	m.mutex.Unlock()
+	if m.LoadFunc != nil {
+		return m.LoadFunc(id)
+	}
+	return nil, sessions.ErrSessionNotFound
+}
+
+func (m *SessionStore) Delete(id string) error {
+	m.mutex.Lock()
+	m.Deleted = append(m.Deleted, id)
+	m.mutex.Unlock()
+	if m.DeleteFunc != nil {
+		return m.DeleteFunc(id)
+	}
+	return nil
+}
+
+func (m *AuditLogger) Log(event *audit.Event) {
+	m.mutex.Lock()
+	m.Events = append(m.Events, event)
+	m.mutex.Unlock()
+	if m.LogFunc != nil {
+		m.LogFunc(event)
+	}
+}
+
+var (
+	_ turboAuth.Authenticator      = (*Authenticator)(nil)
+	_ turboAuth.TokenAuthenticator = (*Authenticator)(nil)
+	_ jwt.KeyStore                 = (*KeyStore)(nil)
+	_ jwt.Revoker                  = (*Revoker)(nil)
+	_ sessions.SessionStore        = (*SessionStore)(nil)
+	_ audit.AuditLogger            = (*AuditLogger)(nil)
+)
@@ -0,0 +1,36 @@
+package mocks
+
+import (
+	"github.com/nandlabs/turbo-auth/audit"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"testing"
+	"time"
+)
+
+func TestRevoker_AuditLogger(t *testing.T) {
+	revoker := &Revoker{IsRevokedFunc: func(jti string) (bool, error) { return true, nil }}
+	auditLogger := &AuditLogger{}
+	authenticator, err := jwt.NewJwtAuthenticator(
+		jwt.WithSigningKey("test_key"),
+		jwt.WithRevoker(revoker),
+		jwt.WithAuditLogger(auditLogger),
+	)
+	if err != nil {
+		t.Fatalf("NewJwtAuthenticator() error = %v", err)
+	}
+	token, jwtErr := authenticator.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	identity, _ := authenticator.Authenticate(token)
+	if identity != nil {
+		t.Errorf("Authenticate() of a revoked token = %v", identity)
+	}
+	if len(revoker.Checked) != 1 {
+		t.Errorf("IsRevoked() calls = %v, want 1", revoker.Checked)
+	}
+	if len(auditLogger.Events) != 2 || auditLogger.Events[1].Type != audit.EventTokenValidation ||
+		auditLogger.Events[1].Outcome != audit.OutcomeFailure {
+		t.Errorf("audit events = %v", auditLogger.Events)
+	}
+}
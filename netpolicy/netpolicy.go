@@ -0,0 +1,182 @@
+package netpolicy
+
+import (
+	"fmt"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/clientip"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/logging"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type (
+	// Policy restricts the requests by the address of the client, the denylisted ranges are always rejected and the
+	// allowlist, when not empty, is the only accepted ranges. The address is derived by the Resolver, by
+	// clientip.FromRequest when nil
+	Policy struct {
+		Allow    []*net.IPNet
+		Deny     []*net.IPNet
+		Resolver *clientip.Resolver
+		// Country resolves the country code of an address, e.g. from a GeoIP database, it is required by the
+		// AllowCountries and DenyCountries restrictions
+		Country        CountryFunc
+		AllowCountries []string
+		DenyCountries  []string
+		// Subjects and Roles restrict the identities to their own ranges, see RestrictIdentities
+		Subjects    map[string][]*net.IPNet
+		Roles       map[string][]*net.IPNet
+		ErrorWriter turboError.ErrorWriter
+	}
+
+	// CountryFunc returns the ISO 3166 country code of the address, empty when unknown
+	CountryFunc func(ip net.IP) string
+)
+
+var logger = logging.Get()
+
+// NewPolicy parses the allowed, the denied and the trusted proxy ranges, in the CIDR notation or as single addresses.
+// The client address is read from the X-Forwarded-For header of the trusted proxies, set the Resolver for another one
+func NewPolicy(allow []string, deny []string, trustedProxies []string) (*Policy, error) {
+	policy := &Policy{}
+	var err error
+	if policy.Allow, err = ParseCIDRs(allow...); err != nil {
+		return nil, err
+	}
+	if policy.Deny, err = ParseCIDRs(deny...); err != nil {
+		return nil, err
+	}
+	if len(trustedProxies) > 0 {
+		if policy.Resolver, err = clientip.NewResolver(clientip.HeaderXForwardedFor, trustedProxies...); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// ParseCIDRs parses the ranges, a single address is a range of its own
+func ParseCIDRs(cidrs ...string) ([]*net.IPNet, error) {
+	return clientip.ParseCIDRs(cidrs...)
+}
+
+// RestrictSubject only accepts the identity of the subject from the ranges
+func (p *Policy) RestrictSubject(subject string, cidrs ...string) error {
+	networks, err := ParseCIDRs(cidrs...)
+	if err != nil {
+		return err
+	}
+	if p.Subjects == nil {
+		p.Subjects = make(map[string][]*net.IPNet)
+	}
+	p.Subjects[subject] = append(p.Subjects[subject], networks...)
+	return nil
+}
+
+// RestrictRole only accepts the identities holding the role from the ranges, e.g. the admins from the office network
+func (p *Policy) RestrictRole(role string, cidrs ...string) error {
+	networks, err := ParseCIDRs(cidrs...)
+	if err != nil {
+		return err
+	}
+	if p.Roles == nil {
+		p.Roles = make(map[string][]*net.IPNet)
+	}
+	p.Roles[role] = append(p.Roles[role], networks...)
+	return nil
+}
+
+// ClientIP returns the address of the client, see clientip.Resolver
+func (p *Policy) ClientIP(r *http.Request) net.IP {
+	if p.Resolver != nil {
+		return p.Resolver.ClientIP(r)
+	}
+	return net.ParseIP(clientip.FromRequest(r))
+}
+
+// Allowed reports whether the address is accepted by the ranges and the countries of the policy, along with the
+// reason otherwise
+func (p *Policy) Allowed(ip net.IP) (bool, string) {
+	if ip == nil {
+		return false, "unknown client address"
+	}
+	if clientip.Contains(p.Deny, ip) {
+		return false, "address " + ip.String() + " is denylisted"
+	}
+	if len(p.Allow) > 0 && !clientip.Contains(p.Allow, ip) {
+		return false, "address " + ip.String() + " is not allowlisted"
+	}
+	if p.Country == nil || (len(p.AllowCountries) == 0 && len(p.DenyCountries) == 0) {
+		return true, ""
+	}
+	country := p.Country(ip)
+	if hasCountry(p.DenyCountries, country) {
+		return false, "country " + country + " is denied"
+	}
+	if len(p.AllowCountries) > 0 && !hasCountry(p.AllowCountries, country) {
+		return false, "country " + country + " is not allowed"
+	}
+	return true, ""
+}
+
+// Middleware rejects the requests of the addresses which are not Allowed with 403 Forbidden, it is placed before the
+// authenticator so that the rejected clients never reach the authentication
+func (p *Policy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, reason := p.Allowed(p.ClientIP(r))
+		if !allowed {
+			p.reject(w, r, reason)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RestrictIdentities rejects the identities of the Subjects and the Roles connecting from outside of their ranges
+// with 403 Forbidden, it is placed after the authenticator. The identities without restriction are let through
+func (p *Policy) RestrictIdentities(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := turboAuth.IdentityFromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := p.ClientIP(r)
+		var restricted, allowed bool
+		if networks, ok := p.Subjects[identity.Subject]; ok {
+			restricted = true
+			allowed = ip != nil && clientip.Contains(networks, ip)
+		}
+		for role, networks := range p.Roles {
+			if !identity.HasRole(role) {
+				continue
+			}
+			restricted = true
+			allowed = allowed || ip != nil && clientip.Contains(networks, ip)
+		}
+		if restricted && !allowed {
+			p.reject(w, r, fmt.Sprintf("%s is not allowed from %v", identity.Subject, ip))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *Policy) reject(w http.ResponseWriter, r *http.Request, reason string) {
+	logger.DebugF("network policy rejected %s %s: %s", r.Method, r.URL.Path, reason)
+	turboAuth.RecordDecision(r.Context(), turboAuth.Decision{Stage: turboAuth.DecisionPolicy, Source: "netpolicy",
+		Reason: reason})
+	turboError.WriteError(p.ErrorWriter, w, r, &turboError.HttpError{
+		StatusCode: http.StatusForbidden,
+		Message:    "Error : access is not allowed from this network \n",
+	})
+}
+
+func hasCountry(countries []string, country string) bool {
+	for _, c := range countries {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
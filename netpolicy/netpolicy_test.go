@@ -0,0 +1,117 @@
+package netpolicy
+
+import (
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPolicy_ClientIP(t *testing.T) {
+	policy, err := NewPolicy(nil, nil, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{name: "Test_direct", remoteAddr: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "Test_untrusted_forwarded", remoteAddr: "203.0.113.7:1234", forwarded: "198.51.100.1", want: "203.0.113.7"},
+		{name: "Test_trusted_proxy", remoteAddr: "10.0.0.2:1234", forwarded: "198.51.100.1", want: "198.51.100.1"},
+		{name: "Test_proxy_chain", remoteAddr: "10.0.0.2:1234", forwarded: "1.1.1.1, 198.51.100.1, 10.0.0.3", want: "198.51.100.1"},
+		{name: "Test_no_forwarded", remoteAddr: "10.0.0.2:1234", want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := policy.ClientIP(r); !got.Equal(net.ParseIP(tt.want)) {
+				t.Errorf("ClientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_Middleware(t *testing.T) {
+	policy, err := NewPolicy([]string{"192.0.2.0/24", "198.51.100.1"}, []string{"192.0.2.128/25"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.Country = func(ip net.IP) string {
+		if ip.Equal(net.ParseIP("198.51.100.1")) {
+			return "FR"
+		}
+		return "DE"
+	}
+	policy.DenyCountries = []string{"fr"}
+	handler := policy.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       int
+	}{
+		{name: "Test_allowlisted", remoteAddr: "192.0.2.1:1234", want: http.StatusOK},
+		{name: "Test_denylisted", remoteAddr: "192.0.2.200:1234", want: http.StatusForbidden},
+		{name: "Test_not_allowlisted", remoteAddr: "203.0.113.7:1234", want: http.StatusForbidden},
+		{name: "Test_denied_country", remoteAddr: "198.51.100.1:1234", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_RestrictIdentities(t *testing.T) {
+	policy := &Policy{}
+	if err := policy.RestrictRole("admin", "10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.RestrictSubject("ops", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.RestrictSubject("bad", "not an address"); err == nil {
+		t.Error("RestrictSubject() accepted an invalid address")
+	}
+	handler := policy.RestrictIdentities(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name       string
+		identity   *turboAuth.Identity
+		remoteAddr string
+		want       int
+	}{
+		{name: "Test_anonymous", remoteAddr: "203.0.113.7:1234", want: http.StatusOK},
+		{name: "Test_unrestricted", identity: &turboAuth.Identity{Subject: "user"}, remoteAddr: "203.0.113.7:1234", want: http.StatusOK},
+		{name: "Test_role_inside", identity: &turboAuth.Identity{Subject: "root", Roles: []string{"admin"}}, remoteAddr: "10.1.2.3:1234", want: http.StatusOK},
+		{name: "Test_role_outside", identity: &turboAuth.Identity{Subject: "root", Roles: []string{"admin"}}, remoteAddr: "203.0.113.7:1234", want: http.StatusForbidden},
+		{name: "Test_subject_inside", identity: &turboAuth.Identity{Subject: "ops"}, remoteAddr: "192.0.2.1:1234", want: http.StatusOK},
+		{name: "Test_subject_outside", identity: &turboAuth.Identity{Subject: "ops"}, remoteAddr: "192.0.2.2:1234", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.identity != nil {
+				r = r.WithContext(turboAuth.NewContext(r.Context(), tt.identity))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package nonce
+
+import (
+	"container/heap"
+	"context"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"time"
+)
+
+type (
+	// Store remembers the nonces until they expire so that their reuse is detected, e.g. the jti of the DPoP
+	// proofs, the used magic links or the time steps of the TOTP codes. Implementations must be safe for concurrent
+	// use
+	Store interface {
+		// Seen records the nonce until expiresAt and reports whether it had already been recorded, the check and
+		// the update are atomic so that a nonce is accepted once even when presented concurrently
+		Seen(nonce string, expiresAt time.Time) (bool, error)
+	}
+
+	// MemoryStore is an in-process Store suitable for single instance deployments, the memory is bounded by
+	// MaxEntries
+	MemoryStore struct {
+		// MaxEntries bounds the nonces kept, the ones expiring first are evicted when it is reached and their reuse
+		// is no longer detected. DefaultMaxEntries when 0, unbounded when negative
+		MaxEntries int
+		mutex      sync.Mutex
+		seen       map[string]*entry
+		expiry     expiryHeap
+	}
+
+	// RedisStore shares the nonces between instances using SET NX and key expiry
+	RedisStore struct {
+		Client    redis.UniversalClient
+		KeyPrefix string
+	}
+
+	entry struct {
+		nonce     string
+		expiresAt time.Time
+		index     int
+	}
+
+	// expiryHeap orders the entries of the MemoryStore by expiry, the next to expire first
+	expiryHeap []*entry
+)
+
+const (
+	// DefaultMaxEntries keeps the MemoryStore around a few tens of MB
+	DefaultMaxEntries = 100000
+	DefaultKeyPrefix  = "turbo-auth:nonce:"
+)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		seen: make(map[string]*entry),
+	}
+}
+
+func (m *MemoryStore) Seen(nonce string, expiresAt time.Time) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.seen == nil {
+		m.seen = make(map[string]*entry)
+	}
+	now := time.Now()
+	m.purge(now)
+	if e, ok := m.seen[nonce]; ok {
+		if expiresAt.After(e.expiresAt) {
+			e.expiresAt = expiresAt
+			heap.Fix(&m.expiry, e.index)
+		}
+		return true, nil
+	}
+	if !expiresAt.After(now) {
+		return false, nil
+	}
+	for max := m.maxEntries(); max > 0 && len(m.expiry) >= max; {
+		delete(m.seen, heap.Pop(&m.expiry).(*entry).nonce)
+	}
+	e := &entry{nonce: nonce, expiresAt: expiresAt}
+	heap.Push(&m.expiry, e)
+	m.seen[nonce] = e
+	return false, nil
+}
+
+// Len returns the number of the nonces kept, the expired ones included until the next call to Seen
+func (m *MemoryStore) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.expiry)
+}
+
+func (m *MemoryStore) maxEntries() int {
+	if m.MaxEntries == 0 {
+		return DefaultMaxEntries
+	}
+	return m.MaxEntries
+}
+
+// purge drops the expired nonces, caller must hold the lock
+func (m *MemoryStore) purge(now time.Time) {
+	for len(m.expiry) > 0 && now.After(m.expiry[0].expiresAt) {
+		delete(m.seen, heap.Pop(&m.expiry).(*entry).nonce)
+	}
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{
+		Client:    client,
+		KeyPrefix: DefaultKeyPrefix,
+	}
+}
+
+func (s *RedisStore) Seen(nonce string, expiresAt time.Time) (bool, error) {
+	return s.SeenContext(context.Background(), nonce, expiresAt)
+}
+
+func (s *RedisStore) SeenContext(ctx context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return false, nil
+	}
+	set, err := s.Client.SetNX(ctx, s.KeyPrefix+nonce, expiresAt.Unix(), ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	return !set, nil
+}
+
+// Check pings the redis server, see health.Checker
+func (s *RedisStore) Check(ctx context.Context) error {
+	return s.Client.Ping(ctx).Err()
+}
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
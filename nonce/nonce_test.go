@@ -0,0 +1,101 @@
+package nonce
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_Seen(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	tests := []struct {
+		name      string
+		nonce     string
+		expiresAt time.Time
+		want      bool
+	}{
+		{name: "Test_new", nonce: "a", expiresAt: now.Add(time.Minute)},
+		{name: "Test_replayed", nonce: "a", expiresAt: now.Add(time.Minute), want: true},
+		{name: "Test_other", nonce: "b", expiresAt: now.Add(time.Minute)},
+		{name: "Test_expired", nonce: "c", expiresAt: now.Add(-time.Second)},
+		{name: "Test_expired_not_recorded", nonce: "c", expiresAt: now.Add(time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.Seen(tt.nonce, tt.expiresAt)
+			if err != nil {
+				t.Fatalf("Seen() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Seen() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStore_expiry(t *testing.T) {
+	store := &MemoryStore{}
+	if seen, _ := store.Seen("a", time.Now().Add(20*time.Millisecond)); seen {
+		t.Fatalf("Seen() = true for a new nonce")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if seen, _ := store.Seen("a", time.Now().Add(time.Minute)); seen {
+		t.Errorf("Seen() = true for an expired nonce")
+	}
+	if store.Len() != 1 {
+		t.Errorf("Len() = %d, want the expired nonce purged", store.Len())
+	}
+}
+
+func TestMemoryStore_MaxEntries(t *testing.T) {
+	store := &MemoryStore{MaxEntries: 3}
+	now := time.Now()
+	// the first nonce expires last, the second first
+	expiries := []time.Duration{time.Hour, time.Minute, 2 * time.Minute, 3 * time.Minute}
+	for i, expiry := range expiries {
+		if seen, _ := store.Seen(fmt.Sprint(i), now.Add(expiry)); seen {
+			t.Fatalf("Seen(%d) = true for a new nonce", i)
+		}
+	}
+	if store.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", store.Len())
+	}
+	tests := []struct {
+		name  string
+		nonce string
+		want  bool
+	}{
+		{name: "Test_kept_longest", nonce: "0", want: true},
+		{name: "Test_evicted_first_to_expire", nonce: "1"},
+		{name: "Test_kept_latest", nonce: "3", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := store.Seen(tt.nonce, now.Add(time.Second)); got != tt.want {
+				t.Errorf("Seen() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStore_concurrent(t *testing.T) {
+	store := NewMemoryStore()
+	var accepted int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if seen, _ := store.Seen("a", time.Now().Add(time.Minute)); !seen {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("the nonce was accepted %d times, want 1", accepted)
+	}
+}
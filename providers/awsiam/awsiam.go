@@ -0,0 +1,325 @@
+package awsiam
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/internal/sigv4"
+	"github.com/nandlabs/turbo-auth/logging"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Provider authenticates the AWS principals presenting a signed sts:GetCallerIdentity request, the request is
+	// forwarded to STS which checks the signature and returns the ARN of the caller. It implements
+	// turboAuth.Authenticator and turboAuth.TokenAuthenticator
+	Provider struct {
+		// STSEndpoint receives the forwarded requests whatever the url of the LoginRequest, DefaultSTSEndpoint when
+		// empty
+		STSEndpoint string
+		// ServerID must be signed in the HeaderServerID of the requests when set, it prevents the replay of a
+		// request captured by another service
+		ServerID string
+		// BoundAccounts restricts the accepted account ids, any account when empty
+		BoundAccounts []string
+		// BoundARNs restricts the accepted canonical ARNs, a trailing * matches any suffix, any ARN when empty
+		BoundARNs []string
+		Client    *http.Client
+		// CacheTTL caches the resolved callers, disabled when 0
+		CacheTTL time.Duration
+		// CacheSize bounds the cached callers, the least recently used one is evicted once full, DefaultCacheSize
+		// when 0
+		CacheSize   int
+		ErrorWriter turboError.ErrorWriter
+
+		mutex sync.Mutex
+		cache map[string]*list.Element
+		lru   *list.List
+	}
+
+	// Caller is the AWS principal resolved by sts:GetCallerIdentity
+	Caller struct {
+		Account string
+		// ARN is the ARN returned by STS, e.g. arn:aws:sts::123456789012:assumed-role/deploy/ci-1234
+		ARN string
+		// CanonicalARN drops the session of the assumed roles, e.g. arn:aws:iam::123456789012:role/deploy
+		CanonicalARN string
+		UserID       string
+		// SessionName is the session of the assumed roles
+		SessionName string
+	}
+
+	getCallerIdentityResponse struct {
+		Result struct {
+			Arn     string `xml:"Arn"`
+			UserID  string `xml:"UserId"`
+			Account string `xml:"Account"`
+		} `xml:"GetCallerIdentityResult"`
+	}
+
+	cacheEntry struct {
+		key       string
+		identity  *turboAuth.Identity
+		expiresAt time.Time
+	}
+)
+
+const (
+	// AuthScheme prefixes the encoded LoginRequest in the Authorization header
+	AuthScheme       = "AWS-IAM"
+	DefaultCacheTTL  = time.Minute
+	DefaultCacheSize = 10000
+)
+
+var (
+	logger = logging.Get()
+
+	ErrInvalidLoginRequest = errors.New("aws: the request is not a signed sts:GetCallerIdentity")
+	ErrServerIDMismatch    = errors.New("aws: missing or mismatching signed server id")
+	ErrUnboundCaller       = errors.New("aws: the caller is not bound to this service")
+)
+
+func NewProvider(serverID string) *Provider {
+	return &Provider{
+		STSEndpoint: DefaultSTSEndpoint,
+		ServerID:    serverID,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		CacheTTL:    DefaultCacheTTL,
+		CacheSize:   DefaultCacheSize,
+	}
+}
+
+func (p *Provider) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get(turboAuth.HeaderAuthorization)
+		if len(auth) <= len(AuthScheme)+1 || !strings.EqualFold(auth[:len(AuthScheme)+1], AuthScheme+" ") {
+			p.writeError(w, r, http.StatusUnauthorized, turboError.ErrMissingToken.Error())
+			return
+		}
+		identity, err := p.AuthenticateContext(r.Context(), strings.TrimSpace(auth[len(AuthScheme)+1:]))
+		if err != nil {
+			statusCode := http.StatusUnauthorized
+			if !errors.Is(err, turboError.ErrTokenInvalid) {
+				statusCode = http.StatusServiceUnavailable
+			}
+			p.writeError(w, r, statusCode, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(turboAuth.NewContext(r.Context(), identity)))
+	})
+}
+
+// Authenticate resolves the caller of the encoded LoginRequest, see LoginRequest.Encode
+func (p *Provider) Authenticate(token string) (*turboAuth.Identity, error) {
+	return p.AuthenticateContext(context.Background(), token)
+}
+
+// AuthenticateContext resolves the caller of the encoded LoginRequest, errors other than turboError.ErrTokenInvalid
+// mean STS could not be reached. The Subject of the identity is the canonical ARN of the caller
+func (p *Provider) AuthenticateContext(ctx context.Context, token string) (*turboAuth.Identity, error) {
+	if token == "" {
+		return nil, turboError.ErrMissingToken
+	}
+	content, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, turboError.Wrap(turboError.ErrTokenInvalid, ErrInvalidLoginRequest)
+	}
+	var login LoginRequest
+	if err := json.Unmarshal(content, &login); err != nil {
+		return nil, turboError.Wrap(turboError.ErrTokenInvalid, ErrInvalidLoginRequest)
+	}
+	key := cacheKey(token)
+	if identity, ok := p.cached(key); ok {
+		return identity, nil
+	}
+	caller, err := p.GetCallerIdentity(ctx, &login)
+	if err != nil {
+		return nil, err
+	}
+	if !p.bound(caller) {
+		return nil, turboError.Wrap(turboError.ErrTokenInvalid, ErrUnboundCaller)
+	}
+	identity := caller.Identity()
+	p.store(key, identity)
+	return identity, nil
+}
+
+// GetCallerIdentity checks the login request and forwards it to the STSEndpoint
+func (p *Provider) GetCallerIdentity(ctx context.Context, login *LoginRequest) (*Caller, error) {
+	_, body, headers, err := login.decode()
+	if err != nil || login.Method != http.MethodPost {
+		return nil, turboError.Wrap(turboError.ErrTokenInvalid, ErrInvalidLoginRequest)
+	}
+	if form, err := url.ParseQuery(string(body)); err != nil || len(form["Action"]) != 1 ||
+		form.Get("Action") != "GetCallerIdentity" {
+		return nil, turboError.Wrap(turboError.ErrTokenInvalid, ErrInvalidLoginRequest)
+	}
+	if p.ServerID != "" && (headers.Get(HeaderServerID) != p.ServerID ||
+		!contains(sigv4.SignedHeaders(headers.Get("Authorization")), strings.ToLower(HeaderServerID))) {
+		return nil, turboError.Wrap(turboError.ErrTokenInvalid, ErrServerIDMismatch)
+	}
+	endpoint := p.STSEndpoint
+	if endpoint == "" {
+		endpoint = DefaultSTSEndpoint
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range headers {
+		if !strings.EqualFold(name, "Host") {
+			req.Header[name] = values
+		}
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusBadRequest:
+		logger.DebugF("sts rejected the login request: %s", res.Status)
+		return nil, turboError.ErrTokenInvalid
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("aws: sts returned %s", res.Status)
+	}
+	var response getCallerIdentityResponse
+	if err := xml.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return NewCaller(response.Result.Arn, response.Result.UserID, response.Result.Account)
+}
+
+// NewCaller parses the ARN returned by STS
+func NewCaller(arn string, userID string, account string) (*Caller, error) {
+	// arn:<partition>:<service>:<region>:<account>:<resource>
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return nil, fmt.Errorf("aws: malformed arn %q", arn)
+	}
+	caller := &Caller{Account: account, ARN: arn, CanonicalARN: arn, UserID: userID}
+	if caller.Account == "" {
+		caller.Account = parts[4]
+	}
+	resource := strings.Split(parts[5], "/")
+	if parts[2] == "sts" && resource[0] == "assumed-role" && len(resource) >= 3 {
+		caller.SessionName = resource[len(resource)-1]
+		caller.CanonicalARN = "arn:" + parts[1] + ":iam::" + parts[4] + ":role/" +
+			strings.Join(resource[1:len(resource)-1], "/")
+	}
+	return caller, nil
+}
+
+// Identity maps the caller, the Claims hold the account, arn, user_id and session_name
+func (c *Caller) Identity() *turboAuth.Identity {
+	return &turboAuth.Identity{
+		Subject: c.CanonicalARN,
+		Claims: map[string]interface{}{
+			"account":      c.Account,
+			"arn":          c.ARN,
+			"user_id":      c.UserID,
+			"session_name": c.SessionName,
+		},
+	}
+}
+
+func (p *Provider) bound(caller *Caller) bool {
+	if len(p.BoundAccounts) > 0 && !contains(p.BoundAccounts, caller.Account) {
+		return false
+	}
+	if len(p.BoundARNs) == 0 {
+		return true
+	}
+	for _, pattern := range p.BoundARNs {
+		if pattern == caller.CanonicalARN ||
+			strings.HasSuffix(pattern, "*") && strings.HasPrefix(caller.CanonicalARN, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Provider) cached(key string) (*turboAuth.Identity, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	element, ok := p.cache[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		p.remove(element)
+		return nil, false
+	}
+	p.lru.MoveToFront(element)
+	return entry.identity, true
+}
+
+func (p *Provider) store(key string, identity *turboAuth.Identity) {
+	if p.CacheTTL <= 0 {
+		return
+	}
+	size := p.CacheSize
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	expiresAt := time.Now().Add(p.CacheTTL)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.cache == nil {
+		p.cache, p.lru = make(map[string]*list.Element), list.New()
+	}
+	if element, ok := p.cache[key]; ok {
+		p.remove(element)
+	}
+	p.cache[key] = p.lru.PushFront(&cacheEntry{key: key, identity: identity, expiresAt: expiresAt})
+	for p.lru.Len() > size {
+		p.remove(p.lru.Back())
+	}
+}
+
+// remove evicts the cached caller, caller must hold the lock
+func (p *Provider) remove(element *list.Element) {
+	p.lru.Remove(element)
+	delete(p.cache, element.Value.(*cacheEntry).key)
+}
+
+func (p *Provider) writeError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	turboError.WriteError(p.ErrorWriter, w, r, &turboError.HttpError{
+		StatusCode: statusCode,
+		Message:    "Error : " + message + " \n",
+	})
+}
+
+// cacheKey hashes the token so the raw login requests are not kept in memory
+func cacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package awsiam
+
+import (
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/internal/sigv4"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testCredentials = Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "session"}
+
+// newSTSServer fakes STS, the signature is checked by signing the signed headers again with the test credentials
+func newSTSServer(t *testing.T, calls *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		date, err := time.Parse(sigv4.DateFormat, r.Header.Get(sigv4.HeaderDate))
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		resigned, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.String(), nil)
+		for _, name := range sigv4.SignedHeaders(r.Header.Get("Authorization")) {
+			if name != "host" {
+				resigned.Header.Set(name, r.Header.Get(name))
+			}
+		}
+		sigv4.Sign(resigned, body, testCredentials, DefaultRegion, "sts", date)
+		if resigned.Header.Get("Authorization") != r.Header.Get("Authorization") {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("<ErrorResponse><Error><Code>SignatureDoesNotMatch</Code></Error></ErrorResponse>"))
+			return
+		}
+		_, _ = w.Write([]byte(`<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:sts::123456789012:assumed-role/deploy/ci-1234</Arn>
+    <UserId>AROAEXAMPLE:ci-1234</UserId>
+    <Account>123456789012</Account>
+  </GetCallerIdentityResult>
+</GetCallerIdentityResponse>`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func loginToken(t *testing.T, credentials Credentials, endpoint string, serverID string) string {
+	login, err := NewLoginRequest(credentials, endpoint, "", serverID)
+	if err != nil {
+		t.Fatalf("NewLoginRequest() error = %v", err)
+	}
+	token, err := login.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestProvider_Authenticate(t *testing.T) {
+	var calls int32
+	server := newSTSServer(t, &calls)
+	forged := testCredentials
+	forged.SecretAccessKey = "guessed"
+
+	tests := []struct {
+		name      string
+		token     string
+		boundARNs []string
+		wantErr   error
+	}{
+		{name: "Test_assumed_role", token: loginToken(t, testCredentials, server.URL, "payments")},
+		{
+			name:      "Test_bound_arn",
+			token:     loginToken(t, testCredentials, server.URL, "payments"),
+			boundARNs: []string{"arn:aws:iam::123456789012:role/dep*"},
+		},
+		{
+			name:      "Test_unbound_arn",
+			token:     loginToken(t, testCredentials, server.URL, "payments"),
+			boundARNs: []string{"arn:aws:iam::123456789012:role/admin"},
+			wantErr:   ErrUnboundCaller,
+		},
+		{name: "Test_other_server_id", token: loginToken(t, testCredentials, server.URL, "billing"), wantErr: ErrServerIDMismatch},
+		{name: "Test_bad_signature", token: loginToken(t, forged, server.URL, "payments"), wantErr: turboError.ErrTokenInvalid},
+		{name: "Test_garbage", token: "bm90IGpzb24", wantErr: ErrInvalidLoginRequest},
+		{name: "Test_missing_token", wantErr: turboError.ErrMissingToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewProvider("payments")
+			provider.STSEndpoint = server.URL
+			provider.BoundARNs = tt.boundARNs
+			identity, err := provider.Authenticate(tt.token)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if identity.Subject != "arn:aws:iam::123456789012:role/deploy" || identity.Claims["account"] != "123456789012" ||
+				identity.Claims["session_name"] != "ci-1234" {
+				t.Errorf("identity = %+v", identity)
+			}
+		})
+	}
+
+	provider := NewProvider("payments")
+	provider.STSEndpoint = server.URL
+	token := loginToken(t, testCredentials, server.URL, "payments")
+	before := atomic.LoadInt32(&calls)
+	for i := 0; i < 3; i++ {
+		if _, err := provider.Authenticate(token); err != nil {
+			t.Fatalf("Authenticate() error = %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&calls) - before; got != 1 {
+		t.Errorf("sts calls = %v, want 1 with the cache", got)
+	}
+}
+
+func TestProvider_cacheSize(t *testing.T) {
+	provider := NewProvider("payments")
+	provider.CacheSize = 2
+	for _, key := range []string{"first", "second", "third"} {
+		provider.store(key, &turboAuth.Identity{Subject: key})
+	}
+	for key, want := range map[string]bool{"first": false, "second": true, "third": true} {
+		if _, ok := provider.cached(key); ok != want {
+			t.Errorf("cached(%v) = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestProvider_Apply(t *testing.T) {
+	var calls int32
+	server := newSTSServer(t, &calls)
+	provider := NewProvider("payments")
+	provider.STSEndpoint = server.URL
+	handler := provider.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		auth       string
+		wantStatus int
+	}{
+		{name: "Test_signed_request", auth: AuthScheme + " " + loginToken(t, testCredentials, server.URL, "payments"), wantStatus: http.StatusOK},
+		{name: "Test_bearer_token", auth: "Bearer abc", wantStatus: http.StatusUnauthorized},
+		{name: "Test_missing_header", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestNewCaller(t *testing.T) {
+	tests := []struct {
+		arn       string
+		canonical string
+		wantErr   bool
+	}{
+		{arn: "arn:aws:iam::123456789012:user/ops/alice", canonical: "arn:aws:iam::123456789012:user/ops/alice"},
+		{arn: "arn:aws-cn:sts::123456789012:assumed-role/deploy/i-0abc", canonical: "arn:aws-cn:iam::123456789012:role/deploy"},
+		{arn: "not-an-arn", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			caller, err := NewCaller(tt.arn, "", "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewCaller() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (caller.CanonicalARN != tt.canonical || caller.Account != "123456789012" ||
+				!strings.HasPrefix(caller.ARN, "arn:")) {
+				t.Errorf("caller = %+v", caller)
+			}
+		})
+	}
+}
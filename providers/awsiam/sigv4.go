@@ -0,0 +1,98 @@
+package awsiam
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/nandlabs/turbo-auth/internal/sigv4"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type (
+	// Credentials are the AWS credentials of the caller, SessionToken is set for the temporary credentials
+	Credentials = sigv4.Credentials
+
+	// LoginRequest is the presigned sts:GetCallerIdentity call a caller presents, the fields are named after the
+	// Vault aws auth method so the existing clients can be reused
+	LoginRequest struct {
+		Method  string `json:"iam_http_request_method"`
+		URL     string `json:"iam_request_url"`
+		Body    string `json:"iam_request_body"`
+		Headers string `json:"iam_request_headers"`
+	}
+)
+
+const (
+	DefaultSTSEndpoint = "https://sts.amazonaws.com"
+	DefaultRegion      = "us-east-1"
+	// HeaderServerID binds the signed request to the receiving service, replaying it to another service fails
+	HeaderServerID    = "X-Turbo-Auth-Server-Id"
+	getCallerIdentity = "Action=GetCallerIdentity&Version=2011-06-15"
+	formContentType   = "application/x-www-form-urlencoded; charset=utf-8"
+)
+
+// NewLoginRequest signs the sts:GetCallerIdentity call of the credentials for the serverID, endpoint and region
+// default to DefaultSTSEndpoint and DefaultRegion when empty
+func NewLoginRequest(credentials Credentials, endpoint string, region string, serverID string) (*LoginRequest, error) {
+	if endpoint == "" {
+		endpoint = DefaultSTSEndpoint
+	}
+	if region == "" {
+		region = DefaultRegion
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(getCallerIdentity))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", formContentType)
+	if serverID != "" {
+		req.Header.Set(HeaderServerID, serverID)
+	}
+	sigv4.Sign(req, []byte(getCallerIdentity), credentials, region, "sts", time.Now())
+	headers, err := json.Marshal(req.Header)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginRequest{
+		Method:  req.Method,
+		URL:     base64.StdEncoding.EncodeToString([]byte(endpoint)),
+		Body:    base64.StdEncoding.EncodeToString([]byte(getCallerIdentity)),
+		Headers: base64.StdEncoding.EncodeToString(headers),
+	}, nil
+}
+
+// Encode returns the login request as carried by the Authorization header, "AWS-IAM <encoded>"
+func (l *LoginRequest) Encode() (string, error) {
+	content, err := json.Marshal(l)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(content), nil
+}
+
+// decode reads the request out of the LoginRequest, the body and the headers are base64 encoded
+func (l *LoginRequest) decode() (*url.URL, []byte, http.Header, error) {
+	rawURL, err := base64.StdEncoding.DecodeString(l.URL)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	endpoint, err := url.Parse(string(rawURL))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	body, err := base64.StdEncoding.DecodeString(l.Body)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rawHeaders, err := base64.StdEncoding.DecodeString(l.Headers)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	headers := http.Header{}
+	if err := json.Unmarshal(rawHeaders, &headers); err != nil {
+		return nil, nil, nil, err
+	}
+	return endpoint, body, headers, nil
+}
@@ -4,7 +4,7 @@ import (
 	"encoding/base64"
 	turboAuth "github.com/nandlabs/turbo-auth"
 	"github.com/nandlabs/turbo-auth/errors"
-	"go.nandlabs.io/l3"
+	"github.com/nandlabs/turbo-auth/logging"
 	"net/http"
 	"strings"
 )
@@ -17,6 +17,8 @@ type (
 		ldapProvider      bool
 		ldapConfig        LdapConfig
 		Validator         BasicAuthValidator
+		// ErrorWriter renders the failures, plain text errors are written when nil
+		ErrorWriter errors.ErrorWriter
 	}
 
 	// BasicAuthValidator expects username and password
@@ -24,7 +26,7 @@ type (
 )
 
 var (
-	logger                       = l3.Get()
+	logger                       = logging.Get()
 	DefaultBasicAuthFilterConfig = BasicAuthFilter{
 		basicAuthProvider: true,
 		dbProvider:        false,
@@ -46,11 +48,11 @@ func (ba *BasicAuthFilter) Apply(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Basic Auth Implementation
 		if ba.Validator == nil {
-			httpError := &errors.HttpError{
+			errors.WriteError(ba.ErrorWriter, w, r, &errors.HttpError{
 				StatusCode: http.StatusBadRequest,
 				Message:    "Error : Basic auth filter requires a validator function \n",
-			}
-			httpError.GenerateError(w, r)
+			})
+			return
 		}
 		// perform pre-requisite checks
 		auth := r.Header.Get(turboAuth.HeaderAuthorization)
@@ -58,36 +60,52 @@ func (ba *BasicAuthFilter) Apply(next http.Handler) http.Handler {
 		if len(auth) > l+1 && strings.EqualFold(auth[:l], turboAuth.Basic) {
 			basicAuth, err := base64.StdEncoding.DecodeString(auth[l+1:])
 			if err != nil {
-				httpError := &errors.HttpError{
+				errors.WriteError(ba.ErrorWriter, w, r, &errors.HttpError{
+					StatusCode: http.StatusBadRequest,
+					Message:    "Error decoding authorization token \n",
+				})
+				return
+			}
+			tokenUsername, tokenPassword, ok := splitCredentials(string(basicAuth))
+			if !ok {
+				errors.WriteError(ba.ErrorWriter, w, r, &errors.HttpError{
 					StatusCode: http.StatusBadRequest,
 					Message:    "Error decoding authorization token \n",
-				}
-				httpError.GenerateError(w, r)
+				})
+				return
 			}
-			logger.InfoF("basic token: %s", basicAuth)
-			tokenUsername := strings.Split(string(basicAuth), ":")[0]
-			tokenPassword := strings.Split(string(basicAuth), ":")[1]
 
 			valid, err := ba.Validator(tokenUsername, tokenPassword)
 			if err != nil {
-				httpError := &errors.HttpError{
+				errors.WriteError(ba.ErrorWriter, w, r, &errors.HttpError{
 					StatusCode: http.StatusForbidden,
 					Message:    "Invalid Token provided for the request \n",
-				}
-				httpError.GenerateError(w, r)
+				})
+				return
 			} else if valid {
-				next.ServeHTTP(w, r)
+				next.ServeHTTP(w, r.WithContext(turboAuth.NewContext(r.Context(), &turboAuth.Identity{
+					Subject: tokenUsername,
+				})))
+				return
 			}
 		}
 		// handle in case of authorization token not sent
-		httpError := &errors.HttpError{
+		errors.WriteError(ba.ErrorWriter, w, r, &errors.HttpError{
 			StatusCode: http.StatusUnauthorized,
 			Message:    "Incoming request cannot be authorized \n",
-		}
-		httpError.GenerateError(w, r)
+		})
 	})
 }
 
+// splitCredentials splits the decoded "username:password" pair, the password may contain colons
+func splitCredentials(credentials string) (string, string, bool) {
+	i := strings.IndexByte(credentials, ':')
+	if i < 0 {
+		return "", "", false
+	}
+	return credentials[:i], credentials[i+1:], true
+}
+
 func CreateBasicAuthAuthenticator(fn BasicAuthValidator) BasicAuthFilter {
 	filterConfig := DefaultBasicAuthFilterConfig
 	filterConfig.Validator = fn
@@ -0,0 +1,248 @@
+package introspection
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/logging"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"github.com/nandlabs/turbo-auth/resilience"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Provider validates opaque access tokens against a remote RFC 7662 introspection endpoint,
+	// it implements turboAuth.Authenticator and turboAuth.TokenAuthenticator
+	Provider struct {
+		Endpoint     string
+		ClientID     string
+		ClientSecret string
+		Client       *http.Client
+		// CacheTTL caches the active responses, never past the expiry of the token, disabled when 0
+		CacheTTL time.Duration
+		// NegativeCacheTTL caches the inactive responses so invalid tokens do not hammer the endpoint, disabled when 0
+		NegativeCacheTTL time.Duration
+		// CacheSize bounds the cached responses, the least recently used one is evicted once full, DefaultCacheSize
+		// when 0
+		CacheSize int
+		// Resilience bounds, retries and breaks the calls to the endpoint, the inactive responses are not retried
+		Resilience  *resilience.Policy
+		ErrorWriter turboError.ErrorWriter
+
+		mutex sync.Mutex
+		cache map[string]*list.Element
+		lru   *list.List
+	}
+
+	cacheEntry struct {
+		key       string
+		response  *jwt.IntrospectionResponse
+		expiresAt time.Time
+	}
+)
+
+const (
+	DefaultCacheTTL         = time.Minute
+	DefaultNegativeCacheTTL = 10 * time.Second
+	DefaultCacheSize        = 10000
+)
+
+var logger = logging.Get()
+
+func NewProvider(endpoint, clientID, clientSecret string) *Provider {
+	return &Provider{
+		Endpoint:         endpoint,
+		ClientID:         clientID,
+		ClientSecret:     clientSecret,
+		Client:           &http.Client{Timeout: 10 * time.Second},
+		CacheTTL:         DefaultCacheTTL,
+		NegativeCacheTTL: DefaultNegativeCacheTTL,
+		CacheSize:        DefaultCacheSize,
+	}
+}
+
+func (p *Provider) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			turboError.WriteError(p.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Error : " + turboError.ErrMissingToken.Error() + " \n",
+			})
+			return
+		}
+		identity, err := p.AuthenticateContext(r.Context(), token)
+		if err != nil {
+			statusCode := http.StatusUnauthorized
+			if !errors.Is(err, turboError.ErrTokenInvalid) {
+				statusCode = http.StatusServiceUnavailable
+			}
+			turboError.WriteError(p.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: statusCode,
+				Message:    "Error : " + err.Error() + " \n",
+			})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(turboAuth.NewContext(r.Context(), identity)))
+	})
+}
+
+// Authenticate introspects the token, errors other than turboError.ErrTokenInvalid mean the endpoint could not be reached
+func (p *Provider) Authenticate(token string) (*turboAuth.Identity, error) {
+	return p.AuthenticateContext(context.Background(), token)
+}
+
+// AuthenticateContext is Authenticate with the deadline and cancellation of the context applied to the request to
+// the endpoint
+func (p *Provider) AuthenticateContext(ctx context.Context, token string) (*turboAuth.Identity, error) {
+	if token == "" {
+		return nil, turboError.ErrMissingToken
+	}
+	key := cacheKey(token)
+	response, ok := p.cached(key)
+	if !ok {
+		err := p.Resilience.Do(ctx, func(ctx context.Context) error {
+			var err error
+			response, err = p.introspect(ctx, token)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		p.store(key, response)
+	}
+	if !response.Active {
+		return nil, turboError.ErrTokenInvalid
+	}
+	return newIdentity(response), nil
+}
+
+func (p *Provider) introspect(ctx context.Context, token string) (*jwt.IntrospectionResponse, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest(http.MethodPost, p.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint returned %s", res.Status)
+	}
+	var response jwt.IntrospectionResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (p *Provider) cached(key string) (*jwt.IntrospectionResponse, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	element, ok := p.cache[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		p.remove(element)
+		return nil, false
+	}
+	p.lru.MoveToFront(element)
+	return entry.response, true
+}
+
+func (p *Provider) store(key string, response *jwt.IntrospectionResponse) {
+	ttl := p.NegativeCacheTTL
+	if response.Active {
+		ttl = p.CacheTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	if response.Active && response.Exp > 0 && time.Unix(response.Exp, 0).Before(expiresAt) {
+		expiresAt = time.Unix(response.Exp, 0)
+	}
+	size := p.CacheSize
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.cache == nil {
+		p.cache, p.lru = make(map[string]*list.Element), list.New()
+	}
+	if element, ok := p.cache[key]; ok {
+		p.remove(element)
+	}
+	p.cache[key] = p.lru.PushFront(&cacheEntry{key: key, response: response, expiresAt: expiresAt})
+	for p.lru.Len() > size {
+		p.remove(p.lru.Back())
+	}
+}
+
+// remove evicts the cached response, caller must hold the lock
+func (p *Provider) remove(element *list.Element) {
+	p.lru.Remove(element)
+	delete(p.cache, element.Value.(*cacheEntry).key)
+}
+
+// cacheKey hashes the token so the raw tokens are not kept in memory
+func cacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newIdentity(response *jwt.IntrospectionResponse) *turboAuth.Identity {
+	identity := &turboAuth.Identity{
+		Subject: response.Sub,
+		TokenID: response.Jti,
+		Scopes:  strings.Fields(response.Scope),
+		Claims: map[string]interface{}{
+			"client_id": response.ClientID,
+			"username":  response.Username,
+			"exp":       response.Exp,
+			"iss":       response.Iss,
+			"aud":       []string(response.Aud),
+		},
+	}
+	if response.Exp != 0 {
+		identity.ExpiresAt = time.Unix(response.Exp, 0)
+	}
+	if identity.Subject == "" {
+		identity.Subject = response.Username
+	}
+	return identity
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get(turboAuth.HeaderAuthorization)
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
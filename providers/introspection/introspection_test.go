@@ -0,0 +1,80 @@
+package introspection
+
+import (
+	"encoding/json"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProvider_Authenticate(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if id, secret, ok := r.BasicAuth(); !ok || id != "gateway" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		response := jwt.IntrospectionResponse{}
+		switch r.PostFormValue("token") {
+		case "opaque-active":
+			response = jwt.IntrospectionResponse{
+				Active: true,
+				Sub:    "test_user",
+				Scope:  "read write",
+				Exp:    time.Now().Add(time.Hour).Unix(),
+				Aud:    jwt.Audience{"orders", "billing"},
+			}
+		case "opaque-single-audience":
+			_, _ = w.Write([]byte(`{"active": true, "sub": "test_user", "scope": "read write", "aud": "orders"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	provider := NewProvider(server.URL, "gateway", "secret")
+	provider.CacheSize = 2
+	tests := []struct {
+		name      string
+		token     string
+		wantErr   error
+		wantAud   int
+		wantCalls int32
+	}{
+		{name: "Test_active", token: "opaque-active", wantAud: 2, wantCalls: 1},
+		{name: "Test_active_cached", token: "opaque-active", wantAud: 2, wantCalls: 1},
+		{name: "Test_inactive", token: "opaque-revoked", wantErr: turboError.ErrTokenInvalid, wantCalls: 2},
+		{name: "Test_inactive_negative_cached", token: "opaque-revoked", wantErr: turboError.ErrTokenInvalid, wantCalls: 2},
+		{name: "Test_single_audience", token: "opaque-single-audience", wantAud: 1, wantCalls: 3},
+		{name: "Test_least_recently_used_evicted", token: "opaque-active", wantAud: 2, wantCalls: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := provider.Authenticate(tt.token)
+			if err != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (identity.Subject != "test_user" || !identity.HasScope("write")) {
+				t.Errorf("Authenticate() identity = %+v", identity)
+			}
+			if err == nil {
+				if aud, _ := identity.Claims["aud"].([]string); len(aud) != tt.wantAud {
+					t.Errorf("aud = %v, want %v audiences", aud, tt.wantAud)
+				}
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("endpoint calls = %v, want %v", got, tt.wantCalls)
+			}
+		})
+	}
+
+	provider.ClientSecret = "wrong"
+	if _, err := provider.Authenticate("opaque-other"); err == nil || err == turboError.ErrTokenInvalid {
+		t.Errorf("Authenticate() with rejected client error = %v, want endpoint error", err)
+	}
+}
@@ -1,45 +1,351 @@
 package jwt
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
 	turboError "github.com/nandlabs/turbo-auth/errors"
-	"go.nandlabs.io/l3"
+	"github.com/nandlabs/turbo-auth/extractor"
+	"github.com/nandlabs/turbo-auth/logging"
 	"net/http"
 	"time"
 )
 
 var (
-	logger = l3.Get()
+	logger = logging.Get()
 )
 
 // HandleRequest fetch and validate incoming request token
 func (authConfig *JwtAuthConfig) HandleRequest(w http.ResponseWriter, r *http.Request) *turboError.JwtError {
+	_, err := authConfig.handleRequest(r)
+	return err
+}
+
+func (authConfig *JwtAuthConfig) handleRequest(r *http.Request) (*turboAuth.Identity, *turboError.JwtError) {
 
 	if r.Method == "OPTIONS" {
 		logger.InfoF("Requested Method is OPTIONS")
-		return nil
+		return nil, nil
 	}
 
+	ctx, span := authConfig.startSpan(r.Context(), "jwt.HandleRequest")
 	var c Credentials
 	// fetch info from token
 	if err := authConfig.fetchCredsFromRequest(r, &c); err != nil {
-		return turboError.NewJwtError(err, 500)
+		endSpan(span, err)
+		turboAuth.RecordDecision(ctx, turboAuth.Decision{Stage: turboAuth.DecisionExtractor, Source: "jwt",
+			Reason: authConfig.tokenSource() + ": " + err.Error()})
+		return nil, turboError.NewJwtError(err, 500)
+	}
+	turboAuth.RecordDecision(ctx, turboAuth.Decision{Stage: turboAuth.DecisionExtractor, Source: "jwt",
+		Allowed: c.AuthToken != "", Reason: authConfig.tokenSource()})
+
+	accessToken := c.AuthToken
+	identity, jwtErr := authConfig.validateCredentials(ctx, r, &c, TokenUseAccess)
+	if jwtErr != nil {
+		endSpan(span, jwtErr)
+		logger.DebugF("token rejected: %v", jwtErr)
+		turboAuth.RecordDecision(ctx, turboAuth.Decision{Stage: turboAuth.DecisionKey, Source: "jwt",
+			Reason: verificationKey(accessToken) + ": " + jwtErr.Error()})
+		return nil, jwtErr
+	}
+	turboAuth.RecordDecision(ctx, turboAuth.Decision{Stage: turboAuth.DecisionKey, Source: "jwt", Allowed: true,
+		Reason: verificationKey(accessToken)})
+	if authConfig.BodyDigest != nil {
+		if err := authConfig.BodyDigest.validate(r, identity.Claims); err != nil {
+			endSpan(span, err)
+			authConfig.Metrics.ObserveAuth("jwt", err)
+			authConfig.audit(r, audit.EventTokenValidation, identity, err)
+			return nil, turboError.NewJwtError(err, bodyDigestStatus(err))
+		}
 	}
+	endSpan(span, nil)
+	return identity, nil
+}
+
+// Authenticate validates the raw auth token and returns the identity it carries
+func (authConfig *JwtAuthConfig) Authenticate(token string) (*turboAuth.Identity, error) {
+	return authConfig.AuthenticateContext(context.Background(), token)
+}
 
+// AuthenticateContext is Authenticate with the context passed to the Revoker and the ClaimsMapper, e.g. the one of
+// the grpc call
+func (authConfig *JwtAuthConfig) AuthenticateContext(ctx context.Context, token string) (*turboAuth.Identity, error) {
+	identity, jwtErr := authConfig.validateCredentials(ctx, nil, &Credentials{AuthToken: token}, TokenUseAccess)
+	if jwtErr != nil {
+		return nil, jwtErr
+	}
+	return identity, nil
+}
+
+// AuthenticateRequest is AuthenticateContext verifying the proofs of the bound tokens against the request, e.g. the
+// upgrade request of a websocket
+func (authConfig *JwtAuthConfig) AuthenticateRequest(r *http.Request, token string) (*turboAuth.Identity, error) {
+	identity, jwtErr := authConfig.validateCredentials(r.Context(), r, &Credentials{AuthToken: token}, TokenUseAccess)
+	if jwtErr != nil {
+		return nil, jwtErr
+	}
+	return identity, nil
+}
+
+// validateCredentials validates the auth token of the credentials, the token_use claim of the token must be tokenUse
+func (authConfig *JwtAuthConfig) validateCredentials(ctx context.Context, r *http.Request, c *Credentials, tokenUse string) (*turboAuth.Identity, *turboError.JwtError) {
+	token := c.AuthToken
+	if err := authConfig.limits().checkToken(token); err != nil {
+		authConfig.Metrics.ObserveAuth("jwt", err)
+		authConfig.audit(r, audit.EventTokenValidation, nil, err)
+		return nil, turboError.NewJwtError(err, 403)
+	}
+	// the break-glass tokens must keep working while the remote dependencies are down
+	if authConfig.BreakGlass != nil && isBreakGlassToken(c.AuthToken) {
+		return authConfig.authenticateBreakGlass(r, c.AuthToken)
+	}
 	// validate
-	if err := c.ValidateToken(authConfig.SigningKey); err != nil {
-		return turboError.NewJwtError(err, 403)
+	tenant, err := authConfig.verifyCachedCredentials(r, c)
+	if err == nil && tokenUseOf(c.Claims) != tokenUse {
+		err = turboError.Wrap(turboError.ErrTokenInvalid, ErrTokenUse)
+	}
+	if err != nil {
+		authConfig.Metrics.ObserveAuth("jwt", err)
+		authConfig.audit(r, audit.EventTokenValidation, nil, err)
+		return nil, turboError.NewJwtError(err, 403)
 	}
 
-	return nil
+	identity := newIdentity(c.Claims)
+	if tenant != nil {
+		identity.Tenant = tenant.ID
+	}
+	if c.carriedBindings {
+		err = authConfig.configuredBindings(identity)
+	} else {
+		err = authConfig.validateBindings(r, token, identity, tokenUse)
+	}
+	if err != nil {
+		turboAuth.RecordDecision(ctx, turboAuth.Decision{Stage: turboAuth.DecisionPolicy, Source: "jwt",
+			Reason: err.Error()})
+		authConfig.Metrics.ObserveAuth("jwt", err)
+		authConfig.audit(r, audit.EventTokenValidation, identity, err)
+		return nil, turboError.NewJwtError(err, 401)
+	}
+	if authConfig.ClaimsType != nil {
+		typed, err := c.typed, error(nil)
+		if typed == nil {
+			typed, err = authConfig.decodeTypedClaims(c.payload)
+		}
+		if err != nil {
+			authConfig.Metrics.ObserveAuth("jwt", err)
+			authConfig.audit(r, audit.EventTokenValidation, identity, err)
+			return nil, turboError.NewJwtError(err, 403)
+		}
+		identity.TypedClaims = typed
+	}
+	// check the token has not been revoked
+	if err := authConfig.checkRevoked(ctx, c.Claims); err != nil {
+		authConfig.Metrics.ObserveAuth("jwt", err)
+		authConfig.audit(r, audit.EventTokenValidation, identity, err)
+		return nil, turboError.NewJwtError(err, 403)
+	}
+	if authConfig.ClaimsMapper != nil {
+		mapped, err := authConfig.ClaimsMapper(ctx, identity)
+		if err == nil && mapped == nil {
+			err = errors.New("claims mapper returned no identity")
+		}
+		if err != nil {
+			authConfig.Metrics.ObserveAuth("jwt", err)
+			authConfig.audit(r, audit.EventTokenValidation, identity, err)
+			return nil, turboError.NewJwtError(err, 403)
+		}
+		identity = mapped
+	}
+
+	authConfig.Metrics.ObserveAuth("jwt", nil)
+	authConfig.audit(r, audit.EventTokenValidation, identity, nil)
+	return identity, nil
 }
 
 func (authConfig *JwtAuthConfig) IssueNewToken(username string, duration time.Duration) (string, *turboError.JwtError) {
-	payload, err := NewPayload(username, duration)
+	return authConfig.IssueNewTokenContext(context.Background(), username, duration)
+}
+
+// IssueNewTokenContext is IssueNewToken with the context passed to the Revoker looking up the token version and to
+// the spans
+func (authConfig *JwtAuthConfig) IssueNewTokenContext(ctx context.Context, username string, duration time.Duration) (string, *turboError.JwtError) {
+	return authConfig.issueToken(ctx, username, duration, nil, nil)
+}
+
+// IssueTokenPair issues an auth token carrying the roles, valid for AuthTokenValidTime, and a refresh token valid
+// for RefreshTokenValidTime, unless the TTLPolicy selects other lifetimes
+func (authConfig *JwtAuthConfig) IssueTokenPair(username string, roles []string) (*TokenPair, *turboError.JwtError) {
+	return authConfig.IssueTokenPairContext(context.Background(), username, roles)
+}
+
+// IssueTokenPairContext is IssueTokenPair with the context passed to the Revoker and the RefreshTokens store
+func (authConfig *JwtAuthConfig) IssueTokenPairContext(ctx context.Context, username string, roles []string) (*TokenPair, *turboError.JwtError) {
+	return authConfig.issueTokenPair(ctx, username, roles, nil, "", time.Time{})
+}
+
+// IssueAuthenticatedTokenPair is IssueTokenPair with the acr, amr and auth_time claims of the authentication in the
+// auth token, e.g. once the second factor is verified, so that mfa.StepUp can enforce the strength of the login. Both
+// tokens are bound to the DeviceID of the authentication when set
+func (authConfig *JwtAuthConfig) IssueAuthenticatedTokenPair(username string, roles []string, authentication *Authentication) (*TokenPair, *turboError.JwtError) {
+	return authConfig.IssueAuthenticatedTokenPairContext(context.Background(), username, roles, authentication)
+}
+
+// IssueAuthenticatedTokenPairContext is IssueAuthenticatedTokenPair with the context passed to the Revoker and the
+// RefreshTokens store
+func (authConfig *JwtAuthConfig) IssueAuthenticatedTokenPairContext(ctx context.Context, username string, roles []string, authentication *Authentication) (*TokenPair, *turboError.JwtError) {
+	if authentication != nil && authentication.Time.IsZero() {
+		stamped := *authentication
+		stamped.Time = authConfig.now()
+		authentication = &stamped
+	}
+	return authConfig.issueTokenPair(ctx, username, roles, authentication, "", time.Time{})
+}
+
+// issueTokenPair issues the pair, the refresh token joins the family when the RefreshTokens store is set, a new
+// family is started when empty. The lifetimes of the pair are capped at the familyExpiry, the family ends after its
+// familyLifetime from now when zero
+func (authConfig *JwtAuthConfig) issueTokenPair(ctx context.Context, username string, roles []string, authentication *Authentication, family string, familyExpiry time.Time) (*TokenPair, *turboError.JwtError) {
+	ttl := authConfig.tokenTTL(&TTLRequest{Subject: username, Roles: roles, Authentication: authentication})
+	if authConfig.RefreshTokens != nil {
+		now := authConfig.now()
+		if family == "" {
+			family = uuid.New().String()
+		}
+		if familyExpiry.IsZero() {
+			// the fexp claim has a precision of a second
+			familyExpiry = time.Unix(now.Add(authConfig.familyLifetime(ttl)).Unix(), 0)
+		}
+		remaining := familyExpiry.Sub(now)
+		if remaining <= 0 {
+			return nil, turboError.NewJwtError(turboError.Wrap(turboError.ErrTokenExpired, ErrRefreshFamilyExpired), 401)
+		}
+		ttl = ttl.capped(remaining)
+	}
+	authToken, jwtErr := authConfig.issueToken(ctx, username, ttl.Auth, roles, authentication)
+	if jwtErr != nil {
+		return nil, jwtErr
+	}
+	var refresh *Authentication
+	if authentication != nil {
+		// the refresh token carries the authentication over to the auth tokens of the rotations
+		refresh = &Authentication{ACR: authentication.ACR, AMR: authentication.AMR, Time: authentication.Time,
+			DeviceID: authentication.DeviceID, UserAgent: authentication.UserAgent, fingerprint: authentication.fingerprint}
+	}
+	var refreshRoles []string
+	if authConfig.RefreshTokens != nil {
+		if refresh == nil {
+			refresh = &Authentication{}
+		}
+		// the roles are carried over to the auth tokens of the rotations
+		refresh.family, refresh.familyExpiry, refreshRoles = family, familyExpiry, roles
+	}
+	refreshToken, payload, jwtErr := authConfig.issue(ctx, username, ttl.Refresh, refreshRoles, refresh, TokenUseRefresh)
+	if jwtErr != nil {
+		return nil, jwtErr
+	}
+	if authConfig.RefreshTokens != nil {
+		err := saveRefreshToken(ctx, authConfig.RefreshTokens, &RefreshToken{JTI: payload.ID.String(), Family: family,
+			Subject: username, ExpiresAt: payload.ExpiredAt})
+		if err != nil {
+			return nil, turboError.NewJwtError(err, 500)
+		}
+	}
+	return &TokenPair{
+		AuthToken:    authToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    ttl.Auth,
+	}, nil
+}
+
+func (authConfig *JwtAuthConfig) issueToken(ctx context.Context, username string, duration time.Duration, roles []string, authentication *Authentication) (string, *turboError.JwtError) {
+	token, _, jwtErr := authConfig.issue(ctx, username, duration, roles, authentication, TokenUseAccess)
+	return token, jwtErr
+}
+
+func (authConfig *JwtAuthConfig) issue(ctx context.Context, username string, duration time.Duration, roles []string, authentication *Authentication, tokenUse string) (string, *Payload, *turboError.JwtError) {
+	payload, err := newPayload(username, duration, authConfig.now())
 	if err != nil {
+		authConfig.audit(nil, audit.EventTokenIssued, &turboAuth.Identity{Subject: username}, err)
+		return "", nil, turboError.NewJwtError(err, 406)
+	}
+	payload.TokenUse = tokenUse
+	var claims jwt.Claims = payload
+	if len(roles) > 0 || authentication != nil {
+		extended := &extendedClaims{Payload: *payload, Roles: roles}
+		if authentication != nil {
+			extended.ACR, extended.AMR = authentication.ACR, authentication.AMR
+			if !authentication.Time.IsZero() {
+				extended.AuthTime = authentication.Time.Unix()
+			}
+			if authentication.DeviceID != "" {
+				// the hashes of the tokens being re-issued are carried over
+				extended.DeviceID, extended.Device = authentication.DeviceID, authentication.fingerprint
+				if extended.Device == "" {
+					if authConfig.DeviceBinding == nil || len(authConfig.DeviceBinding.Key) == 0 {
+						return "", nil, turboError.NewJwtError(ErrDeviceBindingKey, 500)
+					}
+					extended.DeviceID = authConfig.DeviceBinding.DeviceHash(authentication.DeviceID)
+					extended.Device = authConfig.DeviceBinding.Fingerprint(authentication.UserAgent, authentication.DeviceID)
+				}
+			}
+			extended.Family = authentication.family
+			if !authentication.familyExpiry.IsZero() {
+				extended.FamilyExpiry = authentication.familyExpiry.Unix()
+			}
+		}
+		claims = extended
+	}
+	ctx, span := authConfig.startSpan(ctx, "jwt.IssueNewToken")
+	start := time.Now()
+	token, jwtErr := authConfig.signPayload(ctx, claims)
+	authConfig.Metrics.ObserveTokenIssue("jwt", start)
+	identity := &turboAuth.Identity{Subject: username, TokenID: payload.ID.String()}
+	if jwtErr != nil {
+		endSpan(span, jwtErr)
+		authConfig.audit(nil, audit.EventTokenIssued, identity, jwtErr)
+		return "", nil, jwtErr
+	}
+	endSpan(span, nil)
+	authConfig.audit(nil, audit.EventTokenIssued, identity, nil)
+	return token, payload, nil
+}
+
+func (authConfig *JwtAuthConfig) signPayload(ctx context.Context, claims jwt.Claims) (string, *turboError.JwtError) {
+	claims, err := authConfig.stampVersion(ctx, claims)
+	if err != nil {
+		return "", turboError.NewJwtError(err, 500)
+	}
+	if claims, err = authConfig.compressClaims(claims); err != nil {
 		return "", turboError.NewJwtError(err, 406)
 	}
-	jwtToken, err := BuildTokenWithClaims(authConfig.SigningMethod, payload)
+	token, err := authConfig.protectToken(claims, func(claims jwt.Claims) (string, error) {
+		token, jwtErr := authConfig.signPayloadJWS(ctx, claims)
+		if jwtErr != nil {
+			return "", jwtErr
+		}
+		return token, nil
+	})
+	if err != nil {
+		var jwtErr *turboError.JwtError
+		if errors.As(err, &jwtErr) {
+			return "", jwtErr
+		}
+		return "", turboError.NewJwtError(err, 406)
+	}
+	return token, nil
+}
+
+func (authConfig *JwtAuthConfig) signPayloadJWS(ctx context.Context, claims jwt.Claims) (string, *turboError.JwtError) {
+	if authConfig.KeyStore != nil {
+		token, err := authConfig.signWithKeyStore(ctx, claims)
+		return token, turboError.NewJwtError(err, 406)
+	}
+	jwtToken, err := buildToken(authConfig.SigningMethod, claims)
 	if err != nil {
 		return "", turboError.NewJwtError(err, 406)
 	}
@@ -50,6 +356,46 @@ func (authConfig *JwtAuthConfig) IssueNewToken(username string, duration time.Du
 	return token, turboError.NewJwtError(err, 406)
 }
 
+// audit sends the event to the AuditLogger, identity may be nil when the token could not be parsed
+func (authConfig *JwtAuthConfig) audit(r *http.Request, eventType audit.EventType, identity *turboAuth.Identity, err error) {
+	if authConfig.AuditLogger == nil {
+		return
+	}
+	event := audit.NewEvent(r, eventType, "jwt", err)
+	if identity != nil {
+		event.Subject = identity.Subject
+		event.TokenID = identity.TokenID
+	}
+	authConfig.AuditLogger.Log(event)
+}
+
+// tokenSource describes where the auth token is read from, for the decision traces
+func (authConfig *JwtAuthConfig) tokenSource() string {
+	switch {
+	case authConfig.TokenExtractor != nil:
+		return "token extractor"
+	case authConfig.BearerTokens:
+		return "header " + authConfig.AuthTokenName
+	default:
+		return "cookie " + authConfig.AuthTokenName
+	}
+}
+
+// verificationKey describes the key selected by the header of the token, for the decision traces
+func verificationKey(token string) string {
+	if token == "" {
+		return "no token"
+	}
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return "encrypted or malformed token"
+	}
+	if kid, _ := parsed.Header["kid"].(string); kid != "" {
+		return fmt.Sprintf("kid %s, alg %v", kid, parsed.Header["alg"])
+	}
+	return fmt.Sprintf("signing key, alg %v", parsed.Header["alg"])
+}
+
 func (authConfig *JwtAuthConfig) fetchCredsFromRequest(r *http.Request, creds *Credentials) *turboError.JwtError {
 	authToken, refreshToken, err := authConfig.fetchTokensFromRequest(r)
 	if err != nil {
@@ -72,7 +418,7 @@ func (authConfig *JwtAuthConfig) fetchCredsFromRequest(r *http.Request, creds *C
 func (authConfig *JwtAuthConfig) NullifyTokens(w http.ResponseWriter, r *http.Request) error {
 	var c Credentials
 	if err := authConfig.fetchCredsFromRequest(r, &c); err != nil {
-		return turboError.NewJwtError("Error fetching credentials from request", 500)
+		return turboError.NewJwtError(errors.New("error fetching credentials from request"), 500)
 	}
 
 	if authConfig.BearerTokens {
@@ -98,9 +444,43 @@ func (authConfig *JwtAuthConfig) NullifyTokens(w http.ResponseWriter, r *http.Re
 	return nil
 }
 
+// WriteTokens sends the issued tokens to the client as headers or cookies, an empty refresh token is skipped
+func (authConfig *JwtAuthConfig) WriteTokens(w http.ResponseWriter, authToken string, refreshToken string) {
+	if authConfig.BearerTokens {
+		w.Header().Set(authConfig.AuthTokenName, authToken)
+		if refreshToken != "" {
+			w.Header().Set(authConfig.RefreshTokenName, refreshToken)
+		}
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     authConfig.AuthTokenName,
+		Value:    authToken,
+		Path:     "/",
+		Expires:  time.Now().Add(authConfig.AuthTokenValidTime),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	if refreshToken != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     authConfig.RefreshTokenName,
+			Value:    refreshToken,
+			Path:     "/",
+			Expires:  time.Now().Add(authConfig.RefreshTokenValidTime),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
 func (authConfig *JwtAuthConfig) fetchTokensFromRequest(r *http.Request) (string, string, error) {
+	if authConfig.TokenExtractor != nil {
+		return authConfig.extractTokens(r, authConfig.TokenExtractor, authConfig.RefreshTokenExtractor)
+	}
 	if authConfig.BearerTokens {
-		return r.Header.Get(authConfig.AuthTokenName), r.Header.Get(authConfig.RefreshTokenName), nil
+		return authConfig.extractTokens(r, extractor.Header(authConfig.AuthTokenName), extractor.Header(authConfig.RefreshTokenName))
 	}
 
 	var (
@@ -130,6 +510,21 @@ func (authConfig *JwtAuthConfig) fetchTokensFromRequest(r *http.Request) (string
 	return authCookieValue, refreshCookieValue, nil
 }
 
+func (authConfig *JwtAuthConfig) extractTokens(r *http.Request, authExtractor, refreshExtractor extractor.Extractor) (string, string, error) {
+	authToken, err := authExtractor.Extract(r)
+	if err != nil {
+		return "", "", turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	if refreshExtractor == nil {
+		return authToken, "", nil
+	}
+	refreshToken, err := refreshExtractor.Extract(r)
+	if err != nil {
+		return "", "", turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	return authToken, refreshToken, nil
+}
+
 /*func (authConfig *JwtAuthConfig) fetchCsrfFromRequest(r *http.Request) (string, *turboError.JwtError) {
 	csrfString := r.FormValue(authConfig.CSRFTokenName)
 	if csrfString != "" {
@@ -1,12 +1,18 @@
 package jwt
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
+	"github.com/golang-jwt/jwt/v4"
 	turboAuth "github.com/nandlabs/turbo-auth"
 	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/extractor"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -238,6 +244,8 @@ func TestJwtAuthConfig_HandleRequest(t *testing.T) {
 				AuthTokenValidTime:    tt.fields.AuthTokenValidTime,
 				AuthTokenName:         tt.fields.AuthTokenName,
 				RefreshTokenName:      tt.fields.RefreshTokenName,
+				// the token below was issued on 2022-06-30, validate it at that time
+				Clock: FixedClock(time.Date(2022, 6, 30, 0, 49, 52, 435469000, time.FixedZone("IST", 19800))),
 			}
 			authConfig = CreateJwtAuthenticator(authConfig)
 
@@ -263,3 +271,393 @@ func TestJwtAuthConfig_HandleRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestJwtAuthConfig_Authenticate_errorKinds(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{name: "Test_missing_token", token: "", want: turboError.ErrMissingToken},
+		{name: "Test_malformed_token", token: "not-a-token", want: turboError.ErrTokenMalformed},
+		{name: "Test_tampered_signature", token: token[:len(token)-2] + "xx", want: turboError.ErrSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := authConfig.Authenticate(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Authenticate() error = %v, want %v", err, tt.want)
+			}
+			if turboError.Kind(err) != tt.want {
+				t.Errorf("Kind() = %v, want %v", turboError.Kind(err), tt.want)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_Authenticate_malformedTimes(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+	expired := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   error
+	}{
+		{name: "Test_rfc3339_expiry", claims: jwt.MapClaims{"ExpiredAt": time.Now().Add(time.Minute).Format(time.RFC3339Nano)}},
+		{name: "Test_numeric_expiry", claims: jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()}},
+		{name: "Test_expired", claims: jwt.MapClaims{"ExpiredAt": expired.Format(time.RFC3339Nano)}, want: turboError.ErrTokenExpired},
+		{name: "Test_unparsable_expiry", claims: jwt.MapClaims{"ExpiredAt": "next week"}, want: turboError.ErrTokenMalformed},
+		{name: "Test_unparsable_shadowing_exp", claims: jwt.MapClaims{"ExpiredAt": "next week", "exp": time.Now().Add(time.Minute).Unix()},
+			want: turboError.ErrTokenMalformed},
+		{name: "Test_boolean_not_before", claims: jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix(), "nbf": true},
+			want: turboError.ErrTokenMalformed},
+		{name: "Test_unparsable_issued_at", claims: jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix(), "iat": "yesterday"},
+			want: turboError.ErrTokenMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.claims["Username"] = "test_user"
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tt.claims).SignedString([]byte("test_key"))
+			if err != nil {
+				t.Fatalf("SignedString() error = %v", err)
+			}
+			if _, err := authConfig.Authenticate(token); !errors.Is(err, tt.want) {
+				t.Errorf("Authenticate() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_Authenticate_clock(t *testing.T) {
+	issuedAt := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	issuer := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		Clock:         FixedClock(issuedAt),
+	})
+	token, jwtErr := issuer.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	tests := []struct {
+		name   string
+		now    time.Time
+		leeway time.Duration
+		want   error
+	}{
+		{name: "Test_before_expiry", now: issuedAt.Add(30 * time.Second), want: nil},
+		{name: "Test_expired", now: issuedAt.Add(2 * time.Minute), want: turboError.ErrTokenExpired},
+		{name: "Test_expired_within_leeway", now: issuedAt.Add(2 * time.Minute), leeway: 5 * time.Minute, want: nil},
+		{name: "Test_issued_in_future", now: issuedAt.Add(-time.Minute), want: turboError.ErrTokenNotValidYet},
+		{name: "Test_issued_in_future_within_leeway", now: issuedAt.Add(-time.Minute), leeway: 5 * time.Minute, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+				SigningKey:    "test_key",
+				SigningMethod: "HS256",
+				Clock:         FixedClock(tt.now),
+				Leeway:        tt.leeway,
+			})
+			_, err := authConfig.Authenticate(token)
+			if turboError.Kind(err) != tt.want {
+				t.Errorf("Authenticate() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_ClaimsMapper(t *testing.T) {
+	disabled := errors.New("account is disabled")
+	mapper := func(ctx context.Context, identity *turboAuth.Identity) (*turboAuth.Identity, error) {
+		if identity.Subject == "disabled_user" {
+			return nil, disabled
+		}
+		identity.Roles = append(identity.Roles, "user")
+		return identity, nil
+	}
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+	}, WithClaimsMapper(mapper))
+
+	tests := []struct {
+		name      string
+		username  string
+		wantErr   error
+		wantRoles []string
+	}{
+		{name: "Test_mapped", username: "test_user", wantRoles: []string{"user"}},
+		{name: "Test_rejected", username: "disabled_user", wantErr: disabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, jwtErr := authConfig.IssueNewToken(tt.username, time.Minute)
+			if jwtErr != nil {
+				t.Fatalf("IssueNewToken() error = %v", jwtErr)
+			}
+			identity, err := authConfig.Authenticate(token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(identity.Roles, tt.wantRoles) {
+				t.Errorf("Authenticate() roles = %v, want %v", identity.Roles, tt.wantRoles)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_TokenExtractor(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+	}, WithTokenExtractor(extractor.Chain(extractor.AuthorizationBearer(), extractor.QueryParam("access_token")), nil))
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	tests := []struct {
+		name    string
+		request func(r *http.Request)
+		wantErr bool
+	}{
+		{name: "Test_bearer", request: func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }},
+		{name: "Test_query", request: func(r *http.Request) { r.URL.RawQuery = "access_token=" + token }},
+		{name: "Test_cookie_ignored", request: func(r *http.Request) { r.AddCookie(&http.Cookie{Name: authConfig.AuthTokenName, Value: token}) }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			tt.request(r)
+			identity, err := authConfig.handleRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handleRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && identity.Subject != "test_user" {
+				t.Errorf("handleRequest() subject = %v", identity.Subject)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_decisionTrace(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{SigningKey: "test_key", SigningMethod: "HS256", BearerTokens: true})
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{
+			name:  "Test_verified",
+			token: token,
+			want:  "extractor jwt allowed: header X-Auth-Token; key jwt allowed: signing key, alg HS256",
+		},
+		{
+			name: "Test_missing_token",
+			want: "extractor jwt denied: header X-Auth-Token; key jwt denied: no token: empty auth token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, trace := turboAuth.WithDecisionTrace(context.Background())
+			r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+			r.Header.Set(authConfig.AuthTokenName, tt.token)
+			_, _ = authConfig.handleRequest(r)
+			if got := trace.String(); got != tt.want {
+				t.Errorf("trace = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_Apply_challenge(t *testing.T) {
+	rejected := errors.New("account is disabled")
+	mapper := func(ctx context.Context, identity *turboAuth.Identity) (*turboAuth.Identity, error) {
+		return nil, rejected
+	}
+	tests := []struct {
+		name          string
+		errorWriter   turboError.ErrorWriter
+		mapper        func(ctx context.Context, identity *turboAuth.Identity) (*turboAuth.Identity, error)
+		token         string
+		wantCode      int
+		wantChallenge string
+	}{
+		{name: "Test_missing_token", wantCode: http.StatusUnauthorized, wantChallenge: "Bearer"},
+		{name: "Test_malformed_token", token: "invalid", wantCode: http.StatusUnauthorized,
+			wantChallenge: `Bearer error="invalid_token", error_description="token contains an invalid number of segments"`},
+		{name: "Test_bearer_writer_realm", errorWriter: turboError.NewBearerWriter("api"), wantCode: http.StatusUnauthorized,
+			wantChallenge: `Bearer realm="api"`},
+		{name: "Test_rejected_identity", mapper: mapper, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authConfig := CreateJwtAuthenticator(&JwtAuthConfig{SigningKey: "test_key", SigningMethod: "HS256",
+				BearerTokens: true, ErrorWriter: tt.errorWriter, ClaimsMapper: tt.mapper})
+			token := tt.token
+			if tt.mapper != nil {
+				issued, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+				if jwtErr != nil {
+					t.Fatalf("IssueNewToken() error = %v", jwtErr)
+				}
+				token = issued
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(authConfig.AuthTokenName, token)
+			w := httptest.NewRecorder()
+			authConfig.Apply(http.NotFoundHandler()).ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("Apply() status = %v, want %v", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get(turboError.HeaderWWWAuthenticate); got != tt.wantChallenge {
+				t.Errorf("Apply() challenge = %v, want %v", got, tt.wantChallenge)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_verifyHS256(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{SigningKey: "test_key", SigningMethod: "HS256"})
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	tests := []struct {
+		name        string
+		token       string
+		wantHandled bool
+		want        error
+	}{
+		{name: "Test_valid", token: token, wantHandled: true},
+		{name: "Test_tampered_signature", token: token[:len(token)-2] + "xx", wantHandled: true, want: turboError.ErrSignatureInvalid},
+		{name: "Test_tampered_payload", token: strings.Replace(token, ".", ".e", 1), wantHandled: true, want: turboError.ErrSignatureInvalid},
+		{name: "Test_extra_segment", token: token + ".x", wantHandled: true, want: turboError.ErrTokenMalformed},
+		{name: "Test_other_header", token: "eyJhbGciOiJIUzUxMiJ9" + token[strings.IndexByte(token, '.'):]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Credentials{AuthToken: tt.token}
+			handled, err := authConfig.verifyHS256(c)
+			if handled != tt.wantHandled {
+				t.Fatalf("verifyHS256() handled = %v, want %v", handled, tt.wantHandled)
+			}
+			if turboError.Kind(err) != tt.want {
+				t.Errorf("verifyHS256() error = %v, want %v", err, tt.want)
+			}
+			if handled && err == nil {
+				generic := &Credentials{AuthToken: tt.token}
+				if err := generic.validateToken(authConfig.keyFunc, authConfig.now(), 0); err != nil {
+					t.Fatalf("validateToken() error = %v", err)
+				}
+				if !reflect.DeepEqual(c.Claims, generic.Claims) {
+					t.Errorf("verifyHS256() claims = %v, want %v", c.Claims, generic.Claims)
+				}
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_hs256FastPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(authConfig *JwtAuthConfig)
+		want    bool
+	}{
+		{name: "Test_created", want: true},
+		{name: "Test_key_replaced", prepare: func(authConfig *JwtAuthConfig) { authConfig.SigningKey = "rotated_key" }},
+		{name: "Test_not_created", prepare: func(authConfig *JwtAuthConfig) { authConfig.hs256 = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authConfig := CreateJwtAuthenticator(&JwtAuthConfig{SigningKey: "test_key", SigningMethod: "HS256"})
+			if tt.prepare != nil {
+				tt.prepare(authConfig)
+			}
+			if got := authConfig.hs256FastPath(); got != tt.want {
+				t.Errorf("hs256FastPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkJwtAuthConfig_HandleRequest(b *testing.B) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{SigningKey: "test_key", SigningMethod: "HS256", BearerTokens: true})
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Hour)
+	if jwtErr != nil {
+		b.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(authConfig.AuthTokenName, token)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := authConfig.handleRequest(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkJwtAuthConfig_verifyHS256(b *testing.B) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{SigningKey: "test_key", SigningMethod: "HS256"})
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Hour)
+	if jwtErr != nil {
+		b.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := authConfig.verifyHS256(&Credentials{AuthToken: token}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkJwtAuthConfig_verifyHS256_tampered compares the signatures differing at the first and last byte, the
+// timings do not depend on where the signatures differ
+func BenchmarkJwtAuthConfig_verifyHS256_tampered(b *testing.B) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{SigningKey: "test_key", SigningMethod: "HS256"})
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Hour)
+	if jwtErr != nil {
+		b.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	signatureStart := strings.LastIndexByte(token, '.') + 1
+	tamper := func(i int) string {
+		signature, _ := base64.RawURLEncoding.DecodeString(token[signatureStart:])
+		signature[i] ^= 0xff
+		return token[:signatureStart] + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	benchmarks := []struct {
+		name  string
+		token string
+	}{
+		{name: "first_byte", token: tamper(0)},
+		{name: "last_byte", token: tamper(sha256.Size - 1)},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := authConfig.verifyHS256(&Credentials{AuthToken: bm.token}); err == nil {
+					b.Fatal("verifyHS256() accepted a tampered signature")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package jwt
+
+import (
+	"context"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"runtime"
+	"sync"
+)
+
+// TokenResult is the outcome of the validation of one token of a batch
+type TokenResult struct {
+	Identity *turboAuth.Identity
+	Err      error
+}
+
+// ValidateTokens validates the tokens of a batch, e.g. the events of a message queue, see ValidateTokensContext
+func (authConfig *JwtAuthConfig) ValidateTokens(tokens []string) []TokenResult {
+	return authConfig.ValidateTokensContext(context.Background(), tokens)
+}
+
+// ValidateTokensContext validates the tokens across a pool of GOMAXPROCS workers and returns the results in the
+// order of the tokens, a token repeated in the batch is validated once. The tokens not validated when the context is
+// done fail with its error
+func (authConfig *JwtAuthConfig) ValidateTokensContext(ctx context.Context, tokens []string) []TokenResult {
+	results := make([]TokenResult, len(tokens))
+	// the positions of each distinct token
+	positions := make(map[string][]int, len(tokens))
+	var distinct []string
+	for i, token := range tokens {
+		if _, ok := positions[token]; !ok {
+			distinct = append(distinct, token)
+		}
+		positions[token] = append(positions[token], i)
+	}
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(distinct) {
+		workers = len(distinct)
+	}
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for token := range jobs {
+				var result TokenResult
+				if err := ctx.Err(); err != nil {
+					result.Err = err
+				} else {
+					result.Identity, result.Err = authConfig.AuthenticateContext(ctx, token)
+				}
+				// each token has its own positions, no two workers write the same result
+				for _, i := range positions[token] {
+					results[i] = result
+				}
+			}
+		}()
+	}
+	for _, token := range distinct {
+		jobs <- token
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,58 @@
+package jwt
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJwtAuthConfig_ValidateTokens(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+	valid, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	other, jwtErr := authConfig.IssueNewToken("other_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		tokens       []string
+		wantSubjects []string
+	}{
+		{name: "Test_empty_batch", ctx: context.Background()},
+		{
+			name:         "Test_mixed_batch",
+			ctx:          context.Background(),
+			tokens:       []string{valid, "invalid", other, valid},
+			wantSubjects: []string{"test_user", "", "other_user", "test_user"},
+		},
+		{name: "Test_cancelled", ctx: cancelled, tokens: []string{valid}, wantSubjects: []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := authConfig.ValidateTokensContext(tt.ctx, tt.tokens)
+			if len(results) != len(tt.tokens) {
+				t.Fatalf("ValidateTokensContext() returned %d results, want %d", len(results), len(tt.tokens))
+			}
+			for i, result := range results {
+				if tt.wantSubjects[i] == "" {
+					if result.Err == nil {
+						t.Errorf("result %d = %+v, want an error", i, result.Identity)
+					}
+				} else if result.Err != nil || result.Identity.Subject != tt.wantSubjects[i] {
+					t.Errorf("result %d = %+v, %v, want %v", i, result.Identity, result.Err, tt.wantSubjects[i])
+				}
+			}
+		})
+	}
+}
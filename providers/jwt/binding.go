@@ -0,0 +1,78 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+)
+
+// ErrUnverifiableBinding is returned when the token is bound to a key, a certificate or a device whose proof cannot
+// be verified: the binding is not configured or the token is not presented along with a request
+var ErrUnverifiableBinding = errors.New("token binding cannot be verified")
+
+// validateBindings checks the sender constrained, certificate bound and device bound tokens against the proofs of
+// the request. The bound tokens fail closed: they are rejected without a request, e.g. by Authenticate, and when
+// their cnf claim names a confirmation method which is not configured. The DPoP and the certificate bindings only
+// apply to the auth tokens
+func (authConfig *JwtAuthConfig) validateBindings(r *http.Request, token string, identity *turboAuth.Identity, tokenUse string) error {
+	if err := authConfig.configuredBindings(identity); err != nil {
+		return err
+	}
+	_, present := identity.Claims["cnf"]
+	_, deviceBound := identity.Claims[ClaimDeviceID]
+	if r == nil {
+		switch {
+		case present:
+			return bindingError("no proof of possession without a request")
+		case authConfig.DeviceBinding != nil && (deviceBound || authConfig.DeviceBinding.Required):
+			return bindingError("no device without a request")
+		case tokenUse == TokenUseAccess && ((authConfig.DPoP != nil && authConfig.DPoP.Required) ||
+			(authConfig.CertificateBinding != nil && authConfig.CertificateBinding.Required)):
+			return bindingError("no proof of possession without a request")
+		}
+		return nil
+	}
+	if tokenUse == TokenUseAccess && authConfig.DPoP != nil {
+		if err := authConfig.DPoP.validate(r, token, identity.Claims, authConfig.now()); err != nil {
+			return err
+		}
+	}
+	if tokenUse == TokenUseAccess && authConfig.CertificateBinding != nil {
+		if err := authConfig.CertificateBinding.validate(r, identity.Claims); err != nil {
+			return err
+		}
+	}
+	if authConfig.DeviceBinding != nil {
+		if err := authConfig.DeviceBinding.validate(r, identity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configuredBindings checks the bindings of the token are configured, whether or not their proofs are presented
+func (authConfig *JwtAuthConfig) configuredBindings(identity *turboAuth.Identity) error {
+	confirmation, present := identity.Claims["cnf"]
+	cnf, _ := confirmation.(map[string]interface{})
+	if present && len(cnf) == 0 {
+		return bindingError("malformed cnf claim")
+	}
+	for method := range cnf {
+		switch {
+		case method == "jkt" && authConfig.DPoP != nil:
+		case method == claimX5tS256 && authConfig.CertificateBinding != nil:
+		default:
+			return bindingError("confirmation method " + method + " is not configured")
+		}
+	}
+	if _, deviceBound := identity.Claims[ClaimDeviceID]; deviceBound && authConfig.DeviceBinding == nil {
+		return bindingError("device binding is not configured")
+	}
+	return nil
+}
+
+func bindingError(reason string) error {
+	return turboError.Wrap(turboError.ErrTokenInvalid, fmt.Errorf("%w: %s", ErrUnverifiableBinding, reason))
+}
@@ -0,0 +1,66 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestJwtAuthConfig_validateBindings(t *testing.T) {
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := NewJWK(&Key{SigningMethod: "ES256", VerifyKey: &clientKey.PublicKey})
+	jkt, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dpop := NewDPoP()
+	dpop.ProtoHeader = HeaderForwardedProto
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		DPoP:          dpop,
+	})
+	// the same key without the DPoP binding configured
+	plain := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+	token, jwtErr := authConfig.IssueDPoPBoundToken("test_user", time.Minute, jkt)
+	if jwtErr != nil {
+		t.Fatalf("IssueDPoPBoundToken() error = %v", jwtErr)
+	}
+	const uri = "https://example.com/orders"
+
+	t.Run("Test_forwarded_proto", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/orders", nil)
+		r.Header.Set(HeaderForwardedProto, "https")
+		r.Header.Set(HeaderDPoP, newDPoPProof(t, clientKey, http.MethodGet, uri, token, "jti-1"))
+		if _, err := authConfig.AuthenticateRequest(r, token); err != nil {
+			t.Errorf("AuthenticateRequest() error = %v", err)
+		}
+	})
+	t.Run("Test_without_request", func(t *testing.T) {
+		if _, err := authConfig.Authenticate(token); !errors.Is(err, ErrUnverifiableBinding) {
+			t.Errorf("Authenticate() error = %v, want %v", err, ErrUnverifiableBinding)
+		}
+		if results := authConfig.ValidateTokens([]string{token}); !errors.Is(results[0].Err, ErrUnverifiableBinding) {
+			t.Errorf("ValidateTokens() error = %v, want %v", results[0].Err, ErrUnverifiableBinding)
+		}
+	})
+	t.Run("Test_binding_not_configured", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, uri, nil)
+		r.Header.Set(plain.AuthTokenName, token)
+		r.Header.Set(HeaderDPoP, newDPoPProof(t, clientKey, http.MethodGet, uri, token, "jti-2"))
+		if err := plain.HandleRequest(httptest.NewRecorder(), r); err == nil || err.Code != 401 ||
+			!errors.Is(err, ErrUnverifiableBinding) {
+			t.Errorf("HandleRequest() error = %v, want %v", err, ErrUnverifiableBinding)
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/internal/secret"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// BodyDigest protects the body of the requests against substitution, e.g. for the webhooks. A token carrying a
+	// bsh claim, the base64url SHA-256 of the body, is only accepted along with that body, and the Content-Digest
+	// header (RFC 9530) is checked when present. The digested bodies are buffered and verified before the handler
+	// runs, the mismatching and the oversized bodies are rejected with 400
+	BodyDigest struct {
+		// Required rejects the tokens which are not bound to the body
+		Required bool
+		// Header is the header carrying the digest, HeaderContentDigest when empty
+		Header string
+		// MaxBodySize bounds the buffered bodies, DefaultMaxDigestBodySize when 0
+		MaxBodySize int64
+	}
+)
+
+const (
+	HeaderContentDigest = "Content-Digest"
+	// DefaultMaxDigestBodySize is the default MaxBodySize of the BodyDigest
+	DefaultMaxDigestBodySize = 1 << 20
+	// claimBodyDigest is to the body what the ath claim of DPoP is to the access token
+	claimBodyDigest = "bsh"
+)
+
+var (
+	ErrInvalidBodyDigest = errors.New("invalid body digest")
+	// ErrBodyDigestMismatch is returned when the body does not match the digest
+	ErrBodyDigestMismatch = errors.New("body does not match its digest")
+	// ErrBodyTooLarge is returned when the digested body exceeds the MaxBodySize
+	ErrBodyTooLarge = errors.New("body exceeds the maximum size of the digested bodies")
+)
+
+func NewBodyDigest() *BodyDigest {
+	return &BodyDigest{Header: HeaderContentDigest, MaxBodySize: DefaultMaxDigestBodySize}
+}
+
+// BodySHA256 returns the value of the bsh claim for the body, read without being buffered
+func BodySHA256(body io.Reader) (string, error) {
+	sum := sha256.New()
+	if _, err := io.Copy(sum, body); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sum.Sum(nil)), nil
+}
+
+// ContentDigest formats the sha-256 Content-Digest header of the body digest returned by BodySHA256
+func ContentDigest(digest string) (string, error) {
+	sum, err := base64.RawURLEncoding.DecodeString(digest)
+	if err != nil {
+		return "", err
+	}
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum) + ":", nil
+}
+
+// validate checks the digests of the token and the header agree and verifies the body of the request against them,
+// the body is replaced with the buffered one
+func (b *BodyDigest) validate(r *http.Request, claims map[string]interface{}) error {
+	bound, _ := claims[claimBodyDigest].(string)
+	if bound == "" && b.Required {
+		return bodyDigestError("token is not bound to the body")
+	}
+	header, err := b.headerDigest(r)
+	if err != nil {
+		return err
+	}
+	digest := bound
+	switch {
+	case bound == "" && header == "":
+		return nil
+	case bound == "":
+		digest = header
+	case header != "" && !secret.Equal(header, bound):
+		return bodyDigestError("the header digest does not match the token")
+	}
+	expected, err := base64.RawURLEncoding.DecodeString(digest)
+	if err != nil || len(expected) != sha256.Size {
+		return bodyDigestError("malformed digest")
+	}
+	body, err := b.readBody(r)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	if !hmac.Equal(sum[:], expected) {
+		return ErrBodyDigestMismatch
+	}
+	return nil
+}
+
+// readBody buffers the body of the request up to the MaxBodySize
+func (b *BodyDigest) readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	max := b.MaxBodySize
+	if max <= 0 {
+		max = DefaultMaxDigestBodySize
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > max {
+		return nil, ErrBodyTooLarge
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// bodyDigestStatus answers the bodies which do not match or cannot be verified with 400, the tokens which are not
+// bound to them with 401
+func bodyDigestStatus(err error) int {
+	if errors.Is(err, ErrBodyDigestMismatch) || errors.Is(err, ErrBodyTooLarge) {
+		return http.StatusBadRequest
+	}
+	return http.StatusUnauthorized
+}
+
+// headerDigest returns the sha-256 digest of the header base64url encoded, the other algorithms are ignored
+func (b *BodyDigest) headerDigest(r *http.Request) (string, error) {
+	name := b.Header
+	if name == "" {
+		name = HeaderContentDigest
+	}
+	for _, member := range strings.Split(r.Header.Get(name), ",") {
+		parts := strings.SplitN(strings.TrimSpace(member), "=", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "sha-256") {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(strings.Trim(parts[1], ":"))
+		if err != nil {
+			return "", bodyDigestError("malformed " + name + " header")
+		}
+		return base64.RawURLEncoding.EncodeToString(sum), nil
+	}
+	return "", nil
+}
+
+// IssueBodyBoundToken issues an auth token bound to the body of the request it authenticates, digest is returned by
+// BodySHA256, see BodyDigest
+func (authConfig *JwtAuthConfig) IssueBodyBoundToken(username string, duration time.Duration, digest string) (string, *turboError.JwtError) {
+	if sum, err := base64.RawURLEncoding.DecodeString(digest); err != nil || len(sum) != sha256.Size {
+		return "", turboError.NewJwtError(errors.New("digest must be the base64url SHA-256 of the body"), 400)
+	}
+	payload, err := newPayload(username, duration, authConfig.now())
+	if err != nil {
+		return "", turboError.NewJwtError(err, 406)
+	}
+	token, jwtErr := authConfig.signPayload(context.Background(), &extendedClaims{
+		Payload:    *payload,
+		BodyDigest: digest,
+	})
+	identity := &turboAuth.Identity{Subject: username, TokenID: payload.ID.String()}
+	authConfig.audit(nil, audit.EventTokenIssued, identity, jwtErrOrNil(jwtErr))
+	return token, jwtErr
+}
+
+func bodyDigestError(reason string) error {
+	return turboError.Wrap(turboError.ErrTokenInvalid, fmt.Errorf("%w: %s", ErrInvalidBodyDigest, reason))
+}
@@ -0,0 +1,82 @@
+package jwt
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJwtAuthConfig_BodyDigest(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		BodyDigest:    &BodyDigest{MaxBodySize: 64},
+	})
+	const body = `{"event":"invoice.paid","amount":42}`
+	digest, _ := BodySHA256(strings.NewReader(body))
+	otherDigest, _ := BodySHA256(strings.NewReader(`{"event":"invoice.paid","amount":4200}`))
+	header, _ := ContentDigest(digest)
+	otherHeader, _ := ContentDigest(otherDigest)
+	bound, jwtErr := authConfig.IssueBodyBoundToken("test_user", time.Minute, digest)
+	if jwtErr != nil {
+		t.Fatalf("IssueBodyBoundToken() error = %v", jwtErr)
+	}
+	unbound, _ := authConfig.IssueNewToken("test_user", time.Minute)
+
+	tests := []struct {
+		name     string
+		required bool
+		token    string
+		body     string
+		header   string
+		wantErr  error
+		wantCode int
+	}{
+		{name: "Test_bound", token: bound, body: body},
+		{name: "Test_bound_with_header", token: bound, body: body, header: header},
+		{name: "Test_swapped_body", token: bound, body: `{"event":"invoice.paid","amount":4200}`,
+			wantErr: ErrBodyDigestMismatch, wantCode: 400},
+		{name: "Test_oversized_body", token: bound, body: body + strings.Repeat(" ", 64), wantErr: ErrBodyTooLarge,
+			wantCode: 400},
+		{name: "Test_header_disagrees", token: bound, body: body, header: otherHeader, wantErr: ErrInvalidBodyDigest,
+			wantCode: 401},
+		{name: "Test_header_only", token: unbound, body: body, header: header},
+		{name: "Test_header_only_swapped", token: unbound, body: body, header: otherHeader,
+			wantErr: ErrBodyDigestMismatch, wantCode: 400},
+		{name: "Test_malformed_header", token: unbound, body: body, header: "sha-256=:not base64:",
+			wantErr: ErrInvalidBodyDigest, wantCode: 401},
+		{name: "Test_other_algorithm", token: unbound, body: body, header: "sha-512=:AAAA:"},
+		{name: "Test_unbound", token: unbound, body: body},
+		{name: "Test_required", required: true, token: unbound, body: body, wantErr: ErrInvalidBodyDigest,
+			wantCode: 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authConfig.BodyDigest.Required = tt.required
+			r := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(tt.body))
+			r.Header.Set(authConfig.AuthTokenName, tt.token)
+			if tt.header != "" {
+				r.Header.Set(HeaderContentDigest, tt.header)
+			}
+			_, jwtErr := authConfig.handleRequest(r)
+			if tt.wantErr != nil {
+				if jwtErr == nil || !errors.Is(jwtErr, tt.wantErr) || jwtErr.Code != tt.wantCode {
+					t.Errorf("handleRequest() error = %v, want %v with %d", jwtErr, tt.wantErr, tt.wantCode)
+				}
+				return
+			}
+			if jwtErr != nil {
+				t.Fatalf("handleRequest() error = %v", jwtErr)
+			}
+			read, err := ioutil.ReadAll(r.Body)
+			if err != nil || string(read) != tt.body {
+				t.Errorf("ReadAll() = %s, %v, want %s", read, err, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// BreakGlass accepts the emergency tokens signed offline with a dedicated key, e.g. to recover the access while
+	// the identity provider or the revocation store is down. The break-glass tokens skip the Revoker, the
+	// ClaimsMapper and the other remote dependencies, they are short lived, carry the BreakGlassScope and the reason
+	// of the access, and each of their uses is audited: they are rejected when no AuditLogger is configured
+	BreakGlass struct {
+		// Key verifies the tokens, it must not be the signing key of the regular tokens and its private part should
+		// be kept offline, e.g. NewFileKeySource of the PEM public key
+		Key *Key
+		// MaxTTL bounds the lifetime of the accepted tokens, DefaultBreakGlassMaxTTL when 0
+		MaxTTL time.Duration
+	}
+
+	// BreakGlassGrant describes the emergency access of IssueBreakGlassToken
+	BreakGlassGrant struct {
+		Subject string
+		Roles   []string
+		// Reason is mandatory, e.g. the incident id, it is recorded by the audit events of every use
+		Reason string
+		// TTL of the token, DefaultBreakGlassTTL when 0
+		TTL time.Duration
+	}
+)
+
+const (
+	// BreakGlassScope is granted to the break-glass tokens, e.g. for the routes which accept them
+	BreakGlassScope = "break-glass"
+
+	DefaultBreakGlassTTL    = 15 * time.Minute
+	DefaultBreakGlassMaxTTL = time.Hour
+
+	breakGlassType = "break-glass+jwt"
+)
+
+var ErrInvalidBreakGlassToken = errors.New("invalid break-glass token")
+
+func NewBreakGlass(key *Key) *BreakGlass {
+	return &BreakGlass{Key: key, MaxTTL: DefaultBreakGlassMaxTTL}
+}
+
+// IssueBreakGlassToken signs the token of the grant with the offline key, it is meant for the break-glass
+// procedure rather than for the services, e.g. the break-glass command of turbo-auth
+func IssueBreakGlassToken(key *Key, grant *BreakGlassGrant) (string, error) {
+	if key == nil || key.SignKey == nil {
+		return "", errors.New("break-glass: a private key is required")
+	}
+	if grant.Subject == "" || strings.TrimSpace(grant.Reason) == "" {
+		return "", errors.New("break-glass: the subject and the reason are required")
+	}
+	method, err := getSigningMethod(key.SigningMethod)
+	if err != nil {
+		return "", err
+	}
+	ttl := grant.TTL
+	if ttl <= 0 {
+		ttl = DefaultBreakGlassTTL
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":    grant.Subject,
+		"jti":    uuid.New().String(),
+		"iat":    now.Unix(),
+		"exp":    now.Add(ttl).Unix(),
+		"scope":  BreakGlassScope,
+		"reason": grant.Reason,
+	}
+	if len(grant.Roles) > 0 {
+		claims["Roles"] = grant.Roles
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["typ"] = breakGlassType
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.SignKey)
+}
+
+// isBreakGlassToken tells the break-glass tokens apart by their typ header
+func isBreakGlassToken(token string) bool {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	return err == nil && parsed.Header["typ"] == breakGlassType
+}
+
+// verify checks the signature, the expiry, the lifetime and the reason of the token
+func (b *BreakGlass) verify(token string, now time.Time) (*turboAuth.Identity, error) {
+	if b.Key == nil {
+		return nil, breakGlassError("no break-glass key configured")
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return b.Key.VerifyKey, nil
+	}, jwt.WithValidMethods([]string{b.Key.SigningMethod}), jwt.WithoutClaimsValidation())
+	if err != nil {
+		return nil, breakGlassError(err.Error())
+	}
+	issuedAt, okIat := timeClaim(claims, "iat")
+	expiresAt, okExp := timeClaim(claims, "exp")
+	if !okIat || !okExp || !now.Before(expiresAt) || issuedAt.After(now.Add(time.Minute)) {
+		return nil, turboError.Wrap(turboError.ErrTokenExpired, fmt.Errorf("%w: outside of its validity",
+			ErrInvalidBreakGlassToken))
+	}
+	maxTTL := b.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = DefaultBreakGlassMaxTTL
+	}
+	if expiresAt.Sub(issuedAt) > maxTTL {
+		return nil, breakGlassError("lifetime exceeds " + maxTTL.String())
+	}
+	identity := &turboAuth.Identity{
+		Claims:    claims,
+		ExpiresAt: expiresAt,
+		Roles:     stringsClaim(claims["Roles"]),
+		Scopes:    []string{BreakGlassScope},
+	}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.TokenID, _ = claims["jti"].(string)
+	if reason, _ := claims["reason"].(string); identity.Subject == "" || strings.TrimSpace(reason) == "" {
+		return nil, breakGlassError("the subject and the reason are required")
+	}
+	return identity, nil
+}
+
+// authenticateBreakGlass validates the break-glass token and audits its use, successful or not
+func (authConfig *JwtAuthConfig) authenticateBreakGlass(r *http.Request, token string) (*turboAuth.Identity, *turboError.JwtError) {
+	if authConfig.AuditLogger == nil {
+		err := breakGlassError("no audit logger configured")
+		logger.ErrorF("break-glass token rejected: %v", err)
+		return nil, turboError.NewJwtError(err, 403)
+	}
+	identity, err := authConfig.BreakGlass.verify(token, authConfig.now())
+	event := audit.NewEvent(r, audit.EventBreakGlass, "jwt", err)
+	if identity != nil {
+		event.Subject, event.TokenID = identity.Subject, identity.TokenID
+		event.Reason, _ = identity.Claims["reason"].(string)
+	}
+	authConfig.AuditLogger.Log(event)
+	authConfig.Metrics.ObserveAuth("jwt", err)
+	if err != nil {
+		logger.WarnF("break-glass token rejected: %v", err)
+		return nil, turboError.NewJwtError(err, 403)
+	}
+	logger.WarnF("break-glass access of %s, token %s: %s", identity.Subject, identity.TokenID, event.Reason)
+	return identity, nil
+}
+
+func breakGlassError(reason string) error {
+	return turboError.Wrap(turboError.ErrTokenInvalid, fmt.Errorf("%w: %s", ErrInvalidBreakGlassToken, reason))
+}
@@ -0,0 +1,91 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"github.com/nandlabs/turbo-auth/audit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestJwtAuthConfig_BreakGlass(t *testing.T) {
+	offline, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key := &Key{ID: "break-glass", SigningMethod: "ES256", SignKey: offline, VerifyKey: &offline.PublicKey}
+	var events []*audit.Event
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		// the revocation store is down
+		Revoker:     unavailableRevoker{NewMemoryRevoker()},
+		BreakGlass:  NewBreakGlass(&Key{SigningMethod: "ES256", VerifyKey: &offline.PublicKey}),
+		AuditLogger: audit.AuditLoggerFunc(func(event *audit.Event) { events = append(events, event) }),
+	})
+	issue := func(signKey *ecdsa.PrivateKey, grant *BreakGlassGrant) string {
+		token, err := IssueBreakGlassToken(&Key{SigningMethod: "ES256", SignKey: signKey}, grant)
+		if err != nil {
+			t.Fatalf("IssueBreakGlassToken() error = %v", err)
+		}
+		return token
+	}
+	regular, _ := authConfig.IssueNewToken("admin", time.Minute)
+
+	tests := []struct {
+		name      string
+		token     string
+		wantErr   error
+		rejected  bool
+		wantAudit bool
+	}{
+		{name: "Test_break_glass", token: issue(key.SignKey.(*ecdsa.PrivateKey), &BreakGlassGrant{Subject: "admin",
+			Roles: []string{"admin"}, Reason: "INC-42"}), wantAudit: true},
+		{name: "Test_other_key", token: issue(other, &BreakGlassGrant{Subject: "admin", Reason: "INC-42"}),
+			wantErr: ErrInvalidBreakGlassToken, wantAudit: true},
+		{name: "Test_lifetime_too_long", token: issue(offline, &BreakGlassGrant{Subject: "admin", Reason: "INC-42",
+			TTL: 24 * time.Hour}), wantErr: ErrInvalidBreakGlassToken, wantAudit: true},
+		{name: "Test_regular_token_revoker_down", token: regular, rejected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events = nil
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(authConfig.AuthTokenName, tt.token)
+			identity, jwtErr := authConfig.handleRequest(r)
+			if tt.rejected {
+				if jwtErr == nil {
+					t.Fatalf("handleRequest() accepted a regular token while the revoker is down")
+				}
+				return
+			}
+			if tt.wantErr == nil && jwtErr != nil {
+				t.Fatalf("handleRequest() error = %v", jwtErr)
+			}
+			if tt.wantErr != nil && (jwtErr == nil || !errors.Is(jwtErr, tt.wantErr)) {
+				t.Fatalf("handleRequest() error = %v, want %v", jwtErr, tt.wantErr)
+			}
+			if tt.wantErr == nil && (identity.Subject != "admin" || !identity.HasScope(BreakGlassScope) ||
+				!identity.HasRole("admin")) {
+				t.Errorf("handleRequest() identity = %+v", identity)
+			}
+			audited := len(events) == 1 && events[0].Type == audit.EventBreakGlass
+			if audited != tt.wantAudit {
+				t.Errorf("audit events = %+v, want a break-glass event %v", events, tt.wantAudit)
+			}
+			if audited && tt.wantErr == nil && events[0].Reason != "INC-42" {
+				t.Errorf("audit reason = %q, want INC-42", events[0].Reason)
+			}
+		})
+	}
+
+	authConfig.AuditLogger = nil
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(authConfig.AuthTokenName, issue(offline, &BreakGlassGrant{Subject: "admin", Reason: "INC-42"}))
+	if _, jwtErr := authConfig.handleRequest(r); jwtErr == nil {
+		t.Errorf("handleRequest() accepted a break-glass token without an audit logger")
+	}
+}
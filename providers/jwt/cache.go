@@ -0,0 +1,155 @@
+package jwt
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultValidationCacheSize = 10000
+	DefaultValidationCacheTTL  = time.Minute
+)
+
+type (
+	// ValidationCache keeps the claims of the verified tokens, keyed by the hash of the token, to skip the decoding
+	// and the signature verification of the tokens seen recently. Entries never outlive the expiry of their token
+	// and the least recently used one is evicted once the cache is full. The Revoker is consulted on every request
+	// regardless, the tokens revoked through the authenticator are evicted as well
+	ValidationCache struct {
+		mutex   sync.Mutex
+		size    int
+		ttl     time.Duration
+		entries map[string]*list.Element
+		lru     *list.List
+	}
+
+	validationEntry struct {
+		key       string
+		claims    jwt.MapClaims
+		payload   []byte
+		tenant    *Tenant
+		expiresAt time.Time
+	}
+)
+
+// NewValidationCache creates a cache of at most size tokens kept for at most ttl, the defaults are used for zero values
+func NewValidationCache(size int, ttl time.Duration) *ValidationCache {
+	if size <= 0 {
+		size = DefaultValidationCacheSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultValidationCacheTTL
+	}
+	return &ValidationCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Invalidate evicts the token
+func (cache *ValidationCache) Invalidate(token string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.entries[tokenHash(token)]; ok {
+		cache.remove(element)
+	}
+}
+
+func (cache *ValidationCache) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.lru.Len()
+}
+
+func (cache *ValidationCache) get(token string, now time.Time) (jwt.MapClaims, []byte, *Tenant, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.entries[tokenHash(token)]
+	if !ok {
+		return nil, nil, nil, false
+	}
+	entry := element.Value.(*validationEntry)
+	if !now.Before(entry.expiresAt) {
+		cache.remove(element)
+		return nil, nil, nil, false
+	}
+	cache.lru.MoveToFront(element)
+	// the identity built from the claims may be altered by the ClaimsMapper, hand out a copy
+	claims := make(jwt.MapClaims, len(entry.claims))
+	for k, v := range entry.claims {
+		claims[k] = v
+	}
+	return claims, entry.payload, entry.tenant, true
+}
+
+func (cache *ValidationCache) put(token string, claims jwt.MapClaims, payload []byte, tenant *Tenant, now time.Time) {
+	expiresAt := now.Add(cache.ttl)
+	if exp, ok := timeClaim(claims, "ExpiredAt", "exp"); ok && exp.Before(expiresAt) {
+		expiresAt = exp
+	}
+	if !now.Before(expiresAt) {
+		return
+	}
+	entry := &validationEntry{key: tokenHash(token), payload: payload, tenant: tenant, expiresAt: expiresAt}
+	entry.claims = make(jwt.MapClaims, len(claims))
+	for k, v := range claims {
+		entry.claims[k] = v
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.entries[entry.key]; ok {
+		cache.remove(element)
+	}
+	cache.entries[entry.key] = cache.lru.PushFront(entry)
+	for cache.lru.Len() > cache.size {
+		cache.remove(cache.lru.Back())
+	}
+}
+
+func (cache *ValidationCache) remove(element *list.Element) {
+	cache.lru.Remove(element)
+	delete(cache.entries, element.Value.(*validationEntry).key)
+}
+
+// verifyCachedCredentials is verifyCredentials going through the ValidationCache when configured
+func (authConfig *JwtAuthConfig) verifyCachedCredentials(r *http.Request, c *Credentials) (*Tenant, error) {
+	cache := authConfig.ValidationCache
+	if cache == nil || c.AuthToken == "" {
+		return authConfig.verifyCredentials(r, c)
+	}
+	token := c.AuthToken
+	now := authConfig.now()
+	if claims, payload, tenant, ok := cache.get(token, now); ok && authConfig.cachedTenantValid(r, tenant) {
+		c.Claims, c.payload = claims, payload
+		return tenant, nil
+	}
+	tenant, err := authConfig.verifyCredentials(r, c)
+	if err == nil {
+		cache.put(token, c.Claims, c.payload, tenant, now)
+	}
+	return tenant, err
+}
+
+// cachedTenantValid checks the tenant of a cached token is still registered and matches the tenant header
+func (authConfig *JwtAuthConfig) cachedTenantValid(r *http.Request, tenant *Tenant) bool {
+	if tenant == nil || authConfig.Tenants == nil {
+		return tenant == nil && authConfig.Tenants == nil
+	}
+	if id := authConfig.tenantID(r); id != "" && id != tenant.ID {
+		return false
+	}
+	registered, ok := authConfig.Tenants.Tenant(tenant.ID)
+	return ok && registered == tenant
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
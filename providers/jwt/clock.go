@@ -0,0 +1,32 @@
+package jwt
+
+import "time"
+
+type (
+	// Clock is the source of the current time of the authenticator
+	Clock interface {
+		Now() time.Time
+	}
+
+	// ClockFunc adapts a function to the Clock interface
+	ClockFunc func() time.Time
+)
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// FixedClock always returns t, for deterministic tests
+func FixedClock(t time.Time) Clock {
+	return ClockFunc(func() time.Time {
+		return t
+	})
+}
+
+// now reads the Clock of the config, the system time when unset
+func (authConfig *JwtAuthConfig) now() time.Time {
+	if authConfig.Clock == nil {
+		return time.Now()
+	}
+	return authConfig.Clock.Now()
+}
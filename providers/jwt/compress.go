@@ -0,0 +1,127 @@
+package jwt
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// ClaimCompression marks the tokens whose claims are compressed, its value is the algorithm
+	ClaimCompression = "zip"
+	// ClaimCompressed carries the base64url encoded compressed json of the claims
+	ClaimCompressed = "zclaims"
+	// CompressionDeflate is the raw DEFLATE of RFC 1951, as in the zip header of RFC 7516
+	CompressionDeflate = "DEF"
+	// DefaultCompressionThreshold is a claims size in bytes past which the compression is worth it
+	DefaultCompressionThreshold = 1024
+	// maxInflatedClaims bounds the decompressed claims so that a small token cannot expand without limit
+	maxInflatedClaims = 1 << 20
+)
+
+// uncompressedClaims stay readable in the compressed tokens, the Payload and the registered claims are looked up
+// before the claims are inflated, e.g. to select the key or the tenant
+var uncompressedClaims = map[string]bool{
+	"ID": true, "Username": true, "IssuedAt": true, "ExpiredAt": true, "ver": true,
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+}
+
+// compressClaims replaces the claims other than the uncompressedClaims with their DEFLATE compression when the json
+// of the claims exceeds the CompressionThreshold
+func (authConfig *JwtAuthConfig) compressClaims(claims jwt.Claims) (jwt.Claims, error) {
+	if authConfig.CompressionThreshold <= 0 {
+		return claims, nil
+	}
+	raw, err := json.Marshal(claims)
+	if err != nil || len(raw) <= authConfig.CompressionThreshold {
+		return claims, err
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(raw, &all); err != nil {
+		return nil, err
+	}
+	compressed := jwt.MapClaims{}
+	rest := map[string]interface{}{}
+	for name, value := range all {
+		if uncompressedClaims[name] {
+			compressed[name] = value
+		} else {
+			rest[name] = value
+		}
+	}
+	if len(rest) == 0 {
+		return claims, nil
+	}
+	if raw, err = json.Marshal(rest); err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	writer, _ := flate.NewWriter(&buffer, flate.BestCompression)
+	if _, err := writer.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	compressed[ClaimCompression] = CompressionDeflate
+	compressed[ClaimCompressed] = base64.RawURLEncoding.EncodeToString(buffer.Bytes())
+	return compressed, nil
+}
+
+// inflateClaims restores the compressed claims in place, the claims of uncompressed tokens are left as they are
+func inflateClaims(claims map[string]interface{}) error {
+	algorithm, ok := claims[ClaimCompression]
+	if !ok {
+		return nil
+	}
+	if algorithm != CompressionDeflate {
+		return turboError.Wrap(turboError.ErrTokenMalformed, errors.New("unsupported claims compression"))
+	}
+	encoded, _ := claims[ClaimCompressed].(string)
+	compressed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	reader := flate.NewReader(bytes.NewReader(compressed))
+	defer reader.Close()
+	raw, err := ioutil.ReadAll(io.LimitReader(reader, maxInflatedClaims+1))
+	if err != nil {
+		return turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	if len(raw) > maxInflatedClaims {
+		return turboError.Wrap(turboError.ErrTokenMalformed, errors.New("compressed claims are too large"))
+	}
+	var rest map[string]interface{}
+	if err := json.Unmarshal(raw, &rest); err != nil {
+		return turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	delete(claims, ClaimCompression)
+	delete(claims, ClaimCompressed)
+	for name, value := range rest {
+		if _, ok := claims[name]; !ok {
+			claims[name] = value
+		}
+	}
+	return nil
+}
+
+// inflateRawClaims is inflateClaims for the json payload of a token
+func inflateRawClaims(raw []byte) ([]byte, error) {
+	if !bytes.Contains(raw, []byte(`"`+ClaimCompressed+`"`)) {
+		return raw, nil
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	if err := inflateClaims(claims); err != nil {
+		return nil, err
+	}
+	return json.Marshal(claims)
+}
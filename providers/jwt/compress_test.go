@@ -0,0 +1,88 @@
+package jwt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestJwtAuthConfig_ClaimsCompression(t *testing.T) {
+	var roles []string
+	for i := 0; i < 200; i++ {
+		roles = append(roles, fmt.Sprintf("orders:%d:read", i))
+	}
+	tests := []struct {
+		name           string
+		threshold      int
+		roles          []string
+		wantCompressed bool
+	}{
+		{name: "Test_disabled", roles: roles},
+		{name: "Test_under_threshold", threshold: DefaultCompressionThreshold, roles: []string{"admin"}},
+		{name: "Test_compressed", threshold: DefaultCompressionThreshold, roles: roles, wantCompressed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+				SigningKey:    "test_key",
+				SigningMethod: "HS256",
+				BearerTokens:  true,
+			}, WithClaimsCompression(tt.threshold))
+			pair, jwtErr := authConfig.IssueTokenPair("test_user", tt.roles)
+			if jwtErr != nil {
+				t.Fatalf("IssueTokenPair() error = %v", jwtErr)
+			}
+			c := &Credentials{AuthToken: pair.AuthToken}
+			if err := c.validateToken(authConfig.keyFunc, authConfig.now(), 0); err != nil {
+				t.Fatalf("validateToken() error = %v", err)
+			}
+			raw, err := inflateRawClaims(c.payload)
+			if err != nil {
+				t.Fatalf("inflateRawClaims() error = %v", err)
+			}
+			if _, compressed := c.Claims[ClaimCompressed]; compressed != tt.wantCompressed {
+				t.Errorf("compressed = %v, want %v", compressed, tt.wantCompressed)
+			}
+			if c.Claims["Username"] != "test_user" {
+				t.Errorf("Username = %v, the Payload must stay uncompressed", c.Claims["Username"])
+			}
+
+			identity, err := authConfig.Authenticate(pair.AuthToken)
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if len(identity.Roles) != len(tt.roles) || identity.Roles[len(tt.roles)-1] != tt.roles[len(tt.roles)-1] {
+				t.Errorf("Roles = %v, want %d roles", identity.Roles, len(tt.roles))
+			}
+			if _, ok := identity.Claims[ClaimCompressed]; ok {
+				t.Errorf("Claims = %v, want the inflated claims", identity.Claims)
+			}
+			if !strings.Contains(string(raw), tt.roles[0]) {
+				t.Errorf("inflateRawClaims() = %s, want the inflated claims", raw)
+			}
+		})
+	}
+}
+
+func TestInflateClaims(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "Test_uncompressed", claims: map[string]interface{}{"sub": "test_user"}},
+		{name: "Test_unknown_algorithm", claims: map[string]interface{}{ClaimCompression: "GZIP", ClaimCompressed: ""},
+			wantErr: true},
+		{name: "Test_invalid_encoding", claims: map[string]interface{}{ClaimCompression: CompressionDeflate,
+			ClaimCompressed: "!!"}, wantErr: true},
+		{name: "Test_invalid_deflate", claims: map[string]interface{}{ClaimCompression: CompressionDeflate,
+			ClaimCompressed: "AAAA"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := inflateClaims(tt.claims); (err != nil) != tt.wantErr {
+				t.Errorf("inflateClaims() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package jwt
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestJwtAuthenticator_concurrent is meant to be run with -race
+func TestJwtAuthenticator_concurrent(t *testing.T) {
+	keyRing := &KeyRing{}
+	manager := NewKeyManager(keyRing, NewHMACKeySource("HS256", 32), time.Hour)
+	if err := manager.RotateNow(); err != nil {
+		t.Fatalf("RotateNow() error = %v", err)
+	}
+	authenticator, err := NewJwtAuthenticator(
+		WithKeyStore(keyRing),
+		WithRevoker(NewMemoryRevoker()),
+		WithValidationCache(16, time.Minute),
+		WithSlidingWindow(time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("NewJwtAuthenticator() error = %v", err)
+	}
+	handler := authenticator.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				token, jwtErr := authenticator.IssueNewToken("test_user", time.Minute)
+				if jwtErr != nil {
+					errs <- jwtErr
+					return
+				}
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set(authenticator.Config().AuthTokenName, token)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				if w.Code != http.StatusOK {
+					errs <- fmt.Errorf("status = %v, want %v", w.Code, http.StatusOK)
+					return
+				}
+				if _, err := authenticator.Authenticate(token); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5; i++ {
+			if err := manager.RotateNow(); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -1,46 +1,174 @@
 package jwt
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
+	turboError "github.com/nandlabs/turbo-auth/errors"
 	"time"
 )
 
 // currently working only for HMAC algo
 func (creds *Credentials) ValidateToken(signKey string) error {
+	return creds.validateToken(hmacKeyFunc(signKey), time.Now(), 0)
+}
+
+// validateToken verifies the signature and checks the time claims against now, tolerating leeway of clock skew
+func (creds *Credentials) validateToken(keyFunc jwt.Keyfunc, now time.Time, leeway time.Duration) error {
 	if creds.AuthToken == "" {
-		return errors.New("empty auth token")
+		return turboError.ErrMissingToken
 	}
-	token, err := jwt.Parse(creds.AuthToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(signKey), nil
-	})
+	token, err := jwt.ParseWithClaims(creds.AuthToken, &payloadClaims{}, keyFunc, jwt.WithoutClaimsValidation())
 	if err != nil {
-		return err
+		return classifyError(err)
 	}
 	if token.Valid {
-		fmt.Println("token validated")
+		logger.DebugF("token validated")
+		if claims, ok := token.Claims.(*payloadClaims); ok {
+			if err := validateTimes(claims.MapClaims, now, leeway); err != nil {
+				return err
+			}
+			creds.Claims = claims.MapClaims
+			creds.payload = claims.raw
+		}
 	} else {
-		return errors.New("invalid token passed")
+		return turboError.ErrTokenInvalid
+	}
+	return nil
+}
+
+// payloadClaims keeps the json the claims are decoded from so that the typed claims are decoded from it rather than
+// by parsing the token again
+type payloadClaims struct {
+	jwt.MapClaims
+	raw []byte
+}
+
+func (c *payloadClaims) UnmarshalJSON(data []byte) error {
+	c.raw = append([]byte(nil), data...)
+	return json.Unmarshal(data, &c.MapClaims)
+}
+
+// tokenClaims returns the claims of the parsed token, before their verification when called by a key func
+func tokenClaims(token *jwt.Token) jwt.MapClaims {
+	switch claims := token.Claims.(type) {
+	case jwt.MapClaims:
+		return claims
+	case *payloadClaims:
+		return claims.MapClaims
+	default:
+		return nil
+	}
+}
+
+// validateTimes checks the expiry, not before and issued at claims, both the Payload and the registered claim
+// names are understood. A time claim present but not a date is rejected as malformed rather than ignored
+func validateTimes(claims jwt.MapClaims, now time.Time, leeway time.Duration) error {
+	expiresAt, ok, err := parseTimeClaim(claims, "ExpiredAt", "exp")
+	if err != nil {
+		return err
+	}
+	if ok && now.After(expiresAt.Add(leeway)) {
+		return classifyError(jwt.ErrTokenExpired)
+	}
+	notBefore, ok, err := parseTimeClaim(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(leeway).Before(notBefore) {
+		return classifyError(jwt.ErrTokenNotValidYet)
+	}
+	issuedAt, ok, err := parseTimeClaim(claims, "IssuedAt", "iat")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(leeway).Before(issuedAt) {
+		return classifyError(jwt.ErrTokenUsedBeforeIssued)
 	}
 	return nil
 }
 
+// timeClaim reads the first of the names present, RFC 3339 strings and numeric dates are supported
+func timeClaim(claims map[string]interface{}, names ...string) (time.Time, bool) {
+	t, ok, err := parseTimeClaim(claims, names...)
+	return t, ok && err == nil
+}
+
+// parseTimeClaim reads the first of the names present and returns an ErrTokenMalformed error when its value is not
+// a date
+func parseTimeClaim(claims map[string]interface{}, names ...string) (time.Time, bool, error) {
+	for _, name := range names {
+		value, ok := claims[name]
+		if !ok || value == nil {
+			continue
+		}
+		switch value := value.(type) {
+		case string:
+			if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				return t, true, nil
+			}
+		case float64:
+			return time.Unix(0, int64(value*float64(time.Second))), true, nil
+		case json.Number:
+			if seconds, err := value.Float64(); err == nil {
+				return time.Unix(0, int64(seconds*float64(time.Second))), true, nil
+			}
+		}
+		return time.Time{}, false, turboError.Wrap(turboError.ErrTokenMalformed, fmt.Errorf("malformed %s claim", name))
+	}
+	return time.Time{}, false, nil
+}
+
+// classifyError tags the errors of the jwt library with the sentinel errors of the turbo-auth errors package
+func classifyError(err error) error {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return turboError.Wrap(turboError.ErrTokenMalformed, err)
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return turboError.Wrap(turboError.ErrSignatureInvalid, err)
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		return turboError.Wrap(turboError.ErrTokenUnverifiable, err)
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return turboError.Wrap(turboError.ErrTokenExpired, err)
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return turboError.Wrap(turboError.ErrTokenNotValidYet, err)
+	default:
+		return turboError.Wrap(turboError.ErrTokenInvalid, err)
+	}
+}
+
+func hmacKeyFunc(signKey string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(signKey), nil
+	}
+}
+
 func (creds *Credentials) BuildTokenWithClaims(token string, verifyKey interface{}, validTime time.Duration) *jwtToken {
 	return nil
 }
 
 func BuildTokenWithClaims(signingMethod string, payload *Payload) (*jwt.Token, error) {
-	var jwtToken *jwt.Token
-	if signingMethod == "HS256" {
-		jwtToken = jwt.NewWithClaims(jwt.SigningMethodHS256, payload)
-	} else if signingMethod == "RS256" {
-		jwtToken = jwt.NewWithClaims(jwt.SigningMethodRS256, payload)
-	} else {
+	return buildToken(signingMethod, payload)
+}
+
+func buildToken(signingMethod string, claims jwt.Claims) (*jwt.Token, error) {
+	method, err := getSigningMethod(signingMethod)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.NewWithClaims(method, claims), nil
+}
+
+// getSigningMethod restricts the algorithms to the HMAC, RSA and ECDSA families, none is never allowed
+func getSigningMethod(signingMethod string) (jwt.SigningMethod, error) {
+	switch signingMethod {
+	case "HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "ES256", "ES384", "ES512":
+		return jwt.GetSigningMethod(signingMethod), nil
+	default:
 		return nil, errors.New("singing method not supported")
 	}
-	return jwtToken, nil
 }
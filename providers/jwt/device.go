@@ -0,0 +1,158 @@
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/internal/secret"
+	"net/http"
+)
+
+type (
+	// DeviceBinding rejects the tokens presented from another device than the one they were issued to, so that a
+	// token lifted from a cookie cannot be replayed elsewhere. The bound tokens carry the HMAC of the opaque device
+	// id of the client (did claim) and the fingerprint of the device (dfp claim), the HMAC of the user agent and of
+	// the device id, the client presents the device id in the Header or the Cookie on every request
+	DeviceBinding struct {
+		// Key is the HMAC key of the claims, the device id itself never appears in the tokens
+		Key    []byte
+		Header string
+		Cookie string
+		// Required rejects the tokens which are not bound to a device
+		Required bool
+		// Grace tolerates some of the mismatches, e.g. AllowUserAgentChange, every mismatch is rejected when nil
+		Grace DeviceGracePolicy
+	}
+
+	// DeviceGracePolicy returns true to accept the token despite the mismatch err, one of ErrDeviceMismatch and
+	// ErrUserAgentMismatch
+	DeviceGracePolicy func(r *http.Request, identity *turboAuth.Identity, err error) bool
+)
+
+const (
+	DefaultDeviceIDHeader = "X-Device-ID"
+	DefaultDeviceIDCookie = "Device-ID"
+	ClaimDeviceID         = "did"
+	ClaimDevicePrint      = "dfp"
+)
+
+var (
+	ErrDeviceBindingKey = errors.New("device binding requires a key")
+	// ErrDeviceMismatch is returned when the device id of the request is not the one of the token
+	ErrDeviceMismatch = errors.New("token is bound to another device")
+	// ErrUserAgentMismatch is returned when the device id matches but the user agent changed, e.g. a browser update
+	ErrUserAgentMismatch = errors.New("token is bound to another user agent")
+)
+
+func NewDeviceBinding(key []byte) *DeviceBinding {
+	return &DeviceBinding{
+		Key:    key,
+		Header: DefaultDeviceIDHeader,
+		Cookie: DefaultDeviceIDCookie,
+	}
+}
+
+// NewDeviceID returns a random opaque device id, to be stored by the client, e.g. in a long lived cookie
+func NewDeviceID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// AllowUserAgentChange tolerates the refresh tokens presented with their device id from a changed user agent, the
+// browsers change it on every update. The auth tokens are still rejected, the refreshed pair is bound to the new
+// user agent
+func AllowUserAgentChange(r *http.Request, identity *turboAuth.Identity, err error) bool {
+	return errors.Is(err, ErrUserAgentMismatch) && tokenUseOf(identity.Claims) == TokenUseRefresh
+}
+
+// DeviceHash is the value of the did claim for the device id
+func (d *DeviceBinding) DeviceHash(deviceID string) string {
+	return d.mac(deviceID)
+}
+
+// Fingerprint is the value of the dfp claim for the user agent and the device id
+func (d *DeviceBinding) Fingerprint(userAgent string, deviceID string) string {
+	return d.mac(userAgent + "\x00" + deviceID)
+}
+
+func (d *DeviceBinding) mac(value string) string {
+	mac := hmac.New(sha256.New, d.Key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DeviceID returns the device id presented by the request, in the Header or else in the Cookie
+func (d *DeviceBinding) DeviceID(r *http.Request) string {
+	if d.Header != "" {
+		if id := r.Header.Get(d.Header); id != "" {
+			return id
+		}
+	}
+	if d.Cookie != "" {
+		if cookie, err := r.Cookie(d.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// Bind returns a copy of the authentication bound to the device of the request, to be passed to
+// IssueAuthenticatedTokenPair
+func (d *DeviceBinding) Bind(r *http.Request, authentication *Authentication) *Authentication {
+	bound := &Authentication{}
+	if authentication != nil {
+		*bound = *authentication
+	}
+	bound.DeviceID, bound.UserAgent = d.DeviceID(r), r.UserAgent()
+	return bound
+}
+
+// validate checks the request presents the device of the bound tokens
+func (d *DeviceBinding) validate(r *http.Request, identity *turboAuth.Identity) error {
+	deviceID, _ := identity.Claims[ClaimDeviceID].(string)
+	fingerprint, _ := identity.Claims[ClaimDevicePrint].(string)
+	if deviceID == "" {
+		if d.Required {
+			return deviceError(ErrDeviceMismatch, "token is not bound to a device")
+		}
+		return nil
+	}
+	if len(d.Key) == 0 {
+		return deviceError(ErrDeviceBindingKey, "")
+	}
+	var err error
+	switch presented := d.DeviceID(r); {
+	case presented == "" || !secret.Equal(d.DeviceHash(presented), deviceID):
+		err = ErrDeviceMismatch
+	case !secret.Equal(d.Fingerprint(r.UserAgent(), presented), fingerprint):
+		err = ErrUserAgentMismatch
+	}
+	if err == nil || d.Grace != nil && d.Grace(r, identity, err) {
+		return nil
+	}
+	return deviceError(err, "")
+}
+
+// device returns the binding of the identity carried over as it is, nil when its token is not bound
+func device(identity *turboAuth.Identity) *Authentication {
+	deviceID, _ := identity.Claims[ClaimDeviceID].(string)
+	if deviceID == "" {
+		return nil
+	}
+	fingerprint, _ := identity.Claims[ClaimDevicePrint].(string)
+	return &Authentication{DeviceID: deviceID, fingerprint: fingerprint}
+}
+
+func deviceError(err error, reason string) error {
+	if reason != "" {
+		err = turboError.Wrap(err, errors.New(reason))
+	}
+	return turboError.Wrap(turboError.ErrTokenInvalid, err)
+}
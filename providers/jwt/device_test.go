@@ -0,0 +1,109 @@
+package jwt
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJwtAuthConfig_DeviceBinding(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		DeviceBinding: NewDeviceBinding([]byte("device_key")),
+		RefreshTokens: NewMemoryRefreshTokenStore(),
+	})
+	deviceID, err := NewDeviceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := httptest.NewRequest(http.MethodPost, "/login", nil)
+	login.Header.Set("User-Agent", "browser/1.0")
+	login.Header.Set(DefaultDeviceIDHeader, deviceID)
+	pair, jwtErr := authConfig.IssueAuthenticatedTokenPair("test_user", nil, authConfig.DeviceBinding.Bind(login, nil))
+	if jwtErr != nil {
+		t.Fatalf("IssueAuthenticatedTokenPair() error = %v", jwtErr)
+	}
+	unbound, jwtErr := authConfig.IssueNewToken("test_user", authConfig.AuthTokenValidTime)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		deviceID  string
+		userAgent string
+		required  bool
+		grace     DeviceGracePolicy
+		wantErr   error
+	}{
+		{name: "Test_same_device", token: pair.AuthToken, deviceID: deviceID, userAgent: "browser/1.0"},
+		{name: "Test_refresh_token", token: pair.RefreshToken, deviceID: deviceID, userAgent: "browser/1.0", wantErr: ErrTokenUse},
+		{name: "Test_other_device", token: pair.AuthToken, deviceID: "other", userAgent: "browser/1.0", wantErr: ErrDeviceMismatch},
+		{name: "Test_missing_device", token: pair.AuthToken, userAgent: "browser/1.0", wantErr: ErrDeviceMismatch},
+		{name: "Test_user_agent_changed", token: pair.AuthToken, deviceID: deviceID, userAgent: "browser/2.0", wantErr: ErrUserAgentMismatch},
+		{name: "Test_user_agent_grace_auth_token", token: pair.AuthToken, deviceID: deviceID, userAgent: "browser/2.0", grace: AllowUserAgentChange, wantErr: ErrUserAgentMismatch},
+		{name: "Test_device_grace_refused", token: pair.AuthToken, deviceID: "other", userAgent: "browser/1.0", grace: AllowUserAgentChange, wantErr: ErrDeviceMismatch},
+		{name: "Test_unbound_token", token: unbound},
+		{name: "Test_unbound_token_required", token: unbound, required: true, wantErr: ErrDeviceMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authConfig.DeviceBinding.Required, authConfig.DeviceBinding.Grace = tt.required, tt.grace
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(authConfig.AuthTokenName, tt.token)
+			r.Header.Set("User-Agent", tt.userAgent)
+			if tt.deviceID != "" {
+				r.AddCookie(&http.Cookie{Name: DefaultDeviceIDCookie, Value: tt.deviceID})
+			}
+			err := authConfig.HandleRequest(httptest.NewRecorder(), r)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("HandleRequest() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !errors.Is(err, tt.wantErr) {
+				t.Errorf("HandleRequest() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_DeviceBinding_refresh(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		DeviceBinding: &DeviceBinding{Key: []byte("device_key"), Header: DefaultDeviceIDHeader, Grace: AllowUserAgentChange},
+		RefreshTokens: NewMemoryRefreshTokenStore(),
+	})
+	deviceID, _ := NewDeviceID()
+	request := func(userAgent string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("User-Agent", userAgent)
+		r.Header.Set(DefaultDeviceIDHeader, deviceID)
+		return r
+	}
+	pair, jwtErr := authConfig.IssueAuthenticatedTokenPair("test_user", nil, authConfig.DeviceBinding.Bind(request("browser/1.0"), nil))
+	if jwtErr != nil {
+		t.Fatalf("IssueAuthenticatedTokenPair() error = %v", jwtErr)
+	}
+	identity, err := authConfig.AuthenticateRequest(request("browser/1.0"), pair.AuthToken)
+	if err != nil {
+		t.Fatalf("AuthenticateRequest() error = %v", err)
+	}
+	if did := identity.Claims[ClaimDeviceID]; did == deviceID || did != authConfig.DeviceBinding.DeviceHash(deviceID) {
+		t.Errorf("did claim = %v, want the hash of the device id", did)
+	}
+	refreshed, jwtErr := authConfig.Refresh(request("browser/2.0"), pair.RefreshToken)
+	if jwtErr != nil {
+		t.Fatalf("Refresh() from the updated browser error = %v", jwtErr)
+	}
+	if _, err := authConfig.AuthenticateRequest(request("browser/2.0"), refreshed.AuthToken); err != nil {
+		t.Errorf("AuthenticateRequest() of the refreshed token error = %v, want it bound to the new user agent", err)
+	}
+}
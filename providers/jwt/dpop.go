@@ -0,0 +1,213 @@
+package jwt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/internal/secret"
+	"github.com/nandlabs/turbo-auth/nonce"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type (
+	// DPoP validates the proofs of possession of the sender constrained tokens (RFC 9449), a token carrying a
+	// cnf.jkt claim is only accepted along with a proof signed by the key of that thumbprint
+	DPoP struct {
+		// Required rejects the tokens which are not sender constrained
+		Required bool
+		// MaxAge bounds the age of the proofs, DefaultDPoPMaxAge when 0
+		MaxAge time.Duration
+		// ReplayCache rejects the proofs whose jti was already seen, replays are not detected when nil
+		ReplayCache ReplayCache
+		// ProtoHeader reads the scheme the client called from the header set by the proxy terminating the TLS, e.g.
+		// X-Forwarded-Proto, to check the htu claim. The header is trusted, it must be overwritten by the proxy. The
+		// scheme of the connection is used when empty
+		ProtoHeader string
+	}
+
+	// ReplayCache remembers the jti of the proofs until they expire, e.g. a nonce.RedisStore shared across instances
+	ReplayCache = nonce.Store
+
+	// MemoryReplayCache is an in-memory ReplayCache suitable for single instance deployments
+	MemoryReplayCache = nonce.MemoryStore
+)
+
+const (
+	HeaderDPoP           = "DPoP"
+	HeaderForwardedProto = "X-Forwarded-Proto"
+	DefaultDPoPMaxAge    = time.Minute
+	dpopType             = "dpop+jwt"
+)
+
+var (
+	ErrInvalidDPoPProof = errors.New("invalid DPoP proof")
+
+	dpopMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+)
+
+func NewDPoP() *DPoP {
+	return &DPoP{
+		MaxAge:      DefaultDPoPMaxAge,
+		ReplayCache: NewMemoryReplayCache(),
+	}
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return nonce.NewMemoryStore()
+}
+
+// Thumbprint is the RFC 7638 SHA-256 thumbprint of the key, the value of the cnf.jkt claim
+func (jwk JWK) Thumbprint() (string, error) {
+	var members string
+	switch jwk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Crv, jwk.X, jwk.Y)
+	default:
+		return "", errors.New("unsupported key type: " + jwk.Kty)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// VerifyProof validates the DPoP proof of the request and returns the thumbprint of its key, the ath claim is
+// checked when accessToken is not empty
+func (d *DPoP) VerifyProof(r *http.Request, accessToken string, now time.Time) (string, error) {
+	proofs := r.Header.Values(HeaderDPoP)
+	if len(proofs) != 1 {
+		return "", dpopError("exactly one proof is required")
+	}
+	var jwk JWK
+	token, err := jwt.Parse(proofs[0], func(token *jwt.Token) (interface{}, error) {
+		if token.Header["typ"] != dpopType {
+			return nil, errors.New("unexpected typ")
+		}
+		header, err := json.Marshal(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(header, &jwk); err != nil {
+			return nil, err
+		}
+		return jwk.PublicKey()
+	}, jwt.WithValidMethods(dpopMethods), jwt.WithoutClaimsValidation())
+	if err != nil {
+		return "", dpopError(err.Error())
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", dpopError("unexpected claims")
+	}
+
+	if htm, _ := claims["htm"].(string); htm != r.Method {
+		return "", dpopError("htm does not match the request method")
+	}
+	if htu, _ := claims["htu"].(string); !sameURI(htu, d.requestURI(r)) {
+		return "", dpopError("htu does not match the request uri")
+	}
+	maxAge := d.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultDPoPMaxAge
+	}
+	issuedAt, ok := timeClaim(claims, "iat")
+	if !ok || now.Sub(issuedAt) > maxAge || issuedAt.Sub(now) > maxAge {
+		return "", dpopError("iat is outside of the accepted window")
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if ath, _ := claims["ath"].(string); !secret.Equal(ath, base64.RawURLEncoding.EncodeToString(sum[:])) {
+			return "", dpopError("ath does not match the access token")
+		}
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return "", dpopError("jti is required")
+	}
+	if d.ReplayCache != nil {
+		replayed, err := d.ReplayCache.Seen(jti, issuedAt.Add(2*maxAge))
+		if err != nil {
+			return "", err
+		}
+		if replayed {
+			return "", dpopError("proof has already been used")
+		}
+	}
+	return jwk.Thumbprint()
+}
+
+// validate checks the proof of the sender constrained tokens
+func (d *DPoP) validate(r *http.Request, accessToken string, claims map[string]interface{}, now time.Time) error {
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	jkt, _ := cnf["jkt"].(string)
+	if jkt == "" {
+		if d.Required {
+			return dpopError("token is not sender constrained")
+		}
+		return nil
+	}
+	thumbprint, err := d.VerifyProof(r, accessToken, now)
+	if err != nil {
+		return err
+	}
+	if !secret.Equal(thumbprint, jkt) {
+		return dpopError("proof key does not match the token confirmation")
+	}
+	return nil
+}
+
+// IssueDPoPBoundToken issues an auth token bound to the key of the jkt thumbprint, see DPoP.VerifyProof
+func (authConfig *JwtAuthConfig) IssueDPoPBoundToken(username string, duration time.Duration, jkt string) (string, *turboError.JwtError) {
+	payload, err := newPayload(username, duration, authConfig.now())
+	if err != nil {
+		return "", turboError.NewJwtError(err, 406)
+	}
+	token, jwtErr := authConfig.signPayload(context.Background(), &extendedClaims{
+		Payload: *payload,
+		Cnf:     map[string]string{"jkt": jkt},
+	})
+	identity := &turboAuth.Identity{Subject: username, TokenID: payload.ID.String()}
+	authConfig.audit(nil, audit.EventTokenIssued, identity, jwtErrOrNil(jwtErr))
+	return token, jwtErr
+}
+
+func dpopError(reason string) error {
+	return turboError.Wrap(turboError.ErrTokenInvalid, fmt.Errorf("%w: %s", ErrInvalidDPoPProof, reason))
+}
+
+// requestURI rebuilds the uri the client called, without the query and fragment
+func (d *DPoP) requestURI(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if d.ProtoHeader != "" {
+		if proto := strings.TrimSpace(strings.Split(r.Header.Get(d.ProtoHeader), ",")[0]); proto != "" {
+			scheme = strings.ToLower(proto)
+		}
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+func sameURI(htu, uri string) bool {
+	parsed, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	expected, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(parsed.Scheme, expected.Scheme) && strings.EqualFold(parsed.Host, expected.Host) &&
+		parsed.Path == expected.Path
+}
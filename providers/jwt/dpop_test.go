@@ -0,0 +1,99 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newDPoPProof(t *testing.T, key *ecdsa.PrivateKey, method, uri, accessToken, jti string) string {
+	jwk, err := NewJWK(&Key{SigningMethod: "ES256", VerifyKey: &key.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(accessToken))
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"htm": method,
+		"htu": uri,
+		"iat": time.Now().Unix(),
+		"jti": jti,
+		"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+	})
+	token.Header["typ"] = dpopType
+	token.Header["jwk"] = jwk
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func TestJwtAuthConfig_DPoP(t *testing.T) {
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := NewJWK(&Key{SigningMethod: "ES256", VerifyKey: &clientKey.PublicKey})
+	jkt, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		DPoP:          NewDPoP(),
+	})
+	token, jwtErr := authConfig.IssueDPoPBoundToken("test_user", time.Minute, jkt)
+	if jwtErr != nil {
+		t.Fatalf("IssueDPoPBoundToken() error = %v", jwtErr)
+	}
+	const uri = "http://example.com/orders"
+
+	tests := []struct {
+		name  string
+		proof string
+		valid bool
+	}{
+		{name: "Test_valid_proof", proof: newDPoPProof(t, clientKey, http.MethodGet, uri, token, "jti-1"), valid: true},
+		{name: "Test_replayed_proof", proof: newDPoPProof(t, clientKey, http.MethodGet, uri, token, "jti-1"), valid: false},
+		{name: "Test_missing_proof", proof: "", valid: false},
+		{name: "Test_wrong_method", proof: newDPoPProof(t, clientKey, http.MethodPost, uri, token, "jti-2"), valid: false},
+		{name: "Test_wrong_uri", proof: newDPoPProof(t, clientKey, http.MethodGet, "http://example.com/other", token, "jti-3"), valid: false},
+		{name: "Test_wrong_access_token", proof: newDPoPProof(t, clientKey, http.MethodGet, uri, "other", "jti-4"), valid: false},
+		{name: "Test_wrong_key", proof: newDPoPProof(t, otherKey, http.MethodGet, uri, token, "jti-5"), valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, uri+"?page=2", nil)
+			r.Header.Set(authConfig.AuthTokenName, token)
+			if tt.proof != "" {
+				r.Header.Set(HeaderDPoP, tt.proof)
+			}
+			err := authConfig.HandleRequest(httptest.NewRecorder(), r)
+			if (err == nil) != tt.valid {
+				t.Fatalf("HandleRequest() error = %v, valid %v", err, tt.valid)
+			}
+			if err != nil && (err.Code != 401 || !errors.Is(err, ErrInvalidDPoPProof)) {
+				t.Errorf("HandleRequest() error = %v (%v), want an invalid proof", err, err.Code)
+			}
+		})
+	}
+
+	bearer, _ := authConfig.IssueNewToken("test_user", time.Minute)
+	r := httptest.NewRequest(http.MethodGet, uri, nil)
+	r.Header.Set(authConfig.AuthTokenName, bearer)
+	if err := authConfig.HandleRequest(httptest.NewRecorder(), r); err != nil {
+		t.Errorf("HandleRequest() bearer token error = %v", err)
+	}
+	authConfig.DPoP.Required = true
+	if err := authConfig.HandleRequest(httptest.NewRecorder(), r); err == nil {
+		t.Errorf("HandleRequest() bearer token accepted while DPoP is required")
+	}
+}
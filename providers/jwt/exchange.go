@@ -0,0 +1,245 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// TokenExchangeRequest asks for a token derived from the subject token (RFC 8693)
+	TokenExchangeRequest struct {
+		SubjectToken string
+		// ActorToken identifies the party acting on behalf of the subject, the act claim records the delegation chain
+		ActorToken string
+		// Audience of the new token, one of the ExchangeAudiences, kept from the subject token when empty
+		Audience string
+		// Scopes must be a subset of the subject token scopes, the subject token scopes are kept when empty
+		Scopes []string
+		// TTL of the new token, capped by the one of the TTLPolicy or AuthTokenValidTime which applies when 0. The
+		// new token never outlives the subject token
+		TTL time.Duration
+	}
+
+	// TokenExchangeResponse is the RFC 8693 token exchange response
+	TokenExchangeResponse struct {
+		AccessToken     string `json:"access_token"`
+		IssuedTokenType string `json:"issued_token_type"`
+		TokenType       string `json:"token_type"`
+		ExpiresIn       int64  `json:"expires_in,omitempty"`
+		Scope           string `json:"scope,omitempty"`
+	}
+
+	// extendedClaims extends the Payload with the claims of the exchanged, sender constrained, step-up, device
+	// bound, body bound and refresh tokens
+	extendedClaims struct {
+		Payload
+		Audience string                 `json:"aud,omitempty"`
+		Scope    string                 `json:"scope,omitempty"`
+		Roles    []string               `json:"Roles,omitempty"`
+		Act      map[string]interface{} `json:"act,omitempty"`
+		Cnf      map[string]string      `json:"cnf,omitempty"`
+		ACR      string                 `json:"acr,omitempty"`
+		AMR      []string               `json:"amr,omitempty"`
+		AuthTime int64                  `json:"auth_time,omitempty"`
+		DeviceID string                 `json:"did,omitempty"`
+		Device   string                 `json:"dfp,omitempty"`
+		Family   string                 `json:"fam,omitempty"`
+		// FamilyExpiry is the end of the refresh token family, see RefreshFamilyLifetime
+		FamilyExpiry int64 `json:"fexp,omitempty"`
+		// BodyDigest binds the token to the body of a request, see BodyDigest
+		BodyDigest string `json:"bsh,omitempty"`
+	}
+)
+
+const (
+	TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+var (
+	// ErrInvalidScope is returned when the requested scopes are not granted to the subject token
+	ErrInvalidScope = errors.New("requested scope exceeds the scope of the subject token")
+	// ErrInvalidTarget is returned when the requested audience is not one of the ExchangeAudiences
+	ErrInvalidTarget = errors.New("requested audience is not allowed")
+)
+
+// ExchangeToken validates the subject (and actor) token and issues a new token for the requested audience and scopes.
+// Only the auth tokens are exchanged, the new token is bound to the key, certificate or device of the subject token
+// and keeps its authentication
+func (authConfig *JwtAuthConfig) ExchangeToken(request *TokenExchangeRequest) (*TokenExchangeResponse, *turboError.JwtError) {
+	// the proofs of the subject token are not presented by the caller, its bindings are carried over instead
+	subject, jwtErr := authConfig.validateCredentials(context.Background(), nil,
+		&Credentials{AuthToken: request.SubjectToken, carriedBindings: true}, TokenUseAccess)
+	if jwtErr != nil {
+		return nil, turboError.NewJwtError(jwtErr.Err, 403)
+	}
+
+	scopes := subject.Scopes
+	if len(request.Scopes) > 0 {
+		for _, scope := range request.Scopes {
+			if !subject.HasScope(scope) {
+				return nil, turboError.NewJwtError(ErrInvalidScope, 403)
+			}
+		}
+		scopes = request.Scopes
+	}
+
+	audience := request.Audience
+	if audience == "" {
+		audience, _ = subject.Claims["aud"].(string)
+	} else if !contains(authConfig.ExchangeAudiences, audience) {
+		return nil, turboError.NewJwtError(ErrInvalidTarget, 403)
+	}
+
+	var act map[string]interface{}
+	if request.ActorToken != "" {
+		actor, err := authConfig.Authenticate(request.ActorToken)
+		if err != nil {
+			return nil, turboError.NewJwtError(err, 403)
+		}
+		act = map[string]interface{}{"sub": actor.Subject}
+		// the previous actors are nested so that the whole delegation chain is kept
+		if previous, ok := subject.Claims["act"].(map[string]interface{}); ok {
+			act["act"] = previous
+		}
+	} else if previous, ok := subject.Claims["act"].(map[string]interface{}); ok {
+		act = previous
+	}
+
+	carried := authentication(subject)
+	ttl := authConfig.tokenTTL(&TTLRequest{Subject: subject.Subject, Roles: subject.Roles, Audience: audience,
+		Authentication: carried}).Auth
+	if request.TTL > 0 && request.TTL < ttl {
+		ttl = request.TTL
+	}
+	now := authConfig.now()
+	if expiresAt, ok := timeClaim(subject.Claims, "ExpiredAt", "exp"); ok && expiresAt.Sub(now) < ttl {
+		ttl = expiresAt.Sub(now)
+	}
+	payload, err := newPayload(subject.Subject, ttl, now)
+	if err != nil {
+		return nil, turboError.NewJwtError(err, 406)
+	}
+	claims := &extendedClaims{
+		Payload:  *payload,
+		Audience: audience,
+		Scope:    strings.Join(scopes, " "),
+		Roles:    subject.Roles,
+		Act:      act,
+		Cnf:      confirmation(subject),
+	}
+	if carried != nil {
+		claims.ACR, claims.AMR = carried.ACR, carried.AMR
+		if !carried.Time.IsZero() {
+			claims.AuthTime = carried.Time.Unix()
+		}
+	}
+	if binding := device(subject); binding != nil {
+		claims.DeviceID, claims.Device = binding.DeviceID, binding.fingerprint
+	}
+	token, jwtErr := authConfig.signPayload(context.Background(), claims)
+	authConfig.audit(nil, audit.EventTokenExchange, subject, jwtErrOrNil(jwtErr))
+	if jwtErr != nil {
+		return nil, jwtErr
+	}
+	return &TokenExchangeResponse{
+		AccessToken:     token,
+		IssuedTokenType: TokenTypeJWT,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(ttl / time.Second),
+		Scope:           strings.Join(scopes, " "),
+	}, nil
+}
+
+// TokenExchangeHandler implements the RFC 8693 token exchange grant of the token endpoint, the callers
+// (e.g. the gateways) must be authenticated by callerAuthenticator
+func (authConfig *JwtAuthConfig) TokenExchangeHandler(callerAuthenticator CallerAuthenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request")
+			return
+		}
+		if callerAuthenticator == nil {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client")
+			return
+		}
+		if _, ok := callerAuthenticator(r); !ok {
+			w.Header().Set(turboError.HeaderWWWAuthenticate, `Basic realm="token"`)
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client")
+			return
+		}
+		if r.PostFormValue("grant_type") != TokenExchangeGrantType {
+			writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type")
+			return
+		}
+		request := &TokenExchangeRequest{
+			SubjectToken: r.PostFormValue("subject_token"),
+			ActorToken:   r.PostFormValue("actor_token"),
+			Audience:     r.PostFormValue("audience"),
+			Scopes:       strings.Fields(r.PostFormValue("scope")),
+		}
+		if request.SubjectToken == "" || !supportedTokenType(r.PostFormValue("subject_token_type")) ||
+			(request.ActorToken != "" && !supportedTokenType(r.PostFormValue("actor_token_type"))) {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		response, jwtErr := authConfig.ExchangeToken(request)
+		if jwtErr != nil {
+			if errors.Is(jwtErr, ErrInvalidScope) {
+				writeOAuthError(w, http.StatusBadRequest, "invalid_scope")
+				return
+			}
+			if errors.Is(jwtErr, ErrInvalidTarget) {
+				writeOAuthError(w, http.StatusBadRequest, "invalid_target")
+				return
+			}
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.ErrorF("unable to write the token exchange response: %v", err)
+		}
+	})
+}
+
+// confirmation returns the cnf claim of the identity, nil when its token is not sender constrained
+func confirmation(identity *turboAuth.Identity) map[string]string {
+	claim, _ := identity.Claims["cnf"].(map[string]interface{})
+	if len(claim) == 0 {
+		return nil
+	}
+	cnf := make(map[string]string, len(claim))
+	for method, value := range claim {
+		cnf[method], _ = value.(string)
+	}
+	return cnf
+}
+
+func supportedTokenType(tokenType string) bool {
+	return tokenType == TokenTypeJWT || tokenType == TokenTypeAccessToken
+}
+
+func writeOAuthError(w http.ResponseWriter, statusCode int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// jwtErrOrNil avoids passing a typed nil *JwtError as an error
+func jwtErrOrNil(jwtErr *turboError.JwtError) error {
+	if jwtErr == nil {
+		return nil
+	}
+	return jwtErr
+}
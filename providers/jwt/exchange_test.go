@@ -0,0 +1,147 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJwtAuthConfig_ExchangeToken(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+	}, WithExchangeAudiences("orders"))
+	payload, err := NewPayload("test_user", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subjectToken, jwtErr := authConfig.signPayload(context.Background(), &extendedClaims{
+		Payload:  *payload,
+		Audience: "gateway",
+		Scope:    "orders:read orders:write",
+	})
+	if jwtErr != nil {
+		t.Fatalf("signPayload() error = %v", jwtErr)
+	}
+	refreshToken, _, jwtErr := authConfig.issue(context.Background(), "test_user", time.Hour, nil, nil, TokenUseRefresh)
+	if jwtErr != nil {
+		t.Fatalf("issue() error = %v", jwtErr)
+	}
+	actorToken, jwtErr := authConfig.IssueNewToken("gateway", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+
+	tests := []struct {
+		name      string
+		request   *TokenExchangeRequest
+		wantErr   error
+		wantScope string
+		wantActor string
+	}{
+		{
+			name:      "Test_reduced_scope",
+			request:   &TokenExchangeRequest{SubjectToken: subjectToken, Audience: "orders", Scopes: []string{"orders:read"}},
+			wantScope: "orders:read",
+		},
+		{
+			name:      "Test_delegation",
+			request:   &TokenExchangeRequest{SubjectToken: subjectToken, ActorToken: actorToken, Audience: "orders"},
+			wantScope: "orders:read orders:write",
+			wantActor: "gateway",
+		},
+		{
+			name:      "Test_ttl_capped",
+			request:   &TokenExchangeRequest{SubjectToken: subjectToken, Audience: "orders", TTL: time.Hour},
+			wantScope: "orders:read orders:write",
+		},
+		{
+			name:    "Test_audience_not_allowed",
+			request: &TokenExchangeRequest{SubjectToken: subjectToken, Audience: "billing"},
+			wantErr: ErrInvalidTarget,
+		},
+		{
+			name:    "Test_refresh_token",
+			request: &TokenExchangeRequest{SubjectToken: refreshToken, Audience: "orders"},
+			wantErr: ErrTokenUse,
+		},
+		{
+			name:    "Test_scope_escalation",
+			request: &TokenExchangeRequest{SubjectToken: subjectToken, Scopes: []string{"orders:admin"}},
+			wantErr: ErrInvalidScope,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, jwtErr := authConfig.ExchangeToken(tt.request)
+			if tt.wantErr != nil {
+				if !errors.Is(jwtErr, tt.wantErr) {
+					t.Errorf("ExchangeToken() error = %v, want %v", jwtErr, tt.wantErr)
+				}
+				return
+			}
+			if jwtErr != nil {
+				t.Fatalf("ExchangeToken() error = %v", jwtErr)
+			}
+			identity, err := authConfig.Authenticate(response.AccessToken)
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if identity.Subject != "test_user" || identity.Claims["aud"] != "orders" || response.Scope != tt.wantScope {
+				t.Errorf("exchanged token = %+v, scope %v", identity, response.Scope)
+			}
+			if response.ExpiresIn > int64(authConfig.AuthTokenValidTime/time.Second) {
+				t.Errorf("ExpiresIn = %v, want at most %v", response.ExpiresIn, authConfig.AuthTokenValidTime)
+			}
+			act, _ := identity.Claims["act"].(map[string]interface{})
+			if actor, _ := act["sub"].(string); actor != tt.wantActor {
+				t.Errorf("act.sub = %v, want %v", actor, tt.wantActor)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_ExchangeToken_binding(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		DPoP:          NewDPoP(),
+	}, WithExchangeAudiences("orders"))
+	pair, jwtErr := authConfig.IssueAuthenticatedTokenPair("test_user", nil, &Authentication{ACR: "2"})
+	if jwtErr != nil {
+		t.Fatalf("IssueAuthenticatedTokenPair() error = %v", jwtErr)
+	}
+	bound, jwtErr := authConfig.IssueDPoPBoundToken("test_user", time.Minute, "thumbprint")
+	if jwtErr != nil {
+		t.Fatalf("IssueDPoPBoundToken() error = %v", jwtErr)
+	}
+	tests := []struct {
+		name    string
+		token   string
+		wantACR string
+		wantJKT string
+	}{
+		{name: "Test_authentication_carried", token: pair.AuthToken, wantACR: "2"},
+		{name: "Test_cnf_carried", token: bound, wantJKT: "thumbprint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, jwtErr := authConfig.ExchangeToken(&TokenExchangeRequest{SubjectToken: tt.token, Audience: "orders"})
+			if jwtErr != nil {
+				t.Fatalf("ExchangeToken() error = %v", jwtErr)
+			}
+			identity, jwtErr := authConfig.validateCredentials(context.Background(), nil,
+				&Credentials{AuthToken: response.AccessToken, carriedBindings: true}, TokenUseAccess)
+			if jwtErr != nil {
+				t.Fatalf("validateCredentials() error = %v", jwtErr)
+			}
+			if acr, _ := identity.Claims["acr"].(string); acr != tt.wantACR {
+				t.Errorf("acr = %v, want %v", acr, tt.wantACR)
+			}
+			if jkt := confirmation(identity)["jkt"]; jkt != tt.wantJKT {
+				t.Errorf("cnf.jkt = %v, want %v", jkt, tt.wantJKT)
+			}
+		})
+	}
+}
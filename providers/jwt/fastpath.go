@@ -0,0 +1,127 @@
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"hash"
+	"strings"
+	"sync"
+)
+
+var (
+	// hs256Header is the encoded header of the tokens signed with HS256, the tokens carrying it are verified
+	// without going through the generic parser of the jwt library
+	hs256Header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+	bufferPool = sync.Pool{
+		New: func() interface{} {
+			buffer := make([]byte, 0, 1024)
+			return &buffer
+		},
+	}
+)
+
+// hmacPool recycles the HMAC-SHA256 hashes of a signing key, the pool of a retired key is released along with the
+// config holding it
+type hmacPool struct {
+	key  string
+	pool sync.Pool
+}
+
+func newHMACPool(key string) *hmacPool {
+	p := &hmacPool{key: key}
+	p.pool.New = func() interface{} {
+		return hmac.New(sha256.New, []byte(key))
+	}
+	return p
+}
+
+// hs256FastPath reports whether the tokens are plain HS256 JWS verified with the SigningKey. The configs not created
+// by CreateJwtAuthenticator and the ones whose SigningKey was replaced since go through the generic path
+func (authConfig *JwtAuthConfig) hs256FastPath() bool {
+	return authConfig.SigningMethod == "HS256" && authConfig.KeyStore == nil && authConfig.Tenants == nil &&
+		authConfig.tokenMode() == ModeSign && authConfig.hs256 != nil && authConfig.hs256.key == authConfig.SigningKey
+}
+
+// verifyHS256 verifies the token without allocating for the header and the signature, the tokens with any other
+// header are left to the generic path and handled is false
+func (authConfig *JwtAuthConfig) verifyHS256(c *Credentials) (handled bool, err error) {
+	token := c.AuthToken
+	headerEnd := strings.IndexByte(token, '.')
+	if headerEnd < 0 || token[:headerEnd] != hs256Header {
+		return false, nil
+	}
+	signatureStart := strings.LastIndexByte(token, '.')
+	if signatureStart == headerEnd || strings.IndexByte(token[headerEnd+1:signatureStart], '.') >= 0 {
+		return true, classifyError(jwt.NewValidationError("token contains an invalid number of segments", jwt.ValidationErrorMalformed))
+	}
+
+	bufferRef := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(bufferRef)
+	buffer := (*bufferRef)[:0]
+
+	// the signing input and the encoded signature are copied once, the decoded segments reuse the same buffer
+	buffer = append(buffer, token...)
+	var signature [sha256.Size]byte
+	encodedSignature := buffer[signatureStart+1:]
+	if base64.RawURLEncoding.DecodedLen(len(encodedSignature)) != sha256.Size {
+		return true, classifyError(jwt.NewValidationError("signature is invalid", jwt.ValidationErrorSignatureInvalid))
+	}
+	if _, err := base64.RawURLEncoding.Decode(signature[:], encodedSignature); err != nil {
+		return true, classifyError(jwt.NewValidationError("signature is invalid", jwt.ValidationErrorSignatureInvalid))
+	}
+
+	mac := authConfig.hs256.pool.Get().(hash.Hash)
+	mac.Reset()
+	mac.Write(buffer[:signatureStart])
+	var sum [sha256.Size]byte
+	mac.Sum(sum[:0])
+	authConfig.hs256.pool.Put(mac)
+	if !hmac.Equal(sum[:], signature[:]) {
+		return true, classifyError(jwt.NewValidationError("signature is invalid", jwt.ValidationErrorSignatureInvalid))
+	}
+
+	encodedPayload := buffer[headerEnd+1 : signatureStart]
+	payloadStart := len(buffer)
+	size := payloadStart + base64.RawURLEncoding.DecodedLen(len(encodedPayload))
+	if cap(buffer) < size {
+		grown := make([]byte, len(buffer), size)
+		copy(grown, buffer)
+		buffer = grown
+		encodedPayload = buffer[headerEnd+1 : signatureStart]
+	}
+	buffer = buffer[:size]
+	n, err := base64.RawURLEncoding.Decode(buffer[payloadStart:], encodedPayload)
+	*bufferRef = buffer
+	if err != nil {
+		return true, classifyError(&jwt.ValidationError{Inner: err, Errors: jwt.ValidationErrorMalformed})
+	}
+	payload := buffer[payloadStart : payloadStart+n]
+	claims := jwt.MapClaims{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return true, classifyError(&jwt.ValidationError{Inner: err, Errors: jwt.ValidationErrorMalformed})
+	}
+	if err := validateTimes(claims, authConfig.now(), authConfig.Leeway); err != nil {
+		return true, err
+	}
+	// the typed claims are decoded from the same payload rather than by parsing the token again, the payload is
+	// kept out of the pooled buffer for the ValidationCache to decode them on its hits
+	if authConfig.ClaimsType != nil {
+		c.payload = append([]byte(nil), payload...)
+		raw, err := inflateRawClaims(payload)
+		if err != nil {
+			return true, err
+		}
+		typed := authConfig.ClaimsType()
+		if err := authConfig.serializer().Unmarshal(raw, typed); err != nil {
+			return true, turboError.Wrap(turboError.ErrTokenMalformed, err)
+		}
+		c.typed = typed
+	}
+	c.Claims = claims
+	return true, nil
+}
@@ -0,0 +1,179 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang-jwt/jwt/v4"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// FileKeySource reads the signing material from a file, e.g. a secret mounted by kubernetes or written by
+	// cert-manager, used by a KeyManager the key is swapped as soon as the file changes, on the next interval when
+	// the file cannot be watched. The file holds the HMAC secret, or the PEM private key for RS and ES, a PEM public
+	// key only verifies the tokens
+	FileKeySource struct {
+		Path          string
+		SigningMethod string
+		// KeyIDSecret derives the kid of an HMAC secret, the replicas sharing it agree on the kid. The kid is the
+		// file name and its modification time when empty. The kid of a PEM key is derived from its public key
+		KeyIDSecret string
+
+		mutex sync.Mutex
+		sum   [sha256.Size]byte
+	}
+)
+
+// watchSettle lets the writers finish before the changed file is read, the events of a replace are coalesced
+const watchSettle = 100 * time.Millisecond
+
+// ErrKeyUnchanged is returned by the key sources when the material has not changed since the last key, the
+// KeyManager keeps the current key
+var ErrKeyUnchanged = errors.New("signing key unchanged")
+
+func NewFileKeySource(path string, signingMethod string) *FileKeySource {
+	return &FileKeySource{Path: path, SigningMethod: signingMethod}
+}
+
+// NextKey reads the file, the material is only compared in memory and never exposed through the kid
+func (s *FileKeySource) NextKey() (*Key, error) {
+	content, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	// the editors and the secret mounts commonly end the files with a newline
+	content = bytes.TrimRight(content, "\r\n")
+	if len(content) == 0 {
+		return nil, fmt.Errorf("key file %s is empty", s.Path)
+	}
+	sum := sha256.Sum256(content)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sum == s.sum {
+		return nil, ErrKeyUnchanged
+	}
+	key, err := parseKeyMaterial(s.SigningMethod, content)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %v", s.Path, err)
+	}
+	if key.ID, err = s.keyID(key, content); err != nil {
+		return nil, fmt.Errorf("key file %s: %v", s.Path, err)
+	}
+	s.sum = sum
+	return key, nil
+}
+
+// keyID derives the kid from the public key of the PEM keys, from the HMAC of the secret under the KeyIDSecret or
+// from the name and the modification time of the file
+func (s *FileKeySource) keyID(key *Key, content []byte) (string, error) {
+	switch {
+	case !strings.HasPrefix(key.SigningMethod, "HS"):
+		der, err := x509.MarshalPKIXPublicKey(key.VerifyKey)
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256(der)
+		return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+	case s.KeyIDSecret != "":
+		mac := hmac.New(sha256.New, []byte(s.KeyIDSecret))
+		mac.Write(content)
+		return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:12]), nil
+	default:
+		info, err := os.Stat(s.Path)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Base(s.Path) + "-" + strconv.FormatInt(info.ModTime().UnixNano(), 36), nil
+	}
+}
+
+// Watch notifies the changes of the file until stop is closed, see KeyWatcher. The directory is watched since
+// kubernetes swaps the mounted secrets through a symlink, nil is returned when the file cannot be watched and the
+// KeyManager keeps polling on its interval
+func (s *FileKeySource) Watch(stop <-chan struct{}) <-chan struct{} {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.ErrorF("unable to watch the key file %s, polling it: %v", s.Path, err)
+		return nil
+	}
+	if err := watcher.Add(filepath.Dir(s.Path)); err != nil {
+		_ = watcher.Close()
+		logger.ErrorF("unable to watch the key file %s, polling it: %v", s.Path, err)
+		return nil
+	}
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+		var settle <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&fsnotify.Chmod != event.Op {
+					settle = time.After(watchSettle)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.ErrorF("key file %s watch error: %v", s.Path, err)
+			case <-settle:
+				settle = nil
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return changes
+}
+
+// parseKeyMaterial reads the HMAC secret or the PEM key of the signing method
+func parseKeyMaterial(signingMethod string, content []byte) (*Key, error) {
+	if _, err := getSigningMethod(signingMethod); err != nil {
+		return nil, err
+	}
+	key := &Key{SigningMethod: signingMethod}
+	switch {
+	case strings.HasPrefix(signingMethod, "HS"):
+		key.SignKey, key.VerifyKey = content, content
+	case strings.HasPrefix(signingMethod, "RS"):
+		if privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(content); err == nil {
+			key.SignKey, key.VerifyKey = privateKey, &privateKey.PublicKey
+			return key, nil
+		}
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM(content)
+		if err != nil {
+			return nil, err
+		}
+		key.VerifyKey = publicKey
+	default:
+		if privateKey, err := jwt.ParseECPrivateKeyFromPEM(content); err == nil {
+			key.SignKey, key.VerifyKey = privateKey, &privateKey.PublicKey
+			return key, nil
+		}
+		publicKey, err := jwt.ParseECPublicKeyFromPEM(content)
+		if err != nil {
+			return nil, err
+		}
+		key.VerifyKey = publicKey
+	}
+	return key, nil
+}
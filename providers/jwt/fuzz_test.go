@@ -0,0 +1,116 @@
+//go:build go1.18
+
+package jwt
+
+import (
+	"testing"
+	"time"
+)
+
+// go test runs the seeds of the fuzz targets only, the inputs are explored with e.g.
+//
+//	go test ./providers/jwt -run '^$' -fuzz FuzzJwtAuthConfig_Authenticate
+
+// fuzzSeeds are tokens with the shapes the parser must survive, the valid token is added by the targets
+var fuzzSeeds = []string{
+	"",
+	"..",
+	"a.b.c.d.e",
+	"eyJhbGciOiJub25lIn0.eyJVc2VybmFtZSI6InRlc3RfdXNlciJ9.",
+	"eyJhbGciOiJIUzI1NiIsImNyaXQiOlsiYjY0Il19.e30.c2ln",
+	"e30.W1tbW1tbW1tbW11dXV1dXV1dXV0.",
+	"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJ6aXAiOiJERUYiLCJ6Y2xhaW1zIjoiQUFBQSJ9.c2ln",
+}
+
+func fuzzAuthConfig(f *testing.F) (*JwtAuthConfig, string) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+	pair, jwtErr := authConfig.IssueTokenPair("test_user", []string{"admin"})
+	if jwtErr != nil {
+		f.Fatalf("IssueTokenPair() error = %v", jwtErr)
+	}
+	f.Add(pair.AuthToken)
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	return authConfig, pair.AuthToken
+}
+
+func FuzzJwtAuthConfig_Authenticate(f *testing.F) {
+	authConfig, _ := fuzzAuthConfig(f)
+	f.Fuzz(func(t *testing.T, token string) {
+		identity, err := authConfig.Authenticate(token)
+		if err != nil {
+			return
+		}
+		if identity == nil || identity.Subject != "test_user" {
+			t.Errorf("Authenticate() = %+v, the forged token was accepted", identity)
+		}
+		if len(token) > DefaultMaxTokenLength || time.Until(identity.ExpiresAt) <= 0 {
+			t.Errorf("Authenticate() accepted %q past the limits", token)
+		}
+	})
+}
+
+// FuzzCredentials_verifyHS256 checks that the HS256 fast path accepts no token the jwt library rejects
+func FuzzCredentials_verifyHS256(f *testing.F) {
+	authConfig, _ := fuzzAuthConfig(f)
+	f.Fuzz(func(t *testing.T, token string) {
+		fast := &Credentials{AuthToken: token}
+		handled, err := authConfig.verifyHS256(fast)
+		if !handled || err != nil {
+			return
+		}
+		generic := &Credentials{AuthToken: token}
+		if err := generic.validateToken(authConfig.keyFunc, authConfig.now(), 0); err != nil {
+			t.Errorf("verifyHS256() accepted %q, validateToken() error = %v", token, err)
+		}
+	})
+}
+
+func FuzzJwtAuthConfig_decodeTypedClaims(f *testing.F) {
+	authConfig, _ := fuzzAuthConfig(f)
+	authConfig.ClaimsType = func() TypedClaims {
+		return &orderClaims{}
+	}
+	f.Fuzz(func(t *testing.T, token string) {
+		identity, err := authConfig.Authenticate(token)
+		if err != nil {
+			return
+		}
+		if claims, ok := identity.TypedClaims.(*orderClaims); !ok || claims.Username != identity.Subject {
+			t.Errorf("TypedClaims = %+v, want the claims of %q", identity.TypedClaims, identity.Subject)
+		}
+	})
+}
+
+func FuzzLimits_checkToken(f *testing.F) {
+	_, _ = fuzzAuthConfig(f)
+	limits := &Limits{}
+	f.Fuzz(func(t *testing.T, token string) {
+		if err := limits.checkToken(token); err == nil && len(token) > DefaultMaxTokenLength {
+			t.Errorf("checkToken() accepted a token of %d bytes", len(token))
+		}
+	})
+}
+
+func FuzzInflateClaims(f *testing.F) {
+	f.Add("")
+	f.Add("AAAA")
+	f.Add("qlYqyk9KLSrOLEpVslIqSS0uUdJRyk0tSsxJTQEEAAD__w")
+	f.Fuzz(func(t *testing.T, compressed string) {
+		claims := map[string]interface{}{"Username": "test_user", ClaimCompression: CompressionDeflate, ClaimCompressed: compressed}
+		if err := inflateClaims(claims); err != nil {
+			return
+		}
+		if claims["Username"] != "test_user" {
+			t.Errorf("inflateClaims() replaced the Username with %v", claims["Username"])
+		}
+		if _, ok := claims[ClaimCompressed]; ok {
+			t.Errorf("inflateClaims() kept the compressed claims")
+		}
+	})
+}
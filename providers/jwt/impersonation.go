@@ -0,0 +1,194 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// ImpersonationPolicy decides whether the actor may impersonate the target looked up by the
+	// ImpersonationTargetLookup, returning an error denies the impersonation
+	ImpersonationPolicy func(ctx context.Context, actor *turboAuth.Identity, target *turboAuth.Identity, request *ImpersonationRequest) error
+
+	// ImpersonationTargetLookup returns the identity of the subject to impersonate with its roles and scopes, e.g.
+	// from the user store. Returning an error denies the impersonation
+	ImpersonationTargetLookup func(ctx context.Context, subject string) (*turboAuth.Identity, error)
+
+	// ImpersonationRequest asks for a token of the Subject on behalf of the actor. Roles and Scopes narrow the ones
+	// of the target, all of them are granted when empty, and never exceed the ones of the actor
+	ImpersonationRequest struct {
+		Subject string   `json:"subject"`
+		Roles   []string `json:"roles,omitempty"`
+		Scopes  []string `json:"scopes,omitempty"`
+		// Reason is recorded in the audit event, e.g. the support ticket
+		Reason string `json:"reason,omitempty"`
+		// TTL of the token, DefaultImpersonationTTL when 0, the token never outlives the token of the actor
+		TTL time.Duration `json:"-"`
+	}
+)
+
+// DefaultImpersonationTTL keeps the impersonation tokens short lived
+const DefaultImpersonationTTL = 15 * time.Minute
+
+var (
+	ErrImpersonationDenied = errors.New("impersonation is not allowed")
+)
+
+// AllowImpersonation lets the actors holding one of the roles impersonate the other subjects. The targets holding
+// one of the roles themselves are privileged and cannot be impersonated, neither can the impersonated identities
+// impersonate in turn
+func AllowImpersonation(roles ...string) ImpersonationPolicy {
+	return func(ctx context.Context, actor *turboAuth.Identity, target *turboAuth.Identity, request *ImpersonationRequest) error {
+		allowed := false
+		for _, role := range roles {
+			if target.HasRole(role) {
+				return ErrImpersonationDenied
+			}
+			allowed = allowed || actor.HasRole(role)
+		}
+		if !allowed {
+			return ErrImpersonationDenied
+		}
+		return nil
+	}
+}
+
+// Impersonate issues a token of the requested subject carrying the actor in its act claim (RFC 8693), the
+// ImpersonationPolicy must allow it. Every attempt is audited with the actor
+func (authConfig *JwtAuthConfig) Impersonate(ctx context.Context, actor *turboAuth.Identity, request *ImpersonationRequest) (*TokenExchangeResponse, *turboError.JwtError) {
+	token, scopes, ttl, err := authConfig.impersonate(ctx, actor, request)
+	if authConfig.AuditLogger != nil {
+		event := audit.NewEvent(nil, audit.EventImpersonation, "jwt", err)
+		event.Subject = request.Subject
+		event.Actor = actor.Subject
+		if err == nil {
+			event.Reason = request.Reason
+		}
+		authConfig.AuditLogger.Log(event)
+	}
+	if err != nil {
+		return nil, turboError.NewJwtError(err, 403)
+	}
+	return &TokenExchangeResponse{
+		AccessToken:     token,
+		IssuedTokenType: TokenTypeJWT,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(ttl / time.Second),
+		Scope:           strings.Join(scopes, " "),
+	}, nil
+}
+
+func (authConfig *JwtAuthConfig) impersonate(ctx context.Context, actor *turboAuth.Identity, request *ImpersonationRequest) (string, []string, time.Duration, error) {
+	if authConfig.ImpersonationPolicy == nil || authConfig.ImpersonationTargets == nil || request.Subject == "" ||
+		request.Subject == actor.Subject {
+		return "", nil, 0, ErrImpersonationDenied
+	}
+	if _, impersonated := actor.Actor(); impersonated {
+		return "", nil, 0, ErrImpersonationDenied
+	}
+	target, err := authConfig.ImpersonationTargets(ctx, request.Subject)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if target == nil || target.Subject != request.Subject {
+		return "", nil, 0, ErrImpersonationDenied
+	}
+	if err = authConfig.ImpersonationPolicy(ctx, actor, target, request); err != nil {
+		return "", nil, 0, err
+	}
+	roles, ok := narrow(target.Roles, request.Roles, actor.Roles)
+	if !ok {
+		return "", nil, 0, ErrImpersonationDenied
+	}
+	scopes, ok := narrow(target.Scopes, request.Scopes, actor.Scopes)
+	if !ok {
+		return "", nil, 0, ErrImpersonationDenied
+	}
+	ttl := request.TTL
+	if ttl <= 0 {
+		ttl = DefaultImpersonationTTL
+	}
+	now := authConfig.now()
+	if !actor.ExpiresAt.IsZero() && actor.ExpiresAt.Sub(now) < ttl {
+		ttl = actor.ExpiresAt.Sub(now)
+	}
+	payload, err := newPayload(request.Subject, ttl, now)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	token, jwtErr := authConfig.signPayload(ctx, &extendedClaims{
+		Payload: *payload,
+		Scope:   strings.Join(scopes, " "),
+		Roles:   roles,
+		Act:     map[string]interface{}{"sub": actor.Subject},
+	})
+	if jwtErr != nil {
+		return "", nil, 0, jwtErr
+	}
+	return token, scopes, ttl, nil
+}
+
+// narrow returns the requested values, all of the ones of the target when none is requested, held by the actor too.
+// Requesting a value the target does not hold fails
+func narrow(target []string, requested []string, actor []string) ([]string, bool) {
+	if len(requested) == 0 {
+		requested = target
+	}
+	var granted []string
+	for _, value := range requested {
+		if !contains(target, value) {
+			return nil, false
+		}
+		if contains(actor, value) {
+			granted = append(granted, value)
+		}
+	}
+	return granted, true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ImpersonationHandler issues the impersonation tokens of the POSTed json ImpersonationRequest, it must be placed
+// after the authenticator of the actor
+func (authConfig *JwtAuthConfig) ImpersonationHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request")
+			return
+		}
+		actor, ok := turboAuth.IdentityFromContext(r.Context())
+		if !ok {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_token")
+			return
+		}
+		var request ImpersonationRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Subject == "" {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		response, jwtErr := authConfig.Impersonate(r.Context(), actor, &request)
+		if jwtErr != nil {
+			writeOAuthError(w, http.StatusForbidden, "access_denied")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.ErrorF("unable to write the impersonation response: %v", err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJwtAuthConfig_Impersonate(t *testing.T) {
+	var events []*audit.Event
+	targets := map[string]*turboAuth.Identity{
+		"user":  {Subject: "user", Roles: []string{"user", "billing"}, Scopes: []string{"read"}},
+		"agent": {Subject: "agent", Roles: []string{"support", "user"}},
+		"lead":  {Subject: "lead", Roles: []string{"support", "user"}},
+		"other": {Subject: "other"},
+	}
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+	}, WithImpersonationPolicy(AllowImpersonation("support"), func(ctx context.Context, subject string) (*turboAuth.Identity, error) {
+		if target, ok := targets[subject]; ok {
+			return target, nil
+		}
+		return nil, ErrImpersonationDenied
+	}), WithAuditLogger(audit.AuditLoggerFunc(func(event *audit.Event) {
+		events = append(events, event)
+	})))
+	support := &turboAuth.Identity{Subject: "agent", Roles: []string{"support", "user"}, Scopes: []string{"read", "write"},
+		ExpiresAt: time.Now().Add(5 * time.Minute)}
+	impersonated := &turboAuth.Identity{Subject: "user", Roles: []string{"support"},
+		Claims: map[string]interface{}{"act": map[string]interface{}{"sub": "agent"}}}
+
+	tests := []struct {
+		name      string
+		actor     *turboAuth.Identity
+		subject   string
+		roles     []string
+		wantRoles []string
+		wantErr   bool
+	}{
+		{name: "Test_allowed", actor: support, subject: "user", wantRoles: []string{"user"}},
+		{name: "Test_narrowed", actor: support, subject: "user", roles: []string{"user"}, wantRoles: []string{"user"}},
+		{name: "Test_roles_beyond_target", actor: support, subject: "user", roles: []string{"support"}, wantErr: true},
+		{name: "Test_without_role", actor: &turboAuth.Identity{Subject: "user"}, subject: "other", wantErr: true},
+		{name: "Test_privileged_target", actor: support, subject: "lead", wantErr: true},
+		{name: "Test_unknown_target", actor: support, subject: "ghost", wantErr: true},
+		{name: "Test_self", actor: support, subject: "agent", wantErr: true},
+		{name: "Test_nested", actor: impersonated, subject: "other", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events = nil
+			response, jwtErr := authConfig.Impersonate(context.Background(), tt.actor, &ImpersonationRequest{
+				Subject: tt.subject,
+				Roles:   tt.roles,
+				Reason:  "TICKET-42",
+			})
+			if len(events) != 1 || events[0].Actor != tt.actor.Subject || events[0].Subject != tt.subject {
+				t.Errorf("audit events = %+v, want one event with the actor", events)
+			}
+			if tt.wantErr {
+				if !errors.Is(jwtErr, ErrImpersonationDenied) {
+					t.Errorf("Impersonate() error = %v, want %v", jwtErr, ErrImpersonationDenied)
+				}
+				return
+			}
+			if jwtErr != nil {
+				t.Fatalf("Impersonate() error = %v", jwtErr)
+			}
+			if response.ExpiresIn > int64((5 * time.Minute).Seconds()) {
+				t.Errorf("ExpiresIn = %v, want the token of the actor not to be outlived", response.ExpiresIn)
+			}
+			if response.Scope != "read" {
+				t.Errorf("Scope = %v, want the scopes of the target held by the actor", response.Scope)
+			}
+			identity, err := authConfig.Authenticate(response.AccessToken)
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if actor, ok := identity.Actor(); !ok || actor != "agent" || identity.Subject != "user" {
+				t.Errorf("identity = %+v, actor = %v", identity, actor)
+			}
+			if !reflect.DeepEqual(identity.Roles, tt.wantRoles) {
+				t.Errorf("Roles = %v, want %v", identity.Roles, tt.wantRoles)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package jwt
+
+import (
+	"encoding/json"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/internal/secret"
+	"net/http"
+	"strings"
+)
+
+type (
+	// IntrospectionResponse is the RFC 7662 introspection response, only Active is set for inactive tokens
+	IntrospectionResponse struct {
+		Active    bool     `json:"active"`
+		Scope     string   `json:"scope,omitempty"`
+		ClientID  string   `json:"client_id,omitempty"`
+		Username  string   `json:"username,omitempty"`
+		TokenType string   `json:"token_type,omitempty"`
+		Exp       int64    `json:"exp,omitempty"`
+		Iat       int64    `json:"iat,omitempty"`
+		Nbf       int64    `json:"nbf,omitempty"`
+		Sub       string   `json:"sub,omitempty"`
+		Aud       Audience `json:"aud,omitempty"`
+		Iss       string   `json:"iss,omitempty"`
+		Jti       string   `json:"jti,omitempty"`
+	}
+
+	// Audience is the aud claim, a single audience is written as a string and read from a string or an array
+	Audience []string
+
+	// CallerAuthenticator authenticates the resource server calling the introspection endpoint
+	CallerAuthenticator func(r *http.Request) (clientID string, ok bool)
+)
+
+// BasicCallerAuthenticator authenticates the callers with the client id and secret of the basic auth header
+func BasicCallerAuthenticator(clients map[string]string) CallerAuthenticator {
+	return func(r *http.Request) (string, bool) {
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok {
+			return "", false
+		}
+		expected, found := clients[clientID]
+		// compare even for unknown clients so the timing does not reveal them
+		if !secret.Equal(clientSecret, expected) || !found {
+			return "", false
+		}
+		return clientID, true
+	}
+}
+
+// IntrospectionHandler implements RFC 7662 token introspection: the token form parameter is validated and its
+// state is returned, the callers must be authenticated by callerAuthenticator
+func (authConfig *JwtAuthConfig) IntrospectionHandler(callerAuthenticator CallerAuthenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusMethodNotAllowed,
+				Message:    "Error : method not allowed \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+		if callerAuthenticator == nil {
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Error : introspection requires caller authentication \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+		if _, ok := callerAuthenticator(r); !ok {
+			w.Header().Set(turboError.HeaderWWWAuthenticate, `Basic realm="introspection"`)
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Error : invalid client credentials \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+		token := r.PostFormValue("token")
+		if token == "" {
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "Error : token parameter is required \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+
+		response := &IntrospectionResponse{}
+		if identity, err := authConfig.Authenticate(token); err == nil {
+			response = newIntrospectionResponse(identity.Claims)
+			response.Sub = identity.Subject
+			response.Username = identity.Subject
+			response.Jti = identity.TokenID
+			response.Scope = strings.Join(identity.Scopes, " ")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.ErrorF("unable to write the introspection response: %v", err)
+		}
+	})
+}
+
+func newIntrospectionResponse(claims map[string]interface{}) *IntrospectionResponse {
+	response := &IntrospectionResponse{
+		Active:    true,
+		TokenType: "Bearer",
+	}
+	if t, ok := timeClaim(claims, "ExpiredAt", "exp"); ok {
+		response.Exp = t.Unix()
+	}
+	if t, ok := timeClaim(claims, "IssuedAt", "iat"); ok {
+		response.Iat = t.Unix()
+	}
+	if t, ok := timeClaim(claims, "nbf"); ok {
+		response.Nbf = t.Unix()
+	}
+	response.ClientID, _ = claims["client_id"].(string)
+	if response.ClientID == "" {
+		response.ClientID, _ = claims["azp"].(string)
+	}
+	if aud, ok := claims["aud"].(string); ok {
+		response.Aud = Audience{aud}
+	} else {
+		response.Aud = stringsClaim(claims["aud"])
+	}
+	response.Iss, _ = claims["iss"].(string)
+	return response
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*a = values
+	return nil
+}
@@ -0,0 +1,263 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"gopkg.in/square/go-jose.v2"
+	"net/http"
+	"strings"
+)
+
+// TokenMode chooses how the tokens are protected
+type TokenMode string
+
+const (
+	// ModeSign issues and accepts JWS tokens only
+	ModeSign TokenMode = "sign"
+	// ModeEncrypt issues and accepts JWE tokens carrying the claims directly, the tokens are only as authentic as
+	// the encryption key is private, never distribute the public key of an encrypt-only deployment
+	ModeEncrypt TokenMode = "encrypt"
+	// ModeSignEncrypt issues and accepts JWE tokens nesting a JWS
+	ModeSignEncrypt TokenMode = "sign+encrypt"
+)
+
+// Encryption wraps the tokens in a compact JWE with A256GCM content encryption, so the claims cannot be
+// read by the clients
+type Encryption struct {
+	// KeyAlgorithm is one of RSA-OAEP, RSA-OAEP-256 or ECDH-ES
+	KeyAlgorithm string
+	// EncryptKey is the *rsa.PublicKey or *ecdsa.PublicKey the tokens are encrypted for
+	EncryptKey interface{}
+	// DecryptKey is the matching private key used during validation
+	DecryptKey interface{}
+	// KeyID is set as the kid header of the JWE when not empty
+	KeyID string
+}
+
+func NewRSAEncryption(privateKey *rsa.PrivateKey) *Encryption {
+	return &Encryption{
+		KeyAlgorithm: string(jose.RSA_OAEP),
+		EncryptKey:   &privateKey.PublicKey,
+		DecryptKey:   privateKey,
+	}
+}
+
+func NewECDHEncryption(privateKey *ecdsa.PrivateKey) *Encryption {
+	return &Encryption{
+		KeyAlgorithm: string(jose.ECDH_ES),
+		EncryptKey:   &privateKey.PublicKey,
+		DecryptKey:   privateKey,
+	}
+}
+
+func (e *Encryption) validate() error {
+	switch jose.KeyAlgorithm(e.KeyAlgorithm) {
+	case jose.RSA_OAEP, jose.RSA_OAEP_256, jose.ECDH_ES:
+	default:
+		return errors.New("jwt: key management algorithm not supported " + e.KeyAlgorithm)
+	}
+	if e.EncryptKey == nil || e.DecryptKey == nil {
+		return errors.New("jwt: encryption requires both the encrypt and decrypt keys")
+	}
+	return nil
+}
+
+// encrypt wraps the plaintext, nested marks a signed token with the cty header
+func (e *Encryption) encrypt(plaintext string, nested bool) (string, error) {
+	opts := (&jose.EncrypterOptions{}).WithType("JWT")
+	if nested {
+		opts = opts.WithContentType("JWT")
+	}
+	encrypter, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{
+		Algorithm: jose.KeyAlgorithm(e.KeyAlgorithm),
+		Key:       e.EncryptKey,
+		KeyID:     e.KeyID,
+	}, opts)
+	if err != nil {
+		return "", err
+	}
+	object, err := encrypter.Encrypt([]byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return object.CompactSerialize()
+}
+
+func (e *Encryption) decrypt(token string) (string, error) {
+	object, err := jose.ParseEncrypted(token)
+	if err != nil {
+		return "", turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	if object.Header.Algorithm != e.KeyAlgorithm {
+		return "", turboError.Wrap(turboError.ErrTokenUnverifiable, errors.New("unexpected key management algorithm: "+object.Header.Algorithm))
+	}
+	plaintext, err := object.Decrypt(e.DecryptKey)
+	if err != nil {
+		return "", turboError.Wrap(turboError.ErrTokenUnverifiable, err)
+	}
+	return string(plaintext), nil
+}
+
+// isEncrypted tells the 5 parts of a compact JWE from the 3 parts of a JWS
+func isEncrypted(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// tokenMode resolves an unset TokenMode from the Encryption, such configs accept the plain JWS tokens as well
+func (authConfig *JwtAuthConfig) tokenMode() TokenMode {
+	if authConfig.TokenMode != "" {
+		return authConfig.TokenMode
+	}
+	if authConfig.Encryption != nil {
+		return ModeSignEncrypt
+	}
+	return ModeSign
+}
+
+// protectToken applies the TokenMode to the claims, sign is the signing function of the JWS
+func (authConfig *JwtAuthConfig) protectToken(claims jwt.Claims, sign func(jwt.Claims) (string, error)) (string, error) {
+	switch authConfig.tokenMode() {
+	case ModeEncrypt:
+		plaintext, err := json.Marshal(claims)
+		if err != nil {
+			return "", err
+		}
+		return authConfig.Encryption.encrypt(string(plaintext), false)
+	case ModeSignEncrypt:
+		token, err := sign(claims)
+		if err != nil {
+			return "", err
+		}
+		return authConfig.Encryption.encrypt(token, true)
+	default:
+		return sign(claims)
+	}
+}
+
+// verifyCredentials validates the auth token according to the TokenMode and populates the claims, the compressed
+// claims are inflated and checked against the Limits. The tenant the token was verified for is returned when
+// Tenants are configured
+func (authConfig *JwtAuthConfig) verifyCredentials(r *http.Request, c *Credentials) (*Tenant, error) {
+	token := c.AuthToken
+	tenant, err := authConfig.verifyToken(r, c)
+	if err != nil {
+		return nil, err
+	}
+	limits := authConfig.limits()
+	// the header of the token nested in an encrypted one
+	if c.AuthToken != token {
+		if err := limits.checkToken(c.AuthToken); err != nil {
+			return nil, err
+		}
+	}
+	if err := inflateClaims(c.Claims); err != nil {
+		return nil, err
+	}
+	return tenant, limits.checkClaims(c.Claims)
+}
+
+// verifyToken is verifyCredentials but for the compressed claims
+func (authConfig *JwtAuthConfig) verifyToken(r *http.Request, c *Credentials) (*Tenant, error) {
+	if c.AuthToken == "" {
+		return nil, turboError.ErrMissingToken
+	}
+	if authConfig.hs256FastPath() {
+		if handled, err := authConfig.verifyHS256(c); handled {
+			return nil, err
+		}
+	}
+	if authConfig.tokenMode() != ModeEncrypt {
+		token, err := authConfig.decryptToken(c.AuthToken)
+		if err != nil {
+			return nil, err
+		}
+		c.AuthToken = token
+		if authConfig.Tenants == nil {
+			return nil, c.validateToken(authConfig.keyFunc, authConfig.now(), authConfig.Leeway)
+		}
+		var tenant *Tenant
+		keyFunc := authConfig.Tenants.keyFunc(authConfig.tenantID(r), &tenant)
+		if err := c.validateToken(keyFunc, authConfig.now(), authConfig.Leeway); err != nil {
+			return nil, err
+		}
+		return tenant, tenant.validateClaims(c.Claims)
+	}
+	claims, payload, err := authConfig.decryptClaims(c.AuthToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTimes(claims, authConfig.now(), authConfig.Leeway); err != nil {
+		return nil, err
+	}
+	c.Claims, c.payload = claims, payload
+	return nil, nil
+}
+
+// decryptClaims opens an encrypt-only token, its claims are returned along with their json
+func (authConfig *JwtAuthConfig) decryptClaims(token string) (jwt.MapClaims, []byte, error) {
+	if !isEncrypted(token) {
+		return nil, nil, turboError.Wrap(turboError.ErrTokenUnverifiable, errors.New("unencrypted tokens are not accepted"))
+	}
+	plaintext, err := authConfig.Encryption.decrypt(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	var claims jwt.MapClaims
+	if err := json.Unmarshal([]byte(plaintext), &claims); err != nil {
+		return nil, nil, turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	return claims, []byte(plaintext), nil
+}
+
+// parsePayload verifies the token according to the TokenMode without validating the time claims, the fam claim of
+// the refresh tokens is decoded along with the Payload
+func (authConfig *JwtAuthConfig) parsePayload(token string) (*extendedClaims, error) {
+	var payload extendedClaims
+	if authConfig.tokenMode() == ModeEncrypt {
+		if !isEncrypted(token) {
+			return nil, turboError.Wrap(turboError.ErrTokenUnverifiable, errors.New("unencrypted tokens are not accepted"))
+		}
+		plaintext, err := authConfig.Encryption.decrypt(token)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(plaintext), &payload); err != nil {
+			return nil, turboError.Wrap(turboError.ErrTokenMalformed, err)
+		}
+		return &payload, nil
+	}
+	token, err := authConfig.decryptToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := jwt.ParseWithClaims(token, &payload, authConfig.keyFunc, jwt.WithoutClaimsValidation()); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// decryptToken returns the signed token nested in an encrypted one, plain tokens are rejected when the TokenMode
+// requires the encryption and returned as is otherwise
+func (authConfig *JwtAuthConfig) decryptToken(token string) (string, error) {
+	if !isEncrypted(token) {
+		if authConfig.TokenMode == ModeSignEncrypt {
+			return "", turboError.Wrap(turboError.ErrTokenUnverifiable, errors.New("unencrypted tokens are not accepted"))
+		}
+		return token, nil
+	}
+	if authConfig.Encryption == nil || authConfig.TokenMode == ModeSign {
+		return "", turboError.Wrap(turboError.ErrTokenUnverifiable, errors.New("encrypted token but no decryption key configured"))
+	}
+	plaintext, err := authConfig.Encryption.decrypt(token)
+	if err != nil {
+		return "", err
+	}
+	if isEncrypted(plaintext) || strings.Count(plaintext, ".") != 2 {
+		return "", turboError.Wrap(turboError.ErrTokenMalformed, errors.New("encrypted token does not nest a signed token"))
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,175 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"math/big"
+	"net/http"
+)
+
+type (
+	// JWK is the json web key representation (RFC 7517) of a public verification key
+	JWK struct {
+		Kty string `json:"kty"`
+		Use string `json:"use,omitempty"`
+		Alg string `json:"alg,omitempty"`
+		Kid string `json:"kid,omitempty"`
+		// RSA
+		N string `json:"n,omitempty"`
+		E string `json:"e,omitempty"`
+		// EC
+		Crv string `json:"crv,omitempty"`
+		X   string `json:"x,omitempty"`
+		Y   string `json:"y,omitempty"`
+	}
+
+	// JWKS is the json web key set document served to the relying services
+	JWKS struct {
+		Keys []JWK `json:"keys"`
+	}
+)
+
+// NewJWK converts the public part of the key, symmetric keys are rejected
+func NewJWK(key *Key) (JWK, error) {
+	jwk := JWK{
+		Use: "sig",
+		Alg: key.SigningMethod,
+		Kid: key.ID,
+	}
+	switch verifyKey := key.VerifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeBigInt(verifyKey.N)
+		jwk.E = encodeBigInt(big.NewInt(int64(verifyKey.E)))
+	case *ecdsa.PublicKey:
+		params := verifyKey.Curve.Params()
+		size := (params.BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = params.Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(padBytes(verifyKey.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padBytes(verifyKey.Y.Bytes(), size))
+	default:
+		return jwk, errors.New("only RSA and EC keys can be published")
+	}
+	return jwk, nil
+}
+
+// PublicKey converts the JWK back to the *rsa.PublicKey or *ecdsa.PublicKey
+func (jwk JWK) PublicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve: " + jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, errors.New("unsupported key type: " + jwk.Kty)
+	}
+}
+
+// NewJWKS builds the key set from the asymmetric keys of the store, current key first
+func NewJWKS(keyStore KeyStore) (*JWKS, error) {
+	jwks := &JWKS{Keys: []JWK{}}
+	for _, key := range PublicKeys(keyStore) {
+		jwk, err := NewJWK(key)
+		if err != nil {
+			return nil, err
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks, nil
+}
+
+// JWKSHandler serves the key set of the KeyStore, to be mounted at turboAuth.DefaultJWKSPath
+func (authConfig *JwtAuthConfig) JWKSHandler() http.Handler {
+	return authConfig.traceHandler("jwt.JWKS", JWKSHandler(authConfig.KeyStore))
+}
+
+func JWKSHandler(keyStore KeyStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusMethodNotAllowed,
+				Message:    "Error : method not allowed \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+		if keyStore == nil {
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusNotFound,
+				Message:    "Error : no key store configured \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+		jwks, err := NewJWKS(keyStore)
+		if err != nil {
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusInternalServerError,
+				Message:    "Error : unable to build the key set \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", turboAuth.DefaultJWKSCacheControl)
+		if err := json.NewEncoder(w).Encode(jwks); err != nil {
+			logger.ErrorF("unable to write the key set: %v", err)
+		}
+	})
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// padBytes left pads the big endian coordinate to the curve size as required by RFC 7518
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
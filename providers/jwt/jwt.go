@@ -4,7 +4,6 @@ import (
 	turboAuth "github.com/nandlabs/turbo-auth"
 	turboError "github.com/nandlabs/turbo-auth/errors"
 	"net/http"
-	"reflect"
 )
 
 func defaultOptions(options *JwtAuthConfig) *JwtAuthConfig {
@@ -31,6 +30,7 @@ func defaultOptions(options *JwtAuthConfig) *JwtAuthConfig {
 			options.RefreshTokenName = turboAuth.DefaultCookieRefreshTokenName
 		}
 	}
+	options.hs256 = newHMACPool(options.SigningKey)
 	return options
 }
 
@@ -38,30 +38,59 @@ func (authConfig *JwtAuthConfig) Apply(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		jwtErr := authConfig.HandleRequest(w, r)
-		var j turboError.JwtError
+		identity, jwtErr := authConfig.handleRequest(r)
 
 		if jwtErr != nil {
 			_ = authConfig.NullifyTokens(w, r)
-			if reflect.TypeOf(jwtErr) == reflect.TypeOf(&j) {
+			unauthorized := isUnauthorized(jwtErr)
+			if authConfig.ErrorWriter != nil {
 				httpError := &turboError.HttpError{
-					StatusCode: http.StatusBadRequest,
-					Message:    "Error : invalid jwt token \n",
+					StatusCode: jwtErr.Code,
+					Message:    jwtErr.Error(),
+					Err:        jwtErr.Err,
 				}
-				httpError.GenerateError(w, r)
+				if unauthorized {
+					httpError.StatusCode = http.StatusUnauthorized
+					// the BearerWriter replaces the challenge with the one of its realm
+					w.Header().Set(turboError.HeaderWWWAuthenticate, turboError.BearerChallenge("", httpError))
+				}
+				authConfig.ErrorWriter.WriteError(w, r, httpError)
+				return
 			}
-			// send error
 			httpError := &turboError.HttpError{
 				StatusCode: http.StatusBadRequest,
-				Message:    "Error : Basic auth filter requires a validator function \n",
+				Message:    "Error : invalid jwt token \n",
+				Err:        jwtErr.Err,
+			}
+			if unauthorized {
+				httpError.StatusCode = http.StatusUnauthorized
+				w.Header().Set(turboError.HeaderWWWAuthenticate, turboError.BearerChallenge("", httpError))
 			}
 			httpError.GenerateError(w, r)
+			return
+		}
+		if identity != nil {
+			authConfig.slideExpiration(w, r, identity)
+			r = r.WithContext(turboAuth.NewContext(r.Context(), identity))
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-func CreateJwtAuthenticator(auth *JwtAuthConfig) *JwtAuthConfig {
-	auth = defaultOptions(auth)
-	return auth
+// CreateJwtAuthenticator returns a defaulted copy of the config, the given config is left untouched so that
+// later changes to it do not race with the requests being served. The returned config must not be modified once
+// in use, it is then safe for concurrent use: the Revoker, KeyStore, ValidationCache and TenantRegistry
+// implementations of the package synchronize internally
+func CreateJwtAuthenticator(auth *JwtAuthConfig, opts ...Option) *JwtAuthConfig {
+	config := *auth
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return defaultOptions(&config)
+}
+
+// isUnauthorized tells the failures answered with 401 and a Bearer challenge as RFC 6750 requires: the missing
+// tokens and the invalid_token ones, i.e. malformed, expired, revoked or not verified. The others keep their status
+func isUnauthorized(jwtErr *turboError.JwtError) bool {
+	return jwtErr.Code == http.StatusUnauthorized || turboError.Kind(jwtErr.Err) != nil
 }
@@ -0,0 +1,276 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultKeyRotationInterval = 24 * time.Hour
+	DefaultMaxPreviousKeys     = 2
+)
+
+type (
+	// KeySource produces the signing material used on every rotation
+	KeySource interface {
+		NextKey() (*Key, error)
+	}
+
+	// KeySourceFunc adapts a function to the KeySource interface
+	KeySourceFunc func() (*Key, error)
+
+	// KeyWatcher is implemented by the sources which notice the changes of their material, the KeyManager rotates
+	// on every change besides the interval. A nil channel leaves the KeyManager to the interval
+	KeyWatcher interface {
+		Watch(stop <-chan struct{}) <-chan struct{}
+	}
+
+	// RotationHook is notified after every successful rotation
+	RotationHook func(current *Key, previous []*Key)
+
+	// KeyManager rotates the keys of a KeyStore on a schedule so that long running services can change
+	// the signing material without a restart
+	KeyManager struct {
+		KeyStore KeyStore
+		Source   KeySource
+		Interval time.Duration
+		// MaxPreviousKeys is the number of retired keys kept for verification, older keys are removed
+		MaxPreviousKeys int
+		OnRotate        RotationHook
+
+		mutex sync.Mutex
+		// rotating serializes the rotations of the ticker with the ones called directly
+		rotating sync.Mutex
+		stop     chan struct{}
+		// checkedAt is the time of the last successful rotation or check of the Source
+		checkedAt time.Time
+		checked   sync.Mutex
+	}
+)
+
+func (f KeySourceFunc) NextKey() (*Key, error) {
+	return f()
+}
+
+// NewHMACKeySource generates random secrets of the given size in bytes for every rotation
+func NewHMACKeySource(signingMethod string, size int) KeySource {
+	return KeySourceFunc(func() (*Key, error) {
+		secret := make([]byte, size)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return &Key{
+			ID:            uuid.New().String(),
+			SigningMethod: signingMethod,
+			SignKey:       secret,
+			VerifyKey:     secret,
+		}, nil
+	})
+}
+
+// NewRSAKeySource generates a new RSA key pair of the given size in bits for every rotation
+func NewRSAKeySource(signingMethod string, bits int) KeySource {
+	return KeySourceFunc(func() (*Key, error) {
+		privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{
+			ID:            uuid.New().String(),
+			SigningMethod: signingMethod,
+			SignKey:       privateKey,
+			VerifyKey:     &privateKey.PublicKey,
+		}, nil
+	})
+}
+
+// NewECDSAKeySource generates a new EC key pair on the curve matching ES256, ES384 or ES512 for every rotation
+func NewECDSAKeySource(signingMethod string) KeySource {
+	return KeySourceFunc(func() (*Key, error) {
+		var curve elliptic.Curve
+		switch signingMethod {
+		case "ES256":
+			curve = elliptic.P256()
+		case "ES384":
+			curve = elliptic.P384()
+		case "ES512":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("singing method not supported")
+		}
+		privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{
+			ID:            uuid.New().String(),
+			SigningMethod: signingMethod,
+			SignKey:       privateKey,
+			VerifyKey:     &privateKey.PublicKey,
+		}, nil
+	})
+}
+
+func NewKeyManager(keyStore KeyStore, source KeySource, interval time.Duration) *KeyManager {
+	if interval <= 0 {
+		interval = DefaultKeyRotationInterval
+	}
+	return &KeyManager{
+		KeyStore:        keyStore,
+		Source:          source,
+		Interval:        interval,
+		MaxPreviousKeys: DefaultMaxPreviousKeys,
+	}
+}
+
+// Start rotates immediately when the store has no current key and then on every interval, and on every change of a
+// KeyWatcher source, until Stop is called
+func (m *KeyManager) Start() error {
+	if m.KeyStore == nil || m.Source == nil {
+		return errors.New("key manager requires a key store and a key source")
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.stop != nil {
+		return errors.New("key manager already started")
+	}
+	if _, err := m.KeyStore.CurrentKey(); err != nil {
+		if err := m.RotateNow(); err != nil {
+			return err
+		}
+	}
+	m.markChecked()
+	m.stop = make(chan struct{})
+	var changes <-chan struct{}
+	if watcher, ok := m.Source.(KeyWatcher); ok {
+		changes = watcher.Watch(m.stop)
+	}
+	go m.run(m.stop, changes)
+	return nil
+}
+
+func (m *KeyManager) Stop() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+}
+
+func (m *KeyManager) run(stop chan struct{}, changes <-chan struct{}) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.RotateNow(); err != nil {
+				logger.ErrorF("key rotation failed: %v", err)
+			}
+		case <-changes:
+			if err := m.RotateNow(); err != nil {
+				logger.ErrorF("key rotation failed: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// RotateNow fetches the next key from the source, makes it current and retires the keys over MaxPreviousKeys, the
+// current key is kept when the source returns ErrKeyUnchanged
+func (m *KeyManager) RotateNow() error {
+	m.rotating.Lock()
+	defer m.rotating.Unlock()
+	key, err := m.Source.NextKey()
+	if errors.Is(err, ErrKeyUnchanged) {
+		m.markChecked()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keys, err := m.rotate(key)
+	if err != nil {
+		return err
+	}
+	m.markChecked()
+	logger.InfoF("signing key rotated, current kid: %s", key.ID)
+	if m.OnRotate != nil {
+		m.OnRotate(keys[0], keys[1:])
+	}
+	return nil
+}
+
+// rotate makes the key current and retires the keys over MaxPreviousKeys, the KeyRing does both under its lock so
+// that the signers never see the store in between
+func (m *KeyManager) rotate(key *Key) ([]*Key, error) {
+	if keyRing, ok := m.KeyStore.(*KeyRing); ok {
+		return keyRing.rotate(key, m.MaxPreviousKeys)
+	}
+	if err := m.KeyStore.Rotate(key); err != nil {
+		return nil, err
+	}
+	keys := m.KeyStore.Keys()
+	if m.MaxPreviousKeys >= 0 && len(keys) > m.MaxPreviousKeys+1 {
+		for _, retired := range keys[m.MaxPreviousKeys+1:] {
+			if err := m.KeyStore.Remove(retired.ID); err != nil {
+				return nil, err
+			}
+		}
+		keys = keys[:m.MaxPreviousKeys+1]
+	}
+	return keys, nil
+}
+
+// Check fails when the store has no current key or, once started, when the rotations have been failing for two
+// intervals: the current key is then older than intended and its source likely unreachable, see health.Checker
+func (m *KeyManager) Check(ctx context.Context) error {
+	if _, err := m.KeyStore.CurrentKey(); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	started := m.stop != nil
+	m.mutex.Unlock()
+	m.checked.Lock()
+	defer m.checked.Unlock()
+	if started && time.Since(m.checkedAt) > 2*m.Interval {
+		return fmt.Errorf("key rotation overdue, last rotated at %s", m.checkedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (m *KeyManager) markChecked() {
+	m.checked.Lock()
+	defer m.checked.Unlock()
+	m.checkedAt = time.Now()
+}
+
+// PublicKeys returns the asymmetric verification keys which can be published to other services
+func (m *KeyManager) PublicKeys() []*Key {
+	return PublicKeys(m.KeyStore)
+}
+
+// PublicKeys filters out the symmetric keys of the store, the secrets must never be published
+func PublicKeys(keyStore KeyStore) []*Key {
+	var keys []*Key
+	for _, key := range keyStore.Keys() {
+		switch key.VerifyKey.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			keys = append(keys, &Key{
+				ID:            key.ID,
+				SigningMethod: key.SigningMethod,
+				VerifyKey:     key.VerifyKey,
+			})
+		}
+	}
+	return keys
+}
@@ -0,0 +1,220 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"sync"
+)
+
+type (
+	// Key is the signing material identified by the kid header of the token
+	Key struct {
+		ID            string
+		SigningMethod string
+		// SignKey is []byte for HMAC, the private key for RSA and ECDSA or a Signer
+		SignKey interface{}
+		// VerifyKey is []byte for HMAC and the public key for RSA
+		VerifyKey interface{}
+	}
+
+	// KeyStore holds the current signing key along with the previous keys which are still accepted for verification
+	KeyStore interface {
+		// CurrentKey returns the key used to sign new tokens
+		CurrentKey() (*Key, error)
+		// Key looks up a signing or verification key by kid
+		Key(kid string) (*Key, error)
+		// Keys returns all the keys accepted for verification, current key first
+		Keys() []*Key
+		// Rotate makes the key current, the previous current key is kept for verification
+		Rotate(key *Key) error
+		// Remove drops a previous key, tokens signed with it are no longer accepted
+		Remove(kid string) error
+	}
+
+	// Signer signs the tokens without handing the private key to the process, e.g. a KMS or the Vault transit
+	// engine, it returns the raw JWS signature of the signing string
+	Signer interface {
+		Sign(signingString string) ([]byte, error)
+	}
+
+	// ContextSigner is a Signer taking the context of the request issuing the token, its calls to the KMS are
+	// cancelled with the request
+	ContextSigner interface {
+		Signer
+		SignContext(ctx context.Context, signingString string) ([]byte, error)
+	}
+
+	// KeyRing is the default concurrency safe KeyStore
+	KeyRing struct {
+		mutex   sync.RWMutex
+		current *Key
+		// previous keys, most recent first
+		previous []*Key
+	}
+)
+
+// NewHMACKey creates a symmetric key where the secret is used for both signing and verification
+func NewHMACKey(kid string, signingMethod string, secret string) *Key {
+	return &Key{
+		ID:            kid,
+		SigningMethod: signingMethod,
+		SignKey:       []byte(secret),
+		VerifyKey:     []byte(secret),
+	}
+}
+
+func NewKeyRing(current *Key, previous ...*Key) (*KeyRing, error) {
+	keyRing := &KeyRing{}
+	for i := len(previous) - 1; i >= 0; i-- {
+		if err := keyRing.Rotate(previous[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := keyRing.Rotate(current); err != nil {
+		return nil, err
+	}
+	return keyRing, nil
+}
+
+func (k *KeyRing) CurrentKey() (*Key, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	if k.current == nil {
+		return nil, errors.New("no current signing key")
+	}
+	return k.current, nil
+}
+
+func (k *KeyRing) Key(kid string) (*Key, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	if k.current != nil && k.current.ID == kid {
+		return k.current, nil
+	}
+	for _, key := range k.previous {
+		if key.ID == kid {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown kid: %s", kid)
+}
+
+func (k *KeyRing) Keys() []*Key {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	keys := make([]*Key, 0, len(k.previous)+1)
+	if k.current != nil {
+		keys = append(keys, k.current)
+	}
+	return append(keys, k.previous...)
+}
+
+func (k *KeyRing) Rotate(key *Key) error {
+	_, err := k.rotate(key, -1)
+	return err
+}
+
+// rotate makes the key current and keeps at most maxPrevious of the previous keys, all of them when negative. It
+// returns the keys left, current key first
+func (k *KeyRing) rotate(key *Key, maxPrevious int) ([]*Key, error) {
+	if key == nil || key.ID == "" {
+		return nil, errors.New("key with a kid is required")
+	}
+	if _, err := getSigningMethod(key.SigningMethod); err != nil {
+		return nil, err
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	previous := make([]*Key, 0, len(k.previous)+1)
+	if k.current != nil && k.current.ID != key.ID {
+		previous = append(previous, k.current)
+	}
+	for _, p := range k.previous {
+		if p.ID != key.ID {
+			previous = append(previous, p)
+		}
+	}
+	if maxPrevious >= 0 && len(previous) > maxPrevious {
+		previous = previous[:maxPrevious]
+	}
+	k.current = key
+	k.previous = previous
+	return append([]*Key{key}, previous...), nil
+}
+
+func (k *KeyRing) Remove(kid string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.current != nil && k.current.ID == kid {
+		return errors.New("current signing key cannot be removed")
+	}
+	previous := make([]*Key, 0, len(k.previous))
+	for _, p := range k.previous {
+		if p.ID != kid {
+			previous = append(previous, p)
+		}
+	}
+	k.previous = previous
+	return nil
+}
+
+// keyFunc resolves the verification key, by kid when a KeyStore is configured and by the iss claim first
+// when Tenants are configured
+func (authConfig *JwtAuthConfig) keyFunc(token *jwt.Token) (interface{}, error) {
+	if authConfig.Tenants != nil {
+		return authConfig.Tenants.keyFunc("", nil)(token)
+	}
+	if authConfig.KeyStore == nil {
+		return hmacKeyFunc(authConfig.SigningKey)(token)
+	}
+	return keyStoreKeyFunc(authConfig.KeyStore)(token)
+}
+
+func keyStoreKeyFunc(keyStore KeyStore) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid header")
+		}
+		key, err := keyStore.Key(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.SigningMethod {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.VerifyKey, nil
+	}
+}
+
+func (authConfig *JwtAuthConfig) signWithKeyStore(ctx context.Context, claims jwt.Claims) (string, error) {
+	key, err := authConfig.KeyStore.CurrentKey()
+	if err != nil {
+		return "", err
+	}
+	jwtToken, err := buildToken(key.SigningMethod, claims)
+	if err != nil {
+		return "", err
+	}
+	jwtToken.Header["kid"] = key.ID
+	signer, ok := key.SignKey.(Signer)
+	if !ok {
+		return jwtToken.SignedString(key.SignKey)
+	}
+	signingString, err := jwtToken.SigningString()
+	if err != nil {
+		return "", err
+	}
+	var signature []byte
+	if contextSigner, ok := signer.(ContextSigner); ok {
+		signature, err = contextSigner.SignContext(ctx, signingString)
+	} else {
+		signature, err = signer.Sign(signingString)
+	}
+	if err != nil {
+		return "", err
+	}
+	return signingString + "." + jwt.EncodeSegment(signature), nil
+}
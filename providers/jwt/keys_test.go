@@ -0,0 +1,551 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyRing_Rotate(t *testing.T) {
+	keyRing, err := NewKeyRing(NewHMACKey("key-1", "HS256", "first_secret"))
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		BearerTokens: true,
+		KeyStore:     keyRing,
+	})
+	oldToken, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+
+	if err := keyRing.Rotate(NewHMACKey("key-2", "HS512", "second_secret")); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	newToken, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+
+	handle := func(token string) error {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(authConfig.AuthTokenName, token)
+		if err := authConfig.HandleRequest(httptest.NewRecorder(), r); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		remove  string
+		wantErr bool
+	}{
+		{name: "Test_current_key", token: newToken},
+		{name: "Test_previous_key", token: oldToken},
+		{name: "Test_removed_key", token: oldToken, remove: "key-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.remove != "" {
+				if err := keyRing.Remove(tt.remove); err != nil {
+					t.Fatalf("Remove() error = %v", err)
+				}
+			}
+			if err := handle(tt.token); (err != nil) != tt.wantErr {
+				t.Errorf("HandleRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyManager_RotateNow(t *testing.T) {
+	keyRing := &KeyRing{}
+	manager := NewKeyManager(keyRing, NewHMACKeySource("HS256", 32), time.Hour)
+	manager.MaxPreviousKeys = 1
+	var rotations int
+	manager.OnRotate = func(current *Key, previous []*Key) {
+		rotations++
+	}
+	for i := 0; i < 3; i++ {
+		if err := manager.RotateNow(); err != nil {
+			t.Fatalf("RotateNow() error = %v", err)
+		}
+	}
+	if rotations != 3 {
+		t.Errorf("OnRotate called %v times, want 3", rotations)
+	}
+	if got := len(keyRing.Keys()); got != 2 {
+		t.Errorf("Keys() len = %v, want 2", got)
+	}
+	if got := len(manager.PublicKeys()); got != 0 {
+		t.Errorf("PublicKeys() len = %v, want 0 for HMAC keys", got)
+	}
+}
+
+func TestKeyManager_RotateNow_concurrent(t *testing.T) {
+	keyRing := &KeyRing{}
+	manager := NewKeyManager(keyRing, NewHMACKeySource("HS256", 32), time.Hour)
+	manager.MaxPreviousKeys = 1
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := manager.RotateNow(); err != nil {
+					t.Errorf("RotateNow() error = %v", err)
+					return
+				}
+				if got := len(keyRing.Keys()); got > 2 {
+					t.Errorf("Keys() len = %v, want at most 2", got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := len(keyRing.Keys()); got != 2 {
+		t.Errorf("Keys() len = %v, want 2", got)
+	}
+}
+
+func TestFileKeySource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing_key")
+	keyRing := &KeyRing{}
+	manager := NewKeyManager(keyRing, NewFileKeySource(path, "HS256"), time.Hour)
+	var rotations int
+	manager.OnRotate = func(current *Key, previous []*Key) {
+		rotations++
+	}
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{BearerTokens: true, KeyStore: keyRing})
+
+	var tokens []string
+	for _, secret := range []string{"first_secret_of_at_least_32_bytes\n", "second_secret_of_at_least_32_bytes"} {
+		if err := ioutil.WriteFile(path, []byte(secret), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// the second check of the same content keeps the current key
+		for i := 0; i < 2; i++ {
+			if err := manager.RotateNow(); err != nil {
+				t.Fatalf("RotateNow() error = %v", err)
+			}
+		}
+		token, err := authConfig.IssueNewToken("test_user", time.Minute)
+		if err != nil {
+			t.Fatalf("IssueNewToken() error = %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+	if rotations != 2 {
+		t.Errorf("OnRotate called %v times, want 2", rotations)
+	}
+	for _, token := range tokens {
+		if _, err := authConfig.Authenticate(token); err != nil {
+			t.Errorf("Authenticate() error = %v", err)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not a pem key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	manager.Source = NewFileKeySource(path, "RS256")
+	if err := manager.RotateNow(); err == nil {
+		t.Errorf("RotateNow() error = nil, want an error for a malformed key")
+	}
+	if current, _ := keyRing.CurrentKey(); current.SigningMethod != "HS256" {
+		t.Errorf("current key = %+v, want the last valid key", current)
+	}
+}
+
+func TestFileKeySource_keyID(t *testing.T) {
+	dir := t.TempDir()
+	secret := "first_secret_of_at_least_32_bytes"
+	hmacPath := filepath.Join(dir, "signing_key")
+	if err := ioutil.WriteFile(hmacPath, []byte(secret), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPath := filepath.Join(dir, "signing_key.pem")
+	if err := ioutil.WriteFile(rsaPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(secret))
+	contentID := base64.RawURLEncoding.EncodeToString(sum[:12])
+
+	tests := []struct {
+		name        string
+		path        string
+		method      string
+		keyIDSecret string
+	}{
+		{name: "Test_hmac_keyed", path: hmacPath, method: "HS256", keyIDSecret: "kid_secret"},
+		{name: "Test_hmac_file_name", path: hmacPath, method: "HS256"},
+		{name: "Test_public_key", path: rsaPath, method: "RS256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := &FileKeySource{Path: tt.path, SigningMethod: tt.method, KeyIDSecret: tt.keyIDSecret}
+			second := &FileKeySource{Path: tt.path, SigningMethod: tt.method, KeyIDSecret: tt.keyIDSecret}
+			key, err := first.NextKey()
+			if err != nil {
+				t.Fatalf("NextKey() error = %v", err)
+			}
+			other, err := second.NextKey()
+			if err != nil {
+				t.Fatalf("NextKey() error = %v", err)
+			}
+			if key.ID == "" || key.ID != other.ID {
+				t.Errorf("kid = %v and %v, want the same kid for the same file", key.ID, other.ID)
+			}
+			if key.ID == contentID {
+				t.Errorf("kid = %v, the hash of the secret", key.ID)
+			}
+		})
+	}
+}
+
+func TestKeyManager_watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing_key")
+	if err := ioutil.WriteFile(path, []byte("first_secret_of_at_least_32_bytes"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source := NewFileKeySource(path, "HS256")
+	source.KeyIDSecret = "kid_secret"
+	manager := NewKeyManager(&KeyRing{}, source, time.Hour)
+	rotated := make(chan *Key, 2)
+	manager.OnRotate = func(current *Key, previous []*Key) {
+		rotated <- current
+	}
+	if err := manager.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer manager.Stop()
+	first := <-rotated
+	if err := ioutil.WriteFile(path, []byte("second_secret_of_at_least_32_bytes"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case current := <-rotated:
+		if current.ID == first.ID {
+			t.Errorf("kid = %v after the change, want a new kid", current.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the key was not rotated on the change of the file")
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	keyRing := &KeyRing{}
+	manager := NewKeyManager(keyRing, NewECDSAKeySource("ES256"), time.Hour)
+	if err := manager.RotateNow(); err != nil {
+		t.Fatalf("RotateNow() error = %v", err)
+	}
+	manager.Source = NewRSAKeySource("RS256", 2048)
+	if err := manager.RotateNow(); err != nil {
+		t.Fatalf("RotateNow() error = %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	JWKSHandler(keyRing).ServeHTTP(w, httptest.NewRequest(http.MethodGet, turboAuth.DefaultJWKSPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("JWKSHandler() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	var jwks JWKS
+	if err := json.NewDecoder(w.Body).Decode(&jwks); err != nil {
+		t.Fatalf("JWKSHandler() body error = %v", err)
+	}
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kty != "RSA" || jwks.Keys[1].Kty != "EC" {
+		t.Fatalf("JWKSHandler() keys = %+v", jwks.Keys)
+	}
+	for _, jwk := range jwks.Keys {
+		if _, err := jwk.PublicKey(); err != nil {
+			t.Errorf("PublicKey() error = %v", err)
+		}
+	}
+}
+
+func TestJwtAuthConfig_MetadataHandler(t *testing.T) {
+	keyRing := &KeyRing{}
+	if err := NewKeyManager(keyRing, NewECDSAKeySource("ES256"), time.Hour).RotateNow(); err != nil {
+		t.Fatalf("RotateNow() error = %v", err)
+	}
+	endpoints := AuthorizationServerMetadata{
+		Issuer:                "https://auth.example.com/",
+		TokenEndpoint:         "https://auth.example.com/token",
+		IntrospectionEndpoint: "https://auth.example.com/introspect",
+	}
+	tests := []struct {
+		name     string
+		config   *JwtAuthConfig
+		metadata AuthorizationServerMetadata
+		want     AuthorizationServerMetadata
+	}{
+		{
+			name:     "Test_key_store",
+			config:   &JwtAuthConfig{KeyStore: keyRing, RefreshTokens: NewMemoryRefreshTokenStore()},
+			metadata: endpoints,
+			want: AuthorizationServerMetadata{
+				Issuer:                endpoints.Issuer,
+				TokenEndpoint:         endpoints.TokenEndpoint,
+				IntrospectionEndpoint: endpoints.IntrospectionEndpoint,
+				JwksURI:               "https://auth.example.com" + turboAuth.DefaultJWKSPath,
+				GrantTypesSupported:   []string{TokenExchangeGrantType, "refresh_token"},
+				IntrospectionEndpointAuthMethodsSupported: []string{"client_secret_basic"},
+				AccessTokenSigningAlgValuesSupported:      []string{"ES256"},
+			},
+		},
+		{
+			name:     "Test_sender_constrained",
+			config:   &JwtAuthConfig{SigningMethod: "HS256", DPoP: NewDPoP(), CertificateBinding: &CertificateBinding{}},
+			metadata: AuthorizationServerMetadata{Issuer: endpoints.Issuer},
+			want: AuthorizationServerMetadata{
+				Issuer:                                endpoints.Issuer,
+				DPoPSigningAlgValuesSupported:         dpopMethods,
+				TLSClientCertificateBoundAccessTokens: true,
+				AccessTokenSigningAlgValuesSupported:  []string{"HS256"},
+			},
+		},
+		{
+			name:   "Test_explicit_values",
+			config: &JwtAuthConfig{KeyStore: keyRing},
+			metadata: AuthorizationServerMetadata{Issuer: endpoints.Issuer, JwksURI: "https://keys.example.com/jwks",
+				TokenEndpoint: endpoints.TokenEndpoint, GrantTypesSupported: []string{"client_credentials"}},
+			want: AuthorizationServerMetadata{
+				Issuer:                               endpoints.Issuer,
+				JwksURI:                              "https://keys.example.com/jwks",
+				TokenEndpoint:                        endpoints.TokenEndpoint,
+				GrantTypesSupported:                  []string{"client_credentials"},
+				AccessTokenSigningAlgValuesSupported: []string{"ES256"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.config.MetadataHandler(tt.metadata).ServeHTTP(w, httptest.NewRequest(http.MethodGet, turboAuth.DefaultMetadataPath, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("MetadataHandler() status = %v, want %v", w.Code, http.StatusOK)
+			}
+			var got AuthorizationServerMetadata
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("MetadataHandler() body error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MetadataHandler() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_Encryption(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		encryption *Encryption
+	}{
+		{name: "Test_rsa_oaep", encryption: NewRSAEncryption(rsaKey)},
+		{name: "Test_ecdh_es", encryption: NewECDHEncryption(ecKey)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator, err := NewJwtAuthenticator(WithSigningKey("test_key"), WithEncryption(tt.encryption))
+			if err != nil {
+				t.Fatalf("NewJwtAuthenticator() error = %v", err)
+			}
+			token, jwtErr := authenticator.IssueNewToken("test_user", time.Minute)
+			if jwtErr != nil {
+				t.Fatalf("IssueNewToken() error = %v", jwtErr)
+			}
+			if !isEncrypted(token) {
+				t.Fatalf("IssueNewToken() = %v, want a compact JWE", token)
+			}
+			identity, err := authenticator.Authenticate(token)
+			if err != nil || identity.Subject != "test_user" {
+				t.Fatalf("Authenticate() = %v, %v", identity, err)
+			}
+
+			plain, _ := NewJwtAuthenticator(WithSigningKey("test_key"))
+			if _, err := plain.Authenticate(token); turboError.Kind(err) != turboError.ErrTokenUnverifiable {
+				t.Errorf("Authenticate() without decryption key error = %v, want unverifiable", err)
+			}
+			wrongKey, _ := NewJwtAuthenticator(WithSigningKey("test_key"), WithEncryption(NewECDHEncryption(otherKey)))
+			if _, err := wrongKey.Authenticate(token); err == nil {
+				t.Errorf("Authenticate() with another decryption key succeeded")
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_TokenMode(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryption := NewECDHEncryption(ecKey)
+	tokens := make(map[TokenMode]string)
+	for _, mode := range []TokenMode{ModeSign, ModeEncrypt, ModeSignEncrypt} {
+		authenticator, err := NewJwtAuthenticator(WithSigningKey("test_key"), WithEncryption(encryption), WithTokenMode(mode))
+		if err != nil {
+			t.Fatalf("NewJwtAuthenticator(%v) error = %v", mode, err)
+		}
+		token, jwtErr := authenticator.IssueNewToken("test_user", time.Minute)
+		if jwtErr != nil {
+			t.Fatalf("IssueNewToken(%v) error = %v", mode, jwtErr)
+		}
+		tokens[mode] = token
+	}
+	if isEncrypted(tokens[ModeSign]) || !isEncrypted(tokens[ModeEncrypt]) || !isEncrypted(tokens[ModeSignEncrypt]) {
+		t.Fatalf("unexpected token formats %v", tokens)
+	}
+
+	tests := []struct {
+		name  string
+		mode  TokenMode
+		token TokenMode
+		valid bool
+	}{
+		{name: "Test_sign_accepts_signed", mode: ModeSign, token: ModeSign, valid: true},
+		{name: "Test_sign_rejects_encrypted", mode: ModeSign, token: ModeSignEncrypt, valid: false},
+		{name: "Test_encrypt_accepts_encrypted", mode: ModeEncrypt, token: ModeEncrypt, valid: true},
+		{name: "Test_encrypt_rejects_nested", mode: ModeEncrypt, token: ModeSignEncrypt, valid: false},
+		{name: "Test_encrypt_rejects_signed", mode: ModeEncrypt, token: ModeSign, valid: false},
+		{name: "Test_nested_accepts_nested", mode: ModeSignEncrypt, token: ModeSignEncrypt, valid: true},
+		{name: "Test_nested_rejects_encrypt_only", mode: ModeSignEncrypt, token: ModeEncrypt, valid: false},
+		{name: "Test_nested_rejects_signed", mode: ModeSignEncrypt, token: ModeSign, valid: false},
+		{name: "Test_unset_mode_accepts_signed", mode: "", token: ModeSign, valid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator, _ := NewJwtAuthenticator(WithSigningKey("test_key"), WithEncryption(encryption), WithTokenMode(tt.mode))
+			identity, err := authenticator.Authenticate(tokens[tt.token])
+			if (err == nil) != tt.valid {
+				t.Fatalf("Authenticate() error = %v, valid %v", err, tt.valid)
+			}
+			if tt.valid && identity.Subject != "test_user" {
+				t.Errorf("Authenticate() subject = %v", identity.Subject)
+			}
+		})
+	}
+
+	if _, err := NewJwtAuthenticator(WithSigningKey("test_key"), WithTokenMode(ModeSignEncrypt)); err == nil {
+		t.Errorf("NewJwtAuthenticator() without encryption key succeeded")
+	}
+}
+
+func TestJwtAuthConfig_Tenants(t *testing.T) {
+	acme, _ := NewKeyRing(NewHMACKey("acme-1", "HS256", "acme_secret"))
+	globex, _ := NewKeyRing(NewHMACKey("globex-1", "HS256", "globex_secret"))
+	tenants, err := NewTenantRegistry(
+		&Tenant{ID: "acme", Issuer: "https://acme.example.com", KeyStore: acme, Audience: "api"},
+		&Tenant{ID: "globex", Issuer: "https://globex.example.com", KeyStore: globex},
+	)
+	if err != nil {
+		t.Fatalf("NewTenantRegistry() error = %v", err)
+	}
+	issue := func(keyStore KeyStore, claims jwt.MapClaims) string {
+		claims["Username"] = "test_user"
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		token, err := (&JwtAuthConfig{KeyStore: keyStore}).signWithKeyStore(context.Background(), claims)
+		if err != nil {
+			t.Fatalf("signWithKeyStore() error = %v", err)
+		}
+		return token
+	}
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{SigningKey: "test_key", BearerTokens: true, Tenants: tenants, TenantHeader: "X-Tenant-ID"})
+
+	tests := []struct {
+		name       string
+		token      string
+		header     string
+		wantTenant string
+	}{
+		{name: "Test_acme_by_issuer", token: issue(acme, jwt.MapClaims{"iss": "https://acme.example.com", "aud": "api"}), wantTenant: "acme"},
+		{name: "Test_acme_audience_list", token: issue(acme, jwt.MapClaims{"iss": "https://acme.example.com", "aud": []string{"web", "api"}}), wantTenant: "acme"},
+		{name: "Test_acme_wrong_audience", token: issue(acme, jwt.MapClaims{"iss": "https://acme.example.com", "aud": "web"})},
+		{name: "Test_globex_by_header", token: issue(globex, jwt.MapClaims{"iss": "https://globex.example.com"}), header: "globex", wantTenant: "globex"},
+		{name: "Test_header_issuer_mismatch", token: issue(globex, jwt.MapClaims{"iss": "https://globex.example.com"}), header: "acme"},
+		{name: "Test_issuer_with_other_tenant_key", token: issue(globex, jwt.MapClaims{"iss": "https://acme.example.com", "aud": "api"})},
+		{name: "Test_unknown_issuer", token: issue(globex, jwt.MapClaims{"iss": "https://initech.example.com"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(authConfig.AuthTokenName, tt.token)
+			if tt.header != "" {
+				r.Header.Set("X-Tenant-ID", tt.header)
+			}
+			identity, jwtErr := authConfig.handleRequest(r)
+			if (jwtErr == nil) != (tt.wantTenant != "") {
+				t.Fatalf("handleRequest() error = %v, want tenant %v", jwtErr, tt.wantTenant)
+			}
+			if jwtErr == nil && identity.Tenant != tt.wantTenant {
+				t.Errorf("identity tenant = %v, want %v", identity.Tenant, tt.wantTenant)
+			}
+		})
+	}
+
+	token := issue(globex, jwt.MapClaims{"iss": "https://globex.example.com"})
+	if _, err := authConfig.Authenticate(token); err != nil {
+		t.Fatalf("Authenticate() before removal error = %v", err)
+	}
+	tenants.Remove("globex")
+	if _, err := authConfig.Authenticate(token); err == nil {
+		t.Errorf("Authenticate() after removal succeeded")
+	}
+}
+
+func TestKeyManager_Check(t *testing.T) {
+	manager := NewKeyManager(&KeyRing{}, NewHMACKeySource("HS256", 32), time.Hour)
+	if err := manager.Check(context.Background()); err == nil {
+		t.Errorf("Check() without a key succeeded")
+	}
+	if err := manager.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer manager.Stop()
+	if err := manager.Check(context.Background()); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+	manager.checked.Lock()
+	manager.checkedAt = time.Now().Add(-3 * time.Hour)
+	manager.checked.Unlock()
+	if err := manager.Check(context.Background()); err == nil {
+		t.Errorf("Check() with an overdue rotation succeeded")
+	}
+}
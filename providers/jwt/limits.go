@@ -0,0 +1,144 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"strings"
+)
+
+const (
+	// DefaultMaxTokenLength is larger than the tokens carrying a few hundred roles, compressed or encrypted
+	DefaultMaxTokenLength = 16 * 1024
+	// DefaultMaxClaims is the default limit of the top level claims
+	DefaultMaxClaims = 128
+	// DefaultMaxDepth is the default nesting limit of the claims, a flat claim set has a depth of 1
+	DefaultMaxDepth = 8
+	// DefaultMaxHeaderParameters is the default limit of the parameters of the protected header
+	DefaultMaxHeaderParameters = 16
+)
+
+// Limits bounds the tokens accepted by the parser, the zero values select the defaults and the negative values
+// disable the limit
+type Limits struct {
+	// MaxTokenLength is the length of the raw token, checked before anything is decoded
+	MaxTokenLength int
+	// MaxClaims is the number of the top level claims, the compressed claims included
+	MaxClaims int
+	// MaxDepth is the nesting of the objects and arrays of the claims
+	MaxDepth int
+	// MaxHeaderParameters is the number of the parameters of the protected header
+	MaxHeaderParameters int
+	// CriticalHeaders lists the crit header parameters understood by the application, the tokens marking any other
+	// parameter as critical are rejected as RFC 7515 requires
+	CriticalHeaders []string
+}
+
+// limits returns the configured Limits or the defaults
+func (authConfig *JwtAuthConfig) limits() *Limits {
+	if authConfig.Limits != nil {
+		return authConfig.Limits
+	}
+	return &Limits{}
+}
+
+func limit(value, defaultValue int) int {
+	if value == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// checkToken checks the length and the protected header of the raw token, signed or encrypted, before it is parsed
+func (l *Limits) checkToken(token string) error {
+	if max := limit(l.MaxTokenLength, DefaultMaxTokenLength); max > 0 && len(token) > max {
+		return turboError.Wrap(turboError.ErrTokenMalformed, fmt.Errorf("token is longer than %d bytes", max))
+	}
+	// the malformed tokens are left to the parser
+	end := strings.IndexByte(token, '.')
+	if end < 0 {
+		return nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(token[:end], "="))
+	if err != nil {
+		return turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	return l.checkHeader(header)
+}
+
+func (l *Limits) checkHeader(header map[string]interface{}) error {
+	if max := limit(l.MaxHeaderParameters, DefaultMaxHeaderParameters); max > 0 && len(header) > max {
+		return turboError.Wrap(turboError.ErrTokenMalformed, fmt.Errorf("header has more than %d parameters", max))
+	}
+	if alg, _ := header["alg"].(string); strings.EqualFold(alg, "none") {
+		return turboError.Wrap(turboError.ErrTokenUnverifiable, errors.New("unsigned tokens are not accepted"))
+	}
+	crit, ok := header["crit"]
+	if !ok {
+		return nil
+	}
+	names, isList := crit.([]interface{})
+	if !isList || len(names) == 0 {
+		return turboError.Wrap(turboError.ErrTokenMalformed, errors.New("crit header must list the parameter names"))
+	}
+	for _, name := range names {
+		parameter, _ := name.(string)
+		if _, present := header[parameter]; !present || !l.understands(parameter) {
+			return turboError.Wrap(turboError.ErrTokenUnverifiable, fmt.Errorf("critical header parameter %v not understood", name))
+		}
+	}
+	return nil
+}
+
+func (l *Limits) understands(parameter string) bool {
+	for _, name := range l.CriticalHeaders {
+		if name == parameter {
+			return true
+		}
+	}
+	return false
+}
+
+// checkClaims checks the number and the nesting of the claims
+func (l *Limits) checkClaims(claims map[string]interface{}) error {
+	if max := limit(l.MaxClaims, DefaultMaxClaims); max > 0 && len(claims) > max {
+		return turboError.Wrap(turboError.ErrTokenMalformed, fmt.Errorf("token has more than %d claims", max))
+	}
+	if max := limit(l.MaxDepth, DefaultMaxDepth); max > 0 && depthExceeds(claims, max) {
+		return turboError.Wrap(turboError.ErrTokenMalformed, fmt.Errorf("claims are nested deeper than %d", max))
+	}
+	return nil
+}
+
+// depthExceeds tells whether the objects and arrays of the value are nested deeper than max, the value counting as
+// one level
+func depthExceeds(value interface{}, max int) bool {
+	if max < 1 {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return true
+		}
+		return false
+	}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, item := range value {
+			if depthExceeds(item, max-1) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			if depthExceeds(item, max-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package jwt
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJwtAuthConfig_Limits(t *testing.T) {
+	sign := func(method jwt.SigningMethod, header map[string]interface{}, claims jwt.MapClaims) string {
+		claims["Username"] = "test_user"
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		token := jwt.NewWithClaims(method, claims)
+		for name, value := range header {
+			token.Header[name] = value
+		}
+		var key interface{} = []byte("test_key")
+		if method == jwt.SigningMethodNone {
+			key = jwt.UnsafeAllowNoneSignatureType
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("SignedString() error = %v", err)
+		}
+		return signed
+	}
+	nested := func(depth int) interface{} {
+		var value interface{} = "leaf"
+		for i := 0; i < depth; i++ {
+			value = []interface{}{value}
+		}
+		return value
+	}
+	many := jwt.MapClaims{}
+	for i := 0; i < DefaultMaxClaims; i++ {
+		many[strings.Repeat("c", i+1)] = i
+	}
+
+	tests := []struct {
+		name    string
+		limits  *Limits
+		token   string
+		wantErr error
+	}{
+		{name: "Test_valid", token: sign(jwt.SigningMethodHS256, nil, jwt.MapClaims{"Roles": []string{"admin"}})},
+		{name: "Test_alg_none", token: sign(jwt.SigningMethodNone, nil, jwt.MapClaims{}),
+			wantErr: turboError.ErrTokenUnverifiable},
+		{name: "Test_too_long", token: sign(jwt.SigningMethodHS256, nil, jwt.MapClaims{"pad": strings.Repeat("a", DefaultMaxTokenLength)}),
+			wantErr: turboError.ErrTokenMalformed},
+		{name: "Test_max_length", limits: &Limits{MaxTokenLength: -1},
+			token: sign(jwt.SigningMethodHS256, nil, jwt.MapClaims{"pad": strings.Repeat("a", DefaultMaxTokenLength)})},
+		{name: "Test_too_many_claims", token: sign(jwt.SigningMethodHS256, nil, many), wantErr: turboError.ErrTokenMalformed},
+		{name: "Test_too_deep", token: sign(jwt.SigningMethodHS256, nil, jwt.MapClaims{"deep": nested(DefaultMaxDepth)}),
+			wantErr: turboError.ErrTokenMalformed},
+		{name: "Test_max_depth", token: sign(jwt.SigningMethodHS256, nil, jwt.MapClaims{"deep": nested(DefaultMaxDepth - 1)})},
+		{name: "Test_too_many_header_parameters", limits: &Limits{MaxHeaderParameters: 2},
+			token: sign(jwt.SigningMethodHS256, map[string]interface{}{"kid": "1"}, jwt.MapClaims{}), wantErr: turboError.ErrTokenMalformed},
+		{name: "Test_unknown_crit", token: sign(jwt.SigningMethodHS256, map[string]interface{}{"crit": []string{"b64"}, "b64": false},
+			jwt.MapClaims{}), wantErr: turboError.ErrTokenUnverifiable},
+		{name: "Test_understood_crit", limits: &Limits{CriticalHeaders: []string{"tenant"}},
+			token: sign(jwt.SigningMethodHS256, map[string]interface{}{"crit": []string{"tenant"}, "tenant": "acme"}, jwt.MapClaims{})},
+		{name: "Test_crit_absent_parameter", limits: &Limits{CriticalHeaders: []string{"tenant"}},
+			token:   sign(jwt.SigningMethodHS256, map[string]interface{}{"crit": []string{"tenant"}}, jwt.MapClaims{}),
+			wantErr: turboError.ErrTokenUnverifiable},
+		{name: "Test_empty_crit", token: sign(jwt.SigningMethodHS256, map[string]interface{}{"crit": []string{}}, jwt.MapClaims{}),
+			wantErr: turboError.ErrTokenMalformed},
+		{name: "Test_header_not_json", token: "bm90IGpzb24.e30.c2ln", wantErr: turboError.ErrTokenMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+				SigningKey:    "test_key",
+				SigningMethod: "HS256",
+				BearerTokens:  true,
+				Limits:        tt.limits,
+			})
+			_, err := authConfig.Authenticate(tt.token)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
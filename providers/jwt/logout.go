@@ -0,0 +1,60 @@
+package jwt
+
+import (
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+)
+
+// LogoutHandler terminates the session of the caller: the auth and refresh tokens are revoked along with the family
+// of the refresh token, the cookies (or headers) are expired and the OnLogout hook is invoked if configured
+func (authConfig *JwtAuthConfig) LogoutHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var c Credentials
+		if err := authConfig.fetchCredsFromRequest(r, &c); err != nil || (c.AuthToken == "" && c.RefreshToken == "") {
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Error : no active session found for the request \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+
+		var payload *Payload
+		for _, token := range []string{c.AuthToken, c.RefreshToken} {
+			if token == "" {
+				continue
+			}
+			p, err := authConfig.revokeToken(r, token)
+			if err != nil {
+				logger.ErrorF("unable to revoke token: %v", err)
+				continue
+			}
+			// the refresh token wins as it outlives the auth token
+			payload = p
+		}
+
+		if err := authConfig.NullifyTokens(w, r); err != nil {
+			logger.ErrorF("unable to nullify tokens: %v", err)
+		}
+
+		var identity *turboAuth.Identity
+		if payload != nil {
+			identity = &turboAuth.Identity{Subject: payload.Username, TokenID: payload.ID.String()}
+		}
+		if authConfig.OnLogout != nil && payload != nil {
+			if err := authConfig.OnLogout(w, r, payload); err != nil {
+				authConfig.audit(r, audit.EventLogout, identity, err)
+				httpError := &turboError.HttpError{
+					StatusCode: http.StatusInternalServerError,
+					Message:    "Error : logout hook failed \n",
+				}
+				httpError.GenerateError(w, r)
+				return
+			}
+		}
+		authConfig.audit(r, audit.EventLogout, identity, nil)
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,102 @@
+package jwt
+
+import (
+	"encoding/json"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"strings"
+)
+
+// AuthorizationServerMetadata is the authorization server metadata document of RFC 8414
+type AuthorizationServerMetadata struct {
+	Issuer                                    string   `json:"issuer"`
+	AuthorizationEndpoint                     string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                             string   `json:"token_endpoint,omitempty"`
+	JwksURI                                   string   `json:"jwks_uri,omitempty"`
+	RegistrationEndpoint                      string   `json:"registration_endpoint,omitempty"`
+	ScopesSupported                           []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported                    []string `json:"response_types_supported,omitempty"`
+	GrantTypesSupported                       []string `json:"grant_types_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported         []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	RevocationEndpoint                        string   `json:"revocation_endpoint,omitempty"`
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint,omitempty"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported,omitempty"`
+	CodeChallengeMethodsSupported             []string `json:"code_challenge_methods_supported,omitempty"`
+	DeviceAuthorizationEndpoint               string   `json:"device_authorization_endpoint,omitempty"`
+	// DPoPSigningAlgValuesSupported lists the algorithms of the DPoP proofs, RFC 9449
+	DPoPSigningAlgValuesSupported []string `json:"dpop_signing_alg_values_supported,omitempty"`
+	// TLSClientCertificateBoundAccessTokens advertises the certificate bound tokens, RFC 8705
+	TLSClientCertificateBoundAccessTokens bool `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+	// AccessTokenSigningAlgValuesSupported lists the algorithms of the keys signing the tokens, an additional
+	// metadata value as allowed by section 2 of RFC 8414
+	AccessTokenSigningAlgValuesSupported []string `json:"access_token_signing_alg_values_supported,omitempty"`
+}
+
+// Metadata completes the metadata with the configuration of the provider: the jwks_uri defaults to the
+// turboAuth.DefaultJWKSPath of the issuer when the KeyStore has public keys, the signing algorithms come from the
+// keys, and the grant types, the DPoP algorithms and the certificate binding from the enabled features. The
+// issuer and the urls the handlers are mounted at are set by the application, the values already set are kept
+func (authConfig *JwtAuthConfig) Metadata(metadata AuthorizationServerMetadata) *AuthorizationServerMetadata {
+	if authConfig.KeyStore != nil {
+		keys := PublicKeys(authConfig.KeyStore)
+		if metadata.JwksURI == "" && len(keys) > 0 && metadata.Issuer != "" {
+			metadata.JwksURI = strings.TrimSuffix(metadata.Issuer, "/") + turboAuth.DefaultJWKSPath
+		}
+		if metadata.AccessTokenSigningAlgValuesSupported == nil {
+			for _, key := range authConfig.KeyStore.Keys() {
+				metadata.AccessTokenSigningAlgValuesSupported = appendUnique(metadata.AccessTokenSigningAlgValuesSupported, key.SigningMethod)
+			}
+		}
+	} else if metadata.AccessTokenSigningAlgValuesSupported == nil && authConfig.SigningMethod != "" {
+		metadata.AccessTokenSigningAlgValuesSupported = []string{authConfig.SigningMethod}
+	}
+	if metadata.GrantTypesSupported == nil && metadata.TokenEndpoint != "" {
+		metadata.GrantTypesSupported = []string{TokenExchangeGrantType}
+		if authConfig.RefreshTokens != nil {
+			metadata.GrantTypesSupported = append(metadata.GrantTypesSupported, "refresh_token")
+		}
+	}
+	if metadata.IntrospectionEndpointAuthMethodsSupported == nil && metadata.IntrospectionEndpoint != "" {
+		metadata.IntrospectionEndpointAuthMethodsSupported = []string{"client_secret_basic"}
+	}
+	if metadata.DPoPSigningAlgValuesSupported == nil && authConfig.DPoP != nil {
+		metadata.DPoPSigningAlgValuesSupported = append([]string(nil), dpopMethods...)
+	}
+	if authConfig.CertificateBinding != nil {
+		metadata.TLSClientCertificateBoundAccessTokens = true
+	}
+	return &metadata
+}
+
+// MetadataHandler serves the metadata completed by Metadata, to be mounted at turboAuth.DefaultMetadataPath
+func (authConfig *JwtAuthConfig) MetadataHandler(metadata AuthorizationServerMetadata) http.Handler {
+	return authConfig.traceHandler("jwt.Metadata", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusMethodNotAllowed,
+				Message:    "Error : method not allowed \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+		// built on every request so that the rotated keys are advertised
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", turboAuth.DefaultJWKSCacheControl)
+		if err := json.NewEncoder(w).Encode(authConfig.Metadata(metadata)); err != nil {
+			logger.ErrorF("unable to write the metadata: %v", err)
+		}
+	}))
+}
+
+func appendUnique(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,115 @@
+package jwt
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/internal/secret"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type (
+	// CertificateBinding validates the certificate bound tokens (RFC 8705), a token carrying a cnf.x5t#S256 claim is
+	// only accepted over a mutual TLS connection authenticated with the certificate of that thumbprint
+	CertificateBinding struct {
+		// Required rejects the tokens which are not certificate bound
+		Required bool
+		// Header reads the client certificate from the request header set by the proxy terminating the TLS, e.g.
+		// X-SSL-Client-Cert with the url encoded PEM of nginx $ssl_client_escaped_cert. The header is trusted, it
+		// must be overwritten by the proxy. The certificate of the TLS connection is used when empty
+		Header string
+	}
+)
+
+const claimX5tS256 = "x5t#S256"
+
+var ErrInvalidCertificateBinding = errors.New("invalid certificate binding")
+
+func NewCertificateBinding() *CertificateBinding {
+	return &CertificateBinding{}
+}
+
+// CertificateThumbprint is the base64url SHA-256 thumbprint of the DER encoding of the certificate, the value of
+// the cnf.x5t#S256 claim
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ClientCertificate returns the certificate the client authenticated with, nil when there is none
+func (b *CertificateBinding) ClientCertificate(r *http.Request) (*x509.Certificate, error) {
+	if b.Header == "" {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return nil, nil
+		}
+		return r.TLS.PeerCertificates[0], nil
+	}
+	value := r.Header.Get(b.Header)
+	if value == "" {
+		return nil, nil
+	}
+	unescaped, err := url.QueryUnescape(value)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(unescaped))
+	if block == nil {
+		return nil, errors.New("the client certificate header is not a PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// validate checks the client certificate of the certificate bound tokens
+func (b *CertificateBinding) validate(r *http.Request, claims map[string]interface{}) error {
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	x5t, _ := cnf[claimX5tS256].(string)
+	if x5t == "" {
+		if b.Required {
+			return certificateError("token is not certificate bound")
+		}
+		return nil
+	}
+	cert, err := b.ClientCertificate(r)
+	if err != nil {
+		return certificateError(err.Error())
+	}
+	if cert == nil {
+		return certificateError("no client certificate presented")
+	}
+	if !secret.Equal(CertificateThumbprint(cert), x5t) {
+		return certificateError("certificate does not match the token confirmation")
+	}
+	return nil
+}
+
+// IssueCertificateBoundToken issues an auth token bound to the client certificate, e.g. the one of the mutual TLS
+// connection of the token request, see CertificateBinding
+func (authConfig *JwtAuthConfig) IssueCertificateBoundToken(username string, duration time.Duration, cert *x509.Certificate) (string, *turboError.JwtError) {
+	if cert == nil {
+		return "", turboError.NewJwtError(errors.New("a client certificate is required"), 400)
+	}
+	payload, err := newPayload(username, duration, authConfig.now())
+	if err != nil {
+		return "", turboError.NewJwtError(err, 406)
+	}
+	token, jwtErr := authConfig.signPayload(context.Background(), &extendedClaims{
+		Payload: *payload,
+		Cnf:     map[string]string{claimX5tS256: CertificateThumbprint(cert)},
+	})
+	identity := &turboAuth.Identity{Subject: username, TokenID: payload.ID.String()}
+	authConfig.audit(nil, audit.EventTokenIssued, identity, jwtErrOrNil(jwtErr))
+	return token, jwtErr
+}
+
+func certificateError(reason string) error {
+	return turboError.Wrap(turboError.ErrTokenInvalid, fmt.Errorf("%w: %s", ErrInvalidCertificateBinding, reason))
+}
@@ -0,0 +1,103 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestJwtAuthConfig_CertificateBinding(t *testing.T) {
+	clientCert, otherCert := newClientCertificate(t, "client"), newClientCertificate(t, "other")
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:         "test_key",
+		SigningMethod:      "HS256",
+		BearerTokens:       true,
+		CertificateBinding: NewCertificateBinding(),
+	})
+	token, jwtErr := authConfig.IssueCertificateBoundToken("test_user", time.Minute, clientCert)
+	if jwtErr != nil {
+		t.Fatalf("IssueCertificateBoundToken() error = %v", jwtErr)
+	}
+	escaped := url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCert.Raw})))
+
+	tests := []struct {
+		name   string
+		header string
+		cert   *x509.Certificate
+		value  string
+		valid  bool
+	}{
+		{name: "Test_tls_certificate", cert: clientCert, valid: true},
+		{name: "Test_other_certificate", cert: otherCert, valid: false},
+		{name: "Test_missing_certificate", valid: false},
+		{name: "Test_header_certificate", header: "X-SSL-Client-Cert", value: escaped, valid: true},
+		{name: "Test_malformed_header", header: "X-SSL-Client-Cert", value: "garbage", valid: false},
+		{name: "Test_ignored_header", value: escaped, valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authConfig.CertificateBinding.Header = tt.header
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/orders", nil)
+			r.Header.Set(authConfig.AuthTokenName, token)
+			if tt.cert != nil {
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+			}
+			if tt.value != "" {
+				r.Header.Set("X-SSL-Client-Cert", tt.value)
+			}
+			err := authConfig.HandleRequest(httptest.NewRecorder(), r)
+			if (err == nil) != tt.valid {
+				t.Fatalf("HandleRequest() error = %v, valid %v", err, tt.valid)
+			}
+			if err != nil && (err.Code != 401 || !errors.Is(err, ErrInvalidCertificateBinding)) {
+				t.Errorf("HandleRequest() error = %v (%v), want an invalid certificate binding", err, err.Code)
+			}
+		})
+	}
+
+	authConfig.CertificateBinding.Header = ""
+	bearer, _ := authConfig.IssueNewToken("test_user", time.Minute)
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/orders", nil)
+	r.Header.Set(authConfig.AuthTokenName, bearer)
+	if err := authConfig.HandleRequest(httptest.NewRecorder(), r); err != nil {
+		t.Errorf("HandleRequest() bearer token error = %v", err)
+	}
+	authConfig.CertificateBinding.Required = true
+	if err := authConfig.HandleRequest(httptest.NewRecorder(), r); err == nil {
+		t.Errorf("HandleRequest() bearer token accepted while the certificate binding is required")
+	}
+}
+
+func newClientCertificate(t *testing.T, name string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
@@ -0,0 +1,438 @@
+package jwt
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/extractor"
+	"github.com/nandlabs/turbo-auth/metrics"
+	"github.com/nandlabs/turbo-auth/resilience"
+	"net/http"
+	"time"
+)
+
+// JwtAuthenticator is the immutable authenticator built by NewJwtAuthenticator, its configuration cannot be
+// changed once validated. It is safe for concurrent use by multiple goroutines, keys are rotated through the
+// KeyStore (see KeyManager) rather than by changing the authenticator
+type JwtAuthenticator struct {
+	config *JwtAuthConfig
+}
+
+// NewJwtAuthenticator builds and validates the authenticator, bearer tokens signed with HS256 are used
+// unless the options say otherwise
+func NewJwtAuthenticator(opts ...Option) (*JwtAuthenticator, error) {
+	config := &JwtAuthConfig{
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+	return &JwtAuthenticator{config: defaultOptions(config)}, nil
+}
+
+func validateConfig(config *JwtAuthConfig) error {
+	switch config.TokenMode {
+	case "", ModeSign:
+	case ModeEncrypt, ModeSignEncrypt:
+		if config.Encryption == nil {
+			return errors.New("jwt: token mode " + string(config.TokenMode) + " requires encryption")
+		}
+	default:
+		return errors.New("jwt: unknown token mode " + string(config.TokenMode))
+	}
+	if config.KeyStore == nil && config.TokenMode != ModeEncrypt {
+		if config.SigningKey == "" {
+			return errors.New("jwt: a signing key or a key store is required")
+		}
+		if _, err := getSigningMethod(config.SigningMethod); err != nil {
+			return err
+		}
+	}
+	if config.Encryption != nil {
+		if err := config.Encryption.validate(); err != nil {
+			return err
+		}
+	}
+	if config.AuthTokenValidTime < 0 || config.RefreshTokenValidTime < 0 {
+		return errors.New("jwt: token ttl cannot be negative")
+	}
+	if config.RefreshTokenValidTime > 0 && config.RefreshTokenValidTime < config.AuthTokenValidTime {
+		return errors.New("jwt: refresh token ttl cannot be shorter than the auth token ttl")
+	}
+	if config.RefreshFamilyLifetime < 0 {
+		return errors.New("jwt: refresh token family lifetime cannot be negative")
+	}
+	if config.SlidingWindow < 0 || config.SlidingMaxLifetime < 0 || config.Leeway < 0 {
+		return errors.New("jwt: sliding window and leeway cannot be negative")
+	}
+	if config.DeviceBinding != nil && len(config.DeviceBinding.Key) == 0 {
+		return ErrDeviceBindingKey
+	}
+	if config.BreakGlass != nil && config.AuditLogger == nil {
+		return errors.New("jwt: break-glass tokens require an audit logger")
+	}
+	return nil
+}
+
+// WithSigningKey sets the HMAC secret the tokens are signed with
+func WithSigningKey(signingKey string) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.SigningKey = signingKey
+	}
+}
+
+// WithSigningMethod sets the algorithm used with the signing key, HS256 by default
+func WithSigningMethod(signingMethod string) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.SigningMethod = signingMethod
+	}
+}
+
+// WithTokenTTL sets the validity of the auth and refresh tokens, a zero value keeps the default
+func WithTokenTTL(authTokenValidTime, refreshTokenValidTime time.Duration) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.AuthTokenValidTime = authTokenValidTime
+		authConfig.RefreshTokenValidTime = refreshTokenValidTime
+	}
+}
+
+// WithCookieMode carries the tokens in HttpOnly cookies instead of the bearer headers
+func WithCookieMode() Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.BearerTokens = false
+	}
+}
+
+// WithTokenNames overrides the header or cookie names of the tokens
+func WithTokenNames(authTokenName, refreshTokenName string) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.AuthTokenName = authTokenName
+		authConfig.RefreshTokenName = refreshTokenName
+	}
+}
+
+// WithTokenExtractor reads the tokens with the extractors instead of the headers or cookies named by the token
+// names, refreshExtractor may be nil
+func WithTokenExtractor(authExtractor, refreshExtractor extractor.Extractor) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.TokenExtractor = authExtractor
+		authConfig.RefreshTokenExtractor = refreshExtractor
+	}
+}
+
+// WithClock sets the source of time used for issuing and validating tokens
+func WithClock(clock Clock) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.Clock = clock
+	}
+}
+
+// WithLeeway tolerates clock skew when validating the time claims
+func WithLeeway(leeway time.Duration) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.Leeway = leeway
+	}
+}
+
+func WithSlidingWindow(window time.Duration) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.SlidingWindow = window
+	}
+}
+
+// WithSlidingMaxLifetime stops sliding the tokens once the lifetime has passed since the login
+func WithSlidingMaxLifetime(maxLifetime time.Duration) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.SlidingMaxLifetime = maxLifetime
+	}
+}
+
+func WithKeyStore(keyStore KeyStore) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.KeyStore = keyStore
+	}
+}
+
+// WithEncryption issues signed then encrypted tokens, see NewRSAEncryption and NewECDHEncryption
+func WithEncryption(encryption *Encryption) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.Encryption = encryption
+	}
+}
+
+// WithTokenMode enforces how the tokens are protected, ModeEncrypt and ModeSignEncrypt require WithEncryption
+func WithTokenMode(mode TokenMode) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.TokenMode = mode
+	}
+}
+
+// WithDPoP validates the DPoP proofs of the sender constrained tokens, see NewDPoP
+func WithDPoP(dpop *DPoP) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.DPoP = dpop
+	}
+}
+
+// WithCertificateBinding validates the client certificate of the certificate bound tokens, see
+// NewCertificateBinding
+func WithCertificateBinding(binding *CertificateBinding) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.CertificateBinding = binding
+	}
+}
+
+// WithExchangeAudiences allows ExchangeToken to issue tokens for the audiences
+func WithExchangeAudiences(audiences ...string) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.ExchangeAudiences = audiences
+	}
+}
+
+// WithTTLPolicy selects the lifetimes of the tokens at issuance, e.g. TTLByRole
+func WithTTLPolicy(policy TTLPolicy) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.TTLPolicy = policy
+	}
+}
+
+// WithBreakGlass accepts the break-glass tokens verified by the key, an AuditLogger is required, see BreakGlass
+func WithBreakGlass(breakGlass *BreakGlass) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.BreakGlass = breakGlass
+	}
+}
+
+// WithClaimsCompression deflates the claims of the issued tokens larger than the threshold in bytes, e.g. the
+// DefaultCompressionThreshold, keeping the tokens carrying many roles under the header size limits of the proxies
+func WithClaimsCompression(threshold int) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.CompressionThreshold = threshold
+	}
+}
+
+// WithLimits replaces the default Limits of the accepted tokens
+func WithLimits(limits *Limits) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.Limits = limits
+	}
+}
+
+// WithBodyDigest verifies the body of the requests against their digest, see BodyDigest
+func WithBodyDigest(bodyDigest *BodyDigest) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.BodyDigest = bodyDigest
+	}
+}
+
+// WithTenants verifies the tokens with the keys and rules of their tenant, selected by the header when not empty
+// and by the iss claim otherwise
+func WithTenants(tenants *TenantRegistry, tenantHeader string) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.Tenants = tenants
+		authConfig.TenantHeader = tenantHeader
+	}
+}
+
+// WithClaimsType decodes the validated tokens into the claims struct returned by the factory, the handlers read it
+// from the TypedClaims of the identity instead of the claims map
+func WithClaimsType(factory ClaimsFactory) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.ClaimsType = factory
+	}
+}
+
+// WithSerializer encodes and decodes the TypedClaims with the serializer, e.g. one delegating to a faster json
+// library
+func WithSerializer(serializer Serializer) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.Serializer = serializer
+	}
+}
+
+// WithClaimsMapper transforms the identity of every validated token before the request proceeds
+func WithClaimsMapper(mapper turboAuth.ClaimsMapper) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.ClaimsMapper = mapper
+	}
+}
+
+// WithValidationCache caches the claims of up to size validated tokens for at most ttl
+func WithValidationCache(size int, ttl time.Duration) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.ValidationCache = NewValidationCache(size, ttl)
+	}
+}
+
+func WithRevoker(revoker Revoker) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.Revoker = revoker
+	}
+}
+
+// WithRevokerPolicy guards the lookups of the Revoker, see resilience.Policy
+func WithRevokerPolicy(policy *resilience.Policy) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.RevokerPolicy = policy
+	}
+}
+
+func WithAuditLogger(auditLogger audit.AuditLogger) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.AuditLogger = auditLogger
+	}
+}
+
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.Metrics = m
+	}
+}
+
+// WithImpersonationPolicy enables Impersonate for the actors allowed by the policy to impersonate the targets
+// returned by the lookup, see AllowImpersonation
+func WithImpersonationPolicy(policy ImpersonationPolicy, targets ImpersonationTargetLookup) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.ImpersonationPolicy = policy
+		authConfig.ImpersonationTargets = targets
+	}
+}
+
+// WithDeviceBinding rejects the tokens bound to another device than the one of the request, see NewDeviceBinding
+func WithDeviceBinding(binding *DeviceBinding) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.DeviceBinding = binding
+	}
+}
+
+// WithRefreshTokens tracks the refresh tokens in the store so that Refresh rotates them and detects their reuse
+func WithRefreshTokens(store RefreshTokenStore) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.RefreshTokens = store
+	}
+}
+
+// WithRefreshFamilyLifetime ends the refresh token families once the lifetime has passed since the login, whatever
+// the rotations
+func WithRefreshFamilyLifetime(lifetime time.Duration) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.RefreshFamilyLifetime = lifetime
+	}
+}
+
+func WithErrorWriter(errorWriter turboError.ErrorWriter) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.ErrorWriter = errorWriter
+	}
+}
+
+func WithLogoutHook(hook LogoutHook) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.OnLogout = hook
+	}
+}
+
+// Config returns a copy of the validated configuration, changing it does not affect the authenticator
+func (a *JwtAuthenticator) Config() *JwtAuthConfig {
+	config := *a.config
+	return &config
+}
+
+func (a *JwtAuthenticator) Apply(next http.Handler) http.Handler {
+	return a.config.Apply(next)
+}
+
+func (a *JwtAuthenticator) HandleRequest(w http.ResponseWriter, r *http.Request) *turboError.JwtError {
+	return a.config.HandleRequest(w, r)
+}
+
+func (a *JwtAuthenticator) Authenticate(token string) (*turboAuth.Identity, error) {
+	return a.config.Authenticate(token)
+}
+
+func (a *JwtAuthenticator) AuthenticateContext(ctx context.Context, token string) (*turboAuth.Identity, error) {
+	return a.config.AuthenticateContext(ctx, token)
+}
+
+func (a *JwtAuthenticator) AuthenticateRequest(r *http.Request, token string) (*turboAuth.Identity, error) {
+	return a.config.AuthenticateRequest(r, token)
+}
+
+func (a *JwtAuthenticator) IssueNewToken(username string, duration time.Duration) (string, *turboError.JwtError) {
+	return a.config.IssueNewToken(username, duration)
+}
+
+func (a *JwtAuthenticator) IssueNewTokenContext(ctx context.Context, username string, duration time.Duration) (string, *turboError.JwtError) {
+	return a.config.IssueNewTokenContext(ctx, username, duration)
+}
+
+func (a *JwtAuthenticator) WriteTokens(w http.ResponseWriter, authToken string, refreshToken string) {
+	a.config.WriteTokens(w, authToken, refreshToken)
+}
+
+func (a *JwtAuthenticator) NullifyTokens(w http.ResponseWriter, r *http.Request) error {
+	return a.config.NullifyTokens(w, r)
+}
+
+func (a *JwtAuthenticator) LogoutHandler() http.Handler {
+	return a.config.LogoutHandler()
+}
+
+func (a *JwtAuthenticator) JWKSHandler() http.Handler {
+	return a.config.JWKSHandler()
+}
+
+func (a *JwtAuthenticator) MetadataHandler(metadata AuthorizationServerMetadata) http.Handler {
+	return a.config.MetadataHandler(metadata)
+}
+
+func (a *JwtAuthenticator) IntrospectionHandler(callerAuthenticator CallerAuthenticator) http.Handler {
+	return a.config.IntrospectionHandler(callerAuthenticator)
+}
+
+func (a *JwtAuthenticator) ExchangeToken(request *TokenExchangeRequest) (*TokenExchangeResponse, *turboError.JwtError) {
+	return a.config.ExchangeToken(request)
+}
+
+func (a *JwtAuthenticator) TokenExchangeHandler(callerAuthenticator CallerAuthenticator) http.Handler {
+	return a.config.TokenExchangeHandler(callerAuthenticator)
+}
+
+func (a *JwtAuthenticator) IssueDPoPBoundToken(username string, duration time.Duration, jkt string) (string, *turboError.JwtError) {
+	return a.config.IssueDPoPBoundToken(username, duration, jkt)
+}
+
+func (a *JwtAuthenticator) IssueCertificateBoundToken(username string, duration time.Duration, cert *x509.Certificate) (string, *turboError.JwtError) {
+	return a.config.IssueCertificateBoundToken(username, duration, cert)
+}
+
+func (a *JwtAuthenticator) RevokeAllTokens(r *http.Request, subject string) error {
+	return a.config.RevokeAllTokens(r, subject)
+}
+
+func (a *JwtAuthenticator) Refresh(r *http.Request, refreshToken string) (*TokenPair, *turboError.JwtError) {
+	return a.config.Refresh(r, refreshToken)
+}
+
+func (a *JwtAuthenticator) RefreshHandler() http.Handler {
+	return a.config.RefreshHandler()
+}
+
+func (a *JwtAuthenticator) IssueTypedToken(username string, duration time.Duration, claims TypedClaims) (string, *turboError.JwtError) {
+	return a.config.IssueTypedToken(username, duration, claims)
+}
+
+func (a *JwtAuthenticator) ValidateTokens(tokens []string) []TokenResult {
+	return a.config.ValidateTokens(tokens)
+}
+
+func (a *JwtAuthenticator) ValidateTokensContext(ctx context.Context, tokens []string) []TokenResult {
+	return a.config.ValidateTokensContext(ctx, tokens)
+}
@@ -0,0 +1,59 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewJwtAuthenticator(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{"defaults", []Option{WithSigningKey("test_key")}, false},
+		{"missing signing key", nil, true},
+		{"unsupported signing method", []Option{WithSigningKey("test_key"), WithSigningMethod("none")}, true},
+		{"refresh shorter than auth", []Option{WithSigningKey("test_key"), WithTokenTTL(time.Hour, time.Minute)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewJwtAuthenticator(tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewJwtAuthenticator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJwtAuthenticator_immutable(t *testing.T) {
+	issuedAt := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	authenticator, err := NewJwtAuthenticator(
+		WithSigningKey("test_key"),
+		WithCookieMode(),
+		WithClock(ClockFunc(func() time.Time { return issuedAt })),
+	)
+	if err != nil {
+		t.Fatalf("NewJwtAuthenticator() error = %v", err)
+	}
+	config := authenticator.Config()
+	if config.BearerTokens || config.AuthTokenName == "" {
+		t.Errorf("Config() = %+v, want cookie mode with defaults", config)
+	}
+	config.SigningKey = "changed"
+	if authenticator.Config().SigningKey != "test_key" {
+		t.Errorf("Config() change leaked into the authenticator")
+	}
+
+	token, jwtErr := authenticator.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	identity, err := authenticator.Authenticate(token)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if identity.Claims["IssuedAt"] != issuedAt.Format(time.RFC3339Nano) {
+		t.Errorf("IssuedAt = %v, want %v", identity.Claims["IssuedAt"], issuedAt)
+	}
+}
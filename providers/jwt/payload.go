@@ -2,7 +2,10 @@ package jwt
 
 import (
 	"errors"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"strings"
 	"time"
 )
 
@@ -11,9 +14,28 @@ type Payload struct {
 	Username  string
 	IssuedAt  time.Time
 	ExpiredAt time.Time
+	// Version is the token version of the subject at issuance, see TokenVersioner
+	Version int64 `json:"ver,omitempty"`
+	// TokenUse tells the auth tokens from the refresh tokens, TokenUseAccess or TokenUseRefresh
+	TokenUse string `json:"token_use,omitempty"`
 }
 
+const (
+	// ClaimTokenUse is the claim of the token type, only the access tokens authenticate the requests and only the
+	// refresh tokens are accepted by Refresh
+	ClaimTokenUse   = "token_use"
+	TokenUseAccess  = "access"
+	TokenUseRefresh = "refresh"
+)
+
+// ErrTokenUse is returned when a refresh token is presented as an auth token or the other way round
+var ErrTokenUse = errors.New("token_use not accepted")
+
 func NewPayload(username string, duration time.Duration) (*Payload, error) {
+	return newPayload(username, duration, time.Now())
+}
+
+func newPayload(username string, duration time.Duration, now time.Time) (*Payload, error) {
 	token, err := uuid.NewUUID()
 	if err != nil {
 		return nil, err
@@ -27,15 +49,62 @@ func NewPayload(username string, duration time.Duration) (*Payload, error) {
 	payload := &Payload{
 		ID:        token,
 		Username:  username,
-		IssuedAt:  time.Now(),
-		ExpiredAt: time.Now().Add(duration),
+		IssuedAt:  now,
+		ExpiredAt: now.Add(duration),
+		TokenUse:  TokenUseAccess,
 	}
 	return payload, nil
 }
 
+// StandardPayload returns the payload, the claims structs embedding it implement TypedClaims
+func (payload *Payload) StandardPayload() *Payload {
+	return payload
+}
+
 func (payload *Payload) Valid() error {
 	if time.Now().After(payload.ExpiredAt) {
 		return errors.New("token has expired")
 	}
 	return nil
 }
+
+// tokenUseOf returns the token_use claim, the tokens issued before the claim are auth tokens unless they belong to a
+// refresh token family
+func tokenUseOf(claims jwt.MapClaims) string {
+	if use, ok := claims[ClaimTokenUse].(string); ok {
+		return use
+	}
+	if _, ok := claims[ClaimFamily]; ok {
+		return TokenUseRefresh
+	}
+	return TokenUseAccess
+}
+
+// newIdentity maps the claims of a validated token to the identity of the request
+func newIdentity(claims jwt.MapClaims) *turboAuth.Identity {
+	identity := &turboAuth.Identity{
+		Claims: claims,
+	}
+	identity.Subject, _ = claims["Username"].(string)
+	identity.TokenID, _ = claims["ID"].(string)
+	identity.ExpiresAt, _ = timeClaim(claims, "ExpiredAt", "exp")
+	identity.Roles = stringsClaim(claims["Roles"])
+	if scope, ok := claims["scope"].(string); ok {
+		identity.Scopes = strings.Fields(scope)
+	} else {
+		identity.Scopes = stringsClaim(claims["Scopes"])
+	}
+	return identity
+}
+
+func stringsClaim(claim interface{}) []string {
+	var values []string
+	if list, ok := claim.([]interface{}); ok {
+		for _, item := range list {
+			if value, ok := item.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,84 @@
+package jwt
+
+import (
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// TestJwtAuthConfig_roundtrip checks that the issued claims are the validated ones, compressed or not
+func TestJwtAuthConfig_roundtrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{name: "Test_signed"},
+		{name: "Test_compressed", options: []Option{WithClaimsCompression(64)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+				SigningKey:    "test_key",
+				SigningMethod: "HS256",
+				BearerTokens:  true,
+			}, tt.options...)
+			roundtrip := func(username string, roles []string) bool {
+				if username == "" {
+					return true
+				}
+				pair, jwtErr := authConfig.IssueTokenPair(username, roles)
+				if jwtErr != nil {
+					t.Logf("IssueTokenPair(%q) error = %v", username, jwtErr)
+					return false
+				}
+				identity, err := authConfig.Authenticate(pair.AuthToken)
+				if err != nil {
+					t.Logf("Authenticate() error = %v", err)
+					return false
+				}
+				if len(roles) == 0 {
+					roles = nil
+				}
+				return identity.Subject == username && reflect.DeepEqual(identity.Roles, roles) &&
+					identity.ExpiresAt.After(time.Now())
+			}
+			if err := quick.Check(roundtrip, nil); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestJwtAuthConfig_typedRoundtrip checks that the typed claims decode to the issued struct
+func TestJwtAuthConfig_typedRoundtrip(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	}, WithClaimsType(func() TypedClaims {
+		return &orderClaims{}
+	}), WithClaimsCompression(64))
+	roundtrip := func(roles []string, tenantID string) bool {
+		if len(roles) == 0 {
+			roles = nil
+		}
+		issued := &orderClaims{Roles: roles, TenantID: tenantID}
+		token, jwtErr := authConfig.IssueTypedToken("test_user", time.Minute, issued)
+		if jwtErr != nil {
+			t.Logf("IssueTypedToken() error = %v", jwtErr)
+			return false
+		}
+		identity, err := authConfig.Authenticate(token)
+		if err != nil {
+			t.Logf("Authenticate() error = %v", err)
+			return false
+		}
+		validated, ok := identity.TypedClaims.(*orderClaims)
+		return ok && reflect.DeepEqual(validated.Roles, issued.Roles) && validated.TenantID == issued.TenantID &&
+			validated.ID == issued.ID && validated.ExpiredAt.Equal(issued.ExpiredAt)
+	}
+	if err := quick.Check(roundtrip, nil); err != nil {
+		t.Error(err)
+	}
+}
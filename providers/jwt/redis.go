@@ -0,0 +1,103 @@
+package jwt
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+const DefaultRedisRevokerKeyPrefix = "turbo-auth:revoked:"
+
+// RedisRevoker shares the revoked token ids across instances, the entries expire along with their tokens
+type RedisRevoker struct {
+	Client    redis.UniversalClient
+	KeyPrefix string
+}
+
+func NewRedisRevoker(client redis.UniversalClient) *RedisRevoker {
+	return &RedisRevoker{
+		Client:    client,
+		KeyPrefix: DefaultRedisRevokerKeyPrefix,
+	}
+}
+
+// Revoke keeps the jti until expiresAt, forever when expiresAt is zero
+func (r *RedisRevoker) Revoke(jti string, expiresAt time.Time) error {
+	return r.RevokeContext(context.Background(), jti, expiresAt)
+}
+
+func (r *RedisRevoker) RevokeContext(ctx context.Context, jti string, expiresAt time.Time) error {
+	var ttl time.Duration
+	if !expiresAt.IsZero() {
+		if ttl = time.Until(expiresAt); ttl <= 0 {
+			return nil
+		}
+	}
+	return r.Client.Set(ctx, r.KeyPrefix+jti, expiresAt.Unix(), ttl).Err()
+}
+
+func (r *RedisRevoker) IsRevoked(jti string) (bool, error) {
+	return r.IsRevokedContext(context.Background(), jti)
+}
+
+func (r *RedisRevoker) IsRevokedContext(ctx context.Context, jti string) (bool, error) {
+	n, err := r.Client.Exists(ctx, r.KeyPrefix+jti).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// RevokeSubject keeps the revocation of the subject forever, later revocations replace the earlier ones
+func (r *RedisRevoker) RevokeSubject(subject string, issuedBefore time.Time) error {
+	return r.RevokeSubjectContext(context.Background(), subject, issuedBefore)
+}
+
+func (r *RedisRevoker) RevokeSubjectContext(ctx context.Context, subject string, issuedBefore time.Time) error {
+	return r.Client.Set(ctx, r.KeyPrefix+"sub:"+subject, issuedBefore.UnixNano(), 0).Err()
+}
+
+func (r *RedisRevoker) IsSubjectRevoked(subject string, issuedAt time.Time) (bool, error) {
+	return r.IsSubjectRevokedContext(context.Background(), subject, issuedAt)
+}
+
+func (r *RedisRevoker) IsSubjectRevokedContext(ctx context.Context, subject string, issuedAt time.Time) (bool, error) {
+	value, err := r.Client.Get(ctx, r.KeyPrefix+"sub:"+subject).Result()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	issuedBefore, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	return issuedAt.Before(time.Unix(0, issuedBefore)), nil
+}
+
+func (r *RedisRevoker) TokenVersion(subject string) (int64, error) {
+	return r.TokenVersionContext(context.Background(), subject)
+}
+
+func (r *RedisRevoker) TokenVersionContext(ctx context.Context, subject string) (int64, error) {
+	version, err := r.Client.Get(ctx, r.KeyPrefix+"ver:"+subject).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+// BumpTokenVersion increments the version atomically, the version is kept forever
+func (r *RedisRevoker) BumpTokenVersion(subject string) (int64, error) {
+	return r.BumpTokenVersionContext(context.Background(), subject)
+}
+
+func (r *RedisRevoker) BumpTokenVersionContext(ctx context.Context, subject string) (int64, error) {
+	return r.Client.Incr(ctx, r.KeyPrefix+"ver:"+subject).Result()
+}
+
+// Check pings the redis server, see health.Checker
+func (r *RedisRevoker) Check(ctx context.Context) error {
+	return r.Client.Ping(ctx).Err()
+}
@@ -0,0 +1,275 @@
+package jwt
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// RefreshToken is the record of an issued refresh token, the tokens rotated from the same login share the Family
+	RefreshToken struct {
+		JTI       string
+		Family    string
+		Subject   string
+		ExpiresAt time.Time
+		Consumed  bool
+	}
+
+	// RefreshTokenStore remembers the issued refresh tokens until they expire, e.g. stores/sql.RefreshTokenStore
+	RefreshTokenStore interface {
+		Save(token *RefreshToken) error
+		// Consume marks the token as used and returns it, the update must be atomic so that concurrent refreshes with
+		// the same token cannot both succeed. ErrRefreshTokenReused is returned along with the token when it was
+		// already used, ErrRefreshTokenUnknown when it was never saved, has expired or has been revoked
+		Consume(jti string) (*RefreshToken, error)
+		// RevokeFamily deletes the tokens of the family
+		RevokeFamily(family string) error
+	}
+
+	// ContextRefreshTokenStore is implemented by the RefreshTokenStores backed by a network store, the context of the
+	// request is passed to them so that its deadline and cancellation apply
+	ContextRefreshTokenStore interface {
+		SaveContext(ctx context.Context, token *RefreshToken) error
+		ConsumeContext(ctx context.Context, jti string) (*RefreshToken, error)
+		RevokeFamilyContext(ctx context.Context, family string) error
+	}
+
+	// MemoryRefreshTokenStore is an in-memory RefreshTokenStore suitable for single instance deployments
+	MemoryRefreshTokenStore struct {
+		// MaxTokens bounds the tracked tokens, the oldest ones are dropped once full and cannot be refreshed anymore,
+		// DefaultMaxRefreshTokens when 0
+		MaxTokens int
+
+		mutex  sync.Mutex
+		tokens map[string]*list.Element
+		// order holds the tokens in the order they were saved
+		order *list.List
+		// purgedAt is the last purge of the expired tokens, they are purged at most once per refreshPurgeInterval
+		purgedAt time.Time
+	}
+)
+
+const (
+	// ClaimFamily is the family of the refresh tokens
+	ClaimFamily = "fam"
+	// ClaimFamilyExpiry is the end of the family of the refresh tokens, see RefreshFamilyLifetime
+	ClaimFamilyExpiry = "fexp"
+)
+
+const (
+	DefaultMaxRefreshTokens = 100000
+	// refreshPurgeInterval spaces the scans of the tokens for the expired ones
+	refreshPurgeInterval = time.Minute
+)
+
+var (
+	ErrRefreshTokenUnknown = errors.New("unknown refresh token")
+	// ErrRefreshTokenReused is returned when a rotated token is presented again, the token has leaked and its family
+	// is revoked
+	ErrRefreshTokenReused = errors.New("refresh token already used")
+	// ErrRefreshUnsupported is returned by Refresh when no RefreshTokens store is configured
+	ErrRefreshUnsupported = errors.New("refresh tokens are not tracked")
+	// ErrRefreshFamilyExpired is returned when the family of the refresh token has reached its RefreshFamilyLifetime
+	ErrRefreshFamilyExpired = errors.New("refresh token family expired")
+)
+
+func NewMemoryRefreshTokenStore() *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{
+		MaxTokens: DefaultMaxRefreshTokens,
+		tokens:    make(map[string]*list.Element),
+		order:     list.New(),
+	}
+}
+
+func (m *MemoryRefreshTokenStore) Save(token *RefreshToken) error {
+	maxTokens := m.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = DefaultMaxRefreshTokens
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if now := time.Now(); now.Sub(m.purgedAt) >= refreshPurgeInterval {
+		for element := m.order.Front(); element != nil; {
+			next := element.Next()
+			if now.After(element.Value.(*RefreshToken).ExpiresAt) {
+				m.remove(element)
+			}
+			element = next
+		}
+		m.purgedAt = now
+	}
+	if element, ok := m.tokens[token.JTI]; ok {
+		m.remove(element)
+	}
+	saved := *token
+	m.tokens[token.JTI] = m.order.PushBack(&saved)
+	for m.order.Len() > maxTokens {
+		m.remove(m.order.Front())
+	}
+	return nil
+}
+
+func (m *MemoryRefreshTokenStore) Consume(jti string) (*RefreshToken, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	element, ok := m.tokens[jti]
+	if !ok || time.Now().After(element.Value.(*RefreshToken).ExpiresAt) {
+		return nil, ErrRefreshTokenUnknown
+	}
+	saved := element.Value.(*RefreshToken)
+	token := *saved
+	if token.Consumed {
+		return &token, ErrRefreshTokenReused
+	}
+	saved.Consumed, token.Consumed = true, true
+	return &token, nil
+}
+
+func (m *MemoryRefreshTokenStore) RevokeFamily(family string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for element := m.order.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*RefreshToken).Family == family {
+			m.remove(element)
+		}
+		element = next
+	}
+	return nil
+}
+
+// remove drops the token, caller must hold the lock
+func (m *MemoryRefreshTokenStore) remove(element *list.Element) {
+	m.order.Remove(element)
+	delete(m.tokens, element.Value.(*RefreshToken).JTI)
+}
+
+// saveRefreshToken and the following helpers call the Context variant of the method when the store implements it
+func saveRefreshToken(ctx context.Context, store RefreshTokenStore, token *RefreshToken) error {
+	if contextStore, ok := store.(ContextRefreshTokenStore); ok {
+		return contextStore.SaveContext(ctx, token)
+	}
+	return store.Save(token)
+}
+
+func consumeRefreshToken(ctx context.Context, store RefreshTokenStore, jti string) (*RefreshToken, error) {
+	if contextStore, ok := store.(ContextRefreshTokenStore); ok {
+		return contextStore.ConsumeContext(ctx, jti)
+	}
+	return store.Consume(jti)
+}
+
+func revokeRefreshFamily(ctx context.Context, store RefreshTokenStore, family string) error {
+	if contextStore, ok := store.(ContextRefreshTokenStore); ok {
+		return contextStore.RevokeFamilyContext(ctx, family)
+	}
+	return store.RevokeFamily(family)
+}
+
+// Refresh exchanges the refresh token for a new pair, the refresh token is rotated: it is consumed and the new one
+// joins its family. Presenting a consumed token again revokes the family, so that both the thief and the victim of
+// a leaked token have to log in again (OAuth 2.0 Security BCP, 4.14)
+func (authConfig *JwtAuthConfig) Refresh(r *http.Request, refreshToken string) (*TokenPair, *turboError.JwtError) {
+	if authConfig.RefreshTokens == nil {
+		return nil, turboError.NewJwtError(ErrRefreshUnsupported, 501)
+	}
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	identity, jwtErr := authConfig.validateCredentials(ctx, r, &Credentials{AuthToken: refreshToken}, TokenUseRefresh)
+	if jwtErr != nil {
+		return nil, turboError.NewJwtError(jwtErr.Err, 401)
+	}
+	family, _ := identity.Claims[ClaimFamily].(string)
+	if family == "" {
+		// a refresh token issued before the store was configured
+		err := turboError.Wrap(turboError.ErrTokenInvalid, ErrRefreshTokenUnknown)
+		authConfig.audit(r, audit.EventTokenRefresh, identity, err)
+		return nil, turboError.NewJwtError(err, 401)
+	}
+	if _, err := consumeRefreshToken(ctx, authConfig.RefreshTokens, identity.TokenID); err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			authConfig.revokeFamily(ctx, r, identity, family)
+		}
+		authConfig.audit(r, audit.EventTokenRefresh, identity, err)
+		return nil, turboError.NewJwtError(turboError.Wrap(turboError.ErrTokenRevoked, err), 401)
+	}
+	// the acr, amr and auth_time of the login are carried over so that the rotated pair is not stepped down
+	carried := authentication(identity)
+	if binding := device(identity); binding != nil {
+		if r != nil && authConfig.DeviceBinding != nil {
+			// the device was verified, the pair is bound to its current user agent
+			binding = authConfig.DeviceBinding.Bind(r, carried)
+		} else if carried != nil {
+			binding.ACR, binding.AMR, binding.Time = carried.ACR, carried.AMR, carried.Time
+		}
+		carried = binding
+	}
+	// the rotated pair is capped at the end of the family, the families issued without it end from now on
+	familyExpiry, _ := timeClaim(identity.Claims, ClaimFamilyExpiry)
+	pair, jwtErr := authConfig.issueTokenPair(ctx, identity.Subject, identity.Roles, carried, family, familyExpiry)
+	authConfig.audit(r, audit.EventTokenRefresh, identity, jwtErrOrNil(jwtErr))
+	return pair, jwtErr
+}
+
+// revokeFamily revokes the tokens rotated from the reused one and records the reuse
+func (authConfig *JwtAuthConfig) revokeFamily(ctx context.Context, r *http.Request, identity *turboAuth.Identity, family string) {
+	logger.WarnF("refresh token %s of %s reused, revoking its family", identity.TokenID, identity.Subject)
+	err := revokeRefreshFamily(ctx, authConfig.RefreshTokens, family)
+	if err != nil {
+		logger.ErrorF("unable to revoke the refresh token family %s: %v", family, err)
+	}
+	if authConfig.AuditLogger != nil {
+		event := audit.NewEvent(r, audit.EventTokenReuse, "jwt", err)
+		event.Subject, event.TokenID = identity.Subject, identity.TokenID
+		event.Reason = "refresh token reused, family " + family + " revoked"
+		if err != nil {
+			event.Reason = "refresh token reused, unable to revoke family " + family + ": " + err.Error()
+		}
+		authConfig.AuditLogger.Log(event)
+	}
+}
+
+// RefreshHandler rotates the refresh token of the request, read like the LogoutHandler does, and writes the new pair
+// with WriteTokens
+func (authConfig *JwtAuthConfig) RefreshHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			turboError.WriteError(authConfig.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: http.StatusMethodNotAllowed,
+				Message:    "Error : method not allowed \n",
+			})
+			return
+		}
+		var c Credentials
+		if err := authConfig.fetchCredsFromRequest(r, &c); err != nil || c.RefreshToken == "" {
+			turboError.WriteError(authConfig.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Error : refresh token is required \n",
+				Err:        turboError.ErrMissingToken,
+			})
+			return
+		}
+		pair, jwtErr := authConfig.Refresh(r, c.RefreshToken)
+		if jwtErr != nil {
+			turboError.WriteError(authConfig.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: jwtErr.Code,
+				Message:    "Error : " + jwtErr.Error() + " \n",
+				Err:        jwtErr,
+			})
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		authConfig.WriteTokens(w, pair.AuthToken, pair.RefreshToken)
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,240 @@
+package jwt
+
+import (
+	"errors"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestJwtAuthConfig_Refresh(t *testing.T) {
+	var reuses []*audit.Event
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		RefreshTokens: NewMemoryRefreshTokenStore(),
+		AuditLogger: audit.AuditLoggerFunc(func(event *audit.Event) {
+			if event.Type == audit.EventTokenReuse {
+				reuses = append(reuses, event)
+			}
+		}),
+	})
+	login, jwtErr := authConfig.IssueTokenPair("test_user", []string{"admin"})
+	if jwtErr != nil {
+		t.Fatalf("IssueTokenPair() error = %v", jwtErr)
+	}
+	rotated, jwtErr := authConfig.Refresh(nil, login.RefreshToken)
+	if jwtErr != nil {
+		t.Fatalf("Refresh() error = %v", jwtErr)
+	}
+	identity, err := authConfig.Authenticate(rotated.AuthToken)
+	if err != nil || !identity.HasRole("admin") {
+		t.Errorf("Authenticate() of the refreshed token = %+v, %v, want the admin role", identity, err)
+	}
+	if _, err := authConfig.Authenticate(rotated.RefreshToken); !errors.Is(err, ErrTokenUse) {
+		t.Errorf("Authenticate() of the refresh token error = %v, want %v", err, ErrTokenUse)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "Test_auth_token", token: login.AuthToken, wantErr: ErrTokenUse},
+		{name: "Test_reused_token", token: login.RefreshToken, wantErr: ErrRefreshTokenReused},
+		{name: "Test_family_revoked", token: rotated.RefreshToken, wantErr: ErrRefreshTokenUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, jwtErr := authConfig.Refresh(nil, tt.token)
+			if jwtErr == nil || jwtErr.Code != 401 || !errors.Is(jwtErr, tt.wantErr) {
+				t.Errorf("Refresh() error = %v, want %v", jwtErr, tt.wantErr)
+			}
+		})
+	}
+	if len(reuses) != 1 || reuses[0].Subject != "test_user" {
+		t.Errorf("reuse audit events = %+v, want 1", reuses)
+	}
+}
+
+func TestJwtAuthConfig_Refresh_authentication(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		RefreshTokens: NewMemoryRefreshTokenStore(),
+	})
+	authTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	login, jwtErr := authConfig.IssueAuthenticatedTokenPair("test_user", nil,
+		&Authentication{ACR: "2", AMR: []string{"pwd", "otp"}, Time: authTime})
+	if jwtErr != nil {
+		t.Fatalf("IssueAuthenticatedTokenPair() error = %v", jwtErr)
+	}
+	rotated, jwtErr := authConfig.Refresh(nil, login.RefreshToken)
+	if jwtErr != nil {
+		t.Fatalf("Refresh() error = %v", jwtErr)
+	}
+	identity, err := authConfig.Authenticate(rotated.AuthToken)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	got := authentication(identity)
+	if got == nil || got.ACR != "2" || len(got.AMR) != 2 || !got.Time.Equal(authTime) {
+		t.Errorf("authentication of the refreshed token = %+v, want acr 2, amr pwd otp and auth_time %v", got, authTime)
+	}
+}
+
+func TestJwtAuthConfig_Refresh_familyLifetime(t *testing.T) {
+	tests := []struct {
+		name     string
+		lifetime time.Duration
+		// wantEnd is the end of the family from the login
+		wantEnd time.Duration
+	}{
+		{name: "Test_refresh_token_lifetime", wantEnd: time.Hour},
+		{name: "Test_family_lifetime", lifetime: 45 * time.Minute, wantEnd: 45 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login := time.Now().Truncate(time.Second)
+			now := login
+			authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+				SigningKey:            "test_key",
+				SigningMethod:         "HS256",
+				BearerTokens:          true,
+				AuthTokenValidTime:    15 * time.Minute,
+				RefreshTokenValidTime: time.Hour,
+				RefreshTokens:         NewMemoryRefreshTokenStore(),
+				RefreshFamilyLifetime: tt.lifetime,
+				Clock:                 ClockFunc(func() time.Time { return now }),
+			})
+			pair, jwtErr := authConfig.IssueTokenPair("test_user", nil)
+			if jwtErr != nil {
+				t.Fatalf("IssueTokenPair() error = %v", jwtErr)
+			}
+			end := login.Add(tt.wantEnd)
+			for now = login.Add(10 * time.Minute); now.Before(end); now = now.Add(10 * time.Minute) {
+				if pair, jwtErr = authConfig.Refresh(nil, pair.RefreshToken); jwtErr != nil {
+					t.Fatalf("Refresh() at %v error = %v", now.Sub(login), jwtErr)
+				}
+				identity, err := authConfig.Authenticate(pair.AuthToken)
+				if err != nil {
+					t.Fatalf("Authenticate() error = %v", err)
+				}
+				if identity.ExpiresAt.After(end) {
+					t.Errorf("auth token refreshed at %v expires at %v, past the family end %v", now.Sub(login),
+						identity.ExpiresAt.Sub(login), tt.wantEnd)
+				}
+			}
+			if _, jwtErr := authConfig.Refresh(nil, pair.RefreshToken); jwtErr == nil ||
+				!errors.Is(jwtErr, turboError.ErrTokenExpired) {
+				t.Errorf("Refresh() at %v error = %v, want %v", now.Sub(login), jwtErr, turboError.ErrTokenExpired)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_RefreshHandler(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		RefreshTokens: NewMemoryRefreshTokenStore(),
+	})
+	pair, jwtErr := authConfig.IssueTokenPair("test_user", nil)
+	if jwtErr != nil {
+		t.Fatalf("IssueTokenPair() error = %v", jwtErr)
+	}
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		want   int
+	}{
+		{name: "Test_get", method: http.MethodGet, token: pair.RefreshToken, want: http.StatusMethodNotAllowed},
+		{name: "Test_missing_token", method: http.MethodPost, want: http.StatusUnauthorized},
+		{name: "Test_refresh", method: http.MethodPost, token: pair.RefreshToken, want: http.StatusOK},
+		{name: "Test_reuse", method: http.MethodPost, token: pair.RefreshToken, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/refresh", nil)
+			if tt.token != "" {
+				r.Header.Set(authConfig.RefreshTokenName, tt.token)
+			}
+			w := httptest.NewRecorder()
+			authConfig.RefreshHandler().ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v", w.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && (w.Header().Get(authConfig.AuthTokenName) == "" ||
+				w.Header().Get(authConfig.RefreshTokenName) == tt.token) {
+				t.Errorf("RefreshHandler() did not rotate the tokens: %v", w.Header())
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_Refresh_afterLogout(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		RefreshTokens: NewMemoryRefreshTokenStore(),
+	})
+	login, jwtErr := authConfig.IssueTokenPair("test_user", nil)
+	if jwtErr != nil {
+		t.Fatalf("IssueTokenPair() error = %v", jwtErr)
+	}
+	rotated, jwtErr := authConfig.Refresh(nil, login.RefreshToken)
+	if jwtErr != nil {
+		t.Fatalf("Refresh() error = %v", jwtErr)
+	}
+
+	// no Revoker is configured, the logout revokes the family in the RefreshTokens store
+	r := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	r.Header.Set(authConfig.AuthTokenName, rotated.AuthToken)
+	r.Header.Set(authConfig.RefreshTokenName, rotated.RefreshToken)
+	w := httptest.NewRecorder()
+	authConfig.LogoutHandler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("LogoutHandler() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if _, jwtErr := authConfig.Refresh(nil, rotated.RefreshToken); jwtErr == nil ||
+		!errors.Is(jwtErr, ErrRefreshTokenUnknown) {
+		t.Errorf("Refresh() after logout error = %v, want %v", jwtErr, ErrRefreshTokenUnknown)
+	}
+}
+
+func TestMemoryRefreshTokenStore_MaxTokens(t *testing.T) {
+	store := NewMemoryRefreshTokenStore()
+	store.MaxTokens = 2
+	for _, jti := range []string{"first", "second", "third"} {
+		if err := store.Save(&RefreshToken{JTI: jti, Family: "family", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	tests := []struct {
+		name    string
+		jti     string
+		wantErr error
+	}{
+		{name: "Test_oldest_dropped", jti: "first", wantErr: ErrRefreshTokenUnknown},
+		{name: "Test_kept", jti: "third"},
+		{name: "Test_reused", jti: "third", wantErr: ErrRefreshTokenReused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := store.Consume(tt.jti); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Consume() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if err := store.RevokeFamily("family"); err != nil || store.order.Len() != 0 || len(store.tokens) != 0 {
+		t.Errorf("RevokeFamily() error = %v, tokens left = %v", err, store.order.Len())
+	}
+}
@@ -0,0 +1,336 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// Revoker keeps track of the token ids (jti) which are no longer accepted
+	Revoker interface {
+		// Revoke marks the jti as revoked until expiresAt, after which the token is invalid anyway
+		Revoke(jti string, expiresAt time.Time) error
+		// IsRevoked reports whether the jti has been revoked
+		IsRevoked(jti string) (bool, error)
+	}
+
+	// SubjectRevoker is implemented by the Revokers able to revoke all the tokens of a subject at once, e.g. after
+	// a password change or when an account is disabled
+	SubjectRevoker interface {
+		// RevokeSubject revokes the tokens of the subject issued before issuedBefore
+		RevokeSubject(subject string, issuedBefore time.Time) error
+		IsSubjectRevoked(subject string, issuedAt time.Time) (bool, error)
+	}
+
+	// TokenVersioner is implemented by the Revokers keeping a token version per subject, the tokens carry the version
+	// of their subject at issuance (ver claim) and bumping it revokes all of them at once. Unlike RevokeSubject it
+	// does not depend on the clocks of the nodes agreeing
+	TokenVersioner interface {
+		// TokenVersion returns the current version of the subject, 0 when it was never bumped
+		TokenVersion(subject string) (int64, error)
+		// BumpTokenVersion increments the version of the subject and returns the new version
+		BumpTokenVersion(subject string) (int64, error)
+	}
+
+	// ContextRevoker is implemented by the Revokers backed by a network store, the context of the request is passed
+	// to them so that its deadline and cancellation apply to the lookups
+	ContextRevoker interface {
+		RevokeContext(ctx context.Context, jti string, expiresAt time.Time) error
+		IsRevokedContext(ctx context.Context, jti string) (bool, error)
+	}
+
+	// ContextSubjectRevoker is the SubjectRevoker counterpart of the ContextRevoker
+	ContextSubjectRevoker interface {
+		RevokeSubjectContext(ctx context.Context, subject string, issuedBefore time.Time) error
+		IsSubjectRevokedContext(ctx context.Context, subject string, issuedAt time.Time) (bool, error)
+	}
+
+	// ContextTokenVersioner is the TokenVersioner counterpart of the ContextRevoker
+	ContextTokenVersioner interface {
+		TokenVersionContext(ctx context.Context, subject string) (int64, error)
+		BumpTokenVersionContext(ctx context.Context, subject string) (int64, error)
+	}
+
+	// RevocationLister is implemented by the Revokers able to list their entries
+	RevocationLister interface {
+		// Revocations returns the revoked jti along with the expiry of their tokens
+		Revocations() (map[string]time.Time, error)
+	}
+
+	// MemoryRevoker is an in-memory Revoker suitable for single instance deployments
+	MemoryRevoker struct {
+		mutex    sync.RWMutex
+		revoked  map[string]time.Time
+		subjects map[string]time.Time
+		versions map[string]int64
+	}
+)
+
+// ClaimTokenVersion is the token version of the subject at issuance, see TokenVersioner
+const ClaimTokenVersion = "ver"
+
+var (
+	// ErrRevocationUnsupported is returned by RevokeAll when the Revoker can neither bump the token versions nor
+	// revoke the subjects
+	ErrRevocationUnsupported = errors.New("revoker cannot revoke all the tokens of a subject")
+)
+
+func NewMemoryRevoker() *MemoryRevoker {
+	return &MemoryRevoker{
+		revoked:  make(map[string]time.Time),
+		subjects: make(map[string]time.Time),
+		versions: make(map[string]int64),
+	}
+}
+
+// RevokeAll revokes all the tokens of the subject with the Revoker, by bumping its token version when the Revoker is
+// a TokenVersioner, by revoking the tokens issued until now when it is a SubjectRevoker
+func RevokeAll(revoker Revoker, subject string) error {
+	return RevokeAllContext(context.Background(), revoker, subject)
+}
+
+// RevokeAllContext is RevokeAll with the context passed to the ContextTokenVersioner or ContextSubjectRevoker
+func RevokeAllContext(ctx context.Context, revoker Revoker, subject string) error {
+	if versioner, ok := revoker.(TokenVersioner); ok {
+		_, err := bumpTokenVersion(ctx, versioner, subject)
+		return err
+	}
+	if subjectRevoker, ok := revoker.(SubjectRevoker); ok {
+		return revokeSubject(ctx, subjectRevoker, subject, time.Now())
+	}
+	return ErrRevocationUnsupported
+}
+
+// revokeJTI and the following helpers call the Context variant of the method when the revoker implements it
+func revokeJTI(ctx context.Context, revoker Revoker, jti string, expiresAt time.Time) error {
+	if contextRevoker, ok := revoker.(ContextRevoker); ok {
+		return contextRevoker.RevokeContext(ctx, jti, expiresAt)
+	}
+	return revoker.Revoke(jti, expiresAt)
+}
+
+func isRevokedJTI(ctx context.Context, revoker Revoker, jti string) (bool, error) {
+	if contextRevoker, ok := revoker.(ContextRevoker); ok {
+		return contextRevoker.IsRevokedContext(ctx, jti)
+	}
+	return revoker.IsRevoked(jti)
+}
+
+func revokeSubject(ctx context.Context, revoker SubjectRevoker, subject string, issuedBefore time.Time) error {
+	if contextRevoker, ok := revoker.(ContextSubjectRevoker); ok {
+		return contextRevoker.RevokeSubjectContext(ctx, subject, issuedBefore)
+	}
+	return revoker.RevokeSubject(subject, issuedBefore)
+}
+
+func isSubjectRevoked(ctx context.Context, revoker SubjectRevoker, subject string, issuedAt time.Time) (bool, error) {
+	if contextRevoker, ok := revoker.(ContextSubjectRevoker); ok {
+		return contextRevoker.IsSubjectRevokedContext(ctx, subject, issuedAt)
+	}
+	return revoker.IsSubjectRevoked(subject, issuedAt)
+}
+
+func tokenVersion(ctx context.Context, versioner TokenVersioner, subject string) (int64, error) {
+	if contextVersioner, ok := versioner.(ContextTokenVersioner); ok {
+		return contextVersioner.TokenVersionContext(ctx, subject)
+	}
+	return versioner.TokenVersion(subject)
+}
+
+func bumpTokenVersion(ctx context.Context, versioner TokenVersioner, subject string) (int64, error) {
+	if contextVersioner, ok := versioner.(ContextTokenVersioner); ok {
+		return contextVersioner.BumpTokenVersionContext(ctx, subject)
+	}
+	return versioner.BumpTokenVersion(subject)
+}
+
+func (m *MemoryRevoker) Revoke(jti string, expiresAt time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.purge(time.Now())
+	m.revoked[jti] = expiresAt
+	return nil
+}
+
+func (m *MemoryRevoker) IsRevoked(jti string) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	_, ok := m.revoked[jti]
+	return ok, nil
+}
+
+func (m *MemoryRevoker) RevokeSubject(subject string, issuedBefore time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if issuedBefore.After(m.subjects[subject]) {
+		m.subjects[subject] = issuedBefore
+	}
+	return nil
+}
+
+func (m *MemoryRevoker) IsSubjectRevoked(subject string, issuedAt time.Time) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	issuedBefore, ok := m.subjects[subject]
+	return ok && issuedAt.Before(issuedBefore), nil
+}
+
+func (m *MemoryRevoker) TokenVersion(subject string) (int64, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.versions[subject], nil
+}
+
+func (m *MemoryRevoker) BumpTokenVersion(subject string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.versions[subject]++
+	return m.versions[subject], nil
+}
+
+func (m *MemoryRevoker) Revocations() (map[string]time.Time, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	revocations := make(map[string]time.Time, len(m.revoked))
+	for jti, expiresAt := range m.revoked {
+		revocations[jti] = expiresAt
+	}
+	return revocations, nil
+}
+
+// purge drops the entries whose tokens have already expired, caller must hold the write lock
+func (m *MemoryRevoker) purge(now time.Time) {
+	for jti, expiresAt := range m.revoked {
+		if !expiresAt.IsZero() && now.After(expiresAt) {
+			delete(m.revoked, jti)
+		}
+	}
+}
+
+func (authConfig *JwtAuthConfig) checkRevoked(ctx context.Context, claims jwt.MapClaims) error {
+	if authConfig.Revoker == nil || claims == nil {
+		return nil
+	}
+	ctx, span := authConfig.startSpan(ctx, "jwt.Revoker.IsRevoked")
+	var revoked bool
+	err := authConfig.RevokerPolicy.Do(ctx, func(ctx context.Context) error {
+		var err error
+		revoked, err = authConfig.isRevoked(ctx, claims)
+		return err
+	})
+	endSpan(span, err)
+	if err != nil && authConfig.RevokerPolicy.Tolerate(err) {
+		logger.WarnF("revocation check skipped, the revoker is unavailable: %v", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if revoked {
+		return turboError.ErrTokenRevoked
+	}
+	return nil
+}
+
+// isRevoked checks the jti, then the token version when the Revoker is a TokenVersioner and the subject when it is
+// a SubjectRevoker. A token without issued at claim is considered issued before any subject revocation, a token
+// without ver claim carries the version 0
+func (authConfig *JwtAuthConfig) isRevoked(ctx context.Context, claims jwt.MapClaims) (bool, error) {
+	if jti, _ := claims["ID"].(string); jti != "" {
+		if revoked, err := isRevokedJTI(ctx, authConfig.Revoker, jti); err != nil || revoked {
+			return revoked, err
+		}
+	}
+	subject, _ := claims["Username"].(string)
+	if subject == "" {
+		return false, nil
+	}
+	if versioner, ok := authConfig.Revoker.(TokenVersioner); ok {
+		current, err := tokenVersion(ctx, versioner, subject)
+		if err != nil {
+			return false, err
+		}
+		version, _ := claims[ClaimTokenVersion].(float64)
+		if int64(version) < current {
+			return true, nil
+		}
+	}
+	subjectRevoker, ok := authConfig.Revoker.(SubjectRevoker)
+	if !ok {
+		return false, nil
+	}
+	issuedAt, _ := timeClaim(claims, "IssuedAt", "iat")
+	return isSubjectRevoked(ctx, subjectRevoker, subject, issuedAt)
+}
+
+// revokeToken verifies the signature of the token and revokes its jti, the family of a refresh token is revoked in
+// the RefreshTokens store as well so that it cannot be rotated anymore. The payload is returned for further use
+func (authConfig *JwtAuthConfig) revokeToken(r *http.Request, token string) (*Payload, error) {
+	claims, err := authConfig.parsePayload(token)
+	if err != nil {
+		authConfig.audit(r, audit.EventTokenRevocation, nil, err)
+		return nil, err
+	}
+	payload := &claims.Payload
+	identity := &turboAuth.Identity{Subject: payload.Username, TokenID: payload.ID.String()}
+	if authConfig.ValidationCache != nil {
+		authConfig.ValidationCache.Invalidate(token)
+	}
+	if authConfig.Revoker != nil {
+		ctx, span := authConfig.startSpan(r.Context(), "jwt.Revoker.Revoke")
+		err := revokeJTI(ctx, authConfig.Revoker, payload.ID.String(), payload.ExpiredAt)
+		endSpan(span, err)
+		if err != nil {
+			authConfig.audit(r, audit.EventTokenRevocation, identity, err)
+			return nil, err
+		}
+	}
+	family := authConfig.RefreshTokens != nil && claims.Family != ""
+	if family {
+		if err := revokeRefreshFamily(r.Context(), authConfig.RefreshTokens, claims.Family); err != nil {
+			authConfig.audit(r, audit.EventTokenRevocation, identity, err)
+			return nil, err
+		}
+	}
+	if authConfig.Revoker != nil || family {
+		authConfig.audit(r, audit.EventTokenRevocation, identity, nil)
+	}
+	return payload, nil
+}
+
+// RevokeAllTokens revokes all the tokens issued to the subject, e.g. after a password change or when the account is
+// compromised, see RevokeAll
+func (authConfig *JwtAuthConfig) RevokeAllTokens(r *http.Request, subject string) error {
+	if authConfig.Revoker == nil {
+		return ErrRevocationUnsupported
+	}
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	err := RevokeAllContext(ctx, authConfig.Revoker, subject)
+	authConfig.audit(r, audit.EventTokenRevocation, &turboAuth.Identity{Subject: subject}, err)
+	return err
+}
+
+// stampVersion sets the ver claim of the tokens of the subjects whose version was bumped
+func (authConfig *JwtAuthConfig) stampVersion(ctx context.Context, claims jwt.Claims) (jwt.Claims, error) {
+	versioner, ok := authConfig.Revoker.(TokenVersioner)
+	typed, isTyped := claims.(TypedClaims)
+	if !ok || !isTyped {
+		return claims, nil
+	}
+	payload := typed.StandardPayload()
+	version, err := tokenVersion(ctx, versioner, payload.Username)
+	if err != nil {
+		return claims, err
+	}
+	payload.Version = version
+	return claims, nil
+}
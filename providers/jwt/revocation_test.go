@@ -0,0 +1,311 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/nandlabs/turbo-auth/audit"
+	"github.com/nandlabs/turbo-auth/resilience"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJwtAuthConfig_LogoutHandler(t *testing.T) {
+	var events []*audit.Event
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		Revoker:       NewMemoryRevoker(),
+		AuditLogger: audit.AuditLoggerFunc(func(event *audit.Event) {
+			events = append(events, event)
+		}),
+	})
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(authConfig.AuthTokenName, token)
+		return r
+	}
+
+	if err := authConfig.HandleRequest(httptest.NewRecorder(), newRequest()); err != nil {
+		t.Fatalf("HandleRequest() before logout error = %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	authConfig.LogoutHandler().ServeHTTP(w, newRequest())
+	if w.Code != http.StatusOK {
+		t.Errorf("LogoutHandler() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	err := authConfig.HandleRequest(httptest.NewRecorder(), newRequest())
+	if err == nil || err.Code != 403 {
+		t.Errorf("HandleRequest() after logout error = %v, want revoked", err)
+	}
+
+	want := []struct {
+		eventType audit.EventType
+		outcome   audit.Outcome
+	}{
+		{audit.EventTokenIssued, audit.OutcomeSuccess},
+		{audit.EventTokenValidation, audit.OutcomeSuccess},
+		{audit.EventTokenRevocation, audit.OutcomeSuccess},
+		{audit.EventLogout, audit.OutcomeSuccess},
+		{audit.EventTokenValidation, audit.OutcomeFailure},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("audit events = %v, want %v", len(events), len(want))
+	}
+	for i, tt := range want {
+		if events[i].Type != tt.eventType || events[i].Outcome != tt.outcome {
+			t.Errorf("audit event %v = %v/%v, want %v/%v", i, events[i].Type, events[i].Outcome, tt.eventType, tt.outcome)
+		}
+		if events[i].Subject != "test_user" {
+			t.Errorf("audit event %v subject = %v, want test_user", i, events[i].Subject)
+		}
+	}
+}
+
+func TestJwtAuthConfig_IntrospectionHandler(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		Revoker:       NewMemoryRevoker(),
+	})
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	handler := authConfig.IntrospectionHandler(BasicCallerAuthenticator(map[string]string{"gateway": "secret"}))
+
+	tests := []struct {
+		name       string
+		secret     string
+		token      string
+		wantStatus int
+		wantActive bool
+	}{
+		{name: "Test_active", secret: "secret", token: token, wantStatus: http.StatusOK, wantActive: true},
+		{name: "Test_inactive", secret: "secret", token: "not-a-token", wantStatus: http.StatusOK, wantActive: false},
+		{name: "Test_unauthenticated_caller", secret: "wrong", token: token, wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"token": {tt.token}}
+			r := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth("gateway", tt.secret)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var response IntrospectionResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+			if response.Active != tt.wantActive {
+				t.Errorf("active = %v, want %v", response.Active, tt.wantActive)
+			}
+			if tt.wantActive && (response.Sub != "test_user" || response.Exp == 0) {
+				t.Errorf("response = %+v", response)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_ValidationCache(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	clock := &now
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		Revoker:       NewMemoryRevoker(),
+		Clock:         ClockFunc(func() time.Time { return *clock }),
+	}, WithValidationCache(2, time.Hour))
+	issue := func() string {
+		token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+		if jwtErr != nil {
+			t.Fatalf("IssueNewToken() error = %v", jwtErr)
+		}
+		return token
+	}
+	first, second, third := issue(), issue(), issue()
+	for _, token := range []string{first, second, third} {
+		if _, err := authConfig.Authenticate(token); err != nil {
+			t.Fatalf("Authenticate() error = %v", err)
+		}
+	}
+	if got := authConfig.ValidationCache.Len(); got != 2 {
+		t.Errorf("Len() = %v, want 2", got)
+	}
+
+	// the cached tokens are not verified again
+	authConfig.SigningKey = "rotated_key"
+	if _, err := authConfig.Authenticate(third); err != nil {
+		t.Errorf("Authenticate() cached token error = %v", err)
+	}
+	if _, err := authConfig.Authenticate(first); err == nil {
+		t.Errorf("Authenticate() evicted token succeeded")
+	}
+	authConfig.SigningKey = "test_key"
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(authConfig.AuthTokenName, third)
+	authConfig.LogoutHandler().ServeHTTP(httptest.NewRecorder(), r)
+	if _, err := authConfig.Authenticate(third); err == nil {
+		t.Errorf("Authenticate() revoked token succeeded")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := authConfig.Authenticate(second); err == nil {
+		t.Errorf("Authenticate() expired token succeeded")
+	}
+}
+
+func TestJwtAuthConfig_RevokeAllTokens(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		Revoker:       NewMemoryRevoker(),
+	})
+	issue := func(username string) string {
+		pair, jwtErr := authConfig.IssueTokenPair(username, []string{"user"})
+		if jwtErr != nil {
+			t.Fatalf("IssueTokenPair() error = %v", jwtErr)
+		}
+		return pair.AuthToken
+	}
+	before, other := issue("test_user"), issue("other_user")
+	if err := authConfig.RevokeAllTokens(nil, "test_user"); err != nil {
+		t.Fatalf("RevokeAllTokens() error = %v", err)
+	}
+	after := issue("test_user")
+	if err := authConfig.RevokeAllTokens(nil, "other_user"); err != nil {
+		t.Fatalf("RevokeAllTokens() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{name: "Test_issued_before", token: before, valid: false},
+		{name: "Test_issued_after", token: after, valid: true},
+		{name: "Test_other_subject_revoked", token: other, valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(authConfig.AuthTokenName, tt.token)
+			if err := authConfig.HandleRequest(httptest.NewRecorder(), r); (err == nil) != tt.valid {
+				t.Errorf("HandleRequest() error = %v, valid %v", err, tt.valid)
+			}
+		})
+	}
+	if err := RevokeAll(nil, "test_user"); err != ErrRevocationUnsupported {
+		t.Errorf("RevokeAll() without revoker error = %v, want %v", err, ErrRevocationUnsupported)
+	}
+}
+
+// contextRevoker fails the lookups once the context is done, like a network store would
+type contextRevoker struct {
+	*MemoryRevoker
+}
+
+func (c contextRevoker) RevokeContext(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Revoke(jti, expiresAt)
+}
+
+func (c contextRevoker) IsRevokedContext(ctx context.Context, jti string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return c.IsRevoked(jti)
+}
+
+func TestJwtAuthConfig_AuthenticateContext(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		Revoker:       contextRevoker{NewMemoryRevoker()},
+	})
+	token, jwtErr := authConfig.IssueNewTokenContext(context.Background(), "test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewTokenContext() error = %v", jwtErr)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		wantErr error
+	}{
+		{name: "Test_live_context", ctx: context.Background()},
+		{name: "Test_cancelled_context", ctx: cancelled, wantErr: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := authConfig.AuthenticateContext(tt.ctx, token)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("AuthenticateContext() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// unavailableRevoker fails all the lookups, like an unreachable store
+type unavailableRevoker struct {
+	*MemoryRevoker
+}
+
+func (u unavailableRevoker) IsRevoked(jti string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestJwtAuthConfig_RevokerPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure resilience.FailurePolicy
+		valid   bool
+	}{
+		{name: "Test_fail_closed", failure: resilience.FailClosed, valid: false},
+		{name: "Test_fail_open", failure: resilience.FailOpen, valid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+				SigningKey:    "test_key",
+				SigningMethod: "HS256",
+				BearerTokens:  true,
+				Revoker:       unavailableRevoker{NewMemoryRevoker()},
+				RevokerPolicy: &resilience.Policy{Retries: 1, Failure: tt.failure},
+			})
+			token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+			if jwtErr != nil {
+				t.Fatalf("IssueNewToken() error = %v", jwtErr)
+			}
+			if _, err := authConfig.Authenticate(token); (err == nil) != tt.valid {
+				t.Errorf("Authenticate() error = %v, valid %v", err, tt.valid)
+			}
+		})
+	}
+}
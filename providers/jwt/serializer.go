@@ -0,0 +1,206 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/google/uuid"
+	"strconv"
+	"time"
+)
+
+type (
+	// Serializer encodes the TypedClaims into the payload of the issued tokens and decodes the payload of the
+	// validated tokens into them, see WithSerializer
+	Serializer interface {
+		Marshal(claims TypedClaims) ([]byte, error)
+		Unmarshal(data []byte, claims TypedClaims) error
+	}
+
+	// ClaimsMarshaler is implemented by the claims structs with the methods generated by cmd/claimsgen, e.g.
+	//
+	//	//go:generate go run github.com/nandlabs/turbo-auth/cmd/claimsgen -type OrderClaims
+	ClaimsMarshaler interface {
+		MarshalClaims() ([]byte, error)
+	}
+
+	// ClaimsUnmarshaler is the decoding counterpart of ClaimsMarshaler
+	ClaimsUnmarshaler interface {
+		UnmarshalClaims(data []byte) error
+	}
+
+	// JSONSerializer is the default Serializer, the generated methods of the claims are used when present and
+	// encoding/json otherwise
+	JSONSerializer struct{}
+
+	// serializedClaims marshals the claims with the Serializer when the token is signed
+	serializedClaims struct {
+		TypedClaims
+		serializer Serializer
+	}
+)
+
+func (JSONSerializer) Marshal(claims TypedClaims) ([]byte, error) {
+	if marshaler, ok := claims.(ClaimsMarshaler); ok {
+		return marshaler.MarshalClaims()
+	}
+	return json.Marshal(claims)
+}
+
+func (JSONSerializer) Unmarshal(data []byte, claims TypedClaims) error {
+	if unmarshaler, ok := claims.(ClaimsUnmarshaler); ok {
+		return unmarshaler.UnmarshalClaims(data)
+	}
+	return json.Unmarshal(data, claims)
+}
+
+func (c *serializedClaims) MarshalJSON() ([]byte, error) {
+	return c.serializer.Marshal(c.TypedClaims)
+}
+
+// serializer returns the Serializer of the TypedClaims, JSONSerializer when none is configured
+func (authConfig *JwtAuthConfig) serializer() Serializer {
+	if authConfig.Serializer == nil {
+		return JSONSerializer{}
+	}
+	return authConfig.Serializer
+}
+
+// AppendClaimName appends the name of a claim of the object being encoded, preceded by a comma unless it is the
+// first one
+func AppendClaimName(buf []byte, name string) []byte {
+	if len(buf) > 0 && buf[len(buf)-1] != '{' {
+		buf = append(buf, ',')
+	}
+	buf = AppendClaimString(buf, name)
+	return append(buf, ':')
+}
+
+// AppendClaimString appends the json string of s
+func AppendClaimString(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7e || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			raw, _ := json.Marshal(s)
+			return append(buf, raw...)
+		}
+	}
+	buf = append(buf, '"')
+	buf = append(buf, s...)
+	return append(buf, '"')
+}
+
+// AppendClaimStrings appends the json array of the strings, null for a nil slice
+func AppendClaimStrings(buf []byte, values []string) []byte {
+	if values == nil {
+		return append(buf, "null"...)
+	}
+	buf = append(buf, '[')
+	for i, value := range values {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = AppendClaimString(buf, value)
+	}
+	return append(buf, ']')
+}
+
+// AppendClaimValue appends the json of the claims of the other types
+func AppendClaimValue(buf []byte, value interface{}) ([]byte, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, raw...), nil
+}
+
+// AppendPayloadClaims appends the claims of the Payload as encoding/json writes them
+func AppendPayloadClaims(buf []byte, payload *Payload) []byte {
+	buf = AppendClaimName(buf, "ID")
+	buf = append(buf, '"')
+	buf = append(buf, payload.ID.String()...)
+	buf = append(buf, '"')
+	buf = AppendClaimName(buf, "Username")
+	buf = AppendClaimString(buf, payload.Username)
+	buf = AppendClaimName(buf, "IssuedAt")
+	buf = appendClaimTime(buf, payload.IssuedAt)
+	buf = AppendClaimName(buf, "ExpiredAt")
+	buf = appendClaimTime(buf, payload.ExpiredAt)
+	if payload.Version != 0 {
+		buf = AppendClaimName(buf, "ver")
+		buf = strconv.AppendInt(buf, payload.Version, 10)
+	}
+	if payload.TokenUse != "" {
+		buf = AppendClaimName(buf, ClaimTokenUse)
+		buf = AppendClaimString(buf, payload.TokenUse)
+	}
+	return buf
+}
+
+func appendClaimTime(buf []byte, t time.Time) []byte {
+	buf = append(buf, '"')
+	buf = t.AppendFormat(buf, time.RFC3339Nano)
+	return append(buf, '"')
+}
+
+// UnmarshalPayloadClaims decodes the claims of the Payload from the fields of the object
+func UnmarshalPayloadClaims(fields map[string]json.RawMessage, payload *Payload) error {
+	if raw, ok := fields["ID"]; ok && !isNull(raw) {
+		var id string
+		if err := UnmarshalClaimString(raw, &id); err != nil {
+			return err
+		}
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			return err
+		}
+		payload.ID = parsed
+	}
+	if raw, ok := fields["Username"]; ok {
+		if err := UnmarshalClaimString(raw, &payload.Username); err != nil {
+			return err
+		}
+	}
+	for name, t := range map[string]*time.Time{"IssuedAt": &payload.IssuedAt, "ExpiredAt": &payload.ExpiredAt} {
+		if raw, ok := fields[name]; ok && !isNull(raw) {
+			if err := t.UnmarshalJSON(raw); err != nil {
+				return err
+			}
+		}
+	}
+	if raw, ok := fields["ver"]; ok && !isNull(raw) {
+		version, err := strconv.ParseInt(string(raw), 10, 64)
+		if err != nil {
+			return err
+		}
+		payload.Version = version
+	}
+	if raw, ok := fields[ClaimTokenUse]; ok {
+		return UnmarshalClaimString(raw, &payload.TokenUse)
+	}
+	return nil
+}
+
+// UnmarshalClaimString decodes a json string, null leaves s unchanged
+func UnmarshalClaimString(raw json.RawMessage, s *string) error {
+	if isNull(raw) {
+		return nil
+	}
+	if len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"' && bytes.IndexByte(raw, '\\') < 0 {
+		*s = string(raw[1 : len(raw)-1])
+		return nil
+	}
+	return json.Unmarshal(raw, s)
+}
+
+// UnmarshalClaimStrings decodes a json array of strings, null sets a nil slice
+func UnmarshalClaimStrings(raw json.RawMessage, values *[]string) error {
+	return json.Unmarshal(raw, values)
+}
+
+// UnmarshalClaimValue decodes the claims of the other types
+func UnmarshalClaimValue(raw json.RawMessage, value interface{}) error {
+	return json.Unmarshal(raw, value)
+}
+
+func isNull(raw json.RawMessage) bool {
+	return string(raw) == "null"
+}
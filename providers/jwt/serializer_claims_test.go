@@ -0,0 +1,67 @@
+// Code generated by claimsgen. DO NOT EDIT.
+
+package jwt_test
+
+import (
+	"encoding/json"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+)
+
+// MarshalClaims encodes the claims, see jwt.ClaimsMarshaler
+func (c *OrderClaims) MarshalClaims() ([]byte, error) {
+	var err error
+	buf := make([]byte, 0, 256)
+	buf = append(buf, '{')
+	buf = jwt.AppendPayloadClaims(buf, &c.Payload)
+	if len(c.Roles) != 0 {
+		buf = jwt.AppendClaimName(buf, "Roles")
+		buf = jwt.AppendClaimStrings(buf, c.Roles)
+	}
+	buf = jwt.AppendClaimName(buf, "tid")
+	buf = jwt.AppendClaimString(buf, c.TenantID)
+	if c.Amount != 0 {
+		buf = jwt.AppendClaimName(buf, "amount")
+		if buf, err = jwt.AppendClaimValue(buf, c.Amount); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.Labels) != 0 {
+		buf = jwt.AppendClaimName(buf, "labels")
+		if buf, err = jwt.AppendClaimValue(buf, c.Labels); err != nil {
+			return nil, err
+		}
+	}
+	return append(buf, '}'), nil
+}
+
+// UnmarshalClaims decodes the claims, see jwt.ClaimsUnmarshaler
+func (c *OrderClaims) UnmarshalClaims(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if err := jwt.UnmarshalPayloadClaims(fields, &c.Payload); err != nil {
+		return err
+	}
+	if raw, ok := fields["Roles"]; ok {
+		if err := jwt.UnmarshalClaimStrings(raw, &c.Roles); err != nil {
+			return err
+		}
+	}
+	if raw, ok := fields["tid"]; ok {
+		if err := jwt.UnmarshalClaimString(raw, &c.TenantID); err != nil {
+			return err
+		}
+	}
+	if raw, ok := fields["amount"]; ok {
+		if err := jwt.UnmarshalClaimValue(raw, &c.Amount); err != nil {
+			return err
+		}
+	}
+	if raw, ok := fields["labels"]; ok {
+		if err := jwt.UnmarshalClaimValue(raw, &c.Labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package jwt_test
+
+import (
+	"encoding/json"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//go:generate go run ../../cmd/claimsgen -type OrderClaims -output serializer_claims_test.go
+
+type (
+	OrderClaims struct {
+		jwt.Payload
+		Roles    []string          `json:"Roles,omitempty"`
+		TenantID string            `json:"tid"`
+		Amount   float64           `json:"amount,omitempty"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Internal string            `json:"-"`
+	}
+
+	// countingSerializer counts the claims encoded and decoded by the JSONSerializer
+	countingSerializer struct {
+		jwt.JSONSerializer
+		marshalled, unmarshalled int
+	}
+)
+
+func (s *countingSerializer) Marshal(claims jwt.TypedClaims) ([]byte, error) {
+	s.marshalled++
+	return s.JSONSerializer.Marshal(claims)
+}
+
+func (s *countingSerializer) Unmarshal(data []byte, claims jwt.TypedClaims) error {
+	s.unmarshalled++
+	return s.JSONSerializer.Unmarshal(data, claims)
+}
+
+func TestJSONSerializer_generated(t *testing.T) {
+	payload, err := jwt.NewPayload("test_user", time.Minute)
+	if err != nil {
+		t.Fatalf("NewPayload() error = %v", err)
+	}
+	tests := []struct {
+		name   string
+		claims *OrderClaims
+	}{
+		{name: "Test_empty_fields", claims: &OrderClaims{Payload: *payload}},
+		{name: "Test_all_fields", claims: &OrderClaims{Payload: *payload, Roles: []string{"admin", "<viewer>"},
+			TenantID: "acme \"corp\"\n", Amount: 12.5, Labels: map[string]string{"region": "eu"}}},
+		{name: "Test_versioned", claims: &OrderClaims{Payload: jwt.Payload{ID: payload.ID, Username: "test_user",
+			IssuedAt: payload.IssuedAt, ExpiredAt: payload.ExpiredAt, Version: 3, TokenUse: jwt.TokenUseAccess}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generated, err := jwt.JSONSerializer{}.Marshal(tt.claims)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			reflected, err := json.Marshal(tt.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want map[string]interface{}
+			if err := json.Unmarshal(generated, &got); err != nil {
+				t.Fatalf("Marshal() = %s, error = %v", generated, err)
+			}
+			if err := json.Unmarshal(reflected, &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Marshal() = %s, want %s", generated, reflected)
+			}
+			decoded := &OrderClaims{}
+			if err := (jwt.JSONSerializer{}).Unmarshal(generated, decoded); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !decoded.IssuedAt.Equal(tt.claims.IssuedAt) || !decoded.ExpiredAt.Equal(tt.claims.ExpiredAt) {
+				t.Errorf("Unmarshal() times = %v, %v, want %v, %v", decoded.IssuedAt, decoded.ExpiredAt,
+					tt.claims.IssuedAt, tt.claims.ExpiredAt)
+			}
+			decoded.IssuedAt, decoded.ExpiredAt = tt.claims.IssuedAt, tt.claims.ExpiredAt
+			if !reflect.DeepEqual(decoded, tt.claims) {
+				t.Errorf("Unmarshal() = %+v, want %+v", decoded, tt.claims)
+			}
+		})
+	}
+}
+
+func TestJwtAuthConfig_Serializer(t *testing.T) {
+	serializer := &countingSerializer{}
+	authConfig := jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	}, jwt.WithClaimsType(func() jwt.TypedClaims {
+		return &OrderClaims{}
+	}), jwt.WithSerializer(serializer))
+	token, jwtErr := authConfig.IssueTypedToken("test_user", time.Minute, &OrderClaims{TenantID: "acme"})
+	if jwtErr != nil {
+		t.Fatalf("IssueTypedToken() error = %v", jwtErr)
+	}
+	identity, err := authConfig.Authenticate(token)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if claims, ok := identity.TypedClaims.(*OrderClaims); !ok || claims.TenantID != "acme" || claims.Username != "test_user" {
+		t.Errorf("TypedClaims = %+v, want the issued claims", identity.TypedClaims)
+	}
+	if serializer.marshalled != 1 || serializer.unmarshalled != 1 {
+		t.Errorf("serializer calls = %v marshal, %v unmarshal, want 1 each", serializer.marshalled, serializer.unmarshalled)
+	}
+}
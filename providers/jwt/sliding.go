@@ -0,0 +1,106 @@
+package jwt
+
+import (
+	"encoding/json"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	"net/http"
+)
+
+// slidingClaims are the claims of the slid token carried over as they are, only the Payload is renewed
+type slidingClaims struct {
+	Payload
+	claims map[string]interface{}
+}
+
+const (
+	claimACR      = "acr"
+	claimAMR      = "amr"
+	claimAuthTime = "auth_time"
+	// claimSessionStart anchors the SlidingMaxLifetime of the tokens issued without auth_time
+	claimSessionStart = "sst"
+)
+
+// slideExpiration re-issues the auth token when it is within the SlidingWindow of its expiry. The slid token keeps
+// the claims of the token, its lifetime is the one of the TTLPolicy, bounded by the SlidingMaxLifetime from the
+// authentication. The break-glass and the impersonation tokens are not slid
+func (authConfig *JwtAuthConfig) slideExpiration(w http.ResponseWriter, r *http.Request, identity *turboAuth.Identity) {
+	if authConfig.SlidingWindow <= 0 || identity == nil || identity.HasScope(BreakGlassScope) {
+		return
+	}
+	if _, impersonated := identity.Actor(); impersonated {
+		return
+	}
+	now := authConfig.now()
+	expiresAt, ok := timeClaim(identity.Claims, "ExpiredAt", "exp")
+	if !ok || expiresAt.Sub(now) > authConfig.SlidingWindow {
+		return
+	}
+	start, ok := timeClaim(identity.Claims, claimAuthTime, claimSessionStart, "IssuedAt")
+	if !ok {
+		return
+	}
+	maxLifetime := authConfig.SlidingMaxLifetime
+	if maxLifetime <= 0 {
+		maxLifetime = authConfig.RefreshTokenValidTime
+	}
+	ttl := authConfig.tokenTTL(&TTLRequest{Subject: identity.Subject, Roles: identity.Roles,
+		Authentication: authentication(identity)}).Auth
+	if remaining := start.Add(maxLifetime).Sub(now); remaining < ttl {
+		ttl = remaining
+	}
+	if !now.Add(ttl).After(expiresAt) {
+		return
+	}
+	payload, err := newPayload(identity.Subject, ttl, now)
+	if err != nil {
+		logger.ErrorF("unable to slide the token expiration: %v", err)
+		return
+	}
+	claims := make(map[string]interface{}, len(identity.Claims)+1)
+	for name, value := range identity.Claims {
+		switch name {
+		case "ID", "Username", "IssuedAt", "ExpiredAt", "ver", "jti", "iat", "nbf", "exp":
+		default:
+			claims[name] = value
+		}
+	}
+	if _, ok := claims[claimAuthTime]; !ok {
+		claims[claimSessionStart] = start.Unix()
+	}
+	token, jwtErr := authConfig.signPayload(r.Context(), &slidingClaims{Payload: *payload, claims: claims})
+	if jwtErr != nil {
+		logger.ErrorF("unable to slide the token expiration: %v", jwtErr)
+		authConfig.audit(r, audit.EventTokenRefresh, identity, jwtErr)
+		return
+	}
+	authConfig.audit(r, audit.EventTokenRefresh, identity, nil)
+	authConfig.WriteTokens(w, token, "")
+}
+
+// authentication returns the Authentication recorded in the acr, amr and auth_time claims of the identity
+func authentication(identity *turboAuth.Identity) *Authentication {
+	acr, _ := identity.Claims[claimACR].(string)
+	authTime, ok := timeClaim(identity.Claims, claimAuthTime)
+	amr := stringsClaim(identity.Claims[claimAMR])
+	if acr == "" && len(amr) == 0 && !ok {
+		return nil
+	}
+	return &Authentication{ACR: acr, AMR: amr, Time: authTime}
+}
+
+// MarshalJSON merges the renewed Payload into the claims carried over
+func (c *slidingClaims) MarshalJSON() ([]byte, error) {
+	raw, err := json.Marshal(&c.Payload)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]interface{}, len(c.claims)+6)
+	if err := json.Unmarshal(raw, &merged); err != nil {
+		return nil, err
+	}
+	for name, value := range c.claims {
+		merged[name] = value
+	}
+	return json.Marshal(merged)
+}
@@ -0,0 +1,78 @@
+package jwt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJwtAuthConfig_SlidingWindow(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		SlidingWindow: time.Minute,
+	}, WithSlidingMaxLifetime(time.Hour))
+	issue := func(duration time.Duration, authentication *Authentication) string {
+		token, jwtErr := authConfig.issueToken(context.Background(), "test_user", duration, []string{"admin"}, authentication)
+		if jwtErr != nil {
+			t.Fatalf("issueToken() error = %v", jwtErr)
+		}
+		return token
+	}
+	impersonation, jwtErr := authConfig.signPayload(context.Background(), &extendedClaims{
+		Payload: Payload{ID: [16]byte{1}, Username: "test_user", IssuedAt: time.Now(),
+			ExpiredAt: time.Now().Add(30 * time.Second), TokenUse: TokenUseAccess},
+		Act: map[string]interface{}{"sub": "agent"},
+	})
+	if jwtErr != nil {
+		t.Fatalf("signPayload() error = %v", jwtErr)
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "Test_within_window", token: issue(30*time.Second, nil), want: true},
+		{name: "Test_outside_window", token: issue(time.Hour, nil), want: false},
+		{name: "Test_authenticated", token: issue(30*time.Second, &Authentication{ACR: "2", AMR: []string{"pwd", "otp"},
+			Time: time.Now().Add(-10 * time.Minute)}), want: true},
+		{name: "Test_max_lifetime", token: issue(30*time.Second, &Authentication{ACR: "2",
+			Time: time.Now().Add(-2 * time.Hour)}), want: false},
+		{name: "Test_impersonation", token: impersonation, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original, err := authConfig.Authenticate(tt.token)
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(authConfig.AuthTokenName, tt.token)
+			w := httptest.NewRecorder()
+			authConfig.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			token := w.Header().Get(authConfig.AuthTokenName)
+			if got := token != ""; got != tt.want {
+				t.Fatalf("token re-issued = %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+			slid, err := authConfig.Authenticate(token)
+			if err != nil {
+				t.Fatalf("Authenticate() of the slid token error = %v", err)
+			}
+			if !slid.ExpiresAt.After(original.ExpiresAt) || slid.TokenID == original.TokenID || !slid.HasRole("admin") {
+				t.Errorf("slid identity = %+v, want the claims of %+v with a later expiry", slid, original)
+			}
+			for _, claim := range []string{claimACR, claimAMR, claimAuthTime} {
+				if !reflect.DeepEqual(slid.Claims[claim], original.Claims[claim]) {
+					t.Errorf("claim %s = %v, want %v", claim, slid.Claims[claim], original.Claims[claim])
+				}
+			}
+		})
+	}
+}
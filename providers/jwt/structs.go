@@ -1,6 +1,17 @@
 package jwt
 
-import "time"
+import (
+	"github.com/golang-jwt/jwt/v4"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/extractor"
+	"github.com/nandlabs/turbo-auth/metrics"
+	"github.com/nandlabs/turbo-auth/resilience"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"time"
+)
 
 type (
 	JwtAuthConfig struct {
@@ -11,6 +22,123 @@ type (
 		AuthTokenValidTime    time.Duration
 		AuthTokenName         string
 		RefreshTokenName      string
+		// TokenExtractor replaces the lookup of the auth token by AuthTokenName in the headers or cookies, the
+		// refresh token is then read with RefreshTokenExtractor only, if set
+		TokenExtractor        extractor.Extractor
+		RefreshTokenExtractor extractor.Extractor
+		// SlidingWindow re-issues the auth token transparently when a request arrives within the window of its
+		// expiry, disabled when 0
+		SlidingWindow time.Duration
+		// SlidingMaxLifetime bounds the sliding from the authentication of the token, RefreshTokenValidTime when 0
+		SlidingMaxLifetime time.Duration
+		// KeyStore takes precedence over SigningKey and SigningMethod, tokens are signed with its current key
+		KeyStore KeyStore
+		// Encryption wraps the issued tokens in a JWE and decrypts them during validation when set
+		Encryption *Encryption
+		// TokenMode enforces sign-only, encrypt-only or sign+encrypt tokens, when empty tokens are signed then
+		// encrypted if Encryption is set and both plain and encrypted tokens are accepted
+		TokenMode TokenMode
+		// DPoP requires the proof of possession of the sender constrained tokens when set
+		DPoP *DPoP
+		// CertificateBinding requires the client certificate of the certificate bound tokens when set
+		CertificateBinding *CertificateBinding
+		// Tenants selects the verification keys and rules of the token by the TenantHeader or the iss claim when set,
+		// SigningKey and KeyStore are then only used for issuing tokens
+		Tenants *TenantRegistry
+		// TenantHeader names the request header carrying the tenant id, the iss claim alone is used when empty
+		TenantHeader string
+		// ClaimsMapper is invoked once the token is validated, before the request proceeds
+		ClaimsMapper turboAuth.ClaimsMapper
+		// ClaimsType decodes the validated tokens into the TypedClaims of the identity when set
+		ClaimsType ClaimsFactory
+		// Serializer encodes and decodes the TypedClaims, JSONSerializer when nil
+		Serializer Serializer
+		// ValidationCache skips the verification of the tokens validated recently when set
+		ValidationCache *ValidationCache
+		// Revoker is consulted on every request and updated on logout, revocation is skipped when nil
+		Revoker Revoker
+		// RevokerPolicy bounds, retries and breaks the lookups of the Revoker, with FailOpen the tokens are accepted
+		// while the Revoker is unavailable
+		RevokerPolicy *resilience.Policy
+		// AuditLogger receives the issuance, validation, refresh, revocation and logout events when set
+		AuditLogger audit.AuditLogger
+		// Metrics records the authentication outcomes and token issuance latency when set
+		Metrics *metrics.Metrics
+		// Clock is the source of time for issuing and validating the tokens, the system clock when nil
+		Clock Clock
+		// Leeway tolerates the clock skew between the nodes when checking the expiry and issued at claims
+		Leeway time.Duration
+		// TracerProvider enables the OpenTelemetry spans when set, see WithTracerProvider
+		TracerProvider trace.TracerProvider
+		// ErrorWriter renders the authentication failures with the status of the JwtError, e.g. turboError.ProblemWriter
+		ErrorWriter turboError.ErrorWriter
+		// OnLogout is an optional hook invoked by the LogoutHandler once the tokens are revoked
+		OnLogout LogoutHook
+		// ImpersonationPolicy decides who may impersonate whom with Impersonate, impersonation is denied when nil
+		ImpersonationPolicy ImpersonationPolicy
+		// ImpersonationTargets looks the impersonated subjects up, impersonation is denied when nil
+		ImpersonationTargets ImpersonationTargetLookup
+		// DeviceBinding rejects the bound tokens presented from another device when set
+		DeviceBinding *DeviceBinding
+		// RefreshTokens records the issued refresh tokens so that each of them is used once by Refresh, a reused
+		// token revokes its whole family. Refresh is disabled when nil
+		RefreshTokens RefreshTokenStore
+		// RefreshFamilyLifetime bounds the refresh token family from the login, each rotation is capped at what is
+		// left of it so that the family ends. The refresh token lifetime selected at the login when 0
+		RefreshFamilyLifetime time.Duration
+		// ExchangeAudiences are the audiences ExchangeToken may issue tokens for, the exchanged tokens keep the audience
+		// of the subject token when empty
+		ExchangeAudiences []string
+		// TTLPolicy selects the lifetimes of the issued token pairs and exchanged tokens, AuthTokenValidTime and
+		// RefreshTokenValidTime apply to all of them when nil
+		TTLPolicy TTLPolicy
+		// BreakGlass accepts the emergency tokens signed offline with its key when set, see IssueBreakGlassToken
+		BreakGlass *BreakGlass
+		// CompressionThreshold compresses the claims of the issued tokens whose json exceeds this many bytes, see
+		// WithClaimsCompression. The compressed tokens are inflated during validation whatever the threshold
+		CompressionThreshold int
+		// Limits bounds the length, header, claim count and nesting of the accepted tokens, the defaults apply when
+		// nil
+		Limits *Limits
+		// BodyDigest verifies the body of the requests against the digest of their token or header when set
+		BodyDigest *BodyDigest
+
+		// hs256 recycles the hashes of the SigningKey for the HS256 fast path, see CreateJwtAuthenticator
+		hs256 *hmacPool
+	}
+
+	// Option customizes the JwtAuthConfig at construction
+	Option func(authConfig *JwtAuthConfig)
+
+	// LogoutHook receives the claims of the refresh token (or the auth token when no refresh token is present)
+	LogoutHook func(w http.ResponseWriter, r *http.Request, payload *Payload) error
+
+	// Authentication describes how the user logged in, see IssueAuthenticatedTokenPair
+	Authentication struct {
+		// ACR is the authentication context class reference, e.g. "2" or "phr"
+		ACR string
+		// AMR lists the authentication methods references of RFC 8176, e.g. "pwd" and "otp"
+		AMR []string
+		// Time of the authentication, the time of the issue when zero
+		Time time.Time
+		// DeviceID and UserAgent bind the auth and the refresh tokens to the device, see DeviceBinding.Bind
+		DeviceID  string
+		UserAgent string
+
+		// fingerprint carries the dfp claim over when the token is re-issued
+		fingerprint string
+		// family is the fam claim of the refresh tokens, see RefreshTokenStore
+		family string
+		// familyExpiry is the fexp claim of the refresh tokens, the end of their family
+		familyExpiry time.Time
+	}
+
+	// TokenPair is the result of IssueTokenPair
+	TokenPair struct {
+		AuthToken    string
+		RefreshToken string
+		// ExpiresIn is the validity of the auth token
+		ExpiresIn time.Duration
 	}
 
 	Credentials struct {
@@ -19,6 +147,17 @@ type (
 		AuthToken    string
 		RefreshToken string
 
+		// Claims are populated once the auth token is validated
+		Claims jwt.MapClaims
+
+		// carriedBindings skips the proofs of the bound token whose binding is carried over to the token issued from
+		// it, see ExchangeToken
+		carriedBindings bool
+		// typed are the TypedClaims decoded along with the Claims, see WithClaimsType
+		typed TypedClaims
+		// payload is the verified json the Claims were decoded from, the TypedClaims are decoded from it
+		payload []byte
+
 		Options credentialOptions
 	}
 
@@ -0,0 +1,144 @@
+package jwt
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"sync"
+)
+
+type (
+	// Tenant holds the verification keys and the validation rules of the tokens of one issuer
+	Tenant struct {
+		ID string
+		// Issuer is matched against the iss claim, the tenant is only selectable by id when empty
+		Issuer   string
+		KeyStore KeyStore
+		// Audience must be present in the aud claim when set
+		Audience string
+	}
+
+	// TenantRegistry is the concurrency safe set of tenants, tenants can be registered and removed at runtime
+	TenantRegistry struct {
+		mutex    sync.RWMutex
+		byID     map[string]*Tenant
+		byIssuer map[string]*Tenant
+	}
+)
+
+var (
+	ErrUnknownTenant = errors.New("unknown tenant")
+)
+
+func NewTenantRegistry(tenants ...*Tenant) (*TenantRegistry, error) {
+	registry := &TenantRegistry{
+		byID:     make(map[string]*Tenant),
+		byIssuer: make(map[string]*Tenant),
+	}
+	for _, tenant := range tenants {
+		if err := registry.Register(tenant); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// Register adds or replaces the tenant of the same id
+func (registry *TenantRegistry) Register(tenant *Tenant) error {
+	if tenant == nil || tenant.ID == "" {
+		return errors.New("tenant with an id is required")
+	}
+	if tenant.KeyStore == nil {
+		return errors.New("tenant " + tenant.ID + " has no key store")
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if existing, ok := registry.byIssuer[tenant.Issuer]; ok && tenant.Issuer != "" && existing.ID != tenant.ID {
+		return errors.New("issuer " + tenant.Issuer + " is already registered by tenant " + existing.ID)
+	}
+	if previous, ok := registry.byID[tenant.ID]; ok {
+		delete(registry.byIssuer, previous.Issuer)
+	}
+	registry.byID[tenant.ID] = tenant
+	if tenant.Issuer != "" {
+		registry.byIssuer[tenant.Issuer] = tenant
+	}
+	return nil
+}
+
+// Remove drops the tenant, its tokens are no longer accepted
+func (registry *TenantRegistry) Remove(id string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if tenant, ok := registry.byID[id]; ok {
+		delete(registry.byIssuer, tenant.Issuer)
+		delete(registry.byID, id)
+	}
+}
+
+func (registry *TenantRegistry) Tenant(id string) (*Tenant, bool) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	tenant, ok := registry.byID[id]
+	return tenant, ok
+}
+
+// resolve selects the tenant by id when given, by issuer otherwise, the issuer must match either way
+func (registry *TenantRegistry) resolve(id, issuer string) (*Tenant, error) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	var tenant *Tenant
+	if id != "" {
+		tenant = registry.byID[id]
+	} else if issuer != "" {
+		tenant = registry.byIssuer[issuer]
+	}
+	if tenant == nil || (tenant.Issuer != "" && tenant.Issuer != issuer) {
+		return nil, ErrUnknownTenant
+	}
+	return tenant, nil
+}
+
+// keyFunc resolves the key from the KeyStore of the tenant, the selected tenant is stored in selected when not nil
+func (registry *TenantRegistry) keyFunc(id string, selected **Tenant) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		// the claims are not verified yet, the key of the tenant they claim verifies them
+		issuer, _ := tokenClaims(token)["iss"].(string)
+		tenant, err := registry.resolve(id, issuer)
+		if err != nil {
+			return nil, err
+		}
+		if selected != nil {
+			*selected = tenant
+		}
+		return keyStoreKeyFunc(tenant.KeyStore)(token)
+	}
+}
+
+// validateClaims applies the rules of the tenant to the verified claims
+func (tenant *Tenant) validateClaims(claims jwt.MapClaims) error {
+	if tenant == nil || tenant.Audience == "" {
+		return nil
+	}
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud == tenant.Audience {
+			return nil
+		}
+	case []interface{}:
+		for _, value := range aud {
+			if value == tenant.Audience {
+				return nil
+			}
+		}
+	}
+	return turboError.Wrap(turboError.ErrTokenInvalid, errors.New("token audience does not match tenant "+tenant.ID))
+}
+
+func (authConfig *JwtAuthConfig) tenantID(r *http.Request) string {
+	if r == nil || authConfig.TenantHeader == "" {
+		return ""
+	}
+	return r.Header.Get(authConfig.TenantHeader)
+}
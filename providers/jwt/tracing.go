@@ -0,0 +1,57 @@
+package jwt
+
+import (
+	"context"
+	"github.com/nandlabs/turbo-auth/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+const (
+	tracerName = "github.com/nandlabs/turbo-auth/providers/jwt"
+
+	AttributeProvider  = "auth.provider"
+	AttributeOutcome   = "auth.outcome"
+	AttributeErrorKind = "auth.error_kind"
+)
+
+// WithTracerProvider enables the OpenTelemetry spans of request handling, token issuance, revocation and the JWKS handler
+func WithTracerProvider(tracerProvider trace.TracerProvider) Option {
+	return func(authConfig *JwtAuthConfig) {
+		authConfig.TracerProvider = tracerProvider
+	}
+}
+
+func (authConfig *JwtAuthConfig) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	tracerProvider := authConfig.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = trace.NewNoopTracerProvider()
+	}
+	return tracerProvider.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attribute.String(AttributeProvider, "jwt")))
+}
+
+// endSpan records the outcome of the span, err must be an untyped nil on success
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.SetAttributes(
+			attribute.String(AttributeOutcome, "failure"),
+			attribute.String(AttributeErrorKind, metrics.Reason(err)),
+		)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.String(AttributeOutcome, "success"))
+	}
+	span.End()
+}
+
+// traceHandler wraps the handler in a span named name
+func (authConfig *JwtAuthConfig) traceHandler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := authConfig.startSpan(r.Context(), name)
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
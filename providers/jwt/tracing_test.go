@@ -0,0 +1,106 @@
+package jwt
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type (
+	recordedSpan struct {
+		trace.Span
+		name       string
+		attributes map[attribute.Key]string
+		status     codes.Code
+		ended      bool
+	}
+
+	recordingTracer struct {
+		spans []*recordedSpan
+	}
+)
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, attr := range kv {
+		s.attributes[attr.Key] = attr.Value.Emit()
+	}
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, description string) {
+	s.status = code
+}
+
+func (s *recordedSpan) RecordError(err error, options ...trace.EventOption) {
+}
+
+func (s *recordedSpan) End(options ...trace.SpanEndOption) {
+	s.ended = true
+}
+
+func (t *recordingTracer) Tracer(name string, options ...trace.TracerOption) trace.Tracer {
+	return t
+}
+
+func (t *recordingTracer) Start(ctx context.Context, spanName string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	span := &recordedSpan{
+		Span:       trace.SpanFromContext(ctx),
+		name:       spanName,
+		attributes: make(map[attribute.Key]string),
+	}
+	t.spans = append(t.spans, span)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func TestJwtAuthConfig_tracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+		Revoker:       NewMemoryRevoker(),
+	}, WithTracerProvider(tracer))
+
+	token, jwtErr := authConfig.IssueNewToken("test_user", time.Minute)
+	if jwtErr != nil {
+		t.Fatalf("IssueNewToken() error = %v", jwtErr)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(authConfig.AuthTokenName, token)
+	_ = authConfig.HandleRequest(httptest.NewRecorder(), r)
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(authConfig.AuthTokenName, "malformed")
+	_ = authConfig.HandleRequest(httptest.NewRecorder(), r)
+
+	want := []struct {
+		name    string
+		outcome string
+		status  codes.Code
+	}{
+		{"jwt.IssueNewToken", "success", codes.Unset},
+		{"jwt.HandleRequest", "success", codes.Unset},
+		{"jwt.Revoker.IsRevoked", "success", codes.Unset},
+		{"jwt.HandleRequest", "failure", codes.Error},
+	}
+	if len(tracer.spans) != len(want) {
+		t.Fatalf("spans = %v, want %v", len(tracer.spans), len(want))
+	}
+	for i, tt := range want {
+		span := tracer.spans[i]
+		if span.name != tt.name || span.attributes[AttributeOutcome] != tt.outcome || span.status != tt.status {
+			t.Errorf("span %v = %v/%v/%v, want %v/%v/%v", i, span.name, span.attributes[AttributeOutcome], span.status,
+				tt.name, tt.outcome, tt.status)
+		}
+		if !span.ended {
+			t.Errorf("span %v was not ended", span.name)
+		}
+	}
+	if kind := tracer.spans[3].attributes[AttributeErrorKind]; kind != "malformed" {
+		t.Errorf("error kind = %v, want malformed", kind)
+	}
+}
@@ -0,0 +1,84 @@
+package jwt
+
+import (
+	"time"
+)
+
+type (
+	// TokenTTL is the lifetime of the auth and refresh tokens of a pair, a zero duration keeps the configured
+	// AuthTokenValidTime or RefreshTokenValidTime
+	TokenTTL struct {
+		Auth    time.Duration
+		Refresh time.Duration
+	}
+
+	// TTLRequest describes the token being issued to the TTLPolicy
+	TTLRequest struct {
+		Subject string
+		Roles   []string
+		// Audience is the audience of the exchanged tokens, empty for the token pairs
+		Audience string
+		// Authentication is the one of IssueAuthenticatedTokenPair, nil otherwise
+		Authentication *Authentication
+	}
+
+	// TTLPolicy selects the lifetime of the tokens at issuance, e.g. 15 minutes for the admin tokens and 24 hours for
+	// the service tokens
+	TTLPolicy func(request *TTLRequest) TokenTTL
+)
+
+// TTLByRole applies the lifetimes of the roles of the subject, the shortest one wins when the subject has several of
+// the roles, e.g. TTLByRole(map[string]TokenTTL{"admin": {Auth: 15 * time.Minute}})
+func TTLByRole(ttls map[string]TokenTTL) TTLPolicy {
+	return func(request *TTLRequest) TokenTTL {
+		var selected TokenTTL
+		for _, role := range request.Roles {
+			ttl, ok := ttls[role]
+			if !ok {
+				continue
+			}
+			if ttl.Auth > 0 && (selected.Auth == 0 || ttl.Auth < selected.Auth) {
+				selected.Auth = ttl.Auth
+			}
+			if ttl.Refresh > 0 && (selected.Refresh == 0 || ttl.Refresh < selected.Refresh) {
+				selected.Refresh = ttl.Refresh
+			}
+		}
+		return selected
+	}
+}
+
+// tokenTTL evaluates the TTLPolicy, the configured lifetimes fill the durations it leaves to 0
+func (authConfig *JwtAuthConfig) tokenTTL(request *TTLRequest) TokenTTL {
+	var ttl TokenTTL
+	if authConfig.TTLPolicy != nil {
+		ttl = authConfig.TTLPolicy(request)
+	}
+	if ttl.Auth <= 0 {
+		ttl.Auth = authConfig.AuthTokenValidTime
+	}
+	if ttl.Refresh <= 0 {
+		ttl.Refresh = authConfig.RefreshTokenValidTime
+	}
+	return ttl
+}
+
+// familyLifetime is the absolute lifetime of a refresh token family started with the ttl, the rotations never
+// extend it
+func (authConfig *JwtAuthConfig) familyLifetime(ttl TokenTTL) time.Duration {
+	if authConfig.RefreshFamilyLifetime > 0 {
+		return authConfig.RefreshFamilyLifetime
+	}
+	return ttl.Refresh
+}
+
+// capped bounds the lifetimes of the pair to what is left of the lifetime of its refresh family
+func (ttl TokenTTL) capped(remaining time.Duration) TokenTTL {
+	if ttl.Auth > remaining {
+		ttl.Auth = remaining
+	}
+	if ttl.Refresh > remaining {
+		ttl.Refresh = remaining
+	}
+	return ttl
+}
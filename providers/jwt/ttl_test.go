@@ -0,0 +1,75 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJwtAuthConfig_TTLPolicy(t *testing.T) {
+	authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+		SigningKey:            "test_key",
+		SigningMethod:         "HS256",
+		BearerTokens:          true,
+		AuthTokenValidTime:    time.Hour,
+		RefreshTokenValidTime: 48 * time.Hour,
+		TTLPolicy: TTLByRole(map[string]TokenTTL{
+			"admin":   {Auth: 15 * time.Minute, Refresh: time.Hour},
+			"auditor": {Auth: 30 * time.Minute},
+			"service": {Auth: 24 * time.Hour},
+		}),
+	})
+
+	tests := []struct {
+		name  string
+		roles []string
+		want  time.Duration
+	}{
+		{name: "Test_default", roles: []string{"user"}, want: time.Hour},
+		{name: "Test_admin", roles: []string{"admin"}, want: 15 * time.Minute},
+		{name: "Test_service", roles: []string{"service"}, want: 24 * time.Hour},
+		{name: "Test_shortest_role", roles: []string{"auditor", "admin"}, want: 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pair, jwtErr := authConfig.IssueTokenPair("test_user", tt.roles)
+			if jwtErr != nil {
+				t.Fatalf("IssueTokenPair() error = %v", jwtErr)
+			}
+			if pair.ExpiresIn != tt.want {
+				t.Errorf("ExpiresIn = %v, want %v", pair.ExpiresIn, tt.want)
+			}
+			identity, err := authConfig.Authenticate(pair.AuthToken)
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if got := time.Until(identity.ExpiresAt); got > tt.want || got < tt.want-time.Minute {
+				t.Errorf("token expires in %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTTLByRole(t *testing.T) {
+	policy := TTLByRole(map[string]TokenTTL{
+		"admin":   {Auth: 15 * time.Minute, Refresh: time.Hour},
+		"auditor": {Auth: 30 * time.Minute, Refresh: 30 * time.Minute},
+	})
+	tests := []struct {
+		name  string
+		roles []string
+		want  TokenTTL
+	}{
+		{name: "Test_no_role", want: TokenTTL{}},
+		{name: "Test_unknown_role", roles: []string{"user"}, want: TokenTTL{}},
+		{name: "Test_single_role", roles: []string{"admin"}, want: TokenTTL{Auth: 15 * time.Minute, Refresh: time.Hour}},
+		{name: "Test_shortest_of_each", roles: []string{"admin", "auditor"},
+			want: TokenTTL{Auth: 15 * time.Minute, Refresh: 30 * time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy(&TTLRequest{Subject: "test_user", Roles: tt.roles}); got != tt.want {
+				t.Errorf("TTLByRole() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
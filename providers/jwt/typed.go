@@ -0,0 +1,63 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"time"
+)
+
+type (
+	// TypedClaims is implemented by the claims structs of the application embedding the Payload, e.g.
+	//
+	//	type OrderClaims struct {
+	//		jwt.Payload
+	//		Roles    []string `json:"Roles,omitempty"`
+	//		TenantID string   `json:"tid"`
+	//	}
+	//
+	// The struct is encoded by the Serializer, the methods generated by cmd/claimsgen replace the reflection of
+	// encoding/json
+	TypedClaims interface {
+		jwt.Claims
+		StandardPayload() *Payload
+	}
+
+	// ClaimsFactory returns a new claims struct to decode the validated tokens into, see WithClaimsType
+	ClaimsFactory func() TypedClaims
+)
+
+// IssueTypedToken fills the Payload of the claims for the username and signs them, the other fields of the struct
+// are issued as they are
+func (authConfig *JwtAuthConfig) IssueTypedToken(username string, duration time.Duration, claims TypedClaims) (string, *turboError.JwtError) {
+	payload, err := newPayload(username, duration, authConfig.now())
+	if err != nil {
+		return "", turboError.NewJwtError(err, 406)
+	}
+	*claims.StandardPayload() = *payload
+	token, jwtErr := authConfig.signPayload(context.Background(), &serializedClaims{TypedClaims: claims,
+		serializer: authConfig.serializer()})
+	identity := &turboAuth.Identity{Subject: username, TokenID: payload.ID.String()}
+	authConfig.audit(nil, audit.EventTokenIssued, identity, jwtErrOrNil(jwtErr))
+	return token, jwtErr
+}
+
+// decodeTypedClaims decodes the verified payload of the token into a new struct of the ClaimsType, the raw json is
+// decoded rather than the claims map so that the generated methods apply
+func (authConfig *JwtAuthConfig) decodeTypedClaims(payload []byte) (TypedClaims, error) {
+	if payload == nil {
+		return nil, turboError.Wrap(turboError.ErrTokenMalformed, errors.New("no payload to decode the typed claims from"))
+	}
+	raw, err := inflateRawClaims(payload)
+	if err != nil {
+		return nil, err
+	}
+	claims := authConfig.ClaimsType()
+	if err := authConfig.serializer().Unmarshal(raw, claims); err != nil {
+		return nil, turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	return claims, nil
+}
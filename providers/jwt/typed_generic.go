@@ -0,0 +1,56 @@
+//go:build go1.18
+
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+)
+
+// ClaimsPointer constrains the type parameters of the generic helpers to the pointers of the claims structs, e.g.
+// ParseToken[OrderClaims](authenticator, token) returns an *OrderClaims
+type ClaimsPointer[C any] interface {
+	*C
+	TypedClaims
+}
+
+// ErrNoIdentity is returned by ClaimsFromContext when the context carries no identity
+var ErrNoIdentity = errors.New("no identity in the context")
+
+// ParseToken validates the token with the authenticator and returns its claims decoded into a new C
+func ParseToken[C any, P ClaimsPointer[C]](authenticator turboAuth.TokenAuthenticator, token string) (*C, error) {
+	identity, err := authenticator.Authenticate(token)
+	if err != nil {
+		return nil, err
+	}
+	return ClaimsOf[C, P](identity)
+}
+
+// ClaimsOf returns the claims of the identity as a C, the TypedClaims are returned as they are when the provider is
+// configured with the same type by WithClaimsType, the claims map is decoded otherwise
+func ClaimsOf[C any, P ClaimsPointer[C]](identity *turboAuth.Identity) (*C, error) {
+	if claims, ok := identity.TypedClaims.(P); ok {
+		return claims, nil
+	}
+	raw, err := json.Marshal(identity.Claims)
+	if err != nil {
+		return nil, turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	claims := new(C)
+	if err := json.Unmarshal(raw, claims); err != nil {
+		return nil, turboError.Wrap(turboError.ErrTokenMalformed, err)
+	}
+	return claims, nil
+}
+
+// ClaimsFromContext returns the claims of the identity set by the middleware as a C
+func ClaimsFromContext[C any, P ClaimsPointer[C]](ctx context.Context) (*C, error) {
+	identity, ok := turboAuth.IdentityFromContext(ctx)
+	if !ok {
+		return nil, ErrNoIdentity
+	}
+	return ClaimsOf[C, P](identity)
+}
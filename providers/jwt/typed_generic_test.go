@@ -0,0 +1,60 @@
+//go:build go1.18
+
+package jwt
+
+import (
+	"context"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"testing"
+	"time"
+)
+
+func TestParseToken(t *testing.T) {
+	claimsType := WithClaimsType(func() TypedClaims {
+		return &orderClaims{}
+	})
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{name: "Test_claims_map"},
+		{name: "Test_claims_type", options: []Option{claimsType}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+				SigningKey:    "test_key",
+				SigningMethod: "HS256",
+				BearerTokens:  true,
+			}, tt.options...)
+			token, jwtErr := authConfig.IssueTypedToken("test_user", time.Minute, &orderClaims{TenantID: "acme"})
+			if jwtErr != nil {
+				t.Fatalf("IssueTypedToken() error = %v", jwtErr)
+			}
+			claims, err := ParseToken[orderClaims](authConfig, token)
+			if err != nil {
+				t.Fatalf("ParseToken() error = %v", err)
+			}
+			if claims.Username != "test_user" || claims.TenantID != "acme" {
+				t.Errorf("ParseToken() = %+v, want the issued claims", claims)
+			}
+			if _, err := ParseToken[orderClaims](authConfig, token+"x"); err == nil {
+				t.Errorf("ParseToken() of a tampered token succeeded")
+			}
+		})
+	}
+}
+
+func TestClaimsFromContext(t *testing.T) {
+	if _, err := ClaimsFromContext[orderClaims](context.Background()); !errors.Is(err, ErrNoIdentity) {
+		t.Errorf("ClaimsFromContext() error = %v, want %v", err, ErrNoIdentity)
+	}
+	ctx := turboAuth.NewContext(context.Background(), &turboAuth.Identity{
+		Claims: map[string]interface{}{"Username": "test_user", "tid": "acme"},
+	})
+	claims, err := ClaimsFromContext[orderClaims](ctx)
+	if err != nil || claims.Username != "test_user" || claims.TenantID != "acme" {
+		t.Errorf("ClaimsFromContext() = %+v, %v, want the claims of the identity", claims, err)
+	}
+}
@@ -0,0 +1,65 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+)
+
+type orderClaims struct {
+	Payload
+	Roles    []string `json:"Roles,omitempty"`
+	TenantID string   `json:"tid"`
+}
+
+func TestJwtAuthConfig_IssueTypedToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		revoker     Revoker
+		cache       *ValidationCache
+		wantVersion int64
+	}{
+		{name: "Test_typed_claims"},
+		{name: "Test_versioned_claims", revoker: NewMemoryRevoker(), wantVersion: 1},
+		{name: "Test_cached_claims", cache: NewValidationCache(0, time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authConfig := CreateJwtAuthenticator(&JwtAuthConfig{
+				SigningKey:      "test_key",
+				SigningMethod:   "HS256",
+				BearerTokens:    true,
+				Revoker:         tt.revoker,
+				ValidationCache: tt.cache,
+			}, WithClaimsType(func() TypedClaims {
+				return &orderClaims{}
+			}))
+			if tt.revoker != nil {
+				if err := authConfig.RevokeAllTokens(nil, "test_user"); err != nil {
+					t.Fatalf("RevokeAllTokens() error = %v", err)
+				}
+			}
+			token, jwtErr := authConfig.IssueTypedToken("test_user", time.Minute, &orderClaims{
+				Roles:    []string{"admin"},
+				TenantID: "acme",
+			})
+			if jwtErr != nil {
+				t.Fatalf("IssueTypedToken() error = %v", jwtErr)
+			}
+			// the second authentication is served by the ValidationCache when set
+			for i := 0; i < 2; i++ {
+				identity, err := authConfig.Authenticate(token)
+				if err != nil {
+					t.Fatalf("Authenticate() error = %v", err)
+				}
+				claims, ok := identity.TypedClaims.(*orderClaims)
+				if !ok {
+					t.Fatalf("TypedClaims = %T, want *orderClaims", identity.TypedClaims)
+				}
+				if claims.Username != "test_user" || claims.TenantID != "acme" || claims.Version != tt.wantVersion ||
+					!identity.HasRole("admin") {
+					t.Errorf("TypedClaims = %+v, want the issued claims", claims)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,499 @@
+package kubernetes
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/logging"
+	"github.com/nandlabs/turbo-auth/providers/oauth"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Provider validates the projected service account tokens of the pods, with the TokenReview api of the cluster
+	// or locally against the keys of the cluster issuer when a Verifier is set (see NewOIDCProvider), it implements
+	// turboAuth.Authenticator and turboAuth.TokenAuthenticator
+	Provider struct {
+		// APIServer is the url of the kubernetes api server, for the TokenReview mode
+		APIServer string
+		// ReviewerToken authenticates the TokenReview calls, the service account needs the system:auth-delegator
+		// role
+		ReviewerToken string
+		// ReviewerTokenFile is read on each review instead of the ReviewerToken, projected tokens are rotated
+		ReviewerTokenFile string
+		// Audiences the tokens must be issued for, the api server audiences when empty (TokenReview only)
+		Audiences []string
+		// Verifier validates the tokens without calling the api server
+		Verifier *oauth.IDTokenVerifier
+		Client   *http.Client
+		// CacheTTL caches the reviews, never past the expiry of the token, disabled when 0
+		CacheTTL time.Duration
+		// CacheSize bounds the cached reviews, the least recently used one is evicted once full, DefaultCacheSize
+		// when 0
+		CacheSize   int
+		ErrorWriter turboError.ErrorWriter
+
+		mutex sync.Mutex
+		cache map[string]*list.Element
+		lru   *list.List
+	}
+
+	// ServiceAccount is the workload a token was issued to
+	ServiceAccount struct {
+		Namespace string
+		Name      string
+		UID       string
+		// PodName and PodUID are set for the tokens bound to a pod
+		PodName string
+		PodUID  string
+		Groups  []string
+	}
+
+	tokenReview struct {
+		APIVersion string            `json:"apiVersion"`
+		Kind       string            `json:"kind"`
+		Spec       tokenReviewSpec   `json:"spec"`
+		Status     tokenReviewStatus `json:"status"`
+	}
+
+	tokenReviewSpec struct {
+		Token     string   `json:"token"`
+		Audiences []string `json:"audiences,omitempty"`
+	}
+
+	tokenReviewStatus struct {
+		Authenticated bool `json:"authenticated"`
+		User          struct {
+			Username string              `json:"username"`
+			UID      string              `json:"uid"`
+			Groups   []string            `json:"groups"`
+			Extra    map[string][]string `json:"extra"`
+		} `json:"user"`
+		// Audiences are the requested audiences the token is valid for
+		Audiences []string `json:"audiences"`
+		Error     string   `json:"error"`
+	}
+
+	discoveryDocument struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+
+	cacheEntry struct {
+		key       string
+		identity  *turboAuth.Identity
+		expiresAt time.Time
+	}
+)
+
+const (
+	DefaultCacheTTL  = time.Minute
+	DefaultCacheSize = 10000
+	// ServiceAccountDir is where the kubelet mounts the token and the CA of the pod's service account
+	ServiceAccountDir     = "/var/run/secrets/kubernetes.io/serviceaccount"
+	serviceAccountPrefix  = "system:serviceaccount:"
+	tokenReviewPath       = "/apis/authentication.k8s.io/v1/tokenreviews"
+	discoveryPath         = "/.well-known/openid-configuration"
+	extraPodName          = "authentication.kubernetes.io/pod-name"
+	extraPodUID           = "authentication.kubernetes.io/pod-uid"
+	claimKubernetes       = "kubernetes.io"
+	claimServiceAccount   = "serviceaccount"
+	claimNamespace        = "namespace"
+	claimPod              = "pod"
+	defaultRequestTimeout = 10 * time.Second
+)
+
+var (
+	logger = logging.Get()
+
+	ErrNotInCluster   = errors.New("kubernetes: not running in a cluster, KUBERNETES_SERVICE_HOST is not set")
+	ErrNotWorkload    = errors.New("kubernetes: the token was not issued to a service account")
+	ErrIdentityAbsent = errors.New("kubernetes: no service account identity in the context")
+	ErrAudience       = errors.New("kubernetes: the token is not valid for the requested audiences")
+)
+
+// NewTokenReviewProvider validates the tokens with the TokenReview api of the apiServer
+func NewTokenReviewProvider(apiServer string, reviewerToken string, audiences ...string) *Provider {
+	return &Provider{
+		APIServer:     strings.TrimSuffix(apiServer, "/"),
+		ReviewerToken: reviewerToken,
+		Audiences:     audiences,
+		Client:        &http.Client{Timeout: defaultRequestTimeout},
+		CacheTTL:      DefaultCacheTTL,
+		CacheSize:     DefaultCacheSize,
+	}
+}
+
+// InClusterProvider validates the tokens with the TokenReview api using the service account of the pod
+func InClusterProvider(audiences ...string) (*Provider, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrNotInCluster
+	}
+	ca, err := ioutil.ReadFile(ServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("kubernetes: no certificate in the service account ca.crt")
+	}
+	provider := NewTokenReviewProvider("https://"+net.JoinHostPort(host, port), "", audiences...)
+	provider.ReviewerTokenFile = ServiceAccountDir + "/token"
+	provider.Client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	return provider, nil
+}
+
+// NewOIDCProvider discovers the keys of the cluster issuer (--service-account-issuer), the tokens are then validated
+// locally for the audience, client is used for the discovery and the keys, http.DefaultClient when nil
+func NewOIDCProvider(ctx context.Context, issuer string, audience string, client *http.Client) (*Provider, error) {
+	var discovery discoveryDocument
+	if err := getJSON(ctx, client, strings.TrimSuffix(issuer, "/")+discoveryPath, "", &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != issuer || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("kubernetes: unexpected discovery document of %s", issuer)
+	}
+	verifier := oauth.NewIDTokenVerifier(issuer, audience, discovery.JWKSURI)
+	verifier.Client = client
+	return &Provider{
+		Audiences: []string{audience},
+		Verifier:  verifier,
+		Client:    client,
+	}, nil
+}
+
+func (p *Provider) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			p.writeError(w, r, http.StatusUnauthorized, turboError.ErrMissingToken.Error())
+			return
+		}
+		identity, err := p.AuthenticateContext(r.Context(), token)
+		if err != nil {
+			statusCode := http.StatusUnauthorized
+			if !errors.Is(err, turboError.ErrTokenInvalid) {
+				statusCode = http.StatusServiceUnavailable
+			}
+			p.writeError(w, r, statusCode, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(turboAuth.NewContext(r.Context(), identity)))
+	})
+}
+
+func (p *Provider) Authenticate(token string) (*turboAuth.Identity, error) {
+	return p.AuthenticateContext(context.Background(), token)
+}
+
+// AuthenticateContext validates the token, errors other than turboError.ErrTokenInvalid mean the api server or the
+// issuer could not be reached. The Subject of the identity is "system:serviceaccount:<namespace>:<name>" and the
+// Claims hold the namespace and serviceaccount
+func (p *Provider) AuthenticateContext(ctx context.Context, token string) (*turboAuth.Identity, error) {
+	if token == "" {
+		return nil, turboError.ErrMissingToken
+	}
+	if p.Verifier != nil {
+		claims, err := p.Verifier.Verify(ctx, token)
+		if err != nil {
+			logger.DebugF("service account token rejected: %v", err)
+			return nil, turboError.ErrTokenInvalid
+		}
+		return claimsIdentity(claims)
+	}
+	key := cacheKey(token)
+	if identity, ok := p.cached(key); ok {
+		return identity, nil
+	}
+	identity, err := p.review(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	p.store(key, identity)
+	return identity, nil
+}
+
+// review posts a TokenReview, the expiry of the identity is read from the token as the review does not return it
+func (p *Provider) review(ctx context.Context, token string) (*turboAuth.Identity, error) {
+	reviewerToken := p.ReviewerToken
+	if p.ReviewerTokenFile != "" {
+		content, err := ioutil.ReadFile(p.ReviewerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		reviewerToken = strings.TrimSpace(string(content))
+	}
+	body, err := json.Marshal(&tokenReview{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec:       tokenReviewSpec{Token: token, Audiences: p.Audiences},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.APIServer+tokenReviewPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if reviewerToken != "" {
+		req.Header.Set(turboAuth.HeaderAuthorization, "Bearer "+reviewerToken)
+	}
+	var review tokenReview
+	if err := do(p.Client, req, &review); err != nil {
+		return nil, err
+	}
+	status := review.Status
+	if !status.Authenticated {
+		logger.DebugF("service account token rejected: %s", status.Error)
+		return nil, turboError.ErrTokenInvalid
+	}
+	if !p.audienceGranted(status.Audiences) {
+		logger.DebugF("service account token rejected: audiences %v, requested %v", status.Audiences, p.Audiences)
+		return nil, turboError.Wrap(turboError.ErrTokenInvalid, ErrAudience)
+	}
+	account, err := parseUsername(status.User.Username)
+	if err != nil {
+		return nil, err
+	}
+	account.UID = status.User.UID
+	account.Groups = status.User.Groups
+	if values := status.User.Extra[extraPodName]; len(values) > 0 {
+		account.PodName = values[0]
+	}
+	if values := status.User.Extra[extraPodUID]; len(values) > 0 {
+		account.PodUID = values[0]
+	}
+	identity := account.Identity()
+	if exp := unverifiedExpiry(token); !exp.IsZero() {
+		identity.ExpiresAt = exp
+	}
+	return identity, nil
+}
+
+// audienceGranted checks the api server validated the token for one of the requested Audiences, the api server
+// audiences are trusted when none is requested
+func (p *Provider) audienceGranted(audiences []string) bool {
+	if len(p.Audiences) == 0 {
+		return true
+	}
+	for _, audience := range audiences {
+		for _, requested := range p.Audiences {
+			if audience == requested {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Identity maps the service account, the groups become the roles
+func (a *ServiceAccount) Identity() *turboAuth.Identity {
+	identity := &turboAuth.Identity{
+		Subject: serviceAccountPrefix + a.Namespace + ":" + a.Name,
+		Roles:   a.Groups,
+		Claims: map[string]interface{}{
+			claimNamespace:      a.Namespace,
+			claimServiceAccount: a.Name,
+		},
+	}
+	if a.UID != "" {
+		identity.Claims["uid"] = a.UID
+	}
+	if a.PodName != "" {
+		identity.Claims["pod"] = a.PodName
+		identity.Claims["pod_uid"] = a.PodUID
+	}
+	return identity
+}
+
+// FromContext returns the service account of the identity authenticated by the Provider
+func FromContext(ctx context.Context) (*ServiceAccount, error) {
+	identity, ok := turboAuth.IdentityFromContext(ctx)
+	if !ok || !strings.HasPrefix(identity.Subject, serviceAccountPrefix) {
+		return nil, ErrIdentityAbsent
+	}
+	account, err := parseUsername(identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	account.Groups = identity.Roles
+	account.UID, _ = identity.Claims["uid"].(string)
+	account.PodName, _ = identity.Claims["pod"].(string)
+	account.PodUID, _ = identity.Claims["pod_uid"].(string)
+	return account, nil
+}
+
+// claimsIdentity maps the claims of a verified projected token, the kubernetes.io claim holds the namespace, the
+// service account and the pod
+func claimsIdentity(claims map[string]interface{}) (*turboAuth.Identity, error) {
+	account, err := parseUsername(stringValue(claims, "sub"))
+	if err != nil {
+		return nil, err
+	}
+	if private, ok := claims[claimKubernetes].(map[string]interface{}); ok {
+		if serviceAccount, ok := private[claimServiceAccount].(map[string]interface{}); ok {
+			account.UID = stringValue(serviceAccount, "uid")
+		}
+		if pod, ok := private[claimPod].(map[string]interface{}); ok {
+			account.PodName, account.PodUID = stringValue(pod, "name"), stringValue(pod, "uid")
+		}
+	}
+	account.Groups = []string{"system:serviceaccounts", "system:serviceaccounts:" + account.Namespace}
+	identity := account.Identity()
+	identity.TokenID = stringValue(claims, "jti")
+	if exp, ok := claims["exp"].(float64); ok {
+		identity.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	return identity, nil
+}
+
+// parseUsername splits "system:serviceaccount:<namespace>:<name>"
+func parseUsername(username string) (*ServiceAccount, error) {
+	parts := strings.Split(strings.TrimPrefix(username, serviceAccountPrefix), ":")
+	if !strings.HasPrefix(username, serviceAccountPrefix) || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, turboError.Wrap(turboError.ErrTokenInvalid, ErrNotWorkload)
+	}
+	return &ServiceAccount{Namespace: parts[0], Name: parts[1]}, nil
+}
+
+func (p *Provider) cached(key string) (*turboAuth.Identity, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	element, ok := p.cache[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		p.remove(element)
+		return nil, false
+	}
+	p.lru.MoveToFront(element)
+	return entry.identity, true
+}
+
+func (p *Provider) store(key string, identity *turboAuth.Identity) {
+	if p.CacheTTL <= 0 {
+		return
+	}
+	expiresAt := time.Now().Add(p.CacheTTL)
+	if !identity.ExpiresAt.IsZero() && identity.ExpiresAt.Before(expiresAt) {
+		expiresAt = identity.ExpiresAt
+	}
+	size := p.CacheSize
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.cache == nil {
+		p.cache, p.lru = make(map[string]*list.Element), list.New()
+	}
+	if element, ok := p.cache[key]; ok {
+		p.remove(element)
+	}
+	p.cache[key] = p.lru.PushFront(&cacheEntry{key: key, identity: identity, expiresAt: expiresAt})
+	for p.lru.Len() > size {
+		p.remove(p.lru.Back())
+	}
+}
+
+// remove evicts the cached review, caller must hold the lock
+func (p *Provider) remove(element *list.Element) {
+	p.lru.Remove(element)
+	delete(p.cache, element.Value.(*cacheEntry).key)
+}
+
+func (p *Provider) writeError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	turboError.WriteError(p.ErrorWriter, w, r, &turboError.HttpError{
+		StatusCode: statusCode,
+		Message:    "Error : " + message + " \n",
+	})
+}
+
+// cacheKey hashes the token so the raw tokens are not kept in memory
+func cacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get(turboAuth.HeaderAuthorization)
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+func stringValue(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// getJSON sends a GET to the endpoint, with the bearer token when not empty, and decodes the json response into v
+func getJSON(ctx context.Context, client *http.Client, endpoint string, token string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set(turboAuth.HeaderAuthorization, "Bearer "+token)
+	}
+	return do(client, req, v)
+}
+
+func do(client *http.Client, req *http.Request, v interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return fmt.Errorf("kubernetes: %s returned %s", req.URL, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// unverifiedExpiry reads the exp claim of a token the api server already validated
+func unverifiedExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,235 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	turboJwt "github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCluster fakes the api server: the TokenReview api and the service account issuer discovery, the tokens are
+// signed with key
+func newCluster(t *testing.T, key *rsa.PrivateKey, reviews *int32) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case tokenReviewPath:
+			atomic.AddInt32(reviews, 1)
+			if r.Header.Get("Authorization") != "Bearer reviewer" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			var review tokenReview
+			if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			claims := jwt.MapClaims{}
+			_, err := jwt.ParseWithClaims(review.Spec.Token, claims, func(*jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			})
+			if err == nil && claims.VerifyAudience("payments", true) {
+				review.Status.Authenticated = true
+				review.Status.Audiences = []string{"payments"}
+				review.Status.User.Username = claims["sub"].(string)
+				review.Status.User.UID = "sa-uid"
+				review.Status.User.Groups = []string{"system:serviceaccounts", "system:serviceaccounts:shop"}
+				review.Status.User.Extra = map[string][]string{extraPodName: {"checkout-7f9c"}, extraPodUID: {"pod-uid"}}
+			} else {
+				review.Status.Error = "invalid bearer token"
+			}
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(review)
+		case discoveryPath:
+			_ = json.NewEncoder(w).Encode(discoveryDocument{Issuer: server.URL, JWKSURI: server.URL + "/openid/v1/jwks"})
+		case "/openid/v1/jwks":
+			jwk, _ := turboJwt.NewJWK(&turboJwt.Key{ID: "sa-key", SigningMethod: "RS256", VerifyKey: &key.PublicKey})
+			_ = json.NewEncoder(w).Encode(turboJwt.JWKS{Keys: []turboJwt.JWK{jwk}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func projectedToken(t *testing.T, key *rsa.PrivateKey, issuer string, audience string, subject string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": issuer,
+		"aud": []string{audience},
+		"sub": subject,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"kubernetes.io": map[string]interface{}{
+			"namespace":      "shop",
+			"pod":            map[string]interface{}{"name": "checkout-7f9c", "uid": "pod-uid"},
+			"serviceaccount": map[string]interface{}{"name": "checkout", "uid": "sa-uid"},
+		},
+	})
+	token.Header["kid"] = "sa-key"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestProvider_Authenticate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reviews int32
+	cluster := newCluster(t, key, &reviews)
+	oidc, err := NewOIDCProvider(context.Background(), cluster.URL, "payments", nil)
+	if err != nil {
+		t.Fatalf("NewOIDCProvider() error = %v", err)
+	}
+	providers := map[string]*Provider{
+		"review": NewTokenReviewProvider(cluster.URL, "reviewer", "payments"),
+		"oidc":   oidc,
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "Test_service_account", token: projectedToken(t, key, cluster.URL, "payments", "system:serviceaccount:shop:checkout")},
+		{name: "Test_wrong_audience", token: projectedToken(t, key, cluster.URL, "billing", "system:serviceaccount:shop:checkout"), wantErr: turboError.ErrTokenInvalid},
+		{name: "Test_not_a_service_account", token: projectedToken(t, key, cluster.URL, "payments", "admin"), wantErr: turboError.ErrTokenInvalid},
+		{name: "Test_garbage", token: "not.a.token", wantErr: turboError.ErrTokenInvalid},
+		{name: "Test_missing_token", wantErr: turboError.ErrMissingToken},
+	}
+	for mode, provider := range providers {
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				identity, err := provider.Authenticate(tt.token)
+				if tt.wantErr != nil {
+					if !errors.Is(err, tt.wantErr) {
+						t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Authenticate() error = %v", err)
+				}
+				account, err := FromContext(turboAuth.NewContext(context.Background(), identity))
+				if err != nil {
+					t.Fatalf("FromContext() error = %v", err)
+				}
+				if identity.Subject != "system:serviceaccount:shop:checkout" || account.Namespace != "shop" ||
+					account.Name != "checkout" || account.PodName != "checkout-7f9c" || account.UID != "sa-uid" ||
+					!identity.HasRole("system:serviceaccounts:shop") || identity.ExpiresAt.IsZero() {
+					t.Errorf("identity = %+v, account = %+v", identity, account)
+				}
+			})
+		}
+	}
+
+	before := atomic.LoadInt32(&reviews)
+	cached := NewTokenReviewProvider(cluster.URL, "reviewer", "payments")
+	token := projectedToken(t, key, cluster.URL, "payments", "system:serviceaccount:shop:checkout")
+	for i := 0; i < 3; i++ {
+		if _, err := cached.Authenticate(token); err != nil {
+			t.Fatalf("Authenticate() error = %v", err)
+		}
+	}
+	if calls := atomic.LoadInt32(&reviews) - before; calls != 1 {
+		t.Errorf("reviews = %v, want 1 with the cache", calls)
+	}
+
+	before = atomic.LoadInt32(&reviews)
+	bounded := NewTokenReviewProvider(cluster.URL, "reviewer", "payments")
+	bounded.CacheSize = 1
+	other := projectedToken(t, key, cluster.URL, "payments", "system:serviceaccount:shop:billing")
+	for _, token := range []string{token, other, other, token} {
+		if _, err := bounded.Authenticate(token); err != nil {
+			t.Fatalf("Authenticate() error = %v", err)
+		}
+	}
+	if calls := atomic.LoadInt32(&reviews) - before; calls != 3 || bounded.lru.Len() != 1 {
+		t.Errorf("reviews = %v, cached = %v, want 3 reviews and 1 cached", calls, bounded.lru.Len())
+	}
+}
+
+func TestProvider_reviewAudiences(t *testing.T) {
+	var audiences []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review tokenReview
+		_ = json.NewDecoder(r.Body).Decode(&review)
+		review.Status.Authenticated = true
+		review.Status.User.Username = "system:serviceaccount:shop:checkout"
+		review.Status.Audiences = audiences
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(review)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		requested []string
+		audiences []string
+		wantErr   error
+	}{
+		{name: "Test_requested_audience", requested: []string{"payments"}, audiences: []string{"payments"}},
+		{name: "Test_api_server_audience", requested: []string{"payments"}, audiences: []string{"https://kubernetes.default.svc"}, wantErr: ErrAudience},
+		{name: "Test_no_audience_returned", requested: []string{"payments"}, wantErr: ErrAudience},
+		{name: "Test_none_requested", audiences: []string{"https://kubernetes.default.svc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audiences = tt.audiences
+			provider := NewTokenReviewProvider(server.URL, "reviewer", tt.requested...)
+			_, err := provider.Authenticate("a.b.c")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !errors.Is(err, turboError.ErrTokenInvalid) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestProvider_Apply(t *testing.T) {
+	provider := NewTokenReviewProvider("http://127.0.0.1:1", "reviewer")
+	provider.Client.Timeout = time.Second
+	handler := provider.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		auth       string
+		wantStatus int
+	}{
+		{name: "Test_missing_token", wantStatus: http.StatusUnauthorized},
+		{name: "Test_unreachable_api_server", auth: "Bearer a.b.c", wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
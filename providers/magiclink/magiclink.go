@@ -0,0 +1,376 @@
+package magiclink
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/nandlabs/turbo-auth/audit"
+	"github.com/nandlabs/turbo-auth/clientip"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/idp"
+	"github.com/nandlabs/turbo-auth/logging"
+	"github.com/nandlabs/turbo-auth/ratelimit"
+	"github.com/nandlabs/turbo-auth/sessions"
+	"html/template"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTTL        = 15 * time.Minute
+	DefaultTokenParam = "token"
+
+	tokenType = "magic_link"
+)
+
+type (
+	// EmailSender delivers the login link, implementations must be safe for concurrent use
+	EmailSender interface {
+		SendLoginLink(ctx context.Context, email string, link string) error
+	}
+
+	// EmailSenderFunc adapts a function to the EmailSender interface
+	EmailSenderFunc func(ctx context.Context, email string, link string) error
+
+	// Provider authenticates the users by a signed, single use and short lived link sent to their email address.
+	// The link opens a confirmation page whose POST consumes the link, so that the scanners fetching the links of
+	// the emails do not use them up, then issues the token pair of the Issuer, or starts a session of the
+	// SessionManager when no Issuer is set
+	Provider struct {
+		// SigningKey signs the links, use a key dedicated to the links
+		SigningKey []byte
+		// CallbackURL is the url of the CallbackHandler, the token is appended as the TokenParam query parameter
+		CallbackURL string
+		TokenParam  string
+		TTL         time.Duration
+		Sender      EmailSender
+		// Store enforces the single use of the links
+		Store TokenStore
+		// Limiter limits the links sent to each email address, every link sent counts as an attempt
+		Limiter *ratelimit.Limiter
+		// IPLimiter limits the links requested from each client address, see clientip.Resolver
+		IPLimiter *ratelimit.Limiter
+		// ConfirmPage renders the confirmation form posting the link token back to the CallbackHandler,
+		// DefaultConfirmPage when nil
+		ConfirmPage *template.Template
+		// Users restricts the links to the known and enabled users and provides their roles when set, the email
+		// address is the username. Any address may log in when nil
+		Users          idp.UserStore
+		Issuer         idp.TokenIssuer
+		SessionManager *sessions.SessionManager
+		// Responder writes the token pair of the Issuer, see idp.WriteLoginResponse
+		Responder idp.TokenResponder
+		// RedirectURL is where the CallbackHandler sends the browser once logged in, the token pair is written
+		// instead when empty
+		RedirectURL string
+		AuditLogger audit.AuditLogger
+		// ErrorWriter renders the failures, plain text errors are written when nil
+		ErrorWriter turboError.ErrorWriter
+	}
+
+	linkRequest struct {
+		Email string `json:"email"`
+	}
+
+	// ConfirmData is the data of the ConfirmPage, the form must POST the Token as the TokenParam
+	ConfirmData struct {
+		TokenParam string
+		Token      string
+	}
+
+	// LimitedError is returned by SendLink when too many links were sent to the email address
+	LimitedError struct {
+		RetryAfter time.Duration
+	}
+)
+
+const (
+	limiterKeyPrefix   = "magiclink:"
+	ipLimiterKeyPrefix = "magiclink-ip:"
+)
+
+var (
+	ErrInvalidLink = errors.New("invalid or expired login link")
+	// ErrLinkUsed is returned when the link has already been used
+	ErrLinkUsed = errors.New("login link already used")
+
+	logger = logging.Get()
+
+	// DefaultConfirmPage asks the user to confirm the login with a POST
+	DefaultConfirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Log in</title></head>
+<body>
+<form method="post">
+<input type="hidden" name="{{.TokenParam}}" value="{{.Token}}">
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+)
+
+func (f EmailSenderFunc) SendLoginLink(ctx context.Context, email string, link string) error {
+	return f(ctx, email, link)
+}
+
+func NewProvider(signingKey []byte, callbackURL string, sender EmailSender) *Provider {
+	return &Provider{
+		SigningKey:  signingKey,
+		CallbackURL: callbackURL,
+		TokenParam:  DefaultTokenParam,
+		TTL:         DefaultTTL,
+		Sender:      sender,
+		Store:       NewMemoryTokenStore(),
+		Limiter:     ratelimit.NewLimiter(nil),
+		IPLimiter:   ratelimit.NewLimiter(nil),
+	}
+}
+
+// NewLink returns the signed login link of the email address
+func (p *Provider) NewLink(email string) (string, error) {
+	callbackURL, err := url.Parse(p.CallbackURL)
+	if err != nil {
+		return "", err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ": tokenType,
+		"sub": email,
+		"jti": hex.EncodeToString(id),
+		"exp": time.Now().Add(p.ttl()).Unix(),
+	}).SignedString(p.SigningKey)
+	if err != nil {
+		return "", err
+	}
+	query := callbackURL.Query()
+	query.Set(p.tokenParam(), token)
+	callbackURL.RawQuery = query.Encode()
+	return callbackURL.String(), nil
+}
+
+// SendLink sends the login link to the email address, the unknown or disabled users are silently skipped when
+// Users is set so the response does not reveal which addresses are registered. A *LimitedError is returned when
+// too many links were sent to the address
+func (p *Provider) SendLink(ctx context.Context, email string) error {
+	if err := limit(ctx, p.Limiter, limiterKeyPrefix+strings.ToLower(email)); err != nil {
+		return err
+	}
+	if p.Users != nil {
+		user, err := p.Users.FindByUsername(email)
+		if errors.Is(err, idp.ErrUserNotFound) || (err == nil && user.Disabled) {
+			logger.DebugF("no login link sent to the unknown or disabled user %s", email)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	link, err := p.NewLink(email)
+	if err != nil {
+		return err
+	}
+	return p.Sender.SendLoginLink(ctx, email, link)
+}
+
+// Verify checks the signature and expiry of the link token and consumes it, the email address is returned
+func (p *Provider) Verify(token string) (string, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return p.SigningKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return "", turboError.Wrap(turboError.ErrTokenInvalid, ErrInvalidLink)
+	}
+	email, _ := claims["sub"].(string)
+	id, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	expiresAt := time.Unix(int64(exp), 0)
+	if claims["typ"] != tokenType || email == "" || id == "" || !time.Now().Before(expiresAt) {
+		return "", turboError.Wrap(turboError.ErrTokenInvalid, ErrInvalidLink)
+	}
+	unused, err := p.Store.Consume(id, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	if !unused {
+		return "", turboError.Wrap(turboError.ErrTokenRevoked, ErrLinkUsed)
+	}
+	return email, nil
+}
+
+// RequestHandler sends the login link to the POSTed email address, form or json encoded, and answers 202 whether
+// or not the address is registered, 429 when too many links were requested for the address or from the client
+func (p *Provider) RequestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			p.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		email, err := readEmail(r)
+		if err != nil || !strings.Contains(email, "@") {
+			p.writeError(w, r, http.StatusBadRequest, "a valid email is required")
+			return
+		}
+		err = limit(r.Context(), p.IPLimiter, ipLimiterKeyPrefix+clientip.FromRequest(r))
+		if err == nil {
+			err = p.SendLink(r.Context(), email)
+		}
+		var limitedErr *LimitedError
+		if errors.As(err, &limitedErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limitedErr.RetryAfter.Seconds()))))
+			p.writeError(w, r, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		if err != nil {
+			logger.ErrorF("unable to send the login link: %v", err)
+			p.writeError(w, r, http.StatusInternalServerError, "unable to send the login link")
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// CallbackHandler renders the ConfirmPage of the link on GET, the POST of the page verifies and consumes the link,
+// then issues the tokens or starts the session
+func (p *Provider) CallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			p.confirm(w, r)
+			return
+		case http.MethodPost:
+		default:
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+			p.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		email, err := p.Verify(r.PostFormValue(p.tokenParam()))
+		var roles []string
+		if err == nil {
+			roles, err = p.roles(email)
+		}
+		if err != nil {
+			p.audit(r, email, err)
+			if errors.Is(err, ErrInvalidLink) || errors.Is(err, ErrLinkUsed) || errors.Is(err, idp.ErrInvalidCredentials) {
+				p.writeError(w, r, http.StatusUnauthorized, err.Error())
+			} else {
+				p.writeError(w, r, http.StatusInternalServerError, "unable to complete the login")
+			}
+			return
+		}
+		pair, err := idp.StartLogin(w, p.Issuer, p.SessionManager, email, roles, nil)
+		p.audit(r, email, err)
+		if err != nil {
+			p.writeError(w, r, http.StatusInternalServerError, "unable to complete the login")
+			return
+		}
+		idp.WriteLoginResponse(w, r, p.Responder, p.Issuer, pair, p.RedirectURL)
+	})
+}
+
+// confirm renders the ConfirmPage without consuming the link, the link token must not leak to the referred sites
+func (p *Provider) confirm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get(p.tokenParam())
+	if token == "" {
+		p.writeError(w, r, http.StatusBadRequest, "a login link token is required")
+		return
+	}
+	page := p.ConfirmPage
+	if page == nil {
+		page = DefaultConfirmPage
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	if err := page.Execute(w, &ConfirmData{TokenParam: p.tokenParam(), Token: token}); err != nil {
+		logger.ErrorF("unable to render the login confirmation: %v", err)
+	}
+}
+
+// roles returns the roles of the user, the user may have been disabled since the link was sent
+func (p *Provider) roles(email string) ([]string, error) {
+	if p.Users == nil {
+		return nil, nil
+	}
+	user, err := p.Users.FindByUsername(email)
+	if errors.Is(err, idp.ErrUserNotFound) || (err == nil && user.Disabled) {
+		return nil, idp.ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	return p.Users.GetRoles(user)
+}
+
+func (p *Provider) audit(r *http.Request, email string, err error) {
+	if p.AuditLogger == nil {
+		return
+	}
+	event := audit.NewEvent(r, audit.EventLogin, "magiclink", err)
+	event.Subject = email
+	p.AuditLogger.Log(event)
+}
+
+func (p *Provider) writeError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	turboError.WriteError(p.ErrorWriter, w, r, &turboError.HttpError{
+		StatusCode: statusCode,
+		Message:    "Error : " + message + " \n",
+	})
+}
+
+func (p *Provider) ttl() time.Duration {
+	if p.TTL > 0 {
+		return p.TTL
+	}
+	return DefaultTTL
+}
+
+func (p *Provider) tokenParam() string {
+	if p.TokenParam != "" {
+		return p.TokenParam
+	}
+	return DefaultTokenParam
+}
+
+func (err *LimitedError) Error() string {
+	return "too many login links requested, retry after " + strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))) + "s"
+}
+
+// limit counts an attempt of the key, a *LimitedError is returned when the key is locked out
+func limit(ctx context.Context, limiter *ratelimit.Limiter, key string) error {
+	if limiter == nil {
+		return nil
+	}
+	allowed, retryAfter, err := limiter.AllowedContext(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return &LimitedError{RetryAfter: retryAfter}
+	}
+	return limiter.FailContext(ctx, key)
+}
+
+func readEmail(r *http.Request) (string, error) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var request linkRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		return strings.TrimSpace(request.Email), err
+	}
+	if err := r.ParseForm(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(r.PostForm.Get("email")), nil
+}
@@ -0,0 +1,272 @@
+package magiclink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/nandlabs/turbo-auth/credentials"
+	"github.com/nandlabs/turbo-auth/idp"
+	"github.com/nandlabs/turbo-auth/nonce"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"github.com/nandlabs/turbo-auth/sessions"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type outbox struct {
+	mutex sync.Mutex
+	links map[string]string
+}
+
+func (o *outbox) SendLoginLink(_ context.Context, email string, link string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.links[email] = link
+	return nil
+}
+
+func (o *outbox) link(email string) string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.links[email]
+}
+
+func newTestProvider(t *testing.T) (*Provider, *outbox, *jwt.JwtAuthConfig) {
+	users := idp.NewMemoryUserStore()
+	users.Hasher = &credentials.PasswordHasher{Preferred: credentials.NewBcryptHasher(4)}
+	if err := users.AddUser("alice@example.com", "unused password", "user"); err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+	mails := &outbox{links: make(map[string]string)}
+	authConfig := jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+	provider := NewProvider([]byte("link_key"), "https://app.example.com/login/callback", mails)
+	provider.Users = users
+	provider.Issuer = authConfig
+	return provider, mails, authConfig
+}
+
+func requestLink(t *testing.T, provider *Provider, email string) {
+	r := httptest.NewRequest(http.MethodPost, "/login/link", strings.NewReader(url.Values{"email": {email}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	provider.RequestHandler().ServeHTTP(w, r)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("RequestHandler() status = %v, want %v", w.Code, http.StatusAccepted)
+	}
+}
+
+// callback opens the link and confirms the login as the confirmation page does
+func callback(provider *Provider, link string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	provider.CallbackHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, link, nil))
+	if w.Code != http.StatusOK {
+		return w
+	}
+	parsed, _ := url.Parse(link)
+	form := url.Values{DefaultTokenParam: {parsed.Query().Get(DefaultTokenParam)}}
+	r := httptest.NewRequest(http.MethodPost, parsed.Path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	provider.CallbackHandler().ServeHTTP(w, r)
+	return w
+}
+
+func TestProvider_CallbackHandler(t *testing.T) {
+	provider, mails, authConfig := newTestProvider(t)
+	requestLink(t, provider, "alice@example.com")
+	link := mails.link("alice@example.com")
+	if !strings.HasPrefix(link, "https://app.example.com/login/callback?token=") {
+		t.Fatalf("link = %v", link)
+	}
+
+	w := callback(provider, link)
+	if w.Code != http.StatusOK {
+		t.Fatalf("CallbackHandler() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	var response idp.LoginResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+	identity, err := authConfig.Authenticate(response.AccessToken)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if identity.Subject != "alice@example.com" || !identity.HasRole("user") {
+		t.Errorf("identity = %+v", identity)
+	}
+
+	if w := callback(provider, link); w.Code != http.StatusUnauthorized {
+		t.Errorf("CallbackHandler() reused link status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestProvider_CallbackHandler_confirmation(t *testing.T) {
+	provider, mails, _ := newTestProvider(t)
+	requestLink(t, provider, "alice@example.com")
+	link := mails.link("alice@example.com")
+	parsed, _ := url.Parse(link)
+	token := parsed.Query().Get(DefaultTokenParam)
+	for i := 0; i < 2; i++ {
+		// the scanners of the emails fetch the link, possibly several times
+		w := httptest.NewRecorder()
+		provider.CallbackHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, link, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `value="`+token+`"`) ||
+			!strings.Contains(w.Body.String(), `method="post"`) {
+			t.Fatalf("CallbackHandler() GET = %v %v, want the confirmation page", w.Code, w.Body.String())
+		}
+		if w.Header().Get("Referrer-Policy") != "no-referrer" {
+			t.Errorf("Referrer-Policy = %v, want no-referrer", w.Header().Get("Referrer-Policy"))
+		}
+	}
+	if w := callback(provider, link); w.Code != http.StatusOK {
+		t.Errorf("CallbackHandler() POST status = %v, want %v", w.Code, http.StatusOK)
+	}
+}
+
+func TestProvider_RequestHandler_limited(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(p *Provider)
+		emails  []string
+	}{
+		{
+			name:    "Test_per_address",
+			prepare: func(p *Provider) { p.Limiter.MaxAttempts = 2 },
+			emails:  []string{"alice@example.com", "alice@example.com", "ALICE@example.com"},
+		},
+		{
+			name:    "Test_per_client",
+			prepare: func(p *Provider) { p.IPLimiter.MaxAttempts = 2 },
+			emails:  []string{"alice@example.com", "bob@example.com", "carol@example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, _, _ := newTestProvider(t)
+			tt.prepare(provider)
+			for i, email := range tt.emails {
+				r := httptest.NewRequest(http.MethodPost, "/login/link", strings.NewReader(url.Values{"email": {email}}.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := httptest.NewRecorder()
+				provider.RequestHandler().ServeHTTP(w, r)
+				want := http.StatusAccepted
+				if i == len(tt.emails)-1 {
+					want = http.StatusTooManyRequests
+				}
+				if w.Code != want {
+					t.Fatalf("RequestHandler() %v status = %v, want %v", i, w.Code, want)
+				}
+				if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+					t.Errorf("Retry-After is missing")
+				}
+			}
+		})
+	}
+}
+
+func TestProvider_Verify(t *testing.T) {
+	provider, _, _ := newTestProvider(t)
+	token := func(link string) string {
+		parsed, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Query().Get(DefaultTokenParam)
+	}
+	valid, err := provider.NewLink("alice@example.com")
+	if err != nil {
+		t.Fatalf("NewLink() error = %v", err)
+	}
+	provider.TTL = time.Nanosecond
+	expired, err := provider.NewLink("alice@example.com")
+	if err != nil {
+		t.Fatalf("NewLink() error = %v", err)
+	}
+	provider.TTL = DefaultTTL
+	other := NewProvider([]byte("other_key"), provider.CallbackURL, nil)
+	forged, err := other.NewLink("alice@example.com")
+	if err != nil {
+		t.Fatalf("NewLink() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "Test_expired", token: token(expired), wantErr: ErrInvalidLink},
+		{name: "Test_forged", token: token(forged), wantErr: ErrInvalidLink},
+		{name: "Test_malformed", token: "not-a-token", wantErr: ErrInvalidLink},
+		{name: "Test_valid", token: token(valid)},
+		{name: "Test_reused", token: token(valid), wantErr: ErrLinkUsed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := provider.Verify(tt.token)
+			if tt.wantErr == nil {
+				if err != nil || email != "alice@example.com" {
+					t.Errorf("Verify() = %v, %v", email, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProvider_unknownUser(t *testing.T) {
+	provider, mails, _ := newTestProvider(t)
+	requestLink(t, provider, "mallory@example.com")
+	if link := mails.link("mallory@example.com"); link != "" {
+		t.Errorf("link sent to an unknown user: %v", link)
+	}
+}
+
+func TestProvider_sessions(t *testing.T) {
+	provider, mails, _ := newTestProvider(t)
+	provider.Issuer = nil
+	provider.SessionManager = sessions.NewSessionManager(sessions.NewMemoryStore())
+	provider.RedirectURL = "/home"
+	requestLink(t, provider, "alice@example.com")
+
+	w := callback(provider, mails.link("alice@example.com"))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/home" {
+		t.Fatalf("CallbackHandler() status = %v, Location = %v", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessions.DefaultSessionCookieName {
+		t.Errorf("cookies = %v, want the session cookie", cookies)
+	}
+}
+
+func TestNonceTokenStore(t *testing.T) {
+	tests := []struct {
+		name  string
+		store TokenStore
+	}{
+		{name: "Test_memory", store: NewMemoryTokenStore()},
+		{name: "Test_nonce_store", store: NonceTokenStore(nonce.NewMemoryStore())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expiresAt := time.Now().Add(time.Minute)
+			if unused, err := tt.store.Consume("link", expiresAt); err != nil || !unused {
+				t.Fatalf("Consume() = %v, %v, want the first use accepted", unused, err)
+			}
+			if unused, _ := tt.store.Consume("link", expiresAt); unused {
+				t.Errorf("Consume() = true, want the reuse rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package magiclink
+
+import (
+	"github.com/nandlabs/turbo-auth/nonce"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"time"
+)
+
+type (
+	// TokenStore records the used links, implementations must be safe for concurrent use
+	TokenStore interface {
+		// Consume marks the link id as used until expiresAt and reports whether it was unused, the check and the
+		// update must be atomic so a link cannot be used twice concurrently
+		Consume(id string, expiresAt time.Time) (bool, error)
+	}
+
+	// MemoryTokenStore is an in-memory TokenStore suitable for single instance deployments, the memory is bounded
+	// by the MaxEntries of the nonce.MemoryStore
+	MemoryTokenStore struct {
+		nonce.MemoryStore
+	}
+
+	nonceTokenStore struct {
+		store nonce.Store
+	}
+
+	revokerTokenStore struct {
+		revoker jwt.Revoker
+	}
+)
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{}
+}
+
+func (m *MemoryTokenStore) Consume(id string, expiresAt time.Time) (bool, error) {
+	used, err := m.Seen(id, expiresAt)
+	return !used && err == nil, err
+}
+
+// NonceTokenStore records the used links in a nonce.Store, e.g. a nonce.RedisStore shared across instances
+func NonceTokenStore(store nonce.Store) TokenStore {
+	return &nonceTokenStore{store: store}
+}
+
+func (s *nonceTokenStore) Consume(id string, expiresAt time.Time) (bool, error) {
+	used, err := s.store.Seen(id, expiresAt)
+	return !used && err == nil, err
+}
+
+// RevokerTokenStore records the used links in a jwt.Revoker, e.g. a jwt.RedisRevoker shared across instances.
+// The check and the update are two calls to the Revoker, the window between them is not protected, prefer the
+// NonceTokenStore
+func RevokerTokenStore(revoker jwt.Revoker) TokenStore {
+	return &revokerTokenStore{revoker: revoker}
+}
+
+func (s *revokerTokenStore) Consume(id string, expiresAt time.Time) (bool, error) {
+	used, err := s.revoker.IsRevoked(id)
+	if err != nil || used {
+		return false, err
+	}
+	return true, s.revoker.Revoke(id, expiresAt)
+}
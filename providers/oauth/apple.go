@@ -0,0 +1,136 @@
+package oauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// AppleClientSecret mints the ES256 client secret Sign in with Apple expects in place of a static secret, the
+	// secret is reused until half of its TTL elapsed
+	AppleClientSecret struct {
+		TeamID   string
+		KeyID    string
+		ClientID string
+		// PrivateKey is the key of the .p8 file downloaded from the Apple developer account
+		PrivateKey *ecdsa.PrivateKey
+		// TTL is the validity of the minted secrets, DefaultAppleSecretTTL when 0, at most 6 months
+		TTL time.Duration
+
+		mutex     sync.Mutex
+		secret    string
+		renewedAt time.Time
+	}
+
+	// appleUser is the user form field Apple posts on the first login only
+	appleUser struct {
+		Name struct {
+			FirstName string `json:"firstName"`
+			LastName  string `json:"lastName"`
+		} `json:"name"`
+	}
+)
+
+const (
+	AppleIssuer           = "https://appleid.apple.com"
+	AppleJWKSURL          = "https://appleid.apple.com/auth/keys"
+	DefaultAppleSecretTTL = 24 * time.Hour
+	maxAppleSecretTTL     = 180 * 24 * time.Hour
+)
+
+var (
+	// Apple has no userinfo endpoint, the user is read from the id_token, see NewAppleLogin
+	Apple = Preset{
+		Name:     "apple",
+		AuthURL:  "https://appleid.apple.com/auth/authorize",
+		TokenURL: "https://appleid.apple.com/auth/token",
+		Scopes:   []string{"name", "email"},
+		Mapping:  ClaimMapping{Subject: "sub", Email: "email", EmailVerified: "email_verified"},
+		// the name and email scopes require the form_post response mode
+		FormPost:       true,
+		SecretInParams: true,
+	}
+
+	ErrInvalidAppleKey = errors.New("oauth2: invalid Apple private key, a PKCS #8 EC key is expected")
+)
+
+// ParseAppleKey parses the PEM encoded .p8 key of Sign in with Apple
+func ParseAppleKey(p8 []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(p8)
+	if block == nil {
+		return nil, ErrInvalidAppleKey
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidAppleKey
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidAppleKey
+	}
+	return ecKey, nil
+}
+
+// Secret returns the signed client secret, it satisfies the Config.ClientSecretFunc
+func (a *AppleClientSecret) Secret() (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	ttl := a.TTL
+	if ttl <= 0 {
+		ttl = DefaultAppleSecretTTL
+	} else if ttl > maxAppleSecretTTL {
+		ttl = maxAppleSecretTTL
+	}
+	now := time.Now()
+	if a.secret != "" && now.Sub(a.renewedAt) < ttl/2 {
+		return a.secret, nil
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.TeamID,
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+		"aud": AppleIssuer,
+		"sub": a.ClientID,
+	})
+	token.Header["kid"] = a.KeyID
+	secret, err := token.SignedString(a.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	a.secret, a.renewedAt = secret, now
+	return secret, nil
+}
+
+// NewAppleLogin returns the SocialLogin of Sign in with Apple, clientID is the Services ID and p8 the PEM private
+// key of the keyID
+func NewAppleLogin(teamID string, keyID string, clientID string, p8 []byte, redirectURL string) (*SocialLogin, error) {
+	key, err := ParseAppleKey(p8)
+	if err != nil {
+		return nil, err
+	}
+	secret := &AppleClientSecret{TeamID: teamID, KeyID: keyID, ClientID: clientID, PrivateKey: key}
+	login := NewSocialLogin(Apple, clientID, "", redirectURL)
+	login.Config.ClientSecretFunc = secret.Secret
+	login.IDTokenVerifier = NewIDTokenVerifier(AppleIssuer, clientID, AppleJWKSURL)
+	return login, nil
+}
+
+// appleUserName reads the name of the user form field, Apple only sends it on the first login
+func appleUserName(user string) string {
+	if user == "" {
+		return ""
+	}
+	var parsed appleUser
+	if err := json.Unmarshal([]byte(user), &parsed); err != nil {
+		logger.DebugF("ignoring the malformed Apple user field: %v", err)
+		return ""
+	}
+	return strings.TrimSpace(parsed.Name.FirstName + " " + parsed.Name.LastName)
+}
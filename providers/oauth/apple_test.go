@@ -0,0 +1,171 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	turboJwt "github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAppleServer fakes the Apple token and keys endpoints, the id_token is signed with the RSA key
+func newAppleServer(t *testing.T, appleKey *ecdsa.PrivateKey, idTokenKey *rsa.PrivateKey) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/token":
+			claims := jwt.MapClaims{}
+			secret, err := jwt.ParseWithClaims(r.PostFormValue("client_secret"), claims, func(*jwt.Token) (interface{}, error) {
+				return &appleKey.PublicKey, nil
+			}, jwt.WithValidMethods([]string{"ES256"}))
+			if err != nil || secret.Header["kid"] != "KEY123" || claims["iss"] != "TEAM123" ||
+				claims["sub"] != "com.example.web" || !claims.VerifyAudience(AppleIssuer, true) ||
+				r.PostFormValue("code") != "auth-code" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error": "invalid_client"}`))
+				return
+			}
+			idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+				"iss":            AppleIssuer,
+				"aud":            "com.example.web",
+				"sub":            "001234.abcd",
+				"email":          "jane@privaterelay.appleid.com",
+				"email_verified": "true",
+				"exp":            time.Now().Add(time.Minute).Unix(),
+			})
+			idToken.Header["kid"] = "apple-1"
+			signed, _ := idToken.SignedString(idTokenKey)
+			_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "a.b", "token_type": "bearer", "id_token": signed})
+		case "/auth/keys":
+			jwk, _ := turboJwt.NewJWK(&turboJwt.Key{ID: "apple-1", SigningMethod: "RS256", VerifyKey: &idTokenKey.PublicKey})
+			_ = json.NewEncoder(w).Encode(turboJwt.JWKS{Keys: []turboJwt.JWK{jwk}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func encodeP8(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestNewAppleLogin(t *testing.T) {
+	appleKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idTokenKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newAppleServer(t, appleKey, idTokenKey)
+
+	tests := []struct {
+		name       string
+		user       string
+		wantName   string
+		wantStatus int
+	}{
+		{
+			name:       "Test_first_login",
+			user:       `{"name": {"firstName": "Jane", "lastName": "Appleseed"}, "email": "jane@privaterelay.appleid.com"}`,
+			wantName:   "Jane Appleseed",
+			wantStatus: http.StatusOK,
+		},
+		{name: "Test_returning_user", wantStatus: http.StatusOK},
+		{name: "Test_malformed_user", user: "{", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, err := NewAppleLogin("TEAM123", "KEY123", "com.example.web", encodeP8(t, appleKey),
+				"https://app.example.com/auth/apple/callback")
+			if err != nil {
+				t.Fatalf("NewAppleLogin() error = %v", err)
+			}
+			login.Config.TokenURL = server.URL + "/auth/token"
+			login.IDTokenVerifier.JWKSURL = server.URL + "/auth/keys"
+			login.Issuer = turboJwt.CreateJwtAuthenticator(&turboJwt.JwtAuthConfig{
+				SigningKey:    "test_key",
+				SigningMethod: "HS256",
+				BearerTokens:  true,
+			})
+			login.Resolve = func(_ context.Context, info *UserInfo) (string, []string, error) {
+				if info.Subject != "001234.abcd" || !info.EmailVerified || info.Name != tt.wantName {
+					t.Errorf("info = %+v", info)
+				}
+				return info.Email, nil, nil
+			}
+
+			w := httptest.NewRecorder()
+			login.LoginHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/apple", nil))
+			location, err := url.Parse(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if location.Query().Get("response_mode") != "form_post" {
+				t.Errorf("authorization url = %v", location)
+			}
+			cookies := w.Result().Cookies()
+			if len(cookies) == 0 || cookies[0].SameSite != http.SameSiteNoneMode || !cookies[0].Secure {
+				t.Fatalf("state cookie = %+v", cookies)
+			}
+
+			form := url.Values{"code": {"auth-code"}, "state": {location.Query().Get("state")}}
+			if tt.user != "" {
+				form.Set("user", tt.user)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/auth/apple/callback", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.AddCookie(cookies[0])
+			w = httptest.NewRecorder()
+			login.CallbackHandler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("CallbackHandler() status = %v, want %v: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestAppleClientSecret_Secret(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := &AppleClientSecret{TeamID: "TEAM123", KeyID: "KEY123", ClientID: "com.example.web", PrivateKey: key}
+	first, err := secret.Secret()
+	if err != nil {
+		t.Fatalf("Secret() error = %v", err)
+	}
+	if second, _ := secret.Secret(); second != first {
+		t.Errorf("Secret() not reused")
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(first, claims, func(*jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}); err != nil {
+		t.Fatalf("parse error = %v", err)
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Until(time.Unix(int64(exp), 0)) > DefaultAppleSecretTTL {
+		t.Errorf("exp = %v", exp)
+	}
+	if _, err := ParseAppleKey([]byte("not a key")); err != ErrInvalidAppleKey {
+		t.Errorf("ParseAppleKey() error = %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Config is the client side of the authorization code grant (RFC 6749 section 4.1) protected with PKCE (RFC 7636)
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	// PushedAuthURL is the pushed authorization request endpoint (RFC 9126), see PushAuthorizationRequest
+	PushedAuthURL string
+	// UserInfoURL is the OIDC userinfo endpoint, or the profile api of the plain OAuth2 providers
+	UserInfoURL string
+	// RedirectURL is the callback registered with the provider
+	RedirectURL string
+	Scopes      []string
+	// ClientSecretFunc computes the client secret of every exchange when set, e.g. the signed client secret of Apple
+	ClientSecretFunc func() (string, error)
+	// SecretInParams sends the client credentials in the form instead of the basic authorization header
+	SecretInParams bool
+	// AuthParams are added to the AuthCodeURL, e.g. response_mode
+	AuthParams url.Values
+	Client     *http.Client
+}
+
+// AuthCodeURL returns the url of the provider's consent page, the PKCE challenge of the codeVerifier is added
+// when not empty
+func (c *Config) AuthCodeURL(state string, codeVerifier string) string {
+	return c.authURL(c.authParams(state, codeVerifier, nil))
+}
+
+// authParams are the parameters of the authorization request, the extra ones take precedence over the AuthParams
+func (c *Config) authParams(state string, codeVerifier string, extra url.Values) url.Values {
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {c.ClientID},
+		"state":         {state},
+	}
+	if c.RedirectURL != "" {
+		query.Set("redirect_uri", c.RedirectURL)
+	}
+	if len(c.Scopes) > 0 {
+		query.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	if codeVerifier != "" {
+		query.Set("code_challenge", codeChallenge(codeVerifier))
+		query.Set("code_challenge_method", "S256")
+	}
+	for name, values := range c.AuthParams {
+		query[name] = values
+	}
+	for name, values := range extra {
+		query[name] = values
+	}
+	return query
+}
+
+func (c *Config) authURL(query url.Values) string {
+	separator := "?"
+	if strings.Contains(c.AuthURL, "?") {
+		separator = "&"
+	}
+	return c.AuthURL + separator + query.Encode()
+}
+
+// Exchange trades the authorization code for a token, codeVerifier must be the one of the AuthCodeURL
+func (c *Config) Exchange(ctx context.Context, code string, codeVerifier string) (*Token, error) {
+	form := url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {code},
+		"client_id":  {c.ClientID},
+	}
+	if c.RedirectURL != "" {
+		form.Set("redirect_uri", c.RedirectURL)
+	}
+	if codeVerifier != "" {
+		form.Set("code_verifier", codeVerifier)
+	}
+	clientSecret, err := c.clientCredentials(form)
+	if err != nil {
+		return nil, err
+	}
+	token := &Token{}
+	if err := postForm(ctx, c.Client, c.TokenURL, form, c.ClientID, clientSecret, token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("oauth2: token response without access_token")
+	}
+	return token, nil
+}
+
+// clientCredentials returns the client secret of the basic authorization header, empty when the SecretInParams
+// sends it in the form
+func (c *Config) clientCredentials(form url.Values) (string, error) {
+	clientSecret := c.ClientSecret
+	if c.ClientSecretFunc != nil {
+		var err error
+		if clientSecret, err = c.ClientSecretFunc(); err != nil {
+			return "", err
+		}
+	}
+	if c.SecretInParams {
+		form.Set("client_secret", clientSecret)
+		clientSecret = ""
+	}
+	return clientSecret, nil
+}
+
+// UserInfo fetches the claims of the user from the UserInfoURL
+func (c *Config) UserInfo(ctx context.Context, token *Token) (map[string]interface{}, error) {
+	claims := make(map[string]interface{})
+	return claims, getJSON(ctx, c.Client, c.UserInfoURL, token.AccessToken, &claims)
+}
+
+// NewCodeVerifier returns a random PKCE code verifier
+func NewCodeVerifier() (string, error) {
+	return randomString(32)
+}
+
+func codeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(size int) (string, error) {
+	random := make([]byte, size)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// getJSON sends a GET to the endpoint, with the bearer accessToken when not empty, and decodes the json response
+// into v
+func getJSON(ctx context.Context, client *http.Client, endpoint string, accessToken string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	req.Header.Set("Accept", "application/json")
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oauth2: %s returned %s", endpoint, res.Status)
+	}
+	// the numbers are kept as json.Number, e.g. the GitHub user ids
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
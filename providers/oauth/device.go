@@ -0,0 +1,141 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type (
+	// DeviceConfig is the client side of the device authorization grant (RFC 8628) for input constrained
+	// devices and CLI tools
+	DeviceConfig struct {
+		ClientID     string
+		ClientSecret string
+		// DeviceAuthorizationURL is the device authorization endpoint of the authorization server
+		DeviceAuthorizationURL string
+		TokenURL               string
+		Scopes                 []string
+		Client                 *http.Client
+	}
+
+	// DeviceAuthorization is the device authorization response, the user code and verification uri are to be
+	// shown to the user
+	DeviceAuthorization struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+		ExpiresIn               int64  `json:"expires_in"`
+		Interval                int64  `json:"interval,omitempty"`
+	}
+)
+
+const (
+	DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	// DefaultPollInterval is used when the server does not send an interval
+	DefaultPollInterval = 5 * time.Second
+	// slowDownIncrement is added to the interval on every slow_down response
+	slowDownIncrement = 5 * time.Second
+)
+
+var (
+	ErrAuthorizationPending = errors.New("oauth2: authorization pending")
+	ErrAccessDenied         = errors.New("oauth2: the user denied the authorization")
+	ErrExpiredToken         = errors.New("oauth2: the device code has expired")
+
+	// sleep waits between the polls, replaced in the tests
+	sleep = func(ctx context.Context, d time.Duration) error {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		}
+	}
+)
+
+// RequestDeviceCode starts the flow by requesting a device and a user code
+func (c *DeviceConfig) RequestDeviceCode(ctx context.Context) (*DeviceAuthorization, error) {
+	form := url.Values{"client_id": {c.ClientID}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	authorization := &DeviceAuthorization{}
+	if err := postForm(ctx, c.Client, c.DeviceAuthorizationURL, form, c.ClientID, c.ClientSecret, authorization); err != nil {
+		return nil, err
+	}
+	if authorization.DeviceCode == "" || authorization.UserCode == "" || authorization.VerificationURI == "" {
+		return nil, errors.New("oauth2: incomplete device authorization response")
+	}
+	return authorization, nil
+}
+
+// Instructions is the message asking the user to authorize the device
+func (d *DeviceAuthorization) Instructions() string {
+	if d.VerificationURIComplete != "" {
+		return fmt.Sprintf("To sign in, open %s\nor visit %s and enter the code: %s\n",
+			d.VerificationURIComplete, d.VerificationURI, d.UserCode)
+	}
+	return fmt.Sprintf("To sign in, visit %s and enter the code: %s\n", d.VerificationURI, d.UserCode)
+}
+
+// DisplayUserCode writes the Instructions, typically to os.Stderr of a CLI
+func DisplayUserCode(w io.Writer, d *DeviceAuthorization) error {
+	_, err := io.WriteString(w, d.Instructions())
+	return err
+}
+
+// PollToken polls the token endpoint at the interval of the authorization until the user approves or denies
+// the request or the device code expires, the interval grows on slow_down responses
+func (c *DeviceConfig) PollToken(ctx context.Context, d *DeviceAuthorization) (*Token, error) {
+	interval := DefaultPollInterval
+	if d.Interval > 0 {
+		interval = time.Duration(d.Interval) * time.Second
+	}
+	var deadline time.Time
+	if d.ExpiresIn > 0 {
+		deadline = time.Now().Add(time.Duration(d.ExpiresIn) * time.Second)
+	}
+	form := url.Values{
+		"grant_type":  {DeviceCodeGrantType},
+		"device_code": {d.DeviceCode},
+		"client_id":   {c.ClientID},
+	}
+	for {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, ErrExpiredToken
+		}
+		if err := sleep(ctx, interval); err != nil {
+			return nil, err
+		}
+		token := &Token{}
+		err := postForm(ctx, c.Client, c.TokenURL, form, c.ClientID, c.ClientSecret, token)
+		if err == nil {
+			return token, nil
+		}
+		var tokenErr *TokenError
+		if !errors.As(err, &tokenErr) {
+			return nil, err
+		}
+		switch tokenErr.Code {
+		case "authorization_pending":
+			logger.DebugF("device authorization pending, polling again in %v", interval)
+		case "slow_down":
+			interval += slowDownIncrement
+		case "access_denied":
+			return nil, ErrAccessDenied
+		case "expired_token":
+			return nil, ErrExpiredToken
+		default:
+			return nil, tokenErr
+		}
+	}
+}
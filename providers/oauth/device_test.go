@@ -0,0 +1,101 @@
+package oauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeviceConfig_PollToken(t *testing.T) {
+	var slept []time.Duration
+	sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+
+	tests := []struct {
+		name      string
+		responses []string
+		wantErr   error
+		wantSlept []time.Duration
+	}{
+		{
+			name:      "Test_approved_after_pending",
+			responses: []string{"authorization_pending", "slow_down", ""},
+			wantSlept: []time.Duration{time.Second, time.Second, 6 * time.Second},
+		},
+		{
+			name:      "Test_denied",
+			responses: []string{"authorization_pending", "access_denied"},
+			wantErr:   ErrAccessDenied,
+			wantSlept: []time.Duration{time.Second, time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slept = nil
+			polls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/device":
+					_ = json.NewEncoder(w).Encode(DeviceAuthorization{
+						DeviceCode:      "device-code",
+						UserCode:        "WDJB-MJHT",
+						VerificationURI: "https://example.com/device",
+						ExpiresIn:       600,
+						Interval:        1,
+					})
+				case "/token":
+					if r.PostFormValue("grant_type") != DeviceCodeGrantType || r.PostFormValue("device_code") != "device-code" {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					response := tt.responses[polls]
+					polls++
+					if response != "" {
+						w.WriteHeader(http.StatusBadRequest)
+						_ = json.NewEncoder(w).Encode(TokenError{Code: response})
+						return
+					}
+					_ = json.NewEncoder(w).Encode(Token{AccessToken: "access-token", TokenType: "Bearer"})
+				}
+			}))
+			defer server.Close()
+
+			config := &DeviceConfig{
+				ClientID:               "cli",
+				DeviceAuthorizationURL: server.URL + "/device",
+				TokenURL:               server.URL + "/token",
+			}
+			authorization, err := config.RequestDeviceCode(context.Background())
+			if err != nil {
+				t.Fatalf("RequestDeviceCode() error = %v", err)
+			}
+			var out bytes.Buffer
+			if err := DisplayUserCode(&out, authorization); err != nil || !strings.Contains(out.String(), "WDJB-MJHT") {
+				t.Errorf("DisplayUserCode() = %q, %v", out.String(), err)
+			}
+
+			token, err := config.PollToken(context.Background(), authorization)
+			if err != tt.wantErr {
+				t.Fatalf("PollToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && token.AccessToken != "access-token" {
+				t.Errorf("PollToken() token = %+v", token)
+			}
+			if len(slept) != len(tt.wantSlept) {
+				t.Fatalf("polls = %v, want %v", slept, tt.wantSlept)
+			}
+			for i := range slept {
+				if slept[i] != tt.wantSlept[i] {
+					t.Errorf("poll %v interval = %v, want %v", i, slept[i], tt.wantSlept[i])
+				}
+			}
+		})
+	}
+}
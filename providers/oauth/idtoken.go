@@ -0,0 +1,232 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/metrics"
+	turboJwt "github.com/nandlabs/turbo-auth/providers/jwt"
+	"github.com/nandlabs/turbo-auth/resilience"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IDTokenVerifier validates the OIDC id_tokens against the keys published by the provider
+type IDTokenVerifier struct {
+	Issuer   string
+	ClientID string
+	JWKSURL  string
+	Client   *http.Client
+	// RefreshInterval is the minimum delay between two fetches of the keys, DefaultJWKSRefreshInterval when 0
+	RefreshInterval time.Duration
+	// MaxAge is the age past which the keys are refreshed, DefaultJWKSMaxAge when 0
+	MaxAge time.Duration
+	// StaleGrace is how long past MaxAge the keys are still served while they cannot be refreshed, the refresh is
+	// retried in the background meanwhile. DefaultJWKSStaleGrace when 0, negative to never serve stale keys
+	StaleGrace time.Duration
+	// Resilience bounds, retries and breaks the fetches of the keys
+	Resilience *resilience.Policy
+	Metrics    *metrics.Metrics
+
+	mutex       sync.Mutex
+	keys        map[string]interface{}
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// fetch is the fetch of the keys in progress, the concurrent lookups wait for it rather than fetching again
+	fetch *keysFetch
+}
+
+// keysFetch is a fetch of the keys shared by the lookups, done is closed once keys and err are set
+type keysFetch struct {
+	done chan struct{}
+	keys map[string]interface{}
+	err  error
+}
+
+const (
+	DefaultJWKSRefreshInterval = time.Minute
+	DefaultJWKSMaxAge          = time.Hour
+	DefaultJWKSStaleGrace      = 24 * time.Hour
+
+	// fetchTimeout bounds a fetch of the keys including its retries, the fetch is shared by the lookups and outlives
+	// their contexts
+	fetchTimeout = time.Minute
+)
+
+var ErrInvalidIDToken = errors.New("oauth2: invalid id_token")
+
+func NewIDTokenVerifier(issuer string, clientID string, jwksURL string) *IDTokenVerifier {
+	return &IDTokenVerifier{Issuer: issuer, ClientID: clientID, JWKSURL: jwksURL}
+}
+
+// Verify checks the signature, issuer, audience and expiry of the id_token and returns its claims
+func (v *IDTokenVerifier) Verify(ctx context.Context, idToken string) (jwt.MapClaims, error) {
+	if idToken == "" {
+		return nil, ErrInvalidIDToken
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.key(ctx, kid)
+	}, jwt.WithValidMethods([]string{"RS256", "ES256"}))
+	if err != nil {
+		return nil, turboError.Wrap(ErrInvalidIDToken, err)
+	}
+	if !claims.VerifyIssuer(v.Issuer, true) || !claims.VerifyAudience(v.ClientID, true) ||
+		!claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, turboError.Wrap(ErrInvalidIDToken, errors.New("oauth2: unexpected issuer, audience or expiry of the id_token"))
+	}
+	return claims, nil
+}
+
+// key returns the key of the kid, the keys are fetched again when the kid is unknown or the keys are older than
+// MaxAge. Stale keys are served for the StaleGrace while they are refreshed in the background, so that an outage of
+// the provider does not reject the tokens signed with the known keys. The lock is not held during the fetches, the
+// lookups of the known keys are served meanwhile
+func (v *IDTokenVerifier) key(ctx context.Context, kid string) (interface{}, error) {
+	v.mutex.Lock()
+	if key, ok := v.keys[kid]; ok {
+		age := time.Since(v.fetchedAt)
+		if age < v.maxAge() {
+			v.mutex.Unlock()
+			v.Metrics.JWKSCacheHit()
+			return key, nil
+		}
+		if grace := v.staleGrace(); grace > 0 && age < v.maxAge()+grace {
+			if v.fetch == nil && time.Since(v.attemptedAt) >= v.refreshInterval() {
+				v.startFetch()
+			}
+			v.mutex.Unlock()
+			v.Metrics.JWKSStaleHit()
+			return key, nil
+		}
+	}
+	fetch := v.fetch
+	if fetch == nil {
+		if time.Since(v.attemptedAt) < v.refreshInterval() {
+			v.mutex.Unlock()
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		fetch = v.startFetch()
+	}
+	v.mutex.Unlock()
+	if err := fetch.wait(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := fetch.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// Check fetches the keys when they are not fresh, it fails when no keys can be served, i.e. they cannot be fetched
+// and the stale keys are past the StaleGrace, see health.Checker
+func (v *IDTokenVerifier) Check(ctx context.Context) error {
+	v.mutex.Lock()
+	if len(v.keys) > 0 && time.Since(v.fetchedAt) < v.maxAge() {
+		v.mutex.Unlock()
+		return nil
+	}
+	fetch := v.fetch
+	if fetch == nil && time.Since(v.attemptedAt) >= v.refreshInterval() {
+		fetch = v.startFetch()
+	}
+	v.mutex.Unlock()
+	var err error
+	if fetch != nil {
+		if err = fetch.wait(ctx); err == nil {
+			return nil
+		}
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if grace := v.staleGrace(); len(v.keys) > 0 && grace > 0 && time.Since(v.fetchedAt) < v.maxAge()+grace {
+		return nil
+	}
+	if err == nil {
+		err = errors.New("no keys fetched")
+	}
+	return fmt.Errorf("keys of %s unavailable: %v", v.JWKSURL, err)
+}
+
+// startFetch fetches the keys in the background and swaps them in once fetched, the lookups keep being served from
+// the previous keys meanwhile. The caller must hold the lock
+func (v *IDTokenVerifier) startFetch() *keysFetch {
+	fetch := &keysFetch{done: make(chan struct{})}
+	v.fetch, v.attemptedAt = fetch, time.Now()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+		keys, err := v.fetchKeys(ctx)
+		v.mutex.Lock()
+		if err != nil {
+			if len(v.keys) > 0 {
+				logger.WarnF("unable to refresh the keys of %s, serving the keys fetched at %s: %v", v.JWKSURL,
+					v.fetchedAt.Format(time.RFC3339), err)
+			}
+		} else {
+			v.keys, v.fetchedAt = keys, time.Now()
+		}
+		v.fetch = nil
+		v.mutex.Unlock()
+		fetch.keys, fetch.err = keys, err
+		close(fetch.done)
+	}()
+	return fetch
+}
+
+// wait returns once the keys are fetched or the context is done
+func (fetch *keysFetch) wait(ctx context.Context) error {
+	select {
+	case <-fetch.done:
+		return fetch.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fetchKeys fetches the key set of the JWKSURL, the keys which cannot be decoded are skipped
+func (v *IDTokenVerifier) fetchKeys(ctx context.Context) (map[string]interface{}, error) {
+	var jwks turboJwt.JWKS
+	err := v.Resilience.Do(ctx, func(ctx context.Context) error {
+		return getJSON(ctx, v.Client, v.JWKSURL, "", &jwks)
+	})
+	v.Metrics.ObserveJWKSRefresh(err)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.PublicKey()
+		if err != nil {
+			logger.WarnF("skipping the key %s of %s: %v", jwk.Kid, v.JWKSURL, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (v *IDTokenVerifier) refreshInterval() time.Duration {
+	if v.RefreshInterval <= 0 {
+		return DefaultJWKSRefreshInterval
+	}
+	return v.RefreshInterval
+}
+
+func (v *IDTokenVerifier) maxAge() time.Duration {
+	if v.MaxAge <= 0 {
+		return DefaultJWKSMaxAge
+	}
+	return v.MaxAge
+}
+
+func (v *IDTokenVerifier) staleGrace() time.Duration {
+	if v.StaleGrace == 0 {
+		return DefaultJWKSStaleGrace
+	}
+	return v.StaleGrace
+}
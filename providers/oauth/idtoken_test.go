@@ -0,0 +1,184 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	turboJwt "github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIDTokenVerifier_StaleKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var down int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		jwk, _ := turboJwt.NewJWK(&turboJwt.Key{ID: "key-1", SigningMethod: "RS256", VerifyKey: &key.PublicKey})
+		_ = json.NewEncoder(w).Encode(turboJwt.JWKS{Keys: []turboJwt.JWK{jwk}})
+	}))
+	defer server.Close()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": "https://idp.example.com",
+		"aud": "client",
+		"sub": "jane",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	idToken.Header["kid"] = "key-1"
+	signed, err := idToken.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		staleGrace time.Duration
+		wantErr    bool
+	}{
+		{name: "Test_stale_keys_served", staleGrace: time.Hour},
+		{name: "Test_stale_keys_disabled", staleGrace: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&down, 0)
+			verifier := NewIDTokenVerifier("https://idp.example.com", "client", server.URL)
+			verifier.MaxAge, verifier.StaleGrace, verifier.RefreshInterval = 10*time.Millisecond, tt.staleGrace, time.Nanosecond
+			if _, err := verifier.Verify(context.Background(), signed); err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			atomic.StoreInt32(&down, 1)
+			time.Sleep(20 * time.Millisecond)
+			if _, err := verifier.Verify(context.Background(), signed); (err != nil) != tt.wantErr {
+				t.Errorf("Verify() with the keys endpoint down error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIDTokenVerifier_Check(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var down int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		jwk, _ := turboJwt.NewJWK(&turboJwt.Key{ID: "key-1", SigningMethod: "RS256", VerifyKey: &key.PublicKey})
+		_ = json.NewEncoder(w).Encode(turboJwt.JWKS{Keys: []turboJwt.JWK{jwk}})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		fetched    bool
+		staleGrace time.Duration
+		wantErr    bool
+	}{
+		{name: "Test_never_fetched", wantErr: true},
+		{name: "Test_stale_keys_served", fetched: true, staleGrace: time.Hour},
+		{name: "Test_stale_keys_disabled", fetched: true, staleGrace: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&down, 0)
+			verifier := NewIDTokenVerifier("https://idp.example.com", "client", server.URL)
+			verifier.MaxAge, verifier.StaleGrace, verifier.RefreshInterval = 10*time.Millisecond, tt.staleGrace, time.Nanosecond
+			if tt.fetched {
+				if err := verifier.Check(context.Background()); err != nil {
+					t.Fatalf("Check() error = %v", err)
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			atomic.StoreInt32(&down, 1)
+			if err := verifier.Check(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("Check() with the keys endpoint down error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIDTokenVerifier_concurrentFetch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		jwk, _ := turboJwt.NewJWK(&turboJwt.Key{ID: "key-1", SigningMethod: "RS256", VerifyKey: &key.PublicKey})
+		_ = json.NewEncoder(w).Encode(turboJwt.JWKS{Keys: []turboJwt.JWK{jwk}})
+	}))
+	defer server.Close()
+	sign := func(kid string) string {
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss": "https://idp.example.com",
+			"aud": "client",
+			"sub": "jane",
+			"exp": time.Now().Add(time.Minute).Unix(),
+		})
+		idToken.Header["kid"] = kid
+		signed, err := idToken.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	verifier := NewIDTokenVerifier("https://idp.example.com", "client", server.URL)
+	known, unknown := sign("key-1"), sign("key-2")
+	if _, err := verifier.Verify(context.Background(), known); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	// the unknown kid is fetched once, the lookups arriving after the fetch are within the RefreshInterval
+	verifier.attemptedAt = time.Time{}
+
+	// the lookups of the unknown kid share the fetch blocked by the server
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := verifier.Verify(context.Background(), unknown)
+			errs <- err
+		}()
+	}
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := verifier.Verify(context.Background(), known)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Verify() of the known kid during the fetch error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Verify() of the known kid blocked by the fetch")
+	}
+	close(release)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err == nil {
+			t.Errorf("Verify() of the unknown kid error = nil")
+		}
+	}
+	if got := atomic.LoadInt32(&fetches); got != 2 {
+		t.Errorf("fetches = %v, want 2", got)
+	}
+}
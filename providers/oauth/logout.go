@@ -0,0 +1,204 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/internal/secret"
+	"net/http"
+	"time"
+)
+
+// tokenRevoker is implemented by the issuers able to revoke the tokens of a subject, e.g. the jwt.JwtAuthenticator
+type tokenRevoker interface {
+	RevokeAllTokens(r *http.Request, subject string) error
+}
+
+const (
+	// BackChannelLogoutEvent is the member of the events claim of the logout tokens
+	BackChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+	// SessionValueSID is the session value holding the sid of the id_token the session was started with
+	SessionValueSID = "oidc_sid"
+)
+
+var ErrInvalidLogoutToken = errors.New("oauth2: invalid logout_token")
+
+// VerifyLogoutToken checks the logout token of the OIDC Back-Channel Logout 1.0 (section 2.6) and returns its
+// claims: it is verified as an id_token, carries the back-channel logout event, a sub or a sid and no nonce
+func (v *IDTokenVerifier) VerifyLogoutToken(ctx context.Context, logoutToken string) (jwt.MapClaims, error) {
+	claims, err := v.Verify(ctx, logoutToken)
+	if err != nil {
+		return nil, turboError.Wrap(ErrInvalidLogoutToken, err)
+	}
+	events, _ := claims["events"].(map[string]interface{})
+	if _, ok := events[BackChannelLogoutEvent]; !ok {
+		return nil, turboError.Wrap(ErrInvalidLogoutToken, errors.New("oauth2: logout_token without the back-channel logout event"))
+	}
+	if _, ok := claims["nonce"]; ok {
+		return nil, turboError.Wrap(ErrInvalidLogoutToken, errors.New("oauth2: logout_token with a nonce"))
+	}
+	if _, ok := claims["iat"]; !ok {
+		return nil, turboError.Wrap(ErrInvalidLogoutToken, errors.New("oauth2: logout_token without iat"))
+	}
+	sub, _ := claims["sub"].(string)
+	sid, _ := claims["sid"].(string)
+	if sub == "" && sid == "" {
+		return nil, turboError.Wrap(ErrInvalidLogoutToken, errors.New("oauth2: logout_token without sub nor sid"))
+	}
+	return claims, nil
+}
+
+// BackChannelLogoutHandler consumes the logout tokens the provider POSTs when the user logs out: the sessions of
+// the SessionManager started with the sid of the token, or all the sessions of the subject without sid, are
+// revoked, as well as the tokens of the subject when the Issuer can revoke them. The local subject is resolved from
+// the sub of the token, the tokens with a sid only are refused
+func (s *SocialLogin) BackChannelLogoutHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method != http.MethodPost {
+			s.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if s.IDTokenVerifier == nil {
+			s.writeError(w, r, http.StatusNotImplemented, "back-channel logout is not configured")
+			return
+		}
+		claims, err := s.IDTokenVerifier.VerifyLogoutToken(r.Context(), r.PostFormValue("logout_token"))
+		if err == nil {
+			err = s.checkLogoutReplay(claims)
+		}
+		if err != nil {
+			s.auditLogout(r, "", err)
+			logger.DebugF("%s back-channel logout refused: %v", s.Preset.Name, err)
+			writeLogoutError(w, "invalid_request")
+			return
+		}
+		sub, _ := claims["sub"].(string)
+		sid, _ := claims["sid"].(string)
+		username, err := s.logout(r, sub, sid)
+		s.auditLogout(r, username, err)
+		if err != nil {
+			logger.ErrorF("%s back-channel logout failed: %v", s.Preset.Name, err)
+			writeLogoutError(w, "invalid_request")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// FrontChannelLogoutHandler is rendered by the provider in an iframe when the user logs out, it destroys the
+// session of the browser when the iss and sid parameters match the provider and the session. Both parameters are
+// required (frontchannel_logout_session_required) so that a cross-site request cannot log the user out. The session
+// cookie must be SameSite=None to be sent to the iframe
+func (s *SocialLogin) FrontChannelLogoutHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method != http.MethodGet {
+			s.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		iss, sid := query.Get("iss"), query.Get("sid")
+		if iss == "" || sid == "" {
+			s.writeError(w, r, http.StatusBadRequest, "iss and sid are required")
+			return
+		}
+		if s.IDTokenVerifier == nil || iss != s.IDTokenVerifier.Issuer {
+			s.writeError(w, r, http.StatusBadRequest, "unexpected issuer")
+			return
+		}
+		if s.SessionManager != nil {
+			session, err := s.SessionManager.Get(r)
+			if err == nil {
+				sessionSID, _ := session.Values[SessionValueSID].(string)
+				if sessionSID != "" && secret.Equal(sid, sessionSID) {
+					err = s.SessionManager.Destroy(w, r)
+					s.auditLogout(r, session.Subject, err)
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// logout revokes the sessions and tokens of the provider subject and returns the local subject
+func (s *SocialLogin) logout(r *http.Request, sub string, sid string) (string, error) {
+	if sub == "" {
+		return "", errors.New("oauth2: logout_token without sub")
+	}
+	username := s.Preset.Name + ":" + sub
+	if s.LogoutSubject != nil {
+		var err error
+		if username, err = s.LogoutSubject(r.Context(), sub); err != nil {
+			return "", err
+		}
+	}
+	if s.SessionManager != nil {
+		if err := s.revokeSessions(r.Context(), username, sid); err != nil {
+			return username, err
+		}
+	}
+	// the tokens are not bound to the sid, all the tokens of the subject are revoked
+	if revoker, ok := s.Issuer.(tokenRevoker); ok {
+		return username, revoker.RevokeAllTokens(r, username)
+	}
+	return username, nil
+}
+
+func (s *SocialLogin) revokeSessions(ctx context.Context, username string, sid string) error {
+	if sid == "" {
+		return s.SessionManager.RevokeAllContext(ctx, username)
+	}
+	sessions, err := s.SessionManager.SessionsContext(ctx, username)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if sessionSID, _ := session.Values[SessionValueSID].(string); sessionSID == sid {
+			if err := s.SessionManager.RevokeContext(ctx, username, session.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkLogoutReplay records the jti of the logout token in the LogoutReplay until the token expires
+func (s *SocialLogin) checkLogoutReplay(claims jwt.MapClaims) error {
+	if s.LogoutReplay == nil {
+		return nil
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return errors.New("oauth2: logout_token without jti")
+	}
+	exp, _ := claims["exp"].(float64)
+	seen, err := s.LogoutReplay.Seen("logout:"+s.Preset.Name+":"+jti, time.Unix(int64(exp), 0))
+	if err != nil {
+		return err
+	}
+	if seen {
+		return errors.New("oauth2: logout_token replayed")
+	}
+	return nil
+}
+
+func (s *SocialLogin) auditLogout(r *http.Request, subject string, err error) {
+	if s.AuditLogger == nil {
+		return
+	}
+	event := audit.NewEvent(r, audit.EventLogout, s.Preset.Name, err)
+	event.Subject = subject
+	s.AuditLogger.Log(event)
+}
+
+// writeLogoutError answers 400 with the error of section 2.8 of the back-channel logout
+func writeLogoutError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
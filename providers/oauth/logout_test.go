@@ -0,0 +1,164 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/nandlabs/turbo-auth/nonce"
+	turboJwt "github.com/nandlabs/turbo-auth/providers/jwt"
+	"github.com/nandlabs/turbo-auth/sessions"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newLogoutLogin returns a login of the provider whose keys are served by a fake jwks endpoint, with two sessions
+// of the subject started with the sids sid-1 and sid-2
+func newLogoutLogin(t *testing.T, key *rsa.PrivateKey) (*SocialLogin, []*http.Cookie) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwk, _ := turboJwt.NewJWK(&turboJwt.Key{ID: "idp-1", SigningMethod: "RS256", VerifyKey: &key.PublicKey})
+		_ = json.NewEncoder(w).Encode(turboJwt.JWKS{Keys: []turboJwt.JWK{jwk}})
+	}))
+	t.Cleanup(server.Close)
+	login := NewSocialLogin(Google, "client", "secret", "https://app.example.com/callback")
+	login.IDTokenVerifier = NewIDTokenVerifier("https://idp.example.com", "client", server.URL)
+	login.SessionManager = sessions.NewSessionManager(sessions.NewMemoryStore())
+	var cookies []*http.Cookie
+	for _, sid := range []string{"sid-1", "sid-2"} {
+		w := httptest.NewRecorder()
+		if _, err := login.SessionManager.Create(w, "google:alice", map[string]interface{}{SessionValueSID: sid}); err != nil {
+			t.Fatal(err)
+		}
+		cookies = append(cookies, w.Result().Cookies()[0])
+	}
+	return login, cookies
+}
+
+func signLogoutToken(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "idp-1"
+	token.Header["typ"] = "logout+jwt"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func logoutClaims(overrides jwt.MapClaims) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"iss":    "https://idp.example.com",
+		"aud":    "client",
+		"sub":    "alice",
+		"sid":    "sid-1",
+		"iat":    time.Now().Unix(),
+		"exp":    time.Now().Add(2 * time.Minute).Unix(),
+		"jti":    "logout-1",
+		"events": map[string]interface{}{BackChannelLogoutEvent: map[string]interface{}{}},
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+func TestSocialLogin_BackChannelLogoutHandler(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		claims       jwt.MapClaims
+		replayed     bool
+		wantStatus   int
+		wantSessions int
+	}{
+		{name: "Test_sid", claims: logoutClaims(nil), wantStatus: http.StatusOK, wantSessions: 1},
+		{name: "Test_subject", claims: logoutClaims(jwt.MapClaims{"sid": nil}), wantStatus: http.StatusOK},
+		{name: "Test_replayed", claims: logoutClaims(nil), replayed: true, wantStatus: http.StatusBadRequest, wantSessions: 1},
+		{name: "Test_sid_only", claims: logoutClaims(jwt.MapClaims{"sub": nil}), wantStatus: http.StatusBadRequest, wantSessions: 2},
+		{name: "Test_missing_event", claims: logoutClaims(jwt.MapClaims{"events": nil}), wantStatus: http.StatusBadRequest, wantSessions: 2},
+		{name: "Test_id_token_nonce", claims: logoutClaims(jwt.MapClaims{"nonce": "n"}), wantStatus: http.StatusBadRequest, wantSessions: 2},
+		{name: "Test_other_audience", claims: logoutClaims(jwt.MapClaims{"aud": "other"}), wantStatus: http.StatusBadRequest, wantSessions: 2},
+		{name: "Test_expired", claims: logoutClaims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}), wantStatus: http.StatusBadRequest, wantSessions: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, _ := newLogoutLogin(t, key)
+			login.LogoutReplay = nonce.NewMemoryStore()
+			form := url.Values{"logout_token": {signLogoutToken(t, key, tt.claims)}}
+			requests := 1
+			if tt.replayed {
+				requests = 2
+			}
+			w := httptest.NewRecorder()
+			for i := 0; i < requests; i++ {
+				r := httptest.NewRequest(http.MethodPost, "/auth/google/backchannel-logout", strings.NewReader(form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w = httptest.NewRecorder()
+				login.BackChannelLogoutHandler().ServeHTTP(w, r)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("BackChannelLogoutHandler() status = %v, want %v: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Cache-Control = %q", w.Header().Get("Cache-Control"))
+			}
+			active, err := login.SessionManager.Sessions("google:alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(active) != tt.wantSessions {
+				t.Errorf("sessions = %v, want %v", len(active), tt.wantSessions)
+			}
+		})
+	}
+}
+
+func TestSocialLogin_FrontChannelLogoutHandler(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		query        string
+		wantStatus   int
+		wantSessions int
+	}{
+		{name: "Test_matching_sid", query: "iss=https%3A%2F%2Fidp.example.com&sid=sid-1", wantStatus: http.StatusOK, wantSessions: 1},
+		{name: "Test_without_parameters", wantStatus: http.StatusBadRequest, wantSessions: 2},
+		{name: "Test_without_sid", query: "iss=https%3A%2F%2Fidp.example.com", wantStatus: http.StatusBadRequest, wantSessions: 2},
+		{name: "Test_without_issuer", query: "sid=sid-1", wantStatus: http.StatusBadRequest, wantSessions: 2},
+		{name: "Test_other_sid", query: "iss=https%3A%2F%2Fidp.example.com&sid=sid-2", wantStatus: http.StatusOK, wantSessions: 2},
+		{name: "Test_other_issuer", query: "iss=https%3A%2F%2Fevil.example.com&sid=sid-1", wantStatus: http.StatusBadRequest, wantSessions: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, cookies := newLogoutLogin(t, key)
+			r := httptest.NewRequest(http.MethodGet, "/auth/google/frontchannel-logout?"+tt.query, nil)
+			r.AddCookie(cookies[0])
+			w := httptest.NewRecorder()
+			login.FrontChannelLogoutHandler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("FrontChannelLogoutHandler() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			active, err := login.SessionManager.Sessions("google:alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(active) != tt.wantSessions {
+				t.Errorf("sessions = %v, want %v", len(active), tt.wantSessions)
+			}
+		})
+	}
+}
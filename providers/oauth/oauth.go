@@ -1 +1,71 @@
 package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nandlabs/turbo-auth/logging"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type (
+	// Token is the successful response of the token endpoint (RFC 6749 section 5.1)
+	Token struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		ExpiresIn    int64  `json:"expires_in,omitempty"`
+		Scope        string `json:"scope,omitempty"`
+		IDToken      string `json:"id_token,omitempty"`
+	}
+
+	// TokenError is the error response of the token endpoint (RFC 6749 section 5.2)
+	TokenError struct {
+		Code        string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+		URI         string `json:"error_uri,omitempty"`
+	}
+)
+
+var logger = logging.Get()
+
+func (err *TokenError) Error() string {
+	if err.Description != "" {
+		return fmt.Sprintf("oauth2: %s: %s", err.Code, err.Description)
+	}
+	return "oauth2: " + err.Code
+}
+
+// postForm sends the form to the endpoint and decodes the json response into v, the error responses are
+// returned as *TokenError
+func postForm(ctx context.Context, client *http.Client, endpoint string, form url.Values, clientID, clientSecret string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// the pushed authorization requests are answered with 201
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		tokenErr := &TokenError{}
+		if err := json.NewDecoder(res.Body).Decode(tokenErr); err != nil || tokenErr.Code == "" {
+			return fmt.Errorf("oauth2: %s returned %s", endpoint, res.Status)
+		}
+		return tokenErr
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
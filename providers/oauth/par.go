@@ -0,0 +1,36 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+)
+
+// pushedAuthorization is the response of the pushed authorization request endpoint (RFC 9126 section 2.2)
+type pushedAuthorization struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int64  `json:"expires_in"`
+}
+
+// PushAuthorizationRequest posts the parameters of the authorization request to the PushedAuthURL, authenticated
+// as for the token endpoint, and returns the url of the consent page referencing them by their request_uri. The
+// parameters are thus neither exposed to nor alterable by the browser. The params are added to the request, e.g.
+// the OIDC nonce
+func (c *Config) PushAuthorizationRequest(ctx context.Context, state string, codeVerifier string, params url.Values) (string, error) {
+	if c.PushedAuthURL == "" {
+		return "", errors.New("oauth2: no pushed authorization request endpoint")
+	}
+	form := c.authParams(state, codeVerifier, params)
+	clientSecret, err := c.clientCredentials(form)
+	if err != nil {
+		return "", err
+	}
+	pushed := &pushedAuthorization{}
+	if err := postForm(ctx, c.Client, c.PushedAuthURL, form, c.ClientID, clientSecret, pushed); err != nil {
+		return "", err
+	}
+	if pushed.RequestURI == "" {
+		return "", errors.New("oauth2: pushed authorization response without request_uri")
+	}
+	return c.authURL(url.Values{"client_id": {c.ClientID}, "request_uri": {pushed.RequestURI}}), nil
+}
@@ -0,0 +1,432 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/idp"
+	"github.com/nandlabs/turbo-auth/internal/secret"
+	"github.com/nandlabs/turbo-auth/nonce"
+	"github.com/nandlabs/turbo-auth/securecookie"
+	"github.com/nandlabs/turbo-auth/sessions"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type (
+	// ClaimMapping names the userinfo fields read into the UserInfo, an empty name is skipped
+	ClaimMapping struct {
+		Subject       string
+		Email         string
+		EmailVerified string
+		Name          string
+		Picture       string
+	}
+
+	// Preset holds the endpoints, default scopes and claim mapping of a social identity provider
+	Preset struct {
+		Name        string
+		AuthURL     string
+		TokenURL    string
+		UserInfoURL string
+		// EmailsURL lists the addresses of the user, queried when the userinfo lacks a verified email (GitHub)
+		EmailsURL      string
+		Scopes         []string
+		Mapping        ClaimMapping
+		SecretInParams bool
+		// FormPost has the provider POST the callback parameters (response_mode=form_post), the state cookie is
+		// then sent cross site
+		FormPost bool
+	}
+
+	// UserInfo is the normalized profile of the user authenticated by the social provider
+	UserInfo struct {
+		Provider      string
+		Subject       string
+		Email         string
+		EmailVerified bool
+		Name          string
+		Picture       string
+		// Claims are the raw userinfo claims
+		Claims map[string]interface{}
+	}
+
+	// UserResolver maps the authenticated user to the username and roles of the issued tokens, returning
+	// idp.ErrInvalidCredentials refuses the login
+	UserResolver func(ctx context.Context, info *UserInfo) (string, []string, error)
+
+	// SocialLogin runs the login with a social provider: LoginHandler redirects to the provider and CallbackHandler
+	// issues the token pair of the Issuer, or starts a session of the SessionManager when no Issuer is set
+	SocialLogin struct {
+		Preset Preset
+		Config *Config
+		// IDTokenVerifier provides the claims of the user from the id_token when the Config has no UserInfoURL
+		IDTokenVerifier *IDTokenVerifier
+		// Resolve is invoked once the user is authenticated, the username is "<provider>:<subject>" without roles
+		// when nil
+		Resolve        UserResolver
+		Issuer         idp.TokenIssuer
+		SessionManager *sessions.SessionManager
+		// Responder writes the token pair of the Issuer, see idp.WriteLoginResponse
+		Responder idp.TokenResponder
+		// RedirectURL is where the CallbackHandler sends the browser once logged in, the token pair is written
+		// instead when empty
+		RedirectURL string
+		// Insecure allows the state cookie over plain http, for local development only
+		Insecure bool
+		// Cookies signs, and optionally encrypts, the state cookie, it is then bound to the login it was issued for
+		// even when the cookie jar of the browser is shared
+		Cookies *securecookie.Codec
+		// States keeps the PKCE verifier and the OIDC nonce of the logins server side when set, the state cookie
+		// then only binds the login to the browser. A StateStore shared between the instances, e.g. the
+		// RedisStateStore, lets the callback reach any of them
+		States StateStore
+		// LogoutSubject maps the sub of the logout tokens to the username of the local sessions and tokens, set it
+		// along with Resolve. "<provider>:<subject>" when nil, see BackChannelLogoutHandler
+		LogoutSubject func(ctx context.Context, subject string) (string, error)
+		// LogoutReplay rejects the logout tokens whose jti was already seen, replays are not detected when nil
+		LogoutReplay nonce.Store
+		AuditLogger  audit.AuditLogger
+		// ErrorWriter renders the failures, plain text errors are written when nil
+		ErrorWriter turboError.ErrorWriter
+	}
+
+	githubEmail struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+)
+
+const (
+	stateCookiePrefix = "turbo_auth_oauth_"
+	stateTTL          = 10 * time.Minute
+)
+
+var (
+	Google = Preset{
+		Name:        "google",
+		AuthURL:     "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:    "https://oauth2.googleapis.com/token",
+		UserInfoURL: "https://openidconnect.googleapis.com/v1/userinfo",
+		Scopes:      []string{"openid", "email", "profile"},
+		Mapping:     ClaimMapping{Subject: "sub", Email: "email", EmailVerified: "email_verified", Name: "name", Picture: "picture"},
+	}
+
+	GitHub = Preset{
+		Name:           "github",
+		AuthURL:        "https://github.com/login/oauth/authorize",
+		TokenURL:       "https://github.com/login/oauth/access_token",
+		UserInfoURL:    "https://api.github.com/user",
+		EmailsURL:      "https://api.github.com/user/emails",
+		Scopes:         []string{"read:user", "user:email"},
+		Mapping:        ClaimMapping{Subject: "id", Email: "email", Name: "name", Picture: "avatar_url"},
+		SecretInParams: true,
+	}
+
+	// Microsoft accepts the work, school and personal accounts, see MicrosoftTenant to restrict the tenant
+	Microsoft = MicrosoftTenant("common")
+
+	// Presets are the built-in presets by name
+	Presets = map[string]Preset{
+		Google.Name:    Google,
+		GitHub.Name:    GitHub,
+		Microsoft.Name: Microsoft,
+	}
+
+	ErrStateMismatch = errors.New("oauth2: missing or mismatching state")
+	ErrNonceMismatch = errors.New("oauth2: missing or mismatching nonce")
+)
+
+// MicrosoftTenant returns the Microsoft identity platform preset of the tenant, e.g. a tenant id, "organizations"
+// or "consumers"
+func MicrosoftTenant(tenant string) Preset {
+	return Preset{
+		Name:        "microsoft",
+		AuthURL:     "https://login.microsoftonline.com/" + tenant + "/oauth2/v2.0/authorize",
+		TokenURL:    "https://login.microsoftonline.com/" + tenant + "/oauth2/v2.0/token",
+		UserInfoURL: "https://graph.microsoft.com/oidc/userinfo",
+		Scopes:      []string{"openid", "email", "profile"},
+		Mapping:     ClaimMapping{Subject: "sub", Email: "email", Name: "name", Picture: "picture"},
+	}
+}
+
+// Config returns the client Config of the preset, the default scopes are used when none are given
+func (p Preset) Config(clientID string, clientSecret string, redirectURL string, scopes ...string) *Config {
+	if len(scopes) == 0 {
+		scopes = p.Scopes
+	}
+	config := &Config{
+		ClientID:       clientID,
+		ClientSecret:   clientSecret,
+		AuthURL:        p.AuthURL,
+		TokenURL:       p.TokenURL,
+		UserInfoURL:    p.UserInfoURL,
+		RedirectURL:    redirectURL,
+		Scopes:         scopes,
+		SecretInParams: p.SecretInParams,
+	}
+	if p.FormPost {
+		config.AuthParams = url.Values{"response_mode": {"form_post"}}
+	}
+	return config
+}
+
+func NewSocialLogin(preset Preset, clientID string, clientSecret string, redirectURL string) *SocialLogin {
+	return &SocialLogin{
+		Preset: preset,
+		Config: preset.Config(clientID, clientSecret, redirectURL),
+	}
+}
+
+// FetchUserInfo fetches and maps the profile of the user, from the verified id_token when the Config has no
+// UserInfoURL
+func (s *SocialLogin) FetchUserInfo(ctx context.Context, token *Token) (*UserInfo, error) {
+	var claims map[string]interface{}
+	var err error
+	if s.Config.UserInfoURL == "" && s.IDTokenVerifier != nil {
+		claims, err = s.IDTokenVerifier.Verify(ctx, token.IDToken)
+	} else {
+		claims, err = s.Config.UserInfo(ctx, token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	mapping := s.Preset.Mapping
+	info := &UserInfo{
+		Provider: s.Preset.Name,
+		Subject:  stringClaim(claims, mapping.Subject),
+		Email:    stringClaim(claims, mapping.Email),
+		Name:     stringClaim(claims, mapping.Name),
+		Picture:  stringClaim(claims, mapping.Picture),
+		Claims:   claims,
+	}
+	if verified, ok := claims[mapping.EmailVerified]; ok {
+		info.EmailVerified = verified == true || verified == "true"
+	}
+	if info.Subject == "" {
+		return nil, fmt.Errorf("oauth2: the %s userinfo lacks the %s claim", s.Preset.Name, mapping.Subject)
+	}
+	if s.Preset.EmailsURL != "" && !info.EmailVerified {
+		var emails []githubEmail
+		if err := getJSON(ctx, s.Config.Client, s.Preset.EmailsURL, token.AccessToken, &emails); err != nil {
+			return nil, err
+		}
+		for _, email := range emails {
+			if email.Primary && email.Verified {
+				info.Email, info.EmailVerified = email.Email, true
+			}
+		}
+	}
+	return info, nil
+}
+
+// LoginHandler redirects the browser to the provider, the state and PKCE verifier are kept in a short lived cookie,
+// or in the States. The authorization request is pushed to the provider when the Config has a PushedAuthURL
+func (s *SocialLogin) LoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state, err := randomString(16)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "unable to start the login")
+			return
+		}
+		verifier, err := NewCodeVerifier()
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "unable to start the login")
+			return
+		}
+		authURL, err := s.startLogin(r.Context(), w, state, verifier)
+		if err != nil {
+			logger.ErrorF("unable to start the %s login: %v", s.Preset.Name, err)
+			s.writeError(w, r, http.StatusInternalServerError, "unable to start the login")
+			return
+		}
+		http.Redirect(w, r, authURL, http.StatusFound)
+	})
+}
+
+// startLogin keeps the state of the login and returns the url of the consent page
+func (s *SocialLogin) startLogin(ctx context.Context, w http.ResponseWriter, state string, verifier string) (string, error) {
+	var params url.Values
+	if s.States == nil {
+		s.setStateCookie(w, state+"."+verifier, int(stateTTL.Seconds()))
+	} else {
+		login := &LoginState{CodeVerifier: verifier}
+		if s.IDTokenVerifier != nil {
+			nonce, err := randomString(16)
+			if err != nil {
+				return "", err
+			}
+			login.Nonce, params = nonce, url.Values{"nonce": {nonce}}
+		}
+		if err := s.States.Save(ctx, state, login, stateTTL); err != nil {
+			return "", err
+		}
+		s.setStateCookie(w, state, int(stateTTL.Seconds()))
+	}
+	if s.Config.PushedAuthURL != "" {
+		return s.Config.PushAuthorizationRequest(ctx, state, verifier, params)
+	}
+	return s.Config.authURL(s.Config.authParams(state, verifier, params)), nil
+}
+
+// CallbackHandler checks the state, exchanges the code, fetches the user info and completes the login
+func (s *SocialLogin) CallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := r.FormValue("error"); code != "" {
+			s.fail(w, r, "", &TokenError{Code: code, Description: r.FormValue("error_description")})
+			return
+		}
+		login, err := s.checkState(r, r.FormValue("state"))
+		s.setStateCookie(w, "", -1)
+		if err != nil {
+			s.fail(w, r, "", err)
+			return
+		}
+		token, err := s.Config.Exchange(r.Context(), r.FormValue("code"), login.CodeVerifier)
+		if err != nil {
+			s.fail(w, r, "", err)
+			return
+		}
+		idClaims, err := s.verifyIDToken(r.Context(), token, login.Nonce)
+		if err != nil {
+			s.fail(w, r, "", err)
+			return
+		}
+		info, err := s.FetchUserInfo(r.Context(), token)
+		if err != nil {
+			s.fail(w, r, "", err)
+			return
+		}
+		if info.Name == "" && s.Preset.Name == Apple.Name {
+			info.Name = appleUserName(r.FormValue("user"))
+		}
+		username, roles := s.Preset.Name+":"+info.Subject, []string(nil)
+		if s.Resolve != nil {
+			if username, roles, err = s.Resolve(r.Context(), info); err != nil {
+				s.fail(w, r, info.Subject, err)
+				return
+			}
+		}
+		var values map[string]interface{}
+		if sid, _ := idClaims["sid"].(string); sid != "" {
+			values = map[string]interface{}{SessionValueSID: sid}
+		}
+		pair, err := idp.StartLogin(w, s.Issuer, s.SessionManager, username, roles, values)
+		s.audit(r, username, err)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "unable to complete the login")
+			return
+		}
+		idp.WriteLoginResponse(w, r, s.Responder, s.Issuer, pair, s.RedirectURL)
+	})
+}
+
+// checkState compares the state of the callback with the one of the cookie and returns the state of the login
+func (s *SocialLogin) checkState(r *http.Request, state string) (*LoginState, error) {
+	value, err := s.stateCookie(r)
+	if err != nil || state == "" {
+		return nil, ErrStateMismatch
+	}
+	if s.States != nil {
+		if !secret.Equal(state, value) {
+			return nil, ErrStateMismatch
+		}
+		return s.States.Take(r.Context(), state)
+	}
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 || !secret.Equal(state, parts[0]) {
+		return nil, ErrStateMismatch
+	}
+	return &LoginState{CodeVerifier: parts[1]}, nil
+}
+
+// verifyIDToken verifies the id_token of the token response and compares its nonce with the one of the login, nil
+// claims are returned without IDTokenVerifier or without id_token when no nonce was sent
+func (s *SocialLogin) verifyIDToken(ctx context.Context, token *Token, nonce string) (map[string]interface{}, error) {
+	if s.IDTokenVerifier == nil || (token.IDToken == "" && nonce == "") {
+		return nil, nil
+	}
+	claims, err := s.IDTokenVerifier.Verify(ctx, token.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if claimed, _ := claims["nonce"].(string); nonce != "" && !secret.Equal(claimed, nonce) {
+		return nil, ErrNonceMismatch
+	}
+	return claims, nil
+}
+
+func (s *SocialLogin) setStateCookie(w http.ResponseWriter, value string, maxAge int) {
+	cookie := &http.Cookie{
+		Name:     stateCookiePrefix + s.Preset.Name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   !s.Insecure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if s.Preset.FormPost {
+		// the cross site POST of the callback only carries the SameSite=None cookies
+		cookie.Secure, cookie.SameSite = true, http.SameSiteNoneMode
+	}
+	if s.Cookies == nil {
+		http.SetCookie(w, cookie)
+		return
+	}
+	if err := s.Cookies.SetCookie(w, cookie); err != nil {
+		logger.ErrorF("unable to encode the state cookie: %v", err)
+	}
+}
+
+func (s *SocialLogin) stateCookie(r *http.Request) (string, error) {
+	if s.Cookies != nil {
+		return s.Cookies.Cookie(r, stateCookiePrefix+s.Preset.Name)
+	}
+	cookie, err := r.Cookie(stateCookiePrefix + s.Preset.Name)
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+// fail audits the failure and answers 401, the provider errors are not detailed to the client
+func (s *SocialLogin) fail(w http.ResponseWriter, r *http.Request, subject string, err error) {
+	s.audit(r, subject, err)
+	logger.DebugF("%s login failed: %v", s.Preset.Name, err)
+	s.writeError(w, r, http.StatusUnauthorized, s.Preset.Name+" login failed")
+}
+
+func (s *SocialLogin) audit(r *http.Request, subject string, err error) {
+	if s.AuditLogger == nil {
+		return
+	}
+	event := audit.NewEvent(r, audit.EventLogin, s.Preset.Name, err)
+	event.Subject = subject
+	s.AuditLogger.Log(event)
+}
+
+func (s *SocialLogin) writeError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	turboError.WriteError(s.ErrorWriter, w, r, &turboError.HttpError{
+		StatusCode: statusCode,
+		Message:    "Error : " + message + " \n",
+	})
+}
+
+// stringClaim reads a string or number claim
+func stringClaim(claims map[string]interface{}, name string) string {
+	switch value := claims[name].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	default:
+		return ""
+	}
+}
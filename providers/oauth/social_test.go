@@ -0,0 +1,180 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nandlabs/turbo-auth/idp"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newGitHubServer fakes the GitHub endpoints, the user has a private email only listed by the emails api. The
+// pushed authorization requests are accepted on /login/oauth/par although GitHub has no such endpoint
+func newGitHubServer(t *testing.T) *httptest.Server {
+	var challenge, pushedChallenge string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login/oauth/par":
+			if r.PostFormValue("client_secret") != "secret" || r.PostFormValue("code_challenge") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"error": "invalid_client"}`))
+				return
+			}
+			pushedChallenge = r.PostFormValue("code_challenge")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"request_uri": "urn:ietf:params:oauth:request_uri:pushed", "expires_in": 60}`))
+		case "/login/oauth/authorize":
+			challenge = r.URL.Query().Get("code_challenge")
+			if r.URL.Query().Get("request_uri") == "urn:ietf:params:oauth:request_uri:pushed" {
+				challenge = pushedChallenge
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case "/login/oauth/access_token":
+			if r.PostFormValue("code") != "auth-code" || r.PostFormValue("client_secret") != "secret" ||
+				codeChallenge(r.PostFormValue("code_verifier")) != challenge {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error": "invalid_grant"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token": "gho_token", "token_type": "bearer"}`))
+		case "/user":
+			if r.Header.Get("Authorization") != "Bearer gho_token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id": 9007199254740991, "login": "octocat", "name": "The Octocat", "email": null}`))
+		case "/user/emails":
+			_, _ = w.Write([]byte(`[{"email": "old@example.com", "primary": false, "verified": true},
+				{"email": "octocat@example.com", "primary": true, "verified": true}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newGitHubLogin(server *httptest.Server) *SocialLogin {
+	preset := GitHub
+	preset.AuthURL = server.URL + "/login/oauth/authorize"
+	preset.TokenURL = server.URL + "/login/oauth/access_token"
+	preset.UserInfoURL = server.URL + "/user"
+	preset.EmailsURL = server.URL + "/user/emails"
+	return NewSocialLogin(preset, "client", "secret", "https://app.example.com/auth/github/callback")
+}
+
+// authorize runs the LoginHandler and the consent of the fake provider, the callback request is returned
+func authorize(t *testing.T, login *SocialLogin, state string) *http.Request {
+	w := httptest.NewRecorder()
+	login.LoginHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/github", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("LoginHandler() status = %v, want %v", w.Code, http.StatusFound)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := location.Query()
+	if query.Get("client_id") != "client" || query.Get("code_challenge_method") != "S256" ||
+		query.Get("scope") != "read:user user:email" {
+		t.Errorf("authorization url = %v", location)
+	}
+	res, err := http.Get(location.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if state == "" {
+		state = query.Get("state")
+	}
+	r := httptest.NewRequest(http.MethodGet, "/auth/github/callback?code=auth-code&state="+state, nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestSocialLogin_CallbackHandler(t *testing.T) {
+	server := newGitHubServer(t)
+	authConfig := jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+
+	tests := []struct {
+		name         string
+		state        string
+		resolve      UserResolver
+		wantStatus   int
+		wantSubject  string
+		wantRoleUser bool
+	}{
+		{name: "Test_default_username", wantStatus: http.StatusOK, wantSubject: "github:9007199254740991"},
+		{
+			name: "Test_resolved_user",
+			resolve: func(_ context.Context, info *UserInfo) (string, []string, error) {
+				if info.Email != "octocat@example.com" || !info.EmailVerified || info.Name != "The Octocat" {
+					t.Errorf("info = %+v", info)
+				}
+				return info.Email, []string{"user"}, nil
+			},
+			wantStatus:   http.StatusOK,
+			wantSubject:  "octocat@example.com",
+			wantRoleUser: true,
+		},
+		{
+			name: "Test_refused_user",
+			resolve: func(context.Context, *UserInfo) (string, []string, error) {
+				return "", nil, idp.ErrInvalidCredentials
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{name: "Test_state_mismatch", state: "forged", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login := newGitHubLogin(server)
+			login.Issuer = authConfig
+			login.Resolve = tt.resolve
+			w := httptest.NewRecorder()
+			login.CallbackHandler().ServeHTTP(w, authorize(t, login, tt.state))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("CallbackHandler() status = %v, want %v: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var response idp.LoginResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+			identity, err := authConfig.Authenticate(response.AccessToken)
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if identity.Subject != tt.wantSubject || identity.HasRole("user") != tt.wantRoleUser {
+				t.Errorf("identity = %+v", identity)
+			}
+			if cookie := w.Result().Cookies(); len(cookie) == 0 || cookie[0].MaxAge >= 0 {
+				t.Errorf("state cookie not cleared: %v", cookie)
+			}
+		})
+	}
+}
+
+func TestPresets(t *testing.T) {
+	for name, preset := range Presets {
+		if preset.Name != name || !strings.HasPrefix(preset.AuthURL, "https://") || !strings.HasPrefix(preset.TokenURL, "https://") ||
+			preset.UserInfoURL == "" || preset.Mapping.Subject == "" || len(preset.Scopes) == 0 {
+			t.Errorf("preset %v = %+v", name, preset)
+		}
+	}
+	if tenant := MicrosoftTenant("contoso.onmicrosoft.com"); !strings.Contains(tenant.TokenURL, "/contoso.onmicrosoft.com/") {
+		t.Errorf("MicrosoftTenant() token url = %v", tenant.TokenURL)
+	}
+}
@@ -0,0 +1,119 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"time"
+)
+
+type (
+	// LoginState is kept between the redirect to the provider and the callback
+	LoginState struct {
+		CodeVerifier string `json:"code_verifier"`
+		// Nonce is the OIDC nonce the id_token must carry, empty without IDTokenVerifier
+		Nonce string `json:"nonce,omitempty"`
+	}
+
+	// StateStore keeps the login states server side, a shared store lets the callback reach any instance behind the
+	// load balancer. Implementations must be safe for concurrent use
+	StateStore interface {
+		Save(ctx context.Context, state string, login *LoginState, ttl time.Duration) error
+		// Take returns and forgets the login state so that a state is used once, ErrStateMismatch when it is unknown
+		// or expired
+		Take(ctx context.Context, state string) (*LoginState, error)
+	}
+
+	// MemoryStateStore is an in-memory StateStore suitable for single instance deployments
+	MemoryStateStore struct {
+		mutex  sync.Mutex
+		states map[string]memoryState
+	}
+
+	// RedisStateStore shares the login states between instances, they expire with the keys
+	RedisStateStore struct {
+		Client    redis.UniversalClient
+		KeyPrefix string
+	}
+
+	memoryState struct {
+		login     *LoginState
+		expiresAt time.Time
+	}
+)
+
+const DefaultStateKeyPrefix = "turbo-auth:oauth-state:"
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{states: make(map[string]memoryState)}
+}
+
+func (m *MemoryStateStore) Save(_ context.Context, state string, login *LoginState, ttl time.Duration) error {
+	now := time.Now()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.states == nil {
+		m.states = make(map[string]memoryState)
+	}
+	// the abandoned logins are never taken, purge them
+	for key, entry := range m.states {
+		if now.After(entry.expiresAt) {
+			delete(m.states, key)
+		}
+	}
+	m.states[state] = memoryState{login: login, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+func (m *MemoryStateStore) Take(_ context.Context, state string) (*LoginState, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entry, ok := m.states[state]
+	delete(m.states, state)
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, ErrStateMismatch
+	}
+	return entry.login, nil
+}
+
+func NewRedisStateStore(client redis.UniversalClient) *RedisStateStore {
+	return &RedisStateStore{
+		Client:    client,
+		KeyPrefix: DefaultStateKeyPrefix,
+	}
+}
+
+func (s *RedisStateStore) Save(ctx context.Context, state string, login *LoginState, ttl time.Duration) error {
+	value, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+	return s.Client.Set(ctx, s.KeyPrefix+state, value, ttl).Err()
+}
+
+// Take reads and deletes the key in a transaction, GETDEL requires redis 6.2
+func (s *RedisStateStore) Take(ctx context.Context, state string) (*LoginState, error) {
+	pipe := s.Client.TxPipeline()
+	get := pipe.Get(ctx, s.KeyPrefix+state)
+	pipe.Del(ctx, s.KeyPrefix+state)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	value, err := get.Bytes()
+	if err == redis.Nil {
+		return nil, ErrStateMismatch
+	} else if err != nil {
+		return nil, err
+	}
+	var login LoginState
+	if err := json.Unmarshal(value, &login); err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+// Check pings the redis server, see health.Checker
+func (s *RedisStateStore) Check(ctx context.Context) error {
+	return s.Client.Ping(ctx).Err()
+}
@@ -0,0 +1,128 @@
+package oauth
+
+import (
+	"context"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMemoryStateStore_Take(t *testing.T) {
+	store := NewMemoryStateStore()
+	ctx := context.Background()
+	_ = store.Save(ctx, "valid", &LoginState{CodeVerifier: "verifier"}, time.Minute)
+	_ = store.Save(ctx, "expired", &LoginState{CodeVerifier: "verifier"}, -time.Second)
+
+	tests := []struct {
+		name    string
+		state   string
+		wantErr bool
+	}{
+		{name: "Test_valid", state: "valid"},
+		{name: "Test_reused", state: "valid", wantErr: true},
+		{name: "Test_expired", state: "expired", wantErr: true},
+		{name: "Test_unknown", state: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, err := store.Take(ctx, tt.state)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Take() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && login.CodeVerifier != "verifier" {
+				t.Errorf("Take() = %+v", login)
+			}
+		})
+	}
+}
+
+func TestSocialLogin_States(t *testing.T) {
+	server := newGitHubServer(t)
+	authConfig := jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+
+	tests := []struct {
+		name   string
+		pushed bool
+	}{
+		{name: "Test_redirect"},
+		{name: "Test_pushed_authorization_request", pushed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login := newGitHubLogin(server)
+			login.Issuer = authConfig
+			login.States = NewMemoryStateStore()
+			if tt.pushed {
+				login.Config.PushedAuthURL = server.URL + "/login/oauth/par"
+			}
+			w := httptest.NewRecorder()
+			login.LoginHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/github", nil))
+			if w.Code != http.StatusFound {
+				t.Fatalf("LoginHandler() status = %v, want %v: %s", w.Code, http.StatusFound, w.Body)
+			}
+			location, err := url.Parse(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := location.Query()
+			if tt.pushed != (query.Get("request_uri") != "") || tt.pushed != (query.Get("code_challenge") == "") {
+				t.Errorf("authorization url = %v", location)
+			}
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 || len(cookies[0].Value) != 22 {
+				t.Fatalf("state cookie = %v, want the state only", cookies)
+			}
+			res, err := http.Get(location.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			// the state is taken by the first callback, the replay is refused
+			for i, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+				r := httptest.NewRequest(http.MethodGet, "/auth/github/callback?code=auth-code&state="+cookies[0].Value, nil)
+				r.AddCookie(cookies[0])
+				w := httptest.NewRecorder()
+				login.CallbackHandler().ServeHTTP(w, r)
+				if w.Code != want {
+					t.Errorf("CallbackHandler() #%v status = %v, want %v: %s", i, w.Code, want, w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestConfig_PushAuthorizationRequest(t *testing.T) {
+	server := newGitHubServer(t)
+	tests := []struct {
+		name          string
+		pushedAuthURL string
+		clientSecret  string
+		wantErr       bool
+	}{
+		{name: "Test_pushed", pushedAuthURL: server.URL + "/login/oauth/par", clientSecret: "secret"},
+		{name: "Test_invalid_client", pushedAuthURL: server.URL + "/login/oauth/par", clientSecret: "wrong", wantErr: true},
+		{name: "Test_no_endpoint", clientSecret: "secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := GitHub.Config("client", tt.clientSecret, "https://app.example.com/callback")
+			config.AuthURL = server.URL + "/login/oauth/authorize"
+			config.PushedAuthURL = tt.pushedAuthURL
+			authURL, err := config.PushAuthorizationRequest(context.Background(), "state", "verifier", nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PushAuthorizationRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && authURL != config.AuthURL+"?client_id=client&request_uri=urn%3Aietf%3Aparams%3Aoauth%3Arequest_uri%3Apushed" {
+				t.Errorf("PushAuthorizationRequest() = %v", authURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nandlabs/turbo-auth/audit"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/idp"
+	"github.com/nandlabs/turbo-auth/internal/secret"
+	"github.com/nandlabs/turbo-auth/logging"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"github.com/nandlabs/turbo-auth/ratelimit"
+	"github.com/nandlabs/turbo-auth/sessions"
+	"math"
+	"math/big"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultCodeLength  = 6
+	DefaultTTL         = 5 * time.Minute
+	DefaultMaxAttempts = 5
+)
+
+type (
+	// SenderFunc delivers the code to the destination, a phone number or an email address
+	SenderFunc func(ctx context.Context, destination string, code string) error
+
+	// Provider authenticates the users by a one-time passcode sent to their destination, which is their username.
+	// The verification issues the token pair of the Issuer, or starts a session of the SessionManager when no
+	// Issuer is set
+	Provider struct {
+		Sender     SenderFunc
+		Store      CodeStore
+		CodeLength int
+		TTL        time.Duration
+		// MaxAttempts is the number of codes submitted after which the code is discarded
+		MaxAttempts int
+		// Limiter limits the codes sent to each destination, every code sent counts as an attempt. Protect the
+		// handlers with a ratelimit.Limiter by ip as well
+		Limiter *ratelimit.Limiter
+		// Users restricts the codes to the known and enabled users and provides their roles when set. Any
+		// destination may log in when nil
+		Users          idp.UserStore
+		Issuer         idp.TokenIssuer
+		SessionManager *sessions.SessionManager
+		// Responder writes the token pair of the Issuer, see idp.WriteLoginResponse
+		Responder   idp.TokenResponder
+		AuditLogger audit.AuditLogger
+		// ErrorWriter renders the failures, plain text errors are written when nil
+		ErrorWriter turboError.ErrorWriter
+	}
+
+	// CodeRequest is the json body of the RequestHandler and VerifyHandler, the form values are accepted too
+	CodeRequest struct {
+		Destination string `json:"destination"`
+		Code        string `json:"code,omitempty"`
+	}
+
+	// LimitedError is returned by SendCode when too many codes were sent to the destination
+	LimitedError struct {
+		RetryAfter time.Duration
+	}
+)
+
+const limiterKeyPrefix = "otp:"
+
+var (
+	ErrInvalidCode = errors.New("invalid or expired code")
+	// ErrTooManyAttempts is returned once MaxAttempts wrong codes were submitted, a new code must be requested
+	ErrTooManyAttempts = errors.New("too many wrong codes")
+
+	logger = logging.Get()
+)
+
+// NewProvider returns a Provider keeping the codes in memory and sending at most ratelimit.DefaultMaxAttempts
+// codes per destination within ratelimit.DefaultWindow
+func NewProvider(sender SenderFunc) *Provider {
+	return &Provider{
+		Sender:      sender,
+		Store:       NewMemoryCodeStore(),
+		CodeLength:  DefaultCodeLength,
+		TTL:         DefaultTTL,
+		MaxAttempts: DefaultMaxAttempts,
+		Limiter:     ratelimit.NewLimiter(nil),
+	}
+}
+
+// SendCode generates and sends a new code to the destination, replacing the pending one. A
+// *LimitedError is returned when too many codes were sent to the destination, the unknown or disabled
+// users are silently skipped when Users is set
+func (p *Provider) SendCode(ctx context.Context, destination string) error {
+	if p.Limiter != nil {
+		key := limiterKeyPrefix + destination
+		allowed, retryAfter, err := p.Limiter.AllowedContext(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &LimitedError{RetryAfter: retryAfter}
+		}
+		if err := p.Limiter.FailContext(ctx, key); err != nil {
+			return err
+		}
+	}
+	if p.Users != nil {
+		if _, err := p.roles(destination); errors.Is(err, idp.ErrInvalidCredentials) {
+			logger.DebugF("no code sent to the unknown or disabled user %s", destination)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	code, err := generateCode(p.codeLength())
+	if err != nil {
+		return err
+	}
+	if err := p.Store.Save(destination, &Code{Hash: hashCode(code), ExpiresAt: time.Now().Add(p.ttl())}); err != nil {
+		return err
+	}
+	return p.Sender(ctx, destination, code)
+}
+
+// Verify checks the code of the destination, the code is discarded once verified or after MaxAttempts codes. The
+// attempt is counted before the code is compared so that concurrent guesses cannot exceed MaxAttempts
+func (p *Provider) Verify(destination string, code string) error {
+	pending, err := p.Store.Load(destination)
+	if errors.Is(err, ErrCodeNotFound) {
+		return ErrInvalidCode
+	} else if err != nil {
+		return err
+	}
+	if !time.Now().Before(pending.ExpiresAt) {
+		_ = p.Store.Delete(destination)
+		return ErrInvalidCode
+	}
+	attempts, err := p.Store.IncrementAttempts(destination)
+	if errors.Is(err, ErrCodeNotFound) {
+		return ErrInvalidCode
+	} else if err != nil {
+		return err
+	}
+	if attempts > p.maxAttempts() {
+		if err := p.Store.Delete(destination); err != nil {
+			return err
+		}
+		return ErrTooManyAttempts
+	}
+	if secret.Equal(hashCode(code), pending.Hash) {
+		if p.Limiter != nil {
+			if err := p.Limiter.Succeed(limiterKeyPrefix + destination); err != nil {
+				logger.ErrorF("rate limit store error: %v", err)
+			}
+		}
+		return p.Store.Delete(destination)
+	}
+	if attempts == p.maxAttempts() {
+		if err := p.Store.Delete(destination); err != nil {
+			return err
+		}
+		return ErrTooManyAttempts
+	}
+	return ErrInvalidCode
+}
+
+// RequestHandler sends a code to the POSTed destination and answers 202 whether or not the destination is
+// registered, 429 when too many codes were sent to the destination
+func (p *Provider) RequestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request, ok := p.readRequest(w, r)
+		if !ok {
+			return
+		}
+		err := p.SendCode(r.Context(), request.Destination)
+		var limitedErr *LimitedError
+		if errors.As(err, &limitedErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limitedErr.RetryAfter.Seconds()))))
+			p.writeError(w, r, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		if err != nil {
+			logger.ErrorF("unable to send the code: %v", err)
+			p.writeError(w, r, http.StatusInternalServerError, "unable to send the code")
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// VerifyHandler verifies the POSTed destination and code, then issues the tokens or starts the session
+func (p *Provider) VerifyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request, ok := p.readRequest(w, r)
+		if !ok {
+			return
+		}
+		err := p.Verify(request.Destination, request.Code)
+		var roles []string
+		if err == nil {
+			roles, err = p.roles(request.Destination)
+		}
+		var pair *jwt.TokenPair
+		if err == nil {
+			pair, err = idp.StartLogin(w, p.Issuer, p.SessionManager, request.Destination, roles, nil)
+		}
+		p.audit(r, request.Destination, err)
+		switch {
+		case errors.Is(err, ErrInvalidCode) || errors.Is(err, ErrTooManyAttempts) || errors.Is(err, idp.ErrInvalidCredentials):
+			p.writeError(w, r, http.StatusUnauthorized, err.Error())
+		case err != nil:
+			p.writeError(w, r, http.StatusInternalServerError, "unable to complete the login")
+		default:
+			idp.WriteLoginResponse(w, r, p.Responder, p.Issuer, pair, "")
+		}
+	})
+}
+
+// roles returns the roles of the user, idp.ErrInvalidCredentials when the user is unknown or disabled
+func (p *Provider) roles(destination string) ([]string, error) {
+	if p.Users == nil {
+		return nil, nil
+	}
+	user, err := p.Users.FindByUsername(destination)
+	if errors.Is(err, idp.ErrUserNotFound) || (err == nil && user.Disabled) {
+		return nil, idp.ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	return p.Users.GetRoles(user)
+}
+
+func (p *Provider) readRequest(w http.ResponseWriter, r *http.Request) (*CodeRequest, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		p.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return nil, false
+	}
+	var request CodeRequest
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		err = json.NewDecoder(r.Body).Decode(&request)
+	} else if err = r.ParseForm(); err == nil {
+		request.Destination = r.PostForm.Get("destination")
+		request.Code = r.PostForm.Get("code")
+	}
+	request.Destination = strings.TrimSpace(request.Destination)
+	if err != nil || request.Destination == "" {
+		p.writeError(w, r, http.StatusBadRequest, "a destination is required")
+		return nil, false
+	}
+	return &request, true
+}
+
+func (p *Provider) audit(r *http.Request, destination string, err error) {
+	if p.AuditLogger == nil {
+		return
+	}
+	event := audit.NewEvent(r, audit.EventLogin, "otp", err)
+	event.Subject = destination
+	p.AuditLogger.Log(event)
+}
+
+func (p *Provider) writeError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	turboError.WriteError(p.ErrorWriter, w, r, &turboError.HttpError{
+		StatusCode: statusCode,
+		Message:    "Error : " + message + " \n",
+	})
+}
+
+func (p *Provider) codeLength() int {
+	if p.CodeLength > 0 {
+		return p.CodeLength
+	}
+	return DefaultCodeLength
+}
+
+func (p *Provider) ttl() time.Duration {
+	if p.TTL > 0 {
+		return p.TTL
+	}
+	return DefaultTTL
+}
+
+func (p *Provider) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+func (err *LimitedError) Error() string {
+	return "too many codes requested, retry after " + strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))) + "s"
+}
+
+// generateCode returns a uniformly distributed code of length digits
+func generateCode(length int) (string, error) {
+	n, err := rand.Int(rand.Reader, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(length)), nil))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", length, n), nil
+}
+
+// hashCode keeps the codes out of the store in clear text
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,151 @@
+package otp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/nandlabs/turbo-auth/idp"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type inbox struct {
+	mutex sync.Mutex
+	codes map[string]string
+}
+
+func (i *inbox) send(_ context.Context, destination string, code string) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.codes[destination] = code
+	return nil
+}
+
+func (i *inbox) code(destination string) string {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.codes[destination]
+}
+
+func newTestProvider() (*Provider, *inbox, *jwt.JwtAuthConfig) {
+	messages := &inbox{codes: make(map[string]string)}
+	authConfig := jwt.CreateJwtAuthenticator(&jwt.JwtAuthConfig{
+		SigningKey:    "test_key",
+		SigningMethod: "HS256",
+		BearerTokens:  true,
+	})
+	provider := NewProvider(messages.send)
+	provider.Issuer = authConfig
+	return provider, messages, authConfig
+}
+
+func post(handler http.Handler, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestProvider_VerifyHandler(t *testing.T) {
+	provider, messages, authConfig := newTestProvider()
+	if w := post(provider.RequestHandler(), `{"destination": "+15550100"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("RequestHandler() status = %v, want %v", w.Code, http.StatusAccepted)
+	}
+	code := messages.code("+15550100")
+	if !regexp.MustCompile(`^[0-9]{6}$`).MatchString(code) {
+		t.Fatalf("code = %q, want 6 digits", code)
+	}
+
+	w := post(provider.VerifyHandler(), `{"destination": "+15550100", "code": "`+code+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("VerifyHandler() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	var response idp.LoginResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+	if identity, err := authConfig.Authenticate(response.AccessToken); err != nil || identity.Subject != "+15550100" {
+		t.Errorf("Authenticate() = %+v, %v", identity, err)
+	}
+
+	if w := post(provider.VerifyHandler(), `{"destination": "+15550100", "code": "`+code+`"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("VerifyHandler() reused code status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestProvider_Verify(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(p *Provider)
+		wrong   int
+		// used counts the attempts of concurrent requests
+		used    int
+		wantErr error
+	}{
+		{name: "Test_valid"},
+		{name: "Test_wrong_codes_then_valid", wrong: 2},
+		{name: "Test_too_many_attempts", wrong: 3, wantErr: ErrInvalidCode},
+		{name: "Test_attempts_used_concurrently", used: 3, wantErr: ErrTooManyAttempts},
+		{name: "Test_expired", prepare: func(p *Provider) { p.TTL = time.Nanosecond }, wantErr: ErrInvalidCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, messages, _ := newTestProvider()
+			provider.MaxAttempts = 3
+			if tt.prepare != nil {
+				tt.prepare(provider)
+			}
+			if err := provider.SendCode(context.Background(), "alice@example.com"); err != nil {
+				t.Fatalf("SendCode() error = %v", err)
+			}
+			code := messages.code("alice@example.com")
+			time.Sleep(time.Millisecond)
+			for i := 0; i < tt.used; i++ {
+				if _, err := provider.Store.IncrementAttempts("alice@example.com"); err != nil {
+					t.Fatalf("IncrementAttempts() error = %v", err)
+				}
+			}
+			for i := 0; i < tt.wrong; i++ {
+				err := provider.Verify("alice@example.com", "wrong")
+				if i == provider.MaxAttempts-1 {
+					if !errors.Is(err, ErrTooManyAttempts) {
+						t.Fatalf("Verify() last wrong code error = %v, want %v", err, ErrTooManyAttempts)
+					}
+				} else if !errors.Is(err, ErrInvalidCode) {
+					t.Fatalf("Verify() wrong code error = %v, want %v", err, ErrInvalidCode)
+				}
+			}
+			if err := provider.Verify("alice@example.com", code); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProvider_SendCode_limited(t *testing.T) {
+	provider, _, _ := newTestProvider()
+	provider.Limiter.MaxAttempts = 2
+	for i := 0; i < 2; i++ {
+		if err := provider.SendCode(context.Background(), "alice@example.com"); err != nil {
+			t.Fatalf("SendCode() %v error = %v", i, err)
+		}
+	}
+	var limitedErr *LimitedError
+	if err := provider.SendCode(context.Background(), "alice@example.com"); !errors.As(err, &limitedErr) || limitedErr.RetryAfter <= 0 {
+		t.Fatalf("SendCode() error = %v, want a LimitedError", err)
+	}
+	if w := post(provider.RequestHandler(), `{"destination": "alice@example.com"}`); w.Code != http.StatusTooManyRequests ||
+		w.Header().Get("Retry-After") == "" {
+		t.Errorf("RequestHandler() status = %v, Retry-After = %v", w.Code, w.Header().Get("Retry-After"))
+	}
+	if err := provider.SendCode(context.Background(), "bob@example.com"); err != nil {
+		t.Errorf("SendCode() other destination error = %v", err)
+	}
+}
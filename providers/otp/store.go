@@ -0,0 +1,160 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// Code is a pending code, only its hash is stored
+	Code struct {
+		Hash      string
+		ExpiresAt time.Time
+		// Attempts counts the codes submitted
+		Attempts int
+	}
+
+	// CodeStore keeps the pending code of each destination, implementations must be safe for concurrent use
+	CodeStore interface {
+		// Save replaces the code of the destination
+		Save(destination string, code *Code) error
+		// Load returns ErrCodeNotFound when no code is pending
+		Load(destination string) (*Code, error)
+		Delete(destination string) error
+		// IncrementAttempts atomically counts a submitted code and returns the attempts, ErrCodeNotFound when no
+		// code is pending
+		IncrementAttempts(destination string) (int, error)
+	}
+
+	// MemoryCodeStore is an in-memory CodeStore suitable for single instance deployments
+	MemoryCodeStore struct {
+		mutex sync.Mutex
+		codes map[string]Code
+	}
+
+	// RedisCodeStore shares the pending codes between instances, they expire with the keys
+	RedisCodeStore struct {
+		Client    redis.UniversalClient
+		KeyPrefix string
+	}
+)
+
+const DefaultCodeKeyPrefix = "turbo-auth:otp:"
+
+var (
+	ErrCodeNotFound = errors.New("no pending code")
+
+	// incrementScript increments the attempts of a pending code only, HINCRBY alone would create the key
+	incrementScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+return redis.call("HINCRBY", KEYS[1], "attempts", 1)`)
+)
+
+func NewMemoryCodeStore() *MemoryCodeStore {
+	return &MemoryCodeStore{codes: make(map[string]Code)}
+}
+
+func (m *MemoryCodeStore) Save(destination string, code *Code) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	for pending, stored := range m.codes {
+		if now.After(stored.ExpiresAt) {
+			delete(m.codes, pending)
+		}
+	}
+	m.codes[destination] = *code
+	return nil
+}
+
+func (m *MemoryCodeStore) Load(destination string) (*Code, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	code, ok := m.codes[destination]
+	if !ok {
+		return nil, ErrCodeNotFound
+	}
+	return &code, nil
+}
+
+func (m *MemoryCodeStore) Delete(destination string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.codes, destination)
+	return nil
+}
+
+func (m *MemoryCodeStore) IncrementAttempts(destination string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	code, ok := m.codes[destination]
+	if !ok {
+		return 0, ErrCodeNotFound
+	}
+	code.Attempts++
+	m.codes[destination] = code
+	return code.Attempts, nil
+}
+
+func NewRedisCodeStore(client redis.UniversalClient) *RedisCodeStore {
+	return &RedisCodeStore{
+		Client:    client,
+		KeyPrefix: DefaultCodeKeyPrefix,
+	}
+}
+
+func (s *RedisCodeStore) Save(destination string, code *Code) error {
+	ctx := context.Background()
+	key := s.KeyPrefix + destination
+	pipe := s.Client.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key, "hash", code.Hash, "expires", code.ExpiresAt.UnixNano(), "attempts", code.Attempts)
+	pipe.ExpireAt(ctx, key, code.ExpiresAt)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisCodeStore) Load(destination string) (*Code, error) {
+	values, err := s.Client.HGetAll(context.Background(), s.KeyPrefix+destination).Result()
+	if err != nil {
+		return nil, err
+	}
+	if values["hash"] == "" {
+		return nil, ErrCodeNotFound
+	}
+	expires, err := strconv.ParseInt(values["expires"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := strconv.Atoi(values["attempts"])
+	if err != nil {
+		return nil, err
+	}
+	return &Code{Hash: values["hash"], ExpiresAt: time.Unix(0, expires), Attempts: attempts}, nil
+}
+
+func (s *RedisCodeStore) Delete(destination string) error {
+	return s.Client.Del(context.Background(), s.KeyPrefix+destination).Err()
+}
+
+func (s *RedisCodeStore) IncrementAttempts(destination string) (int, error) {
+	attempts, err := incrementScript.Run(context.Background(), s.Client, []string{s.KeyPrefix + destination}).Int()
+	if err != nil {
+		return 0, err
+	}
+	if attempts < 0 {
+		return 0, ErrCodeNotFound
+	}
+	return attempts, nil
+}
+
+// Check pings the redis server, see health.Checker
+func (s *RedisCodeStore) Check(ctx context.Context) error {
+	return s.Client.Ping(ctx).Err()
+}
@@ -0,0 +1,42 @@
+package webauthn
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryChallengeStore is an in-memory ChallengeStore suitable for single instance deployments
+type MemoryChallengeStore struct {
+	mutex    sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewMemoryChallengeStore() *MemoryChallengeStore {
+	return &MemoryChallengeStore{
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (m *MemoryChallengeStore) Save(id string, session *Session) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	for key, s := range m.sessions {
+		if now.After(s.Expires) {
+			delete(m.sessions, key)
+		}
+	}
+	m.sessions[id] = session
+	return nil
+}
+
+func (m *MemoryChallengeStore) Load(id string) (*Session, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrCeremonyNotFound
+	}
+	delete(m.sessions, id)
+	return session, nil
+}
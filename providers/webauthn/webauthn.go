@@ -0,0 +1,278 @@
+package webauthn
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/go-webauthn/webauthn/webauthn"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/idp"
+	"github.com/nandlabs/turbo-auth/logging"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultCeremonyCookieName = "WebAuthnSession"
+	DefaultCeremonyTimeout    = 5 * time.Minute
+	UsernameParam             = "username"
+)
+
+type (
+	// UserStore resolves the users taking part in the ceremonies and persists their credentials
+	UserStore interface {
+		User(username string) (webauthn.User, error)
+		// SaveCredential stores a newly registered credential of the user
+		SaveCredential(username string, credential *webauthn.Credential) error
+		// UpdateCredential persists the sign counter of the credential after a login
+		UpdateCredential(username string, credential *webauthn.Credential) error
+	}
+
+	// ChallengeStore keeps the session data between the begin and the finish of a ceremony
+	ChallengeStore interface {
+		Save(id string, session *Session) error
+		// Load returns and deletes the session, a challenge can only be answered once
+		Load(id string) (*Session, error)
+	}
+
+	// EnrollmentFunc returns the username of the account being created by the request, e.g. from a verified email
+	// enrollment token, reporting false when the request carries no verified enrollment
+	EnrollmentFunc func(r *http.Request) (string, bool)
+
+	Session struct {
+		Username string
+		Data     webauthn.SessionData
+		Expires  time.Time
+	}
+
+	// Provider runs the registration and login ceremonies, a successful login issues a turbo-auth jwt
+	Provider struct {
+		WebAuthn   *webauthn.WebAuthn
+		Users      UserStore
+		Challenges ChallengeStore
+		JwtConfig  *jwt.JwtAuthConfig
+		// Responder writes the token of FinishLogin, see idp.WriteLoginResponse
+		Responder idp.TokenResponder
+		// CeremonyCookieName carries the id of the pending ceremony between the begin and finish requests
+		CeremonyCookieName string
+		CeremonyTimeout    time.Duration
+		// Enrollment lets the new accounts register their first credential without an authenticated identity,
+		// the registration requires the identity of the user in the request context when nil
+		Enrollment EnrollmentFunc
+	}
+)
+
+var (
+	logger = logging.Get()
+
+	ErrCeremonyNotFound    = errors.New("webauthn ceremony not found or expired")
+	ErrUnauthenticated     = errors.New("registration requires an authenticated user or a verified enrollment")
+	ErrClonedAuthenticator = errors.New("authenticator sign counter went backwards, possible cloned authenticator")
+)
+
+func NewProvider(config *webauthn.Config, users UserStore, challenges ChallengeStore, jwtConfig *jwt.JwtAuthConfig) (*Provider, error) {
+	w, err := webauthn.New(config)
+	if err != nil {
+		return nil, err
+	}
+	if challenges == nil {
+		challenges = NewMemoryChallengeStore()
+	}
+	return &Provider{
+		WebAuthn:           w,
+		Users:              users,
+		Challenges:         challenges,
+		JwtConfig:          jwtConfig,
+		CeremonyCookieName: DefaultCeremonyCookieName,
+		CeremonyTimeout:    DefaultCeremonyTimeout,
+	}, nil
+}
+
+// BeginRegistration returns the credential creation options of the authenticated user, it must be placed after the
+// authenticator of the user unless the Enrollment verifies the new accounts
+func (p *Provider) BeginRegistration() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, ok := p.registrant(r)
+		if !ok {
+			p.writeError(w, r, http.StatusUnauthorized, "Error : "+ErrUnauthenticated.Error()+" \n")
+			return
+		}
+		user, err := p.Users.User(username)
+		if err != nil {
+			p.writeError(w, r, http.StatusNotFound, "Error : unknown user \n")
+			return
+		}
+		options, data, err := p.WebAuthn.BeginRegistration(user)
+		if err != nil {
+			p.writeError(w, r, http.StatusInternalServerError, "Error : unable to begin registration \n")
+			return
+		}
+		if err := p.startCeremony(w, username, data); err != nil {
+			p.writeError(w, r, http.StatusInternalServerError, "Error : unable to store the challenge \n")
+			return
+		}
+		writeJSON(w, options)
+	})
+}
+
+// FinishRegistration verifies the attestation of the authenticator and stores the new credential of the user who
+// began the registration
+func (p *Provider) FinishRegistration() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, ok := p.registrant(r)
+		if !ok {
+			p.writeError(w, r, http.StatusUnauthorized, "Error : "+ErrUnauthenticated.Error()+" \n")
+			return
+		}
+		session, user, err := p.resumeCeremony(r)
+		if err != nil {
+			p.writeError(w, r, http.StatusBadRequest, "Error : "+err.Error()+" \n")
+			return
+		}
+		if session.Username != username {
+			p.writeError(w, r, http.StatusForbidden, "Error : the registration belongs to another user \n")
+			return
+		}
+		credential, err := p.WebAuthn.FinishRegistration(user, session.Data, r)
+		if err != nil {
+			p.writeError(w, r, http.StatusBadRequest, "Error : invalid attestation \n")
+			return
+		}
+		if err := p.Users.SaveCredential(session.Username, credential); err != nil {
+			p.writeError(w, r, http.StatusInternalServerError, "Error : unable to store the credential \n")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+}
+
+// BeginLogin returns the assertion options for the username query parameter
+func (p *Provider) BeginLogin() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := r.URL.Query().Get(UsernameParam)
+		user, err := p.Users.User(username)
+		if err != nil {
+			p.writeError(w, r, http.StatusNotFound, "Error : unknown user \n")
+			return
+		}
+		options, data, err := p.WebAuthn.BeginLogin(user)
+		if err != nil {
+			p.writeError(w, r, http.StatusBadRequest, "Error : unable to begin login \n")
+			return
+		}
+		if err := p.startCeremony(w, username, data); err != nil {
+			p.writeError(w, r, http.StatusInternalServerError, "Error : unable to store the challenge \n")
+			return
+		}
+		writeJSON(w, options)
+	})
+}
+
+// FinishLogin verifies the assertion, checks the sign counter and issues the turbo-auth token
+func (p *Provider) FinishLogin() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, user, err := p.resumeCeremony(r)
+		if err != nil {
+			p.writeError(w, r, http.StatusUnauthorized, "Error : "+err.Error()+" \n")
+			return
+		}
+		credential, err := p.WebAuthn.FinishLogin(user, session.Data, r)
+		if err != nil {
+			p.writeError(w, r, http.StatusUnauthorized, "Error : invalid assertion \n")
+			return
+		}
+		if credential.Authenticator.CloneWarning {
+			logger.ErrorF("%v, user: %s", ErrClonedAuthenticator, session.Username)
+			p.writeError(w, r, http.StatusUnauthorized, "Error : "+ErrClonedAuthenticator.Error()+" \n")
+			return
+		}
+		if err := p.Users.UpdateCredential(session.Username, credential); err != nil {
+			p.writeError(w, r, http.StatusInternalServerError, "Error : unable to update the credential \n")
+			return
+		}
+		token, jwtErr := p.JwtConfig.IssueNewToken(session.Username, p.JwtConfig.AuthTokenValidTime)
+		if jwtErr != nil {
+			p.writeError(w, r, http.StatusInternalServerError, "Error : unable to issue the token \n")
+			return
+		}
+		pair := &jwt.TokenPair{AuthToken: token, ExpiresIn: p.JwtConfig.AuthTokenValidTime}
+		idp.WriteLoginResponse(w, r, p.Responder, p.JwtConfig, pair, "")
+	})
+}
+
+// registrant returns the user registering a credential, the authenticated identity or the verified enrollment
+func (p *Provider) registrant(r *http.Request) (string, bool) {
+	if identity, ok := turboAuth.IdentityFromContext(r.Context()); ok && identity.Subject != "" {
+		return identity.Subject, true
+	}
+	if p.Enrollment != nil {
+		return p.Enrollment(r)
+	}
+	return "", false
+}
+
+func (p *Provider) startCeremony(w http.ResponseWriter, username string, data *webauthn.SessionData) error {
+	id, err := randomID()
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(p.CeremonyTimeout)
+	if err := p.Challenges.Save(id, &Session{Username: username, Data: *data, Expires: expires}); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     p.CeremonyCookieName,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+func (p *Provider) resumeCeremony(r *http.Request) (*Session, webauthn.User, error) {
+	cookie, err := r.Cookie(p.CeremonyCookieName)
+	if err != nil {
+		return nil, nil, ErrCeremonyNotFound
+	}
+	session, err := p.Challenges.Load(cookie.Value)
+	if err != nil || session == nil || time.Now().After(session.Expires) {
+		return nil, nil, ErrCeremonyNotFound
+	}
+	user, err := p.Users.User(session.Username)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, user, nil
+}
+
+func (p *Provider) writeError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	errorWriter := turboError.ErrorWriter(nil)
+	if p.JwtConfig != nil {
+		errorWriter = p.JwtConfig.ErrorWriter
+	}
+	turboError.WriteError(errorWriter, w, r, &turboError.HttpError{
+		StatusCode: statusCode,
+		Message:    message,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.ErrorF("unable to write the ceremony options: %v", err)
+	}
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,221 @@
+package quota
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/logging"
+	"github.com/nandlabs/turbo-auth/ratelimit"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Daily   Period = "daily"
+	Monthly Period = "monthly"
+
+	HeaderLimit     = "X-Quota-Limit"
+	HeaderRemaining = "X-Quota-Remaining"
+	// HeaderReset is the number of seconds until the quota resets
+	HeaderReset = "X-Quota-Reset"
+)
+
+type (
+	// Period is the calendar period the requests are counted over, the quotas reset at its start
+	Period string
+
+	// Limit is the number of requests allowed per Period
+	Limit struct {
+		Period   Period
+		Requests int64
+	}
+
+	// Store counts the requests, the ratelimit.MemoryStore and ratelimit.RedisStore are suitable
+	Store interface {
+		// Increment counts a request for the key and returns the requests counted within the ttl
+		Increment(key string, ttl time.Duration) (int64, error)
+	}
+
+	// Quota enforces daily and monthly request limits per authenticated subject or API key, and per route. The
+	// identity must have been authenticated by the middleware wrapping the Quota
+	Quota struct {
+		Store Store
+		// Limits apply to all the identities and routes unless LimitsFunc is set
+		Limits []Limit
+		// LimitsFunc selects the limits of the request, e.g. by the plan of the identity or by route, no limits
+		// apply when it returns none
+		LimitsFunc func(r *http.Request, identity *turboAuth.Identity) []Limit
+		// KeyFunc identifies the consumer of the quota, BySubject when nil
+		KeyFunc KeyFunc
+		// Route groups the requests counted together, e.g. ByPathPrefix, the whole API is one route when nil
+		Route func(r *http.Request) string
+		// Location sets the start of the days and months, UTC when nil
+		Location    *time.Location
+		ErrorWriter turboError.ErrorWriter
+	}
+
+	// KeyFunc derives the quota key from the request and its identity, an empty key is not counted
+	KeyFunc func(r *http.Request, identity *turboAuth.Identity) string
+
+	// Usage is the state of a quota after the request was counted
+	Usage struct {
+		Limit Limit
+		Used  int64
+		// Reset is the start of the next period
+		Reset time.Time
+	}
+)
+
+const keyPrefix = "quota:"
+
+var logger = logging.Get()
+
+func NewQuota(store Store, limits ...Limit) *Quota {
+	if store == nil {
+		store = ratelimit.NewMemoryStore()
+	}
+	return &Quota{
+		Store:  store,
+		Limits: limits,
+	}
+}
+
+// Count counts the request against every limit and returns the usages, the exceeded and then the most constrained
+// first. The rejected requests count as well
+func (q *Quota) Count(r *http.Request, identity *turboAuth.Identity, now time.Time) ([]Usage, error) {
+	keyFunc := q.KeyFunc
+	if keyFunc == nil {
+		keyFunc = BySubject
+	}
+	key := keyFunc(r, identity)
+	if key == "" {
+		return nil, nil
+	}
+	limits := q.Limits
+	if q.LimitsFunc != nil {
+		limits = q.LimitsFunc(r, identity)
+	}
+	var route string
+	if q.Route != nil {
+		route = q.Route(r)
+	}
+	var usages []Usage
+	for _, limit := range limits {
+		start, reset := limit.Period.window(now, q.Location)
+		windowKey := keyPrefix + key + ":" + route + ":" + string(limit.Period) + ":" + strconv.FormatInt(start.Unix(), 10)
+		// the counter outlives the period by a minute to cover the clock skew between the instances
+		used, err := q.Store.Increment(windowKey, reset.Sub(now)+time.Minute)
+		if err != nil {
+			return usages, err
+		}
+		usages = append(usages, Usage{Limit: limit, Used: used, Reset: reset})
+	}
+	sort.SliceStable(usages, func(i, j int) bool {
+		if usages[i].Exceeded() != usages[j].Exceeded() {
+			return usages[i].Exceeded()
+		}
+		return usages[i].Remaining() < usages[j].Remaining()
+	})
+	return usages, nil
+}
+
+// Middleware counts the requests and answers 429 once a quota is exhausted, the X-Quota headers describe the most
+// constrained quota. The requests are let through when the Store fails
+func (q *Quota) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := turboAuth.IdentityFromContext(r.Context())
+		now := time.Now()
+		usages, err := q.Count(r, identity, now)
+		if err != nil {
+			logger.ErrorF("quota store error: %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(usages) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		usage := usages[0]
+		resetIn := strconv.Itoa(int(math.Ceil(usage.Reset.Sub(now).Seconds())))
+		w.Header().Set(HeaderLimit, strconv.FormatInt(usage.Limit.Requests, 10))
+		w.Header().Set(HeaderRemaining, strconv.FormatInt(usage.Remaining(), 10))
+		w.Header().Set(HeaderReset, resetIn)
+		if usage.Exceeded() {
+			w.Header().Set("Retry-After", resetIn)
+			turboError.WriteError(q.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: http.StatusTooManyRequests,
+				Message:    "Error : " + string(usage.Limit.Period) + " quota exceeded \n",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Remaining is the number of requests left in the period
+func (u Usage) Remaining() int64 {
+	if remaining := u.Limit.Requests - u.Used; remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Exceeded reports whether the counted request was over the limit
+func (u Usage) Exceeded() bool {
+	return u.Used > u.Limit.Requests
+}
+
+// window returns the start of the period containing now and the start of the next one
+func (p Period) window(now time.Time, location *time.Location) (time.Time, time.Time) {
+	if location == nil {
+		location = time.UTC
+	}
+	now = now.In(location)
+	if p == Monthly {
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// BySubject keys the quotas by the subject of the identity, within its tenant
+func BySubject(r *http.Request, identity *turboAuth.Identity) string {
+	if identity == nil || identity.Subject == "" {
+		return ""
+	}
+	if identity.Tenant != "" {
+		return "sub:" + identity.Tenant + "/" + identity.Subject
+	}
+	return "sub:" + identity.Subject
+}
+
+// ByAPIKey keys the quotas by the API key of the header, the key is hashed so that it is not kept in the Store
+func ByAPIKey(header string) KeyFunc {
+	return func(r *http.Request, _ *turboAuth.Identity) string {
+		apiKey := r.Header.Get(header)
+		if apiKey == "" {
+			return ""
+		}
+		sum := sha256.Sum256([]byte(apiKey))
+		return "key:" + hex.EncodeToString(sum[:16])
+	}
+}
+
+// ByPathPrefix counts the requests per the longest matching prefix, the other requests are counted together
+func ByPathPrefix(prefixes ...string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		var route string
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > len(route) {
+				route = prefix
+			}
+		}
+		return route
+	}
+}
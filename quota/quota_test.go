@@ -0,0 +1,123 @@
+package quota
+
+import (
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQuota_Middleware(t *testing.T) {
+	q := NewQuota(nil, Limit{Period: Daily, Requests: 2}, Limit{Period: Monthly, Requests: 100})
+	q.Route = ByPathPrefix("/reports", "/orders")
+	handler := q.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(subject string, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if subject != "" {
+			r = r.WithContext(turboAuth.NewContext(r.Context(), &turboAuth.Identity{Subject: subject}))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name          string
+		subject       string
+		path          string
+		wantCode      int
+		wantRemaining string
+	}{
+		{name: "Test_first", subject: "alice", path: "/reports/1", wantCode: http.StatusOK, wantRemaining: "1"},
+		{name: "Test_second", subject: "alice", path: "/reports/2", wantCode: http.StatusOK, wantRemaining: "0"},
+		{name: "Test_exceeded", subject: "alice", path: "/reports/3", wantCode: http.StatusTooManyRequests, wantRemaining: "0"},
+		{name: "Test_other_route", subject: "alice", path: "/orders", wantCode: http.StatusOK, wantRemaining: "1"},
+		{name: "Test_other_subject", subject: "bob", path: "/reports/1", wantCode: http.StatusOK, wantRemaining: "1"},
+		{name: "Test_anonymous", path: "/reports/1", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.subject, tt.path)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %v, want %v", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get(HeaderRemaining); got != tt.wantRemaining {
+				t.Errorf("%s = %q, want %q", HeaderRemaining, got, tt.wantRemaining)
+			}
+			if tt.wantRemaining != "" && (w.Header().Get(HeaderLimit) != "2" || w.Header().Get(HeaderReset) == "") {
+				t.Errorf("quota headers = %v, want the daily quota", w.Header())
+			}
+			if tt.wantCode == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Errorf("Retry-After is missing")
+			}
+		})
+	}
+}
+
+func TestQuota_LimitsFunc(t *testing.T) {
+	q := NewQuota(nil)
+	q.KeyFunc = ByAPIKey("X-API-Key")
+	q.LimitsFunc = func(r *http.Request, identity *turboAuth.Identity) []Limit {
+		if r.Header.Get("X-API-Key") == "premium" {
+			return []Limit{{Period: Monthly, Requests: 3}}
+		}
+		return []Limit{{Period: Monthly, Requests: 1}}
+	}
+	tests := []struct {
+		name         string
+		apiKey       string
+		requests     int
+		want         int64
+		wantExceeded bool
+	}{
+		{name: "Test_free", apiKey: "free", requests: 2, want: 2, wantExceeded: true},
+		{name: "Test_premium", apiKey: "premium", requests: 2, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var usages []Usage
+			for i := 0; i < tt.requests; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set("X-API-Key", tt.apiKey)
+				var err error
+				if usages, err = q.Count(r, nil, time.Now()); err != nil {
+					t.Fatalf("Count() error = %v", err)
+				}
+			}
+			if len(usages) != 1 || usages[0].Used != tt.want {
+				t.Fatalf("Count() = %+v, want %d requests used", usages, tt.want)
+			}
+			if usages[0].Exceeded() != tt.wantExceeded {
+				t.Errorf("Exceeded() = %v, want %v", usages[0].Exceeded(), tt.wantExceeded)
+			}
+		})
+	}
+}
+
+func TestPeriod_window(t *testing.T) {
+	paris := time.FixedZone("CEST", 2*60*60)
+	tests := []struct {
+		name      string
+		period    Period
+		now       time.Time
+		location  *time.Location
+		wantStart time.Time
+		wantReset time.Time
+	}{
+		{name: "Test_daily", period: Daily, now: time.Date(2024, 2, 29, 13, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), wantReset: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "Test_monthly", period: Monthly, now: time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC),
+			wantStart: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), wantReset: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "Test_location", period: Daily, now: time.Date(2024, 6, 1, 23, 30, 0, 0, time.UTC), location: paris,
+			wantStart: time.Date(2024, 6, 1, 22, 0, 0, 0, time.UTC), wantReset: time.Date(2024, 6, 2, 22, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, reset := tt.period.window(tt.now, tt.location)
+			if !start.Equal(tt.wantStart) || !reset.Equal(tt.wantReset) {
+				t.Errorf("window() = %v, %v, want %v, %v", start, reset, tt.wantStart, tt.wantReset)
+			}
+		})
+	}
+}
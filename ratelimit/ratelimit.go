@@ -0,0 +1,197 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/nandlabs/turbo-auth/clientip"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/logging"
+	"github.com/nandlabs/turbo-auth/resilience"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts = 5
+	DefaultWindow      = 15 * time.Minute
+	DefaultBaseLockout = 30 * time.Second
+	DefaultMaxLockout  = time.Hour
+
+	// IdentityKeyPrefix prefixes the keys of the identities (usernames ...), a successful attempt resets them. The
+	// other keys, e.g. the ip ones, are only cleared by their Window so that logging into an account of one's own
+	// does not lift the lockout of the address
+	IdentityKeyPrefix = "user:"
+	ipKeyPrefix       = "ip:"
+)
+
+type (
+	// Limiter locks out the keys (ip, username ...) which fail authentication too often, the lockout doubles
+	// for every failure past MaxAttempts up to MaxLockout
+	Limiter struct {
+		Store       CounterStore
+		MaxAttempts int64
+		// Window is the period the failures are counted over
+		Window      time.Duration
+		BaseLockout time.Duration
+		MaxLockout  time.Duration
+		// Failure tells whether the requests are let through while the Store is unavailable, resilience.FailClosed
+		// rejects them with a 503 and is the default
+		Failure     resilience.FailurePolicy
+		ErrorWriter turboError.ErrorWriter
+	}
+
+	// KeyFunc derives a rate limiting key from the request, an empty key is not limited
+	KeyFunc func(r *http.Request) string
+
+	statusRecorder struct {
+		http.ResponseWriter
+		statusCode int
+	}
+)
+
+var logger = logging.Get()
+
+func NewLimiter(store CounterStore) *Limiter {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &Limiter{
+		Store:       store,
+		MaxAttempts: DefaultMaxAttempts,
+		Window:      DefaultWindow,
+		BaseLockout: DefaultBaseLockout,
+		MaxLockout:  DefaultMaxLockout,
+	}
+}
+
+// Allowed reports whether the key is not locked out, along with the remaining lockout otherwise
+func (l *Limiter) Allowed(key string) (bool, time.Duration, error) {
+	return l.AllowedContext(context.Background(), key)
+}
+
+// AllowedContext is Allowed with the context passed to the Store, e.g. the one of the request
+func (l *Limiter) AllowedContext(ctx context.Context, key string) (bool, time.Duration, error) {
+	until, err := lockedUntil(ctx, l.Store, key)
+	if err != nil {
+		return false, 0, err
+	}
+	if remaining := time.Until(until); remaining > 0 {
+		return false, remaining, nil
+	}
+	return true, 0, nil
+}
+
+// Fail records a failed attempt and locks the key out once MaxAttempts is exceeded
+func (l *Limiter) Fail(key string) error {
+	return l.FailContext(context.Background(), key)
+}
+
+func (l *Limiter) FailContext(ctx context.Context, key string) error {
+	count, err := increment(ctx, l.Store, key, l.Window)
+	if err != nil {
+		return err
+	}
+	if count < l.MaxAttempts {
+		return nil
+	}
+	lockout := time.Duration(float64(l.BaseLockout) * math.Pow(2, float64(count-l.MaxAttempts)))
+	if lockout > l.MaxLockout || lockout <= 0 {
+		lockout = l.MaxLockout
+	}
+	logger.WarnF("locking out %s for %s after %d failed attempts", key, lockout, count)
+	return lock(ctx, l.Store, key, time.Now().Add(lockout))
+}
+
+// Succeed clears the failures of the key
+func (l *Limiter) Succeed(key string) error {
+	return l.SucceedContext(context.Background(), key)
+}
+
+func (l *Limiter) SucceedContext(ctx context.Context, key string) error {
+	return reset(ctx, l.Store, key)
+}
+
+// Protect guards a login or refresh endpoint: locked out keys get a 429 and the 401/403 responses of the
+// endpoint are counted as failures against every key, the successful responses reset the identity keys only
+func (l *Limiter) Protect(keyFuncs ...KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var keys []string
+			for _, keyFunc := range keyFuncs {
+				if key := keyFunc(r); key != "" {
+					keys = append(keys, key)
+				}
+			}
+			for _, key := range keys {
+				allowed, retryAfter, err := l.AllowedContext(r.Context(), key)
+				if err != nil {
+					logger.ErrorF("rate limit store error: %v", err)
+					if l.Failure == resilience.FailOpen {
+						continue
+					}
+					turboError.WriteError(l.ErrorWriter, w, r, &turboError.HttpError{
+						StatusCode: http.StatusServiceUnavailable,
+						Message:    "Error : rate limit unavailable, try again later \n",
+						Err:        err,
+					})
+					return
+				}
+				if !allowed {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+					turboError.WriteError(l.ErrorWriter, w, r, &turboError.HttpError{
+						StatusCode: http.StatusTooManyRequests,
+						Message:    "Error : too many failed attempts, try again later \n",
+					})
+					return
+				}
+			}
+
+			rec := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			for _, key := range keys {
+				var err error
+				switch {
+				case rec.statusCode == http.StatusUnauthorized || rec.statusCode == http.StatusForbidden:
+					err = l.FailContext(r.Context(), key)
+				case rec.statusCode < 300 && strings.HasPrefix(key, IdentityKeyPrefix):
+					err = l.SucceedContext(r.Context(), key)
+				}
+				if err != nil {
+					logger.ErrorF("rate limit store error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// ByIP keys the attempts by the address of the client, see clientip.FromRequest
+func ByIP(r *http.Request) string {
+	return ipKeyPrefix + clientip.FromRequest(r)
+}
+
+// ByBasicAuthUser keys the attempts by the username of the basic auth header
+func ByBasicAuthUser(r *http.Request) string {
+	if username, _, ok := r.BasicAuth(); ok && username != "" {
+		return IdentityKeyPrefix + username
+	}
+	return ""
+}
+
+// ByFormValue keys the attempts by a form field such as the username of a login form, note that the
+// body is parsed into r.Form
+func ByFormValue(field string) KeyFunc {
+	return func(r *http.Request) string {
+		if value := r.FormValue(field); value != "" {
+			return IdentityKeyPrefix + value
+		}
+		return ""
+	}
+}
+
+func (rec *statusRecorder) WriteHeader(statusCode int) {
+	rec.statusCode = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
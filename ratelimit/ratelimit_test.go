@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/nandlabs/turbo-auth/resilience"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Protect(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore())
+	limiter.MaxAttempts = 2
+	limiter.BaseLockout = time.Minute
+
+	password := "wrong"
+	handler := limiter.Protect(ByIP, ByBasicAuthUser)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, p, _ := r.BasicAuth(); p != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	do := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.SetBasicAuth("test_user", password)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	want := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}
+	for i, code := range want {
+		if got := do(); got != code {
+			t.Errorf("attempt %v status = %v, want %v", i, got, code)
+		}
+	}
+	// the correct password is refused as well while locked out
+	password = "password"
+	if got := do(); got != http.StatusTooManyRequests {
+		t.Errorf("locked out status = %v, want %v", got, http.StatusTooManyRequests)
+	}
+}
+
+func TestLimiter_Protect_success(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore())
+	limiter.MaxAttempts = 3
+	limiter.BaseLockout = time.Minute
+	handler := limiter.Protect(ByIP, ByBasicAuthUser)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, p, _ := r.BasicAuth(); p != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	do := func(username, password string) int {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.SetBasicAuth(username, password)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// the guesses against the victim are interleaved with logins into an account of the attacker
+	attempts := []struct {
+		username string
+		password string
+		want     int
+	}{
+		{username: "victim", password: "guess-1", want: http.StatusUnauthorized},
+		{username: "attacker", password: "password", want: http.StatusOK},
+		{username: "victim", password: "guess-2", want: http.StatusUnauthorized},
+		{username: "attacker", password: "password", want: http.StatusOK},
+		{username: "victim", password: "guess-3", want: http.StatusUnauthorized},
+		{username: "attacker", password: "password", want: http.StatusTooManyRequests},
+	}
+	for i, attempt := range attempts {
+		if got := do(attempt.username, attempt.password); got != attempt.want {
+			t.Errorf("attempt %v status = %v, want %v", i, got, attempt.want)
+		}
+	}
+	if count, _ := limiter.Store.Increment(IdentityKeyPrefix+"attacker", time.Minute); count != 1 {
+		t.Errorf("failures of the attacker account = %v, want them reset by the successful logins", count-1)
+	}
+}
+
+type unavailableStore struct{}
+
+var errUnavailable = errors.New("store unavailable")
+
+func (unavailableStore) Increment(string, time.Duration) (int64, error) { return 0, errUnavailable }
+func (unavailableStore) Reset(string) error                             { return errUnavailable }
+func (unavailableStore) Lock(string, time.Time) error                   { return errUnavailable }
+func (unavailableStore) LockedUntil(string) (time.Time, error)          { return time.Time{}, errUnavailable }
+
+func TestLimiter_Protect_unavailable(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure resilience.FailurePolicy
+		want    int
+	}{
+		{name: "Test_fail_closed", failure: resilience.FailClosed, want: http.StatusServiceUnavailable},
+		{name: "Test_fail_open", failure: resilience.FailOpen, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewLimiter(unavailableStore{})
+			limiter.Failure = tt.failure
+			handler := limiter.Protect(ByIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+// contextStore records the contexts the Limiter passes to the store
+type contextStore struct {
+	*MemoryStore
+	contexts []context.Context
+}
+
+func (s *contextStore) IncrementContext(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.contexts = append(s.contexts, ctx)
+	return s.Increment(key, ttl)
+}
+
+func (s *contextStore) ResetContext(ctx context.Context, key string) error {
+	s.contexts = append(s.contexts, ctx)
+	return s.Reset(key)
+}
+
+func (s *contextStore) LockContext(ctx context.Context, key string, until time.Time) error {
+	s.contexts = append(s.contexts, ctx)
+	return s.Lock(key, until)
+}
+
+func (s *contextStore) LockedUntilContext(ctx context.Context, key string) (time.Time, error) {
+	s.contexts = append(s.contexts, ctx)
+	return s.LockedUntil(key)
+}
+
+func TestLimiter_Protect_context(t *testing.T) {
+	store := &contextStore{MemoryStore: NewMemoryStore()}
+	handler := NewLimiter(store).Protect(ByBasicAuthUser)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	type key struct{}
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+	r = r.WithContext(context.WithValue(r.Context(), key{}, "request"))
+	r.SetBasicAuth("test_user", "wrong")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if len(store.contexts) != 2 {
+		t.Fatalf("store calls = %v, want the lockout lookup and the failure", len(store.contexts))
+	}
+	for _, ctx := range store.contexts {
+		if ctx.Value(key{}) != "request" {
+			t.Errorf("store called without the context of the request")
+		}
+	}
+}
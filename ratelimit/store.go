@@ -0,0 +1,199 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// CounterStore keeps the failed attempts and the lockouts, shared between instances for multi-instance deployments
+	CounterStore interface {
+		// Increment records a failure for the key and returns the failures recorded within the ttl
+		Increment(key string, ttl time.Duration) (int64, error)
+		// Reset clears the failures and the lockout of the key
+		Reset(key string) error
+		Lock(key string, until time.Time) error
+		// LockedUntil returns the zero time when the key is not locked
+		LockedUntil(key string) (time.Time, error)
+	}
+
+	// ContextCounterStore is implemented by the CounterStores backed by a network store, the context of the request
+	// is passed to them so that its deadline and cancellation apply
+	ContextCounterStore interface {
+		IncrementContext(ctx context.Context, key string, ttl time.Duration) (int64, error)
+		ResetContext(ctx context.Context, key string) error
+		LockContext(ctx context.Context, key string, until time.Time) error
+		LockedUntilContext(ctx context.Context, key string) (time.Time, error)
+	}
+
+	// MemoryStore is an in-process CounterStore
+	MemoryStore struct {
+		mutex    sync.Mutex
+		counters map[string]*counter
+	}
+
+	counter struct {
+		count       int64
+		expiresAt   time.Time
+		lockedUntil time.Time
+	}
+
+	// RedisStore shares the counters between instances using INCR and key expiry
+	RedisStore struct {
+		Client    redis.UniversalClient
+		KeyPrefix string
+	}
+)
+
+const DefaultRedisKeyPrefix = "turbo-auth:ratelimit:"
+
+// incrementScript sets the expiry along with the first failure, a counter left without one would lock the key out
+// for good
+var incrementScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count`)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: make(map[string]*counter),
+	}
+}
+
+func (m *MemoryStore) Increment(key string, ttl time.Duration) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	m.purge(now)
+	c, ok := m.counters[key]
+	if !ok {
+		c = &counter{}
+		m.counters[key] = c
+	}
+	if now.After(c.expiresAt) {
+		c.count = 0
+		c.expiresAt = now.Add(ttl)
+	}
+	c.count++
+	return c.count, nil
+}
+
+func (m *MemoryStore) Reset(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.counters, key)
+	return nil
+}
+
+func (m *MemoryStore) Lock(key string, until time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	c, ok := m.counters[key]
+	if !ok {
+		c = &counter{expiresAt: until}
+		m.counters[key] = c
+	}
+	c.lockedUntil = until
+	return nil
+}
+
+func (m *MemoryStore) LockedUntil(key string) (time.Time, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if c, ok := m.counters[key]; ok {
+		return c.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+// purge drops the counters which are neither counting nor locked, caller must hold the lock
+func (m *MemoryStore) purge(now time.Time) {
+	for key, c := range m.counters {
+		if now.After(c.expiresAt) && now.After(c.lockedUntil) {
+			delete(m.counters, key)
+		}
+	}
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{
+		Client:    client,
+		KeyPrefix: DefaultRedisKeyPrefix,
+	}
+}
+
+func (s *RedisStore) Increment(key string, ttl time.Duration) (int64, error) {
+	return s.IncrementContext(context.Background(), key, ttl)
+}
+
+func (s *RedisStore) IncrementContext(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return incrementScript.Run(ctx, s.Client, []string{s.KeyPrefix + key}, ttl.Milliseconds()).Int64()
+}
+
+func (s *RedisStore) Reset(key string) error {
+	return s.ResetContext(context.Background(), key)
+}
+
+func (s *RedisStore) ResetContext(ctx context.Context, key string) error {
+	return s.Client.Del(ctx, s.KeyPrefix+key, s.KeyPrefix+key+":lock").Err()
+}
+
+func (s *RedisStore) Lock(key string, until time.Time) error {
+	return s.LockContext(context.Background(), key, until)
+}
+
+func (s *RedisStore) LockContext(ctx context.Context, key string, until time.Time) error {
+	return s.Client.Set(ctx, s.KeyPrefix+key+":lock", until.Unix(), time.Until(until)).Err()
+}
+
+func (s *RedisStore) LockedUntil(key string) (time.Time, error) {
+	return s.LockedUntilContext(context.Background(), key)
+}
+
+func (s *RedisStore) LockedUntilContext(ctx context.Context, key string) (time.Time, error) {
+	value, err := s.Client.Get(ctx, s.KeyPrefix+key+":lock").Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// increment and the following helpers call the Context variant of the method when the store implements it
+func increment(ctx context.Context, store CounterStore, key string, ttl time.Duration) (int64, error) {
+	if contextStore, ok := store.(ContextCounterStore); ok {
+		return contextStore.IncrementContext(ctx, key, ttl)
+	}
+	return store.Increment(key, ttl)
+}
+
+func reset(ctx context.Context, store CounterStore, key string) error {
+	if contextStore, ok := store.(ContextCounterStore); ok {
+		return contextStore.ResetContext(ctx, key)
+	}
+	return store.Reset(key)
+}
+
+func lock(ctx context.Context, store CounterStore, key string, until time.Time) error {
+	if contextStore, ok := store.(ContextCounterStore); ok {
+		return contextStore.LockContext(ctx, key, until)
+	}
+	return store.Lock(key, until)
+}
+
+func lockedUntil(ctx context.Context, store CounterStore, key string) (time.Time, error) {
+	if contextStore, ok := store.(ContextCounterStore); ok {
+		return contextStore.LockedUntilContext(ctx, key)
+	}
+	return store.LockedUntil(key)
+}
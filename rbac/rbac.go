@@ -0,0 +1,175 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// Hierarchy resolves the roles implied by the roles and the groups of an identity, e.g. with admin inheriting
+	// from editor and editor from viewer an admin is also an editor and a viewer. The groups are nodes of the same
+	// graph, a group inherits from its parent groups and from the roles granted to its members. Safe for concurrent
+	// use
+	Hierarchy struct {
+		mutex    sync.RWMutex
+		inherits map[string][]string
+		// expanded caches the transitive closure of every name, cleared when the graph changes
+		expanded map[string][]string
+	}
+)
+
+// ErrCycle is returned when an inheritance would make a role or a group inherit from itself
+var ErrCycle = errors.New("role inheritance cycle")
+
+func NewHierarchy() *Hierarchy {
+	return &Hierarchy{
+		inherits: make(map[string][]string),
+		expanded: make(map[string][]string),
+	}
+}
+
+// Inherit makes the role or the group inherit from the parents, nothing is changed and ErrCycle is returned when
+// one of the parents already inherits from it
+func (h *Hierarchy) Inherit(name string, parents ...string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.inherits == nil {
+		h.inherits = make(map[string][]string)
+	}
+	for _, parent := range parents {
+		if path := h.path(parent, name, map[string]bool{}); path != nil {
+			return fmt.Errorf("%w: %s -> %s", ErrCycle, name, strings.Join(path, " -> "))
+		}
+	}
+	h.inherits[name] = append(h.inherits[name], parents...)
+	h.expanded = make(map[string][]string)
+	return nil
+}
+
+// path returns the inheritance path from the name to the target, nil when the name does not inherit from it
+func (h *Hierarchy) path(name string, target string, visited map[string]bool) []string {
+	if name == target {
+		return []string{name}
+	}
+	if visited[name] {
+		return nil
+	}
+	visited[name] = true
+	for _, parent := range h.inherits[name] {
+		if path := h.path(parent, target, visited); path != nil {
+			return append([]string{name}, path...)
+		}
+	}
+	return nil
+}
+
+// Expand returns the names and every role and group they inherit from, transitively, sorted and without duplicates
+func (h *Hierarchy) Expand(names ...string) []string {
+	set := make(map[string]bool)
+	for _, name := range names {
+		set[name] = true
+		for _, inherited := range h.closure(name) {
+			set[inherited] = true
+		}
+	}
+	expanded := make([]string, 0, len(set))
+	for name := range set {
+		expanded = append(expanded, name)
+	}
+	sort.Strings(expanded)
+	return expanded
+}
+
+// Implies reports whether holding the role or the group grants the role
+func (h *Hierarchy) Implies(name string, role string) bool {
+	if name == role {
+		return true
+	}
+	for _, inherited := range h.closure(name) {
+		if inherited == role {
+			return true
+		}
+	}
+	return false
+}
+
+// closure returns the names inherited by the name, from the cache when it was resolved since the last change
+func (h *Hierarchy) closure(name string) []string {
+	h.mutex.RLock()
+	closure, ok := h.expanded[name]
+	h.mutex.RUnlock()
+	if ok {
+		return closure
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	// Inherit rejects the cycles, the visited set only avoids walking the shared ancestors twice
+	visited := map[string]bool{name: true}
+	pending := append([]string(nil), h.inherits[name]...)
+	closure = []string{}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		if visited[current] {
+			continue
+		}
+		visited[current] = true
+		closure = append(closure, current)
+		pending = append(pending, h.inherits[current]...)
+	}
+	if h.expanded == nil {
+		h.expanded = make(map[string][]string)
+	}
+	h.expanded[name] = closure
+	return closure
+}
+
+// GroupPrefix namespaces the groups in the hierarchy so that an IdP group is never taken for a role of the same
+// name, e.g. the engineering group is declared with Inherit("group:engineering", "editor")
+const GroupPrefix = "group:"
+
+// ClaimsMapper expands the roles of the identity, and the groups listed in the groupsClaim of its claims when not
+// empty, into every role they inherit from so that the RequireRole checks see the inherited roles, e.g.
+// jwt.WithClaimsMapper(hierarchy.ClaimsMapper("groups")). Only the groups declared in the hierarchy under the
+// GroupPrefix are mapped, they are kept in that namespace and the other groups are ignored
+func (h *Hierarchy) ClaimsMapper(groupsClaim string) turboAuth.ClaimsMapper {
+	return func(_ context.Context, identity *turboAuth.Identity) (*turboAuth.Identity, error) {
+		names := append([]string(nil), identity.Roles...)
+		if groupsClaim != "" {
+			var groups []string
+			switch claim := identity.Claims[groupsClaim].(type) {
+			case []string:
+				groups = claim
+			case []interface{}:
+				for _, group := range claim {
+					if name, ok := group.(string); ok {
+						groups = append(groups, name)
+					}
+				}
+			case string:
+				groups = strings.Fields(claim)
+			}
+			names = append(names, h.declaredGroups(groups)...)
+		}
+		identity.Roles = h.Expand(names...)
+		return identity, nil
+	}
+}
+
+// declaredGroups returns the namespaced names of the groups declared in the hierarchy
+func (h *Hierarchy) declaredGroups(groups []string) []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	var names []string
+	for _, group := range groups {
+		if _, ok := h.inherits[GroupPrefix+group]; ok {
+			names = append(names, GroupPrefix+group)
+		}
+	}
+	return names
+}
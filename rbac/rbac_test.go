@@ -0,0 +1,110 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"reflect"
+	"testing"
+)
+
+func newHierarchy(t *testing.T) *Hierarchy {
+	h := NewHierarchy()
+	for name, parents := range map[string][]string{
+		"admin":             {"editor", "auditor"},
+		"editor":            {"viewer"},
+		"auditor":           {"viewer"},
+		"engineering":       {"staff", "editor"},
+		"staff":             {"viewer"},
+		"group:engineering": {"editor"},
+		"group:support":     {"viewer"},
+	} {
+		if err := h.Inherit(name, parents...); err != nil {
+			t.Fatalf("Inherit(%v) error = %v", name, err)
+		}
+	}
+	return h
+}
+
+func TestHierarchy_Expand(t *testing.T) {
+	h := newHierarchy(t)
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{name: "Test_transitive", names: []string{"admin"}, want: []string{"admin", "auditor", "editor", "viewer"}},
+		{name: "Test_group", names: []string{"engineering"}, want: []string{"editor", "engineering", "staff", "viewer"}},
+		{name: "Test_leaf", names: []string{"viewer"}, want: []string{"viewer"}},
+		{name: "Test_unknown", names: []string{"guest"}, want: []string{"guest"}},
+		{name: "Test_several", names: []string{"auditor", "staff"}, want: []string{"auditor", "staff", "viewer"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.Expand(tt.names...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expand() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHierarchy_Inherit(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    string
+		parents []string
+		wantErr error
+	}{
+		{name: "Test_self", role: "viewer", parents: []string{"viewer"}, wantErr: ErrCycle},
+		{name: "Test_direct_cycle", role: "viewer", parents: []string{"editor"}, wantErr: ErrCycle},
+		{name: "Test_transitive_cycle", role: "viewer", parents: []string{"engineering"}, wantErr: ErrCycle},
+		{name: "Test_diamond", role: "engineering", parents: []string{"auditor"}},
+		{name: "Test_new_role", role: "owner", parents: []string{"admin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHierarchy(t)
+			h.Expand("viewer")
+			if err := h.Inherit(tt.role, tt.parents...); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Inherit() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && !reflect.DeepEqual(h.Expand("viewer"), []string{"viewer"}) {
+				t.Errorf("the rejected inheritance changed the hierarchy")
+			}
+			if tt.wantErr == nil && !h.Implies(tt.role, tt.parents[0]) {
+				t.Errorf("Implies(%v, %v) = false after the inheritance", tt.role, tt.parents[0])
+			}
+		})
+	}
+}
+
+func TestHierarchy_ClaimsMapper(t *testing.T) {
+	h := newHierarchy(t)
+	tests := []struct {
+		name   string
+		roles  []string
+		groups interface{}
+		want   []string
+	}{
+		{name: "Test_roles", roles: []string{"editor"}, want: []string{"editor", "viewer"}},
+		{name: "Test_groups_list", groups: []interface{}{"engineering"}, want: []string{"editor", "group:engineering", "viewer"}},
+		{name: "Test_groups_string", roles: []string{"auditor"}, groups: "support", want: []string{"auditor", "group:support", "viewer"}},
+		{name: "Test_group_named_as_role", groups: []string{"admin", "staff"}, want: []string{}},
+		{name: "Test_no_groups", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := &turboAuth.Identity{Subject: "alice", Roles: tt.roles, Claims: map[string]interface{}{}}
+			if tt.groups != nil {
+				identity.Claims["groups"] = tt.groups
+			}
+			mapped, err := h.ClaimsMapper("groups")(context.Background(), identity)
+			if err != nil {
+				t.Fatalf("ClaimsMapper() error = %v", err)
+			}
+			if !reflect.DeepEqual(mapped.Roles, tt.want) {
+				t.Errorf("Roles = %v, want %v", mapped.Roles, tt.want)
+			}
+		})
+	}
+}
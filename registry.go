@@ -0,0 +1,127 @@
+package turbo_auth
+
+import (
+	"fmt"
+	"github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Registry holds the authenticators by name, they can be swapped at runtime (e.g. after a config reload)
+	// without recreating the middlewares built from Authenticator. Lookups are lock free, the map is copied on write
+	Registry struct {
+		mutex          sync.Mutex
+		authenticators atomic.Value
+	}
+
+	// registration is an authenticator as registered, replacing it creates a new registration
+	registration struct {
+		authenticator Authenticator
+	}
+
+	// registeredAuthenticator resolves the authenticator from the registry on every request
+	registeredAuthenticator struct {
+		registry *Registry
+		name     string
+	}
+
+	// registeredHandler is the handler of a route applied with a registeredAuthenticator, the chain of the current
+	// registration is built once and rebuilt only when the authenticator is replaced
+	registeredHandler struct {
+		registered *registeredAuthenticator
+		handler    http.Handler
+		chain      atomic.Value
+	}
+
+	chain struct {
+		registration *registration
+		handler      http.Handler
+	}
+)
+
+func NewRegistry() *Registry {
+	registry := &Registry{}
+	registry.authenticators.Store(map[string]*registration{})
+	return registry
+}
+
+// Register adds the authenticator, replacing the one registered with the same name
+func (registry *Registry) Register(name string, authenticator Authenticator) {
+	registry.update(func(authenticators map[string]*registration) {
+		authenticators[name] = &registration{authenticator: authenticator}
+	})
+}
+
+// Remove drops the authenticator, requests going through it are then rejected
+func (registry *Registry) Remove(name string) {
+	registry.update(func(authenticators map[string]*registration) {
+		delete(authenticators, name)
+	})
+}
+
+// Get returns the authenticator currently registered with the name
+func (registry *Registry) Get(name string) (Authenticator, bool) {
+	registered, ok := registry.load()[name]
+	if !ok {
+		return nil, false
+	}
+	return registered.authenticator, true
+}
+
+// Names returns the sorted names of the registered authenticators
+func (registry *Registry) Names() []string {
+	authenticators := registry.load()
+	names := make([]string, 0, len(authenticators))
+	for name := range authenticators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Authenticator returns an Authenticator delegating to the one registered with the name at request time, the
+// request is rejected with 503 while no authenticator is registered
+func (registry *Registry) Authenticator(name string) Authenticator {
+	return &registeredAuthenticator{registry: registry, name: name}
+}
+
+func (registry *Registry) load() map[string]*registration {
+	return registry.authenticators.Load().(map[string]*registration)
+}
+
+func (registry *Registry) update(fn func(authenticators map[string]*registration)) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	current := registry.load()
+	authenticators := make(map[string]*registration, len(current)+1)
+	for name, registered := range current {
+		authenticators[name] = registered
+	}
+	fn(authenticators)
+	registry.authenticators.Store(authenticators)
+}
+
+func (registered *registeredAuthenticator) Apply(handler http.Handler) http.Handler {
+	return &registeredHandler{registered: registered, handler: handler}
+}
+
+func (h *registeredHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	current, ok := h.registered.registry.load()[h.registered.name]
+	if !ok {
+		httpError := &errors.HttpError{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    fmt.Sprintf("Error : authenticator %s is not registered \n", h.registered.name),
+		}
+		httpError.GenerateError(w, r)
+		return
+	}
+	built, _ := h.chain.Load().(*chain)
+	if built == nil || built.registration != current {
+		built = &chain{registration: current, handler: current.authenticator.Apply(h.handler)}
+		h.chain.Store(built)
+	}
+	built.handler.ServeHTTP(w, r)
+}
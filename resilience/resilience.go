@@ -0,0 +1,268 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/logging"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type (
+	// Policy guards the calls to a remote dependency of the validation (revocation store, introspection endpoint,
+	// JWKS ...), each attempt is bounded by the Timeout, the transient failures are retried with a jittered backoff
+	// and the Breaker stops calling a dependency which keeps failing. A nil Policy calls the dependency once
+	Policy struct {
+		// Timeout bounds each attempt, the deadline of the caller still applies, none when 0
+		Timeout time.Duration
+		// Retries is the number of attempts after the first one
+		Retries int
+		// Backoff is the delay before the first retry, doubled at each retry up to MaxBackoff, the actual delay is
+		// drawn between 0 and the backoff (full jitter) so that the instances do not retry in lockstep
+		Backoff    time.Duration
+		MaxBackoff time.Duration
+		// Breaker is shared by the calls to the same dependency, disabled when nil
+		Breaker *Breaker
+		// Failure tells the callers what to do once the dependency is unavailable, see Tolerate
+		Failure FailurePolicy
+		// Retryable reports whether the error is transient, DefaultRetryable when nil
+		Retryable func(err error) bool
+	}
+
+	// Breaker opens after Threshold consecutive transient failures, the calls are then rejected with ErrBreakerOpen
+	// until the Cooldown has elapsed. A single trial call is let through afterwards (half-open), its success closes
+	// the breaker and its failure opens it again
+	Breaker struct {
+		Threshold int
+		Cooldown  time.Duration
+
+		mutex    sync.Mutex
+		state    State
+		failures int
+		openedAt time.Time
+	}
+
+	State int
+
+	// FailurePolicy tells whether a check may be skipped when its dependency is unavailable
+	FailurePolicy int
+)
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+const (
+	// FailClosed rejects the requests while the dependency is unavailable, it is the default
+	FailClosed FailurePolicy = iota
+	// FailOpen skips the check while the dependency is unavailable. It only applies to the checks restricting an
+	// otherwise valid token, e.g. the revocation lookups, the authentication itself always fails closed
+	FailOpen
+)
+
+const (
+	DefaultThreshold  = 5
+	DefaultCooldown   = 30 * time.Second
+	DefaultBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff = 2 * time.Second
+)
+
+var (
+	ErrBreakerOpen = errors.New("circuit breaker is open")
+
+	logger = logging.Get()
+)
+
+// NewPolicy bounds the attempts by the timeout and retries the transient failures, a Breaker with the default
+// threshold and cooldown is attached
+func NewPolicy(timeout time.Duration, retries int) *Policy {
+	return &Policy{
+		Timeout:    timeout,
+		Retries:    retries,
+		Backoff:    DefaultBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		Breaker:    NewBreaker(DefaultThreshold, DefaultCooldown),
+	}
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// DefaultRetryable considers all the errors transient but the rejections of the token and the end of the context
+// of the caller
+func DefaultRetryable(err error) bool {
+	return !errors.Is(err, turboError.ErrTokenInvalid) && !errors.Is(err, context.Canceled) &&
+		!errors.Is(err, ErrBreakerOpen)
+}
+
+// Do calls fn until it succeeds, fails with an error which is not retryable or the retries are exhausted, the
+// last error is returned
+func (p *Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p == nil {
+		return fn(ctx)
+	}
+	backoff := p.Backoff
+	var err error
+	for attempt := 0; attempt <= p.Retries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, jitter(backoff)); err != nil {
+				return err
+			}
+			if backoff *= 2; p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+				backoff = p.MaxBackoff
+			}
+		}
+		if err = p.Breaker.Allow(); err != nil {
+			return err
+		}
+		err = p.attempt(ctx, fn)
+		if ctx.Err() != nil {
+			// the caller gave up, the call says nothing about the dependency
+			p.Breaker.abort()
+			return err
+		}
+		transient := err != nil && p.retryable(err)
+		p.Breaker.Record(transient)
+		if !transient {
+			return err
+		}
+		logger.DebugF("attempt %d failed: %v", attempt+1, err)
+	}
+	return err
+}
+
+// Tolerate reports whether the caller may proceed without the result of the dependency, i.e. the policy fails
+// open and err is a failure of the dependency rather than a rejection
+func (p *Policy) Tolerate(err error) bool {
+	return p != nil && p.Failure == FailOpen && err != nil && !errors.Is(err, turboError.ErrTokenInvalid)
+}
+
+func (p *Policy) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p.Timeout <= 0 {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+func (p *Policy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return DefaultRetryable(err)
+}
+
+// Allow returns ErrBreakerOpen while the breaker is open or its trial call is in flight, a nil Breaker allows all
+// the calls
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.cooldown() {
+			return ErrBreakerOpen
+		}
+		b.state = StateHalfOpen
+		return nil
+	case StateHalfOpen:
+		return ErrBreakerOpen
+	default:
+		return nil
+	}
+}
+
+// Record closes the breaker after a success, counts the failure otherwise
+func (b *Breaker) Record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !failed {
+		if b.state != StateClosed {
+			logger.InfoF("circuit breaker closed")
+		}
+		b.state, b.failures = StateClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.threshold() {
+		if b.state != StateOpen {
+			logger.WarnF("circuit breaker opened after %d consecutive failures", b.failures)
+		}
+		b.state, b.openedAt = StateOpen, time.Now()
+	}
+}
+
+// abort lets the next call through as a new trial when the aborted call was the trial
+func (b *Breaker) abort() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == StateHalfOpen {
+		b.state = StateOpen
+	}
+}
+
+func (b *Breaker) State() State {
+	if b == nil {
+		return StateClosed
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+func (b *Breaker) threshold() int {
+	if b.Threshold <= 0 {
+		return DefaultThreshold
+	}
+	return b.Threshold
+}
+
+func (b *Breaker) cooldown() time.Duration {
+	if b.Cooldown <= 0 {
+		return DefaultCooldown
+	}
+	return b.Cooldown
+}
+
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+func jitter(backoff time.Duration) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
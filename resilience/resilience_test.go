@@ -0,0 +1,102 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"testing"
+	"time"
+)
+
+func TestPolicy_Do(t *testing.T) {
+	errUnavailable := errors.New("unavailable")
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		retries   int
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "Test_success", wantCalls: 1},
+		{name: "Test_retried", failures: 2, err: errUnavailable, retries: 2, wantCalls: 3},
+		{name: "Test_retries_exhausted", failures: 5, err: errUnavailable, retries: 2, wantCalls: 3, wantErr: errUnavailable},
+		{name: "Test_rejection_not_retried", failures: 5, err: turboError.ErrTokenInvalid, retries: 2, wantCalls: 1,
+			wantErr: turboError.ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &Policy{Retries: tt.retries, Backoff: time.Millisecond}
+			calls := 0
+			err := policy.Do(context.Background(), func(ctx context.Context) error {
+				if calls++; calls <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			if calls != tt.wantCalls || !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("Do() = %v after %d calls, want %v after %d calls", err, calls, tt.wantErr, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPolicy_Timeout(t *testing.T) {
+	policy := &Policy{Timeout: 10 * time.Millisecond, Retries: 1}
+	calls := 0
+	err := policy.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if calls != 2 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do() = %v after %d calls, want %v after 2 calls", err, calls, context.DeadlineExceeded)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	breaker := NewBreaker(2, 20*time.Millisecond)
+	policy := &Policy{Breaker: breaker}
+	failing := func(ctx context.Context) error { return errors.New("unavailable") }
+	succeeding := func(ctx context.Context) error { return nil }
+
+	_ = policy.Do(context.Background(), failing)
+	if breaker.State() != StateClosed {
+		t.Errorf("State() after 1 failure = %v, want %v", breaker.State(), StateClosed)
+	}
+	_ = policy.Do(context.Background(), failing)
+	if breaker.State() != StateOpen {
+		t.Errorf("State() after 2 failures = %v, want %v", breaker.State(), StateOpen)
+	}
+	if err := policy.Do(context.Background(), succeeding); err != ErrBreakerOpen {
+		t.Errorf("Do() while open = %v, want %v", err, ErrBreakerOpen)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := policy.Do(context.Background(), succeeding); err != nil {
+		t.Errorf("Do() after the cooldown = %v, want the trial call", err)
+	}
+	if breaker.State() != StateClosed {
+		t.Errorf("State() after the trial = %v, want %v", breaker.State(), StateClosed)
+	}
+}
+
+func TestPolicy_Tolerate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *Policy
+		err    error
+		want   bool
+	}{
+		{name: "Test_nil_policy", err: ErrBreakerOpen, want: false},
+		{name: "Test_fail_closed", policy: &Policy{}, err: ErrBreakerOpen, want: false},
+		{name: "Test_fail_open", policy: &Policy{Failure: FailOpen}, err: ErrBreakerOpen, want: true},
+		{name: "Test_fail_open_rejection", policy: &Policy{Failure: FailOpen}, err: turboError.ErrTokenInvalid, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Tolerate(tt.err); got != tt.want {
+				t.Errorf("Tolerate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package securecookie
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Key signs the values with HMAC-SHA256 of the HashKey and, when the BlockKey is set, encrypts them with
+	// AES-GCM. The BlockKey is 16, 24 or 32 bytes long, the HashKey should be at least 32 random bytes
+	Key struct {
+		HashKey  []byte
+		BlockKey []byte
+	}
+
+	// Codec encodes the values of the cookies so that the client cannot forge or alter them, and cannot read them
+	// when encrypted. The first of the Keys encodes, all of them decode so that the keys can be rotated: the new key
+	// is prepended and the old one dropped once the cookies it encoded have expired
+	Codec struct {
+		Keys []Key
+		// MaxAge rejects the values encoded earlier, regardless of the expiry of the cookie, unbounded when 0
+		MaxAge time.Duration
+	}
+)
+
+var (
+	ErrNoKeys       = errors.New("no keys configured")
+	ErrInvalidValue = errors.New("the value is not valid")
+	ErrExpiredValue = errors.New("the value has expired")
+)
+
+// NewCodec validates the keys, the first one encodes the values
+func NewCodec(keys ...Key) (*Codec, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	for i, key := range keys {
+		if len(key.HashKey) == 0 {
+			return nil, fmt.Errorf("key %d: the hash key is required", i)
+		}
+		if _, err := key.aead(); err != nil {
+			return nil, fmt.Errorf("key %d: %v", i, err)
+		}
+	}
+	return &Codec{Keys: keys}, nil
+}
+
+// Encode signs, and encrypts when the key has a BlockKey, the value of the cookie of the name. The name is part of
+// the signature, a value cannot be moved to another cookie
+func (c *Codec) Encode(name string, value []byte) (string, error) {
+	if len(c.Keys) == 0 {
+		return "", ErrNoKeys
+	}
+	aead, err := c.Keys[0].aead()
+	if err != nil {
+		return "", err
+	}
+	if aead != nil {
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return "", err
+		}
+		value = aead.Seal(nonce, nonce, value, []byte(name))
+	}
+	message := base64.RawURLEncoding.EncodeToString(value) + "." + strconv.FormatInt(time.Now().Unix(), 10)
+	return message + "." + sign(c.Keys[0].HashKey, name, message), nil
+}
+
+// Decode verifies the value encoded by Encode for the cookie of the name with each of the Keys
+func (c *Codec) Decode(name string, encoded string) ([]byte, error) {
+	separator := strings.LastIndexByte(encoded, '.')
+	if separator < 0 {
+		return nil, ErrInvalidValue
+	}
+	message, signature := encoded[:separator], encoded[separator+1:]
+	for _, key := range c.Keys {
+		if !hmac.Equal([]byte(signature), []byte(sign(key.HashKey, name, message))) {
+			continue
+		}
+		parts := strings.SplitN(message, ".", 2)
+		if len(parts) != 2 {
+			return nil, ErrInvalidValue
+		}
+		timestamp, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, ErrInvalidValue
+		}
+		if c.MaxAge > 0 && time.Since(time.Unix(timestamp, 0)) > c.MaxAge {
+			return nil, ErrExpiredValue
+		}
+		value, err := base64.RawURLEncoding.DecodeString(parts[0])
+		if err != nil {
+			return nil, ErrInvalidValue
+		}
+		aead, err := key.aead()
+		if err != nil {
+			return nil, err
+		}
+		if aead != nil {
+			if len(value) < aead.NonceSize() {
+				return nil, ErrInvalidValue
+			}
+			nonce := value[:aead.NonceSize()]
+			if value, err = aead.Open(nil, nonce, value[aead.NonceSize():], []byte(name)); err != nil {
+				return nil, ErrInvalidValue
+			}
+		}
+		return value, nil
+	}
+	return nil, ErrInvalidValue
+}
+
+// SetCookie encodes the value of the cookie before setting it, the empty values of the cookies being deleted are
+// left as is
+func (c *Codec) SetCookie(w http.ResponseWriter, cookie *http.Cookie) error {
+	if cookie.Value != "" {
+		value, err := c.Encode(cookie.Name, []byte(cookie.Value))
+		if err != nil {
+			return err
+		}
+		copied := *cookie
+		copied.Value, cookie = value, &copied
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// Cookie returns the decoded value of the cookie of the request, http.ErrNoCookie when it is absent
+func (c *Codec) Cookie(r *http.Request, name string) (string, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	value, err := c.Decode(name, cookie.Value)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// aead returns nil when the values are only signed
+func (k Key) aead() (cipher.AEAD, error) {
+	if len(k.BlockKey) == 0 {
+		return nil, nil
+	}
+	block, err := aes.NewCipher(k.BlockKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sign(key []byte, name string, message string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "|" + message))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
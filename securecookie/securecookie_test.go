@@ -0,0 +1,112 @@
+package securecookie
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCodec(t *testing.T) {
+	signing := Key{HashKey: []byte("01234567890123456789012345678901")}
+	encrypting := Key{HashKey: []byte("hash_key_of_the_encrypting_codec"), BlockKey: []byte("0123456789abcdef")}
+	signed, err := NewCodec(signing)
+	if err != nil {
+		t.Fatalf("NewCodec() error = %v", err)
+	}
+	encrypted, err := NewCodec(encrypting)
+	if err != nil {
+		t.Fatalf("NewCodec() error = %v", err)
+	}
+	rotated, err := NewCodec(Key{HashKey: []byte("the_new_hash_key_of_the_rotation")}, encrypting)
+	if err != nil {
+		t.Fatalf("NewCodec() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		encode  *Codec
+		decode  *Codec
+		cookie  string
+		tamper  func(encoded string) string
+		wantErr error
+	}{
+		{name: "Test_signed", encode: signed, decode: signed, cookie: "session"},
+		{name: "Test_encrypted", encode: encrypted, decode: encrypted, cookie: "session"},
+		{name: "Test_rotated_key", encode: encrypted, decode: rotated, cookie: "session"},
+		{name: "Test_removed_key", encode: rotated, decode: encrypted, cookie: "session", wantErr: ErrInvalidValue},
+		{name: "Test_tampered", encode: signed, decode: signed, cookie: "session", tamper: func(encoded string) string {
+			return "YWRtaW4" + encoded[strings.IndexByte(encoded, '.'):]
+		}, wantErr: ErrInvalidValue},
+		{name: "Test_other_cookie", encode: signed, decode: signed, cookie: "csrf", wantErr: ErrInvalidValue},
+		{name: "Test_expired", encode: signed, decode: &Codec{Keys: []Key{signing}, MaxAge: time.Minute},
+			cookie: "session", tamper: func(encoded string) string {
+				parts := strings.Split(encoded, ".")
+				message := parts[0] + ".1000"
+				return message + "." + sign(signing.HashKey, "session", message)
+			}, wantErr: ErrExpiredValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.encode.Encode(tt.cookie, []byte("test_user"))
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if tt.tamper != nil {
+				encoded = tt.tamper(encoded)
+			}
+			got, err := tt.decode.Decode("session", encoded)
+			if err != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != "test_user" {
+				t.Errorf("Decode() = %q, want test_user", got)
+			}
+		})
+	}
+}
+
+func TestCodec_Cookie(t *testing.T) {
+	codec, err := NewCodec(Key{HashKey: []byte("01234567890123456789012345678901"), BlockKey: []byte("0123456789abcdef")})
+	if err != nil {
+		t.Fatalf("NewCodec() error = %v", err)
+	}
+	w := httptest.NewRecorder()
+	if err := codec.SetCookie(w, &http.Cookie{Name: "session", Value: "secret_id"}); err != nil {
+		t.Fatalf("SetCookie() error = %v", err)
+	}
+	cookie := w.Result().Cookies()[0]
+	if strings.Contains(cookie.Value, "secret_id") {
+		t.Errorf("SetCookie() value = %q, want it encrypted", cookie.Value)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	if got, err := codec.Cookie(r, "session"); err != nil || got != "secret_id" {
+		t.Errorf("Cookie() = %q, %v, want secret_id", got, err)
+	}
+	if _, err := codec.Cookie(r, "missing"); err != http.ErrNoCookie {
+		t.Errorf("Cookie() error = %v, want %v", err, http.ErrNoCookie)
+	}
+}
+
+func TestNewCodec(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []Key
+		wantErr bool
+	}{
+		{name: "Test_no_keys", wantErr: true},
+		{name: "Test_missing_hash_key", keys: []Key{{BlockKey: []byte("0123456789abcdef")}}, wantErr: true},
+		{name: "Test_invalid_block_key", keys: []Key{{HashKey: []byte("hash"), BlockKey: []byte("short")}},
+			wantErr: true},
+		{name: "Test_valid", keys: []Key{{HashKey: []byte("hash"), BlockKey: []byte("0123456789abcdef")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCodec(tt.keys...); (err != nil) != tt.wantErr {
+				t.Errorf("NewCodec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package sessions
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"net/http"
+	"time"
+)
+
+type (
+	// SessionInfo describes a session to its subject, the session id is a credential and is replaced by a Handle
+	SessionInfo struct {
+		Handle         string    `json:"handle"`
+		Current        bool      `json:"current"`
+		CreatedAt      time.Time `json:"createdAt"`
+		LastAccessedAt time.Time `json:"lastAccessedAt"`
+		ExpiresAt      time.Time `json:"expiresAt"`
+	}
+
+	sessionsResponse struct {
+		Sessions []SessionInfo `json:"sessions"`
+	}
+)
+
+// Handle is the public reference of the session, derived from its id so that the id never leaves the cookie
+func Handle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// Handler lets the users manage their own sessions, e.g. from a "signed in devices" page, it is placed after Apply:
+//
+//	GET    lists the sessions of the identity as SessionInfo
+//	DELETE revokes the session of the handle query parameter, or all the other sessions when it is absent
+func (m *SessionManager) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := turboAuth.IdentityFromContext(r.Context())
+		if !ok {
+			m.writeError(w, r, http.StatusUnauthorized, ErrSessionNotFound.Error())
+			return
+		}
+		sessions, err := m.SessionsContext(r.Context(), identity.Subject)
+		if err != nil {
+			m.writeError(w, r, http.StatusNotImplemented, err.Error())
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			response := &sessionsResponse{Sessions: make([]SessionInfo, 0, len(sessions))}
+			for _, session := range sessions {
+				response.Sessions = append(response.Sessions, SessionInfo{
+					Handle:         Handle(session.ID),
+					Current:        session.ID == identity.TokenID,
+					CreatedAt:      session.CreatedAt,
+					LastAccessedAt: session.LastAccessedAt,
+					ExpiresAt:      session.ExpiresAt,
+				})
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			_ = json.NewEncoder(w).Encode(response)
+		case http.MethodDelete:
+			handle := r.URL.Query().Get("handle")
+			revoked := false
+			for _, session := range sessions {
+				if handle == "" && session.ID == identity.TokenID || handle != "" && Handle(session.ID) != handle {
+					continue
+				}
+				if err := m.delete(r.Context(), session.ID); err != nil {
+					m.writeError(w, r, http.StatusInternalServerError, err.Error())
+					return
+				}
+				revoked = true
+			}
+			if handle != "" && !revoked {
+				m.writeError(w, r, http.StatusNotFound, ErrSessionNotFound.Error())
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			m.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
+
+func (m *SessionManager) writeError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	turboError.WriteError(m.ErrorWriter, w, r, &turboError.HttpError{
+		StatusCode: statusCode,
+		Message:    "Error : " + message + " \n",
+	})
+}
@@ -0,0 +1,317 @@
+package sessions
+
+import (
+	"context"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/logging"
+	"github.com/nandlabs/turbo-auth/securecookie"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultSessionCookieName = "SessionID"
+	DefaultIdleTimeout       = 30 * time.Minute
+	DefaultAbsoluteTimeout   = 12 * time.Hour
+	DefaultTouchInterval     = time.Minute
+)
+
+// SessionManager creates and validates server side sessions, it implements the Authenticator interface
+// so it can be used in place of the jwt provider
+type SessionManager struct {
+	Store      SessionStore
+	CookieName string
+	// IdleTimeout expires the sessions which are not used for the duration
+	IdleTimeout time.Duration
+	// TouchInterval is the activity Apply lets go unrecorded, the session is saved once it was last accessed that
+	// long ago rather than on every request. DefaultTouchInterval, or a tenth of the IdleTimeout when shorter, when 0
+	// and every request when negative
+	TouchInterval time.Duration
+	// AbsoluteTimeout expires the sessions after the duration regardless of activity
+	AbsoluteTimeout time.Duration
+	// MaxSessions bounds the concurrent sessions of a subject, e.g. 5 devices, the oldest sessions are evicted when
+	// a new one is created past the limit. It is unlimited when 0, the Store must be a SubjectLister otherwise
+	MaxSessions int
+	// Insecure allows the cookie to be sent over plain http, for local development only
+	Insecure bool
+	SameSite http.SameSite
+	// Cookies signs, and optionally encrypts, the session cookie, the id is sent as is when nil
+	Cookies     *securecookie.Codec
+	ErrorWriter turboError.ErrorWriter
+}
+
+var logger = logging.Get()
+
+func NewSessionManager(store SessionStore) *SessionManager {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &SessionManager{
+		Store:           store,
+		CookieName:      DefaultSessionCookieName,
+		IdleTimeout:     DefaultIdleTimeout,
+		AbsoluteTimeout: DefaultAbsoluteTimeout,
+		SameSite:        http.SameSiteLaxMode,
+	}
+}
+
+// Create starts a new session for the subject and sets the session cookie
+func (m *SessionManager) Create(w http.ResponseWriter, subject string, values map[string]interface{}) (*Session, error) {
+	return m.CreateContext(context.Background(), w, subject, values)
+}
+
+// CreateContext is Create with the context passed to the Store, e.g. the one of the login request
+func (m *SessionManager) CreateContext(ctx context.Context, w http.ResponseWriter, subject string, values map[string]interface{}) (*Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &Session{
+		ID:             id,
+		Subject:        subject,
+		Values:         values,
+		CreatedAt:      now,
+		LastAccessedAt: now,
+		ExpiresAt:      now.Add(m.AbsoluteTimeout),
+	}
+	if err := m.save(ctx, session, m.ttl(session, now)); err != nil {
+		return nil, err
+	}
+	if err := m.evict(ctx, session); err != nil {
+		logger.ErrorF("unable to enforce the session limit of %s: %v", subject, err)
+	}
+	m.setCookie(w, session.ID, session.ExpiresAt)
+	return session, nil
+}
+
+// Sessions returns the live sessions of the subject, the oldest first
+func (m *SessionManager) Sessions(subject string) ([]*Session, error) {
+	return m.SessionsContext(context.Background(), subject)
+}
+
+func (m *SessionManager) SessionsContext(ctx context.Context, subject string) ([]*Session, error) {
+	var sessions []*Session
+	var err error
+	if lister, ok := m.Store.(ContextSubjectLister); ok {
+		sessions, err = lister.ListSubjectContext(ctx, subject)
+	} else if lister, ok := m.Store.(SubjectLister); ok {
+		sessions, err = lister.ListSubject(subject)
+	} else {
+		return nil, ErrListingUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// Revoke deletes the session of the subject, ErrSessionNotFound is returned when the session belongs to another
+// subject
+func (m *SessionManager) Revoke(subject string, id string) error {
+	return m.RevokeContext(context.Background(), subject, id)
+}
+
+func (m *SessionManager) RevokeContext(ctx context.Context, subject string, id string) error {
+	session, err := m.load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if session.Subject != subject {
+		return ErrSessionNotFound
+	}
+	return m.delete(ctx, id)
+}
+
+// RevokeAll deletes the sessions of the subject, e.g. on a password change
+func (m *SessionManager) RevokeAll(subject string) error {
+	return m.RevokeAllContext(context.Background(), subject)
+}
+
+func (m *SessionManager) RevokeAllContext(ctx context.Context, subject string) error {
+	sessions, err := m.SessionsContext(ctx, subject)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := m.delete(ctx, session.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evict deletes the oldest sessions of the subject of the new session past MaxSessions
+func (m *SessionManager) evict(ctx context.Context, created *Session) error {
+	if m.MaxSessions <= 0 {
+		return nil
+	}
+	sessions, err := m.SessionsContext(ctx, created.Subject)
+	if err != nil {
+		return err
+	}
+	excess := len(sessions) - m.MaxSessions
+	for _, session := range sessions {
+		if excess <= 0 {
+			break
+		}
+		if session.ID == created.ID {
+			continue
+		}
+		logger.InfoF("evicting the session created at %s of %s, limit of %d sessions reached",
+			session.CreatedAt.Format(time.RFC3339), created.Subject, m.MaxSessions)
+		if err := m.delete(ctx, session.ID); err != nil {
+			return err
+		}
+		excess--
+	}
+	return nil
+}
+
+// Get loads the session of the request and enforces the idle and absolute timeouts
+func (m *SessionManager) Get(r *http.Request) (*Session, error) {
+	id := m.sessionID(r)
+	if id == "" {
+		return nil, ErrSessionNotFound
+	}
+	session, err := m.load(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if now.After(session.ExpiresAt) || (m.IdleTimeout > 0 && now.Sub(session.LastAccessedAt) > m.IdleTimeout) {
+		if err := m.delete(r.Context(), session.ID); err != nil {
+			logger.ErrorF("unable to delete expired session: %v", err)
+		}
+		return nil, ErrSessionExpired
+	}
+	return session, nil
+}
+
+// Touch records the activity on the session, extending the idle timeout
+func (m *SessionManager) Touch(session *Session) error {
+	return m.TouchContext(context.Background(), session)
+}
+
+func (m *SessionManager) TouchContext(ctx context.Context, session *Session) error {
+	now := time.Now()
+	session.LastAccessedAt = now
+	return m.save(ctx, session, m.ttl(session, now))
+}
+
+// Destroy deletes the session of the request and expires the cookie
+func (m *SessionManager) Destroy(w http.ResponseWriter, r *http.Request) error {
+	m.setCookie(w, "", time.Unix(0, 0))
+	id := m.sessionID(r)
+	if id == "" {
+		return nil
+	}
+	return m.delete(r.Context(), id)
+}
+
+// Apply validates the session of the request and injects its Identity in the context
+func (m *SessionManager) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := m.Get(r)
+		if err != nil {
+			m.setCookie(w, "", time.Unix(0, 0))
+			turboError.WriteError(m.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Error : " + err.Error() + " \n",
+			})
+			return
+		}
+		if time.Since(session.LastAccessedAt) >= m.touchInterval() {
+			if err := m.TouchContext(r.Context(), session); err != nil {
+				logger.ErrorF("unable to touch session: %v", err)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(turboAuth.NewContext(r.Context(), Identity(session))))
+	})
+}
+
+// Identity maps the session to the identity of the request
+func Identity(session *Session) *turboAuth.Identity {
+	return &turboAuth.Identity{
+		Subject: session.Subject,
+		TokenID: session.ID,
+		Claims:  session.Values,
+	}
+}
+
+// save and the following helpers call the Context variant of the method when the Store implements it
+func (m *SessionManager) save(ctx context.Context, session *Session, ttl time.Duration) error {
+	if store, ok := m.Store.(ContextSessionStore); ok {
+		return store.SaveContext(ctx, session, ttl)
+	}
+	return m.Store.Save(session, ttl)
+}
+
+func (m *SessionManager) load(ctx context.Context, id string) (*Session, error) {
+	if store, ok := m.Store.(ContextSessionStore); ok {
+		return store.LoadContext(ctx, id)
+	}
+	return m.Store.Load(id)
+}
+
+func (m *SessionManager) delete(ctx context.Context, id string) error {
+	if store, ok := m.Store.(ContextSessionStore); ok {
+		return store.DeleteContext(ctx, id)
+	}
+	return m.Store.Delete(id)
+}
+
+func (m *SessionManager) ttl(session *Session, now time.Time) time.Duration {
+	ttl := session.ExpiresAt.Sub(now)
+	if m.IdleTimeout > 0 && m.IdleTimeout < ttl {
+		ttl = m.IdleTimeout
+	}
+	return ttl
+}
+
+func (m *SessionManager) touchInterval() time.Duration {
+	if m.TouchInterval != 0 {
+		return m.TouchInterval
+	}
+	if m.IdleTimeout > 0 && m.IdleTimeout/10 < DefaultTouchInterval {
+		return m.IdleTimeout / 10
+	}
+	return DefaultTouchInterval
+}
+
+// sessionID returns the id of the session cookie, empty when it is absent or was not encoded by the Cookies codec
+func (m *SessionManager) sessionID(r *http.Request) string {
+	if m.Cookies != nil {
+		id, _ := m.Cookies.Cookie(r, m.CookieName)
+		return id
+	}
+	cookie, err := r.Cookie(m.CookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func (m *SessionManager) setCookie(w http.ResponseWriter, value string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     m.CookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   !m.Insecure,
+		SameSite: m.SameSite,
+	}
+	if m.Cookies == nil {
+		http.SetCookie(w, cookie)
+		return
+	}
+	if err := m.Cookies.SetCookie(w, cookie); err != nil {
+		logger.ErrorF("unable to encode the session cookie: %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package sessions
+
+import (
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/securecookie"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionManager_Apply(t *testing.T) {
+	manager := NewSessionManager(NewMemoryStore())
+	w := httptest.NewRecorder()
+	session, err := manager.Create(w, "test_user", map[string]interface{}{"tenant": "acme"})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	tests := []struct {
+		name    string
+		prepare func()
+		want    int
+	}{
+		{name: "Test_active_session", prepare: func() {}, want: http.StatusOK},
+		{
+			name: "Test_idle_timeout",
+			prepare: func() {
+				session.LastAccessedAt = time.Now().Add(-2 * manager.IdleTimeout)
+				_ = manager.Store.Save(session, time.Hour)
+			},
+			want: http.StatusUnauthorized,
+		},
+		{name: "Test_expired_session_deleted", prepare: func() {}, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.prepare()
+			var identity *turboAuth.Identity
+			handler := manager.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				identity, _ = turboAuth.IdentityFromContext(r.Context())
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("Apply() status = %v, want %v", w.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && (identity == nil || identity.Subject != "test_user") {
+				t.Errorf("Apply() identity = %+v", identity)
+			}
+		})
+	}
+}
+
+func TestSessionManager_MaxSessions(t *testing.T) {
+	manager := NewSessionManager(NewMemoryStore())
+	manager.MaxSessions = 2
+	var created []*Session
+	for i := 0; i < 3; i++ {
+		session, err := manager.Create(httptest.NewRecorder(), "test_user", nil)
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		// distinct creation times so that the oldest is known
+		session.CreatedAt = session.CreatedAt.Add(time.Duration(i-3) * time.Minute)
+		_ = manager.Store.Save(session, time.Hour)
+		created = append(created, session)
+	}
+	if _, err := manager.Create(httptest.NewRecorder(), "other_user", nil); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	sessions, err := manager.Sessions("test_user")
+	if err != nil {
+		t.Fatalf("Sessions() error = %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != created[1].ID || sessions[1].ID != created[2].ID {
+		t.Errorf("Sessions() = %+v, want the 2 newest sessions", sessions)
+	}
+	if err := manager.Revoke("other_user", created[1].ID); err != ErrSessionNotFound {
+		t.Errorf("Revoke() of another subject error = %v, want %v", err, ErrSessionNotFound)
+	}
+	if err := manager.RevokeAll("test_user"); err != nil {
+		t.Fatalf("RevokeAll() error = %v", err)
+	}
+	if sessions, _ := manager.Sessions("test_user"); len(sessions) != 0 {
+		t.Errorf("Sessions() after RevokeAll = %+v", sessions)
+	}
+	if sessions, _ := manager.Sessions("other_user"); len(sessions) != 1 {
+		t.Errorf("Sessions() of another subject = %+v", sessions)
+	}
+}
+
+func TestSessionManager_Handler(t *testing.T) {
+	manager := NewSessionManager(NewMemoryStore())
+	w := httptest.NewRecorder()
+	current, err := manager.Create(w, "test_user", nil)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	cookie := w.Result().Cookies()[0]
+	other, err := manager.Create(httptest.NewRecorder(), "test_user", nil)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	handler := manager.Apply(manager.Handler())
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		want     int
+		wantBody string
+	}{
+		{name: "Test_list", method: http.MethodGet, target: "/", want: http.StatusOK, wantBody: `"handle":"` + Handle(other.ID) + `","current":false`},
+		{name: "Test_unknown_handle", method: http.MethodDelete, target: "/?handle=unknown", want: http.StatusNotFound},
+		{name: "Test_revoke_handle", method: http.MethodDelete, target: "/?handle=" + Handle(other.ID), want: http.StatusNoContent},
+		{name: "Test_list_current", method: http.MethodGet, target: "/", want: http.StatusOK, wantBody: `{"sessions":[{"handle":"` + Handle(current.ID) + `","current":true`},
+		{name: "Test_post", method: http.MethodPost, target: "/", want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			r.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("status = %v, body = %v", w.Code, w.Body.String())
+			}
+			if strings.Contains(w.Body.String(), current.ID) {
+				t.Errorf("body leaks the session id: %v", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSessionManager_Cookies(t *testing.T) {
+	codec, err := securecookie.NewCodec(securecookie.Key{HashKey: []byte("01234567890123456789012345678901")})
+	if err != nil {
+		t.Fatalf("NewCodec() error = %v", err)
+	}
+	manager := NewSessionManager(NewMemoryStore())
+	manager.Cookies = codec
+	w := httptest.NewRecorder()
+	session, err := manager.Create(w, "test_user", nil)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	signed := w.Result().Cookies()[0]
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		want   int
+	}{
+		{name: "Test_signed_cookie", cookie: signed, want: http.StatusOK},
+		{name: "Test_raw_session_id", cookie: &http.Cookie{Name: manager.CookieName, Value: session.ID},
+			want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(tt.cookie)
+			w := httptest.NewRecorder()
+			manager.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("Apply() status = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+// countingStore counts the saves of the sessions
+type countingStore struct {
+	*MemoryStore
+	saves int
+}
+
+func (s *countingStore) Save(session *Session, ttl time.Duration) error {
+	s.saves++
+	return s.MemoryStore.Save(session, ttl)
+}
+
+func TestSessionManager_TouchInterval(t *testing.T) {
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	manager := NewSessionManager(store)
+	w := httptest.NewRecorder()
+	session, err := manager.Create(w, "test_user", nil)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	cookie := w.Result().Cookies()[0]
+	apply := func() {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		manager.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Apply() status = %v, want %v", w.Code, http.StatusOK)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		apply()
+	}
+	if store.saves != 1 {
+		t.Errorf("saves = %v, want the creation only within the TouchInterval", store.saves)
+	}
+	session.LastAccessedAt = time.Now().Add(-2 * DefaultTouchInterval)
+	_ = store.MemoryStore.Save(session, time.Hour)
+	apply()
+	if store.saves != 2 {
+		t.Errorf("saves = %v, want the session touched past the TouchInterval", store.saves)
+	}
+}
@@ -0,0 +1,81 @@
+package sessions
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// MemoryStore keeps the sessions in process, suitable for single instance deployments and tests
+	MemoryStore struct {
+		mutex    sync.RWMutex
+		sessions map[string]memoryEntry
+		// purgedAt is the last purge of the expired sessions, they are purged at most once per purgeInterval
+		purgedAt time.Time
+	}
+
+	memoryEntry struct {
+		session   Session
+		expiresAt time.Time
+	}
+)
+
+// purgeInterval spaces the scans of the sessions for the expired ones, the expired sessions are not served meanwhile
+const purgeInterval = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]memoryEntry),
+	}
+}
+
+func (m *MemoryStore) Save(session *Session, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(m.purgedAt) >= purgeInterval {
+		for id, entry := range m.sessions {
+			if now.After(entry.expiresAt) {
+				delete(m.sessions, id)
+			}
+		}
+		m.purgedAt = now
+	}
+	m.sessions[session.ID] = memoryEntry{
+		session:   *session,
+		expiresAt: now.Add(ttl),
+	}
+	return nil
+}
+
+func (m *MemoryStore) Load(id string) (*Session, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	entry, ok := m.sessions[id]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, ErrSessionNotFound
+	}
+	session := entry.session
+	return &session, nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemoryStore) ListSubject(subject string) ([]*Session, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	now := time.Now()
+	var sessions []*Session
+	for _, entry := range m.sessions {
+		if entry.session.Subject == subject && !now.After(entry.expiresAt) {
+			session := entry.session
+			sessions = append(sessions, &session)
+		}
+	}
+	return sessions, nil
+}
@@ -0,0 +1,111 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+const DefaultRedisKeyPrefix = "turbo-auth:session:"
+
+// RedisStore shares the sessions across instances, the ttl is delegated to redis key expiry. The ids of the sessions
+// of a subject are indexed in a sorted set scored by their absolute expiry, the index expires with the last session
+type RedisStore struct {
+	Client    redis.UniversalClient
+	KeyPrefix string
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{
+		Client:    client,
+		KeyPrefix: DefaultRedisKeyPrefix,
+	}
+}
+
+func (s *RedisStore) Save(session *Session, ttl time.Duration) error {
+	return s.SaveContext(context.Background(), session, ttl)
+}
+
+func (s *RedisStore) SaveContext(ctx context.Context, session *Session, ttl time.Duration) error {
+	value, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	key := s.subjectKey(session.Subject)
+	pipe := s.Client.TxPipeline()
+	pipe.Set(ctx, s.KeyPrefix+session.ID, value, ttl)
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(session.ExpiresAt.Unix()), Member: session.ID})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(time.Now().Unix(), 10))
+	last := pipe.ZRevRangeWithScores(ctx, key, 0, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if newest := last.Val(); len(newest) > 0 {
+		return s.Client.ExpireAt(ctx, key, time.Unix(int64(newest[0].Score), 0)).Err()
+	}
+	return nil
+}
+
+func (s *RedisStore) Load(id string) (*Session, error) {
+	return s.LoadContext(context.Background(), id)
+}
+
+func (s *RedisStore) LoadContext(ctx context.Context, id string) (*Session, error) {
+	value, err := s.Client.Get(ctx, s.KeyPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var session Session
+	if err := json.Unmarshal(value, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *RedisStore) Delete(id string) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *RedisStore) DeleteContext(ctx context.Context, id string) error {
+	return s.Client.Del(ctx, s.KeyPrefix+id).Err()
+}
+
+func (s *RedisStore) ListSubject(subject string) ([]*Session, error) {
+	return s.ListSubjectContext(context.Background(), subject)
+}
+
+func (s *RedisStore) ListSubjectContext(ctx context.Context, subject string) ([]*Session, error) {
+	ids, err := s.Client.ZRangeByScore(ctx, s.subjectKey(subject), &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	var sessions []*Session
+	for _, id := range ids {
+		session, err := s.LoadContext(ctx, id)
+		// e.g. deleted or dropped by the idle timeout
+		if err == ErrSessionNotFound {
+			s.Client.ZRem(ctx, s.subjectKey(subject), id)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (s *RedisStore) subjectKey(subject string) string {
+	return s.KeyPrefix + "subject:" + subject
+}
+
+// Check pings the redis server, see health.Checker
+func (s *RedisStore) Check(ctx context.Context) error {
+	return s.Client.Ping(ctx).Err()
+}
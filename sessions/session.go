@@ -0,0 +1,66 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+type (
+	// Session is the server side state of an authenticated client, referenced by the id sent in the cookie
+	Session struct {
+		ID             string                 `json:"id"`
+		Subject        string                 `json:"subject"`
+		Values         map[string]interface{} `json:"values,omitempty"`
+		CreatedAt      time.Time              `json:"createdAt"`
+		LastAccessedAt time.Time              `json:"lastAccessedAt"`
+		// ExpiresAt is the absolute expiry, the session cannot be extended past it
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+
+	// SessionStore persists the sessions, ttl is the duration after which the store may drop the session
+	SessionStore interface {
+		Save(session *Session, ttl time.Duration) error
+		// Load returns ErrSessionNotFound when the session does not exist or has been dropped
+		Load(id string) (*Session, error)
+		Delete(id string) error
+	}
+
+	// SubjectLister is implemented by the stores which index the sessions by subject, it is required by the session
+	// limits and the listing of the sessions of a subject
+	SubjectLister interface {
+		// ListSubject returns the live sessions of the subject
+		ListSubject(subject string) ([]*Session, error)
+	}
+
+	// ContextSessionStore is implemented by the stores backed by a network store, the context of the request is
+	// passed to them so that its deadline and cancellation apply
+	ContextSessionStore interface {
+		SaveContext(ctx context.Context, session *Session, ttl time.Duration) error
+		LoadContext(ctx context.Context, id string) (*Session, error)
+		DeleteContext(ctx context.Context, id string) error
+	}
+
+	// ContextSubjectLister is the SubjectLister counterpart of the ContextSessionStore
+	ContextSubjectLister interface {
+		ListSubjectContext(ctx context.Context, subject string) ([]*Session, error)
+	}
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionExpired  = errors.New("session has expired")
+	// ErrListingUnsupported is returned by the session listing when the store is not a SubjectLister
+	ErrListingUnsupported = errors.New("session store cannot list the sessions of a subject")
+)
+
+// newSessionID returns 256 bits of randomness encoded for use in a cookie
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,49 @@
+package sql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect adapts the queries of the stores to the database, the queries are written with ? placeholders
+type Dialect struct {
+	Name string
+	// Placeholder returns the bind parameter n, starting at 1
+	Placeholder func(n int) string
+}
+
+var (
+	// Postgres suits lib/pq and pgx (through its database/sql driver)
+	Postgres = &Dialect{
+		Name:        "postgres",
+		Placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+	}
+
+	MySQL = &Dialect{
+		Name:        "mysql",
+		Placeholder: func(int) string { return "?" },
+	}
+
+	SQLite = &Dialect{
+		Name:        "sqlite",
+		Placeholder: func(int) string { return "?" },
+	}
+)
+
+// rebind replaces the ? placeholders of the query with the ones of the dialect
+func (d *Dialect) rebind(query string) string {
+	if d == nil || d.Placeholder == nil {
+		return query
+	}
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			builder.WriteString(d.Placeholder(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
@@ -0,0 +1,108 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+)
+
+const migrationsTable = "turbo_auth_schema_migrations"
+
+// migrations are applied in order and never modified once released, append a new version to change the schema.
+// The column types are common to postgres, mysql and sqlite, the times are stored as unix nanoseconds
+var migrations = []struct {
+	version    int
+	statements []string
+}{
+	{
+		version: 1,
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS turbo_auth_users (
+				username VARCHAR(255) NOT NULL PRIMARY KEY,
+				password_hash VARCHAR(255) NOT NULL,
+				disabled BOOLEAN NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS turbo_auth_user_roles (
+				username VARCHAR(255) NOT NULL,
+				role VARCHAR(255) NOT NULL,
+				PRIMARY KEY (username, role)
+			)`,
+			`CREATE TABLE IF NOT EXISTS turbo_auth_refresh_tokens (
+				jti VARCHAR(255) NOT NULL PRIMARY KEY,
+				subject VARCHAR(255) NOT NULL,
+				expires_at BIGINT NOT NULL,
+				consumed BOOLEAN NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS turbo_auth_revoked_tokens (
+				jti VARCHAR(255) NOT NULL PRIMARY KEY,
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS turbo_auth_revoked_subjects (
+				subject VARCHAR(255) NOT NULL PRIMARY KEY,
+				issued_before BIGINT NOT NULL
+			)`,
+		},
+	},
+	{
+		version: 2,
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS turbo_auth_token_versions (
+				subject VARCHAR(255) NOT NULL PRIMARY KEY,
+				version BIGINT NOT NULL
+			)`,
+		},
+	},
+	{
+		version: 3,
+		statements: []string{
+			`ALTER TABLE turbo_auth_refresh_tokens ADD COLUMN family VARCHAR(255) NOT NULL DEFAULT ''`,
+		},
+	},
+}
+
+// Migrate creates or upgrades the tables of the stores, the applied versions are recorded in
+// turbo_auth_schema_migrations so Migrate can run on every start
+func Migrate(db *dbsql.DB, dialect *Dialect) error {
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+migrationsTable+` (
+		version INTEGER NOT NULL PRIMARY KEY
+	)`); err != nil {
+		return err
+	}
+	current, err := schemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if migration.version <= current {
+			continue
+		}
+		err := inTx(db, func(tx *dbsql.Tx) error {
+			for _, statement := range migration.statements {
+				if _, err := tx.ExecContext(ctx, statement); err != nil {
+					return err
+				}
+			}
+			query := dialect.rebind(`INSERT INTO ` + migrationsTable + ` (version) VALUES (?)`)
+			_, err := tx.ExecContext(ctx, query, migration.version)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the latest applied migration, 0 when none
+func SchemaVersion(db *dbsql.DB) (int, error) {
+	return schemaVersion(context.Background(), db)
+}
+
+func schemaVersion(ctx context.Context, db *dbsql.DB) (int, error) {
+	var version dbsql.NullInt64
+	err := db.QueryRowContext(ctx, `SELECT MAX(version) FROM `+migrationsTable).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
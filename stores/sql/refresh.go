@@ -0,0 +1,107 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"time"
+)
+
+// RefreshTokenStore records the issued refresh tokens in turbo_auth_refresh_tokens so each of them is exchanged
+// only once, a second use of a consumed token reveals a leaked token
+type RefreshTokenStore struct {
+	DB      *dbsql.DB
+	Dialect *Dialect
+}
+
+var (
+	_ jwt.RefreshTokenStore        = (*RefreshTokenStore)(nil)
+	_ jwt.ContextRefreshTokenStore = (*RefreshTokenStore)(nil)
+
+	// ErrRefreshTokenUnknown is returned for the tokens never saved, expired or deleted
+	ErrRefreshTokenUnknown = jwt.ErrRefreshTokenUnknown
+	// ErrRefreshTokenReused is returned when a consumed token is presented again, the family of the token should
+	// then be revoked
+	ErrRefreshTokenReused = jwt.ErrRefreshTokenReused
+)
+
+func NewRefreshTokenStore(db *dbsql.DB, dialect *Dialect) *RefreshTokenStore {
+	return &RefreshTokenStore{DB: db, Dialect: dialect}
+}
+
+// Save records the jti of a newly issued refresh token
+func (s *RefreshTokenStore) Save(token *jwt.RefreshToken) error {
+	return s.SaveContext(context.Background(), token)
+}
+
+func (s *RefreshTokenStore) SaveContext(ctx context.Context, token *jwt.RefreshToken) error {
+	query := s.Dialect.rebind(`INSERT INTO turbo_auth_refresh_tokens (jti, family, subject, expires_at, consumed) VALUES (?, ?, ?, ?, ?)`)
+	_, err := s.DB.ExecContext(ctx, query, token.JTI, token.Family, token.Subject,
+		token.ExpiresAt.UnixNano(), false)
+	return err
+}
+
+// Consume marks the token as used and returns it, the update is atomic so concurrent refreshes with the same token
+// cannot both succeed
+func (s *RefreshTokenStore) Consume(jti string) (*jwt.RefreshToken, error) {
+	return s.ConsumeContext(context.Background(), jti)
+}
+
+func (s *RefreshTokenStore) ConsumeContext(ctx context.Context, jti string) (*jwt.RefreshToken, error) {
+	query := s.Dialect.rebind(`UPDATE turbo_auth_refresh_tokens SET consumed = ? WHERE jti = ? AND consumed = ? AND expires_at >= ?`)
+	result, err := s.DB.ExecContext(ctx, query, true, jti, false, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	token := &jwt.RefreshToken{JTI: jti}
+	var expiresAt int64
+	query = s.Dialect.rebind(`SELECT family, subject, expires_at, consumed FROM turbo_auth_refresh_tokens WHERE jti = ?`)
+	err = s.DB.QueryRowContext(ctx, query, jti).Scan(&token.Family, &token.Subject, &expiresAt, &token.Consumed)
+	if err == dbsql.ErrNoRows {
+		return nil, ErrRefreshTokenUnknown
+	} else if err != nil {
+		return nil, err
+	}
+	token.ExpiresAt = time.Unix(0, expiresAt)
+	if n == 0 {
+		if token.Consumed {
+			return token, ErrRefreshTokenReused
+		}
+		return nil, ErrRefreshTokenUnknown
+	}
+	return token, nil
+}
+
+// RevokeFamily deletes the refresh tokens rotated from the same login
+func (s *RefreshTokenStore) RevokeFamily(family string) error {
+	return s.RevokeFamilyContext(context.Background(), family)
+}
+
+func (s *RefreshTokenStore) RevokeFamilyContext(ctx context.Context, family string) error {
+	query := s.Dialect.rebind(`DELETE FROM turbo_auth_refresh_tokens WHERE family = ?`)
+	_, err := s.DB.ExecContext(ctx, query, family)
+	return err
+}
+
+// DeleteSubject deletes the refresh tokens of the subject, e.g. on logout from all the devices
+func (s *RefreshTokenStore) DeleteSubject(subject string) error {
+	query := s.Dialect.rebind(`DELETE FROM turbo_auth_refresh_tokens WHERE subject = ?`)
+	_, err := s.DB.ExecContext(context.Background(), query, subject)
+	return err
+}
+
+// Purge deletes the expired tokens, call it periodically
+func (s *RefreshTokenStore) Purge() error {
+	query := s.Dialect.rebind(`DELETE FROM turbo_auth_refresh_tokens WHERE expires_at < ?`)
+	_, err := s.DB.ExecContext(context.Background(), query, time.Now().UnixNano())
+	return err
+}
+
+// Check pings the database, see health.Checker
+func (s *RefreshTokenStore) Check(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
@@ -0,0 +1,174 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"time"
+)
+
+// Revoker shares the revoked token ids, subjects and token versions across instances through the
+// turbo_auth_revoked_tokens, turbo_auth_revoked_subjects and turbo_auth_token_versions tables
+type Revoker struct {
+	DB      *dbsql.DB
+	Dialect *Dialect
+}
+
+var (
+	_ jwt.Revoker          = (*Revoker)(nil)
+	_ jwt.SubjectRevoker   = (*Revoker)(nil)
+	_ jwt.TokenVersioner   = (*Revoker)(nil)
+	_ jwt.RevocationLister = (*Revoker)(nil)
+
+	_ jwt.ContextRevoker        = (*Revoker)(nil)
+	_ jwt.ContextSubjectRevoker = (*Revoker)(nil)
+	_ jwt.ContextTokenVersioner = (*Revoker)(nil)
+)
+
+func NewRevoker(db *dbsql.DB, dialect *Dialect) *Revoker {
+	return &Revoker{DB: db, Dialect: dialect}
+}
+
+// Revoke keeps the jti until expiresAt, forever when expiresAt is zero. The expired entries are purged on the way
+func (r *Revoker) Revoke(jti string, expiresAt time.Time) error {
+	return r.RevokeContext(context.Background(), jti, expiresAt)
+}
+
+func (r *Revoker) RevokeContext(ctx context.Context, jti string, expiresAt time.Time) error {
+	var expires int64
+	if !expiresAt.IsZero() {
+		expires = expiresAt.UnixNano()
+	}
+	return inTxContext(ctx, r.DB, func(tx *dbsql.Tx) error {
+		query := r.Dialect.rebind(`DELETE FROM turbo_auth_revoked_tokens WHERE jti = ? OR (expires_at > 0 AND expires_at < ?)`)
+		if _, err := tx.ExecContext(ctx, query, jti, time.Now().UnixNano()); err != nil {
+			return err
+		}
+		query = r.Dialect.rebind(`INSERT INTO turbo_auth_revoked_tokens (jti, expires_at) VALUES (?, ?)`)
+		_, err := tx.ExecContext(ctx, query, jti, expires)
+		return err
+	})
+}
+
+func (r *Revoker) IsRevoked(jti string) (bool, error) {
+	return r.IsRevokedContext(context.Background(), jti)
+}
+
+func (r *Revoker) IsRevokedContext(ctx context.Context, jti string) (bool, error) {
+	query := r.Dialect.rebind(`SELECT COUNT(*) FROM turbo_auth_revoked_tokens WHERE jti = ?`)
+	var n int
+	if err := r.DB.QueryRowContext(ctx, query, jti).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// RevokeSubject keeps the revocation of the subject forever, later revocations replace the earlier ones
+func (r *Revoker) RevokeSubject(subject string, issuedBefore time.Time) error {
+	return r.RevokeSubjectContext(context.Background(), subject, issuedBefore)
+}
+
+func (r *Revoker) RevokeSubjectContext(ctx context.Context, subject string, issuedBefore time.Time) error {
+	return inTxContext(ctx, r.DB, func(tx *dbsql.Tx) error {
+		var existing int64
+		query := r.Dialect.rebind(`SELECT issued_before FROM turbo_auth_revoked_subjects WHERE subject = ?`)
+		err := tx.QueryRowContext(ctx, query, subject).Scan(&existing)
+		switch {
+		case err == dbsql.ErrNoRows:
+			query = r.Dialect.rebind(`INSERT INTO turbo_auth_revoked_subjects (issued_before, subject) VALUES (?, ?)`)
+		case err != nil:
+			return err
+		case existing >= issuedBefore.UnixNano():
+			return nil
+		default:
+			query = r.Dialect.rebind(`UPDATE turbo_auth_revoked_subjects SET issued_before = ? WHERE subject = ?`)
+		}
+		_, err = tx.ExecContext(ctx, query, issuedBefore.UnixNano(), subject)
+		return err
+	})
+}
+
+func (r *Revoker) IsSubjectRevoked(subject string, issuedAt time.Time) (bool, error) {
+	return r.IsSubjectRevokedContext(context.Background(), subject, issuedAt)
+}
+
+func (r *Revoker) IsSubjectRevokedContext(ctx context.Context, subject string, issuedAt time.Time) (bool, error) {
+	query := r.Dialect.rebind(`SELECT issued_before FROM turbo_auth_revoked_subjects WHERE subject = ?`)
+	var issuedBefore int64
+	err := r.DB.QueryRowContext(ctx, query, subject).Scan(&issuedBefore)
+	if err == dbsql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return issuedAt.Before(time.Unix(0, issuedBefore)), nil
+}
+
+func (r *Revoker) TokenVersion(subject string) (int64, error) {
+	return r.TokenVersionContext(context.Background(), subject)
+}
+
+func (r *Revoker) TokenVersionContext(ctx context.Context, subject string) (int64, error) {
+	query := r.Dialect.rebind(`SELECT version FROM turbo_auth_token_versions WHERE subject = ?`)
+	var version int64
+	err := r.DB.QueryRowContext(ctx, query, subject).Scan(&version)
+	if err == dbsql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+func (r *Revoker) BumpTokenVersion(subject string) (int64, error) {
+	return r.BumpTokenVersionContext(context.Background(), subject)
+}
+
+func (r *Revoker) BumpTokenVersionContext(ctx context.Context, subject string) (int64, error) {
+	var version int64
+	err := inTxContext(ctx, r.DB, func(tx *dbsql.Tx) error {
+		query := r.Dialect.rebind(`UPDATE turbo_auth_token_versions SET version = version + 1 WHERE subject = ?`)
+		result, err := tx.ExecContext(ctx, query, subject)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			query = r.Dialect.rebind(`INSERT INTO turbo_auth_token_versions (subject, version) VALUES (?, ?)`)
+			_, err = tx.ExecContext(ctx, query, subject, 1)
+			version = 1
+			return err
+		}
+		query = r.Dialect.rebind(`SELECT version FROM turbo_auth_token_versions WHERE subject = ?`)
+		return tx.QueryRowContext(ctx, query, subject).Scan(&version)
+	})
+	return version, err
+}
+
+// Revocations lists the revoked jti which have not expired yet, the ones revoked forever have a zero expiry
+func (r *Revoker) Revocations() (map[string]time.Time, error) {
+	query := r.Dialect.rebind(`SELECT jti, expires_at FROM turbo_auth_revoked_tokens WHERE expires_at = 0 OR expires_at >= ?`)
+	rows, err := r.DB.QueryContext(context.Background(), query, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revocations := make(map[string]time.Time)
+	for rows.Next() {
+		var jti string
+		var expires int64
+		if err := rows.Scan(&jti, &expires); err != nil {
+			return nil, err
+		}
+		var expiresAt time.Time
+		if expires > 0 {
+			expiresAt = time.Unix(0, expires)
+		}
+		revocations[jti] = expiresAt
+	}
+	return revocations, rows.Err()
+}
+
+// Check pings the database, see health.Checker
+func (r *Revoker) Check(ctx context.Context) error {
+	return r.DB.PingContext(ctx)
+}
@@ -0,0 +1,164 @@
+package sql
+
+import (
+	dbsql "database/sql"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// recordingDriver records the executed statements, the MAX(version) query answers version
+	recordingDriver struct {
+		mutex      sync.Mutex
+		statements []string
+		version    int64
+	}
+
+	recordingConn struct {
+		driver *recordingDriver
+	}
+
+	recordingStmt struct {
+		conn  *recordingConn
+		query string
+	}
+
+	versionRows struct {
+		version int64
+		done    bool
+	}
+)
+
+var driverCount int
+
+func openRecordingDB(t *testing.T, version int64) (*dbsql.DB, *recordingDriver) {
+	driverCount++
+	name := "recording" + strconv.Itoa(driverCount)
+	d := &recordingDriver{version: version}
+	dbsql.Register(name, d)
+	db, err := dbsql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db, d
+}
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) {
+	return &recordingConn{driver: d}, nil
+}
+
+func (d *recordingDriver) executed() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.statements...)
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{conn: c, query: query}, nil
+}
+
+func (c *recordingConn) Close() error { return nil }
+
+func (c *recordingConn) Begin() (driver.Tx, error) { return c, nil }
+
+func (c *recordingConn) Commit() error { return nil }
+
+func (c *recordingConn) Rollback() error { return nil }
+
+func (s *recordingStmt) Close() error { return nil }
+
+func (s *recordingStmt) NumInput() int { return -1 }
+
+func (s *recordingStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.conn.driver.mutex.Lock()
+	defer s.conn.driver.mutex.Unlock()
+	s.conn.driver.statements = append(s.conn.driver.statements, s.query)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recordingStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &versionRows{version: s.conn.driver.version}, nil
+}
+
+func (r *versionRows) Columns() []string { return []string{"version"} }
+
+func (r *versionRows) Close() error { return nil }
+
+func (r *versionRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.version
+	return nil
+}
+
+func TestDialect_rebind(t *testing.T) {
+	query := `UPDATE t SET a = ? WHERE b = ? AND c = ?`
+	tests := []struct {
+		name    string
+		dialect *Dialect
+		want    string
+	}{
+		{name: "Test_postgres", dialect: Postgres, want: `UPDATE t SET a = $1 WHERE b = $2 AND c = $3`},
+		{name: "Test_mysql", dialect: MySQL, want: query},
+		{name: "Test_sqlite", dialect: SQLite, want: query},
+		{name: "Test_nil", dialect: nil, want: query},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dialect.rebind(query); got != tt.want {
+				t.Errorf("rebind() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	db, d := openRecordingDB(t, 0)
+	if err := Migrate(db, Postgres); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	statements := d.executed()
+	// the migrations table, then the tables and the recorded version of every migration
+	want := 1
+	for _, migration := range migrations {
+		want += len(migration.statements) + 1
+	}
+	if len(statements) != want {
+		t.Fatalf("executed %v statements, want %v", len(statements), want)
+	}
+	if last := statements[len(statements)-1]; !strings.Contains(last, "INSERT INTO "+migrationsTable) ||
+		!strings.Contains(last, "$1") {
+		t.Errorf("last statement = %v", last)
+	}
+
+	db, d = openRecordingDB(t, int64(migrations[len(migrations)-1].version))
+	if err := Migrate(db, MySQL); err != nil {
+		t.Fatalf("Migrate() up to date error = %v", err)
+	}
+	if statements := d.executed(); len(statements) != 1 {
+		t.Errorf("executed %v statements on an up to date schema, want 1", len(statements))
+	}
+	if version, err := SchemaVersion(db); err != nil || version != migrations[len(migrations)-1].version {
+		t.Errorf("SchemaVersion() = %v, %v", version, err)
+	}
+}
+
+func TestRevoker_Revoke(t *testing.T) {
+	db, d := openRecordingDB(t, 0)
+	revoker := NewRevoker(db, Postgres)
+	if err := revoker.Revoke("jti", time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	statements := d.executed()
+	if len(statements) != 2 || !strings.HasPrefix(statements[0], "DELETE") || !strings.Contains(statements[1], "VALUES ($1, $2)") {
+		t.Errorf("statements = %v", statements)
+	}
+}
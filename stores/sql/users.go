@@ -0,0 +1,212 @@
+package sql
+
+import (
+	"context"
+	"crypto/rand"
+	dbsql "database/sql"
+	"encoding/hex"
+	"github.com/nandlabs/turbo-auth/credentials"
+	"github.com/nandlabs/turbo-auth/idp"
+	"sync"
+)
+
+// UserStore is the idp.UserStore of the turbo_auth_users and turbo_auth_user_roles tables
+type UserStore struct {
+	DB      *dbsql.DB
+	Dialect *Dialect
+	// Hasher is credentials.DefaultPasswordHasher when nil
+	Hasher *credentials.PasswordHasher
+	// Policy is enforced by AddUser and SetPassword when set
+	Policy    *idp.PasswordPolicy
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+var _ idp.UserStore = (*UserStore)(nil)
+
+func NewUserStore(db *dbsql.DB, dialect *Dialect) *UserStore {
+	return &UserStore{DB: db, Dialect: dialect}
+}
+
+// AddUser hashes the password and stores the user along with its roles, an *idp.PolicyError is returned when the
+// password violates the Policy
+func (s *UserStore) AddUser(username string, password string, roles ...string) error {
+	if err := s.Policy.Validate(password); err != nil {
+		return err
+	}
+	existing, err := s.FindByUsername(username)
+	if err == nil && existing != nil {
+		return idp.ErrUserExists
+	} else if err != idp.ErrUserNotFound {
+		return err
+	}
+	hash, err := s.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+	return inTx(s.DB, func(tx *dbsql.Tx) error {
+		ctx := context.Background()
+		query := s.Dialect.rebind(`INSERT INTO turbo_auth_users (username, password_hash, disabled) VALUES (?, ?, ?)`)
+		if _, err := tx.ExecContext(ctx, query, username, hash, false); err != nil {
+			return err
+		}
+		query = s.Dialect.rebind(`INSERT INTO turbo_auth_user_roles (username, role) VALUES (?, ?)`)
+		for _, role := range roles {
+			if _, err := tx.ExecContext(ctx, query, username, role); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetRoles replaces the roles of the user
+func (s *UserStore) SetRoles(username string, roles ...string) error {
+	return inTx(s.DB, func(tx *dbsql.Tx) error {
+		ctx := context.Background()
+		query := s.Dialect.rebind(`DELETE FROM turbo_auth_user_roles WHERE username = ?`)
+		if _, err := tx.ExecContext(ctx, query, username); err != nil {
+			return err
+		}
+		query = s.Dialect.rebind(`INSERT INTO turbo_auth_user_roles (username, role) VALUES (?, ?)`)
+		for _, role := range roles {
+			if _, err := tx.ExecContext(ctx, query, username, role); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetPassword replaces the password of the user, an *idp.PolicyError is returned when the password violates the
+// Policy
+func (s *UserStore) SetPassword(username string, password string) error {
+	if err := s.Policy.Validate(password); err != nil {
+		return err
+	}
+	hash, err := s.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+	query := s.Dialect.rebind(`UPDATE turbo_auth_users SET password_hash = ? WHERE username = ?`)
+	result, err := s.DB.ExecContext(context.Background(), query, hash, username)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return idp.ErrUserNotFound
+	}
+	return nil
+}
+
+// SetDisabled enables or disables the login of the user
+func (s *UserStore) SetDisabled(username string, disabled bool) error {
+	query := s.Dialect.rebind(`UPDATE turbo_auth_users SET disabled = ? WHERE username = ?`)
+	result, err := s.DB.ExecContext(context.Background(), query, disabled, username)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return idp.ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *UserStore) RemoveUser(username string) error {
+	return inTx(s.DB, func(tx *dbsql.Tx) error {
+		ctx := context.Background()
+		if _, err := tx.ExecContext(ctx, s.Dialect.rebind(`DELETE FROM turbo_auth_user_roles WHERE username = ?`), username); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, s.Dialect.rebind(`DELETE FROM turbo_auth_users WHERE username = ?`), username)
+		return err
+	})
+}
+
+func (s *UserStore) FindByUsername(username string) (*idp.User, error) {
+	query := s.Dialect.rebind(`SELECT username, password_hash, disabled FROM turbo_auth_users WHERE username = ?`)
+	var user idp.User
+	err := s.DB.QueryRowContext(context.Background(), query, username).Scan(&user.Username, &user.PasswordHash, &user.Disabled)
+	if err == dbsql.ErrNoRows {
+		return nil, idp.ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// VerifyPassword checks the password against the hash of the user, the stored hash is upgraded when the Hasher
+// parameters changed since it was computed
+func (s *UserStore) VerifyPassword(user *idp.User, password string) (bool, error) {
+	if user == nil {
+		_, _ = s.hasher().Verify(password, s.dummy())
+		return false, nil
+	}
+	// a failed upgrade keeps the current hash, the password is valid nonetheless
+	ok, upgraded, err := s.hasher().VerifyAndUpgrade(password, user.PasswordHash)
+	if !ok {
+		return false, err
+	}
+	if upgraded != "" {
+		query := s.Dialect.rebind(`UPDATE turbo_auth_users SET password_hash = ? WHERE username = ? AND password_hash = ?`)
+		_, _ = s.DB.ExecContext(context.Background(), query, upgraded, user.Username, user.PasswordHash)
+	}
+	return true, nil
+}
+
+func (s *UserStore) GetRoles(user *idp.User) ([]string, error) {
+	query := s.Dialect.rebind(`SELECT role FROM turbo_auth_user_roles WHERE username = ? ORDER BY role`)
+	rows, err := s.DB.QueryContext(context.Background(), query, user.Username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// inTx runs fn in a transaction, rolled back when fn fails
+func inTx(db *dbsql.DB, fn func(tx *dbsql.Tx) error) error {
+	return inTxContext(context.Background(), db, fn)
+}
+
+func inTxContext(ctx context.Context, db *dbsql.DB, fn func(tx *dbsql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *UserStore) hasher() *credentials.PasswordHasher {
+	if s.Hasher != nil {
+		return s.Hasher
+	}
+	return credentials.DefaultPasswordHasher
+}
+
+// dummy returns the hash of a random password, verified when the username is unknown
+func (s *UserStore) dummy() string {
+	s.dummyOnce.Do(func() {
+		random := make([]byte, 16)
+		_, _ = rand.Read(random)
+		s.dummyHash, _ = s.hasher().Hash(hex.EncodeToString(random))
+	})
+	return s.dummyHash
+}
+
+// Check pings the database, see health.Checker
+func (s *UserStore) Check(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
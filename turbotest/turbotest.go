@@ -0,0 +1,173 @@
+package turbotest
+
+import (
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	DefaultSigningKey = "turbotest-signing-key-of-32-bytes!"
+	DefaultTokenTTL   = time.Hour
+)
+
+type (
+	// Issuer mints tokens accepted by its Authenticator
+	Issuer struct {
+		Authenticator *jwt.JwtAuthenticator
+		// KeyStore is set by NewRSAIssuer, serve it with NewJWKSServer
+		KeyStore jwt.KeyStore
+		opts     []jwt.Option
+	}
+
+	// Authenticator is a fake turboAuth.Authenticator and turboAuth.TokenAuthenticator, it accepts every request
+	// with the Identity or rejects every request with Err
+	Authenticator struct {
+		Identity *turboAuth.Identity
+		Err      error
+	}
+)
+
+var (
+	ErrRejected = errors.New("rejected by turbotest")
+)
+
+// NewIssuer creates a HS256 issuer with DefaultSigningKey, the options override the defaults
+func NewIssuer(t testing.TB, opts ...jwt.Option) *Issuer {
+	t.Helper()
+	opts = append([]jwt.Option{jwt.WithSigningKey(DefaultSigningKey)}, opts...)
+	authenticator, err := jwt.NewJwtAuthenticator(opts...)
+	if err != nil {
+		t.Fatalf("turbotest: %v", err)
+	}
+	return &Issuer{Authenticator: authenticator, opts: opts}
+}
+
+// NewRSAIssuer creates a RS256 issuer whose public key can be served with NewJWKSServer
+func NewRSAIssuer(t testing.TB, opts ...jwt.Option) *Issuer {
+	t.Helper()
+	key, err := jwt.NewRSAKeySource("RS256", 2048).NextKey()
+	if err != nil {
+		t.Fatalf("turbotest: %v", err)
+	}
+	keyRing, err := jwt.NewKeyRing(key)
+	if err != nil {
+		t.Fatalf("turbotest: %v", err)
+	}
+	issuer := NewIssuer(t, append([]jwt.Option{jwt.WithKeyStore(keyRing)}, opts...)...)
+	issuer.KeyStore = keyRing
+	return issuer
+}
+
+// Token issues a token for the username valid for ttl
+func (issuer *Issuer) Token(t testing.TB, username string, ttl time.Duration) string {
+	t.Helper()
+	token, jwtErr := issuer.Authenticator.IssueNewToken(username, ttl)
+	if jwtErr != nil {
+		t.Fatalf("turbotest: %v", jwtErr)
+	}
+	return token
+}
+
+// ValidToken issues a token for the username valid for DefaultTokenTTL
+func (issuer *Issuer) ValidToken(t testing.TB, username string) string {
+	t.Helper()
+	return issuer.Token(t, username, DefaultTokenTTL)
+}
+
+// ExpiredToken issues a token for the username that expired an hour ago
+func (issuer *Issuer) ExpiredToken(t testing.TB, username string) string {
+	t.Helper()
+	past := jwt.FixedClock(time.Now().Add(-2 * time.Hour))
+	authenticator, err := jwt.NewJwtAuthenticator(append(issuer.opts, jwt.WithClock(past))...)
+	if err != nil {
+		t.Fatalf("turbotest: %v", err)
+	}
+	token, jwtErr := authenticator.IssueNewToken(username, time.Hour)
+	if jwtErr != nil {
+		t.Fatalf("turbotest: %v", jwtErr)
+	}
+	return token
+}
+
+// TamperedToken issues a valid token for the username and corrupts its signature
+func (issuer *Issuer) TamperedToken(t testing.TB, username string) string {
+	t.Helper()
+	return Tamper(issuer.ValidToken(t, username))
+}
+
+// NewRequest builds a request carrying the token the way the Authenticator expects it, header or cookie
+func (issuer *Issuer) NewRequest(method string, target string, token string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	config := issuer.Authenticator.Config()
+	if config.BearerTokens {
+		r.Header.Set(config.AuthTokenName, token)
+	} else {
+		r.AddCookie(&http.Cookie{Name: config.AuthTokenName, Value: token})
+	}
+	return r
+}
+
+// Tamper flips the first character of the signature of the token, the last one may only carry padding bits
+func Tamper(token string) string {
+	i := strings.LastIndex(token, ".") + 1
+	if i == 0 || i == len(token) {
+		return token
+	}
+	flipped := "A"
+	if token[i] == 'A' {
+		flipped = "B"
+	}
+	return token[:i] + flipped + token[i+1:]
+}
+
+// NewJWKSServer serves the public keys of the key store on turboAuth.DefaultJWKSPath, the server is closed with
+// the test
+func NewJWKSServer(t testing.TB, keyStore jwt.KeyStore) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.Handle(turboAuth.DefaultJWKSPath, jwt.JWKSHandler(keyStore))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Authenticated returns a fake Authenticator accepting every request with the identity
+func Authenticated(identity *turboAuth.Identity) *Authenticator {
+	return &Authenticator{Identity: identity}
+}
+
+// Rejecting returns a fake Authenticator rejecting every request, with ErrRejected when err is nil
+func Rejecting(err error) *Authenticator {
+	if err == nil {
+		err = ErrRejected
+	}
+	return &Authenticator{Err: err}
+}
+
+func (a *Authenticator) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.Err != nil {
+			httpError := &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Error : " + a.Err.Error() + " \n",
+			}
+			httpError.GenerateError(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(turboAuth.NewContext(r.Context(), a.Identity)))
+	})
+}
+
+func (a *Authenticator) Authenticate(token string) (*turboAuth.Identity, error) {
+	if a.Err != nil {
+		return nil, a.Err
+	}
+	return a.Identity, nil
+}
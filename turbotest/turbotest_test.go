@@ -0,0 +1,81 @@
+package turbotest
+
+import (
+	"encoding/json"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/providers/jwt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIssuer(t *testing.T) {
+	issuer := NewIssuer(t)
+	handler := issuer.Authenticator.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{name: "Test_valid", token: issuer.ValidToken(t, "test_user"), want: http.StatusOK},
+		{name: "Test_expired", token: issuer.ExpiredToken(t, "test_user"), want: http.StatusUnauthorized},
+		{name: "Test_tampered", token: issuer.TamperedToken(t, "test_user"), want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, issuer.NewRequest(http.MethodGet, "/", tt.token))
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewJWKSServer(t *testing.T) {
+	issuer := NewRSAIssuer(t)
+	server := NewJWKSServer(t, issuer.KeyStore)
+	response, err := http.Get(server.URL + turboAuth.DefaultJWKSPath)
+	if err != nil {
+		t.Fatalf("GET jwks error = %v", err)
+	}
+	defer response.Body.Close()
+	var jwks jwt.JWKS
+	if err := json.NewDecoder(response.Body).Decode(&jwks); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kty != "RSA" {
+		t.Errorf("jwks = %+v", jwks)
+	}
+	if _, err := issuer.Authenticator.Authenticate(issuer.ValidToken(t, "test_user")); err != nil {
+		t.Errorf("Authenticate() error = %v", err)
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	identity := &turboAuth.Identity{Subject: "test_user"}
+	tests := []struct {
+		name          string
+		authenticator *Authenticator
+		want          int
+	}{
+		{name: "Test_authenticated", authenticator: Authenticated(identity), want: http.StatusOK},
+		{name: "Test_rejecting", authenticator: Rejecting(nil), want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var subject string
+			w := httptest.NewRecorder()
+			tt.authenticator.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ := turboAuth.IdentityFromContext(r.Context())
+				subject = got.Subject
+			})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v", w.Code, tt.want)
+			}
+			if w.Code == http.StatusOK && subject != identity.Subject {
+				t.Errorf("subject = %v, want %v", subject, identity.Subject)
+			}
+		})
+	}
+}
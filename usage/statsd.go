@@ -0,0 +1,66 @@
+package usage
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultStatsdPrefix = "turbo_auth."
+	// maxStatsdPacket keeps the packets under the MTU of the common networks
+	maxStatsdPacket = 1432
+)
+
+// StatsdSink sends the counts as statsd counters named <Prefix>requests, tagged with the subject, tenant and
+// route in the DogStatsD format
+type StatsdSink struct {
+	Prefix string
+	conn   net.Conn
+}
+
+// tagReplacer strips the separators of the statsd protocol from the tag values
+var tagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+// NewStatsdSink sends the counts over udp to the address, e.g. localhost:8125
+func NewStatsdSink(address string) (*StatsdSink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsdSink{Prefix: DefaultStatsdPrefix, conn: conn}, nil
+}
+
+func (s *StatsdSink) Export(_ context.Context, records []Record) error {
+	var packet []byte
+	for _, record := range records {
+		line := s.Prefix + "requests:" + strconv.FormatInt(record.Requests, 10) + "|c|#subject:" +
+			tagReplacer.Replace(record.Subject)
+		if record.Tenant != "" {
+			line += ",tenant:" + tagReplacer.Replace(record.Tenant)
+		}
+		if record.Route != "" {
+			line += ",route:" + tagReplacer.Replace(record.Route)
+		}
+		if len(packet) > 0 && len(packet)+1+len(line) > maxStatsdPacket {
+			if _, err := s.conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(packet)
+	return err
+}
+
+func (s *StatsdSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,184 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"github.com/nandlabs/turbo-auth/logging"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const DefaultInterval = time.Minute
+
+type (
+	// Record is the number of the authenticated requests of an identity on a route over a flush interval
+	Record struct {
+		Subject  string
+		Tenant   string
+		Route    string
+		Requests int64
+		Start    time.Time
+		End      time.Time
+	}
+
+	// Sink receives the records of each flush, e.g. a SinkFunc writing them to the billing store or a StatsdSink
+	Sink interface {
+		Export(ctx context.Context, records []Record) error
+	}
+
+	// SinkFunc adapts a function to a Sink
+	SinkFunc func(ctx context.Context, records []Record) error
+
+	// Accountant counts the authenticated requests per identity and route in memory and flushes the counts to the
+	// Sink every Interval, the counts of a failed flush are kept for the next one
+	Accountant struct {
+		Sink     Sink
+		Interval time.Duration
+		// Route groups the requests counted together, e.g. quota.ByPathPrefix, all the requests are one route when
+		// nil
+		Route  func(r *http.Request) string
+		mutex  sync.Mutex
+		counts map[recordKey]int64
+		start  time.Time
+		stop   chan struct{}
+		done   chan struct{}
+	}
+
+	recordKey struct {
+		subject string
+		tenant  string
+		route   string
+	}
+)
+
+var logger = logging.Get()
+
+func (f SinkFunc) Export(ctx context.Context, records []Record) error {
+	return f(ctx, records)
+}
+
+func NewAccountant(sink Sink) *Accountant {
+	return &Accountant{
+		Sink:     sink,
+		Interval: DefaultInterval,
+	}
+}
+
+// Middleware counts the requests of the identities authenticated by the middleware wrapping it, the anonymous
+// requests are not counted
+func (a *Accountant) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if identity, ok := turboAuth.IdentityFromContext(r.Context()); ok {
+			var route string
+			if a.Route != nil {
+				route = a.Route(r)
+			}
+			a.Count(identity, route)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Count counts a request of the identity on the route, e.g. for the grpc calls
+func (a *Accountant) Count(identity *turboAuth.Identity, route string) {
+	if identity == nil || identity.Subject == "" {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.counts == nil {
+		a.counts = make(map[recordKey]int64)
+		a.start = time.Now()
+	}
+	a.counts[recordKey{subject: identity.Subject, tenant: identity.Tenant, route: route}]++
+}
+
+// Flush exports the counts since the previous flush, nothing is exported when there are none
+func (a *Accountant) Flush(ctx context.Context) error {
+	a.mutex.Lock()
+	counts, start := a.counts, a.start
+	a.counts = nil
+	a.mutex.Unlock()
+	if len(counts) == 0 {
+		return nil
+	}
+	end := time.Now()
+	records := make([]Record, 0, len(counts))
+	for key, requests := range counts {
+		records = append(records, Record{Subject: key.subject, Tenant: key.tenant, Route: key.route,
+			Requests: requests, Start: start, End: end})
+	}
+	if err := a.Sink.Export(ctx, records); err != nil {
+		a.restore(counts, start)
+		return err
+	}
+	return nil
+}
+
+// restore adds back the counts of a failed flush
+func (a *Accountant) restore(counts map[recordKey]int64, start time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.counts == nil {
+		a.counts = counts
+		a.start = start
+		return
+	}
+	for key, requests := range counts {
+		a.counts[key] += requests
+	}
+	a.start = start
+}
+
+// Start flushes the counts every Interval until Stop
+func (a *Accountant) Start() error {
+	if a.Sink == nil {
+		return errors.New("usage accountant requires a sink")
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.stop != nil {
+		return errors.New("usage accountant already started")
+	}
+	interval := a.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	a.stop, a.done = make(chan struct{}), make(chan struct{})
+	go a.run(interval, a.stop, a.done)
+	return nil
+}
+
+// Stop stops the periodic flushes and flushes the remaining counts
+func (a *Accountant) Stop() {
+	a.mutex.Lock()
+	stop, done := a.stop, a.done
+	a.stop, a.done = nil, nil
+	a.mutex.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+func (a *Accountant) run(interval time.Duration, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Flush(context.Background()); err != nil {
+				logger.ErrorF("usage flush failed: %v", err)
+			}
+		case <-stop:
+			if err := a.Flush(context.Background()); err != nil {
+				logger.ErrorF("usage flush failed: %v", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccountant_Flush(t *testing.T) {
+	var exported []Record
+	fail := false
+	accountant := NewAccountant(SinkFunc(func(ctx context.Context, records []Record) error {
+		if fail {
+			return errors.New("billing store unavailable")
+		}
+		exported = append(exported, records...)
+		return nil
+	}))
+	accountant.Route = func(r *http.Request) string {
+		return strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	}
+	handler := accountant.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(identity *turboAuth.Identity, path string) {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if identity != nil {
+			r = r.WithContext(turboAuth.NewContext(r.Context(), identity))
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	alice := &turboAuth.Identity{Subject: "alice", Tenant: "acme"}
+	do(alice, "/orders/1")
+	do(alice, "/orders/2")
+	do(alice, "/reports")
+	do(&turboAuth.Identity{Subject: "bob"}, "/orders/1")
+	do(nil, "/orders/1")
+
+	// a failed flush keeps the counts for the next one
+	fail = true
+	if err := accountant.Flush(context.Background()); err == nil {
+		t.Fatalf("Flush() error = nil, want the sink error")
+	}
+	do(alice, "/orders/3")
+	fail = false
+	if err := accountant.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	sort.Slice(exported, func(i, j int) bool {
+		return exported[i].Subject+exported[i].Route < exported[j].Subject+exported[j].Route
+	})
+	want := []Record{
+		{Subject: "alice", Tenant: "acme", Route: "orders", Requests: 3},
+		{Subject: "alice", Tenant: "acme", Route: "reports", Requests: 1},
+		{Subject: "bob", Route: "orders", Requests: 1},
+	}
+	if len(exported) != len(want) {
+		t.Fatalf("exported = %+v, want %+v", exported, want)
+	}
+	for i, record := range exported {
+		if record.Subject != want[i].Subject || record.Tenant != want[i].Tenant || record.Route != want[i].Route ||
+			record.Requests != want[i].Requests || record.End.Before(record.Start) {
+			t.Errorf("record %d = %+v, want %+v", i, record, want[i])
+		}
+	}
+
+	exported = nil
+	if err := accountant.Flush(context.Background()); err != nil || exported != nil {
+		t.Errorf("Flush() = %v exported %+v, want nothing", err, exported)
+	}
+}
+
+func TestAccountant_Start(t *testing.T) {
+	flushed := make(chan []Record, 2)
+	accountant := NewAccountant(SinkFunc(func(ctx context.Context, records []Record) error {
+		flushed <- records
+		return nil
+	}))
+	accountant.Interval = 10 * time.Millisecond
+	if err := accountant.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := accountant.Start(); err == nil {
+		t.Errorf("Start() twice error = nil")
+	}
+	accountant.Count(&turboAuth.Identity{Subject: "alice"}, "")
+	select {
+	case records := <-flushed:
+		if len(records) != 1 || records[0].Requests != 1 {
+			t.Errorf("flushed %+v, want 1 request of alice", records)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the counts were not flushed")
+	}
+	// Stop flushes the remaining counts
+	accountant.Count(&turboAuth.Identity{Subject: "bob"}, "")
+	accountant.Interval = time.Hour
+	accountant.Stop()
+	select {
+	case records := <-flushed:
+		if len(records) != 1 || records[0].Subject != "bob" {
+			t.Errorf("flushed %+v, want 1 request of bob", records)
+		}
+	default:
+		t.Errorf("Stop() did not flush the remaining counts")
+	}
+}
+
+func TestStatsdSink_Export(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("udp unavailable: %v", err)
+	}
+	defer listener.Close()
+	sink, err := NewStatsdSink(listener.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("NewStatsdSink() error = %v", err)
+	}
+	defer sink.Close()
+	err = sink.Export(context.Background(), []Record{
+		{Subject: "alice", Tenant: "acme", Route: "/orders", Requests: 3},
+		{Subject: "bob,admin|x", Requests: 1},
+	})
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	buffer := make([]byte, maxStatsdPacket)
+	_ = listener.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := listener.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	want := "turbo_auth.requests:3|c|#subject:alice,tenant:acme,route:/orders\nturbo_auth.requests:1|c|#subject:bob_admin_x"
+	if got := string(buffer[:n]); got != want {
+		t.Errorf("packet = %q, want %q", got, want)
+	}
+}
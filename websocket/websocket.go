@@ -0,0 +1,311 @@
+package websocket
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	turboError "github.com/nandlabs/turbo-auth/errors"
+	"github.com/nandlabs/turbo-auth/extractor"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderProtocol = "Sec-WebSocket-Protocol"
+	// DefaultTokenProtocol marks the subprotocol carrying the token, browsers cannot set headers on upgrades so
+	// clients send "Sec-WebSocket-Protocol: access_token, <token>"
+	DefaultTokenProtocol = "access_token"
+	// DefaultTicketParam is the query parameter of the one-time tickets, the tokens are never read from the url
+	DefaultTicketParam     = "ticket"
+	DefaultTicketTTL       = 30 * time.Second
+	DefaultRevalidateEvery = time.Minute
+)
+
+type (
+	// Authenticator authenticates the upgrade requests with a TokenAuthenticator and re-validates the token of
+	// the long-lived connections, e.g. to close the sockets of revoked or expired tokens
+	Authenticator struct {
+		Tokens turboAuth.TokenAuthenticator
+		// Extractor defaults to the TokenProtocol subprotocol
+		Extractor extractor.Extractor
+		// Tickets enables the one-time tickets of the DefaultTicketParam query parameter, see TicketHandler
+		Tickets *Tickets
+		// TokenProtocol is the marker subprotocol, DefaultTokenProtocol when empty
+		TokenProtocol string
+		// RevalidateEvery is the interval of Watch, DefaultRevalidateEvery when 0
+		RevalidateEvery time.Duration
+		// ErrorWriter renders the failures, plain text errors are written when nil
+		ErrorWriter turboError.ErrorWriter
+	}
+
+	// Tickets are short-lived single use tickets exchanged for the token of an authenticated http request, the
+	// clients unable to send the subprotocol put them in the url of the upgrade where they are harmless in the
+	// access logs. The tickets expire in the order they are issued, they are purged from the front of the queue
+	Tickets struct {
+		// TTL is DefaultTicketTTL when 0
+		TTL     time.Duration
+		mutex   sync.Mutex
+		tickets map[string]*list.Element
+		queue   *list.List
+	}
+
+	ticket struct {
+		id        string
+		token     string
+		expiresAt time.Time
+	}
+
+	ticketResponse struct {
+		Ticket    string `json:"ticket"`
+		ExpiresIn int64  `json:"expires_in"`
+	}
+
+	tokenKey struct{}
+)
+
+var ErrInvalidTicket = errors.New("invalid or expired websocket ticket")
+
+func NewAuthenticator(tokens turboAuth.TokenAuthenticator) *Authenticator {
+	return &Authenticator{
+		Tokens:          tokens,
+		TokenProtocol:   DefaultTokenProtocol,
+		RevalidateEvery: DefaultRevalidateEvery,
+	}
+}
+
+// IsUpgrade reports whether the request asks for a websocket upgrade
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// Protocol reads the token sent as the subprotocol following the marker protocol
+func Protocol(marker string) extractor.Extractor {
+	return extractor.ExtractorFunc(func(r *http.Request) (string, error) {
+		protocols := protocols(r)
+		for i := 0; i < len(protocols)-1; i++ {
+			if protocols[i] == marker {
+				return protocols[i+1], nil
+			}
+		}
+		return "", nil
+	})
+}
+
+func NewTickets(ttl time.Duration) *Tickets {
+	return &Tickets{TTL: ttl}
+}
+
+// Issue returns a ticket redeemable once for the token
+func (t *Tickets) Issue(token string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(raw)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	t.purge(now)
+	if t.tickets == nil {
+		t.tickets = make(map[string]*list.Element)
+		t.queue = list.New()
+	}
+	t.tickets[id] = t.queue.PushBack(&ticket{id: id, token: token, expiresAt: now.Add(t.ttl())})
+	return id, nil
+}
+
+// Redeem returns the token of the ticket and invalidates it
+func (t *Tickets) Redeem(id string) (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	t.purge(now)
+	element, ok := t.tickets[id]
+	if !ok {
+		return "", ErrInvalidTicket
+	}
+	delete(t.tickets, id)
+	t.queue.Remove(element)
+	if redeemed := element.Value.(*ticket); redeemed.expiresAt.After(now) {
+		return redeemed.token, nil
+	}
+	return "", ErrInvalidTicket
+}
+
+func (t *Tickets) purge(now time.Time) {
+	if t.queue == nil {
+		return
+	}
+	for front := t.queue.Front(); front != nil && !front.Value.(*ticket).expiresAt.After(now); front = t.queue.Front() {
+		delete(t.tickets, front.Value.(*ticket).id)
+		t.queue.Remove(front)
+	}
+}
+
+func (t *Tickets) ttl() time.Duration {
+	if t.TTL <= 0 {
+		return DefaultTicketTTL
+	}
+	return t.TTL
+}
+
+// TicketHandler exchanges the token of the request, read by tokens (the Authorization bearer token when nil), for
+// a ticket of the upgrade request. The token is validated through the binding-aware path before the ticket is issued
+func (a *Authenticator) TicketHandler(tokens extractor.Extractor) http.Handler {
+	if tokens == nil {
+		tokens = extractor.AuthorizationBearer()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := tokens.Extract(r)
+		if err == nil {
+			_, err = turboAuth.AuthenticateRequest(r, a.Tokens, token)
+		}
+		var id string
+		if err == nil {
+			if a.Tickets == nil {
+				err = ErrInvalidTicket
+			} else {
+				id, err = a.Tickets.Issue(token)
+			}
+		}
+		if err != nil {
+			turboError.WriteError(a.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Error : " + err.Error() + " \n",
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(&ticketResponse{Ticket: id, ExpiresIn: int64(a.Tickets.ttl() / time.Second)})
+	})
+}
+
+// Authenticate validates the token of the upgrade request, along with the proofs of the request when Tokens is a
+// turboAuth.RequestAuthenticator
+func (a *Authenticator) Authenticate(r *http.Request) (*turboAuth.Identity, error) {
+	token, err := a.token(r)
+	if err != nil {
+		return nil, err
+	}
+	return turboAuth.AuthenticateRequest(r, a.Tokens, token)
+}
+
+// token reads the token of the upgrade request, the one of the redeemed ticket is kept in the request context so
+// that Watch re-validates it
+func (a *Authenticator) token(r *http.Request) (string, error) {
+	if token, ok := r.Context().Value(tokenKey{}).(string); ok {
+		return token, nil
+	}
+	if a.Tickets != nil {
+		if id := r.URL.Query().Get(DefaultTicketParam); id != "" {
+			return a.Tickets.Redeem(id)
+		}
+	}
+	return a.extractor().Extract(r)
+}
+
+// Apply authenticates the upgrade request and stores the identity in its context. When the token was sent as a
+// subprotocol the marker protocol is the one the upgrader must select, see AcceptedProtocol
+func (a *Authenticator) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := a.token(r)
+		var identity *turboAuth.Identity
+		if err == nil {
+			identity, err = turboAuth.AuthenticateRequest(r, a.Tokens, token)
+		}
+		if err != nil {
+			turboError.WriteError(a.ErrorWriter, w, r, &turboError.HttpError{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Error : " + err.Error() + " \n",
+			})
+			return
+		}
+		ctx := context.WithValue(turboAuth.NewContext(r.Context(), identity), tokenKey{}, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AcceptedProtocol returns the subprotocol to answer the upgrade with, the token itself must never be echoed back
+func (a *Authenticator) AcceptedProtocol(r *http.Request) string {
+	for _, protocol := range protocols(r) {
+		if protocol == a.tokenProtocol() {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// Watch re-validates the token of the upgrade request every RevalidateEvery, r is the request passed on by Apply until the request context is done
+// or stop is called. onInvalid is invoked once, typically to close the socket, when the token is rejected
+func (a *Authenticator) Watch(r *http.Request, onInvalid func(err error)) (stop func()) {
+	done := make(chan struct{})
+	interval := a.RevalidateEvery
+	if interval <= 0 {
+		interval = DefaultRevalidateEvery
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+				if _, err := a.Authenticate(r); err != nil {
+					onInvalid(err)
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+func (a *Authenticator) extractor() extractor.Extractor {
+	if a.Extractor != nil {
+		return a.Extractor
+	}
+	return Protocol(a.tokenProtocol())
+}
+
+func (a *Authenticator) tokenProtocol() string {
+	if a.TokenProtocol == "" {
+		return DefaultTokenProtocol
+	}
+	return a.TokenProtocol
+}
+
+func protocols(r *http.Request) []string {
+	var protocols []string
+	for _, value := range r.Header.Values(HeaderProtocol) {
+		for _, protocol := range strings.Split(value, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return protocols
+}
+
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
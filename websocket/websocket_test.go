@@ -0,0 +1,173 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	turboAuth "github.com/nandlabs/turbo-auth"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type tokenAuthenticator struct {
+	mutex   sync.Mutex
+	revoked map[string]bool
+}
+
+func (a *tokenAuthenticator) Authenticate(token string) (*turboAuth.Identity, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if token == "" || a.revoked[token] {
+		return nil, errors.New("invalid token")
+	}
+	return &turboAuth.Identity{Subject: token}, nil
+}
+
+func (a *tokenAuthenticator) revoke(token string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.revoked[token] = true
+}
+
+func TestAuthenticator_Apply(t *testing.T) {
+	authenticator := NewAuthenticator(&tokenAuthenticator{revoked: map[string]bool{"revoked": true}})
+	tests := []struct {
+		name         string
+		protocol     string
+		query        string
+		want         int
+		wantSubject  string
+		wantProtocol string
+	}{
+		{name: "Test_protocol", protocol: "access_token, alice", want: http.StatusOK, wantSubject: "alice", wantProtocol: "access_token"},
+		{name: "Test_query_token", query: "access_token=bob", want: http.StatusUnauthorized},
+		{name: "Test_revoked", protocol: "access_token, revoked", want: http.StatusUnauthorized},
+		{name: "Test_marker_without_token", protocol: "access_token", want: http.StatusUnauthorized},
+		{name: "Test_missing", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws?"+tt.query, nil)
+			r.Header.Set("Connection", "Upgrade")
+			r.Header.Set("Upgrade", "websocket")
+			if tt.protocol != "" {
+				r.Header.Set(HeaderProtocol, tt.protocol)
+			}
+			if !IsUpgrade(r) {
+				t.Fatalf("IsUpgrade() = false")
+			}
+			var subject, protocol string
+			w := httptest.NewRecorder()
+			authenticator.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				identity, _ := turboAuth.IdentityFromContext(r.Context())
+				subject = identity.Subject
+				protocol = authenticator.AcceptedProtocol(r)
+			})).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %v, want %v", w.Code, tt.want)
+			}
+			if subject != tt.wantSubject || protocol != tt.wantProtocol {
+				t.Errorf("subject = %v, protocol = %v, want %v, %v", subject, protocol, tt.wantSubject, tt.wantProtocol)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_Watch(t *testing.T) {
+	tokens := &tokenAuthenticator{revoked: map[string]bool{}}
+	authenticator := NewAuthenticator(tokens)
+	authenticator.RevalidateEvery = 5 * time.Millisecond
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set(HeaderProtocol, "access_token, alice")
+
+	closed := make(chan error, 1)
+	stop := authenticator.Watch(r, func(err error) { closed <- err })
+	defer stop()
+
+	select {
+	case err := <-closed:
+		t.Fatalf("closed before revocation: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	tokens.revoke("alice")
+	select {
+	case err := <-closed:
+		if err == nil {
+			t.Errorf("onInvalid() called without error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("socket not closed after revocation")
+	}
+}
+
+func TestAuthenticator_Tickets(t *testing.T) {
+	tokens := &tokenAuthenticator{revoked: map[string]bool{}}
+	authenticator := NewAuthenticator(tokens)
+	authenticator.Tickets = NewTickets(time.Minute)
+	authenticator.RevalidateEvery = 5 * time.Millisecond
+	issue := func(authorization string) (int, string) {
+		r := httptest.NewRequest(http.MethodPost, "/ws/ticket", nil)
+		r.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		authenticator.TicketHandler(nil).ServeHTTP(w, r)
+		var response ticketResponse
+		_ = json.NewDecoder(w.Body).Decode(&response)
+		return w.Code, response.Ticket
+	}
+	if code, _ := issue("Bearer "); code != http.StatusUnauthorized {
+		t.Fatalf("status without token = %v, want %v", code, http.StatusUnauthorized)
+	}
+	code, ticket := issue("Bearer alice")
+	if code != http.StatusOK || ticket == "" {
+		t.Fatalf("status = %v, ticket = %v", code, ticket)
+	}
+
+	upgrade := func() (int, *http.Request) {
+		var upgraded *http.Request
+		w := httptest.NewRecorder()
+		authenticator.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upgraded = r
+		})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ws?ticket="+ticket, nil))
+		return w.Code, upgraded
+	}
+	code, r := upgrade()
+	if code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", code, http.StatusOK)
+	}
+	if identity, _ := turboAuth.IdentityFromContext(r.Context()); identity.Subject != "alice" {
+		t.Errorf("subject = %v, want alice", identity.Subject)
+	}
+	if code, _ := upgrade(); code != http.StatusUnauthorized {
+		t.Errorf("status of the redeemed ticket = %v, want %v", code, http.StatusUnauthorized)
+	}
+
+	// the token behind the ticket is re-validated
+	closed := make(chan error, 1)
+	stop := authenticator.Watch(r, func(err error) { closed <- err })
+	defer stop()
+	tokens.revoke("alice")
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("socket not closed after revocation")
+	}
+}
+
+func TestTickets_expiry(t *testing.T) {
+	tickets := NewTickets(time.Millisecond)
+	expired, _ := tickets.Issue("alice")
+	time.Sleep(5 * time.Millisecond)
+	tickets.TTL = time.Minute
+	valid, _ := tickets.Issue("bob")
+	if _, err := tickets.Redeem(expired); err != ErrInvalidTicket {
+		t.Errorf("Redeem() of the expired ticket error = %v, want %v", err, ErrInvalidTicket)
+	}
+	if len(tickets.tickets) != 1 || tickets.queue.Len() != 1 {
+		t.Errorf("tickets kept = %v, %v, want 1", len(tickets.tickets), tickets.queue.Len())
+	}
+	if token, err := tickets.Redeem(valid); err != nil || token != "bob" {
+		t.Errorf("Redeem() = %v, %v, want bob", token, err)
+	}
+}